@@ -2,22 +2,52 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/user"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
 	"time"
 
+	"excel-automation-mcp/backend/service/dryrun"
+	"excel-automation-mcp/backend/service/generation"
+	"excel-automation-mcp/backend/service/jetsql"
+	"excel-automation-mcp/backend/service/llm"
+	"excel-automation-mcp/backend/service/mcp"
+	"excel-automation-mcp/backend/service/validation"
+	"excel-automation-mcp/backend/service/vba"
+	"excel-automation-mcp/backend/service/vbaexport"
+	"excel-automation-mcp/backend/service/vbaproject"
+	"excel-automation-mcp/backend/service/xlsx"
+
 	"github.com/wails-io/wails/v2"
 	"github.com/wails-io/wails/v2/pkg/options"
 	"github.com/wails-io/wails/v2/pkg/options/assetserver"
+	wailsruntime "github.com/wails-io/wails/v2/pkg/runtime"
 )
 
 // App struct represents the main application
 type App struct {
-	ctx    context.Context
-	cancel context.CancelFunc
-	logger *log.Logger
+	ctx       context.Context
+	cancel    context.CancelFunc
+	logger    *log.Logger
+	mu        sync.RWMutex // Guards security, style, llmConfig, llm, resilience and local; they are replaced, never changed in place
+	security  *validation.SecurityChecker
+	style     *vba.FormatStyle
+	llmConfig *llm.Config
+	llm       llm.Client
+	jobs      *generation.Manager
+
+	resilience *llm.ResilientClient // Retry and circuit breaker layer of llm
+	local      *llm.LocalClient     // Innermost client of llm when it talks to a local server, else nil
+	limiters   *llm.Limiters        // Per-provider rate limits, kept across settings changes
+	ledger     *llm.Ledger          // Token usage and cost per session and user
+	cache      *llm.ResponseCache   // Responses to repeated prompts, nil when the cache directory is unusable
+	session    string
 }
 
 // AppMetadata contains application information
@@ -33,9 +63,20 @@ type AppMetadata struct {
 func NewApp() *App {
 	logger := log.New(os.Stdout, "[ExcelMCP] ", log.LstdFlags|log.Lshortfile)
 	
-	return &App{
-		logger: logger,
+	app := &App{
+		logger:    logger,
+		security:  validation.NewSecurityChecker(nil),
+		style:     vba.DefaultFormatStyle(),
+		llmConfig: llm.DefaultConfig(),
+		jobs:      generation.NewManager(),
+		limiters:  llm.NewLimiters(),
+		ledger:    llm.NewLedger(),
+		session:   "session-" + time.Now().Format("20060102-150405"),
+	}
+	if clients, err := app.newLLMClient(*app.llmConfig); err == nil {
+		app.useLLM(app.llmConfig, clients)
 	}
+	return app
 }
 
 // OnStartup is called when the app starts up. It sets up the application context
@@ -48,7 +89,7 @@ func (a *App) OnStartup(ctx context.Context) {
 	
 	// Check if we're running on Windows (required for Excel COM)
 	if runtime.GOOS != "windows" {
-		a.logger.Println("WARNING: Excel COM functionality requires Windows platform")
+		a.logger.Println("WARNING: Excel COM functionality requires Windows platform; workbooks can still be analyzed from .xlsx/.xlsm files")
 	}
 	
 	// Initialize application components here
@@ -93,11 +134,51 @@ func (a *App) OnBeforeClose(ctx context.Context) (prevent bool) {
 func (a *App) initializeServices() {
 	a.logger.Println("Initializing application services...")
 	
+	// Team security policy, if one has been configured
+	if policy, err := validation.LoadSecurityPolicy(securityPolicyPath()); err == nil {
+		a.setSecurity(validation.NewSecurityChecker(policy))
+		a.logger.Printf("Loaded security policy %q", policy.Name)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		a.logger.Printf("WARNING: %v; using default security policy", err)
+	}
+	
+	// Team formatting style, if one has been configured
+	if style, err := vba.LoadFormatStyle(formatStylePath()); err == nil {
+		a.setStyle(style)
+		a.logger.Printf("Loaded format style %q", style.Name)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		a.logger.Printf("WARNING: %v; using default format style", err)
+	}
+	
+	// Response cache, shared by every provider
+	config, _ := a.llmState()
+	if cache, err := llm.OpenCache(responseCacheDir(), config.Cache); err == nil {
+		a.cache = cache
+		if clients, err := a.newLLMClient(*config); err == nil {
+			a.useLLM(config, clients)
+		} else {
+			a.logger.Printf("WARNING: %v; the default LLM client is not available", err)
+		}
+	} else {
+		a.logger.Printf("WARNING: %v; responses will not be cached", err)
+	}
+	
+	// LLM provider settings, if they have been saved
+	if config, err := llm.LoadConfig(llmConfigPath()); err == nil {
+		if clients, err := a.newLLMClient(*config); err == nil {
+			a.useLLM(config, clients)
+			a.logger.Printf("Using %s model %s", config.Provider, clients.client.Model().Name)
+		} else {
+			a.logger.Printf("WARNING: %v; using default LLM settings", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		a.logger.Printf("WARNING: %v; using default LLM settings", err)
+	}
+	
 	// TODO: Initialize services in next development phase:
 	// - Configuration service
 	// - Excel service
 	// - MCP service
-	// - LLM service
 	// - Validation service
 	
 	a.logger.Println("Services initialization completed")
@@ -107,9 +188,11 @@ func (a *App) initializeServices() {
 func (a *App) cleanupServices() {
 	a.logger.Println("Cleaning up application services...")
 	
+	// Generation jobs stop with the root context; wait for them to finish
+	a.jobs.CancelAll()
+	
 	// TODO: Cleanup services:
 	// - Close Excel COM objects
-	// - Cancel LLM requests
 	// - Save application state
 	// - Close database connections
 	
@@ -131,6 +214,7 @@ func (a *App) GetAppInfo() AppMetadata {
 
 // HealthCheck returns application health status
 func (a *App) HealthCheck() map[string]interface{} {
+	config, clients := a.llmState()
 	return map[string]interface{}{
 		"status":    "healthy",
 		"timestamp": time.Now().Unix(),
@@ -138,11 +222,24 @@ func (a *App) HealthCheck() map[string]interface{} {
 		"goroutines": runtime.NumGoroutine(),
 		"services": map[string]bool{
 			"excel":      runtime.GOOS == "windows", // Excel COM only on Windows
+			"xlsx":       true,                      // File analysis works on every platform
+			"vbaProject": true,                      // Macro source is read from the file
 			"config":     true,                      // Always available
 			"mcp":        true,                      // Always available
 			"validation": true,                      // Always available
+			"llm":        config.Ready() && clients.resilience.Stats().BreakerState != llm.BreakerOpen, // Configured and not failing
+			"cache":      a.cache != nil && a.cache.Enabled(),
 		},
+		"cache": a.cacheStats(),
+	}
+}
+
+// cacheStats returns the hit and miss counters of the response cache
+func (a *App) cacheStats() llm.CacheStats {
+	if a.cache == nil {
+		return llm.CacheStats{}
 	}
+	return a.cache.Stats()
 }
 
 // LogMessage logs a message from the frontend
@@ -182,6 +279,536 @@ func (a *App) GetSystemInfo() map[string]interface{} {
 	}
 }
 
+// AnalyzeWorkbookFile reads an .xlsx or .xlsm file without Excel and returns
+// the data range of each worksheet, or of the named sheets only
+func (a *App) AnalyzeWorkbookFile(path string, sheets []string) ([]mcp.DataRange, error) {
+	var ranges []mcp.DataRange
+	err := a.safeExecute("AnalyzeWorkbookFile", func() error {
+		var err error
+		ranges, err = xlsx.AnalyzeFile(path, xlsx.AnalyzeOptions{Sheets: sheets})
+		return err
+	})
+	return ranges, err
+}
+
+// DetectTables finds the tables on the worksheets of an .xlsx or .xlsm file
+// and returns them ranked by confidence, so the user can pick the right one
+func (a *App) DetectTables(path string, sheets []string) ([]xlsx.Candidate, error) {
+	var candidates []xlsx.Candidate
+	err := a.safeExecute("DetectTables", func() error {
+		var err error
+		candidates, err = xlsx.DetectFile(path, xlsx.AnalyzeOptions{Sheets: sheets})
+		return err
+	})
+	return candidates, err
+}
+
+// AnalyzeTextFile reads a CSV or TSV export, detecting its encoding,
+// delimiter and quotes, and returns its data range
+func (a *App) AnalyzeTextFile(path string) (*xlsx.TextAnalysis, error) {
+	var analysis *xlsx.TextAnalysis
+	err := a.safeExecute("AnalyzeTextFile", func() error {
+		var err error
+		analysis, err = xlsx.AnalyzeTextFile(path, xlsx.TextOptions{})
+		return err
+	})
+	return analysis, err
+}
+
+// AnalyzePastedData reads cells pasted from Excel or another tab- or
+// comma-separated source and returns their data range on a "Clipboard" sheet
+func (a *App) AnalyzePastedData(text string) (*xlsx.TextAnalysis, error) {
+	return xlsx.AnalyzeText([]byte(text), xlsx.TextOptions{SheetName: xlsx.ClipboardSheet})
+}
+
+// ReadVBAProject lists the modules of the VBA project in an .xlsm file, or in
+// a vbaProject.bin extracted from one, with their types and source
+func (a *App) ReadVBAProject(path string) (*vbaproject.Project, error) {
+	var project *vbaproject.Project
+	err := a.safeExecute("ReadVBAProject", func() error {
+		var err error
+		if strings.EqualFold(filepath.Ext(path), ".bin") {
+			project, err = vbaproject.Open(path)
+			return err
+		}
+		wb, err := xlsx.Open(path)
+		if err != nil {
+			return err
+		}
+		project, err = wb.VBAProject()
+		return err
+	})
+	return project, err
+}
+
+// GetExistingModules returns the named modules of a workbook's VBA project,
+// or all of them, ready to pass to prompt generation as existing code
+func (a *App) GetExistingModules(path string, names []string) ([]mcp.ExistingModule, error) {
+	project, err := a.ReadVBAProject(path)
+	if err != nil {
+		return nil, err
+	}
+	return xlsx.ExistingModules(project, names), nil
+}
+
+// SplitVBAModules splits a generated response into the modules it would be
+// exported as, for preview before export
+func (a *App) SplitVBAModules(response string) *vbaexport.Bundle {
+	return vbaexport.NewBundle(response, vbaexport.Options{})
+}
+
+// ExportVBABundle writes the modules of a generated response as importable
+// .bas, .cls and .frm files in a zip at path, with a manifest and install
+// instructions. The files are written in codePage, that of the VBA editor
+// importing them (0 for Windows-1252); text the code page cannot hold fails
+// the export. Code the security policy denies is never exported; code it
+// wants confirmed is only exported once confirmed is true.
+func (a *App) ExportVBABundle(response string, path string, codePage int, confirmed bool) (*vbaexport.Manifest, error) {
+	var manifest vbaexport.Manifest
+	err := a.safeExecute("ExportVBABundle", func() error {
+		if err := a.gateCode(validation.ExtractVBACode(response), confirmed); err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		bundle := vbaexport.NewBundle(response, vbaexport.Options{Name: name, CodePage: codePage})
+		if len(bundle.Modules) == 0 {
+			return errors.New("the response contains no VBA code to export")
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if err := bundle.WriteZip(f); err != nil {
+			f.Close()
+			os.Remove(path)
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		manifest = bundle.Manifest()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// ValidateVBA checks generated VBA code and returns diagnostics for the code editor
+func (a *App) ValidateVBA(code string) *validation.Report {
+	return validation.ValidateVBA(code)
+}
+
+// CheckVBAReferences compares the sheets, headers, columns and ranges used by
+// generated code with the analyzed data
+func (a *App) CheckVBAReferences(code string, structure mcp.DataRange) *validation.ReferenceReport {
+	return validation.CheckReferences(code, structure)
+}
+
+// CheckVBASQL parses the queries generated code passes to getSQL and checks
+// their tables and columns against the analyzed data
+func (a *App) CheckVBASQL(code string, structure mcp.DataRange) *validation.SQLReport {
+	return validation.CheckSQL(code, structure)
+}
+
+// PreviewSQL runs a Jet SQL query over the sample rows of the analyzed data
+func (a *App) PreviewSQL(query string, structures []mcp.DataRange) (*jetsql.Result, error) {
+	db := jetsql.NewDatabase()
+	for _, structure := range structures {
+		db.AddTable(jetsql.TableFromDataRange(structure))
+	}
+	return db.Query(query)
+}
+
+// PreviewVBASQL dry-runs the queries generated code passes to getSQL and
+// returns their result headers and rows
+func (a *App) PreviewVBASQL(code string, structures []mcp.DataRange) []validation.SQLPreview {
+	return validation.PreviewSQL(code, structures...)
+}
+
+// DryRunVBA runs generated code against a mock workbook built from the sample
+// data and returns the cells it would change. entry names the macro to run;
+// when empty the first Sub without parameters is used. Code the security
+// policy denies is not run.
+func (a *App) DryRunVBA(code string, structures []mcp.DataRange, entry string) (*dryrun.Result, error) {
+	if _, err := a.securityChecker().Gate(code); err != nil {
+		return nil, err
+	}
+	return dryrun.Run(code, structures, dryrun.Options{Entry: entry}), nil
+}
+
+// gateCode applies the security policy to code about to leave the app:
+// denied code is refused, and code that needs confirmation is refused
+// until the user has confirmed it
+func (a *App) gateCode(code string, confirmed bool) error {
+	report, err := a.securityChecker().Gate(code)
+	if err != nil {
+		return err
+	}
+	if report.NeedsConfirmation() && !confirmed {
+		return fmt.Errorf("the code needs confirmation before it is exported: %s", strings.Join(report.Reasons, "; "))
+	}
+	return nil
+}
+
+// CheckVBASecurity scans generated VBA code for risky operations and applies the security policy
+func (a *App) CheckVBASecurity(code string) *validation.SecurityReport {
+	return a.securityChecker().Check(code)
+}
+
+// GetSecurityPolicy returns the active security policy
+func (a *App) GetSecurityPolicy() *validation.SecurityPolicy {
+	return a.securityChecker().Policy
+}
+
+// UpdateSecurityPolicy validates, saves and activates a new security policy
+func (a *App) UpdateSecurityPolicy(policy validation.SecurityPolicy) error {
+	return a.safeExecute("UpdateSecurityPolicy", func() error {
+		if err := policy.Save(securityPolicyPath()); err != nil {
+			return err
+		}
+		a.setSecurity(validation.NewSecurityChecker(&policy))
+		return nil
+	})
+}
+
+// FormatVBA formats generated code in the team style. In check mode only the
+// diff is returned; otherwise the result also carries the formatted code.
+func (a *App) FormatVBA(code string, check bool) (*vba.FormatResult, error) {
+	return vba.CheckFormat("generated.bas", code, a.formatStyle(), !check)
+}
+
+// FormatVBAFiles formats exported module files in the team style. In check
+// mode the files are left untouched; in write mode changed files are rewritten.
+func (a *App) FormatVBAFiles(paths []string, write bool) ([]*vba.FormatResult, error) {
+	results := []*vba.FormatResult{}
+	err := a.safeExecute("FormatVBAFiles", func() error {
+		style := a.formatStyle()
+		for _, path := range paths {
+			result, err := vba.FormatFile(path, style, write)
+			if err != nil {
+				return err
+			}
+			results = append(results, result)
+		}
+		return nil
+	})
+	return results, err
+}
+
+// GetFormatStyle returns the active formatting style
+func (a *App) GetFormatStyle() *vba.FormatStyle {
+	return a.formatStyle()
+}
+
+// UpdateFormatStyle validates, saves and activates a new formatting style
+func (a *App) UpdateFormatStyle(style vba.FormatStyle) error {
+	return a.safeExecute("UpdateFormatStyle", func() error {
+		if err := style.Save(formatStylePath()); err != nil {
+			return err
+		}
+		a.setStyle(&style)
+		return nil
+	})
+}
+
+// GenerateVBA starts generating code for a requirement and returns the job
+// ID at once. Progress arrives as generation:phase, generation:delta,
+// generation:attempt, generation:candidates, generation:result and
+// generation:error events carrying the job ID. Code that needs the user's
+// confirmation is released by ConfirmGeneration.
+func (a *App) GenerateVBA(req generation.Request) (string, error) {
+	var jobID string
+	err := a.safeExecute("GenerateVBA", func() error {
+		// The job keeps the client and policy it started with; later
+		// settings changes apply to the next job
+		config, clients := a.llmState()
+		if !config.Ready() {
+			return errors.New("no LLM provider is configured; set an API key or a local server URL first")
+		}
+		ctx := a.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		pipeline := generation.Pipeline{Client: clients.client, Security: a.securityChecker()}
+		jobID = a.jobs.Start(llm.WithCaller(ctx, a.session, currentUser()), pipeline, req, func(event string, payload interface{}) {
+			wailsruntime.EventsEmit(ctx, event, payload)
+		})
+		return nil
+	})
+	return jobID, err
+}
+
+// ConfirmGeneration releases the code of a finished job that the security
+// policy held back for confirmation
+func (a *App) ConfirmGeneration(jobID string) (*generation.Result, error) {
+	var result *generation.Result
+	err := a.safeExecute("ConfirmGeneration", func() error {
+		var err error
+		result, err = a.jobs.Release(jobID)
+		return err
+	})
+	return result, err
+}
+
+// CancelGeneration stops a running generation job
+func (a *App) CancelGeneration(jobID string) error {
+	return a.safeExecute("CancelGeneration", func() error {
+		return a.jobs.Cancel(jobID)
+	})
+}
+
+// GetGenerationJobs lists the running generation jobs
+func (a *App) GetGenerationJobs() []generation.Job {
+	return a.jobs.Jobs()
+}
+
+// GetLLMConfig returns the LLM provider settings with the API key hidden
+func (a *App) GetLLMConfig() llm.Config {
+	config, _ := a.llmState()
+	return config.Redacted()
+}
+
+// UpdateLLMConfig validates, saves and activates new LLM provider settings.
+// An empty or hidden API key, and missing headers, keep the saved ones
+// only while the provider and base URL stay the same.
+func (a *App) UpdateLLMConfig(config llm.Config) error {
+	return a.safeExecute("UpdateLLMConfig", func() error {
+		// The stored key and headers only carry over to the same server; a
+		// key must never be sent to a provider it was not entered for
+		saved, _ := a.llmState()
+		sameServer := config.Provider == saved.Provider && config.BaseURL == saved.BaseURL
+		redacted := saved.APIKey != "" && config.APIKey == saved.Redacted().APIKey
+		switch {
+		case sameServer && (config.APIKey == "" || redacted):
+			config.APIKey = saved.APIKey
+		case redacted:
+			return errors.New("a new API key is required when the provider or base URL changes")
+		}
+		if config.Headers == nil && sameServer {
+			config.Headers = saved.Headers
+		}
+		clients, err := a.newLLMClient(config)
+		if err != nil {
+			return err
+		}
+		if err := config.Save(llmConfigPath()); err != nil {
+			return err
+		}
+		a.useLLM(&config, clients)
+		return nil
+	})
+}
+
+// GetLLMModelInfo describes the model prompts are sent to
+func (a *App) GetLLMModelInfo() llm.ModelInfo {
+	_, clients := a.llmState()
+	return clients.client.Model()
+}
+
+// GetLLMUsage returns the tokens used since the provider was configured
+func (a *App) GetLLMUsage() llm.Usage {
+	_, clients := a.llmState()
+	return clients.client.Usage()
+}
+
+// LLMStats reports the usage, cost and health of the LLM provider
+type LLMStats struct {
+	Session    string              `json:"session"`
+	Model      llm.ModelInfo       `json:"model"`
+	Usage      llm.UsageSnapshot   `json:"usage"`
+	Resilience llm.ResilienceStats `json:"resilience"`
+	Local      *llm.Capabilities   `json:"local,omitempty"` // Detected capabilities of a local server
+}
+
+// GetLLMStats returns the tokens and estimated cost per session and user,
+// along with retry, throttling and circuit breaker counters
+func (a *App) GetLLMStats() LLMStats {
+	_, clients := a.llmState()
+	stats := LLMStats{
+		Session:    a.session,
+		Model:      clients.client.Model(),
+		Usage:      a.ledger.Snapshot(),
+		Resilience: clients.resilience.Stats(),
+	}
+	if clients.local != nil {
+		stats.Local = clients.local.Capabilities()
+	}
+	return stats
+}
+
+// llmClients holds the layers of the LLM client the app keeps a handle on
+type llmClients struct {
+	client     llm.Client // Outermost layer, used for requests
+	resilience *llm.ResilientClient
+	local      *llm.LocalClient
+}
+
+// newLLMClient builds the client for a provider, wrapped in rate limiting,
+// retries and a circuit breaker, and booking its usage in the ledger
+func (a *App) newLLMClient(config llm.Config) (llmClients, error) {
+	provider, err := llm.New(config)
+	if err != nil {
+		return llmClients{}, err
+	}
+	local, _ := provider.(*llm.LocalClient)
+	resilience := llm.NewResilientClient(provider, llm.ResilienceOptions{
+		MaxRetries: config.MaxRetries,
+		Limiter:    a.limiters.For(config),
+	})
+	var client llm.Client = llm.NewMeteredClient(resilience, a.ledger, config.Pricing)
+	if a.cache != nil {
+		client = llm.NewCachedClient(client, a.cache, mcp.NormalizePrompt)
+	}
+	return llmClients{client: client, resilience: resilience, local: local}, nil
+}
+
+// useLLM activates new settings and their client, applying the cache
+// limits of the settings to the shared cache. A local server is probed in
+// the background so the first prompt already fits its context window.
+func (a *App) useLLM(config *llm.Config, clients llmClients) {
+	if a.cache != nil {
+		// The settings were validated when the client was built
+		if err := a.cache.SetConfig(config.Cache); err != nil {
+			a.logger.Printf("WARNING: %v; keeping the previous cache settings", err)
+		}
+	}
+	a.mu.Lock()
+	a.llmConfig, a.llm, a.resilience, a.local = config, clients.client, clients.resilience, clients.local
+	a.mu.Unlock()
+	if clients.local != nil && a.ctx != nil {
+		go func() {
+			if caps, err := clients.local.Detect(a.ctx); err != nil {
+				a.logger.Printf("WARNING: %v", err)
+			} else {
+				a.logger.Printf("Local %s server runs %s with a %d-token context", caps.Server, caps.Model, caps.ContextWindow)
+			}
+		}()
+	}
+}
+
+// llmState returns the active LLM settings and client layers together
+func (a *App) llmState() (*llm.Config, llmClients) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.llmConfig, llmClients{client: a.llm, resilience: a.resilience, local: a.local}
+}
+
+// securityChecker returns the active security checker
+func (a *App) securityChecker() *validation.SecurityChecker {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.security
+}
+
+// setSecurity activates a new security checker
+func (a *App) setSecurity(checker *validation.SecurityChecker) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.security = checker
+}
+
+// formatStyle returns the active formatting style
+func (a *App) formatStyle() *vba.FormatStyle {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.style
+}
+
+// setStyle activates a new formatting style
+func (a *App) setStyle(style *vba.FormatStyle) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.style = style
+}
+
+// DetectLLMCapabilities probes the local model server again and reports its
+// models, context window, streaming and JSON mode support
+func (a *App) DetectLLMCapabilities() (*llm.Capabilities, error) {
+	var caps *llm.Capabilities
+	err := a.safeExecute("DetectLLMCapabilities", func() error {
+		_, clients := a.llmState()
+		if clients.local == nil {
+			return errors.New("the LLM provider is not a local server")
+		}
+		ctx := a.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		var err error
+		caps, err = clients.local.Detect(ctx)
+		return err
+	})
+	return caps, err
+}
+
+// ClearLLMCache removes every cached response
+func (a *App) ClearLLMCache() error {
+	return a.safeExecute("ClearLLMCache", func() error {
+		if a.cache == nil {
+			return errors.New("the response cache is not available")
+		}
+		return a.cache.Clear()
+	})
+}
+
+// currentUser returns the OS user name usage is booked to
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return "local"
+}
+
+// securityPolicyPath returns the location of the team security policy file
+func securityPolicyPath() string {
+	if path := os.Getenv("EXCELMCP_SECURITY_POLICY"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "ExcelMCP", "security_policy.json")
+}
+
+// formatStylePath returns the location of the team formatting style file
+func formatStylePath() string {
+	if path := os.Getenv("EXCELMCP_FORMAT_STYLE"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "ExcelMCP", "format_style.json")
+}
+
+// llmConfigPath returns the location of the LLM provider settings
+func llmConfigPath() string {
+	if path := os.Getenv("EXCELMCP_LLM_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "ExcelMCP", "llm_config.json")
+}
+
+// responseCacheDir returns the directory of cached LLM responses
+func responseCacheDir() string {
+	if dir := os.Getenv("EXCELMCP_CACHE_DIR"); dir != "" {
+		return dir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "ExcelMCP", "responses")
+}
+
 // Error handling and recovery
 func (a *App) handlePanic() {
 	if r := recover(); r != nil {
@@ -234,7 +861,9 @@ func main() {
 			OpenInspectorOnStartup: false,
 		},
 		// Export app methods to frontend
-		Ctx: app,
+		Bind: []interface{}{
+			app,
+		},
 	})
 
 	if err != nil {
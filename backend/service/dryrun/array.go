@@ -0,0 +1,109 @@
+package dryrun
+
+// Array is a VBA array of one or more dimensions. Elements are stored with
+// the first dimension varying fastest, the order For Each visits them in.
+type Array struct {
+	Lower   []int   // Lower bound of each dimension
+	Lengths []int   // Number of elements in each dimension
+	Data    []Value // Elements
+	Type    string  // Declared element type; "" or "Variant" for Variant arrays
+	Fixed   bool    // Declared with bounds, so ReDim is not allowed
+}
+
+// NewArray creates an array with the given bounds filled with the default
+// value of the element type
+func NewArray(lower, upper []int, elemType string) *Array {
+	arr := &Array{Lower: append([]int(nil), lower...), Type: elemType}
+	size := 1
+	for i := range lower {
+		n := upper[i] - lower[i] + 1
+		if n < 0 {
+			n = 0
+		}
+		arr.Lengths = append(arr.Lengths, n)
+		size *= n
+	}
+	arr.Data = make([]Value, size)
+	zero := defaultValue(elemType)
+	for i := range arr.Data {
+		arr.Data[i] = zero
+	}
+	return arr
+}
+
+// ListArray creates a one-dimensional array holding the given values
+func ListArray(lower int, values []Value) *Array {
+	return &Array{Lower: []int{lower}, Lengths: []int{len(values)}, Data: values}
+}
+
+// Upper returns the upper bound of dimension dim (0-based)
+func (a *Array) Upper(dim int) int {
+	return a.Lower[dim] + a.Lengths[dim] - 1
+}
+
+// offset returns the position of an element in Data, or -1 when an index is
+// out of range or the number of indexes does not match
+func (a *Array) offset(indexes []int) int {
+	if len(indexes) != len(a.Lengths) {
+		return -1
+	}
+	offset, stride := 0, 1
+	for dim, index := range indexes {
+		i := index - a.Lower[dim]
+		if i < 0 || i >= a.Lengths[dim] {
+			return -1
+		}
+		offset += i * stride
+		stride *= a.Lengths[dim]
+	}
+	return offset
+}
+
+// Copy returns a deep copy of the array, as VBA makes when an array is assigned
+func (a *Array) Copy() *Array {
+	c := *a
+	c.Lower = append([]int(nil), a.Lower...)
+	c.Lengths = append([]int(nil), a.Lengths...)
+	c.Data = make([]Value, len(a.Data))
+	for i, v := range a.Data {
+		if v.Kind == KindArray {
+			v.Arr = v.Arr.Copy()
+		}
+		c.Data[i] = v
+	}
+	c.Fixed = false
+	return &c
+}
+
+// Redim resizes the array. With preserve only the last dimension may change
+// and existing elements are kept.
+func (a *Array) Redim(lower, upper []int, preserve bool) bool {
+	if !preserve {
+		*a = *NewArray(lower, upper, a.Type)
+		return true
+	}
+	if len(a.Lengths) != 0 && len(lower) != len(a.Lengths) {
+		return false
+	}
+	last := len(lower) - 1
+	for dim := 0; dim < last && len(a.Lengths) != 0; dim++ {
+		if lower[dim] != a.Lower[dim] || upper[dim]-lower[dim]+1 != a.Lengths[dim] {
+			return false
+		}
+	}
+
+	resized := NewArray(lower, upper, a.Type)
+	if len(a.Lengths) != 0 && last >= 0 {
+		inner := len(a.Data)
+		if a.Lengths[last] > 0 {
+			inner = len(a.Data) / a.Lengths[last]
+		}
+		keep := a.Lengths[last]
+		if resized.Lengths[last] < keep {
+			keep = resized.Lengths[last]
+		}
+		copy(resized.Data, a.Data[:inner*keep])
+	}
+	*a = *resized
+	return true
+}
@@ -0,0 +1,1180 @@
+package dryrun
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"excel-automation-mcp/backend/service/jetsql"
+	"excel-automation-mcp/backend/service/vba"
+)
+
+// builtinFunc is a VBA library function
+type builtinFunc struct {
+	min, max int  // Number of arguments; max -1 for ParamArray functions
+	raw      bool // Receives objects and arrays instead of default values
+	fn       func(in *Interpreter, args []Arg) (Value, error)
+}
+
+// builtin checks the arguments and calls a library function
+func (in *Interpreter) builtin(node vba.Node, name string, fn builtinFunc, args []Arg) Value {
+	if len(args) < fn.min || (fn.max >= 0 && len(args) > fn.max) {
+		in.fail(node, runtimeError(450, name))
+	}
+	for i := 0; i < fn.min; i++ {
+		if args[i].Missing {
+			in.fail(node, runtimeError(449, name))
+		}
+	}
+	if !fn.raw {
+		for i := range args {
+			if !args[i].Missing {
+				args[i].Value = in.scalar(node, args[i].Value)
+			}
+		}
+	}
+	v, err := fn.fn(in, args)
+	in.check(node, err)
+	return v
+}
+
+// arg returns argument i, or Empty and false when it was omitted
+func arg(args []Arg, i int) (Value, bool) {
+	if i >= len(args) || args[i].Missing {
+		return Empty, false
+	}
+	return args[i].Value, true
+}
+
+// namedArg returns the argument passed by name or at position i
+func namedArg(args []Arg, i int, name string) (Value, bool) {
+	for _, a := range args {
+		if strings.EqualFold(a.Name, name) && !a.Missing {
+			return a.Value, true
+		}
+	}
+	positional := 0
+	for _, a := range args {
+		if a.Name != "" {
+			continue
+		}
+		if positional == i {
+			return a.Value, !a.Missing
+		}
+		positional++
+	}
+	return Empty, false
+}
+
+func argString(args []Arg, i int) (string, error) {
+	v, _ := arg(args, i)
+	switch v.Kind {
+	case KindNull:
+		return "", runtimeError(94, "")
+	case KindArray, KindObject, KindError:
+		return "", runtimeError(13, "")
+	}
+	return v.String(), nil
+}
+
+func argNumber(args []Arg, i int, fallback float64) (float64, error) {
+	v, ok := arg(args, i)
+	if !ok {
+		return fallback, nil
+	}
+	return toNumber(v)
+}
+
+func argInt(args []Arg, i int, fallback int) (int, error) {
+	n, err := argNumber(args, i, float64(fallback))
+	if err != nil {
+		return 0, err
+	}
+	n = roundEven(n)
+	if math.Abs(n) > math.MaxInt32 {
+		return 0, runtimeError(6, "")
+	}
+	return int(n), nil
+}
+
+func argDate(args []Arg, i int) (time.Time, error) {
+	v, _ := arg(args, i)
+	return toDate(v)
+}
+
+// toDate converts a value to a time the way CDate does
+func toDate(v Value) (time.Time, error) {
+	switch v.Kind {
+	case KindString:
+		if t, ok := parseDate(v.Str); ok {
+			return t, nil
+		}
+		if n, ok := parseNumber(v.Str); ok {
+			return serialTime(n), nil
+		}
+		return time.Time{}, runtimeError(13, strconv.Quote(v.Str)+" is not a date")
+	case KindNull:
+		return time.Time{}, runtimeError(94, "")
+	}
+	n, err := toNumber(v)
+	return serialTime(n), err
+}
+
+// nullable returns Null when the first argument is Null, as most string
+// functions do
+func nullable(fn func(in *Interpreter, args []Arg) (Value, error)) func(in *Interpreter, args []Arg) (Value, error) {
+	return func(in *Interpreter, args []Arg) (Value, error) {
+		if v, _ := arg(args, 0); v.Kind == KindNull {
+			return Null, nil
+		}
+		return fn(in, args)
+	}
+}
+
+// textCompare reports whether a compare argument at position i asks for
+// case-insensitive comparison
+func (in *Interpreter) textCompare(args []Arg, i int) bool {
+	if v, ok := arg(args, i); ok {
+		n, _ := toNumber(v)
+		return n == 1
+	}
+	return in.compareText
+}
+
+// conversion returns the builtin for a C* conversion function
+func conversion(typeName string) builtinFunc {
+	return builtinFunc{min: 1, max: 1, fn: func(in *Interpreter, args []Arg) (Value, error) {
+		v := args[0].Value
+		if typeName == "date" && v.Kind == KindString {
+			t, err := toDate(v)
+			return DateValue(t), err
+		}
+		if v.Kind == KindString && typeName == "boolean" {
+			switch strings.ToLower(strings.TrimSpace(v.Str)) {
+			case "true":
+				return Bool(true), nil
+			case "false":
+				return Bool(false), nil
+			}
+		}
+		if v.Kind == KindEmpty && typeName == "string" {
+			return Str(""), nil
+		}
+		return coerce(typeName, v)
+	}}
+}
+
+// numeric returns the builtin for a one-argument math function
+func numeric(fn func(float64) (float64, bool), integral bool) builtinFunc {
+	return builtinFunc{min: 1, max: 1, fn: nullable(func(in *Interpreter, args []Arg) (Value, error) {
+		n, err := toNumber(args[0].Value)
+		if err != nil {
+			return Empty, err
+		}
+		result, ok := fn(n)
+		if !ok {
+			return Empty, runtimeError(5, "")
+		}
+		if integral && isIntegral(args[0].Value) {
+			return Long(int64(result)), nil
+		}
+		return Double(result), nil
+	})}
+}
+
+func datePart(part func(t time.Time) int) builtinFunc {
+	return builtinFunc{min: 1, max: 1, fn: nullable(func(in *Interpreter, args []Arg) (Value, error) {
+		t, err := argDate(args, 0)
+		return Long(int64(part(t))), err
+	})}
+}
+
+var builtins map[string]builtinFunc
+
+func init() {
+	builtins = map[string]builtinFunc{
+		// Strings
+		"len": {min: 1, max: 1, fn: nullable(func(in *Interpreter, args []Arg) (Value, error) {
+			s, err := argString(args, 0)
+			return Long(int64(len([]rune(s)))), err
+		})},
+		"left":  {min: 2, max: 2, fn: nullable(substring(func(r []rune, n int) []rune { return r[:n] }))},
+		"right": {min: 2, max: 2, fn: nullable(substring(func(r []rune, n int) []rune { return r[len(r)-n:] }))},
+		"mid": {min: 2, max: 3, fn: nullable(func(in *Interpreter, args []Arg) (Value, error) {
+			s, err := argString(args, 0)
+			if err != nil {
+				return Empty, err
+			}
+			start, err := argInt(args, 1, 1)
+			if err != nil {
+				return Empty, err
+			}
+			r := []rune(s)
+			length, err := argInt(args, 2, len(r))
+			if err != nil {
+				return Empty, err
+			}
+			if start < 1 || length < 0 {
+				return Empty, runtimeError(5, "Mid")
+			}
+			if start > len(r) {
+				return Str(""), nil
+			}
+			end := start - 1 + length
+			if end > len(r) {
+				end = len(r)
+			}
+			return Str(string(r[start-1 : end])), nil
+		})},
+		"instr":    {min: 2, max: 4, fn: instr},
+		"instrrev": {min: 2, max: 4, fn: instrRev},
+		"replace":  {min: 3, max: 6, fn: replace},
+		"trim":     {min: 1, max: 1, fn: nullable(stringFunc(func(s string) string { return strings.Trim(s, " ") }))},
+		"ltrim":    {min: 1, max: 1, fn: nullable(stringFunc(func(s string) string { return strings.TrimLeft(s, " ") }))},
+		"rtrim":    {min: 1, max: 1, fn: nullable(stringFunc(func(s string) string { return strings.TrimRight(s, " ") }))},
+		"ucase":    {min: 1, max: 1, fn: nullable(stringFunc(strings.ToUpper))},
+		"lcase":    {min: 1, max: 1, fn: nullable(stringFunc(strings.ToLower))},
+		"strreverse": {min: 1, max: 1, fn: stringFunc(func(s string) string {
+			r := []rune(s)
+			for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+				r[i], r[j] = r[j], r[i]
+			}
+			return string(r)
+		})},
+		"space": {min: 1, max: 1, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			n, err := argInt(args, 0, 0)
+			if err == nil && n < 0 {
+				err = runtimeError(5, "Space")
+			}
+			if err != nil {
+				return Empty, err
+			}
+			return Str(strings.Repeat(" ", n)), nil
+		}},
+		"string": {min: 2, max: 2, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			n, err := argInt(args, 0, 0)
+			if err == nil && n < 0 {
+				err = runtimeError(5, "String")
+			}
+			if err != nil {
+				return Empty, err
+			}
+			char := args[1].Value
+			if char.IsNumeric() {
+				return Str(strings.Repeat(string(rune(int(char.Num))), n)), nil
+			}
+			r := []rune(char.String())
+			if len(r) == 0 {
+				return Empty, runtimeError(5, "String")
+			}
+			return Str(strings.Repeat(string(r[0]), n)), nil
+		}},
+		"chr":  {min: 1, max: 1, fn: chr},
+		"chrw": {min: 1, max: 1, fn: chr},
+		"asc":  {min: 1, max: 1, fn: asc},
+		"ascw": {min: 1, max: 1, fn: asc},
+		"strcomp": {min: 2, max: 3, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			if args[0].Value.Kind == KindNull || args[1].Value.Kind == KindNull {
+				return Null, nil
+			}
+			a, b := args[0].Value.String(), args[1].Value.String()
+			if in.textCompare(args, 2) {
+				a, b = strings.ToLower(a), strings.ToLower(b)
+			}
+			return Long(int64(strings.Compare(a, b))), nil
+		}},
+		"strconv": {min: 2, max: 3, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			s, err := argString(args, 0)
+			if err != nil {
+				return Empty, err
+			}
+			mode, err := argInt(args, 1, 0)
+			switch {
+			case err != nil:
+				return Empty, err
+			case mode&3 == 3:
+				return Str(properCase(s)), nil
+			case mode&1 == 1:
+				return Str(strings.ToUpper(s)), nil
+			case mode&2 == 2:
+				return Str(strings.ToLower(s)), nil
+			}
+			return Str(s), nil
+		}},
+		"split": {min: 1, max: 4, fn: split},
+		"join": {min: 1, max: 2, raw: true, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			list := args[0].Value
+			if list.Kind != KindArray || len(list.Arr.Lengths) != 1 {
+				return Empty, runtimeError(5, "Join needs a one-dimensional array")
+			}
+			delimiter := " "
+			if v, ok := arg(args, 1); ok {
+				delimiter = v.String()
+			}
+			parts := make([]string, len(list.Arr.Data))
+			for i, v := range list.Arr.Data {
+				parts[i] = v.String()
+			}
+			return Str(strings.Join(parts, delimiter)), nil
+		}},
+		"val": {min: 1, max: 1, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			s, err := argString(args, 0)
+			return Double(val(s)), err
+		}},
+		"format": {min: 1, max: 4, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			pattern := ""
+			if v, ok := arg(args, 1); ok {
+				pattern = v.String()
+			}
+			return Str(format(args[0].Value, pattern)), nil
+		}},
+		"hex": {min: 1, max: 1, fn: nullable(func(in *Interpreter, args []Arg) (Value, error) {
+			n, err := argInt(args, 0, 0)
+			return Str(strings.ToUpper(strconv.FormatInt(int64(uint32(int32(n))), 16))), err
+		})},
+		"oct": {min: 1, max: 1, fn: nullable(func(in *Interpreter, args []Arg) (Value, error) {
+			n, err := argInt(args, 0, 0)
+			return Str(strconv.FormatInt(int64(uint32(int32(n))), 8)), err
+		})},
+
+		// Math
+		"abs": numeric(func(n float64) (float64, bool) { return math.Abs(n), true }, true),
+		"int": numeric(func(n float64) (float64, bool) { return math.Floor(n), true }, true),
+		"fix": numeric(func(n float64) (float64, bool) { return math.Trunc(n), true }, true),
+		"sgn": {min: 1, max: 1, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			n, err := toNumber(args[0].Value)
+			switch {
+			case n > 0:
+				return Long(1), err
+			case n < 0:
+				return Long(-1), err
+			}
+			return Long(0), err
+		}},
+		"sqr": numeric(func(n float64) (float64, bool) { return math.Sqrt(n), n >= 0 }, false),
+		"exp": numeric(func(n float64) (float64, bool) { return math.Exp(n), n <= 709 }, false),
+		"log": numeric(func(n float64) (float64, bool) { return math.Log(n), n > 0 }, false),
+		"sin": numeric(func(n float64) (float64, bool) { return math.Sin(n), true }, false),
+		"cos": numeric(func(n float64) (float64, bool) { return math.Cos(n), true }, false),
+		"tan": numeric(func(n float64) (float64, bool) { return math.Tan(n), true }, false),
+		"atn": numeric(func(n float64) (float64, bool) { return math.Atan(n), true }, false),
+		"round": {min: 1, max: 2, fn: nullable(func(in *Interpreter, args []Arg) (Value, error) {
+			n, err := toNumber(args[0].Value)
+			if err != nil {
+				return Empty, err
+			}
+			digits, err := argInt(args, 1, 0)
+			if err != nil || digits < 0 {
+				return Empty, runtimeError(5, "Round")
+			}
+			scale := math.Pow(10, float64(digits))
+			rounded := math.RoundToEven(n*scale) / scale
+			if isIntegral(args[0].Value) {
+				return Long(int64(rounded)), nil
+			}
+			return Double(rounded), nil
+		})},
+		"rnd": {min: 0, max: 1, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			// The VBA generator, so runs are repeatable
+			in.rnd = (in.rnd*1140671485 + 12820163) & 0xFFFFFF
+			return Double(float64(in.rnd) / 16777216), nil
+		}},
+		"randomize": {min: 0, max: 1, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			in.note("randomize", "Randomize is ignored so Rnd returns the same sequence on every dry run")
+			return Empty, nil
+		}},
+
+		// Conversion
+		"cbool":   conversion("boolean"),
+		"cbyte":   conversion("byte"),
+		"cint":    conversion("integer"),
+		"clng":    conversion("long"),
+		"clnglng": conversion("longlong"),
+		"clngptr": conversion("longptr"),
+		"csng":    conversion("single"),
+		"cdbl":    conversion("double"),
+		"ccur":    conversion("currency"),
+		"cdec":    conversion("decimal"),
+		"cstr":    conversion("string"),
+		"cdate":   conversion("date"),
+		"cvdate":  conversion("date"),
+		"cvar":    conversion("variant"),
+		"cverr": {min: 1, max: 1, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			n, err := argInt(args, 0, 0)
+			return Value{Kind: KindError, Num: float64(n)}, err
+		}},
+
+		// Information
+		"isempty":   {min: 1, max: 1, fn: func(in *Interpreter, args []Arg) (Value, error) { return Bool(args[0].Value.Kind == KindEmpty), nil }},
+		"isnull":    {min: 1, max: 1, fn: func(in *Interpreter, args []Arg) (Value, error) { return Bool(args[0].Value.Kind == KindNull), nil }},
+		"iserror":   {min: 1, max: 1, fn: func(in *Interpreter, args []Arg) (Value, error) { return Bool(args[0].Value.Kind == KindError), nil }},
+		"isarray":   {min: 1, max: 1, raw: true, fn: func(in *Interpreter, args []Arg) (Value, error) { return Bool(args[0].Value.Kind == KindArray), nil }},
+		"isobject":  {min: 1, max: 1, raw: true, fn: func(in *Interpreter, args []Arg) (Value, error) { return Bool(args[0].Value.Kind == KindObject), nil }},
+		"ismissing": {min: 1, max: 1, raw: true, fn: func(in *Interpreter, args []Arg) (Value, error) { return Bool(args[0].Value.missing), nil }},
+		"isnumeric": {min: 1, max: 1, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			v := args[0].Value
+			if v.Kind == KindString {
+				_, ok := parseNumber(v.Str)
+				return Bool(ok), nil
+			}
+			return Bool(v.Kind == KindEmpty || (v.IsNumeric() && v.Kind != KindDate)), nil
+		}},
+		"isdate": {min: 1, max: 1, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			v := args[0].Value
+			if v.Kind == KindString {
+				_, ok := parseDate(v.Str)
+				return Bool(ok), nil
+			}
+			return Bool(v.Kind == KindDate), nil
+		}},
+		"typename": {min: 1, max: 1, raw: true, fn: func(in *Interpreter, args []Arg) (Value, error) { return Str(args[0].Value.TypeName()), nil }},
+		"vartype": {min: 1, max: 1, raw: true, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			return Long(varType(args[0].Value)), nil
+		}},
+		"lbound": {min: 1, max: 2, raw: true, fn: bound(false)},
+		"ubound": {min: 1, max: 2, raw: true, fn: bound(true)},
+		"array": {min: 0, max: -1, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			values := make([]Value, len(args))
+			for i, a := range args {
+				values[i] = a.Value
+			}
+			return ArrayValue(ListArray(in.optionBase, values)), nil
+		}},
+
+		// Dates
+		"now": {fn: func(in *Interpreter, args []Arg) (Value, error) { return DateValue(in.opts.Now), nil }},
+		"date": {fn: func(in *Interpreter, args []Arg) (Value, error) {
+			return Value{Kind: KindDate, Num: math.Floor(dateSerial(in.opts.Now))}, nil
+		}},
+		"time": {fn: func(in *Interpreter, args []Arg) (Value, error) {
+			serial := dateSerial(in.opts.Now)
+			return Value{Kind: KindDate, Num: serial - math.Floor(serial)}, nil
+		}},
+		"timer": {fn: func(in *Interpreter, args []Arg) (Value, error) {
+			t := in.opts.Now
+			return Double(float64(t.Hour()*3600+t.Minute()*60+t.Second()) + float64(t.Nanosecond())/1e9), nil
+		}},
+		"year":   datePart(func(t time.Time) int { return t.Year() }),
+		"month":  datePart(func(t time.Time) int { return int(t.Month()) }),
+		"day":    datePart(func(t time.Time) int { return t.Day() }),
+		"hour":   datePart(func(t time.Time) int { return t.Hour() }),
+		"minute": datePart(func(t time.Time) int { return t.Minute() }),
+		"second": datePart(func(t time.Time) int { return t.Second() }),
+		"weekday": {min: 1, max: 2, fn: nullable(func(in *Interpreter, args []Arg) (Value, error) {
+			t, err := argDate(args, 0)
+			if err != nil {
+				return Empty, err
+			}
+			first, err := argInt(args, 1, 1)
+			return Long(int64(weekday(t, first))), err
+		})},
+		"dateserial": {min: 3, max: 3, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			var parts [3]int
+			for i := range parts {
+				n, err := argInt(args, i, 0)
+				if err != nil {
+					return Empty, err
+				}
+				parts[i] = n
+			}
+			year := parts[0]
+			if year >= 0 && year < 30 {
+				year += 2000
+			} else if year >= 30 && year < 100 {
+				year += 1900
+			}
+			return DateValue(time.Date(year, time.Month(parts[1]), parts[2], 0, 0, 0, 0, time.UTC)), nil
+		}},
+		"timeserial": {min: 3, max: 3, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			seconds := 0
+			for i, scale := range []int{3600, 60, 1} {
+				n, err := argInt(args, i, 0)
+				if err != nil {
+					return Empty, err
+				}
+				seconds += n * scale
+			}
+			return Value{Kind: KindDate, Num: float64(seconds) / 86400}, nil
+		}},
+		"datevalue": {min: 1, max: 1, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			t, err := argDate(args, 0)
+			return Value{Kind: KindDate, Num: math.Floor(dateSerial(t))}, err
+		}},
+		"timevalue": {min: 1, max: 1, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			t, err := argDate(args, 0)
+			serial := dateSerial(t)
+			return Value{Kind: KindDate, Num: serial - math.Floor(serial)}, err
+		}},
+		"dateadd":  {min: 3, max: 3, fn: dateAdd},
+		"datediff": {min: 3, max: 5, fn: dateDiff},
+		"datepart": {min: 2, max: 4, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			interval, err := argString(args, 0)
+			if err != nil {
+				return Empty, err
+			}
+			t, err := argDate(args, 1)
+			if err != nil {
+				return Empty, err
+			}
+			switch strings.ToLower(interval) {
+			case "yyyy":
+				return Long(int64(t.Year())), nil
+			case "q":
+				return Long(int64((int(t.Month())-1)/3 + 1)), nil
+			case "m":
+				return Long(int64(t.Month())), nil
+			case "y":
+				return Long(int64(t.YearDay())), nil
+			case "d":
+				return Long(int64(t.Day())), nil
+			case "w":
+				return Long(int64(weekday(t, 1))), nil
+			case "ww":
+				_, week := t.ISOWeek()
+				return Long(int64(week)), nil
+			case "h":
+				return Long(int64(t.Hour())), nil
+			case "n":
+				return Long(int64(t.Minute())), nil
+			case "s":
+				return Long(int64(t.Second())), nil
+			}
+			return Empty, runtimeError(5, "DatePart interval "+interval)
+		}},
+		"monthname": {min: 1, max: 2, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			m, err := argInt(args, 0, 0)
+			if err != nil || m < 1 || m > 12 {
+				return Empty, runtimeError(5, "MonthName")
+			}
+			name := time.Month(m).String()
+			if abbreviate, _ := arg(args, 1); abbreviate.Num != 0 {
+				name = name[:3]
+			}
+			return Str(name), nil
+		}},
+		"weekdayname": {min: 1, max: 3, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			d, err := argInt(args, 0, 0)
+			if err != nil || d < 1 || d > 7 {
+				return Empty, runtimeError(5, "WeekdayName")
+			}
+			first, _ := argInt(args, 2, 1)
+			if first < 1 || first > 7 {
+				first = 1
+			}
+			name := time.Weekday((d + first - 2) % 7).String()
+			if abbreviate, _ := arg(args, 1); abbreviate.Num != 0 {
+				name = name[:3]
+			}
+			return Str(name), nil
+		}},
+
+		// Interaction
+		"msgbox":   {min: 1, max: 5, fn: msgBox},
+		"inputbox": {min: 1, max: 7, fn: inputBox},
+		"iif": {min: 3, max: 3, raw: true, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			cond := args[0].Value
+			if cond.Kind == KindObject {
+				return Empty, runtimeError(13, "")
+			}
+			n, err := toNumber(cond)
+			if err != nil || cond.Kind == KindNull {
+				return args[2].Value, err
+			}
+			if n != 0 {
+				return args[1].Value, nil
+			}
+			return args[2].Value, nil
+		}},
+		"choose": {min: 2, max: -1, raw: true, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			n, err := toNumber(args[0].Value)
+			index := int(n)
+			if err != nil || index < 1 || index >= len(args) {
+				return Null, err
+			}
+			return args[index].Value, nil
+		}},
+		"switch": {min: 2, max: -1, raw: true, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			if len(args)%2 != 0 {
+				return Empty, runtimeError(5, "Switch needs condition and value pairs")
+			}
+			for i := 0; i < len(args); i += 2 {
+				n, err := toNumber(args[i].Value)
+				if err != nil {
+					return Empty, err
+				}
+				if n != 0 {
+					return args[i+1].Value, nil
+				}
+			}
+			return Null, nil
+		}},
+		"doevents": {fn: func(in *Interpreter, args []Arg) (Value, error) { return Long(0), nil }},
+		"beep":     {fn: func(in *Interpreter, args []Arg) (Value, error) { return Empty, nil }},
+		"createobject": {min: 1, max: 2, fn: func(in *Interpreter, args []Arg) (Value, error) {
+			class, err := argString(args, 0)
+			if err != nil {
+				return Empty, err
+			}
+			obj, err := in.newObject(class)
+			return ObjectValue(obj), err
+		}},
+	}
+}
+
+// unsupportedFunctions reach outside the workbook, so the dry run stops
+// instead of pretending they succeeded
+var unsupportedFunctions = map[string]bool{
+	"shell": true, "dir": true, "kill": true, "filecopy": true, "mkdir": true, "rmdir": true, "chdir": true,
+	"curdir": true, "filelen": true, "filedatetime": true, "getattr": true, "setattr": true, "sendkeys": true,
+	"appactivate": true, "getobject": true, "freefile": true, "eof": true, "lof": true, "loc": true,
+	"savesetting": true, "getsetting": true, "deletesetting": true, "callbyname": true, "command": true,
+	"environ": true,
+}
+
+// newObject creates an object for New or CreateObject
+func (in *Interpreter) newObject(class string) (Object, error) {
+	switch strings.ToLower(class) {
+	case "collection", "vba.collection":
+		return &collection{}, nil
+	case "scripting.dictionary", "dictionary":
+		return newDictionary(), nil
+	}
+	return nil, unsupported("creating "+class, "only Collection and Scripting.Dictionary objects can be created")
+}
+
+func stringFunc(fn func(string) string) func(in *Interpreter, args []Arg) (Value, error) {
+	return func(in *Interpreter, args []Arg) (Value, error) {
+		s, err := argString(args, 0)
+		return Str(fn(s)), err
+	}
+}
+
+func substring(take func(r []rune, n int) []rune) func(in *Interpreter, args []Arg) (Value, error) {
+	return func(in *Interpreter, args []Arg) (Value, error) {
+		s, err := argString(args, 0)
+		if err != nil {
+			return Empty, err
+		}
+		n, err := argInt(args, 1, 0)
+		if err != nil {
+			return Empty, err
+		}
+		if n < 0 {
+			return Empty, runtimeError(5, "negative length")
+		}
+		r := []rune(s)
+		if n > len(r) {
+			n = len(r)
+		}
+		return Str(string(take(r, n))), nil
+	}
+}
+
+// runeIndex finds sub in s starting at rune offset start, returning a rune offset or -1
+func runeIndex(s, sub []rune, start int, ignoreCase bool) int {
+	for i := start; i+len(sub) <= len(s); i++ {
+		if runesEqual(s[i:i+len(sub)], sub, ignoreCase) {
+			return i
+		}
+	}
+	return -1
+}
+
+func runesEqual(a, b []rune, ignoreCase bool) bool {
+	for i := range a {
+		if a[i] != b[i] && !(ignoreCase && unicode.ToLower(a[i]) == unicode.ToLower(b[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+func instr(in *Interpreter, args []Arg) (Value, error) {
+	start := 1
+	if len(args) >= 3 {
+		n, err := argInt(args, 0, 1)
+		if err != nil {
+			return Empty, err
+		}
+		if n < 1 {
+			return Empty, runtimeError(5, "InStr start")
+		}
+		start, args = n, args[1:]
+	}
+	if args[0].Value.Kind == KindNull || args[1].Value.Kind == KindNull {
+		return Null, nil
+	}
+	s, find := []rune(args[0].Value.String()), []rune(args[1].Value.String())
+	if start > len(s) {
+		return Long(0), nil
+	}
+	if len(find) == 0 {
+		return Long(int64(start)), nil
+	}
+	return Long(int64(runeIndex(s, find, start-1, in.textCompare(args, 2)) + 1)), nil
+}
+
+func instrRev(in *Interpreter, args []Arg) (Value, error) {
+	s, err := argString(args, 0)
+	if err != nil {
+		return Empty, err
+	}
+	find, err := argString(args, 1)
+	if err != nil {
+		return Empty, err
+	}
+	r, f := []rune(s), []rune(find)
+	start, err := argInt(args, 2, -1)
+	if err != nil {
+		return Empty, err
+	}
+	if start == -1 {
+		start = len(r)
+	}
+	if start < 1 {
+		return Empty, runtimeError(5, "InStrRev start")
+	}
+	if len(f) == 0 {
+		return Long(int64(start)), nil
+	}
+	ignoreCase := in.textCompare(args, 3)
+	for i := start - len(f); i >= 0; i-- {
+		if i+len(f) <= len(r) && runesEqual(r[i:i+len(f)], f, ignoreCase) {
+			return Long(int64(i + 1)), nil
+		}
+	}
+	return Long(0), nil
+}
+
+func replace(in *Interpreter, args []Arg) (Value, error) {
+	var parts [3]string
+	for i := range parts {
+		s, err := argString(args, i)
+		if err != nil {
+			return Empty, err
+		}
+		parts[i] = s
+	}
+	start, err := argInt(args, 3, 1)
+	if err != nil {
+		return Empty, err
+	}
+	count, err := argInt(args, 4, -1)
+	if err != nil {
+		return Empty, err
+	}
+	if start < 1 {
+		return Empty, runtimeError(5, "Replace start")
+	}
+
+	s, find, with := []rune(parts[0]), []rune(parts[1]), []rune(parts[2])
+	if start > len(s) {
+		return Str(""), nil
+	}
+	s = s[start-1:]
+	if len(find) == 0 {
+		return Str(string(s)), nil
+	}
+	ignoreCase := in.textCompare(args, 5)
+	var out []rune
+	for i := 0; i < len(s); {
+		if count != 0 && i+len(find) <= len(s) && runesEqual(s[i:i+len(find)], find, ignoreCase) {
+			out = append(out, with...)
+			i += len(find)
+			count--
+			continue
+		}
+		out = append(out, s[i])
+		i++
+	}
+	return Str(string(out)), nil
+}
+
+func split(in *Interpreter, args []Arg) (Value, error) {
+	s, err := argString(args, 0)
+	if err != nil {
+		return Empty, err
+	}
+	delimiter := " "
+	if v, ok := arg(args, 1); ok {
+		delimiter = v.String()
+	}
+	limit, err := argInt(args, 2, -1)
+	if err != nil {
+		return Empty, err
+	}
+
+	var parts []string
+	switch {
+	case s == "":
+	case delimiter == "":
+		parts = []string{s}
+	case in.textCompare(args, 3):
+		r, d := []rune(s), []rune(delimiter)
+		from := 0
+		for {
+			if limit > 0 && len(parts) == limit-1 {
+				break
+			}
+			i := runeIndex(r, d, from, true)
+			if i < 0 {
+				break
+			}
+			parts = append(parts, string(r[from:i]))
+			from = i + len(d)
+		}
+		parts = append(parts, string(r[from:]))
+	default:
+		parts = strings.SplitN(s, delimiter, limit)
+	}
+
+	values := make([]Value, len(parts))
+	for i, part := range parts {
+		values[i] = Str(part)
+	}
+	arr := ListArray(0, values)
+	arr.Type = "String"
+	return ArrayValue(arr), nil
+}
+
+func chr(in *Interpreter, args []Arg) (Value, error) {
+	n, err := argInt(args, 0, 0)
+	if err != nil || n < 0 || n > 0xFFFF {
+		return Empty, runtimeError(5, "Chr")
+	}
+	if n < 256 {
+		// Chr uses the ANSI code page
+		return Str(string(windows1252(byte(n)))), nil
+	}
+	return Str(string(rune(n))), nil
+}
+
+func asc(in *Interpreter, args []Arg) (Value, error) {
+	s, err := argString(args, 0)
+	if err != nil {
+		return Empty, err
+	}
+	if s == "" {
+		return Empty, runtimeError(5, "Asc of an empty string")
+	}
+	r := []rune(s)[0]
+	for b := 128; b < 256; b++ {
+		if windows1252(byte(b)) == r {
+			return Long(int64(b)), nil
+		}
+	}
+	return Long(int64(r)), nil
+}
+
+// windows1252 maps an ANSI code to its character
+func windows1252(b byte) rune {
+	high := []rune("€\u0081‚ƒ„…†‡ˆ‰Š‹Œ\u008dŽ\u008f\u0090‘’“”•–—˜™š›œ\u009džŸ")
+	if b >= 0x80 && b < 0xA0 {
+		return high[b-0x80]
+	}
+	return rune(b)
+}
+
+func properCase(s string) string {
+	r := []rune(strings.ToLower(s))
+	start := true
+	for i, c := range r {
+		if start && unicode.IsLetter(c) {
+			r[i] = unicode.ToUpper(c)
+		}
+		start = !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	}
+	return string(r)
+}
+
+// val reads the number at the start of a string, ignoring spaces
+func val(s string) float64 {
+	s = strings.ReplaceAll(strings.ReplaceAll(s, " ", ""), "\t", "")
+	upper := strings.ToUpper(s)
+	if strings.HasPrefix(upper, "&H") || strings.HasPrefix(upper, "&O") {
+		end := 2
+		for end < len(s) && strings.ContainsRune("0123456789ABCDEFabcdef", rune(s[end])) {
+			end++
+		}
+		n, _ := parseNumber(s[:end])
+		return n
+	}
+	end := 0
+	seenDot, seenExp := false, false
+	for end < len(s) {
+		c := s[end]
+		switch {
+		case c >= '0' && c <= '9':
+		case (c == '-' || c == '+') && (end == 0 || s[end-1] == 'E' || s[end-1] == 'e'):
+		case c == '.' && !seenDot && !seenExp:
+			seenDot = true
+		case (c == 'E' || c == 'e') && !seenExp && end > 0:
+			seenExp = true
+		default:
+			n, _ := strconv.ParseFloat(strings.TrimRight(s[:end], "eE+-"), 64)
+			return n
+		}
+		end++
+	}
+	n, _ := strconv.ParseFloat(strings.TrimRight(s, "eE+-"), 64)
+	return n
+}
+
+// format implements Format with the Jet SQL engine's implementation of the
+// VBA format patterns
+func format(v Value, pattern string) string {
+	if pattern == "" || v.Kind == KindEmpty || v.Kind == KindNull {
+		return v.String()
+	}
+	var sql jetsql.Value
+	switch v.Kind {
+	case KindLong, KindDouble:
+		sql = jetsql.Number(v.Num)
+	case KindBoolean:
+		sql = jetsql.Bool(v.Num != 0)
+	case KindDate:
+		sql = jetsql.Date(serialTime(v.Num))
+	default:
+		sql = jetsql.Text(v.String())
+	}
+	return jetsql.Format(sql, pattern)
+}
+
+func varType(v Value) int64 {
+	switch v.Kind {
+	case KindNull:
+		return 1
+	case KindLong:
+		if v.TypeName() == "Integer" {
+			return 2
+		}
+		return 3
+	case KindDouble:
+		return 5
+	case KindDate:
+		return 7
+	case KindString:
+		return 8
+	case KindObject:
+		return 9
+	case KindError:
+		return 10
+	case KindBoolean:
+		return 11
+	case KindArray:
+		return 8192 + 12
+	}
+	return 0
+}
+
+func bound(upper bool) func(in *Interpreter, args []Arg) (Value, error) {
+	return func(in *Interpreter, args []Arg) (Value, error) {
+		v := args[0].Value
+		if v.Kind != KindArray {
+			return Empty, runtimeError(13, "LBound and UBound need an array")
+		}
+		dim, err := argInt(args, 1, 1)
+		if err != nil {
+			return Empty, err
+		}
+		if dim < 1 || dim > len(v.Arr.Lengths) {
+			return Empty, runtimeError(9, "")
+		}
+		if upper {
+			return Long(int64(v.Arr.Upper(dim - 1))), nil
+		}
+		return Long(int64(v.Arr.Lower[dim-1])), nil
+	}
+}
+
+// weekday returns the VBA day of the week, 1 being the first day
+func weekday(t time.Time, first int) int {
+	if first < 1 || first > 7 {
+		first = 1
+	}
+	return (int(t.Weekday())-(first-1)+7)%7 + 1
+}
+
+func dateAdd(in *Interpreter, args []Arg) (Value, error) {
+	interval, err := argString(args, 0)
+	if err != nil {
+		return Empty, err
+	}
+	n, err := argInt(args, 1, 0)
+	if err != nil {
+		return Empty, err
+	}
+	t, err := argDate(args, 2)
+	if err != nil {
+		return Empty, err
+	}
+	switch strings.ToLower(interval) {
+	case "yyyy":
+		t = addMonths(t, 12*n)
+	case "q":
+		t = addMonths(t, 3*n)
+	case "m":
+		t = addMonths(t, n)
+	case "y", "d", "w":
+		t = t.AddDate(0, 0, n)
+	case "ww":
+		t = t.AddDate(0, 0, 7*n)
+	case "h":
+		t = t.Add(time.Duration(n) * time.Hour)
+	case "n":
+		t = t.Add(time.Duration(n) * time.Minute)
+	case "s":
+		t = t.Add(time.Duration(n) * time.Second)
+	default:
+		return Empty, runtimeError(5, "DateAdd interval "+interval)
+	}
+	return DateValue(t), nil
+}
+
+// addMonths adds months, clamping the day to the end of a shorter month as
+// DateAdd does
+func addMonths(t time.Time, months int) time.Time {
+	first := time.Date(t.Year(), t.Month(), 1, t.Hour(), t.Minute(), t.Second(), 0, time.UTC).AddDate(0, months, 0)
+	last := first.AddDate(0, 1, -1).Day()
+	day := t.Day()
+	if day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+func dateDiff(in *Interpreter, args []Arg) (Value, error) {
+	interval, err := argString(args, 0)
+	if err != nil {
+		return Empty, err
+	}
+	a, err := argDate(args, 1)
+	if err != nil {
+		return Empty, err
+	}
+	b, err := argDate(args, 2)
+	if err != nil {
+		return Empty, err
+	}
+	days := func(t time.Time) int64 { return int64(math.Floor(dateSerial(t))) }
+	switch strings.ToLower(interval) {
+	case "yyyy":
+		return Long(int64(b.Year() - a.Year())), nil
+	case "q":
+		return Long(int64((b.Year()*12+int(b.Month())-1)/3 - (a.Year()*12+int(a.Month())-1)/3)), nil
+	case "m":
+		return Long(int64((b.Year()-a.Year())*12 + int(b.Month()) - int(a.Month()))), nil
+	case "y", "d":
+		return Long(days(b) - days(a)), nil
+	case "w":
+		return Long((days(b) - days(a)) / 7), nil
+	case "ww":
+		// Counts the Sundays crossed
+		return Long((days(b)-int64(weekday(b, 1)-1))/7 - (days(a)-int64(weekday(a, 1)-1))/7), nil
+	case "h":
+		return Long(int64(math.Floor(b.Sub(oleEpoch).Hours()) - math.Floor(a.Sub(oleEpoch).Hours()))), nil
+	case "n":
+		return Long(int64(math.Floor(b.Sub(oleEpoch).Minutes()) - math.Floor(a.Sub(oleEpoch).Minutes()))), nil
+	case "s":
+		return Long(int64(b.Sub(a).Seconds())), nil
+	}
+	return Empty, runtimeError(5, "DateDiff interval "+interval)
+}
+
+// msgBox records the prompt and answers Yes, Retry or OK so the macro continues
+func msgBox(in *Interpreter, args []Arg) (Value, error) {
+	prompt, err := argString(args, 0)
+	if err != nil {
+		return Empty, err
+	}
+	buttons, err := argInt(args, 1, 0)
+	if err != nil {
+		return Empty, err
+	}
+	in.output = append(in.output, "MsgBox: "+prompt)
+	switch buttons & 7 {
+	case 3, 4:
+		in.note("msgbox-yes", "MsgBox questions were answered Yes")
+		return Long(6), nil
+	case 2:
+		in.note("msgbox-ignore", "MsgBox Abort/Retry/Ignore prompts were answered Ignore")
+		return Long(5), nil
+	case 5:
+		in.note("msgbox-retry", "MsgBox Retry/Cancel prompts were answered Retry")
+		return Long(4), nil
+	}
+	return Long(1), nil
+}
+
+// inputBox answers with the next configured input, or the default value
+func inputBox(in *Interpreter, args []Arg) (Value, error) {
+	prompt, err := argString(args, 0)
+	if err != nil {
+		return Empty, err
+	}
+	answer := ""
+	if in.inputs < len(in.opts.Inputs) {
+		answer = in.opts.Inputs[in.inputs]
+		in.inputs++
+	} else {
+		if v, ok := namedArg(args, 2, "Default"); ok {
+			answer = v.String()
+		}
+		in.note("inputbox", "InputBox had no configured answer and returned its default")
+	}
+	in.output = append(in.output, fmt.Sprintf("InputBox: %s -> %q", prompt, answer))
+	return Str(answer), nil
+}
+
+// constants are the VBA and Excel enumeration values macros commonly use
+var constants = map[string]Value{
+	"vbcrlf": Str("\r\n"), "vbnewline": Str("\r\n"), "vbcr": Str("\r"), "vblf": Str("\n"), "vbtab": Str("\t"),
+	"vbnullstring": Str(""), "vbnullchar": Str("\x00"), "vbback": Str("\b"), "vbformfeed": Str("\f"),
+	"vbverticaltab": Str("\v"),
+	"vbtrue":        Long(-1), "vbfalse": Long(0), "vbusedefault": Long(-2),
+
+	"vbokonly": Long(0), "vbokcancel": Long(1), "vbabortretryignore": Long(2), "vbyesnocancel": Long(3),
+	"vbyesno": Long(4), "vbretrycancel": Long(5), "vbcritical": Long(16), "vbquestion": Long(32),
+	"vbexclamation": Long(48), "vbinformation": Long(64), "vbdefaultbutton1": Long(0), "vbdefaultbutton2": Long(256),
+	"vbdefaultbutton3": Long(512), "vbapplicationmodal": Long(0), "vbsystemmodal": Long(4096),
+	"vbok": Long(1), "vbcancel": Long(2), "vbabort": Long(3), "vbretry": Long(4), "vbignore": Long(5),
+	"vbyes": Long(6), "vbno": Long(7),
+
+	"vbbinarycompare": Long(0), "vbtextcompare": Long(1), "vbdatabasecompare": Long(2),
+	"vbuppercase": Long(1), "vblowercase": Long(2), "vbpropercase": Long(3),
+	"vbusesystemdayofweek": Long(0), "vbsunday": Long(1), "vbmonday": Long(2), "vbtuesday": Long(3),
+	"vbwednesday": Long(4), "vbthursday": Long(5), "vbfriday": Long(6), "vbsaturday": Long(7),
+	"vbgeneraldate": Long(0), "vblongdate": Long(1), "vbshortdate": Long(2), "vblongtime": Long(3), "vbshorttime": Long(4),
+
+	"vbempty": Long(0), "vbnull": Long(1), "vbinteger": Long(2), "vblong": Long(3), "vbsingle": Long(4),
+	"vbdouble": Long(5), "vbcurrency": Long(6), "vbdate": Long(7), "vbstring": Long(8), "vbobject": Long(9),
+	"vberror": Long(10), "vbboolean": Long(11), "vbvariant": Long(12), "vbarray": Long(8192),
+	"vbobjecterror": Long(-2147221504),
+
+	"vbblack": Long(0), "vbred": Long(255), "vbgreen": Long(65280), "vbyellow": Long(65535),
+	"vbblue": Long(16711680), "vbmagenta": Long(16711935), "vbcyan": Long(16776960), "vbwhite": Long(16777215),
+
+	"xlup": Long(-4162), "xldown": Long(-4121), "xltoleft": Long(-4159), "xltoright": Long(-4161),
+	"xlshiftup": Long(-4162), "xlshiftdown": Long(-4121), "xlshifttoleft": Long(-4159), "xlshifttoright": Long(-4161),
+	"xlvalues": Long(-4163), "xlformulas": Long(-4123), "xlcomments": Long(-4144), "xlpart": Long(2), "xlwhole": Long(1),
+	"xlbyrows": Long(1), "xlbycolumns": Long(2), "xlnext": Long(1), "xlprevious": Long(2),
+	"xlascending": Long(1), "xldescending": Long(2), "xlyes": Long(1), "xlno": Long(2), "xlguess": Long(0),
+	"xlcalculationmanual": Long(-4135), "xlcalculationautomatic": Long(-4105), "xlcalculationsemiautomatic": Long(2),
+	"xlpasteall": Long(-4104), "xlpastevalues": Long(-4163), "xlpasteformats": Long(-4122), "xlpasteformulas": Long(-4123),
+	"xlcelltypeblanks": Long(4), "xlcelltypeconstants": Long(2), "xlcelltypeformulas": Long(-4123),
+	"xlcelltypelastcell": Long(11), "xlcelltypevisible": Long(12), "xllastcell": Long(11),
+	"xla1": Long(1), "xlr1c1": Long(-4150),
+	"xlcenter": Long(-4108), "xlleft": Long(-4131), "xlright": Long(-4152), "xltop": Long(-4160), "xlbottom": Long(-4107),
+	"xlnone": Long(-4142), "xlautomatic": Long(-4105), "xlsolid": Long(1), "xlcontinuous": Long(1),
+	"xlthin": Long(2), "xlmedium": Long(-4138), "xlthick": Long(4), "xlhairline": Long(1),
+	"xledgeleft": Long(7), "xledgetop": Long(8), "xledgebottom": Long(9), "xledgeright": Long(10),
+	"xlinsidevertical": Long(11), "xlinsidehorizontal": Long(12),
+	"xlworksheet": Long(-4167), "xland": Long(1), "xlor": Long(2), "xlfiltervalues": Long(7),
+	"xlsheetvisible": Long(-1), "xlsheethidden": Long(0), "xlsheetveryhidden": Long(2),
+	"xlopenxmlworkbook": Long(51), "xlopenxmlworkbookmacroenabled": Long(52), "xlcsv": Long(6),
+	"xlerrdiv0": Long(2007), "xlerrna": Long(2042), "xlerrname": Long(2029), "xlerrnull": Long(2000),
+	"xlerrnum": Long(2036), "xlerrref": Long(2023), "xlerrvalue": Long(2015),
+}
@@ -0,0 +1,1058 @@
+package dryrun
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"excel-automation-mcp/backend/service/vba"
+)
+
+// errNoMember is returned by Object.Get and Object.Let for members the
+// object does not have
+var errNoMember = errors.New("no such member")
+
+// Object is a VBA object reference: a range, sheet, collection or other
+// object of the mock object model
+type Object interface {
+	// TypeName returns the class name TypeName reports
+	TypeName() string
+	// Get reads a property or calls a method; name "" is the default member
+	Get(in *Interpreter, name string, args []Arg) (Value, error)
+	// Let assigns a property; name "" is the default member
+	Let(in *Interpreter, name string, args []Arg, v Value) error
+}
+
+// enumerable objects can be the group of a For Each loop
+type enumerable interface {
+	items(in *Interpreter) ([]Value, error)
+}
+
+// Arg is an evaluated argument passed to an object member or builtin
+type Arg struct {
+	Name    string // Named argument
+	Value   Value
+	Missing bool // Omitted: Foo(a, , c)
+}
+
+// nameKey normalizes an identifier for lookup: lower case without a type suffix
+func nameKey(name string) string {
+	return strings.ToLower(strings.TrimRight(name, "%&^!#@$"))
+}
+
+// lookupVar finds a variable in the current procedure or the module
+func (in *Interpreter) lookupVar(name string) *Var {
+	key := nameKey(name)
+	if in.frame != nil {
+		if v, ok := in.frame.locals[key]; ok {
+			return v
+		}
+	}
+	return in.globals[key]
+}
+
+// variable returns the variable named by a simple identifier, creating an
+// implicit one when allowed
+func (in *Interpreter) variable(expr vba.Expr, create bool) *Var {
+	ident, ok := expr.(*vba.Ident)
+	if !ok {
+		in.fail(expr, unsupported("loop or Erase target "+vba.ExprName(expr), "use a simple variable"))
+	}
+	if v := in.lookupVar(ident.Name); v != nil {
+		if v.Const {
+			in.fail(expr, compileError("assignment to constant %s", ident.Name))
+		}
+		return v
+	}
+	if !create {
+		in.fail(expr, compileError("variable not defined: %s", ident.Name))
+	}
+	return in.implicit(ident)
+}
+
+// implicit creates an undeclared variable, which Option Explicit forbids
+func (in *Interpreter) implicit(ident *vba.Ident) *Var {
+	if in.explicit || in.frame == nil {
+		in.fail(ident, compileError("variable not defined: %s", ident.Name))
+	}
+	v := &Var{Value: Empty}
+	in.frame.locals[nameKey(ident.Name)] = v
+	return v
+}
+
+// setVar assigns a value to a variable, converting it to the declared type
+func (in *Interpreter) setVar(node vba.Node, v *Var, value Value) {
+	if value.Kind == KindArray {
+		if v.Value.Kind == KindArray && v.Value.Arr.Fixed {
+			in.fail(node, compileError("cannot assign to a fixed-size array"))
+		}
+		v.Value = ArrayValue(value.Arr.Copy())
+		return
+	}
+	typed, err := coerce(v.Type, value)
+	in.check(node, err)
+	v.Value = typed
+}
+
+// ----------------------------------------------------------------------------
+// Evaluation
+
+// eval evaluates an expression. Object results are returned as references;
+// use scalar to read their default member.
+func (in *Interpreter) eval(expr vba.Expr) Value {
+	switch e := expr.(type) {
+	case *vba.Literal:
+		return in.literal(e)
+	case *vba.Ident:
+		return in.name(e)
+	case *vba.MemberExpr:
+		if e.Bang {
+			return in.member(e, in.object(e), "", []Arg{{Value: Str(e.Name)}})
+		}
+		return in.member(e, in.object(e), e.Name, nil)
+	case *vba.CallExpr:
+		return in.call(e)
+	case *vba.ParenExpr:
+		return in.eval(e.X)
+	case *vba.BinaryExpr:
+		return in.binary(e)
+	case *vba.UnaryExpr:
+		return in.unary(e)
+	case *vba.NewExpr:
+		obj, err := in.newObject(e.Type)
+		in.check(e, err)
+		return ObjectValue(obj)
+	case *vba.TypeOfExpr:
+		v := in.eval(e.X)
+		if v.Kind != KindObject {
+			in.fail(e, runtimeError(424, ""))
+		}
+		if v.Obj == nil {
+			return Bool(false)
+		}
+		typeName := e.Type
+		if i := strings.LastIndex(typeName, "."); i >= 0 {
+			typeName = typeName[i+1:]
+		}
+		return Bool(strings.EqualFold(typeName, "Object") || strings.EqualFold(typeName, v.Obj.TypeName()))
+	case *vba.BadExpr:
+		in.fail(e, compileError("expression could not be parsed"))
+	case nil:
+		return Empty
+	}
+	in.fail(expr, unsupported("expression", ""))
+	return Empty
+}
+
+func (in *Interpreter) literal(e *vba.Literal) Value {
+	switch e.Kind {
+	case vba.LitInteger:
+		n, ok := parseNumber(strings.TrimRight(e.Value, "%&^"))
+		if !ok {
+			in.fail(e, compileError("invalid number %s", e.Raw))
+		}
+		return Long(int64(n))
+	case vba.LitFloat:
+		n, ok := parseNumber(strings.TrimRight(e.Value, "!#@"))
+		if !ok {
+			in.fail(e, compileError("invalid number %s", e.Raw))
+		}
+		return Double(n)
+	case vba.LitString:
+		return Str(e.Value)
+	case vba.LitDate:
+		t, ok := parseDate(e.Value)
+		if !ok {
+			in.fail(e, compileError("invalid date literal %s", e.Raw))
+		}
+		return DateValue(t)
+	case vba.LitBoolean:
+		return Bool(strings.EqualFold(e.Raw, "True"))
+	case vba.LitNothing:
+		return Nothing
+	case vba.LitNull:
+		return Null
+	}
+	return Empty
+}
+
+// name resolves a bare identifier: a variable, a function called without
+// arguments, a constant or a global object
+func (in *Interpreter) name(e *vba.Ident) Value {
+	if v := in.lookupVar(e.Name); v != nil {
+		return v.Value
+	}
+	key := nameKey(e.Name)
+	if proc, ok := in.procs[key]; ok {
+		return in.callProc(proc, nil, e)
+	}
+	if v, ok := constants[key]; ok {
+		return v
+	}
+	if v, ok := in.global(e, key, nil); ok {
+		return v
+	}
+	if fn, ok := builtins[key]; ok {
+		return in.builtin(e, key, fn, nil)
+	}
+	if in.declares[key] {
+		in.fail(e, unsupported("Declared DLL function "+e.Name, ""))
+	}
+	// Sheets can be referred to by code name, which defaults to the sheet name
+	if sheet := in.book.Sheet(e.Name); sheet != nil {
+		return ObjectValue(sheet)
+	}
+	return in.implicit(e).Value
+}
+
+// global resolves the members of Application that VBA exposes without a qualifier
+func (in *Interpreter) global(node vba.Node, key string, args []Arg) (Value, bool) {
+	switch key {
+	case "application":
+		if args != nil {
+			return Empty, false
+		}
+		return ObjectValue(in.app), true
+	case "err":
+		if args != nil {
+			return Empty, false
+		}
+		return ObjectValue(in.errObj), true
+	case "debug":
+		if args != nil {
+			return Empty, false
+		}
+		return ObjectValue(debugObject{}), true
+	case "activesheet", "activeworkbook", "thisworkbook", "worksheets", "sheets", "workbooks",
+		"range", "cells", "rows", "columns", "selection", "activecell", "worksheetfunction",
+		"intersect", "union":
+		v, err := in.app.Get(in, key, args)
+		in.check(node, err)
+		return v, true
+	}
+	return Empty, false
+}
+
+// object evaluates the object a member expression is applied to, which is
+// the With object for .Name
+func (in *Interpreter) object(e *vba.MemberExpr) Value {
+	if e.X != nil {
+		return in.eval(e.X)
+	}
+	if len(in.frame.with) == 0 {
+		in.fail(e, compileError("invalid or unqualified reference .%s", e.Name))
+	}
+	return in.frame.with[len(in.frame.with)-1]
+}
+
+// member reads a property or calls a method of an object
+func (in *Interpreter) member(node vba.Node, obj Value, name string, args []Arg) Value {
+	target := in.requireObject(node, obj)
+	v, err := target.Get(in, name, args)
+	if err == errNoMember {
+		in.fail(node, runtimeError(438, target.TypeName()+"."+name))
+	}
+	in.check(node, err)
+	return v
+}
+
+// requireObject returns the object held by v or fails with the VBA error for
+// using a non-object
+func (in *Interpreter) requireObject(node vba.Node, v Value) Object {
+	if v.Kind != KindObject {
+		in.fail(node, runtimeError(424, ""))
+	}
+	if v.Obj == nil {
+		in.fail(node, runtimeError(91, ""))
+	}
+	return v.Obj
+}
+
+// args evaluates call arguments for objects and builtins
+func (in *Interpreter) args(list []*vba.Arg) []Arg {
+	args := make([]Arg, 0, len(list))
+	for _, arg := range list {
+		if arg.Value == nil {
+			args = append(args, Arg{Name: arg.Name, Missing: true})
+			continue
+		}
+		args = append(args, Arg{Name: arg.Name, Value: in.eval(arg.Value)})
+	}
+	return args
+}
+
+// call evaluates Fun(args), which is a procedure call, an array index or a
+// call to an object's member
+func (in *Interpreter) call(e *vba.CallExpr) Value {
+	switch fun := e.Fun.(type) {
+	case *vba.Ident:
+		return in.callName(e, fun, e.Args)
+	case *vba.MemberExpr:
+		obj := in.object(fun)
+		if fun.Bang {
+			obj = in.member(fun, obj, "", []Arg{{Value: Str(fun.Name)}})
+			return in.index(e, obj, in.args(e.Args))
+		}
+		return in.member(e, obj, fun.Name, in.args(e.Args))
+	}
+	return in.index(e, in.eval(e.Fun), in.args(e.Args))
+}
+
+// callName evaluates name(args) for a bare name
+func (in *Interpreter) callName(node vba.Node, ident *vba.Ident, list []*vba.Arg) Value {
+	if v := in.lookupVar(ident.Name); v != nil {
+		return in.index(node, v.Value, in.args(list))
+	}
+	key := nameKey(ident.Name)
+	if proc, ok := in.procs[key]; ok {
+		return in.callProc(proc, list, node)
+	}
+	args := in.args(list)
+	if v, ok := in.global(node, key, args); ok {
+		return v
+	}
+	if fn, ok := builtins[key]; ok {
+		return in.builtin(node, key, fn, args)
+	}
+	if in.declares[key] {
+		in.fail(node, unsupported("Declared DLL function "+ident.Name, ""))
+	}
+	if unsupportedFunctions[key] {
+		in.fail(node, unsupported(ident.Name, "it touches files, the system or other applications"))
+	}
+	in.fail(node, compileError("Sub or Function not defined: %s", ident.Name))
+	return Empty
+}
+
+// index applies an argument list to a value: array indexing or the object's
+// default member
+func (in *Interpreter) index(node vba.Node, v Value, args []Arg) Value {
+	switch {
+	case v.Kind == KindArray:
+		if len(args) == 0 {
+			return v
+		}
+		arr, offset := in.element(node, v.Arr, args)
+		return arr.Data[offset]
+	case v.Kind == KindObject:
+		if len(args) == 0 {
+			return v
+		}
+		return in.member(node, v, "", args)
+	case len(args) == 0:
+		return v
+	}
+	in.fail(node, runtimeError(13, "value is not an array"))
+	return Empty
+}
+
+// element locates an array element
+func (in *Interpreter) element(node vba.Node, arr *Array, args []Arg) (*Array, int) {
+	indexes := make([]int, len(args))
+	for i, arg := range args {
+		if arg.Missing {
+			in.fail(node, runtimeError(9, ""))
+		}
+		indexes[i] = in.integer(node, in.scalar(node, arg.Value))
+	}
+	offset := arr.offset(indexes)
+	if offset < 0 {
+		in.fail(node, runtimeError(9, ""))
+	}
+	return arr, offset
+}
+
+// scalar reads the default member of an object, as VBA does when an object
+// is used where a value is expected
+func (in *Interpreter) scalar(node vba.Node, v Value) Value {
+	if v.Kind != KindObject {
+		return v
+	}
+	if v.Obj == nil {
+		in.fail(node, runtimeError(91, ""))
+	}
+	result, err := v.Obj.Get(in, "", nil)
+	if err == errNoMember {
+		in.fail(node, runtimeError(438, v.Obj.TypeName()+" has no default value"))
+	}
+	in.check(node, err)
+	return result
+}
+
+// ----------------------------------------------------------------------------
+// Statements that evaluate expressions
+
+func (in *Interpreter) assign(s *vba.AssignStmt) {
+	value := in.eval(s.Value)
+	if strings.EqualFold(s.Keyword, "Set") {
+		if value.Kind != KindObject {
+			in.fail(s.Value, runtimeError(424, ""))
+		}
+		in.assignTo(s.Target, value, true)
+		return
+	}
+	if value.Kind == KindObject {
+		value = in.scalar(s.Value, value)
+	}
+	in.assignTo(s.Target, value, false)
+}
+
+// assignTo stores a value in a variable, array element or object property
+func (in *Interpreter) assignTo(target vba.Expr, value Value, set bool) {
+	switch t := target.(type) {
+	case *vba.Ident:
+		v := in.lookupVar(t.Name)
+		if v == nil {
+			if !set {
+				if sheet := in.book.Sheet(t.Name); sheet != nil {
+					in.fail(t, compileError("cannot assign to sheet %s", t.Name))
+				}
+			}
+			v = in.implicit(t)
+		}
+		if v.Const {
+			in.fail(t, compileError("assignment to constant %s", t.Name))
+		}
+		if set {
+			if v.Type != "" && v.Type != "variant" && !isObjectType(v.Type) {
+				in.fail(t, runtimeError(13, ""))
+			}
+			v.Value = value
+			return
+		}
+		if v.Value.Kind == KindObject && v.Value.Obj != nil {
+			in.letDefault(t, v.Value, nil, value)
+			return
+		}
+		in.setVar(t, v, value)
+
+	case *vba.MemberExpr:
+		obj := in.requireObject(t, in.object(t))
+		if t.Bang {
+			in.letMember(t, obj, "", []Arg{{Value: Str(t.Name)}}, value)
+			return
+		}
+		in.letMember(t, obj, t.Name, nil, value)
+
+	case *vba.CallExpr:
+		args := in.args(t.Args)
+		switch fun := t.Fun.(type) {
+		case *vba.Ident:
+			if v := in.lookupVar(fun.Name); v != nil {
+				switch {
+				case v.Value.Kind == KindArray:
+					arr, offset := in.element(t, v.Value.Arr, args)
+					if !set && arr.Type != "" && arr.Type != "variant" {
+						typed, err := coerce(arr.Type, value)
+						in.check(t, err)
+						value = typed
+					}
+					arr.Data[offset] = value
+				case v.Value.Kind == KindObject:
+					in.letMember(t, in.requireObject(t, v.Value), "", args, value)
+				default:
+					in.fail(t, runtimeError(13, fun.Name+" is not an array"))
+				}
+				return
+			}
+			if _, ok := in.procs[nameKey(fun.Name)]; ok {
+				in.fail(t, compileError("function call on left-hand side of assignment"))
+			}
+			obj, ok := in.global(t, nameKey(fun.Name), args)
+			if !ok {
+				in.fail(t, compileError("Sub or Function not defined: %s", fun.Name))
+			}
+			in.letDefault(t, obj, nil, value)
+		case *vba.MemberExpr:
+			obj := in.requireObject(fun, in.object(fun))
+			in.letMember(t, obj, fun.Name, args, value)
+		default:
+			in.letDefault(t, in.eval(t.Fun), args, value)
+		}
+
+	default:
+		in.fail(target, compileError("invalid assignment target"))
+	}
+}
+
+// letMember assigns obj.name(args) = value. Members that cannot be assigned
+// but return an object, such as ws.Cells(1, 1), assign that object's default
+// member instead.
+func (in *Interpreter) letMember(node vba.Node, obj Object, name string, args []Arg, value Value) {
+	err := obj.Let(in, name, args, value)
+	if err != errNoMember {
+		in.check(node, err)
+		return
+	}
+	result, err := obj.Get(in, name, args)
+	if err == errNoMember {
+		in.fail(node, runtimeError(438, obj.TypeName()+"."+name))
+	}
+	in.check(node, err)
+	if result.Kind != KindObject || result.Obj == nil {
+		in.fail(node, runtimeError(424, "cannot assign to "+obj.TypeName()+"."+name))
+	}
+	in.letDefault(node, result, nil, value)
+}
+
+// letDefault assigns the default member of an object
+func (in *Interpreter) letDefault(node vba.Node, obj Value, args []Arg, value Value) {
+	target := in.requireObject(node, obj)
+	err := target.Let(in, "", args, value)
+	if err == errNoMember {
+		in.fail(node, runtimeError(438, target.TypeName()+" has no default value"))
+	}
+	in.check(node, err)
+}
+
+// callStmt runs a procedure or method called as a statement
+func (in *Interpreter) callStmt(s *vba.CallStmt) {
+	switch target := s.Target.(type) {
+	case *vba.Ident:
+		if proc, ok := in.procs[nameKey(target.Name)]; ok {
+			in.callProc(proc, s.Args, s)
+			return
+		}
+		if v := in.lookupVar(target.Name); v != nil && v.Value.Kind == KindObject && len(s.Args) > 0 {
+			in.index(s, v.Value, in.args(s.Args))
+			return
+		}
+		in.callName(s, target, s.Args)
+	case *vba.MemberExpr:
+		if !target.Bang && strings.EqualFold(target.Name, "Print") {
+			if root, ok := target.X.(*vba.Ident); ok && strings.EqualFold(root.Name, "Debug") {
+				in.debugPrint(s.Args)
+				return
+			}
+		}
+		obj := in.object(target)
+		if target.Bang {
+			obj = in.member(target, obj, "", []Arg{{Value: Str(target.Name)}})
+			in.index(s, obj, in.args(s.Args))
+			return
+		}
+		in.member(s, obj, target.Name, in.args(s.Args))
+	case *vba.CallExpr:
+		v := in.call(target)
+		if len(s.Args) > 0 {
+			in.index(s, v, in.args(s.Args))
+		}
+	default:
+		in.eval(s.Target)
+	}
+}
+
+// debugPrint writes a Debug.Print line. A semicolon joins items directly
+// and a comma moves to the next 14-character print zone.
+func (in *Interpreter) debugPrint(args []*vba.Arg) {
+	var line strings.Builder
+	for _, arg := range args {
+		if arg.Value != nil {
+			v := in.scalar(arg, in.eval(arg.Value))
+			text := v.String()
+			if v.IsNumeric() && v.Kind != KindBoolean && v.Kind != KindDate {
+				// Numbers print with a sign position and a trailing space
+				if v.Num >= 0 {
+					text = " " + text
+				}
+				text += " "
+			}
+			line.WriteString(text)
+		}
+		if in.separator(arg) == ',' {
+			line.WriteString(strings.Repeat(" ", 14-line.Len()%14))
+		}
+	}
+	in.output = append(in.output, strings.TrimRight(line.String(), " "))
+}
+
+// separator returns the character following an argument in the source
+func (in *Interpreter) separator(arg *vba.Arg) byte {
+	for i := arg.Stop.Offset; i < len(in.src); i++ {
+		switch in.src[i] {
+		case ' ', '\t':
+			continue
+		case ';', ',':
+			return in.src[i]
+		}
+		break
+	}
+	return 0
+}
+
+// ----------------------------------------------------------------------------
+// Operators
+
+func (in *Interpreter) binary(e *vba.BinaryExpr) Value {
+	if e.Op == "Is" {
+		x, y := in.eval(e.X), in.eval(e.Y)
+		if x.Kind != KindObject || y.Kind != KindObject {
+			in.fail(e, runtimeError(424, ""))
+		}
+		return Bool(sameObject(x.Obj, y.Obj))
+	}
+
+	x := in.scalar(e.X, in.eval(e.X))
+	y := in.scalar(e.Y, in.eval(e.Y))
+	switch e.Op {
+	case "=", "<>", "<", ">", "<=", ">=":
+		return in.compare(e, e.Op, x, y)
+	case "Like":
+		if x.Kind == KindNull || y.Kind == KindNull {
+			return Null
+		}
+		return Bool(like(x.String(), y.String(), in.compareText))
+	case "&":
+		if x.Kind == KindNull && y.Kind == KindNull {
+			return Null
+		}
+		return Str(in.text(e.X, x) + in.text(e.Y, y))
+	case "And", "Or", "Xor", "Eqv", "Imp":
+		return in.logical(e, e.Op, x, y)
+	}
+	return in.arithmetic(e, e.Op, x, y)
+}
+
+// sameObject compares object references
+func sameObject(a, b Object) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if ra, ok := a.(*Range); ok {
+		rb, ok := b.(*Range)
+		return ok && ra.sheet == rb.sheet && ra.area == rb.area
+	}
+	return a == b
+}
+
+// text converts a value to a string for concatenation
+func (in *Interpreter) text(node vba.Node, v Value) string {
+	switch v.Kind {
+	case KindNull:
+		return ""
+	case KindArray, KindObject, KindError:
+		in.fail(node, runtimeError(13, ""))
+	}
+	return v.String()
+}
+
+func (in *Interpreter) arithmetic(node vba.Node, op string, x, y Value) Value {
+	if x.Kind == KindNull || y.Kind == KindNull {
+		return Null
+	}
+	if op == "+" && x.Kind == KindString && y.Kind == KindString {
+		return Str(x.Str + y.Str)
+	}
+
+	a, err := toNumber(x)
+	in.check(node, err)
+	b, err := toNumber(y)
+	in.check(node, err)
+	integral := isIntegral(x) && isIntegral(y)
+
+	var n float64
+	switch op {
+	case "+":
+		n = a + b
+	case "-":
+		n = a - b
+	case "*":
+		n = a * b
+	case "/":
+		if b == 0 {
+			in.fail(node, runtimeError(11, ""))
+		}
+		return Double(a / b)
+	case "\\":
+		ia, ib := roundEven(a), roundEven(b)
+		if ib == 0 {
+			in.fail(node, runtimeError(11, ""))
+		}
+		return Long(int64(ia / ib))
+	case "Mod":
+		ia, ib := roundEven(a), roundEven(b)
+		if ib == 0 {
+			in.fail(node, runtimeError(11, ""))
+		}
+		return Long(int64(math.Mod(ia, ib)))
+	case "^":
+		n = math.Pow(a, b)
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			in.fail(node, runtimeError(5, ""))
+		}
+		return Double(n)
+	default:
+		in.fail(node, unsupported("operator "+op, ""))
+	}
+
+	if math.IsInf(n, 0) || math.IsNaN(n) {
+		in.fail(node, runtimeError(6, ""))
+	}
+	// Date arithmetic keeps a date unless two dates are subtracted
+	switch {
+	case x.Kind == KindDate && y.Kind == KindDate && op == "-":
+		return Double(n)
+	case (x.Kind == KindDate || y.Kind == KindDate) && (op == "+" || op == "-"):
+		return Value{Kind: KindDate, Num: n}
+	case integral && math.Abs(n) < 1<<53:
+		return Long(int64(n))
+	}
+	return Double(n)
+}
+
+// compare evaluates a comparison with Variant rules: numbers compare
+// numerically, strings as text, and a string compared with a number is
+// converted when it looks like one
+func (in *Interpreter) compare(node vba.Node, op string, x, y Value) Value {
+	if x.Kind == KindNull || y.Kind == KindNull {
+		return Null
+	}
+	for _, v := range []Value{x, y} {
+		if v.Kind == KindArray || v.Kind == KindObject || v.Kind == KindError {
+			in.fail(node, runtimeError(13, ""))
+		}
+	}
+
+	var order int
+	numeric := (x.IsNumeric() || x.Kind == KindEmpty) && (y.IsNumeric() || y.Kind == KindEmpty)
+	if !numeric && (x.Kind == KindString) != (y.Kind == KindString) {
+		// One side is a string: compare as numbers when it holds a number
+		_, xok := parseNumber(x.String())
+		_, yok := parseNumber(y.String())
+		numeric = (x.Kind != KindString || xok) && (y.Kind != KindString || yok) && x.Kind != KindEmpty && y.Kind != KindEmpty
+	}
+	if numeric {
+		a, err := toNumber(x)
+		in.check(node, err)
+		b, err := toNumber(y)
+		in.check(node, err)
+		switch {
+		case a < b:
+			order = -1
+		case a > b:
+			order = 1
+		}
+	} else {
+		a, b := x.String(), y.String()
+		if in.compareText {
+			a, b = strings.ToLower(a), strings.ToLower(b)
+		}
+		order = strings.Compare(a, b)
+	}
+
+	switch op {
+	case "=":
+		return Bool(order == 0)
+	case "<>":
+		return Bool(order != 0)
+	case "<":
+		return Bool(order < 0)
+	case ">":
+		return Bool(order > 0)
+	case "<=":
+		return Bool(order <= 0)
+	}
+	return Bool(order >= 0)
+}
+
+// logical evaluates And, Or, Xor, Eqv and Imp, which are bitwise on numbers
+// and logical on Booleans
+func (in *Interpreter) logical(node vba.Node, op string, x, y Value) Value {
+	if x.Kind == KindNull || y.Kind == KindNull {
+		// Null propagates unless the other operand decides the result
+		other := x
+		if x.Kind == KindNull {
+			other = y
+		}
+		if other.Kind != KindNull {
+			n, err := toNumber(other)
+			in.check(node, err)
+			switch {
+			case op == "And" && n == 0:
+				return Bool(false)
+			case op == "Or" && n == -1:
+				return Bool(true)
+			}
+		}
+		return Null
+	}
+
+	a, err := toNumber(x)
+	in.check(node, err)
+	b, err := toNumber(y)
+	in.check(node, err)
+	ia, ib := int64(roundEven(a)), int64(roundEven(b))
+	var n int64
+	switch op {
+	case "And":
+		n = ia & ib
+	case "Or":
+		n = ia | ib
+	case "Xor":
+		n = ia ^ ib
+	case "Eqv":
+		n = ^(ia ^ ib)
+	case "Imp":
+		n = ^ia | ib
+	}
+	if x.Kind == KindBoolean && y.Kind == KindBoolean {
+		return Bool(n != 0)
+	}
+	return Long(n)
+}
+
+func (in *Interpreter) unary(e *vba.UnaryExpr) Value {
+	if e.Op == "AddressOf" {
+		in.fail(e, unsupported("AddressOf", ""))
+	}
+	x := in.scalar(e.X, in.eval(e.X))
+	if x.Kind == KindNull {
+		return Null
+	}
+	n, err := toNumber(x)
+	in.check(e, err)
+	switch e.Op {
+	case "Not":
+		if x.Kind == KindBoolean {
+			return Bool(n == 0)
+		}
+		return Long(^int64(roundEven(n)))
+	case "-":
+		if x.Kind == KindDate {
+			return Value{Kind: KindDate, Num: -n}
+		}
+		if isIntegral(x) {
+			return Long(-int64(n))
+		}
+		return Double(-n)
+	}
+	if x.Kind == KindString {
+		return Double(n)
+	}
+	return x
+}
+
+// like implements the Like operator: ?, *, #, [charlist] and [!charlist]
+func like(s, pattern string, ignoreCase bool) bool {
+	if ignoreCase {
+		s, pattern = strings.ToLower(s), strings.ToLower(pattern)
+	}
+	return likeRunes([]rune(s), []rune(pattern))
+}
+
+func likeRunes(s, p []rune) bool {
+	for len(p) > 0 {
+		switch p[0] {
+		case '*':
+			for len(p) > 0 && p[0] == '*' {
+				p = p[1:]
+			}
+			if len(p) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if likeRunes(s[i:], p) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '#':
+			if len(s) == 0 || s[0] < '0' || s[0] > '9' {
+				return false
+			}
+		case '[':
+			end := 1
+			for end < len(p) && p[end] != ']' {
+				end++
+			}
+			if end == len(p) || len(s) == 0 {
+				return false
+			}
+			set := p[1:end]
+			negate := len(set) > 0 && set[0] == '!'
+			if negate {
+				set = set[1:]
+			}
+			matched := false
+			for i := 0; i < len(set); i++ {
+				if i+2 < len(set) && set[i+1] == '-' {
+					if s[0] >= set[i] && s[0] <= set[i+2] {
+						matched = true
+					}
+					i += 2
+					continue
+				}
+				if s[0] == set[i] {
+					matched = true
+				}
+			}
+			if matched == negate {
+				return false
+			}
+			s, p = s[1:], p[end+1:]
+			continue
+		default:
+			if len(s) == 0 || s[0] != p[0] {
+				return false
+			}
+		}
+		s, p = s[1:], p[1:]
+	}
+	return len(s) == 0
+}
+
+// ----------------------------------------------------------------------------
+// Conversions
+
+// toNumber converts a scalar to a number the way VBA's implicit conversion does
+func toNumber(v Value) (float64, error) {
+	switch v.Kind {
+	case KindEmpty:
+		return 0, nil
+	case KindBoolean, KindLong, KindDouble, KindDate:
+		return v.Num, nil
+	case KindString:
+		if n, ok := parseNumber(v.Str); ok {
+			return n, nil
+		}
+		if strings.EqualFold(strings.TrimSpace(v.Str), "True") {
+			return -1, nil
+		}
+		if strings.EqualFold(strings.TrimSpace(v.Str), "False") {
+			return 0, nil
+		}
+		return 0, runtimeError(13, strconv.Quote(v.Str)+" is not a number")
+	case KindNull:
+		return 0, runtimeError(94, "")
+	}
+	return 0, runtimeError(13, "")
+}
+
+// isIntegral reports whether arithmetic on the value stays integral
+func isIntegral(v Value) bool {
+	return v.Kind == KindLong || v.Kind == KindBoolean || v.Kind == KindEmpty
+}
+
+// roundEven rounds half to even, as VBA does when converting to integers
+func roundEven(n float64) float64 {
+	return math.RoundToEven(n)
+}
+
+// truthy converts a condition to a Boolean
+func (in *Interpreter) truthy(node vba.Node, v Value) bool {
+	v = in.scalar(node, v)
+	if v.Kind == KindNull {
+		in.fail(node, runtimeError(94, ""))
+	}
+	n, err := toNumber(v)
+	in.check(node, err)
+	return n != 0
+}
+
+// integer converts a value to an index or count
+func (in *Interpreter) integer(node vba.Node, v Value) int {
+	v = in.scalar(node, v)
+	n, err := toNumber(v)
+	in.check(node, err)
+	n = roundEven(n)
+	if math.Abs(n) > math.MaxInt32 {
+		in.fail(node, runtimeError(6, ""))
+	}
+	return int(n)
+}
+
+// primitiveTypes are the declared types that hold values rather than objects
+var primitiveTypes = map[string]bool{
+	"": true, "variant": true, "byte": true, "integer": true, "long": true, "longlong": true, "longptr": true,
+	"single": true, "double": true, "currency": true, "decimal": true, "string": true, "boolean": true, "date": true,
+}
+
+// isObjectType reports whether a declared type holds an object reference
+func isObjectType(typeName string) bool {
+	return !primitiveTypes[typeName]
+}
+
+// defaultValue returns the initial value of a variable of the declared type
+func defaultValue(typeName string) Value {
+	switch strings.ToLower(typeName) {
+	case "", "variant":
+		return Empty
+	case "byte", "integer", "long", "longlong", "longptr":
+		return Long(0)
+	case "single", "double", "currency", "decimal":
+		return Double(0)
+	case "string":
+		return Str("")
+	case "boolean":
+		return Bool(false)
+	case "date":
+		return Value{Kind: KindDate}
+	}
+	return Nothing
+}
+
+// integerLimits are the ranges of the integer types
+var integerLimits = map[string][2]float64{
+	"byte":     {0, 255},
+	"integer":  {math.MinInt16, math.MaxInt16},
+	"long":     {math.MinInt32, math.MaxInt32},
+	"longptr":  {math.MinInt64, math.MaxInt64},
+	"longlong": {math.MinInt64, math.MaxInt64},
+}
+
+// coerce converts a value to a declared type as assignment does
+func coerce(typeName string, v Value) (Value, error) {
+	typeName = strings.ToLower(typeName)
+	if typeName == "" || typeName == "variant" {
+		return v, nil
+	}
+	if isObjectType(typeName) {
+		if v.Kind != KindObject {
+			return v, runtimeError(13, "object expected")
+		}
+		return v, nil
+	}
+	if v.Kind == KindArray || v.Kind == KindObject || v.Kind == KindError {
+		return v, runtimeError(13, "")
+	}
+	if v.Kind == KindNull {
+		return v, runtimeError(94, "")
+	}
+
+	switch typeName {
+	case "string":
+		return Str(v.String()), nil
+	case "boolean":
+		n, err := toNumber(v)
+		return Bool(n != 0), err
+	case "date":
+		if v.Kind == KindString {
+			if t, ok := parseDate(v.Str); ok {
+				return DateValue(t), nil
+			}
+		}
+		n, err := toNumber(v)
+		return Value{Kind: KindDate, Num: n}, err
+	}
+
+	n, err := toNumber(v)
+	if err != nil {
+		return v, err
+	}
+	if limits, ok := integerLimits[typeName]; ok {
+		n = roundEven(n)
+		if n < limits[0] || n > limits[1] {
+			return v, runtimeError(6, "")
+		}
+		return Long(int64(n)), nil
+	}
+	if typeName == "currency" {
+		n = math.RoundToEven(n*10000) / 10000
+	}
+	return Double(n), nil
+}
@@ -0,0 +1,1023 @@
+package dryrun
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"excel-automation-mcp/backend/service/mcp"
+	"excel-automation-mcp/backend/service/vba"
+)
+
+const (
+	defaultMaxSteps = 1000000
+	defaultTimeout  = 5 * time.Second
+	maxCallDepth    = 200
+)
+
+// ErrorKind classifies why a run stopped
+type ErrorKind string
+
+const (
+	ErrRuntime     ErrorKind = "runtime"     // VBA run-time error, which On Error can handle
+	ErrCompile     ErrorKind = "compile"     // Code VBA would reject before running it
+	ErrUnsupported ErrorKind = "unsupported" // Construct outside the subset the interpreter runs
+	ErrLimit       ErrorKind = "limit"       // Step limit or timeout reached
+	ErrInternal    ErrorKind = "internal"    // Bug in the interpreter
+)
+
+// RunError describes why a run stopped before the end of the procedure
+type RunError struct {
+	Kind      ErrorKind `json:"kind"`
+	Number    int       `json:"number,omitempty"` // VBA error number of run-time errors
+	Message   string    `json:"message"`
+	Construct string    `json:"construct,omitempty"` // The unsupported statement, function or member
+	Procedure string    `json:"procedure,omitempty"`
+	Line      int       `json:"line,omitempty"`
+	Column    int       `json:"column,omitempty"`
+}
+
+// Error implements the error interface
+func (e *RunError) Error() string {
+	prefix := ""
+	if e.Line > 0 {
+		prefix = fmt.Sprintf("line %d: ", e.Line)
+	}
+	switch e.Kind {
+	case ErrRuntime:
+		return fmt.Sprintf("%srun-time error %d: %s", prefix, e.Number, e.Message)
+	case ErrUnsupported:
+		return fmt.Sprintf("%sunsupported: %s", prefix, e.Message)
+	}
+	return fmt.Sprintf("%s%s error: %s", prefix, e.Kind, e.Message)
+}
+
+// runtimeMessages are the standard messages of the VBA run-time errors the interpreter raises
+var runtimeMessages = map[int]string{
+	5:    "Invalid procedure call or argument",
+	6:    "Overflow",
+	9:    "Subscript out of range",
+	10:   "This array is fixed or temporarily locked",
+	11:   "Division by zero",
+	13:   "Type mismatch",
+	20:   "Resume without error",
+	28:   "Out of stack space",
+	91:   "Object variable or With block variable not set",
+	92:   "For loop not initialized",
+	94:   "Invalid use of Null",
+	424:  "Object required",
+	438:  "Object doesn't support this property or method",
+	449:  "Argument not optional",
+	450:  "Wrong number of arguments or invalid property assignment",
+	457:  "This key is already associated with an element of this collection",
+	1004: "Application-defined or object-defined error",
+}
+
+func runtimeError(number int, detail string) *RunError {
+	message := runtimeMessages[number]
+	if message == "" {
+		message = "Application-defined or object-defined error"
+	}
+	if detail != "" {
+		message += ": " + detail
+	}
+	return &RunError{Kind: ErrRuntime, Number: number, Message: message}
+}
+
+func unsupported(construct, detail string) *RunError {
+	message := construct + " is not supported by the dry run"
+	if detail != "" {
+		message += "; " + detail
+	}
+	return &RunError{Kind: ErrUnsupported, Construct: construct, Message: message}
+}
+
+func compileError(format string, args ...interface{}) *RunError {
+	return &RunError{Kind: ErrCompile, Message: fmt.Sprintf(format, args...)}
+}
+
+// Options control a dry run
+type Options struct {
+	Entry    string        `json:"entry,omitempty"`    // Procedure to run; defaults to the first Sub without parameters
+	MaxSteps int           `json:"maxSteps,omitempty"` // Statements executed before the run is stopped
+	Timeout  time.Duration `json:"timeout,omitempty"`  // Wall-clock limit for the run
+	Now      time.Time     `json:"now,omitempty"`      // Clock for Now, Date and Time; defaults to the current time
+	Inputs   []string      `json:"inputs,omitempty"`   // Answers to InputBox calls, in order
+}
+
+// Result is the outcome of a dry run
+type Result struct {
+	Procedure string        `json:"procedure"`
+	Completed bool          `json:"completed"`       // The procedure ran to the end
+	Error     *RunError     `json:"error,omitempty"` // Why the run stopped early
+	Changes   []CellChange  `json:"changes"`         // Cells the macro changed, also when it stopped early
+	Sheets    []SheetChange `json:"sheets"`          // Sheets the macro added, deleted or renamed
+	Output    []string      `json:"output"`          // Debug.Print lines and MsgBox/InputBox prompts
+	Notes     []string      `json:"notes"`           // Effects that were skipped or only approximated
+	Steps     int           `json:"steps"`           // Statements executed
+}
+
+// Run parses VBA code and runs a procedure against a workbook built from
+// the data ranges, returning the cells it changed
+func Run(code string, ranges []mcp.DataRange, opts Options) *Result {
+	book := WorkbookFromRanges(ranges...)
+	var notes []string
+	for _, structure := range ranges {
+		if structure.DataRows > len(structure.SampleData) {
+			notes = append(notes, fmt.Sprintf(
+				"Sheet %s holds %d sample rows of %d; loops and End(xlUp) only see the sample",
+				book.Sheet(nonEmpty(structure.SheetName, "Sheet1")).Name, len(structure.SampleData), structure.DataRows))
+		}
+	}
+	result := RunWorkbook(code, book, opts)
+	result.Notes = append(notes, result.Notes...)
+	return result
+}
+
+// RunWorkbook parses VBA code and runs a procedure against the given workbook
+func RunWorkbook(code string, book *Workbook, opts Options) *Result {
+	result := &Result{Changes: []CellChange{}, Sheets: []SheetChange{}, Output: []string{}, Notes: []string{}}
+
+	module, errs := vba.ParseModule(code)
+	if len(errs) > 0 {
+		result.Error = &RunError{Kind: ErrCompile, Message: errs[0].Msg, Line: errs[0].Pos.Line, Column: errs[0].Pos.Column}
+		return result
+	}
+
+	if opts.MaxSteps <= 0 {
+		opts.MaxSteps = defaultMaxSteps
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+
+	in := newInterpreter(module, code, book, opts)
+	before := book.snapshot()
+
+	proc, err := in.entry(opts.Entry)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	result.Procedure = proc.Name
+
+	result.Error = in.run(proc)
+	result.Completed = result.Error == nil
+	result.Changes, result.Sheets = book.diff(before)
+	result.Output = append(result.Output, in.output...)
+	result.Notes = append(result.Notes, in.notes...)
+	result.Steps = in.steps
+	return result
+}
+
+func nonEmpty(s, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
+
+// Var is a variable or constant
+type Var struct {
+	Value Value
+	Type  string // Declared type in lower case; "" for Variant
+	Const bool
+}
+
+// errorMode is the active On Error setting of a procedure
+type errorMode int
+
+const (
+	errorsRaise      errorMode = iota // On Error GoTo 0
+	errorsResumeNext                  // On Error Resume Next
+	errorsGoTo                        // On Error GoTo label
+)
+
+// frame is the state of one procedure call
+type frame struct {
+	proc     *vba.Procedure
+	locals   map[string]*Var
+	with     []Value
+	onError  errorMode
+	handler  string // Label of On Error GoTo
+	handling bool   // Inside the error handler
+	resumeAt int    // Top-level statement that raised the handled error
+}
+
+// ctlKind is how a statement transfers control
+type ctlKind int
+
+const (
+	ctlNext ctlKind = iota
+	ctlExitProc
+	ctlExitFor
+	ctlExitDo
+	ctlGoTo
+	ctlResume
+)
+
+type ctl struct {
+	kind  ctlKind
+	label string
+	next  bool // Resume Next
+}
+
+// endRun unwinds the interpreter on an End or Stop statement
+type endRun struct{}
+
+// Interpreter runs VBA procedures against a mock workbook
+type Interpreter struct {
+	module      *vba.Module
+	src         string
+	book        *Workbook
+	opts        Options
+	globals     map[string]*Var
+	procs       map[string]*vba.Procedure
+	declares    map[string]bool
+	types       map[string]bool
+	statics     map[*vba.Procedure]map[string]*Var
+	frame       *frame
+	depth       int
+	steps       int
+	deadline    time.Time
+	output      []string
+	notes       []string
+	noted       map[string]bool
+	inputs      int
+	compareText bool
+	optionBase  int
+	explicit    bool
+	app         *application
+	errObj      *errObject
+	rnd         uint32
+}
+
+func newInterpreter(module *vba.Module, src string, book *Workbook, opts Options) *Interpreter {
+	in := &Interpreter{
+		module:   module,
+		src:      src,
+		book:     book,
+		opts:     opts,
+		globals:  map[string]*Var{},
+		procs:    map[string]*vba.Procedure{},
+		declares: map[string]bool{},
+		types:    map[string]bool{},
+		statics:  map[*vba.Procedure]map[string]*Var{},
+		noted:    map[string]bool{},
+		errObj:   &errObject{},
+		rnd:      0x50000,
+	}
+	in.app = &application{in: in, settings: map[string]Value{}}
+
+	for _, proc := range module.Procedures {
+		if _, exists := in.procs[nameKey(proc.Name)]; !exists {
+			in.procs[nameKey(proc.Name)] = proc
+		}
+	}
+	for _, option := range module.Options {
+		switch strings.ToLower(option.Name) {
+		case "explicit":
+			in.explicit = true
+		case "base":
+			if option.Value == "1" {
+				in.optionBase = 1
+			}
+		case "compare":
+			in.compareText = strings.EqualFold(option.Value, "Text")
+		}
+	}
+	return in
+}
+
+// note records a skipped or approximated effect once
+func (in *Interpreter) note(key, text string) {
+	if in.noted[key] {
+		return
+	}
+	in.noted[key] = true
+	in.notes = append(in.notes, text)
+}
+
+// entry finds the procedure to run
+func (in *Interpreter) entry(name string) (*vba.Procedure, *RunError) {
+	if name != "" {
+		proc := in.module.Procedure(name)
+		if proc == nil {
+			return nil, compileError("procedure %s not found", name)
+		}
+		for _, param := range proc.Params {
+			if !param.Optional && !param.ParamArray {
+				return nil, compileError("procedure %s takes parameters and cannot be started directly", proc.Name)
+			}
+		}
+		return proc, nil
+	}
+
+	candidates := []*vba.Procedure{}
+	for _, proc := range in.module.Procedures {
+		if proc.Kind != vba.ProcSub {
+			continue
+		}
+		required := false
+		for _, param := range proc.Params {
+			required = required || (!param.Optional && !param.ParamArray)
+		}
+		if !required {
+			candidates = append(candidates, proc)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, compileError("no Sub without parameters to run")
+	}
+	// Prefer a public macro over private helpers
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Visibility != "Private" && candidates[j].Visibility == "Private"
+	})
+	return candidates[0], nil
+}
+
+// run initializes the module and calls the entry procedure, converting
+// every way a run can stop into a RunError
+func (in *Interpreter) run(proc *vba.Procedure) (err *RunError) {
+	in.deadline = time.Now().Add(in.opts.Timeout)
+	defer func() {
+		if r := recover(); r != nil {
+			switch e := r.(type) {
+			case endRun:
+				err = nil
+			case *RunError:
+				err = e
+			default:
+				err = &RunError{Kind: ErrInternal, Message: fmt.Sprint(r)}
+			}
+		}
+	}()
+
+	in.declareModule()
+	in.callProc(proc, nil, nil)
+	return nil
+}
+
+// declareModule creates the module-level variables, constants and enums
+func (in *Interpreter) declareModule() {
+	for _, decl := range in.module.Declarations {
+		switch d := decl.(type) {
+		case *vba.DimStmt:
+			in.declare(d.Vars, in.globals)
+		case *vba.ConstStmt:
+			for _, c := range d.Consts {
+				in.globals[nameKey(c.Name)] = &Var{Value: in.constValue(c), Const: true}
+			}
+		case *vba.EnumDef:
+			next := int64(0)
+			for _, member := range d.Members {
+				if member.Value != nil {
+					n, err := toNumber(in.eval(member.Value))
+					in.check(member, err)
+					next = int64(n)
+				}
+				in.globals[nameKey(member.Name)] = &Var{Value: Long(next), Type: "long", Const: true}
+				next++
+			}
+		case *vba.DeclareStmt:
+			in.declares[strings.ToLower(d.Name)] = true
+		case *vba.TypeDef:
+			in.types[strings.ToLower(d.Name)] = true
+		}
+	}
+}
+
+func (in *Interpreter) constValue(c *vba.ConstDecl) Value {
+	v := in.scalar(c, in.eval(c.Value))
+	if c.Type != nil {
+		typed, err := coerce(strings.ToLower(c.Type.Name), v)
+		in.check(c, err)
+		return typed
+	}
+	return v
+}
+
+// fail stops evaluation with an error positioned at node
+func (in *Interpreter) fail(node vba.Node, err *RunError) {
+	if err.Line == 0 && node != nil {
+		pos := node.Pos()
+		err.Line, err.Column = pos.Line, pos.Column
+	}
+	if err.Procedure == "" && in.frame != nil {
+		err.Procedure = in.frame.proc.Name
+	}
+	panic(err)
+}
+
+// check fails with err when it is not nil
+func (in *Interpreter) check(node vba.Node, err error) {
+	if err == nil {
+		return
+	}
+	runErr, ok := err.(*RunError)
+	if !ok {
+		runErr = runtimeError(1004, err.Error())
+	}
+	in.fail(node, runErr)
+}
+
+// step counts an executed statement and enforces the limits
+func (in *Interpreter) step(stmt vba.Stmt) {
+	in.steps++
+	if in.steps > in.opts.MaxSteps {
+		in.fail(stmt, &RunError{Kind: ErrLimit, Message: fmt.Sprintf(
+			"stopped after %d statements; the macro may loop forever", in.opts.MaxSteps)})
+	}
+	if in.steps%1024 == 0 && time.Now().After(in.deadline) {
+		in.fail(stmt, &RunError{Kind: ErrLimit, Message: fmt.Sprintf("stopped after %s", in.opts.Timeout)})
+	}
+}
+
+// callProc runs a user procedure and returns its result
+func (in *Interpreter) callProc(proc *vba.Procedure, args []*vba.Arg, site vba.Node) Value {
+	if in.depth >= maxCallDepth {
+		in.fail(site, runtimeError(28, ""))
+	}
+	if proc.Kind == vba.ProcPropertyLet || proc.Kind == vba.ProcPropertySet {
+		in.fail(site, unsupported(string(proc.Kind), "call procedures in standard modules instead"))
+	}
+
+	f := &frame{proc: proc, locals: map[string]*Var{}}
+	in.bindParams(f, proc, args, site)
+
+	returnVar := ""
+	if proc.Kind == vba.ProcFunction || proc.Kind == vba.ProcPropertyGet {
+		returnVar = nameKey(proc.Name)
+		returnType := ""
+		if proc.ReturnType != nil {
+			returnType = strings.ToLower(proc.ReturnType.Name)
+		}
+		f.locals[returnVar] = &Var{Value: defaultValue(returnType), Type: returnType}
+	}
+	if proc.Static {
+		f.locals = in.staticLocals(proc, f.locals)
+	}
+
+	caller := in.frame
+	in.frame = f
+	in.depth++
+	defer func() {
+		in.frame = caller
+		in.depth--
+	}()
+
+	in.runBody(f, proc.Body)
+	if returnVar == "" {
+		return Empty
+	}
+	return f.locals[returnVar].Value
+}
+
+// staticLocals returns the persistent variables of a Static procedure
+func (in *Interpreter) staticLocals(proc *vba.Procedure, params map[string]*Var) map[string]*Var {
+	saved, ok := in.statics[proc]
+	if !ok {
+		saved = map[string]*Var{}
+		in.statics[proc] = saved
+	}
+	for name, v := range params {
+		saved[name] = v
+	}
+	return saved
+}
+
+// bindParams evaluates the arguments in the caller's frame and binds them to
+// the parameters. ByRef arguments naming a variable share it with the callee.
+func (in *Interpreter) bindParams(f *frame, proc *vba.Procedure, args []*vba.Arg, site vba.Node) {
+	positional := []*vba.Arg{}
+	named := map[string]*vba.Arg{}
+	for _, arg := range args {
+		if arg.Name != "" {
+			named[nameKey(arg.Name)] = arg
+		} else {
+			positional = append(positional, arg)
+		}
+	}
+
+	for i, param := range proc.Params {
+		name := nameKey(param.Name)
+		paramType := ""
+		if param.Type != nil {
+			paramType = strings.ToLower(param.Type.Name)
+		}
+
+		if param.ParamArray {
+			var values []Value
+			if i < len(positional) {
+				for _, arg := range positional[i:] {
+					values = append(values, in.eval(arg.Value))
+				}
+			}
+			f.locals[name] = &Var{Value: ArrayValue(ListArray(0, values))}
+			return
+		}
+
+		var arg *vba.Arg
+		if i < len(positional) {
+			arg = positional[i]
+		}
+		if a, ok := named[name]; ok {
+			arg = a
+		}
+
+		if arg == nil || arg.Value == nil {
+			if !param.Optional {
+				in.fail(site, runtimeError(449, param.Name))
+			}
+			v := Value{Kind: KindEmpty, missing: true}
+			if param.Default != nil {
+				v = in.eval(param.Default)
+			} else if paramType != "" && paramType != "variant" {
+				v = defaultValue(paramType)
+			}
+			f.locals[name] = &Var{Value: v, Type: paramType}
+			continue
+		}
+
+		if !param.ByVal {
+			if ident, ok := arg.Value.(*vba.Ident); ok {
+				if v := in.lookupVar(ident.Name); v != nil && !v.Const {
+					f.locals[name] = v
+					continue
+				}
+			}
+		}
+
+		v := in.eval(arg.Value)
+		if param.IsArray || v.Kind == KindArray {
+			if v.Kind != KindArray {
+				in.fail(arg, runtimeError(13, "array expected for "+param.Name))
+			}
+			f.locals[name] = &Var{Value: ArrayValue(v.Arr.Copy()), Type: paramType}
+			continue
+		}
+		if !isObjectType(paramType) {
+			v = in.scalar(arg, v)
+		}
+		typed, err := coerce(paramType, v)
+		in.check(arg, err)
+		f.locals[name] = &Var{Value: typed, Type: paramType}
+	}
+
+	if len(positional) > len(proc.Params) {
+		in.fail(site, runtimeError(450, proc.Name))
+	}
+}
+
+// runBody executes a procedure body, following GoTo to top-level labels and
+// sending run-time errors to the On Error handler
+func (in *Interpreter) runBody(f *frame, body []vba.Stmt) {
+	labels := map[string]int{}
+	for i, stmt := range body {
+		if label, ok := stmt.(*vba.LabelStmt); ok {
+			labels[strings.ToLower(label.Name)] = i
+		}
+	}
+	jump := func(stmt vba.Stmt, label string) int {
+		i, ok := labels[strings.ToLower(label)]
+		if !ok {
+			in.fail(stmt, unsupported("GoTo "+label, "only labels at the top level of a procedure can be jumped to"))
+		}
+		return i
+	}
+
+	for i := 0; i < len(body); {
+		c, err := in.execGuarded(body[i])
+		if err != nil {
+			if f.onError != errorsGoTo || f.handling {
+				panic(err)
+			}
+			in.errObj.set(err)
+			f.handling = true
+			f.resumeAt = i
+			i = jump(body[i], f.handler)
+			continue
+		}
+
+		switch c.kind {
+		case ctlExitProc:
+			return
+		case ctlGoTo:
+			i = jump(body[i], c.label)
+			continue
+		case ctlResume:
+			if !f.handling {
+				in.fail(body[i], runtimeError(20, ""))
+			}
+			f.handling = false
+			in.errObj.clear()
+			switch {
+			case c.label != "":
+				i = jump(body[i], c.label)
+			case c.next:
+				i = f.resumeAt + 1
+			default:
+				i = f.resumeAt
+			}
+			continue
+		case ctlExitFor, ctlExitDo:
+			in.fail(body[i], compileError("Exit %s not within a loop", map[ctlKind]string{ctlExitFor: "For", ctlExitDo: "Do"}[c.kind]))
+		}
+		i++
+	}
+}
+
+// execGuarded executes a top-level statement, returning a run-time error
+// instead of unwinding so the procedure's handler can take it
+func (in *Interpreter) execGuarded(stmt vba.Stmt) (c ctl, err *RunError) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(*RunError); ok && e.Kind == ErrRuntime && in.frame.onError == errorsGoTo && !in.frame.handling {
+				err = e
+				return
+			}
+			panic(r)
+		}
+	}()
+	return in.exec(stmt), nil
+}
+
+// execBlock executes statements until one transfers control
+func (in *Interpreter) execBlock(stmts []vba.Stmt) ctl {
+	for _, stmt := range stmts {
+		if c := in.exec(stmt); c.kind != ctlNext {
+			return c
+		}
+	}
+	return ctl{}
+}
+
+// exec executes one statement. Under On Error Resume Next a run-time error
+// skips the statement.
+func (in *Interpreter) exec(stmt vba.Stmt) (c ctl) {
+	in.step(stmt)
+	if in.frame.onError == errorsResumeNext {
+		defer func() {
+			if r := recover(); r != nil {
+				if e, ok := r.(*RunError); ok && e.Kind == ErrRuntime && in.frame.onError == errorsResumeNext {
+					in.errObj.set(e)
+					c = ctl{}
+					return
+				}
+				panic(r)
+			}
+		}()
+	}
+
+	switch s := stmt.(type) {
+	case *vba.DimStmt:
+		if strings.EqualFold(s.Keyword, "Static") {
+			in.declareStatic(s.Vars)
+		} else {
+			in.declare(s.Vars, in.frame.locals)
+		}
+	case *vba.ConstStmt:
+		for _, c := range s.Consts {
+			in.frame.locals[nameKey(c.Name)] = &Var{Value: in.constValue(c), Const: true}
+		}
+	case *vba.ReDimStmt:
+		in.redim(s)
+	case *vba.AssignStmt:
+		in.assign(s)
+	case *vba.CallStmt:
+		in.callStmt(s)
+	case *vba.IfStmt:
+		return in.execIf(s)
+	case *vba.ForStmt:
+		return in.execFor(s)
+	case *vba.ForEachStmt:
+		return in.execForEach(s)
+	case *vba.DoStmt:
+		return in.execDo(s)
+	case *vba.WhileStmt:
+		for in.truthy(s.Cond, in.eval(s.Cond)) {
+			if c := in.execBlock(s.Body); c.kind != ctlNext {
+				return c
+			}
+			in.step(stmt)
+		}
+	case *vba.SelectStmt:
+		return in.execSelect(s)
+	case *vba.WithStmt:
+		obj := in.eval(s.Object)
+		in.frame.with = append(in.frame.with, obj)
+		c := in.execBlock(s.Body)
+		in.frame.with = in.frame.with[:len(in.frame.with)-1]
+		return c
+	case *vba.LabelStmt:
+	case *vba.GoToStmt:
+		if s.GoSub {
+			in.fail(s, unsupported("GoSub", "call a Sub instead"))
+		}
+		return ctl{kind: ctlGoTo, label: s.Label}
+	case *vba.OnErrorStmt:
+		in.onError(s)
+	case *vba.ResumeStmt:
+		return ctl{kind: ctlResume, label: s.Label, next: s.Next}
+	case *vba.ExitStmt:
+		switch s.Kind {
+		case "For":
+			return ctl{kind: ctlExitFor}
+		case "Do":
+			return ctl{kind: ctlExitDo}
+		}
+		return ctl{kind: ctlExitProc}
+	case *vba.EndStmt:
+		panic(endRun{})
+	case *vba.StopStmt:
+		in.note("stop", fmt.Sprintf("Stop at line %d ended the run, as the debugger would break there", s.Pos().Line))
+		panic(endRun{})
+	case *vba.EraseStmt:
+		for _, name := range s.Names {
+			v := in.variable(name, false)
+			if v.Value.Kind != KindArray {
+				in.fail(name, runtimeError(13, "Erase needs an array"))
+			}
+			if v.Value.Arr.Fixed {
+				zero := defaultValue(v.Value.Arr.Type)
+				for i := range v.Value.Arr.Data {
+					v.Value.Arr.Data[i] = zero
+				}
+			} else {
+				v.Value = ArrayValue(&Array{Type: v.Value.Arr.Type})
+			}
+		}
+	case *vba.RawStmt:
+		in.fail(s, unsupported(s.Keyword+" statement", "file I/O and other raw statements cannot run in the dry run"))
+	case *vba.BadStmt:
+		in.fail(s, compileError("statement could not be parsed"))
+	default:
+		in.fail(stmt, unsupported(fmt.Sprintf("%T", stmt), ""))
+	}
+	return ctl{}
+}
+
+func (in *Interpreter) onError(s *vba.OnErrorStmt) {
+	f := in.frame
+	switch s.Action {
+	case vba.OnErrorResumeNext:
+		f.onError = errorsResumeNext
+	case vba.OnErrorGoTo0:
+		f.onError = errorsRaise
+	case vba.OnErrorGoToMinus1:
+		f.handling = false
+		in.errObj.clear()
+	case vba.OnErrorGoToLabel:
+		f.onError = errorsGoTo
+		f.handler = s.Label
+	}
+	if s.Action != vba.OnErrorGoToMinus1 {
+		in.errObj.clear()
+	}
+}
+
+// declare creates variables in scope
+func (in *Interpreter) declare(vars []*vba.VarDecl, scope map[string]*Var) {
+	for _, decl := range vars {
+		scope[nameKey(decl.Name)] = in.newVar(decl)
+	}
+}
+
+// declareStatic creates the Static variables of the current procedure once
+func (in *Interpreter) declareStatic(vars []*vba.VarDecl) {
+	saved, ok := in.statics[in.frame.proc]
+	if !ok {
+		saved = map[string]*Var{}
+		in.statics[in.frame.proc] = saved
+	}
+	for _, decl := range vars {
+		name := nameKey(decl.Name)
+		if _, exists := saved[name]; !exists {
+			saved[name] = in.newVar(decl)
+		}
+		in.frame.locals[name] = saved[name]
+	}
+}
+
+func (in *Interpreter) newVar(decl *vba.VarDecl) *Var {
+	typeName := ""
+	if decl.Type != nil {
+		typeName = strings.ToLower(decl.Type.Name)
+		if in.types[typeName] {
+			in.fail(decl, unsupported("User-defined Type "+decl.Type.Name, ""))
+		}
+	}
+
+	v := &Var{Type: typeName, Value: defaultValue(typeName)}
+	switch {
+	case decl.IsArray && len(decl.Bounds) > 0:
+		lower, upper := in.bounds(decl.Bounds)
+		arr := NewArray(lower, upper, typeName)
+		arr.Fixed = true
+		v.Value = ArrayValue(arr)
+	case decl.IsArray:
+		v.Value = ArrayValue(&Array{Type: typeName})
+	case decl.Type != nil && decl.Type.New:
+		obj, err := in.newObject(decl.Type.Name)
+		in.check(decl, err)
+		v.Value = ObjectValue(obj)
+	}
+	return v
+}
+
+// bounds evaluates array bounds; a missing lower bound is the Option Base
+func (in *Interpreter) bounds(bounds []*vba.ArrayBound) ([]int, []int) {
+	lower := make([]int, len(bounds))
+	upper := make([]int, len(bounds))
+	for i, bound := range bounds {
+		lower[i] = in.optionBase
+		if bound.Lower != nil {
+			lower[i] = in.integer(bound.Lower, in.eval(bound.Lower))
+		}
+		upper[i] = in.integer(bound.Upper, in.eval(bound.Upper))
+		if upper[i] < lower[i]-1 {
+			in.fail(bound, runtimeError(9, "upper bound below lower bound"))
+		}
+	}
+	return lower, upper
+}
+
+func (in *Interpreter) redim(s *vba.ReDimStmt) {
+	for _, decl := range s.Vars {
+		v := in.lookupVar(decl.Name)
+		if v == nil {
+			v = in.newVar(&vba.VarDecl{Span: decl.Span, Name: decl.Name, IsArray: true, Type: decl.Type})
+			in.frame.locals[nameKey(decl.Name)] = v
+		}
+		lower, upper := in.bounds(decl.Bounds)
+
+		if v.Value.Kind != KindArray {
+			if v.Value.Kind != KindEmpty && v.Type != "" && v.Type != "variant" {
+				in.fail(decl, compileError("ReDim of %s, which is not an array", decl.Name))
+			}
+			typeName := v.Type
+			if decl.Type != nil {
+				typeName = strings.ToLower(decl.Type.Name)
+			}
+			v.Value = ArrayValue(NewArray(lower, upper, typeName))
+			continue
+		}
+		if v.Value.Arr.Fixed {
+			in.fail(decl, runtimeError(10, decl.Name))
+		}
+		if !v.Value.Arr.Redim(lower, upper, s.Preserve) {
+			in.fail(decl, runtimeError(9, "ReDim Preserve can only change the last dimension"))
+		}
+	}
+}
+
+func (in *Interpreter) execIf(s *vba.IfStmt) ctl {
+	if in.truthy(s.Cond, in.eval(s.Cond)) {
+		return in.execBlock(s.Then)
+	}
+	for _, clause := range s.ElseIfs {
+		if in.truthy(clause.Cond, in.eval(clause.Cond)) {
+			return in.execBlock(clause.Body)
+		}
+	}
+	return in.execBlock(s.Else)
+}
+
+func (in *Interpreter) execFor(s *vba.ForStmt) ctl {
+	start := in.scalar(s.From, in.eval(s.From))
+	end := in.scalar(s.To, in.eval(s.To))
+	step := Long(1)
+	if s.Step != nil {
+		step = in.scalar(s.Step, in.eval(s.Step))
+	}
+	stepNum, err := toNumber(step)
+	in.check(s.Step, err)
+	endNum, err := toNumber(end)
+	in.check(s.To, err)
+
+	counter := in.variable(s.Var, true)
+	in.setVar(s.Var, counter, start)
+	for {
+		current, err := toNumber(counter.Value)
+		in.check(s.Var, err)
+		if (stepNum >= 0 && current > endNum) || (stepNum < 0 && current < endNum) {
+			return ctl{}
+		}
+
+		c := in.execBlock(s.Body)
+		switch c.kind {
+		case ctlExitFor:
+			return ctl{}
+		case ctlNext:
+		default:
+			return c
+		}
+
+		current, err = toNumber(counter.Value)
+		in.check(s.Var, err)
+		next := Double(current + stepNum)
+		if isIntegral(counter.Value) && isIntegral(step) {
+			next = Long(int64(current + stepNum))
+		}
+		in.setVar(s.Var, counter, next)
+		in.step(s)
+	}
+}
+
+func (in *Interpreter) execForEach(s *vba.ForEachStmt) ctl {
+	group := in.eval(s.Group)
+	var items []Value
+	switch {
+	case group.Kind == KindArray:
+		if len(group.Arr.Lengths) == 0 {
+			in.fail(s.Group, runtimeError(92, "the array has no elements"))
+		}
+		items = append(items, group.Arr.Data...)
+	case group.Kind == KindObject && group.Obj != nil:
+		enum, ok := group.Obj.(enumerable)
+		if !ok {
+			in.fail(s.Group, runtimeError(438, "For Each over "+group.Obj.TypeName()))
+		}
+		var err error
+		items, err = enum.items(in)
+		in.check(s.Group, err)
+	case group.IsNothing():
+		in.fail(s.Group, runtimeError(91, ""))
+	default:
+		in.fail(s.Group, runtimeError(424, "For Each needs an array or collection"))
+	}
+
+	element := in.variable(s.Var, true)
+	for _, item := range items {
+		element.Value = item
+		c := in.execBlock(s.Body)
+		switch c.kind {
+		case ctlExitFor:
+			return ctl{}
+		case ctlNext:
+		default:
+			return c
+		}
+		in.step(s)
+	}
+	return ctl{}
+}
+
+func (in *Interpreter) execDo(s *vba.DoStmt) ctl {
+	test := func() bool {
+		if s.Cond == nil {
+			return true
+		}
+		result := in.truthy(s.Cond, in.eval(s.Cond))
+		return result != s.Until
+	}
+
+	for {
+		if !s.CondAtEnd && !test() {
+			return ctl{}
+		}
+		c := in.execBlock(s.Body)
+		switch c.kind {
+		case ctlExitDo:
+			return ctl{}
+		case ctlNext:
+		default:
+			return c
+		}
+		if s.CondAtEnd && !test() {
+			return ctl{}
+		}
+		in.step(s)
+	}
+}
+
+func (in *Interpreter) execSelect(s *vba.SelectStmt) ctl {
+	subject := in.scalar(s.Expr, in.eval(s.Expr))
+	for _, clause := range s.Cases {
+		if clause.IsElse {
+			return in.execBlock(clause.Body)
+		}
+		for _, cond := range clause.Conditions {
+			if in.caseMatches(subject, cond) {
+				return in.execBlock(clause.Body)
+			}
+		}
+	}
+	return ctl{}
+}
+
+func (in *Interpreter) caseMatches(subject Value, cond vba.Expr) bool {
+	switch c := cond.(type) {
+	case *vba.CaseRange:
+		low := in.scalar(c.Low, in.eval(c.Low))
+		high := in.scalar(c.High, in.eval(c.High))
+		return in.truthy(c, in.compare(c, ">=", subject, low)) && in.truthy(c, in.compare(c, "<=", subject, high))
+	case *vba.CaseIs:
+		return in.truthy(c, in.compare(c, c.Op, subject, in.scalar(c.Value, in.eval(c.Value))))
+	}
+	return in.truthy(cond, in.compare(cond, "=", subject, in.scalar(cond, in.eval(cond))))
+}
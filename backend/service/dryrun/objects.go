@@ -0,0 +1,1754 @@
+package dryrun
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"excel-automation-mcp/backend/service/excelrange"
+)
+
+// maxArrayCells caps the ranges read into or filled from a single value, so
+// Range("A:A").Value cannot exhaust memory
+const maxArrayCells = 250000
+
+// ----------------------------------------------------------------------------
+// Range
+
+// rangeMode is how a range counts and enumerates its members
+type rangeMode int
+
+const (
+	modeCells   rangeMode = iota // Range, Cells
+	modeRows                     // Rows
+	modeColumns                  // Columns
+)
+
+// Range is a rectangular block of cells on a sheet
+type Range struct {
+	sheet *Worksheet
+	area  Area
+	mode  rangeMode
+}
+
+func newRange(sheet *Worksheet, area Area) *Range {
+	return &Range{sheet: sheet, area: area}
+}
+
+// TypeName implements Object
+func (r *Range) TypeName() string { return "Range" }
+
+func (r *Range) rows() int { return r.area.Row2 - r.area.Row1 + 1 }
+func (r *Range) cols() int { return r.area.Col2 - r.area.Col1 + 1 }
+
+// offsetArea moves and resizes the area, failing outside the sheet
+func (r *Range) offsetArea(rowOffset, colOffset, rows, cols int) (*Range, error) {
+	area := Area{Row1: r.area.Row1 + rowOffset, Col1: r.area.Col1 + colOffset}
+	area.Row2, area.Col2 = area.Row1+rows-1, area.Col1+cols-1
+	if area.Row1 < 1 || area.Col1 < 1 || area.Row2 > MaxRows || area.Col2 > MaxColumns || rows < 1 || cols < 1 {
+		return nil, runtimeError(1004, "the range would be outside the worksheet")
+	}
+	return newRange(r.sheet, area), nil
+}
+
+// cell returns the cell at a 1-based position relative to the top-left corner
+func (r *Range) cell(row, col int) (*Range, error) {
+	return r.offsetArea(row-1, col-1, 1, 1)
+}
+
+// item implements Range(i), Range(row, column), Rows(i) and Columns(i)
+func (r *Range) item(in *Interpreter, args []Arg) (*Range, error) {
+	first, _ := arg(args, 0)
+	first = in.scalar(nil, first)
+	if second, ok := arg(args, 1); ok {
+		row, err := toNumber(first)
+		if err != nil {
+			return nil, err
+		}
+		col, err := columnArg(in.scalar(nil, second))
+		if err != nil {
+			return nil, err
+		}
+		return r.cell(int(row), col)
+	}
+
+	n, err := toNumber(first)
+	if err != nil {
+		return nil, err
+	}
+	i := int(n)
+	switch r.mode {
+	case modeRows:
+		return r.offsetArea(i-1, 0, 1, r.cols())
+	case modeColumns:
+		return r.offsetArea(0, i-1, r.rows(), 1)
+	}
+	if i < 1 {
+		return nil, runtimeError(1004, "")
+	}
+	return r.cell((i-1)/r.cols()+1, (i-1)%r.cols()+1)
+}
+
+// columnArg reads a column given as a number or letters
+func columnArg(v Value) (int, error) {
+	if v.Kind == KindString {
+		if _, ok := parseNumber(v.Str); !ok {
+			if col := columnNumber(v.Str); col > 0 {
+				return col, nil
+			}
+			return 0, runtimeError(1004, "invalid column "+strconv.Quote(v.Str))
+		}
+	}
+	n, err := toNumber(v)
+	return int(n), err
+}
+
+// values returns the value of a single cell or a 1-based two-dimensional array
+func (r *Range) values(in *Interpreter) (Value, error) {
+	if r.area.Cells() == 1 {
+		return r.cellValue(in, r.area.Row1, r.area.Col1), nil
+	}
+	if r.area.Cells() > maxArrayCells {
+		return Empty, &RunError{Kind: ErrLimit, Message: fmt.Sprintf(
+			"reading %s into an array needs %d cells; the dry run allows %d", r.area.Address(false), r.area.Cells(), maxArrayCells)}
+	}
+	arr := NewArray([]int{1, 1}, []int{r.rows(), r.cols()}, "")
+	for c := 0; c < r.cols(); c++ {
+		for row := 0; row < r.rows(); row++ {
+			arr.Data[row+c*r.rows()] = r.cellValue(in, r.area.Row1+row, r.area.Col1+c)
+		}
+	}
+	return ArrayValue(arr), nil
+}
+
+func (r *Range) cellValue(in *Interpreter, row, col int) Value {
+	if formula := r.sheet.cells[cellKey{row, col}]; formula != nil && formula.Formula != "" {
+		in.note("formula", "Formulas written by the macro are not calculated; reading such a cell returns the formula text")
+	}
+	return r.sheet.Value(row, col)
+}
+
+// setValues assigns Range.Value: arrays are written cell by cell and a
+// scalar fills every cell
+func (r *Range) setValues(in *Interpreter, v Value) error {
+	if v.Kind == KindObject || v.Kind == KindNull {
+		if v.Kind == KindNull {
+			r.sheet.clear(r.area)
+			return nil
+		}
+		return runtimeError(13, "")
+	}
+
+	if v.Kind != KindArray {
+		if v.Kind == KindEmpty || (v.Kind == KindString && v.Str == "") {
+			r.sheet.clear(r.area)
+			return nil
+		}
+		if r.area.Cells() > maxArrayCells {
+			return &RunError{Kind: ErrLimit, Message: fmt.Sprintf("filling %d cells exceeds the dry run limit of %d", r.area.Cells(), maxArrayCells)}
+		}
+		for row := r.area.Row1; row <= r.area.Row2; row++ {
+			for col := r.area.Col1; col <= r.area.Col2; col++ {
+				if err := r.store(in, row, col, v); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	arr := v.Arr
+	get := func(row, col int) (Value, bool) { return Value{}, false }
+	switch len(arr.Lengths) {
+	case 1:
+		// A one-dimensional array is a row
+		get = func(row, col int) (Value, bool) {
+			if row > 0 || col >= arr.Lengths[0] {
+				return Value{}, false
+			}
+			return arr.Data[col], true
+		}
+	case 2:
+		get = func(row, col int) (Value, bool) {
+			if row >= arr.Lengths[0] || col >= arr.Lengths[1] {
+				return Value{}, false
+			}
+			return arr.Data[row+col*arr.Lengths[0]], true
+		}
+	default:
+		return runtimeError(13, "only one- and two-dimensional arrays can be written to a range")
+	}
+	if r.area.Cells() > maxArrayCells {
+		return &RunError{Kind: ErrLimit, Message: fmt.Sprintf("writing %d cells exceeds the dry run limit of %d", r.area.Cells(), maxArrayCells)}
+	}
+	for row := 0; row < r.rows(); row++ {
+		for col := 0; col < r.cols(); col++ {
+			value, ok := get(row, col)
+			if !ok {
+				// Excel fills the part of the range the array does not cover with #N/A
+				value = Value{Kind: KindError, Num: 2042}
+			}
+			if err := r.store(in, r.area.Row1+row, r.area.Col1+col, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// store writes one cell the way Excel enters a value: text starting with =
+// becomes a formula and numeric text becomes a number
+func (r *Range) store(in *Interpreter, row, col int, v Value) error {
+	switch v.Kind {
+	case KindObject, KindArray:
+		return runtimeError(13, "")
+	case KindNull:
+		v = Empty
+	case KindString:
+		if strings.HasPrefix(v.Str, "=") && len(v.Str) > 1 {
+			in.note("formula", "Formulas written by the macro are not calculated; reading such a cell returns the formula text")
+			r.sheet.SetFormula(row, col, v.Str)
+			return nil
+		}
+		if strings.TrimSpace(v.Str) != "" && !strings.HasPrefix(v.Str, "'") {
+			v = typedCell(v.Str, "")
+		}
+	}
+	r.sheet.SetValue(row, col, v)
+	return nil
+}
+
+// setFormulas assigns Range.Formula
+func (r *Range) setFormulas(in *Interpreter, v Value) error {
+	if v.Kind == KindArray {
+		return r.setValues(in, v)
+	}
+	if v.Kind != KindString || !strings.HasPrefix(v.Str, "=") {
+		return r.setValues(in, v)
+	}
+	if r.area.Cells() > maxArrayCells {
+		return &RunError{Kind: ErrLimit, Message: fmt.Sprintf("filling %d cells exceeds the dry run limit of %d", r.area.Cells(), maxArrayCells)}
+	}
+	if r.area.Cells() > 1 {
+		in.note("formula-fill", "A formula written to several cells is copied verbatim; relative references are not adjusted")
+	}
+	for row := r.area.Row1; row <= r.area.Row2; row++ {
+		for col := r.area.Col1; col <= r.area.Col2; col++ {
+			if err := r.store(in, row, col, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// end implements Range.End: the cell Ctrl+Arrow moves to from the top-left cell
+func (r *Range) end(direction int) (*Range, error) {
+	dr, dc := 0, 0
+	switch direction {
+	case -4162:
+		dr = -1
+	case -4121:
+		dr = 1
+	case -4159:
+		dc = -1
+	case -4161:
+		dc = 1
+	default:
+		return nil, runtimeError(5, "End needs xlUp, xlDown, xlToLeft or xlToRight")
+	}
+
+	s := r.sheet
+	row, col := r.area.Row1, r.area.Col1
+	inside := func(row, col int) bool { return row >= 1 && row <= MaxRows && col >= 1 && col <= MaxColumns }
+	if !inside(row+dr, col+dc) {
+		return newRange(s, Area{row, col, row, col}), nil
+	}
+	if !s.isEmpty(row, col) && !s.isEmpty(row+dr, col+dc) {
+		// Inside a block: move to its last filled cell
+		for inside(row+dr, col+dc) && !s.isEmpty(row+dr, col+dc) {
+			row, col = row+dr, col+dc
+		}
+		return newRange(s, Area{row, col, row, col}), nil
+	}
+
+	// Jump to the next filled cell, or the edge of the sheet
+	best := -1
+	for key := range s.cells {
+		distance := -1
+		switch {
+		case dc == 0 && key.Col == col && (key.Row-row)*dr > 0:
+			distance = (key.Row - row) * dr
+		case dr == 0 && key.Row == row && (key.Col-col)*dc > 0:
+			distance = (key.Col - col) * dc
+		}
+		if distance > 0 && (best < 0 || distance < best) {
+			best = distance
+		}
+	}
+	if best < 0 {
+		switch {
+		case dr < 0:
+			row = 1
+		case dr > 0:
+			row = MaxRows
+		case dc < 0:
+			col = 1
+		default:
+			col = MaxColumns
+		}
+	} else {
+		row, col = row+dr*best, col+dc*best
+	}
+	return newRange(s, Area{row, col, row, col}), nil
+}
+
+// currentRegion grows the area until it is surrounded by empty cells
+func (r *Range) currentRegion() *Range {
+	s := r.sheet
+	area := r.area
+	filled := func(row1, col1, row2, col2 int) bool {
+		if row1 < 1 {
+			row1 = 1
+		}
+		if col1 < 1 {
+			col1 = 1
+		}
+		if row2 > MaxRows {
+			row2 = MaxRows
+		}
+		if col2 > MaxColumns {
+			col2 = MaxColumns
+		}
+		if row1 > row2 || col1 > col2 {
+			return false
+		}
+		for key := range s.cells {
+			if key.Row >= row1 && key.Row <= row2 && key.Col >= col1 && key.Col <= col2 {
+				return true
+			}
+		}
+		return false
+	}
+	for grown := true; grown; {
+		grown = false
+		if area.Row1 > 1 && filled(area.Row1-1, area.Col1-1, area.Row1-1, area.Col2+1) {
+			area.Row1--
+			grown = true
+		}
+		if area.Row2 < MaxRows && filled(area.Row2+1, area.Col1-1, area.Row2+1, area.Col2+1) {
+			area.Row2++
+			grown = true
+		}
+		if area.Col1 > 1 && filled(area.Row1-1, area.Col1-1, area.Row2+1, area.Col1-1) {
+			area.Col1--
+			grown = true
+		}
+		if area.Col2 < MaxColumns && filled(area.Row1-1, area.Col2+1, area.Row2+1, area.Col2+1) {
+			area.Col2++
+			grown = true
+		}
+	}
+	// An isolated cell without neighbours is its own region
+	return newRange(s, area)
+}
+
+// address implements Range.Address
+func (r *Range) address(in *Interpreter, args []Arg) (Value, error) {
+	flag := func(i int, name string) bool {
+		v, ok := namedArg(args, i, name)
+		if !ok {
+			return true
+		}
+		n, _ := toNumber(in.scalar(nil, v))
+		return n != 0
+	}
+	rowAbs, colAbs := flag(0, "RowAbsolute"), flag(1, "ColumnAbsolute")
+	style, _ := namedArg(args, 2, "ReferenceStyle")
+	external, _ := namedArg(args, 3, "External")
+
+	var text string
+	if n, _ := toNumber(style); n == -4150 {
+		text = r1c1(r.area)
+	} else {
+		text = a1(r.area, rowAbs, colAbs)
+	}
+	if n, _ := toNumber(external); n != 0 {
+		text = "[" + r.sheet.book.Name + "]" + quoteSheet(r.sheet.Name) + "!" + text
+	}
+	return Str(text), nil
+}
+
+func a1(area Area, rowAbs, colAbs bool) string {
+	row := func(n int) string {
+		if rowAbs {
+			return "$" + strconv.Itoa(n)
+		}
+		return strconv.Itoa(n)
+	}
+	col := func(n int) string {
+		if colAbs {
+			return "$" + ColumnLetter(n)
+		}
+		return ColumnLetter(n)
+	}
+	switch {
+	case area.Row1 == 1 && area.Row2 == MaxRows:
+		return col(area.Col1) + ":" + col(area.Col2)
+	case area.Col1 == 1 && area.Col2 == MaxColumns:
+		return row(area.Row1) + ":" + row(area.Row2)
+	}
+	first := col(area.Col1) + row(area.Row1)
+	if area.Row1 == area.Row2 && area.Col1 == area.Col2 {
+		return first
+	}
+	return first + ":" + col(area.Col2) + row(area.Row2)
+}
+
+func r1c1(area Area) string {
+	first := fmt.Sprintf("R%dC%d", area.Row1, area.Col1)
+	if area.Row1 == area.Row2 && area.Col1 == area.Col2 {
+		return first
+	}
+	return first + fmt.Sprintf(":R%dC%d", area.Row2, area.Col2)
+}
+
+func quoteSheet(name string) string {
+	if strings.ContainsAny(name, " -+()&,;'!") {
+		return "'" + strings.ReplaceAll(name, "'", "''") + "'"
+	}
+	return name
+}
+
+// formattingMembers only change how cells look, which the dry run does not model
+var formattingMembers = map[string]bool{
+	"numberformat": true, "numberformatlocal": true, "font": true, "interior": true, "borders": true,
+	"horizontalalignment": true, "verticalalignment": true, "wraptext": true, "columnwidth": true,
+	"rowheight": true, "autofit": true, "style": true, "locked": true, "merge": true, "unmerge": true,
+	"mergecells": true, "orientation": true, "indentlevel": true, "shrinktofit": true, "borderaround": true,
+	"clearformats": true, "hidden": true, "formatconditions": true, "characters": true, "validation": true,
+	"tab": true, "visible": true, "standardwidth": true, "displaypagebreaks": true, "pagesetup": true,
+}
+
+// unsupportedRangeMembers need Excel features the mock object model lacks
+var unsupportedRangeMembers = map[string]bool{
+	"sort": true, "autofilter": true, "find": true, "findnext": true, "findprevious": true, "replace": true,
+	"removeduplicates": true, "advancedfilter": true, "texttocolumns": true, "subtotal": true,
+	"consolidate": true, "autofill": true, "pastespecial": true, "listobject": true, "areas": true,
+	"hyperlinks": true, "comment": true, "addcomment": true, "calculate": true, "dependents": true,
+	"precedents": true, "pivottable": true, "name": true, "cut": true, "printout": true,
+}
+
+// Get implements Object
+func (r *Range) Get(in *Interpreter, name string, args []Arg) (Value, error) {
+	name = strings.ToLower(name)
+	switch name {
+	case "", "value", "value2", "item":
+		if len(args) > 0 && name != "value" && name != "value2" {
+			cell, err := r.item(in, args)
+			if err != nil {
+				return Empty, err
+			}
+			return ObjectValue(cell), nil
+		}
+		return r.values(in)
+	case "text":
+		if r.area.Cells() > 1 {
+			return Null, nil
+		}
+		return Str(r.sheet.Value(r.area.Row1, r.area.Col1).String()), nil
+	case "formula", "formular1c1", "formulalocal", "formula2":
+		if r.area.Cells() == 1 {
+			return Str(r.sheet.Formula(r.area.Row1, r.area.Col1)), nil
+		}
+		v, err := r.values(in)
+		if err == nil && v.Kind == KindArray {
+			for i, cell := range v.Arr.Data {
+				v.Arr.Data[i] = Str(cell.String())
+			}
+		}
+		return v, err
+	case "hasformula":
+		for key, cell := range r.sheet.cells {
+			if r.area.Contains(key.Row, key.Col) && cell.Formula != "" {
+				return Bool(true), nil
+			}
+		}
+		return Bool(false), nil
+	case "row":
+		return Long(int64(r.area.Row1)), nil
+	case "column":
+		return Long(int64(r.area.Col1)), nil
+	case "count", "countlarge":
+		switch r.mode {
+		case modeRows:
+			return Long(int64(r.rows())), nil
+		case modeColumns:
+			return Long(int64(r.cols())), nil
+		}
+		return Long(int64(r.area.Cells())), nil
+	case "cells", "rows", "columns":
+		mode := map[string]rangeMode{"cells": modeCells, "rows": modeRows, "columns": modeColumns}[name]
+		all := &Range{sheet: r.sheet, area: r.area, mode: mode}
+		if len(args) == 0 {
+			return ObjectValue(all), nil
+		}
+		item, err := all.item(in, args)
+		if err != nil {
+			return Empty, err
+		}
+		return ObjectValue(item), nil
+	case "range":
+		v, _ := arg(args, 0)
+		area, err := ParseAddress(in.scalar(nil, v).String())
+		if err != nil {
+			return Empty, runtimeError(1004, err.Error())
+		}
+		inner, err := r.offsetArea(area.Row1-1, area.Col1-1, area.Row2-area.Row1+1, area.Col2-area.Col1+1)
+		if err != nil {
+			return Empty, err
+		}
+		return ObjectValue(inner), nil
+	case "offset":
+		rowOffset, err := argInt(scalarArgs(in, args), 0, 0)
+		if err != nil {
+			return Empty, err
+		}
+		colOffset, err := argInt(scalarArgs(in, args), 1, 0)
+		if err != nil {
+			return Empty, err
+		}
+		moved, err := r.offsetArea(rowOffset, colOffset, r.rows(), r.cols())
+		if err != nil {
+			return Empty, err
+		}
+		return ObjectValue(moved), nil
+	case "resize":
+		rows, err := argInt(scalarArgs(in, args), 0, r.rows())
+		if err != nil {
+			return Empty, err
+		}
+		cols, err := argInt(scalarArgs(in, args), 1, r.cols())
+		if err != nil {
+			return Empty, err
+		}
+		resized, err := r.offsetArea(0, 0, rows, cols)
+		if err != nil {
+			return Empty, err
+		}
+		return ObjectValue(resized), nil
+	case "end":
+		direction, err := argInt(scalarArgs(in, args), 0, 0)
+		if err != nil {
+			return Empty, err
+		}
+		cell, err := r.end(direction)
+		if err != nil {
+			return Empty, err
+		}
+		return ObjectValue(cell), nil
+	case "currentregion":
+		return ObjectValue(r.currentRegion()), nil
+	case "entirerow":
+		return ObjectValue(newRange(r.sheet, Area{r.area.Row1, 1, r.area.Row2, MaxColumns})), nil
+	case "entirecolumn":
+		return ObjectValue(newRange(r.sheet, Area{1, r.area.Col1, MaxRows, r.area.Col2})), nil
+	case "address", "addresslocal":
+		return r.address(in, args)
+	case "worksheet", "parent":
+		return ObjectValue(r.sheet), nil
+	case "clearcontents", "clear":
+		if name == "clear" {
+			in.note("formatting", "Formatting (fonts, colors, number formats, widths) is not simulated")
+		}
+		r.sheet.clear(r.area)
+		return Empty, nil
+	case "delete":
+		return Empty, r.delete(in, args)
+	case "insert":
+		return Empty, r.insert(in, args)
+	case "copy":
+		return Empty, r.copy(in, args)
+	case "filldown", "fillright":
+		return Empty, r.fill(in, name == "filldown")
+	case "select", "activate":
+		r.sheet.book.Activate(r.sheet)
+		in.app.selection = r
+		return Empty, nil
+	case "specialcells":
+		kind, err := argInt(scalarArgs(in, args), 0, 0)
+		if err != nil {
+			return Empty, err
+		}
+		if kind != 11 {
+			return Empty, unsupported("Range.SpecialCells", "only xlCellTypeLastCell is simulated")
+		}
+		used, _ := r.sheet.usedArea()
+		return ObjectValue(newRange(r.sheet, Area{used.Row2, used.Col2, used.Row2, used.Col2})), nil
+	}
+	if formattingMembers[name] {
+		in.note("formatting", "Formatting (fonts, colors, number formats, widths) is not simulated")
+		return ObjectValue(formatSink{}), nil
+	}
+	if unsupportedRangeMembers[name] {
+		return Empty, unsupported("Range."+properName(name), "")
+	}
+	return Empty, errNoMember
+}
+
+// Let implements Object
+func (r *Range) Let(in *Interpreter, name string, args []Arg, v Value) error {
+	name = strings.ToLower(name)
+	switch name {
+	case "", "value", "value2", "item":
+		if len(args) > 0 && name != "value" && name != "value2" {
+			cell, err := r.item(in, args)
+			if err != nil {
+				return err
+			}
+			return cell.setValues(in, v)
+		}
+		return r.setValues(in, v)
+	case "formula", "formular1c1", "formulalocal", "formula2":
+		return r.setFormulas(in, v)
+	}
+	if formattingMembers[name] {
+		in.note("formatting", "Formatting (fonts, colors, number formats, widths) is not simulated")
+		return nil
+	}
+	if unsupportedRangeMembers[name] {
+		return unsupported("Range."+properName(name), "")
+	}
+	return errNoMember
+}
+
+// items implements enumerable: cells row by row, or whole rows or columns
+func (r *Range) items(in *Interpreter) ([]Value, error) {
+	var items []Value
+	switch r.mode {
+	case modeRows:
+		for row := r.area.Row1; row <= r.area.Row2; row++ {
+			items = append(items, ObjectValue(newRange(r.sheet, Area{row, r.area.Col1, row, r.area.Col2})))
+		}
+		return items, nil
+	case modeColumns:
+		for col := r.area.Col1; col <= r.area.Col2; col++ {
+			items = append(items, ObjectValue(newRange(r.sheet, Area{r.area.Row1, col, r.area.Row2, col})))
+		}
+		return items, nil
+	}
+	if r.area.Cells() > maxArrayCells {
+		return nil, &RunError{Kind: ErrLimit, Message: fmt.Sprintf(
+			"looping over %d cells of %s exceeds the dry run limit of %d", r.area.Cells(), r.area.Address(false), maxArrayCells)}
+	}
+	for row := r.area.Row1; row <= r.area.Row2; row++ {
+		for col := r.area.Col1; col <= r.area.Col2; col++ {
+			items = append(items, ObjectValue(newRange(r.sheet, Area{row, col, row, col})))
+		}
+	}
+	return items, nil
+}
+
+// wholeRows and wholeColumns report whether the range spans the sheet
+func (r *Range) wholeRows() bool    { return r.area.Col1 == 1 && r.area.Col2 == MaxColumns }
+func (r *Range) wholeColumns() bool { return r.area.Row1 == 1 && r.area.Row2 == MaxRows }
+
+func (r *Range) delete(in *Interpreter, args []Arg) error {
+	shift, ok := namedArg(args, 0, "Shift")
+	up := r.rows() <= r.cols()
+	if ok {
+		n, err := toNumber(in.scalar(nil, shift))
+		if err != nil {
+			return err
+		}
+		up = n == -4162
+	}
+	switch {
+	case r.wholeRows():
+		up = true
+	case r.wholeColumns():
+		up = false
+	}
+	r.sheet.deleteCells(r.area, up)
+	return nil
+}
+
+func (r *Range) insert(in *Interpreter, args []Arg) error {
+	shift, ok := namedArg(args, 0, "Shift")
+	down := r.rows() <= r.cols()
+	if ok {
+		n, err := toNumber(in.scalar(nil, shift))
+		if err != nil {
+			return err
+		}
+		down = n == -4121
+	}
+	switch {
+	case r.wholeRows():
+		down = true
+	case r.wholeColumns():
+		down = false
+	}
+	// Excel refuses to push filled cells off the sheet
+	for key := range r.sheet.cells {
+		if down && key.Row > MaxRows-r.rows() && key.Col >= r.area.Col1 && key.Col <= r.area.Col2 ||
+			!down && key.Col > MaxColumns-r.cols() && key.Row >= r.area.Row1 && key.Row <= r.area.Row2 {
+			return runtimeError(1004, "inserting would shift filled cells off the worksheet")
+		}
+	}
+	r.sheet.insertCells(r.area, down)
+	return nil
+}
+
+func (r *Range) copy(in *Interpreter, args []Arg) error {
+	destination, ok := namedArg(args, 0, "Destination")
+	if !ok {
+		return unsupported("Range.Copy without Destination", "the clipboard is not simulated; pass Destination:= or assign .Value")
+	}
+	target, isRange := destination.Obj.(*Range)
+	if destination.Kind != KindObject || !isRange {
+		return runtimeError(424, "Destination must be a Range")
+	}
+	if r.area.Cells() > maxArrayCells {
+		return &RunError{Kind: ErrLimit, Message: fmt.Sprintf("copying %d cells exceeds the dry run limit of %d", r.area.Cells(), maxArrayCells)}
+	}
+	dest, err := target.offsetArea(0, 0, r.rows(), r.cols())
+	if err != nil {
+		return err
+	}
+
+	// Read everything first so overlapping copies behave
+	cells := map[cellKey]*Cell{}
+	for key, cell := range r.sheet.cells {
+		if r.area.Contains(key.Row, key.Col) {
+			copied := *cell
+			cells[cellKey{key.Row - r.area.Row1, key.Col - r.area.Col1}] = &copied
+			if cell.Formula != "" {
+				in.note("formula-copy", "Copied formulas keep their text; relative references are not adjusted")
+			}
+		}
+	}
+	dest.sheet.clear(dest.area)
+	for key, cell := range cells {
+		dest.sheet.cells[cellKey{dest.area.Row1 + key.Row, dest.area.Col1 + key.Col}] = cell
+	}
+	return nil
+}
+
+// fill copies the first row down, or the first column right, across the range
+func (r *Range) fill(in *Interpreter, down bool) error {
+	if r.area.Cells() > maxArrayCells {
+		return &RunError{Kind: ErrLimit, Message: fmt.Sprintf("filling %d cells exceeds the dry run limit of %d", r.area.Cells(), maxArrayCells)}
+	}
+	for row := r.area.Row1; row <= r.area.Row2; row++ {
+		for col := r.area.Col1; col <= r.area.Col2; col++ {
+			source := cellKey{r.area.Row1, col}
+			if !down {
+				source = cellKey{row, r.area.Col1}
+			}
+			if source == (cellKey{row, col}) {
+				continue
+			}
+			cell, ok := r.sheet.cells[source]
+			if !ok {
+				delete(r.sheet.cells, cellKey{row, col})
+				continue
+			}
+			if cell.Formula != "" {
+				in.note("formula-copy", "Copied formulas keep their text; relative references are not adjusted")
+			}
+			copied := *cell
+			r.sheet.cells[cellKey{row, col}] = &copied
+		}
+	}
+	return nil
+}
+
+// scalarArgs reads the default values of object arguments
+func scalarArgs(in *Interpreter, args []Arg) []Arg {
+	out := make([]Arg, len(args))
+	for i, a := range args {
+		out[i] = a
+		if !a.Missing {
+			out[i].Value = in.scalar(nil, a.Value)
+		}
+	}
+	return out
+}
+
+// properName capitalizes a lower-case member name for messages
+func properName(name string) string {
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// formatSink absorbs formatting properties, which the dry run ignores
+type formatSink struct{}
+
+// TypeName implements Object
+func (formatSink) TypeName() string { return "Object" }
+
+// Get implements Object
+func (formatSink) Get(in *Interpreter, name string, args []Arg) (Value, error) {
+	if name == "" {
+		return Empty, nil
+	}
+	return ObjectValue(formatSink{}), nil
+}
+
+// Let implements Object
+func (formatSink) Let(in *Interpreter, name string, args []Arg, v Value) error { return nil }
+
+// ----------------------------------------------------------------------------
+// Worksheets and workbooks
+
+// TypeName implements Object
+func (s *Worksheet) TypeName() string { return "Worksheet" }
+
+// rangeArg converts a Range argument given as an address or a Range object
+func (s *Worksheet) rangeArg(in *Interpreter, v Value) (Area, error) {
+	if v.Kind == KindObject {
+		cell, ok := v.Obj.(*Range)
+		if !ok {
+			return Area{}, runtimeError(13, "Range expected")
+		}
+		if cell.sheet != s {
+			return Area{}, runtimeError(1004, "the cells are on another sheet")
+		}
+		return cell.area, nil
+	}
+	area, err := excelrange.ParseArea(v.String())
+	if err != nil {
+		return Area{}, runtimeError(1004, err.Error())
+	}
+	if area.Sheet != "" && !strings.EqualFold(area.Sheet, s.Name) {
+		return Area{}, runtimeError(1004, "the address refers to sheet "+area.Sheet)
+	}
+	return Area{Row1: area.Row1, Col1: area.Col1, Row2: area.Row2, Col2: area.Col2}, nil
+}
+
+// lineArg reads Rows(n), Rows("2:5"), Columns(3), Columns("B") or Columns("A:C")
+func lineArg(v Value, rows bool) (Area, error) {
+	text := strings.ReplaceAll(v.String(), "$", "")
+	parts := strings.Split(text, ":")
+	if len(parts) > 2 {
+		return Area{}, runtimeError(1004, "invalid address "+strconv.Quote(text))
+	}
+	var bounds []int
+	for _, part := range parts {
+		n := 0
+		if number, ok := parseNumber(part); ok {
+			n = int(number)
+		} else if !rows {
+			n = columnNumber(part)
+		}
+		limit := MaxColumns
+		if rows {
+			limit = MaxRows
+		}
+		if n < 1 || n > limit {
+			return Area{}, runtimeError(1004, "invalid address "+strconv.Quote(text))
+		}
+		bounds = append(bounds, n)
+	}
+	first, last := bounds[0], bounds[len(bounds)-1]
+	if first > last {
+		first, last = last, first
+	}
+	if rows {
+		return Area{first, 1, last, MaxColumns}, nil
+	}
+	return Area{1, first, MaxRows, last}, nil
+}
+
+// Get implements Object
+func (s *Worksheet) Get(in *Interpreter, name string, args []Arg) (Value, error) {
+	if s.deleted {
+		return Empty, runtimeError(424, "the sheet was deleted")
+	}
+	name = strings.ToLower(name)
+	all := &Range{sheet: s, area: Area{1, 1, MaxRows, MaxColumns}}
+	switch name {
+	case "name", "codename":
+		return Str(s.Name), nil
+	case "index":
+		return Long(int64(s.Index())), nil
+	case "parent":
+		return ObjectValue(s.book), nil
+	case "range":
+		first, ok := arg(args, 0)
+		if !ok {
+			return Empty, runtimeError(449, "Range")
+		}
+		if first.Kind == KindObject {
+			if _, isRange := first.Obj.(*Range); !isRange {
+				first = in.scalar(nil, first)
+			}
+		}
+		area, err := s.rangeArg(in, first)
+		if err != nil {
+			return Empty, err
+		}
+		if second, ok := arg(args, 1); ok {
+			if second.Kind == KindObject {
+				if _, isRange := second.Obj.(*Range); !isRange {
+					second = in.scalar(nil, second)
+				}
+			}
+			other, err := s.rangeArg(in, second)
+			if err != nil {
+				return Empty, err
+			}
+			area = bounding(area, other)
+		}
+		return ObjectValue(newRange(s, area)), nil
+	case "cells":
+		if len(args) == 0 {
+			return ObjectValue(all), nil
+		}
+		cell, err := all.item(in, args)
+		if err != nil {
+			return Empty, err
+		}
+		return ObjectValue(cell), nil
+	case "rows", "columns":
+		rows := name == "rows"
+		lines := &Range{sheet: s, area: all.area, mode: modeColumns}
+		if rows {
+			lines.mode = modeRows
+		}
+		if len(args) == 0 {
+			return ObjectValue(lines), nil
+		}
+		v := in.scalar(nil, args[0].Value)
+		if v.Kind == KindString {
+			if _, numeric := parseNumber(v.Str); !numeric {
+				area, err := lineArg(v, rows)
+				if err != nil {
+					return Empty, err
+				}
+				return ObjectValue(&Range{sheet: s, area: area, mode: lines.mode}), nil
+			}
+		}
+		line, err := lines.item(in, []Arg{{Value: v}})
+		if err != nil {
+			return Empty, err
+		}
+		return ObjectValue(line), nil
+	case "usedrange":
+		area, _ := s.usedArea()
+		return ObjectValue(newRange(s, area)), nil
+	case "activate", "select":
+		s.book.Activate(s)
+		return Empty, nil
+	case "delete":
+		if !s.book.RemoveSheet(s) {
+			return Empty, runtimeError(1004, "a workbook must contain at least one sheet")
+		}
+		in.note("sheet-delete", "Deleting a sheet asks for confirmation unless Application.DisplayAlerts is False")
+		return Empty, nil
+	case "copy":
+		sheets := &sheetsObject{book: s.book}
+		copied, err := sheets.add(in, args, copyName(s.book, s.Name))
+		if err != nil {
+			return Empty, err
+		}
+		for key, cell := range s.cells {
+			c := *cell
+			copied.cells[key] = &c
+		}
+		return Empty, nil
+	case "calculate", "protect", "unprotect", "showalldata":
+		in.note("sheet-"+name, "Worksheet."+properName(name)+" has no effect in the dry run")
+		return Empty, nil
+	case "autofiltermode":
+		return Bool(false), nil
+	case "listobjects", "pivottables", "shapes", "chartobjects", "hyperlinks", "querytables", "move", "paste",
+		"printout", "autofilter", "sort", "evaluate":
+		return Empty, unsupported("Worksheet."+properName(name), "")
+	}
+	if formattingMembers[name] {
+		in.note("formatting", "Formatting (fonts, colors, number formats, widths) is not simulated")
+		return ObjectValue(formatSink{}), nil
+	}
+	return Empty, errNoMember
+}
+
+// Let implements Object
+func (s *Worksheet) Let(in *Interpreter, name string, args []Arg, v Value) error {
+	if s.deleted {
+		return runtimeError(424, "the sheet was deleted")
+	}
+	name = strings.ToLower(name)
+	switch name {
+	case "name":
+		newName := v.String()
+		if err := validSheetName(newName); err != nil {
+			return err
+		}
+		if other := s.book.Sheet(newName); other != nil && other != s {
+			return runtimeError(1004, "a sheet named "+strconv.Quote(newName)+" already exists")
+		}
+		s.Name = newName
+		return nil
+	case "autofiltermode":
+		return nil
+	}
+	if formattingMembers[name] {
+		in.note("formatting", "Formatting (fonts, colors, number formats, widths) is not simulated")
+		return nil
+	}
+	return errNoMember
+}
+
+// validSheetName applies Excel's rules for sheet names
+func validSheetName(name string) error {
+	switch {
+	case name == "":
+		return runtimeError(1004, "a sheet name cannot be empty")
+	case len([]rune(name)) > 31:
+		return runtimeError(1004, "a sheet name cannot be longer than 31 characters")
+	case strings.ContainsAny(name, `[]:*?/\`):
+		return runtimeError(1004, "a sheet name cannot contain [ ] : * ? / \\")
+	case strings.HasPrefix(name, "'") || strings.HasSuffix(name, "'"):
+		return runtimeError(1004, "a sheet name cannot start or end with an apostrophe")
+	}
+	return nil
+}
+
+// copyName returns the name Excel gives a copy of a sheet: "Data (2)"
+func copyName(book *Workbook, name string) string {
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)", name, n)
+		if book.Sheet(candidate) == nil {
+			return candidate
+		}
+	}
+}
+
+// bounding returns the smallest area containing both areas
+func bounding(a, b Area) Area {
+	if b.Row1 < a.Row1 {
+		a.Row1 = b.Row1
+	}
+	if b.Col1 < a.Col1 {
+		a.Col1 = b.Col1
+	}
+	if b.Row2 > a.Row2 {
+		a.Row2 = b.Row2
+	}
+	if b.Col2 > a.Col2 {
+		a.Col2 = b.Col2
+	}
+	return a
+}
+
+// sheetsObject is the Worksheets and Sheets collection of a workbook
+type sheetsObject struct {
+	book *Workbook
+}
+
+// TypeName implements Object
+func (c *sheetsObject) TypeName() string { return "Sheets" }
+
+// item finds a sheet by 1-based index or name
+func (c *sheetsObject) item(in *Interpreter, v Value) (*Worksheet, error) {
+	v = in.scalar(nil, v)
+	if v.Kind == KindString {
+		if sheet := c.book.Sheet(v.Str); sheet != nil {
+			return sheet, nil
+		}
+		return nil, runtimeError(9, "no sheet named "+strconv.Quote(v.Str))
+	}
+	n, err := toNumber(v)
+	if err != nil {
+		return nil, err
+	}
+	i := int(n)
+	if i < 1 || i > len(c.book.Sheets) {
+		return nil, runtimeError(9, fmt.Sprintf("sheet index %d", i))
+	}
+	return c.book.Sheets[i-1], nil
+}
+
+// add implements Sheets.Add and Worksheet.Copy: the new sheet goes before
+// or after a given sheet, or before the active sheet
+func (c *sheetsObject) add(in *Interpreter, args []Arg, name string) (*Worksheet, error) {
+	position := c.book.ActiveSheet().Index() - 1
+	if before, ok := namedArg(args, 0, "Before"); ok {
+		sheet, isSheet := before.Obj.(*Worksheet)
+		if before.Kind != KindObject || !isSheet {
+			return nil, runtimeError(13, "Before must be a sheet")
+		}
+		position = sheet.Index() - 1
+	}
+	if after, ok := namedArg(args, 1, "After"); ok {
+		sheet, isSheet := after.Obj.(*Worksheet)
+		if after.Kind != KindObject || !isSheet {
+			return nil, runtimeError(13, "After must be a sheet")
+		}
+		position = sheet.Index()
+	}
+	if count, ok := namedArg(args, 2, "Count"); ok {
+		if n, _ := toNumber(in.scalar(nil, count)); n != 1 {
+			return nil, unsupported("Sheets.Add with Count", "add one sheet at a time")
+		}
+	}
+
+	sheet := c.book.AddSheet(name)
+	copy(c.book.Sheets[position+1:], c.book.Sheets[position:len(c.book.Sheets)-1])
+	c.book.Sheets[position] = sheet
+	c.book.Activate(sheet)
+	return sheet, nil
+}
+
+// Get implements Object
+func (c *sheetsObject) Get(in *Interpreter, name string, args []Arg) (Value, error) {
+	switch strings.ToLower(name) {
+	case "", "item":
+		v, ok := arg(args, 0)
+		if !ok {
+			return Empty, runtimeError(449, "Item")
+		}
+		sheet, err := c.item(in, v)
+		if err != nil {
+			return Empty, err
+		}
+		return ObjectValue(sheet), nil
+	case "count":
+		return Long(int64(len(c.book.Sheets))), nil
+	case "add":
+		sheet, err := c.add(in, args, "")
+		if err != nil {
+			return Empty, err
+		}
+		return ObjectValue(sheet), nil
+	case "parent":
+		return ObjectValue(c.book), nil
+	case "select":
+		return Empty, nil
+	}
+	return Empty, errNoMember
+}
+
+// Let implements Object
+func (c *sheetsObject) Let(in *Interpreter, name string, args []Arg, v Value) error {
+	return errNoMember
+}
+
+func (c *sheetsObject) items(in *Interpreter) ([]Value, error) {
+	var items []Value
+	for _, sheet := range c.book.Sheets {
+		items = append(items, ObjectValue(sheet))
+	}
+	return items, nil
+}
+
+// TypeName implements Object
+func (b *Workbook) TypeName() string { return "Workbook" }
+
+// Get implements Object
+func (b *Workbook) Get(in *Interpreter, name string, args []Arg) (Value, error) {
+	name = strings.ToLower(name)
+	switch name {
+	case "worksheets", "sheets":
+		sheets := &sheetsObject{book: b}
+		if len(args) == 0 {
+			return ObjectValue(sheets), nil
+		}
+		return sheets.Get(in, "", args)
+	case "name", "fullname":
+		return Str(b.Name), nil
+	case "path":
+		return Str(""), nil
+	case "activesheet":
+		return ObjectValue(b.ActiveSheet()), nil
+	case "save", "close", "saveas", "savecopyas", "refreshall", "activate":
+		if name != "activate" {
+			in.note("workbook-"+name, "Workbook."+properName(name)+" was skipped; the dry run never writes files")
+		}
+		return Empty, nil
+	case "saved":
+		return Bool(false), nil
+	}
+	return Empty, errNoMember
+}
+
+// Let implements Object
+func (b *Workbook) Let(in *Interpreter, name string, args []Arg, v Value) error {
+	if strings.EqualFold(name, "saved") {
+		return nil
+	}
+	return errNoMember
+}
+
+// workbooksObject is Application.Workbooks, holding the one mock workbook
+type workbooksObject struct {
+	book *Workbook
+}
+
+// TypeName implements Object
+func (w workbooksObject) TypeName() string { return "Workbooks" }
+
+// Get implements Object
+func (w workbooksObject) Get(in *Interpreter, name string, args []Arg) (Value, error) {
+	switch strings.ToLower(name) {
+	case "", "item":
+		v, _ := arg(args, 0)
+		v = in.scalar(nil, v)
+		if n, err := toNumber(v); (err == nil && n == 1) || strings.EqualFold(v.String(), w.book.Name) {
+			return ObjectValue(w.book), nil
+		}
+		return Empty, runtimeError(9, "only the macro's own workbook is open in the dry run")
+	case "count":
+		return Long(1), nil
+	case "open", "add":
+		return Empty, unsupported("Workbooks."+properName(strings.ToLower(name)), "the dry run only has the macro's own workbook")
+	}
+	return Empty, errNoMember
+}
+
+// Let implements Object
+func (w workbooksObject) Let(in *Interpreter, name string, args []Arg, v Value) error {
+	return errNoMember
+}
+
+func (w workbooksObject) items(in *Interpreter) ([]Value, error) {
+	return []Value{ObjectValue(w.book)}, nil
+}
+
+// ----------------------------------------------------------------------------
+// Application
+
+// application is the Excel Application object
+type application struct {
+	in        *Interpreter
+	settings  map[string]Value
+	selection *Range
+}
+
+// applicationSettings are properties a macro may set and read back
+var applicationSettings = map[string]Value{
+	"screenupdating": Bool(true), "displayalerts": Bool(true), "enableevents": Bool(true),
+	"calculation": Long(-4105), "statusbar": Bool(false), "cutcopymode": Bool(false),
+	"displaystatusbar": Bool(true), "asktoupdatelinks": Bool(true), "enablecancelkey": Long(1),
+	"cursor": Long(-4143), "printcommunication": Bool(true), "iteration": Bool(false),
+}
+
+// TypeName implements Object
+func (a *application) TypeName() string { return "Application" }
+
+// Get implements Object
+func (a *application) Get(in *Interpreter, name string, args []Arg) (Value, error) {
+	name = strings.ToLower(name)
+	book := in.book
+	switch name {
+	case "activesheet":
+		return ObjectValue(book.ActiveSheet()), nil
+	case "activeworkbook", "thisworkbook":
+		return ObjectValue(book), nil
+	case "worksheets", "sheets":
+		return book.Get(in, name, args)
+	case "workbooks":
+		books := workbooksObject{book: book}
+		if len(args) == 0 {
+			return ObjectValue(books), nil
+		}
+		return books.Get(in, "", args)
+	case "range", "cells", "rows", "columns":
+		return book.ActiveSheet().Get(in, name, args)
+	case "selection":
+		if a.selection == nil || a.selection.sheet.deleted {
+			return ObjectValue(newRange(book.ActiveSheet(), Area{1, 1, 1, 1})), nil
+		}
+		return ObjectValue(a.selection), nil
+	case "activecell":
+		if a.selection == nil || a.selection.sheet.deleted {
+			return ObjectValue(newRange(book.ActiveSheet(), Area{1, 1, 1, 1})), nil
+		}
+		return ObjectValue(newRange(a.selection.sheet, Area{a.selection.area.Row1, a.selection.area.Col1, a.selection.area.Row1, a.selection.area.Col1})), nil
+	case "worksheetfunction":
+		return ObjectValue(worksheetFunction{}), nil
+	case "intersect":
+		return a.intersect(in, args)
+	case "union":
+		return Empty, unsupported("Union", "ranges with several areas are not simulated")
+	case "inputbox":
+		v, err := inputBox(in, scalarArgs(in, args))
+		if kind, ok := namedArg(args, 6, "Type"); ok && err == nil {
+			if n, _ := toNumber(kind); int(n)&1 == 1 {
+				number, ok := parseNumber(v.Str)
+				if !ok {
+					return Bool(false), nil
+				}
+				return Double(number), nil
+			}
+		}
+		return v, err
+	case "calculate", "calculatefull":
+		return Empty, nil
+	case "version":
+		return Str("16.0"), nil
+	case "name":
+		return Str("Microsoft Excel"), nil
+	case "username":
+		return Str("User"), nil
+	case "wait", "ontime", "run", "quit", "getopenfilename", "getsaveasfilename", "filedialog", "sendkeys",
+		"evaluate", "caller", "onkey", "executeexcel4macro":
+		return Empty, unsupported("Application."+properName(name), "")
+	}
+	if v, ok := a.settings[name]; ok {
+		return v, nil
+	}
+	if v, ok := applicationSettings[name]; ok {
+		return v, nil
+	}
+	if worksheetFunctions[name] != nil {
+		return worksheetFunction{lenient: true}.Get(in, name, args)
+	}
+	return Empty, errNoMember
+}
+
+// Let implements Object
+func (a *application) Let(in *Interpreter, name string, args []Arg, v Value) error {
+	name = strings.ToLower(name)
+	if _, ok := applicationSettings[name]; ok {
+		a.settings[name] = v
+		return nil
+	}
+	return errNoMember
+}
+
+// intersect implements Application.Intersect
+func (a *application) intersect(in *Interpreter, args []Arg) (Value, error) {
+	var result *Range
+	for _, argument := range args {
+		if argument.Missing {
+			continue
+		}
+		r, ok := argument.Value.Obj.(*Range)
+		if argument.Value.Kind != KindObject || !ok {
+			return Empty, runtimeError(13, "Intersect needs ranges")
+		}
+		if result == nil {
+			result = r
+			continue
+		}
+		if r.sheet != result.sheet {
+			return Empty, runtimeError(1004, "Intersect of ranges on different sheets")
+		}
+		area := Area{result.area.Row1, result.area.Col1, result.area.Row2, result.area.Col2}
+		if r.area.Row1 > area.Row1 {
+			area.Row1 = r.area.Row1
+		}
+		if r.area.Col1 > area.Col1 {
+			area.Col1 = r.area.Col1
+		}
+		if r.area.Row2 < area.Row2 {
+			area.Row2 = r.area.Row2
+		}
+		if r.area.Col2 < area.Col2 {
+			area.Col2 = r.area.Col2
+		}
+		if area.Row1 > area.Row2 || area.Col1 > area.Col2 {
+			return Nothing, nil
+		}
+		result = newRange(r.sheet, area)
+	}
+	if result == nil {
+		return Empty, runtimeError(449, "Intersect")
+	}
+	return ObjectValue(result), nil
+}
+
+// ----------------------------------------------------------------------------
+// VBA library objects
+
+// collection is a VBA Collection
+type collection struct {
+	values []Value
+	keys   []string // Lower-case key of each item; "" when added without one
+}
+
+// TypeName implements Object
+func (c *collection) TypeName() string { return "Collection" }
+
+// find returns the 0-based position of an item given by 1-based index or key
+func (c *collection) find(in *Interpreter, v Value) (int, error) {
+	v = in.scalar(nil, v)
+	if v.Kind == KindString {
+		key := strings.ToLower(v.Str)
+		for i, k := range c.keys {
+			if k == key && key != "" {
+				return i, nil
+			}
+		}
+		return -1, runtimeError(5, "no item with key "+strconv.Quote(v.Str))
+	}
+	n, err := toNumber(v)
+	if err != nil {
+		return -1, err
+	}
+	i := int(n)
+	if i < 1 || i > len(c.values) {
+		return -1, runtimeError(9, "")
+	}
+	return i - 1, nil
+}
+
+// Get implements Object
+func (c *collection) Get(in *Interpreter, name string, args []Arg) (Value, error) {
+	switch strings.ToLower(name) {
+	case "", "item":
+		v, ok := arg(args, 0)
+		if !ok {
+			return Empty, runtimeError(449, "Item")
+		}
+		i, err := c.find(in, v)
+		if err != nil {
+			return Empty, err
+		}
+		return c.values[i], nil
+	case "count":
+		return Long(int64(len(c.values))), nil
+	case "add":
+		item, ok := namedArg(args, 0, "Item")
+		if !ok {
+			return Empty, runtimeError(449, "Add")
+		}
+		key := ""
+		if k, ok := namedArg(args, 1, "Key"); ok {
+			k = in.scalar(nil, k)
+			if k.Kind != KindString {
+				return Empty, runtimeError(13, "Collection keys must be strings")
+			}
+			key = strings.ToLower(k.Str)
+			for _, existing := range c.keys {
+				if existing == key {
+					return Empty, runtimeError(457, "")
+				}
+			}
+		}
+		position := len(c.values)
+		if before, ok := namedArg(args, 2, "Before"); ok {
+			i, err := c.find(in, before)
+			if err != nil {
+				return Empty, err
+			}
+			position = i
+		} else if after, ok := namedArg(args, 3, "After"); ok {
+			i, err := c.find(in, after)
+			if err != nil {
+				return Empty, err
+			}
+			position = i + 1
+		}
+		c.values = append(c.values[:position], append([]Value{item}, c.values[position:]...)...)
+		c.keys = append(c.keys[:position], append([]string{key}, c.keys[position:]...)...)
+		return Empty, nil
+	case "remove":
+		v, ok := arg(args, 0)
+		if !ok {
+			return Empty, runtimeError(449, "Remove")
+		}
+		i, err := c.find(in, v)
+		if err != nil {
+			return Empty, err
+		}
+		c.values = append(c.values[:i], c.values[i+1:]...)
+		c.keys = append(c.keys[:i], c.keys[i+1:]...)
+		return Empty, nil
+	}
+	return Empty, errNoMember
+}
+
+// Let implements Object
+func (c *collection) Let(in *Interpreter, name string, args []Arg, v Value) error {
+	return errNoMember
+}
+
+func (c *collection) items(in *Interpreter) ([]Value, error) {
+	return append([]Value(nil), c.values...), nil
+}
+
+// dictionary is a Scripting.Dictionary, keeping keys in insertion order
+type dictionary struct {
+	keys        []Value
+	values      []Value
+	index       map[string]int
+	textCompare bool
+}
+
+func newDictionary() *dictionary {
+	return &dictionary{index: map[string]int{}}
+}
+
+// TypeName implements Object
+func (d *dictionary) TypeName() string { return "Dictionary" }
+
+// key returns the lookup form of a key; numbers and strings never match
+func (d *dictionary) key(v Value) string {
+	switch v.Kind {
+	case KindString:
+		if d.textCompare {
+			return "s:" + strings.ToLower(v.Str)
+		}
+		return "s:" + v.Str
+	case KindLong, KindDouble:
+		return "n:" + formatNumber(v.Num)
+	case KindObject:
+		return fmt.Sprintf("o:%p", v.Obj)
+	}
+	return fmt.Sprintf("%d:%v", v.Kind, v.Num)
+}
+
+func (d *dictionary) reindex() {
+	d.index = map[string]int{}
+	for i, k := range d.keys {
+		d.index[d.key(k)] = i
+	}
+}
+
+func (d *dictionary) set(key, v Value) {
+	if i, ok := d.index[d.key(key)]; ok {
+		d.values[i] = v
+		return
+	}
+	d.index[d.key(key)] = len(d.keys)
+	d.keys = append(d.keys, key)
+	d.values = append(d.values, v)
+}
+
+// Get implements Object
+func (d *dictionary) Get(in *Interpreter, name string, args []Arg) (Value, error) {
+	name = strings.ToLower(name)
+	key, hasKey := arg(args, 0)
+	if hasKey && key.Kind == KindObject {
+		if _, isRange := key.Obj.(*Range); isRange {
+			in.note("dictionary-range-key", "A Range was used as a Dictionary key; the object, not its value, is the key, as in Excel")
+		}
+	}
+	switch name {
+	case "", "item":
+		if !hasKey {
+			return Empty, runtimeError(449, "Item")
+		}
+		i, ok := d.index[d.key(key)]
+		if !ok {
+			// Reading a missing key adds it, as Scripting.Dictionary does
+			d.set(key, Empty)
+			return Empty, nil
+		}
+		return d.values[i], nil
+	case "exists":
+		_, ok := d.index[d.key(key)]
+		return Bool(ok), nil
+	case "add":
+		item, ok := arg(args, 1)
+		if !hasKey || !ok {
+			return Empty, runtimeError(449, "Add")
+		}
+		if _, exists := d.index[d.key(key)]; exists {
+			return Empty, runtimeError(457, "")
+		}
+		d.set(key, item)
+		return Empty, nil
+	case "count":
+		return Long(int64(len(d.keys))), nil
+	case "keys":
+		return ArrayValue(ListArray(0, append([]Value(nil), d.keys...))), nil
+	case "items":
+		return ArrayValue(ListArray(0, append([]Value(nil), d.values...))), nil
+	case "remove":
+		i, ok := d.index[d.key(key)]
+		if !ok {
+			return Empty, runtimeError(32811, "key not found")
+		}
+		d.keys = append(d.keys[:i], d.keys[i+1:]...)
+		d.values = append(d.values[:i], d.values[i+1:]...)
+		d.reindex()
+		return Empty, nil
+	case "removeall":
+		d.keys, d.values = nil, nil
+		d.reindex()
+		return Empty, nil
+	case "comparemode":
+		if d.textCompare {
+			return Long(1), nil
+		}
+		return Long(0), nil
+	}
+	return Empty, errNoMember
+}
+
+// Let implements Object
+func (d *dictionary) Let(in *Interpreter, name string, args []Arg, v Value) error {
+	switch strings.ToLower(name) {
+	case "", "item":
+		key, ok := arg(args, 0)
+		if !ok {
+			return runtimeError(449, "Item")
+		}
+		d.set(key, v)
+		return nil
+	case "comparemode":
+		if len(d.keys) > 0 {
+			return runtimeError(5, "CompareMode can only change while the dictionary is empty")
+		}
+		n, err := toNumber(v)
+		d.textCompare = n == 1
+		return err
+	case "key":
+		old, ok := arg(args, 0)
+		if !ok {
+			return runtimeError(449, "Key")
+		}
+		i, exists := d.index[d.key(old)]
+		if !exists {
+			return runtimeError(32811, "key not found")
+		}
+		if _, taken := d.index[d.key(v)]; taken {
+			return runtimeError(457, "")
+		}
+		d.keys[i] = v
+		d.reindex()
+		return nil
+	}
+	return errNoMember
+}
+
+func (d *dictionary) items(in *Interpreter) ([]Value, error) {
+	return append([]Value(nil), d.keys...), nil
+}
+
+// errObject is the VBA Err object
+type errObject struct {
+	number      int
+	description string
+	source      string
+}
+
+// set records a trapped run-time error
+func (e *errObject) set(err *RunError) {
+	e.number = err.Number
+	e.description = runtimeMessages[err.Number]
+	if e.description == "" || err.Construct == "raise" {
+		e.description = err.Message
+	}
+	e.source = "VBAProject"
+}
+
+func (e *errObject) clear() {
+	*e = errObject{}
+}
+
+// TypeName implements Object
+func (e *errObject) TypeName() string { return "ErrObject" }
+
+// Get implements Object
+func (e *errObject) Get(in *Interpreter, name string, args []Arg) (Value, error) {
+	switch strings.ToLower(name) {
+	case "", "number":
+		return Long(int64(e.number)), nil
+	case "description":
+		return Str(e.description), nil
+	case "source":
+		return Str(e.source), nil
+	case "clear":
+		e.clear()
+		return Empty, nil
+	case "raise":
+		args = scalarArgs(in, args)
+		v, ok := namedArg(args, 0, "Number")
+		if !ok {
+			return Empty, runtimeError(449, "Raise")
+		}
+		n, err := toNumber(v)
+		if err != nil {
+			return Empty, err
+		}
+		message := runtimeMessages[int(n)]
+		if description, ok := namedArg(args, 2, "Description"); ok {
+			message = description.String()
+		}
+		if message == "" {
+			message = "Application-defined or object-defined error"
+		}
+		return Empty, &RunError{Kind: ErrRuntime, Number: int(n), Message: message, Construct: "raise"}
+	}
+	return Empty, errNoMember
+}
+
+// Let implements Object
+func (e *errObject) Let(in *Interpreter, name string, args []Arg, v Value) error {
+	switch strings.ToLower(name) {
+	case "", "number":
+		n, err := toNumber(v)
+		e.number = int(n)
+		return err
+	case "description":
+		e.description = v.String()
+		return nil
+	case "source":
+		e.source = v.String()
+		return nil
+	}
+	return errNoMember
+}
+
+// debugObject is the VBA Debug object; Debug.Print is handled by the
+// interpreter because it needs the separators from the source
+type debugObject struct{}
+
+// TypeName implements Object
+func (debugObject) TypeName() string { return "Debug" }
+
+// Get implements Object
+func (debugObject) Get(in *Interpreter, name string, args []Arg) (Value, error) {
+	switch strings.ToLower(name) {
+	case "print":
+		parts := make([]string, len(args))
+		for i, a := range scalarArgs(in, args) {
+			parts[i] = a.Value.String()
+		}
+		in.output = append(in.output, strings.Join(parts, " "))
+		return Empty, nil
+	case "assert":
+		v, _ := arg(args, 0)
+		n, err := toNumber(in.scalar(nil, v))
+		if err != nil {
+			return Empty, err
+		}
+		if n == 0 {
+			in.note("assert", "Debug.Assert failed, which breaks into the debugger; the run stopped there")
+			panic(endRun{})
+		}
+		return Empty, nil
+	}
+	return Empty, errNoMember
+}
+
+// Let implements Object
+func (debugObject) Let(in *Interpreter, name string, args []Arg, v Value) error {
+	return errNoMember
+}
+
+// sortedKeys returns the cells of an area in row-major order
+func (s *Worksheet) sortedKeys(area Area) []cellKey {
+	var keys []cellKey
+	for key := range s.cells {
+		if area.Contains(key.Row, key.Col) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Row != keys[j].Row {
+			return keys[i].Row < keys[j].Row
+		}
+		return keys[i].Col < keys[j].Col
+	})
+	return keys
+}
@@ -0,0 +1,266 @@
+package dryrun
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind is the subtype of a Variant
+type Kind int
+
+const (
+	KindEmpty   Kind = iota // Uninitialized Variant
+	KindNull                // Null
+	KindBoolean             // Boolean
+	KindLong                // Byte, Integer or Long
+	KindDouble              // Single, Double or Currency
+	KindString              // String
+	KindDate                // Date, stored as an OLE Automation serial
+	KindObject              // Object reference, including Nothing
+	KindArray               // Array
+	KindError               // Error value such as #N/A; Num holds the error number
+)
+
+var kindNames = map[Kind]string{
+	KindEmpty:   "Empty",
+	KindNull:    "Null",
+	KindBoolean: "Boolean",
+	KindLong:    "Long",
+	KindDouble:  "Double",
+	KindString:  "String",
+	KindDate:    "Date",
+	KindObject:  "Object",
+	KindArray:   "Variant()",
+	KindError:   "Error",
+}
+
+// String returns the VBA type name of the kind
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+// Value is a VBA Variant
+type Value struct {
+	Kind Kind
+	Num  float64 // Boolean (-1 or 0), Long, Double and Date
+	Str  string
+	Obj  Object // nil is Nothing
+	Arr  *Array
+
+	missing bool // Omitted Optional Variant argument, for IsMissing
+}
+
+var (
+	Empty   = Value{Kind: KindEmpty}
+	Null    = Value{Kind: KindNull}
+	Nothing = Value{Kind: KindObject}
+)
+
+// Long returns an integer value
+func Long(n int64) Value { return Value{Kind: KindLong, Num: float64(n)} }
+
+// Double returns a floating point value
+func Double(n float64) Value { return Value{Kind: KindDouble, Num: n} }
+
+// Str returns a string value
+func Str(s string) Value { return Value{Kind: KindString, Str: s} }
+
+// Bool returns a Boolean value
+func Bool(b bool) Value {
+	if b {
+		return Value{Kind: KindBoolean, Num: -1}
+	}
+	return Value{Kind: KindBoolean}
+}
+
+// DateValue returns a date value
+func DateValue(t time.Time) Value { return Value{Kind: KindDate, Num: dateSerial(t)} }
+
+// ObjectValue wraps an object reference
+func ObjectValue(obj Object) Value { return Value{Kind: KindObject, Obj: obj} }
+
+// ArrayValue wraps an array
+func ArrayValue(arr *Array) Value { return Value{Kind: KindArray, Arr: arr} }
+
+// IsNumeric reports whether the value is a number, Boolean or date
+func (v Value) IsNumeric() bool {
+	return v.Kind == KindLong || v.Kind == KindDouble || v.Kind == KindBoolean || v.Kind == KindDate
+}
+
+// IsNothing reports whether the value is an object reference to Nothing
+func (v Value) IsNothing() bool {
+	return v.Kind == KindObject && v.Obj == nil
+}
+
+// String converts the value the way CStr does. Objects and arrays, which
+// CStr rejects, are described instead.
+func (v Value) String() string {
+	switch v.Kind {
+	case KindEmpty:
+		return ""
+	case KindNull:
+		return "Null"
+	case KindBoolean:
+		if v.Num != 0 {
+			return "True"
+		}
+		return "False"
+	case KindLong, KindDouble:
+		return formatNumber(v.Num)
+	case KindString:
+		return v.Str
+	case KindDate:
+		return formatDate(v.Num)
+	case KindObject:
+		if v.Obj == nil {
+			return "Nothing"
+		}
+		return "<" + v.Obj.TypeName() + ">"
+	case KindArray:
+		return "<Array>"
+	case KindError:
+		return "Error " + strconv.Itoa(int(v.Num))
+	}
+	return ""
+}
+
+// formatNumber writes a number with up to 15 significant digits as VBA does
+func formatNumber(n float64) string {
+	if n == math.Trunc(n) && math.Abs(n) < 1e15 {
+		return strconv.FormatFloat(n, 'f', 0, 64)
+	}
+	s := strconv.FormatFloat(n, 'G', 15, 64)
+	if i := strings.Index(s, "E"); i >= 0 {
+		mantissa, exp := s[:i], s[i+1:]
+		sign := "+"
+		if exp[0] == '-' || exp[0] == '+' {
+			sign, exp = exp[:1], exp[1:]
+		}
+		for len(exp) < 2 {
+			exp = "0" + exp
+		}
+		return mantissa + "E" + sign + exp
+	}
+	return s
+}
+
+// oleEpoch is day zero of OLE Automation dates
+var oleEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+func dateSerial(t time.Time) float64 {
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	days := math.Floor(t.Sub(oleEpoch).Hours() / 24)
+	midnight := oleEpoch.AddDate(0, 0, int(days))
+	fraction := t.Sub(midnight).Seconds() / 86400
+	if days < 0 {
+		// Negative serials count days back but the time forward
+		return days - fraction
+	}
+	return days + fraction
+}
+
+func serialTime(serial float64) time.Time {
+	days := math.Trunc(serial)
+	fraction := math.Abs(serial - days)
+	seconds := math.Round(fraction * 86400)
+	return oleEpoch.AddDate(0, 0, int(days)).Add(time.Duration(seconds) * time.Second)
+}
+
+// formatDate writes a date serial as CStr does in the US English locale
+func formatDate(serial float64) string {
+	t := serialTime(serial)
+	hasTime := t.Hour() != 0 || t.Minute() != 0 || t.Second() != 0
+	switch {
+	case math.Trunc(serial) == 0 && hasTime:
+		return t.Format("3:04:05 PM")
+	case hasTime:
+		return t.Format("1/2/2006 3:04:05 PM")
+	}
+	return t.Format("1/2/2006")
+}
+
+// dateLayouts are the date formats accepted by CDate and DateValue
+var dateLayouts = []string{
+	"1/2/2006", "1/2/2006 15:04", "1/2/2006 15:04:05", "1/2/2006 3:04:05 PM", "1/2/2006 3:04 PM",
+	"2006-01-02", "2006-01-02 15:04", "2006-01-02 15:04:05", "2006-01-02T15:04:05",
+	"2006/01/02", "2006/1/2", "2-Jan-2006", "2-Jan-06", "January 2, 2006", "Jan 2, 2006",
+	"2 January 2006", "15:04:05", "15:04", "3:04:05 PM", "3:04 PM",
+}
+
+func parseDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			if t.Year() == 0 {
+				// Time only
+				return oleEpoch.Add(time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+					time.Duration(t.Second())*time.Second), true
+			}
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseNumber converts text the way VBA converts strings to numbers,
+// accepting thousands separators, a currency sign and &H hexadecimal
+func parseNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+	upper := strings.ToUpper(s)
+	if strings.HasPrefix(upper, "&H") {
+		n, err := strconv.ParseInt(upper[2:], 16, 64)
+		return float64(n), err == nil
+	}
+	if strings.HasPrefix(upper, "&O") {
+		n, err := strconv.ParseInt(upper[2:], 8, 64)
+		return float64(n), err == nil
+	}
+
+	negative := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		s, negative = s[1:len(s)-1], true
+	}
+	if strings.HasPrefix(s, "-") {
+		s, negative = s[1:], !negative
+	}
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "+"), "$")
+	s = strings.ReplaceAll(s, ",", "")
+	if s == "" || strings.ContainsAny(s, " _") || strings.HasPrefix(strings.ToLower(s), "in") || strings.HasPrefix(strings.ToLower(s), "na") {
+		// Reject Inf and NaN, which ParseFloat would accept
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	if negative {
+		n = -n
+	}
+	return n, true
+}
+
+// TypeName returns the result of the VBA TypeName function
+func (v Value) TypeName() string {
+	switch v.Kind {
+	case KindObject:
+		if v.Obj == nil {
+			return "Nothing"
+		}
+		return v.Obj.TypeName()
+	case KindArray:
+		if v.Arr.Type != "" && v.Arr.Type != "Variant" {
+			return v.Arr.Type + "()"
+		}
+		return "Variant()"
+	case KindLong:
+		if v.Num >= math.MinInt16 && v.Num <= math.MaxInt16 {
+			return "Integer"
+		}
+	}
+	return v.Kind.String()
+}
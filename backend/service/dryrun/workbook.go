@@ -0,0 +1,507 @@
+package dryrun
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"excel-automation-mcp/backend/service/excelrange"
+	"excel-automation-mcp/backend/service/mcp"
+)
+
+const (
+	MaxRows    = excelrange.MaxRows    // Rows in a worksheet
+	MaxColumns = excelrange.MaxColumns // Columns in a worksheet (XFD)
+)
+
+// cellKey addresses a cell by 1-based row and column
+type cellKey struct {
+	Row, Col int
+}
+
+// Cell is the content of one worksheet cell
+type Cell struct {
+	Value   Value
+	Formula string // Formula text when the macro wrote a formula; it is not calculated
+}
+
+// Workbook is the in-memory workbook a macro runs against
+type Workbook struct {
+	Name   string
+	Sheets []*Worksheet
+	active int
+	nextID int
+}
+
+// Worksheet is one sheet of the mock workbook
+type Worksheet struct {
+	Name     string
+	book     *Workbook
+	id       int // Stable identity across renames, used by the diff
+	cells    map[cellKey]*Cell
+	deleted  bool
+	original string // Name before the macro ran; "" for sheets the macro added
+}
+
+// NewWorkbook creates an empty workbook with no sheets
+func NewWorkbook(name string) *Workbook {
+	if name == "" {
+		name = "Book1.xlsm"
+	}
+	return &Workbook{Name: name}
+}
+
+// WorkbookFromRanges builds a workbook from analyzed data ranges. Each range
+// is placed at its address on its sheet: the headers in the first row when
+// the range has them (or the group and column labels of a multi-row header),
+// followed by the sample rows. Values are typed using
+// the column data types.
+func WorkbookFromRanges(ranges ...mcp.DataRange) *Workbook {
+	book := NewWorkbook("")
+	for _, structure := range ranges {
+		name := structure.SheetName
+		if name == "" {
+			name = "Sheet1"
+		}
+		sheet := book.Sheet(name)
+		if sheet == nil {
+			sheet = book.AddSheet(name)
+		}
+
+		row, col := 1, 1
+		if area, err := ParseAddress(structure.RangeAddress); err == nil {
+			row, col = area.Row1, area.Col1
+		}
+		if structure.HeaderRows > 1 {
+			// Group labels sit over their first column, column labels below
+			for _, group := range structure.HeaderGroups {
+				sheet.SetValue(row+group.Level, col+group.FirstColumn, Str(group.Label))
+			}
+			for i, label := range mcp.ColumnLabels(structure) {
+				if label != "" {
+					sheet.SetValue(row+structure.HeaderRows-1, col+i, Str(label))
+				}
+			}
+			row += structure.HeaderRows
+		} else if structure.HasHeaders || (len(structure.Headers) > 0 && len(structure.SampleData) == 0) {
+			for i, header := range structure.Headers {
+				if header != "" {
+					sheet.SetValue(row, col+i, Str(header))
+				}
+			}
+			row++
+		}
+		for r, sample := range structure.SampleData {
+			for c, text := range sample {
+				dataType := ""
+				if c < len(structure.Headers) {
+					dataType = structure.DataTypes[structure.Headers[c]]
+				}
+				if v := typedCell(text, dataType); v.Kind != KindEmpty {
+					sheet.SetValue(row+r, col+c, v)
+				}
+			}
+		}
+	}
+	if len(book.Sheets) == 0 {
+		book.AddSheet("Sheet1")
+	}
+	for _, sheet := range book.Sheets {
+		sheet.original = sheet.Name
+	}
+	return book
+}
+
+// typedCell converts sample text to the value Excel would hold for a column type
+func typedCell(text, dataType string) Value {
+	if strings.TrimSpace(text) == "" {
+		return Empty
+	}
+	switch mcp.DataType(dataType) {
+	case mcp.TypeText:
+		return Str(text)
+	case mcp.TypeDate:
+		if t, ok := parseDate(text); ok {
+			return DateValue(t)
+		}
+	case mcp.TypeBoolean:
+		switch strings.ToUpper(strings.TrimSpace(text)) {
+		case "TRUE":
+			return Bool(true)
+		case "FALSE":
+			return Bool(false)
+		}
+	}
+	if n, ok := parseNumber(strings.TrimSuffix(strings.TrimSpace(text), "%")); ok {
+		if strings.HasSuffix(strings.TrimSpace(text), "%") {
+			n /= 100
+		}
+		return Double(n)
+	}
+	return Str(text)
+}
+
+// AddSheet appends a new empty sheet and makes it active
+func (b *Workbook) AddSheet(name string) *Worksheet {
+	b.nextID++
+	if name == "" {
+		for n := len(b.Sheets) + 1; ; n++ {
+			name = "Sheet" + strconv.Itoa(n)
+			if b.Sheet(name) == nil {
+				break
+			}
+		}
+	}
+	sheet := &Worksheet{Name: name, book: b, id: b.nextID, cells: map[cellKey]*Cell{}}
+	b.Sheets = append(b.Sheets, sheet)
+	b.active = len(b.Sheets) - 1
+	return sheet
+}
+
+// Sheet returns the sheet with the given name, compared case-insensitively, or nil
+func (b *Workbook) Sheet(name string) *Worksheet {
+	for _, sheet := range b.Sheets {
+		if strings.EqualFold(sheet.Name, name) {
+			return sheet
+		}
+	}
+	return nil
+}
+
+// ActiveSheet returns the active sheet
+func (b *Workbook) ActiveSheet() *Worksheet {
+	if b.active >= len(b.Sheets) {
+		b.active = 0
+	}
+	return b.Sheets[b.active]
+}
+
+// Activate makes a sheet the active sheet
+func (b *Workbook) Activate(sheet *Worksheet) {
+	for i, s := range b.Sheets {
+		if s == sheet {
+			b.active = i
+		}
+	}
+}
+
+// RemoveSheet deletes a sheet; the last sheet cannot be removed
+func (b *Workbook) RemoveSheet(sheet *Worksheet) bool {
+	if len(b.Sheets) <= 1 {
+		return false
+	}
+	for i, s := range b.Sheets {
+		if s == sheet {
+			b.Sheets = append(b.Sheets[:i], b.Sheets[i+1:]...)
+			sheet.deleted = true
+			if b.active >= len(b.Sheets) {
+				b.active = len(b.Sheets) - 1
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Index returns the 1-based position of the sheet in the workbook
+func (s *Worksheet) Index() int {
+	for i, sheet := range s.book.Sheets {
+		if sheet == s {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Value returns the value of a cell; empty cells are Empty
+func (s *Worksheet) Value(row, col int) Value {
+	if cell, ok := s.cells[cellKey{row, col}]; ok {
+		return cell.Value
+	}
+	return Empty
+}
+
+// Formula returns the formula of a cell or its value as text
+func (s *Worksheet) Formula(row, col int) string {
+	if cell, ok := s.cells[cellKey{row, col}]; ok {
+		if cell.Formula != "" {
+			return cell.Formula
+		}
+		return cell.Value.String()
+	}
+	return ""
+}
+
+// SetValue stores a value in a cell. Empty values and empty strings clear it.
+func (s *Worksheet) SetValue(row, col int, v Value) {
+	if v.Kind == KindEmpty || (v.Kind == KindString && v.Str == "") {
+		delete(s.cells, cellKey{row, col})
+		return
+	}
+	s.cells[cellKey{row, col}] = &Cell{Value: v}
+}
+
+// SetFormula stores formula text in a cell. Text that does not start with =
+// is entered as a value, as Excel does.
+func (s *Worksheet) SetFormula(row, col int, formula string) {
+	if !strings.HasPrefix(formula, "=") {
+		s.SetValue(row, col, typedCell(formula, ""))
+		return
+	}
+	s.cells[cellKey{row, col}] = &Cell{Value: Str(formula), Formula: formula}
+}
+
+// isEmpty reports whether a cell holds nothing
+func (s *Worksheet) isEmpty(row, col int) bool {
+	_, ok := s.cells[cellKey{row, col}]
+	return !ok
+}
+
+// usedArea returns the smallest area containing every non-empty cell
+func (s *Worksheet) usedArea() (Area, bool) {
+	if len(s.cells) == 0 {
+		return Area{Row1: 1, Col1: 1, Row2: 1, Col2: 1}, false
+	}
+	area := Area{Row1: MaxRows, Col1: MaxColumns}
+	for key := range s.cells {
+		if key.Row < area.Row1 {
+			area.Row1 = key.Row
+		}
+		if key.Row > area.Row2 {
+			area.Row2 = key.Row
+		}
+		if key.Col < area.Col1 {
+			area.Col1 = key.Col
+		}
+		if key.Col > area.Col2 {
+			area.Col2 = key.Col
+		}
+	}
+	return area, true
+}
+
+// clear empties every cell of an area
+func (s *Worksheet) clear(area Area) {
+	if area.Cells() > len(s.cells) {
+		for key := range s.cells {
+			if area.Contains(key.Row, key.Col) {
+				delete(s.cells, key)
+			}
+		}
+		return
+	}
+	for r := area.Row1; r <= area.Row2; r++ {
+		for c := area.Col1; c <= area.Col2; c++ {
+			delete(s.cells, cellKey{r, c})
+		}
+	}
+}
+
+// deleteCells removes an area, shifting the cells below it up or the cells
+// to its right left. Whole rows and columns are the areas spanning the sheet.
+func (s *Worksheet) deleteCells(area Area, up bool) {
+	rows, cols := area.Row2-area.Row1+1, area.Col2-area.Col1+1
+	moved := map[cellKey]*Cell{}
+	for key, cell := range s.cells {
+		switch {
+		case area.Contains(key.Row, key.Col):
+			continue
+		case up && key.Row > area.Row2 && key.Col >= area.Col1 && key.Col <= area.Col2:
+			key.Row -= rows
+		case !up && key.Col > area.Col2 && key.Row >= area.Row1 && key.Row <= area.Row2:
+			key.Col -= cols
+		}
+		moved[key] = cell
+	}
+	s.cells = moved
+}
+
+// insertCells inserts empty cells at an area, shifting the cells there down
+// or right. Cells pushed past the edge of the sheet are lost, as Excel
+// refuses to do; the caller checks for that first.
+func (s *Worksheet) insertCells(area Area, down bool) {
+	rows, cols := area.Row2-area.Row1+1, area.Col2-area.Col1+1
+	moved := map[cellKey]*Cell{}
+	for key, cell := range s.cells {
+		switch {
+		case down && key.Row >= area.Row1 && key.Col >= area.Col1 && key.Col <= area.Col2:
+			key.Row += rows
+		case !down && key.Col >= area.Col1 && key.Row >= area.Row1 && key.Row <= area.Row2:
+			key.Col += cols
+		}
+		if key.Row <= MaxRows && key.Col <= MaxColumns {
+			moved[key] = cell
+		}
+	}
+	s.cells = moved
+}
+
+// ----------------------------------------------------------------------------
+// Addresses
+
+// Area is a rectangular block of cells with 1-based inclusive bounds
+type Area struct {
+	Row1, Col1, Row2, Col2 int
+}
+
+// Cells returns the number of cells in the area
+func (a Area) Cells() int {
+	return (a.Row2 - a.Row1 + 1) * (a.Col2 - a.Col1 + 1)
+}
+
+// Contains reports whether a cell lies inside the area
+func (a Area) Contains(row, col int) bool {
+	return row >= a.Row1 && row <= a.Row2 && col >= a.Col1 && col <= a.Col2
+}
+
+// Address returns the area in A1 notation, absolute when requested
+func (a Area) Address(absolute bool) string {
+	dollar := ""
+	if absolute {
+		dollar = "$"
+	}
+	switch {
+	case a.Row1 == 1 && a.Row2 == MaxRows:
+		return dollar + ColumnLetter(a.Col1) + ":" + dollar + ColumnLetter(a.Col2)
+	case a.Col1 == 1 && a.Col2 == MaxColumns:
+		return dollar + strconv.Itoa(a.Row1) + ":" + dollar + strconv.Itoa(a.Row2)
+	}
+	first := dollar + ColumnLetter(a.Col1) + dollar + strconv.Itoa(a.Row1)
+	if a.Row1 == a.Row2 && a.Col1 == a.Col2 {
+		return first
+	}
+	return first + ":" + dollar + ColumnLetter(a.Col2) + dollar + strconv.Itoa(a.Row2)
+}
+
+// ColumnLetter converts a 1-based column number to letters (1 -> A, 27 -> AA)
+func ColumnLetter(col int) string {
+	return excelrange.ColumnLetter(col)
+}
+
+// columnNumber converts column letters to a 1-based number, or 0
+func columnNumber(letters string) int {
+	return excelrange.ColumnNumber(letters)
+}
+
+// ParseAddress parses an A1-style address such as "B2", "$A$1:$D$10", "A:C"
+// or "3:5". A sheet qualifier is ignored.
+func ParseAddress(address string) (Area, error) {
+	area, err := excelrange.ParseArea(address)
+	if err != nil {
+		return Area{}, err
+	}
+	return Area{Row1: area.Row1, Col1: area.Col1, Row2: area.Row2, Col2: area.Col2}, nil
+}
+
+// ----------------------------------------------------------------------------
+// Diff
+
+// CellChange is one cell whose content differs after the macro ran
+type CellChange struct {
+	Sheet   string `json:"sheet"`
+	Address string `json:"address"`
+	Row     int    `json:"row"`
+	Column  int    `json:"column"`
+	Before  string `json:"before"`
+	After   string `json:"after"`
+	Type    string `json:"type"` // VBA type of the new value, or "Formula"
+}
+
+// SheetChange is a sheet the macro added, deleted or renamed
+type SheetChange struct {
+	Sheet   string `json:"sheet"`
+	Action  string `json:"action"` // "added", "deleted" or "renamed"
+	OldName string `json:"oldName,omitempty"`
+}
+
+// snapshot records the cells of every sheet so changes can be listed later
+type snapshot struct {
+	sheets []*Worksheet
+	cells  map[int]map[cellKey]Cell
+}
+
+func (b *Workbook) snapshot() *snapshot {
+	snap := &snapshot{sheets: append([]*Worksheet(nil), b.Sheets...), cells: map[int]map[cellKey]Cell{}}
+	for _, sheet := range b.Sheets {
+		cells := make(map[cellKey]Cell, len(sheet.cells))
+		for key, cell := range sheet.cells {
+			cells[key] = *cell
+		}
+		snap.cells[sheet.id] = cells
+	}
+	return snap
+}
+
+// diff lists the sheets and cells that differ from the snapshot
+func (b *Workbook) diff(before *snapshot) ([]CellChange, []SheetChange) {
+	changes := []CellChange{}
+	sheets := []SheetChange{}
+
+	for _, sheet := range before.sheets {
+		if sheet.deleted {
+			sheets = append(sheets, SheetChange{Sheet: sheet.original, Action: "deleted"})
+		}
+	}
+	for _, sheet := range b.Sheets {
+		old, existed := before.cells[sheet.id]
+		switch {
+		case !existed:
+			sheets = append(sheets, SheetChange{Sheet: sheet.Name, Action: "added"})
+		case sheet.Name != sheet.original && sheet.original != "":
+			sheets = append(sheets, SheetChange{Sheet: sheet.Name, Action: "renamed", OldName: sheet.original})
+		}
+
+		keys := map[cellKey]bool{}
+		for key := range old {
+			keys[key] = true
+		}
+		for key := range sheet.cells {
+			keys[key] = true
+		}
+		var sheetChanges []CellChange
+		for key := range keys {
+			was, had := old[key]
+			now, has := sheet.cells[key]
+			if had && has && sameCell(was, *now) {
+				continue
+			}
+			change := CellChange{
+				Sheet:   sheet.Name,
+				Address: ColumnLetter(key.Col) + strconv.Itoa(key.Row),
+				Row:     key.Row,
+				Column:  key.Col,
+				Type:    "Empty",
+			}
+			if had {
+				change.Before = cellText(was)
+			}
+			if has {
+				change.After = cellText(*now)
+				change.Type = now.Value.TypeName()
+				if now.Formula != "" {
+					change.Type = "Formula"
+				}
+			}
+			sheetChanges = append(sheetChanges, change)
+		}
+		sort.Slice(sheetChanges, func(i, j int) bool {
+			if sheetChanges[i].Row != sheetChanges[j].Row {
+				return sheetChanges[i].Row < sheetChanges[j].Row
+			}
+			return sheetChanges[i].Column < sheetChanges[j].Column
+		})
+		changes = append(changes, sheetChanges...)
+	}
+	return changes, sheets
+}
+
+func sameCell(a, b Cell) bool {
+	return a.Formula == b.Formula && a.Value.Kind == b.Value.Kind && a.Value.Num == b.Value.Num && a.Value.Str == b.Value.Str
+}
+
+func cellText(cell Cell) string {
+	if cell.Formula != "" {
+		return cell.Formula
+	}
+	return cell.Value.String()
+}
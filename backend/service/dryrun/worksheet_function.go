@@ -0,0 +1,657 @@
+package dryrun
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Excel error values held in KindError values
+const (
+	xlErrDiv0  = 2007
+	xlErrNA    = 2042
+	xlErrValue = 2015
+	xlErrRef   = 2023
+)
+
+// worksheetFunction is Application.WorksheetFunction. Called through
+// WorksheetFunction a failing function raises run-time error 1004; called
+// directly on Application (lenient) it returns an error value instead.
+type worksheetFunction struct {
+	lenient bool
+}
+
+// wsFunc evaluates a worksheet function, returning an error number for
+// results such as #N/A
+type wsFunc func(in *Interpreter, args []Arg) (Value, int)
+
+var worksheetFunctions map[string]wsFunc
+
+func init() {
+	worksheetFunctions = map[string]wsFunc{
+		"sum":        wsSum,
+		"average":    wsAverage,
+		"min":        wsExtreme(true),
+		"max":        wsExtreme(false),
+		"count":      wsCount(func(v Value) bool { return v.Kind == KindLong || v.Kind == KindDouble || v.Kind == KindDate }),
+		"counta":     wsCount(func(v Value) bool { return v.Kind != KindEmpty }),
+		"countblank": wsCountBlank,
+		"countif":    wsCountIf,
+		"countifs":   wsCountIfs,
+		"sumif":      wsSumIf,
+		"sumifs":     wsSumIfs,
+		"match":      wsMatch,
+		"vlookup":    wsVLookup,
+		"index":      wsIndex,
+		"round":      wsRound(func(n float64) float64 { return math.Round(n) }),
+		"roundup": wsRound(func(n float64) float64 {
+			if n < 0 {
+				return -math.Ceil(-n)
+			}
+			return math.Ceil(n)
+		}),
+		"rounddown": wsRound(math.Trunc),
+	}
+}
+
+// TypeName implements Object
+func (w worksheetFunction) TypeName() string { return "WorksheetFunction" }
+
+// Get implements Object
+func (w worksheetFunction) Get(in *Interpreter, name string, args []Arg) (Value, error) {
+	fn, ok := worksheetFunctions[strings.ToLower(name)]
+	if !ok {
+		if name == "" {
+			return Empty, errNoMember
+		}
+		return Empty, unsupported("WorksheetFunction."+properName(strings.ToLower(name)), "")
+	}
+	v, code := fn(in, args)
+	if code == 0 {
+		return v, nil
+	}
+	if w.lenient {
+		return Value{Kind: KindError, Num: float64(code)}, nil
+	}
+	return Empty, runtimeError(1004, "Unable to get the "+properName(strings.ToLower(name))+" property of the WorksheetFunction class")
+}
+
+// Let implements Object
+func (w worksheetFunction) Let(in *Interpreter, name string, args []Arg, v Value) error {
+	return errNoMember
+}
+
+// wsArg is a worksheet function argument: a range, an array or a scalar
+type wsArg struct {
+	grid   [][]Value // Rows of a range or array
+	scalar Value
+	isGrid bool
+}
+
+// readArg converts an argument. Ranges are cut to the used area so whole
+// columns stay cheap.
+func readArg(in *Interpreter, v Value) wsArg {
+	if v.Kind == KindObject {
+		if r, ok := v.Obj.(*Range); ok {
+			area := r.area
+			used, any := r.sheet.usedArea()
+			if !any {
+				used = Area{1, 1, 0, 0}
+			}
+			if area.Row2 > used.Row2 {
+				area.Row2 = used.Row2
+			}
+			if area.Col2 > used.Col2 {
+				area.Col2 = used.Col2
+			}
+			grid := [][]Value{}
+			for row := area.Row1; row <= area.Row2; row++ {
+				line := make([]Value, 0, area.Col2-area.Col1+1)
+				for col := area.Col1; col <= area.Col2; col++ {
+					line = append(line, r.sheet.Value(row, col))
+				}
+				grid = append(grid, line)
+			}
+			return wsArg{grid: grid, isGrid: true}
+		}
+		v = in.scalar(nil, v)
+	}
+	if v.Kind == KindArray {
+		arr := v.Arr
+		switch len(arr.Lengths) {
+		case 1:
+			return wsArg{grid: [][]Value{append([]Value(nil), arr.Data...)}, isGrid: true}
+		case 2:
+			grid := make([][]Value, arr.Lengths[0])
+			for row := range grid {
+				grid[row] = make([]Value, arr.Lengths[1])
+				for col := range grid[row] {
+					grid[row][col] = arr.Data[row+col*arr.Lengths[0]]
+				}
+			}
+			return wsArg{grid: grid, isGrid: true}
+		}
+	}
+	return wsArg{scalar: v}
+}
+
+// cells lists the values of a range or array row by row
+func (a wsArg) cells() []Value {
+	var values []Value
+	for _, line := range a.grid {
+		values = append(values, line...)
+	}
+	return values
+}
+
+// numbers collects the numbers of the arguments the way SUM does: numbers in
+// ranges count and text there is skipped, while text passed directly must
+// convert
+func numbers(in *Interpreter, args []Arg) ([]float64, int) {
+	var list []float64
+	for _, a := range args {
+		if a.Missing {
+			continue
+		}
+		argument := readArg(in, a.Value)
+		if !argument.isGrid {
+			v := argument.scalar
+			if v.Kind == KindError {
+				return nil, int(v.Num)
+			}
+			n, err := toNumber(v)
+			if err != nil {
+				return nil, xlErrValue
+			}
+			list = append(list, n)
+			continue
+		}
+		for _, v := range argument.cells() {
+			switch v.Kind {
+			case KindLong, KindDouble, KindDate:
+				list = append(list, v.Num)
+			case KindError:
+				return nil, int(v.Num)
+			}
+		}
+	}
+	return list, 0
+}
+
+func wsSum(in *Interpreter, args []Arg) (Value, int) {
+	list, code := numbers(in, args)
+	total := 0.0
+	for _, n := range list {
+		total += n
+	}
+	return Double(total), code
+}
+
+func wsAverage(in *Interpreter, args []Arg) (Value, int) {
+	list, code := numbers(in, args)
+	if code != 0 {
+		return Empty, code
+	}
+	if len(list) == 0 {
+		return Empty, xlErrDiv0
+	}
+	total := 0.0
+	for _, n := range list {
+		total += n
+	}
+	return Double(total / float64(len(list))), 0
+}
+
+func wsExtreme(smallest bool) wsFunc {
+	return func(in *Interpreter, args []Arg) (Value, int) {
+		list, code := numbers(in, args)
+		if code != 0 || len(list) == 0 {
+			return Double(0), code
+		}
+		result := list[0]
+		for _, n := range list[1:] {
+			if (smallest && n < result) || (!smallest && n > result) {
+				result = n
+			}
+		}
+		return Double(result), 0
+	}
+}
+
+func wsCount(counts func(Value) bool) wsFunc {
+	return func(in *Interpreter, args []Arg) (Value, int) {
+		count := 0
+		for _, a := range args {
+			if a.Missing {
+				continue
+			}
+			argument := readArg(in, a.Value)
+			if !argument.isGrid {
+				if counts(argument.scalar) || argument.scalar.Kind == KindString && counts(Double(0)) {
+					count++
+				}
+				continue
+			}
+			for _, v := range argument.cells() {
+				if counts(v) {
+					count++
+				}
+			}
+		}
+		return Double(float64(count)), 0
+	}
+}
+
+func wsCountBlank(in *Interpreter, args []Arg) (Value, int) {
+	v, _ := arg(args, 0)
+	r, ok := v.Obj.(*Range)
+	if v.Kind != KindObject || !ok {
+		return Empty, xlErrValue
+	}
+	filled := 0
+	for _, key := range r.sheet.sortedKeys(r.area) {
+		if value := r.sheet.Value(key.Row, key.Col); value.Kind != KindString || value.Str != "" {
+			filled++
+		}
+	}
+	return Double(float64(r.area.Cells() - filled)), 0
+}
+
+// criterion builds the matcher for a COUNTIF/SUMIF criteria argument such as
+// ">5", "<>Closed", "North*" or 10
+func criterion(v Value) func(Value) bool {
+	if v.Kind != KindString {
+		return func(cell Value) bool {
+			if v.Kind == KindBoolean {
+				return cell.Kind == KindBoolean && cell.Num == v.Num
+			}
+			n, err := toNumber(cell)
+			return err == nil && cell.Kind != KindEmpty && cell.Kind != KindBoolean && n == v.Num
+		}
+	}
+
+	text := v.Str
+	op := "="
+	for _, candidate := range []string{"<=", ">=", "<>", "<", ">", "="} {
+		if strings.HasPrefix(text, candidate) {
+			op, text = candidate, text[len(candidate):]
+			break
+		}
+	}
+
+	if n, ok := parseNumber(text); ok {
+		return func(cell Value) bool {
+			if cell.Kind != KindLong && cell.Kind != KindDouble && cell.Kind != KindDate {
+				if cell.Kind == KindString && (op == "=" || op == "<>") {
+					m, ok := parseNumber(cell.Str)
+					return (ok && m == n) == (op == "=")
+				}
+				return op == "<>"
+			}
+			return compareOrder(op, compareNumbers(cell.Num, n))
+		}
+	}
+
+	return func(cell Value) bool {
+		if op == "=" || op == "<>" {
+			var matched bool
+			if text == "" {
+				matched = cell.Kind == KindEmpty || (cell.Kind == KindString && cell.Str == "")
+			} else {
+				matched = cell.Kind != KindEmpty && wildcardMatch(cell.String(), text)
+			}
+			return matched == (op == "=")
+		}
+		if cell.Kind != KindString {
+			return false
+		}
+		return compareOrder(op, strings.Compare(strings.ToLower(cell.Str), strings.ToLower(text)))
+	}
+}
+
+func compareNumbers(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareOrder(op string, order int) bool {
+	switch op {
+	case "<":
+		return order < 0
+	case ">":
+		return order > 0
+	case "<=":
+		return order <= 0
+	case ">=":
+		return order >= 0
+	case "<>":
+		return order != 0
+	}
+	return order == 0
+}
+
+// wildcardMatch compares text case-insensitively with Excel wildcards:
+// * and ?, escaped with ~
+func wildcardMatch(s, pattern string) bool {
+	var b strings.Builder
+	escaped := false
+	for _, r := range strings.ToLower(pattern) {
+		switch {
+		case escaped:
+			if r == '*' || r == '?' || r == '#' || r == '[' {
+				b.WriteString("[" + string(r) + "]")
+			} else {
+				b.WriteRune(r)
+			}
+			escaped = false
+		case r == '~':
+			escaped = true
+		case r == '#' || r == '[':
+			b.WriteString("[" + string(r) + "]")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return like(strings.ToLower(s), b.String(), false)
+}
+
+// conditions pairs criteria ranges with their matchers for the *IFS functions
+type condition struct {
+	grid  [][]Value
+	match func(Value) bool
+}
+
+func (c condition) matches(row, col int) bool {
+	if row >= len(c.grid) || col >= len(c.grid[row]) {
+		return c.match(Empty)
+	}
+	return c.match(c.grid[row][col])
+}
+
+func conditions(in *Interpreter, args []Arg) ([]condition, int) {
+	if len(args)%2 != 0 {
+		return nil, xlErrValue
+	}
+	var list []condition
+	for i := 0; i < len(args); i += 2 {
+		grid := readArg(in, args[i].Value)
+		if !grid.isGrid {
+			return nil, xlErrValue
+		}
+		list = append(list, condition{grid: grid.grid, match: criterion(in.scalar(nil, args[i+1].Value))})
+	}
+	return list, 0
+}
+
+// extent returns the size of the area a range argument covers, even past
+// the used area, so criteria over empty cells still count them
+func extent(v Value, grid [][]Value) (int, int) {
+	if r, ok := v.Obj.(*Range); v.Kind == KindObject && ok {
+		return r.rows(), r.cols()
+	}
+	if len(grid) == 0 {
+		return 0, 0
+	}
+	return len(grid), len(grid[0])
+}
+
+func wsCountIf(in *Interpreter, args []Arg) (Value, int) {
+	if len(args) != 2 {
+		return Empty, xlErrValue
+	}
+	return wsCountIfs(in, args)
+}
+
+func wsCountIfs(in *Interpreter, args []Arg) (Value, int) {
+	list, code := conditions(in, args)
+	if code != 0 {
+		return Empty, code
+	}
+	rows, cols := extent(args[0].Value, list[0].grid)
+	if rows*cols > maxArrayCells {
+		// Whole columns: only the used part can match non-blank criteria
+		rows, cols = len(list[0].grid), 0
+		if rows > 0 {
+			cols = len(list[0].grid[0])
+		}
+	}
+	count := 0
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			all := true
+			for _, c := range list {
+				all = all && c.matches(row, col)
+			}
+			if all {
+				count++
+			}
+		}
+	}
+	return Double(float64(count)), 0
+}
+
+func wsSumIf(in *Interpreter, args []Arg) (Value, int) {
+	if len(args) < 2 || len(args) > 3 {
+		return Empty, xlErrValue
+	}
+	sumRange := args[0]
+	if v, ok := arg(args, 2); ok {
+		sumRange = Arg{Value: v}
+	}
+	return wsSumIfs(in, append([]Arg{sumRange}, args[:2]...))
+}
+
+func wsSumIfs(in *Interpreter, args []Arg) (Value, int) {
+	if len(args) < 3 {
+		return Empty, xlErrValue
+	}
+	sums := readArg(in, args[0].Value)
+	list, code := conditions(in, args[1:])
+	if code != 0 {
+		return Empty, code
+	}
+	total := 0.0
+	for row, line := range sums.grid {
+		for col, v := range line {
+			if v.Kind != KindLong && v.Kind != KindDouble && v.Kind != KindDate {
+				continue
+			}
+			all := true
+			for _, c := range list {
+				all = all && c.matches(row, col)
+			}
+			if all {
+				total += v.Num
+			}
+		}
+	}
+	return Double(total), 0
+}
+
+// lookupOrder compares a cell with a lookup value for MATCH and VLOOKUP:
+// numbers with numbers and text with text, case-insensitively. ok is false
+// when the types differ.
+func lookupOrder(cell, lookup Value) (int, bool) {
+	cellNumeric := cell.Kind == KindLong || cell.Kind == KindDouble || cell.Kind == KindDate
+	lookupNumeric := lookup.Kind == KindLong || lookup.Kind == KindDouble || lookup.Kind == KindDate
+	switch {
+	case cellNumeric && lookupNumeric:
+		return compareNumbers(cell.Num, lookup.Num), true
+	case cell.Kind == KindString && lookup.Kind == KindString:
+		return strings.Compare(strings.ToLower(cell.Str), strings.ToLower(lookup.Str)), true
+	case cell.Kind == KindBoolean && lookup.Kind == KindBoolean:
+		return compareNumbers(-cell.Num, -lookup.Num), true
+	}
+	return 0, false
+}
+
+// lookup finds a value in a list: exact match (with wildcards for text), the
+// largest value not above it (mode 1) or the smallest not below it (mode -1)
+func lookup(list []Value, value Value, mode int) int {
+	found := -1
+	for i, cell := range list {
+		if mode == 0 {
+			if value.Kind == KindString && cell.Kind == KindString {
+				if wildcardMatch(cell.Str, value.Str) {
+					return i
+				}
+				continue
+			}
+			if order, ok := lookupOrder(cell, value); ok && order == 0 {
+				return i
+			}
+			continue
+		}
+		order, ok := lookupOrder(cell, value)
+		if !ok {
+			continue
+		}
+		if mode > 0 {
+			if order > 0 {
+				break
+			}
+			found = i
+		} else {
+			if order < 0 {
+				break
+			}
+			found = i
+		}
+	}
+	return found
+}
+
+func wsMatch(in *Interpreter, args []Arg) (Value, int) {
+	if len(args) < 2 {
+		return Empty, xlErrValue
+	}
+	value := in.scalar(nil, args[0].Value)
+	table := readArg(in, args[1].Value)
+	mode := 1
+	if v, ok := arg(args, 2); ok {
+		n, err := toNumber(in.scalar(nil, v))
+		if err != nil {
+			return Empty, xlErrValue
+		}
+		mode = int(n)
+	}
+	if !table.isGrid {
+		table.grid = [][]Value{{table.scalar}}
+	}
+
+	var list []Value
+	switch {
+	case len(table.grid) == 1:
+		list = table.grid[0]
+	case len(table.grid) > 0 && len(table.grid[0]) == 1:
+		for _, line := range table.grid {
+			list = append(list, line[0])
+		}
+	default:
+		return Empty, xlErrNA
+	}
+	i := lookup(list, value, mode)
+	if i < 0 {
+		return Empty, xlErrNA
+	}
+	return Double(float64(i + 1)), 0
+}
+
+func wsVLookup(in *Interpreter, args []Arg) (Value, int) {
+	if len(args) < 3 {
+		return Empty, xlErrValue
+	}
+	value := in.scalar(nil, args[0].Value)
+	table := readArg(in, args[1].Value)
+	col, err := toNumber(in.scalar(nil, args[2].Value))
+	if err != nil || col < 1 {
+		return Empty, xlErrValue
+	}
+	mode := 1
+	if v, ok := arg(args, 3); ok {
+		if n, err := toNumber(in.scalar(nil, v)); err == nil && n == 0 {
+			mode = 0
+		}
+	}
+	if !table.isGrid {
+		return Empty, xlErrNA
+	}
+	first := make([]Value, len(table.grid))
+	for i, line := range table.grid {
+		first[i] = line[0]
+	}
+	row := lookup(first, value, mode)
+	if row < 0 {
+		return Empty, xlErrNA
+	}
+	if int(col) > len(table.grid[row]) {
+		if r, ok := args[1].Value.Obj.(*Range); ok && int(col) <= r.cols() {
+			// A column of the range beyond the used area is empty
+			return Double(0), 0
+		}
+		return Empty, xlErrRef
+	}
+	result := table.grid[row][int(col)-1]
+	if result.Kind == KindEmpty {
+		return Double(0), 0
+	}
+	return result, 0
+}
+
+func wsIndex(in *Interpreter, args []Arg) (Value, int) {
+	if len(args) < 2 {
+		return Empty, xlErrValue
+	}
+	table := readArg(in, args[0].Value)
+	if !table.isGrid {
+		table.grid = [][]Value{{table.scalar}}
+	}
+	row, err := toNumber(in.scalar(nil, args[1].Value))
+	if err != nil {
+		return Empty, xlErrValue
+	}
+	col := 1.0
+	if v, ok := arg(args, 2); ok {
+		if col, err = toNumber(in.scalar(nil, v)); err != nil {
+			return Empty, xlErrValue
+		}
+	} else if len(table.grid) == 1 {
+		// A single row is indexed by column
+		row, col = 1, row
+	}
+	rows, cols := extent(args[0].Value, table.grid)
+	if row < 1 || col < 1 || int(row) > rows || int(col) > cols {
+		return Empty, xlErrRef
+	}
+	if int(row) > len(table.grid) || int(col) > len(table.grid[int(row)-1]) {
+		return Empty, 0
+	}
+	return table.grid[int(row)-1][int(col)-1], 0
+}
+
+// wsRound implements ROUND, ROUNDUP and ROUNDDOWN, which round half away
+// from zero unlike the VBA Round function
+func wsRound(round func(float64) float64) wsFunc {
+	return func(in *Interpreter, args []Arg) (Value, int) {
+		if len(args) != 2 {
+			return Empty, xlErrValue
+		}
+		n, err := toNumber(in.scalar(nil, args[0].Value))
+		if err != nil {
+			return Empty, xlErrValue
+		}
+		digits, err := toNumber(in.scalar(nil, args[1].Value))
+		if err != nil {
+			return Empty, xlErrValue
+		}
+		scale := math.Pow(10, math.Trunc(digits))
+		// Format first so 2.675 rounds as the decimal it was written as
+		shifted, _ := strconv.ParseFloat(strconv.FormatFloat(n*scale, 'f', 9, 64), 64)
+		return Double(round(shifted) / scale), 0
+	}
+}
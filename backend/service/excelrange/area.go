@@ -0,0 +1,290 @@
+package excelrange
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Sheet limits of Excel 2007 and later
+const (
+	MaxRows    = 1048576
+	MaxColumns = 16384 // XFD
+)
+
+// BoundsError reports a row or column outside the sheet
+type BoundsError struct {
+	Column bool // The column is out of bounds, otherwise the row
+	Index  int  // The 1-based row or column number
+}
+
+func (e *BoundsError) Error() string {
+	switch {
+	case e.Column && e.Index > MaxColumns:
+		return fmt.Sprintf("column %s is beyond the last Excel column XFD", ColumnLetter(e.Index))
+	case e.Column:
+		return fmt.Sprintf("column %d is before the first Excel column A", e.Index)
+	case e.Index > MaxRows:
+		return fmt.Sprintf("row %d is beyond the last Excel row %d", e.Index, MaxRows)
+	default:
+		return fmt.Sprintf("row %d is before the first Excel row 1", e.Index)
+	}
+}
+
+// ColumnLetter converts a 1-based column number to letters (1 -> A, 27 -> AA).
+// Numbers below 1 give an empty string.
+func ColumnLetter(col int) string {
+	letters := ""
+	for col > 0 {
+		col--
+		letters = string(rune('A'+col%26)) + letters
+		col /= 26
+	}
+	return letters
+}
+
+// ColumnNumber converts column letters to a 1-based number (A -> 1, XFD -> 16384),
+// or returns 0 if the letters are not a column on the sheet
+func ColumnNumber(letters string) int {
+	n := columnValue(letters)
+	if n > MaxColumns {
+		return 0
+	}
+	return n
+}
+
+// columnValue converts up to three column letters to a number without
+// checking the sheet bounds, or returns 0 if they are not letters
+func columnValue(letters string) int {
+	if letters == "" || len(letters) > 3 {
+		return 0
+	}
+	n := 0
+	for _, r := range strings.ToUpper(letters) {
+		if r < 'A' || r > 'Z' {
+			return 0
+		}
+		n = n*26 + int(r-'A'+1)
+	}
+	return n
+}
+
+// Cell is a single cell position with 1-based row and column numbers
+type Cell struct {
+	Row, Col int
+}
+
+// Address returns the cell in A1 notation, absolute when requested
+func (c Cell) Address(absolute bool) string {
+	if absolute {
+		return "$" + ColumnLetter(c.Col) + "$" + strconv.Itoa(c.Row)
+	}
+	return ColumnLetter(c.Col) + strconv.Itoa(c.Row)
+}
+
+// Area is a rectangular block of cells with 1-based inclusive bounds. Whole
+// columns span rows 1 to MaxRows and whole rows span columns 1 to MaxColumns.
+// An empty Sheet means the area is not qualified.
+type Area struct {
+	Sheet                  string
+	Row1, Col1, Row2, Col2 int
+}
+
+// NewArea returns the area between two corners in any order
+func NewArea(sheet string, row1, col1, row2, col2 int) (Area, error) {
+	if row1 > row2 {
+		row1, row2 = row2, row1
+	}
+	if col1 > col2 {
+		col1, col2 = col2, col1
+	}
+	area := Area{Sheet: sheet, Row1: row1, Col1: col1, Row2: row2, Col2: col2}
+	return area, area.Check()
+}
+
+// Check reports the first corner that lies outside the sheet
+func (a Area) Check() error {
+	for _, row := range []int{a.Row1, a.Row2} {
+		if row < 1 || row > MaxRows {
+			return &BoundsError{Index: row}
+		}
+	}
+	for _, col := range []int{a.Col1, a.Col2} {
+		if col < 1 || col > MaxColumns {
+			return &BoundsError{Column: true, Index: col}
+		}
+	}
+	return nil
+}
+
+// Rows returns the number of rows in the area
+func (a Area) Rows() int {
+	return a.Row2 - a.Row1 + 1
+}
+
+// Columns returns the number of columns in the area
+func (a Area) Columns() int {
+	return a.Col2 - a.Col1 + 1
+}
+
+// Cells returns the number of cells in the area
+func (a Area) Cells() int {
+	return a.Rows() * a.Columns()
+}
+
+// WholeColumns reports whether the area spans every row, as in A:C
+func (a Area) WholeColumns() bool {
+	return a.Row1 == 1 && a.Row2 == MaxRows
+}
+
+// WholeRows reports whether the area spans every column, as in 3:5
+func (a Area) WholeRows() bool {
+	return a.Col1 == 1 && a.Col2 == MaxColumns
+}
+
+// TopLeft returns the first cell of the area
+func (a Area) TopLeft() Cell {
+	return Cell{Row: a.Row1, Col: a.Col1}
+}
+
+// Contains reports whether a cell lies inside the area
+func (a Area) Contains(row, col int) bool {
+	return row >= a.Row1 && row <= a.Row2 && col >= a.Col1 && col <= a.Col2
+}
+
+// ContainsArea reports whether b lies entirely inside a on the same sheet
+func (a Area) ContainsArea(b Area) bool {
+	return sameSheet(a.Sheet, b.Sheet) && a.Contains(b.Row1, b.Col1) && a.Contains(b.Row2, b.Col2)
+}
+
+// Intersect returns the cells common to both areas. It reports false when the
+// areas do not overlap or are qualified with different sheets.
+func (a Area) Intersect(b Area) (Area, bool) {
+	if !sameSheet(a.Sheet, b.Sheet) {
+		return Area{}, false
+	}
+	result := Area{Sheet: a.Sheet}
+	if result.Sheet == "" {
+		result.Sheet = b.Sheet
+	}
+	result.Row1, result.Row2 = a.Row1, a.Row2
+	if b.Row1 > result.Row1 {
+		result.Row1 = b.Row1
+	}
+	if b.Row2 < result.Row2 {
+		result.Row2 = b.Row2
+	}
+	result.Col1, result.Col2 = a.Col1, a.Col2
+	if b.Col1 > result.Col1 {
+		result.Col1 = b.Col1
+	}
+	if b.Col2 < result.Col2 {
+		result.Col2 = b.Col2
+	}
+	if result.Row1 > result.Row2 || result.Col1 > result.Col2 {
+		return Area{}, false
+	}
+	return result, true
+}
+
+// Offset moves the area by rows and columns, like Range.Offset
+func (a Area) Offset(rows, cols int) (Area, error) {
+	moved := Area{Sheet: a.Sheet, Row1: a.Row1 + rows, Col1: a.Col1 + cols, Row2: a.Row2 + rows, Col2: a.Col2 + cols}
+	if err := moved.Check(); err != nil {
+		return a, err
+	}
+	return moved, nil
+}
+
+// Resize keeps the top-left cell and sets the size of the area, like
+// Range.Resize. A size of zero or less keeps that dimension unchanged.
+func (a Area) Resize(rows, cols int) (Area, error) {
+	resized := a
+	if rows > 0 {
+		resized.Row2 = a.Row1 + rows - 1
+	}
+	if cols > 0 {
+		resized.Col2 = a.Col1 + cols - 1
+	}
+	if err := resized.Check(); err != nil {
+		return a, err
+	}
+	return resized, nil
+}
+
+// Address returns the area in A1 notation without the sheet, absolute when
+// requested. Whole rows and columns use the short forms 3:5 and A:C.
+func (a Area) Address(absolute bool) string {
+	dollar := ""
+	if absolute {
+		dollar = "$"
+	}
+	switch {
+	case a.WholeColumns() && !a.WholeRows():
+		return dollar + ColumnLetter(a.Col1) + ":" + dollar + ColumnLetter(a.Col2)
+	case a.WholeRows() && !a.WholeColumns():
+		return dollar + strconv.Itoa(a.Row1) + ":" + dollar + strconv.Itoa(a.Row2)
+	}
+	first := Cell{Row: a.Row1, Col: a.Col1}.Address(absolute)
+	if a.Row1 == a.Row2 && a.Col1 == a.Col2 {
+		return first
+	}
+	return first + ":" + Cell{Row: a.Row2, Col: a.Col2}.Address(absolute)
+}
+
+// AddressR1C1 returns the area in absolute R1C1 notation without the sheet
+func (a Area) AddressR1C1() string {
+	rows := func() string {
+		if a.Row1 == a.Row2 {
+			return "R" + strconv.Itoa(a.Row1)
+		}
+		return "R" + strconv.Itoa(a.Row1) + ":R" + strconv.Itoa(a.Row2)
+	}
+	cols := func() string {
+		if a.Col1 == a.Col2 {
+			return "C" + strconv.Itoa(a.Col1)
+		}
+		return "C" + strconv.Itoa(a.Col1) + ":C" + strconv.Itoa(a.Col2)
+	}
+	switch {
+	case a.WholeColumns() && !a.WholeRows():
+		return cols()
+	case a.WholeRows() && !a.WholeColumns():
+		return rows()
+	}
+	first := "R" + strconv.Itoa(a.Row1) + "C" + strconv.Itoa(a.Col1)
+	if a.Row1 == a.Row2 && a.Col1 == a.Col2 {
+		return first
+	}
+	return first + ":R" + strconv.Itoa(a.Row2) + "C" + strconv.Itoa(a.Col2)
+}
+
+// String returns the absolute A1 address, qualified with the sheet when set
+func (a Area) String() string {
+	if a.Sheet == "" {
+		return a.Address(true)
+	}
+	return QuoteSheet(a.Sheet) + "!" + a.Address(true)
+}
+
+// sameSheet reports whether two sheet qualifiers can refer to the same sheet.
+// Sheet names are case-insensitive and an unqualified area matches any sheet.
+func sameSheet(a, b string) bool {
+	return a == "" || b == "" || strings.EqualFold(a, b)
+}
+
+// QuoteSheet returns the sheet name as it must appear before "!", quoted
+// when it holds spaces or punctuation or could be read as a cell reference
+func QuoteSheet(name string) string {
+	plain := name != "" && !(name[0] >= '0' && name[0] <= '9')
+	for _, r := range name {
+		if !(r == '_' || r == '.' || r >= '0' && r <= '9' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r > 0x7f) {
+			plain = false
+			break
+		}
+	}
+	if plain && !looksLikeReference(name) {
+		return name
+	}
+	return "'" + strings.ReplaceAll(name, "'", "''") + "'"
+}
@@ -0,0 +1,280 @@
+package excelrange
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// referencePattern matches A1 references in a formula: cells, areas, whole
+// columns and whole rows, optionally qualified by a sheet
+var referencePattern = regexp.MustCompile(`(?:('(?:[^']|'')+'|[\p{L}_][\p{L}\p{N}_.]*)!)?` +
+	`(\$?[A-Za-z]{1,3}\$?[0-9]+(?::\$?[A-Za-z]{1,3}\$?[0-9]+)?|\$?[A-Za-z]{1,3}:\$?[A-Za-z]{1,3}|\$?[0-9]+:\$?[0-9]+)`)
+
+// FormulaReference is a reference found in an A1 formula
+type FormulaReference struct {
+	Sheet string // Sheet name without quotes, "" when not qualified
+	Area  Area   // Whole columns span every row and whole rows every column
+	Text  string // The reference as written, with its sheet
+	Start int    // Byte offset of Text in the formula
+	End   int    // Byte offset just after Text
+
+	ends           [2]refEnd
+	single         bool // A lone cell rather than a pair of ends
+	hasCol, hasRow bool
+}
+
+// refEnd is one corner of a reference with its absolute markers
+type refEnd struct {
+	row, col       int
+	rowAbs, colAbs bool
+}
+
+// FormulaReferences returns the references of an A1 formula in order. Text in
+// string literals and in structured references such as Table1[Amount] is
+// skipped, as are function names such as LOG10.
+func FormulaReferences(formula string) []FormulaReference {
+	masked := maskFormula(formula)
+	var refs []FormulaReference
+	for _, m := range referencePattern.FindAllStringSubmatchIndex(masked, -1) {
+		start, end := m[0], m[1]
+		if start > 0 && isNameByte(masked[start-1]) || end < len(masked) && (isNameByte(masked[end]) || masked[end] == '(' || masked[end] == '!') {
+			continue
+		}
+		ref, ok := parseFormulaReference(formula[m[4]:m[5]])
+		if !ok {
+			continue
+		}
+		if m[2] >= 0 {
+			ref.Sheet = unquoteSheet(formula[m[2]:m[3]])
+		}
+		ref.Area.Sheet = ref.Sheet
+		ref.Text, ref.Start, ref.End = formula[start:end], start, end
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// TranslateFormula moves the relative references of a formula written in
+// from so it can be entered in to, as Excel does when a formula is filled or
+// copied. References pushed off the sheet become #REF!.
+func TranslateFormula(formula string, from, to Cell) string {
+	rows, cols := to.Row-from.Row, to.Col-from.Col
+	return rewriteReferences(formula, func(ref FormulaReference) string {
+		ends := ref.ends
+		for i := range ends {
+			if !ends[i].rowAbs && ref.hasRow {
+				ends[i].row += rows
+			}
+			if !ends[i].colAbs && ref.hasCol {
+				ends[i].col += cols
+			}
+			if ref.hasRow && (ends[i].row < 1 || ends[i].row > MaxRows) || ref.hasCol && (ends[i].col < 1 || ends[i].col > MaxColumns) {
+				return "#REF!"
+			}
+		}
+		text := sheetPrefix(ref) + ref.formatA1(ends[0])
+		if !ref.single {
+			text += ":" + ref.formatA1(ends[1])
+		}
+		return text
+	})
+}
+
+// FormulaR1C1 rewrites an A1 formula entered in base in R1C1 notation. A
+// formula filled down a column has the same R1C1 text in every row, which
+// makes it the way to compare formulas across cells.
+func FormulaR1C1(formula string, base Cell) string {
+	return rewriteReferences(formula, func(ref FormulaReference) string {
+		text := sheetPrefix(ref) + ref.formatR1C1(ref.ends[0], base)
+		if !ref.single {
+			text += ":" + ref.formatR1C1(ref.ends[1], base)
+		}
+		return text
+	})
+}
+
+// rewriteReferences replaces each reference of a formula by the text fn returns
+func rewriteReferences(formula string, fn func(FormulaReference) string) string {
+	var result strings.Builder
+	last := 0
+	for _, ref := range FormulaReferences(formula) {
+		result.WriteString(formula[last:ref.Start])
+		result.WriteString(fn(ref))
+		last = ref.End
+	}
+	result.WriteString(formula[last:])
+	return result.String()
+}
+
+// parseFormulaReference parses the part of a reference after the sheet
+func parseFormulaReference(text string) (FormulaReference, bool) {
+	parts := strings.Split(text, ":")
+	ref := FormulaReference{single: len(parts) == 1}
+	for i, part := range parts {
+		end, hasCol, hasRow, ok := parseRefEnd(part)
+		if !ok {
+			return ref, false
+		}
+		if i == 0 {
+			ref.hasCol, ref.hasRow = hasCol, hasRow
+		} else if hasCol != ref.hasCol || hasRow != ref.hasRow {
+			return ref, false
+		}
+		ref.ends[i] = end
+	}
+	if ref.single {
+		ref.ends[1] = ref.ends[0]
+	}
+
+	first, second := ref.ends[0], ref.ends[1]
+	ref.Area = Area{Row1: first.row, Col1: first.col, Row2: second.row, Col2: second.col}
+	if !ref.hasRow {
+		ref.Area.Row1, ref.Area.Row2 = 1, MaxRows
+	}
+	if !ref.hasCol {
+		ref.Area.Col1, ref.Area.Col2 = 1, MaxColumns
+	}
+	if ref.Area.Row1 > ref.Area.Row2 {
+		ref.Area.Row1, ref.Area.Row2 = ref.Area.Row2, ref.Area.Row1
+	}
+	if ref.Area.Col1 > ref.Area.Col2 {
+		ref.Area.Col1, ref.Area.Col2 = ref.Area.Col2, ref.Area.Col1
+	}
+	return ref, ref.Area.Check() == nil
+}
+
+// parseRefEnd parses "$B$2", "B", "$2" and the like
+func parseRefEnd(part string) (refEnd, bool, bool, bool) {
+	var end refEnd
+	i := 0
+	if i < len(part) && part[i] == '$' {
+		end.colAbs = true
+		i++
+	}
+	j := i
+	for j < len(part) && isLetter(part[j]) {
+		j++
+	}
+	letters := part[i:j]
+	if letters == "" && end.colAbs {
+		// "$2" is an absolute row
+		end.colAbs, end.rowAbs = false, true
+	}
+	if j < len(part) && part[j] == '$' {
+		end.rowAbs = true
+		j++
+	}
+	digits := part[j:]
+	if letters != "" {
+		end.col = ColumnNumber(letters)
+		if end.col == 0 {
+			return end, false, false, false
+		}
+	}
+	if digits != "" {
+		n, err := strconv.Atoi(digits)
+		if err != nil || n < 1 {
+			return end, false, false, false
+		}
+		end.row = n
+	}
+	return end, letters != "", digits != "", letters != "" || digits != ""
+}
+
+// formatA1 writes one end of a reference in A1 notation
+func (ref FormulaReference) formatA1(end refEnd) string {
+	var text strings.Builder
+	if ref.hasCol {
+		if end.colAbs {
+			text.WriteByte('$')
+		}
+		text.WriteString(ColumnLetter(end.col))
+	}
+	if ref.hasRow {
+		if end.rowAbs {
+			text.WriteByte('$')
+		}
+		text.WriteString(strconv.Itoa(end.row))
+	}
+	return text.String()
+}
+
+// formatR1C1 writes one end of a reference in R1C1 notation relative to base
+func (ref FormulaReference) formatR1C1(end refEnd, base Cell) string {
+	part := func(prefix string, n, from int, absolute bool) string {
+		switch {
+		case absolute:
+			return prefix + strconv.Itoa(n)
+		case n == from:
+			return prefix
+		default:
+			return prefix + "[" + strconv.Itoa(n-from) + "]"
+		}
+	}
+	text := ""
+	if ref.hasRow {
+		text += part("R", end.row, base.Row, end.rowAbs)
+	}
+	if ref.hasCol {
+		text += part("C", end.col, base.Col, end.colAbs)
+	}
+	return text
+}
+
+// maskFormula blanks string literals and bracketed parts of a formula so
+// their text is not read as references; byte offsets are kept
+func maskFormula(formula string) string {
+	masked := []byte(formula)
+	inString, depth := false, 0
+	for i := 0; i < len(masked); i++ {
+		c := masked[i]
+		switch {
+		case inString:
+			if c == '"' {
+				if i+1 < len(masked) && masked[i+1] == '"' {
+					masked[i], masked[i+1] = ' ', ' '
+					i++
+					continue
+				}
+				inString = false
+				continue
+			}
+			masked[i] = ' '
+		case c == '"' && depth == 0:
+			inString = true
+		case c == '[':
+			depth++
+		case c == ']' && depth > 0:
+			depth--
+		case depth > 0:
+			masked[i] = ' '
+		}
+	}
+	return string(masked)
+}
+
+// sheetPrefix returns the sheet qualifier of a reference as written
+func sheetPrefix(ref FormulaReference) string {
+	if i := strings.LastIndex(ref.Text, "!"); i >= 0 {
+		return ref.Text[:i+1]
+	}
+	return ""
+}
+
+// unquoteSheet removes the quotes of a sheet name written in a formula
+func unquoteSheet(name string) string {
+	if len(name) >= 2 && name[0] == '\'' && name[len(name)-1] == '\'' {
+		return strings.ReplaceAll(name[1:len(name)-1], "''", "'")
+	}
+	return name
+}
+
+// isNameByte reports whether a byte can be part of a name next to a reference
+func isNameByte(c byte) bool {
+	return isLetter(c) || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '$' || c >= 0x80
+}
+
+func isLetter(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
@@ -0,0 +1,359 @@
+package excelrange
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Range is one or more areas, written with commas as in "A1:B2,D4:D9"
+type Range struct {
+	Areas []Area
+}
+
+// Parse parses an A1 address such as "B2", "$A$1:$D$10", "A:C", "3:5",
+// "'My Sheet'!$A$1:$D$10" or the union "A1:B2,Sheet2!D4". Errors for rows and
+// columns beyond XFD1048576 wrap a *BoundsError.
+func Parse(address string) (Range, error) {
+	return parse(address, Cell{}, false)
+}
+
+// ParseR1C1 parses an R1C1 address such as "R1C1:R10C4", "R2", "C3:C5" or
+// "Data!R[1]C[-1]". Relative parts are resolved against base, which must be
+// set when the address uses them.
+func ParseR1C1(address string, base Cell) (Range, error) {
+	return parse(address, base, true)
+}
+
+// ParseArea parses an A1 address that must be a single area
+func ParseArea(address string) (Area, error) {
+	r, err := Parse(address)
+	if err != nil {
+		return Area{}, err
+	}
+	if len(r.Areas) != 1 {
+		return Area{}, fmt.Errorf("range address %q has %d areas, expected one", address, len(r.Areas))
+	}
+	return r.Areas[0], nil
+}
+
+// Cells returns the number of cells in all areas, counting overlaps twice
+func (r Range) Cells() int {
+	n := 0
+	for _, area := range r.Areas {
+		n += area.Cells()
+	}
+	return n
+}
+
+// Contains reports whether any area holds the cell
+func (r Range) Contains(row, col int) bool {
+	for _, area := range r.Areas {
+		if area.Contains(row, col) {
+			return true
+		}
+	}
+	return false
+}
+
+// Intersect returns the overlap of every pair of areas, like Application.Intersect
+func (r Range) Intersect(other Range) Range {
+	var result Range
+	for _, a := range r.Areas {
+		for _, b := range other.Areas {
+			if area, ok := a.Intersect(b); ok {
+				result.Areas = append(result.Areas, area)
+			}
+		}
+	}
+	return result
+}
+
+// Offset moves every area by rows and columns
+func (r Range) Offset(rows, cols int) (Range, error) {
+	moved := Range{Areas: make([]Area, len(r.Areas))}
+	for i, area := range r.Areas {
+		next, err := area.Offset(rows, cols)
+		if err != nil {
+			return r, err
+		}
+		moved.Areas[i] = next
+	}
+	return moved, nil
+}
+
+// Address returns the areas in A1 notation without sheets, joined by commas
+func (r Range) Address(absolute bool) string {
+	parts := make([]string, len(r.Areas))
+	for i, area := range r.Areas {
+		parts[i] = area.Address(absolute)
+	}
+	return strings.Join(parts, ",")
+}
+
+// String returns the absolute A1 address of every area with its sheet
+func (r Range) String() string {
+	parts := make([]string, len(r.Areas))
+	for i, area := range r.Areas {
+		parts[i] = area.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+func parse(address string, base Cell, r1c1 bool) (Range, error) {
+	parts, err := splitUnion(address)
+	if err != nil {
+		return Range{}, err
+	}
+
+	var result Range
+	for _, part := range parts {
+		sheet, ref, err := splitSheet(part)
+		if err != nil {
+			return Range{}, fmt.Errorf("invalid range address %q: %w", address, err)
+		}
+		area, err := parseArea(ref, base, r1c1)
+		if err != nil {
+			var bounds *BoundsError
+			if errors.As(err, &bounds) {
+				return Range{}, fmt.Errorf("range address %q: %w", address, err)
+			}
+			return Range{}, fmt.Errorf("invalid range address %q: %w", address, err)
+		}
+		area.Sheet = sheet
+		result.Areas = append(result.Areas, area)
+	}
+	return result, nil
+}
+
+// splitUnion splits an address on the commas outside quoted sheet names
+func splitUnion(address string) ([]string, error) {
+	var parts []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(address); i++ {
+		switch {
+		case address[i] == '\'':
+			quoted = !quoted
+		case address[i] == ',' && !quoted:
+			parts = append(parts, strings.TrimSpace(address[start:i]))
+			start = i + 1
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("invalid range address %q: unterminated sheet name", address)
+	}
+	parts = append(parts, strings.TrimSpace(address[start:]))
+	for _, part := range parts {
+		if part == "" {
+			return nil, fmt.Errorf("invalid range address %q", address)
+		}
+	}
+	return parts, nil
+}
+
+// splitSheet separates the sheet qualifier from a single reference
+func splitSheet(part string) (sheet, ref string, err error) {
+	if strings.HasPrefix(part, "'") {
+		var name strings.Builder
+		for i := 1; i < len(part); i++ {
+			if part[i] != '\'' {
+				name.WriteByte(part[i])
+				continue
+			}
+			if i+1 < len(part) && part[i+1] == '\'' {
+				name.WriteByte('\'')
+				i++
+				continue
+			}
+			if i+1 >= len(part) || part[i+1] != '!' {
+				return "", "", errors.New("expected ! after the quoted sheet name")
+			}
+			sheet = name.String()
+			ref = part[i+2:]
+			break
+		}
+		if sheet == "" {
+			return "", "", errors.New("empty sheet name")
+		}
+	} else if i := strings.LastIndex(part, "!"); i >= 0 {
+		sheet, ref = part[:i], part[i+1:]
+		if sheet == "" || strings.ContainsAny(sheet, " !") {
+			return "", "", fmt.Errorf("sheet name %q must be quoted", sheet)
+		}
+	} else {
+		ref = part
+	}
+	if strings.Contains(sheet, ":") && !strings.ContainsAny(sheet, "[]") {
+		return "", "", errors.New("3-D references across sheets are not supported")
+	}
+	return sheet, ref, nil
+}
+
+// parseArea parses a reference without a sheet in A1 or R1C1 notation
+func parseArea(ref string, base Cell, r1c1 bool) (Area, error) {
+	parts := strings.Split(ref, ":")
+	if len(parts) > 2 || ref == "" {
+		return Area{}, errors.New("expected a cell, area, row or column reference")
+	}
+
+	var ends [2]Cell // Row or Col is 0 for a whole column or row
+	for i, part := range parts {
+		var cell Cell
+		var err error
+		if r1c1 {
+			cell, err = parseR1C1Cell(part, base)
+		} else {
+			cell, err = parseA1Cell(part)
+		}
+		if err != nil {
+			return Area{}, err
+		}
+		ends[i] = cell
+	}
+	if len(parts) == 1 {
+		if !r1c1 && (ends[0].Row == 0 || ends[0].Col == 0) {
+			// A bare "A" or "3" is a name or number; whole columns need "A:A"
+			return Area{}, errors.New("expected a cell, area, row or column reference")
+		}
+		ends[1] = ends[0]
+	}
+
+	first, last := ends[0], ends[1]
+	switch {
+	case first.Row == 0 && last.Row == 0:
+		first.Row, last.Row = 1, MaxRows
+	case first.Col == 0 && last.Col == 0:
+		first.Col, last.Col = 1, MaxColumns
+	case first.Row == 0 || last.Row == 0 || first.Col == 0 || last.Col == 0:
+		return Area{}, errors.New("cannot mix a whole row or column with a cell")
+	}
+	return NewArea("", first.Row, first.Col, last.Row, last.Col)
+}
+
+// parseA1Cell parses $A$1, A1, $A or 1; a missing row or column is 0
+func parseA1Cell(part string) (Cell, error) {
+	s := strings.TrimPrefix(part, "$")
+	i := 0
+	for i < len(s) && (s[i]|0x20) >= 'a' && (s[i]|0x20) <= 'z' {
+		i++
+	}
+	letters := s[:i]
+	s = s[i:]
+	if letters != "" && strings.HasPrefix(s, "$") {
+		if s = s[1:]; s == "" {
+			return Cell{}, fmt.Errorf("invalid reference %q", part)
+		}
+	}
+	if letters == "" && s == "" {
+		return Cell{}, fmt.Errorf("invalid reference %q", part)
+	}
+
+	var cell Cell
+	if letters != "" {
+		if cell.Col = columnValue(letters); cell.Col == 0 {
+			return Cell{}, fmt.Errorf("invalid reference %q", part)
+		}
+		if cell.Col > MaxColumns {
+			return Cell{}, &BoundsError{Column: true, Index: cell.Col}
+		}
+	}
+	if s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || s[0] == '+' || s[0] == '-' {
+			return Cell{}, fmt.Errorf("invalid reference %q", part)
+		}
+		if n > MaxRows {
+			return Cell{}, &BoundsError{Index: n}
+		}
+		cell.Row = n
+	}
+	return cell, nil
+}
+
+// parseR1C1Cell parses R1C1, R[1]C[-1], RC, R2 or C[3]; a missing row or
+// column is 0
+func parseR1C1Cell(part string, base Cell) (Cell, error) {
+	s := strings.ToUpper(part)
+	var cell Cell
+	var hasRow, hasCol bool
+	var err error
+	if strings.HasPrefix(s, "R") {
+		hasRow = true
+		cell.Row, s, err = r1c1Index(s[1:], base.Row, false)
+		if err != nil {
+			return Cell{}, fmt.Errorf("invalid reference %q: %w", part, err)
+		}
+	}
+	if strings.HasPrefix(s, "C") {
+		hasCol = true
+		cell.Col, s, err = r1c1Index(s[1:], base.Col, true)
+		if err != nil {
+			return Cell{}, fmt.Errorf("invalid reference %q: %w", part, err)
+		}
+	}
+	if s != "" || !hasRow && !hasCol {
+		return Cell{}, fmt.Errorf("invalid reference %q", part)
+	}
+	return cell, nil
+}
+
+// r1c1Index reads the number after R or C: absolute digits, a bracketed
+// offset from the base, or nothing for the base itself
+func r1c1Index(s string, base int, column bool) (int, string, error) {
+	limit := MaxRows
+	if column {
+		limit = MaxColumns
+	}
+	check := func(n int) error {
+		if n < 1 || n > limit {
+			return &BoundsError{Column: column, Index: n}
+		}
+		return nil
+	}
+
+	if strings.HasPrefix(s, "[") {
+		end := strings.IndexByte(s, ']')
+		if end < 0 {
+			return 0, s, errors.New("missing ]")
+		}
+		offset, err := strconv.Atoi(s[1:end])
+		if err != nil {
+			return 0, s, fmt.Errorf("invalid offset %q", s[1:end])
+		}
+		if base < 1 {
+			return 0, s, errors.New("relative reference needs a base cell")
+		}
+		return base + offset, s[end+1:], check(base + offset)
+	}
+
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	if i == 0 {
+		if base < 1 {
+			return 0, s, errors.New("relative reference needs a base cell")
+		}
+		return base, s, nil
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil {
+		return 0, s, fmt.Errorf("invalid number %q", s[:i])
+	}
+	return n, s[i:], check(n)
+}
+
+// looksLikeReference reports whether a name would be read as a cell or area
+// reference in either notation, including references beyond the sheet
+func looksLikeReference(name string) bool {
+	var bounds *BoundsError
+	for _, r1c1 := range []bool{false, true} {
+		_, err := parseArea(name, Cell{Row: 1, Col: 1}, r1c1)
+		if err == nil || errors.As(err, &bounds) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,352 @@
+package generation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"excel-automation-mcp/backend/service/llm"
+	"excel-automation-mcp/backend/service/vba"
+)
+
+// Limits of best-of-N generation
+const (
+	MaxCandidates    = 5 // Candidates generated for one job
+	diffedCandidates = 3 // The best candidate is diffed against the next ones up to this rank
+)
+
+// variantTemperatures are the temperatures of the candidates after the
+// first, which follows the request as it is
+var variantTemperatures = []float64{0.8, 0.4, 1.0, 0.6}
+
+// Points taken off a candidate's score of 100 for each problem
+const (
+	errorPenalty      = 15
+	warningPenalty    = 3
+	referencePenalty  = 5  // Each unknown sheet, column or range, on top of its diagnostic
+	truncationPenalty = 20 // The answer was cut off by the output limit
+	riskDivisor       = 5  // One point per 5 points of security risk
+	linesPerPoint     = 40 // One point per 40 lines beyond the shortest candidate
+)
+
+// Variant is the way a candidate differs from the others
+type Variant struct {
+	Advanced    bool     `json:"advanced"`              // Built from the advanced prompt
+	Compact     bool     `json:"compact"`               // Built from the compact prompt of a small context window
+	Temperature *float64 `json:"temperature,omitempty"` // Provider default when nil
+	Label       string   `json:"label"`
+}
+
+// Candidate is one of several answers generated for the same requirement
+type Candidate struct {
+	Number  int      `json:"number"` // 1 for the candidate that follows the request as it is
+	Rank    int      `json:"rank"`   // 1 for the best; 0 when generation failed
+	Variant Variant  `json:"variant"`
+	Attempt *Attempt `json:"attempt,omitempty"`
+	Score   int      `json:"score"`   // 100 minus penalties, never below 0
+	Reasons []string `json:"reasons"` // What the score is made of
+	Lines   int      `json:"lines"`   // Non-blank lines of code
+	Error   string   `json:"error,omitempty"`
+}
+
+// DiffRow is one line of a side-by-side diff; a line number is 0 on the
+// side the line does not exist
+type DiffRow struct {
+	Kind      string `json:"kind"` // "same", "changed", "removed", "added" or "hunk"
+	Left      string `json:"left"`
+	Right     string `json:"right"`
+	LeftLine  int    `json:"leftLine"`
+	RightLine int    `json:"rightLine"`
+}
+
+// CandidateDiff compares the code of two candidates
+type CandidateDiff struct {
+	Left     int       `json:"left"`  // Candidate number
+	Right    int       `json:"right"` // Candidate number
+	Unified  string    `json:"unified"`
+	Rows     []DiffRow `json:"rows"`
+	Withheld bool      `json:"withheld"` // The policy does not allow the code of one side to be shown
+}
+
+// CandidatesEvent reports the ranked candidates of a job
+type CandidatesEvent struct {
+	JobID      string          `json:"jobId"`
+	Candidates []*Candidate    `json:"candidates"`
+	Diffs      []CandidateDiff `json:"diffs"`
+}
+
+// candidateVariants returns how each of n candidates is generated: the
+// first follows the request, the others alternate the prompt template and
+// spread the temperature. A compact prompt replaces both templates, so
+// compact variants differ in temperature only.
+func candidateVariants(req Request, n int, compact bool) []Variant {
+	variants := make([]Variant, n)
+	for i := range variants {
+		variant := Variant{Advanced: req.Advanced, Temperature: req.Temperature}
+		if i > 0 {
+			temperature := variantTemperatures[(i-1)%len(variantTemperatures)]
+			variant.Advanced = req.Advanced != (i%2 == 1)
+			variant.Temperature = &temperature
+		}
+		if compact {
+			variant.Advanced, variant.Compact = false, true
+		}
+		variant.Label = variantLabel(variant)
+		variants[i] = variant
+	}
+	return variants
+}
+
+// variantLabel describes a variant for display
+func variantLabel(v Variant) string {
+	label := "standard prompt"
+	switch {
+	case v.Compact:
+		label = "compact prompt"
+	case v.Advanced:
+		label = "advanced prompt"
+	}
+	if v.Temperature == nil {
+		return label + ", default temperature"
+	}
+	return label + ", temperature " + strconv.FormatFloat(*v.Temperature, 'f', -1, 64)
+}
+
+// generateCandidates generates a candidate for each variant at the same
+// time. It fails only when every candidate failed.
+func (p Pipeline) generateCandidates(ctx context.Context, req Request, variants []Variant, generate func(candidate int, request llm.Request, prompt string) (*Attempt, error)) ([]*Candidate, error) {
+	model := p.Client.Model()
+	candidates := make([]*Candidate, len(variants))
+	var wg sync.WaitGroup
+	for i, variant := range variants {
+		candidate := &Candidate{Number: i + 1, Variant: variant}
+		candidates[i] = candidate
+
+		variantReq := req
+		variantReq.Advanced = variant.Advanced
+		prompt, _ := BuildPromptFor(variantReq, model)
+		request := llm.Prompt(SystemPrompt, prompt)
+		request.Temperature = variant.Temperature
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attempt, err := generate(candidate.Number, request, prompt)
+			if err != nil {
+				candidate.Error = err.Error()
+				return
+			}
+			candidate.Attempt = attempt
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, candidate := range candidates {
+		if candidate.Attempt != nil {
+			rankCandidates(candidates)
+			return candidates, nil
+		}
+	}
+	return nil, fmt.Errorf("all %d candidates failed: %s", len(candidates), candidates[0].Error)
+}
+
+// rankCandidates scores the generated candidates and orders them best
+// first; ties go to the shorter code, then to the earlier candidate
+func rankCandidates(candidates []*Candidate) {
+	shortest := -1
+	for _, candidate := range candidates {
+		if candidate.Attempt == nil {
+			continue
+		}
+		candidate.Lines = codeLines(candidate.Attempt.Code)
+		if shortest < 0 || candidate.Lines < shortest {
+			shortest = candidate.Lines
+		}
+	}
+	for _, candidate := range candidates {
+		if candidate.Attempt != nil {
+			scoreCandidate(candidate, shortest)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if (a.Attempt == nil) != (b.Attempt == nil) {
+			return a.Attempt != nil
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Lines != b.Lines {
+			return a.Lines < b.Lines
+		}
+		return a.Number < b.Number
+	})
+	for i, candidate := range candidates {
+		if candidate.Attempt != nil {
+			candidate.Rank = i + 1
+		}
+	}
+}
+
+// scoreCandidate takes points off for diagnostics, security risk, unknown
+// references, truncation and length, recording a reason for each
+func scoreCandidate(candidate *Candidate, shortest int) {
+	attempt := candidate.Attempt
+	score := 100
+	var reasons []string
+	penalize := func(points int, reason string) {
+		if points > 0 {
+			score -= points
+			reason += fmt.Sprintf(" (-%d)", points)
+		}
+		reasons = append(reasons, reason)
+	}
+
+	if attempt.Score.Errors == 0 && attempt.Score.Warnings == 0 {
+		reasons = append(reasons, "No errors or warnings")
+	} else {
+		penalize(attempt.Score.Errors*errorPenalty+attempt.Score.Warnings*warningPenalty,
+			fmt.Sprintf("%d error(s) and %d warning(s)", attempt.Score.Errors, attempt.Score.Warnings))
+	}
+
+	if unknown := len(attempt.References.Unknown); unknown > 0 {
+		penalize(unknown*referencePenalty, fmt.Sprintf("%d unknown sheet, column or range reference(s)", unknown))
+	} else {
+		reasons = append(reasons, fmt.Sprintf("All %d sheet, column and range reference(s) exist", len(attempt.References.References)))
+	}
+
+	if attempt.Security.RiskScore == 0 {
+		reasons = append(reasons, "No risky operations")
+	} else {
+		penalize(attempt.Security.RiskScore/riskDivisor,
+			fmt.Sprintf("Security risk %d (%s), policy decision %s", attempt.Security.RiskScore, attempt.Security.RiskLevel, attempt.Security.Decision))
+	}
+
+	if attempt.Response.Truncated {
+		penalize(truncationPenalty, "Answer cut off by the output token limit")
+	}
+
+	if extra := candidate.Lines - shortest; extra > 0 {
+		penalize(extra/linesPerPoint, fmt.Sprintf("%d lines of code, %d more than the shortest", candidate.Lines, extra))
+	} else {
+		reasons = append(reasons, fmt.Sprintf("%d lines of code, the shortest", candidate.Lines))
+	}
+
+	if score < 0 {
+		score = 0
+	}
+	candidate.Score = score
+	candidate.Reasons = reasons
+}
+
+// codeLines counts the non-blank lines of code
+func codeLines(code string) int {
+	count := 0
+	for _, line := range strings.Split(code, "\n") {
+		if strings.TrimSpace(line) != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// diffCandidates compares the best candidate with the next ones
+func diffCandidates(candidates []*Candidate) []CandidateDiff {
+	var diffs []CandidateDiff
+	best := candidates[0]
+	for _, other := range candidates[1:] {
+		if other.Rank == 0 || other.Rank > diffedCandidates {
+			break
+		}
+		unified := vba.UnifiedDiff(
+			fmt.Sprintf("candidate %d", best.Number),
+			fmt.Sprintf("candidate %d", other.Number),
+			best.Attempt.Code, other.Attempt.Code)
+		diffs = append(diffs, CandidateDiff{
+			Left:    best.Number,
+			Right:   other.Number,
+			Unified: unified,
+			Rows:    sideBySide(unified),
+		})
+	}
+	return diffs
+}
+
+// sideBySide turns a unified diff into rows for two columns. Deleted lines
+// are paired with the inserted lines that follow them; a hunk row
+// separates the hunks.
+func sideBySide(unified string) []DiffRow {
+	var rows []DiffRow
+	var removed, added []string
+	left, right := 0, 0
+	flush := func() {
+		for i := 0; i < len(removed) || i < len(added); i++ {
+			row := DiffRow{Kind: "changed"}
+			if i < len(removed) {
+				left++
+				row.Left, row.LeftLine = removed[i], left
+			} else {
+				row.Kind = "added"
+			}
+			if i < len(added) {
+				right++
+				row.Right, row.RightLine = added[i], right
+			} else {
+				row.Kind = "removed"
+			}
+			rows = append(rows, row)
+		}
+		removed, added = nil, nil
+	}
+
+	for _, line := range strings.Split(strings.TrimSuffix(unified, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "+++ "):
+		case strings.HasPrefix(line, "@@"):
+			flush()
+			var leftCount, rightCount int
+			fmt.Sscanf(line, "@@ -%d,%d +%d,%d @@", &left, &leftCount, &right, &rightCount)
+			// The numbers name the first line of the hunk; an empty side
+			// names the line before it
+			if leftCount > 0 {
+				left--
+			}
+			if rightCount > 0 {
+				right--
+			}
+			rows = append(rows, DiffRow{Kind: "hunk", Left: line})
+		case strings.HasPrefix(line, "-"):
+			if len(added) > 0 {
+				flush()
+			}
+			removed = append(removed, line[1:])
+		case strings.HasPrefix(line, "+"):
+			added = append(added, line[1:])
+		case strings.HasPrefix(line, " "):
+			flush()
+			left++
+			right++
+			rows = append(rows, DiffRow{Kind: "same", Left: line[1:], Right: line[1:], LeftLine: left, RightLine: right})
+		}
+	}
+	flush()
+	return rows
+}
+
+// candidates returns the number of candidates to generate, within limits
+func (r Request) candidates() int {
+	switch {
+	case r.Candidates < 1:
+		return 1
+	case r.Candidates > MaxCandidates:
+		return MaxCandidates
+	}
+	return r.Candidates
+}
@@ -0,0 +1,103 @@
+package generation
+
+import (
+	"fmt"
+	"strings"
+
+	"excel-automation-mcp/backend/service/validation"
+)
+
+// Generated text only reaches the frontend once the security policy has
+// seen it. Attempts the policy denies or wants confirmed are sent without
+// their code and response text; a confirmed result is released in full by
+// Manager.Release.
+
+// allowed reports whether the policy lets an attempt's code be shown
+func (a *Attempt) allowed() bool {
+	return a.Security.Decision == validation.PolicyAllow
+}
+
+// public returns the attempt as it may be shown: unchanged when allowed,
+// otherwise a copy without its code and response text
+func (a *Attempt) public() *Attempt {
+	if a == nil || a.allowed() {
+		return a
+	}
+	withheld := *a
+	withheld.Code = ""
+	withheld.Withheld = true
+	if a.Response != nil {
+		response := *a.Response
+		response.Content = ""
+		withheld.Response = &response
+	}
+	return &withheld
+}
+
+// public returns the candidate with its attempt as it may be shown
+func (c *Candidate) public() *Candidate {
+	shown := *c
+	shown.Attempt = c.Attempt.public()
+	return &shown
+}
+
+// gate applies the security policy to the best attempt and returns the
+// result as it may be shown. Code the policy denies is dropped for good;
+// code that needs confirmation is kept in the returned result's held copy
+// until Manager.Release.
+func gate(result *Result, best *Attempt, security *validation.SecurityChecker) *Result {
+	report, err := security.Gate(best.Code)
+	result.Security = report
+	result.Decision = report.Decision
+
+	shown := *result
+	shown.Attempts = make([]*Attempt, len(result.Attempts))
+	for i, attempt := range result.Attempts {
+		shown.Attempts[i] = attempt.public()
+	}
+	if result.Candidates != nil {
+		shown.Candidates, shown.Diffs = publicCandidates(result.Candidates, result.Diffs)
+	}
+
+	switch {
+	case err != nil:
+		shown.Blocked = err.Error()
+	case report.NeedsConfirmation():
+		full := *result
+		shown.held = &full
+	default:
+		return &shown
+	}
+	shown.Code = ""
+	shown.Withheld = true
+	if result.Response != nil {
+		response := *result.Response
+		response.Content = ""
+		shown.Response = &response
+	}
+	return &shown
+}
+
+// publicCandidates returns the candidates and diffs as they may be shown;
+// a diff is withheld when either side is
+func publicCandidates(candidates []*Candidate, diffs []CandidateDiff) ([]*Candidate, []CandidateDiff) {
+	allowed := map[int]bool{}
+	shown := make([]*Candidate, len(candidates))
+	for i, candidate := range candidates {
+		shown[i] = candidate.public()
+		allowed[candidate.Number] = candidate.Attempt == nil || candidate.Attempt.allowed()
+	}
+	shownDiffs := make([]CandidateDiff, len(diffs))
+	for i, diff := range diffs {
+		if !allowed[diff.Left] || !allowed[diff.Right] {
+			diff = CandidateDiff{Left: diff.Left, Right: diff.Right, Withheld: true}
+		}
+		shownDiffs[i] = diff
+	}
+	return shown, shownDiffs
+}
+
+// confirmMessage explains why a result waits for confirmation
+func confirmMessage(result *Result) string {
+	return fmt.Sprintf("The code needs confirmation before it is shown: %s", strings.Join(result.Security.Reasons, "; "))
+}
@@ -0,0 +1,156 @@
+package generation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job is a running generation job
+type Job struct {
+	ID          string    `json:"id"`
+	Requirement string    `json:"requirement"`
+	Started     time.Time `json:"started"`
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// maxHeldResults bounds the finished results kept for confirmation; the
+// oldest is dropped first
+const maxHeldResults = 20
+
+// Manager runs generation jobs in the background and cancels them by ID. It
+// keeps the results whose code waits for the user's confirmation.
+type Manager struct {
+	mu        sync.Mutex
+	jobs      map[string]*Job
+	held      map[string]*Result
+	heldOrder []string
+}
+
+// NewManager creates a manager without jobs
+func NewManager() *Manager {
+	return &Manager{jobs: map[string]*Job{}, held: map[string]*Result{}}
+}
+
+// Start runs a job in the background and returns its ID at once. The job
+// stops when ctx, normally the app's root context, is cancelled or when
+// Cancel is called with its ID; its events go to emit.
+func (m *Manager) Start(ctx context.Context, pipeline Pipeline, req Request, emit Emitter) string {
+	jobCtx, cancel := context.WithCancel(ctx)
+	job := &Job{ID: newJobID(), Requirement: req.Requirement, Started: time.Now(), cancel: cancel, done: make(chan struct{})}
+
+	m.mu.Lock()
+	m.jobs[job.ID] = job
+	m.mu.Unlock()
+
+	go func() {
+		defer func() {
+			cancel()
+			m.mu.Lock()
+			delete(m.jobs, job.ID)
+			m.mu.Unlock()
+			close(job.done)
+		}()
+		if result, err := pipeline.Run(jobCtx, job.ID, req, emit); err == nil && result.held != nil {
+			m.hold(job.ID, result.held)
+		}
+	}()
+	return job.ID
+}
+
+// hold keeps a result until it is released
+func (m *Manager) hold(jobID string, result *Result) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.heldOrder) == maxHeldResults {
+		delete(m.held, m.heldOrder[0])
+		m.heldOrder = m.heldOrder[1:]
+	}
+	m.held[jobID] = result
+	m.heldOrder = append(m.heldOrder, jobID)
+}
+
+// Release returns the complete result of a job whose code needed
+// confirmation, once the user has confirmed it. A result is released once.
+func (m *Manager) Release(jobID string) (*Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result, ok := m.held[jobID]
+	if !ok {
+		return nil, fmt.Errorf("no generation result of job %q waits for confirmation", jobID)
+	}
+	delete(m.held, jobID)
+	for i, id := range m.heldOrder {
+		if id == jobID {
+			m.heldOrder = append(m.heldOrder[:i], m.heldOrder[i+1:]...)
+			break
+		}
+	}
+	return result, nil
+}
+
+// Cancel stops a running job
+func (m *Manager) Cancel(jobID string) error {
+	m.mu.Lock()
+	job, ok := m.jobs[jobID]
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no running generation job %q", jobID)
+	}
+	job.cancel()
+	return nil
+}
+
+// CancelAll stops every running job and waits for them to finish
+func (m *Manager) CancelAll() {
+	m.mu.Lock()
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+	m.mu.Unlock()
+
+	for _, job := range jobs {
+		job.cancel()
+	}
+	for _, job := range jobs {
+		<-job.done
+	}
+}
+
+// Wait blocks until a job has finished; unknown jobs have already finished
+func (m *Manager) Wait(jobID string) {
+	m.mu.Lock()
+	job, ok := m.jobs[jobID]
+	m.mu.Unlock()
+	if ok {
+		<-job.done
+	}
+}
+
+// Jobs lists the running jobs, oldest first
+func (m *Manager) Jobs() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, Job{ID: job.ID, Requirement: job.Requirement, Started: job.Started})
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Started.Before(jobs[j].Started) })
+	return jobs
+}
+
+// newJobID returns a random job ID
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("job-%d", time.Now().UnixNano())
+	}
+	return "job-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,383 @@
+// Package generation runs a code generation job end to end: it builds the
+// MCP prompt, streams the model's answer and validates the code, reporting
+// each step through events so the frontend can follow along. When the
+// checks find problems it can send them back to the model for repair, and
+// it can generate several candidates and keep the best.
+package generation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"excel-automation-mcp/backend/service/llm"
+	"excel-automation-mcp/backend/service/mcp"
+	"excel-automation-mcp/backend/service/validation"
+)
+
+// Phase is a step of a generation job
+type Phase string
+
+const (
+	PhaseBuildingPrompt Phase = "building_prompt"
+	PhasePromptBuilt    Phase = "prompt_built"
+	PhaseStreaming      Phase = "streaming"
+	PhaseValidating     Phase = "validating"
+	PhaseRanking        Phase = "ranking"
+	PhaseRepairing      Phase = "repairing"
+	PhaseDone           Phase = "done"
+	PhaseFailed         Phase = "failed"
+	PhaseCancelled      Phase = "cancelled"
+)
+
+// Event names sent to the frontend
+const (
+	EventPhase      = "generation:phase"      // PhaseEvent
+	EventDelta      = "generation:delta"      // DeltaEvent
+	EventAttempt    = "generation:attempt"    // AttemptEvent
+	EventCandidates = "generation:candidates" // CandidatesEvent
+	EventResult     = "generation:result"     // Result
+	EventError      = "generation:error"      // ErrorEvent
+)
+
+// SystemPrompt frames every generation request
+const SystemPrompt = "You are an expert Excel VBA developer. Follow the instructions of the prompt exactly and return the VBA code in ```vba fenced code blocks."
+
+// Emitter delivers an event to the frontend
+type Emitter func(event string, payload interface{})
+
+// Request describes the code to generate
+type Request struct {
+	Structure              mcp.DataRange        `json:"structure"`
+	Requirement            string               `json:"requirement"`
+	Advanced               bool                 `json:"advanced"` // Use the advanced prompt with task-specific examples
+	IncludeStandardModules bool                 `json:"includeStandardModules"`
+	TargetExcelVersion     string               `json:"targetExcelVersion"`
+	ExistingModules        []mcp.ExistingModule `json:"existingModules"`
+	Temperature            *float64             `json:"temperature,omitempty"`
+	BypassCache            bool                 `json:"bypassCache"`    // Ask the model again even when a cached response exists
+	MaxAttempts            int                  `json:"maxAttempts"`    // Generations including repairs; 0 or 1 generates once, at most MaxAttempts
+	RepairWarnings         bool                 `json:"repairWarnings"` // Also repair code with warnings but no errors
+	Candidates             int                  `json:"candidates"`     // First answers to generate and rank, at most MaxCandidates; the best is repaired
+}
+
+// PhaseEvent reports that a job entered a phase
+type PhaseEvent struct {
+	JobID   string    `json:"jobId"`
+	Phase   Phase     `json:"phase"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// DeltaEvent reports the progress of a streaming answer and carries no
+// text: code may only be shown once the security policy has checked all of
+// it, so the text arrives with the attempt and result events. The counter
+// is for progress display only.
+type DeltaEvent struct {
+	JobID     string `json:"jobId"`
+	Attempt   int    `json:"attempt"`   // 1 for the first generation
+	Candidate int    `json:"candidate"` // Candidate of the first generation, 0 when there is only one
+	Received  int    `json:"received"`  // Characters (runes) received so far in this attempt or candidate
+}
+
+// ErrorEvent reports why a job stopped
+type ErrorEvent struct {
+	JobID     string `json:"jobId"`
+	Error     string `json:"error"`
+	Cancelled bool   `json:"cancelled"`
+}
+
+// Result is the outcome of a finished job. The response, code and reports
+// are those of the best attempt. Code the security policy does not allow
+// is withheld: for good when it is denied, until Manager.Release when it
+// needs confirmation.
+type Result struct {
+	JobID       string                      `json:"jobId"`
+	Prompt      string                      `json:"prompt"`
+	Response    *llm.Response               `json:"response"`
+	Code        string                      `json:"code"` // VBA code blocks of the response
+	Validation  *validation.Report          `json:"validation"`
+	References  *validation.ReferenceReport `json:"references"`
+	Security    *validation.SecurityReport  `json:"security"`
+	Attempts    []*Attempt                  `json:"attempts"`    // Every generation in order, with its diagnostics
+	BestAttempt int                         `json:"bestAttempt"` // Number of the attempt the result comes from
+	StopReason  StopReason                  `json:"stopReason"`
+	Candidates  []*Candidate                `json:"candidates,omitempty"` // Ranked best first; the best is the first attempt
+	Diffs       []CandidateDiff             `json:"diffs,omitempty"`      // The best candidate against the next ones
+	Decision    validation.PolicyAction     `json:"decision"`             // Security policy decision on the code
+	Withheld    bool                        `json:"withheld"`             // Code and response text are not included
+	Blocked     string                      `json:"blocked,omitempty"`    // Why the policy denied the code
+
+	held *Result // The complete result while it waits for confirmation
+}
+
+// Pipeline holds what a job needs besides its request
+type Pipeline struct {
+	Client   llm.Client
+	Security *validation.SecurityChecker // Default policy when nil
+}
+
+// BuildPrompt builds the MCP prompt for a request
+func BuildPrompt(req Request) string {
+	if req.Advanced {
+		config := mcp.DefaultAdvancedConfig()
+		config.TaskType = mcp.DetectTaskType(req.Structure, req.Requirement)
+		if req.IncludeStandardModules {
+			config.IncludeModules = []string{"SQLUtils", "DataTools", "UIHelpers"}
+		}
+		if req.TargetExcelVersion != "" {
+			config.TargetExcelVersion = req.TargetExcelVersion
+		}
+		config.ExistingModules = req.ExistingModules
+		return mcp.GenerateAdvancedPrompt(req.Structure, req.Requirement, config)
+	}
+
+	config := mcp.DefaultPromptConfig()
+	if req.IncludeStandardModules {
+		config.IncludeModules = []string{"SQLUtils", "DataTools", "UIHelpers"}
+	}
+	if req.TargetExcelVersion != "" {
+		config.TargetExcelVersion = req.TargetExcelVersion
+	}
+	config.ExistingModules = req.ExistingModules
+	return mcp.GenerateExaMCPPromptWithConfig(req.Structure, req.Requirement, config)
+}
+
+// SmallContextWindow is the context size, in tokens, below which prompts
+// are compacted
+const SmallContextWindow = 16384
+
+// BuildPromptFor builds the prompt for a request to the given model. Models
+// with a small context window get the compact template without examples or
+// standard modules, with less sample data and existing source, shrunk
+// further until the prompt leaves room for the answer. It reports whether
+// the prompt was compacted.
+func BuildPromptFor(req Request, model llm.ModelInfo) (string, bool) {
+	if model.ContextWindow == 0 || model.ContextWindow >= SmallContextWindow {
+		return BuildPrompt(req), false
+	}
+
+	budget := model.ContextWindow - model.MaxOutputTokens // Tokens left for the prompt
+	if budget <= 0 {
+		budget = model.ContextWindow / 2
+	}
+	config := mcp.DefaultPromptConfig()
+	config.OutputType = "Compact"
+	config.IncludeExamples = false
+	config.MaxSampleRows = 2
+	config.ExistingModules = req.ExistingModules
+	config.MaxExistingCode = budget * 4 / 3 // A third of the budget, at four characters per token
+	if req.TargetExcelVersion != "" {
+		config.TargetExcelVersion = req.TargetExcelVersion
+	}
+
+	prompt := mcp.GenerateExaMCPPromptWithConfig(req.Structure, req.Requirement, config)
+	for step := 0; llm.EstimateTokens(SystemPrompt+prompt) > budget && step < 2; step++ {
+		// First drop the sample rows, then all existing source but the
+		// procedure names
+		if step == 0 {
+			config.MaxSampleRows = 0
+		} else {
+			config.MaxExistingCode = 1
+		}
+		prompt = mcp.GenerateExaMCPPromptWithConfig(req.Structure, req.Requirement, config)
+	}
+	return prompt, true
+}
+
+// Run builds the prompt, streams the response and validates the code,
+// emitting the phase, delta, attempt and result events of the job. While
+// the code has problems and attempts are left, it asks the model to repair
+// them, stopping early once a repair is no better than the best attempt so
+// far. With several candidates the first attempt is the best ranked of
+// them. The best attempt passes the security gate before its code is
+// released. A cancelled context stops the job with an error wrapping
+// context.Canceled.
+func (p Pipeline) Run(ctx context.Context, jobID string, req Request, emit Emitter) (*Result, error) {
+	if emit == nil {
+		emit = func(string, interface{}) {}
+	}
+	phase := func(phase Phase, message string) {
+		emit(EventPhase, PhaseEvent{JobID: jobID, Phase: phase, Message: message, Time: time.Now()})
+	}
+	fail := func(err error) (*Result, error) {
+		cancelled := errors.Is(err, context.Canceled)
+		if cancelled {
+			phase(PhaseCancelled, "Generation cancelled")
+		} else {
+			phase(PhaseFailed, err.Error())
+		}
+		emit(EventError, ErrorEvent{JobID: jobID, Error: err.Error(), Cancelled: cancelled})
+		return nil, err
+	}
+
+	if strings.TrimSpace(req.Requirement) == "" {
+		return fail(errors.New("requirement is empty"))
+	}
+	if p.Client == nil {
+		return fail(errors.New("no LLM provider is configured"))
+	}
+
+	phase(PhaseBuildingPrompt, "Building prompt")
+	model := p.Client.Model()
+	prompt, compact := BuildPromptFor(req, model)
+	if err := ctx.Err(); err != nil {
+		return fail(err)
+	}
+	if compact {
+		phase(PhasePromptBuilt, fmt.Sprintf("Prompt built (%d characters, compacted for the %d-token context of %s)", len(prompt), model.ContextWindow, model.Name))
+	} else {
+		phase(PhasePromptBuilt, fmt.Sprintf("Prompt built (%d characters)", len(prompt)))
+	}
+
+	if req.BypassCache {
+		ctx = llm.WithoutCache(ctx)
+	}
+	security := p.Security
+	if security == nil {
+		security = validation.NewSecurityChecker(nil)
+	}
+	maxAttempts := req.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	} else if maxAttempts > MaxAttempts {
+		maxAttempts = MaxAttempts
+	}
+
+	stream := func(number, candidate int, request llm.Request) (*llm.Response, error) {
+		received := 0
+		return p.Client.Stream(ctx, request, func(delta string) {
+			received += utf8.RuneCountInString(delta)
+			emit(EventDelta, DeltaEvent{JobID: jobID, Attempt: number, Candidate: candidate, Received: received})
+		})
+	}
+
+	result := &Result{JobID: jobID, Prompt: prompt}
+	var best *Attempt
+	request, attemptPrompt := llm.Prompt(SystemPrompt, prompt), prompt
+	request.Temperature = req.Temperature
+	for number := 1; ; number++ {
+		var attempt *Attempt
+		switch {
+		case number == 1 && req.candidates() > 1:
+			variants := candidateVariants(req, req.candidates(), compact)
+			phase(PhaseStreaming, fmt.Sprintf("Waiting for %d candidates from %s", len(variants), model.Name))
+			candidates, err := p.generateCandidates(ctx, req, variants, func(candidate int, request llm.Request, prompt string) (*Attempt, error) {
+				response, err := stream(number, candidate, request)
+				if err != nil {
+					return nil, err
+				}
+				return checkAttempt(number, prompt, response, req.Structure, security), nil
+			})
+			if err != nil {
+				return fail(err)
+			}
+			phase(PhaseRanking, "Ranking candidates")
+			result.Candidates = candidates
+			winner := candidates[0]
+			result.Diffs = diffCandidates(result.Candidates)
+			shownCandidates, shownDiffs := publicCandidates(result.Candidates, result.Diffs)
+			emit(EventCandidates, CandidatesEvent{JobID: jobID, Candidates: shownCandidates, Diffs: shownDiffs})
+
+			// Repairs continue the winner's conversation
+			attempt, prompt = winner.Attempt, winner.Attempt.Prompt
+			result.Prompt = prompt
+			request.Temperature = winner.Variant.Temperature
+		case number == 1:
+			phase(PhaseStreaming, fmt.Sprintf("Waiting for %s", model.Name))
+			response, err := stream(number, 0, request)
+			if err != nil {
+				return fail(err)
+			}
+			phase(PhaseValidating, "Validating generated code")
+			attempt = checkAttempt(number, attemptPrompt, response, req.Structure, security)
+		default:
+			phase(PhaseRepairing, fmt.Sprintf("Attempt %d of %d: asking %s to fix %s", number, maxAttempts, model.Name, result.Attempts[number-2].summary()))
+			response, err := stream(number, 0, request)
+			if err != nil {
+				// A failed repair keeps what the earlier attempts produced
+				if ctx.Err() != nil {
+					return fail(err)
+				}
+				phase(PhaseRepairing, fmt.Sprintf("Repair attempt %d failed: %v", number, err))
+				result.StopReason = StopRepairFailed
+				break
+			}
+			phase(PhaseValidating, "Validating generated code")
+			attempt = checkAttempt(number, attemptPrompt, response, req.Structure, security)
+		}
+		if attempt == nil {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return fail(err)
+		}
+		result.Attempts = append(result.Attempts, attempt)
+		emit(EventAttempt, AttemptEvent{JobID: jobID, Attempt: attempt.public()})
+
+		improved := best == nil || attempt.Score.Better(best.Score)
+		if improved {
+			best = attempt
+		}
+		if attempt.clean(req.RepairWarnings) {
+			result.StopReason = StopClean
+			break
+		}
+		if !improved {
+			result.StopReason = StopNotImproving
+			break
+		}
+		if number == maxAttempts {
+			result.StopReason = StopMaxAttempts
+			break
+		}
+		request, attemptPrompt = repairRequest(prompt, attempt, req.RepairWarnings, request.Temperature)
+	}
+
+	result.Response = best.Response
+	result.Code = best.Code
+	result.Validation = best.Validation
+	result.References = best.References
+	result.BestAttempt = best.Number
+	shown := gate(result, best, security)
+	emit(EventResult, shown)
+	phase(PhaseDone, doneMessage(shown))
+	return shown, nil
+}
+
+// doneMessage summarizes how a job ended
+func doneMessage(result *Result) string {
+	switch {
+	case result.Blocked != "":
+		return "Generation finished, but the security policy blocked the code: " + result.Blocked
+	case result.held != nil:
+		return confirmMessage(result)
+	}
+	message := "Generation finished"
+	switch {
+	case result.Response.Cached:
+		message = "Generation finished from a cached response"
+	case result.Response.Truncated:
+		message = "Generation finished, but the response was cut off by the output token limit"
+	}
+	if len(result.Candidates) > 0 {
+		message += fmt.Sprintf("; candidate %d ranked best of %d", result.Candidates[0].Number, len(result.Candidates))
+	}
+	if len(result.Attempts) == 1 {
+		return message
+	}
+	switch result.StopReason {
+	case StopClean:
+		message += fmt.Sprintf("; attempt %d passed the checks", result.BestAttempt)
+	case StopNotImproving:
+		message += fmt.Sprintf("; repairs stopped improving, keeping attempt %d", result.BestAttempt)
+	case StopMaxAttempts:
+		message += fmt.Sprintf("; %d attempts used, keeping attempt %d", len(result.Attempts), result.BestAttempt)
+	case StopRepairFailed:
+		message += fmt.Sprintf("; a repair failed, keeping attempt %d", result.BestAttempt)
+	}
+	return message
+}
@@ -0,0 +1,164 @@
+package generation
+
+import (
+	"fmt"
+	"strings"
+
+	"excel-automation-mcp/backend/service/llm"
+	"excel-automation-mcp/backend/service/mcp"
+	"excel-automation-mcp/backend/service/validation"
+	"excel-automation-mcp/backend/service/vbaexport"
+)
+
+// Limits of the self-repair loop
+const (
+	MaxAttempts          = 5  // Generations per job, the first included
+	maxRepairDiagnostics = 25 // Problems listed in one repair prompt
+)
+
+// StopReason tells why a job stopped generating
+type StopReason string
+
+const (
+	StopClean        StopReason = "clean"         // The checks found nothing left to repair
+	StopNotImproving StopReason = "not_improving" // The last repair was no better than the best attempt
+	StopMaxAttempts  StopReason = "max_attempts"  // The attempt limit was reached
+	StopRepairFailed StopReason = "repair_failed" // A repair request failed; earlier attempts are kept
+)
+
+// SourceGeneration marks diagnostics about the response as a whole
+const SourceGeneration = "generation"
+
+// CodeNoCode is reported when a response holds no VBA code block
+const CodeNoCode = "NO_CODE"
+
+// Score counts the problems that matter for repair; fewer errors win, then
+// fewer warnings
+type Score struct {
+	Errors   int `json:"errors"`
+	Warnings int `json:"warnings"`
+}
+
+// Better reports whether s has fewer problems than other
+func (s Score) Better(other Score) bool {
+	if s.Errors != other.Errors {
+		return s.Errors < other.Errors
+	}
+	return s.Warnings < other.Warnings
+}
+
+// Attempt is one generation of a job with what the checks found in it
+type Attempt struct {
+	Number      int                         `json:"number"` // 1 for the first generation
+	Prompt      string                      `json:"prompt"` // The MCP prompt for the first attempt, the repair prompt after
+	Response    *llm.Response               `json:"response"`
+	Code        string                      `json:"code"`
+	Validation  *validation.Report          `json:"validation"`
+	References  *validation.ReferenceReport `json:"references"`
+	Security    *validation.SecurityReport  `json:"security"`
+	Diagnostics *validation.Report          `json:"diagnostics"` // Structure, reference and security problems together
+	Score       Score                       `json:"score"`
+	Withheld    bool                        `json:"withheld"` // Code and response text left out because the policy does not allow them
+}
+
+// AttemptEvent reports a finished attempt
+type AttemptEvent struct {
+	JobID   string   `json:"jobId"`
+	Attempt *Attempt `json:"attempt"`
+}
+
+// checkAttempt runs the structure, reference and security checks on a
+// response. The structure of each module is checked on its own.
+func checkAttempt(number int, prompt string, response *llm.Response, structure mcp.DataRange, security *validation.SecurityChecker) *Attempt {
+	code := validation.ExtractVBACode(response.Content)
+	attempt := &Attempt{
+		Number:      number,
+		Prompt:      prompt,
+		Response:    response,
+		Code:        code,
+		Validation:  vbaexport.Validate(response.Content),
+		References:  validation.CheckReferences(code, structure),
+		Security:    security.Check(code),
+		Diagnostics: validation.NewReport(),
+	}
+	if strings.TrimSpace(code) == "" {
+		attempt.Diagnostics.Add(validation.Diagnostic{
+			Code:     CodeNoCode,
+			Severity: validation.SeverityError,
+			Message:  "The response contains no ```vba code block",
+			Source:   SourceGeneration,
+		})
+	}
+	attempt.Diagnostics.Merge(attempt.Validation)
+	attempt.Diagnostics.Merge(attempt.References.Diagnostics())
+	attempt.Diagnostics.Merge(attempt.Security.Diagnostics())
+	attempt.Diagnostics.Sort()
+	attempt.Score = Score{Errors: attempt.Diagnostics.ErrorCount, Warnings: attempt.Diagnostics.WarningCount}
+	return attempt
+}
+
+// clean reports whether an attempt needs no repair
+func (a *Attempt) clean(repairWarnings bool) bool {
+	return a.Score.Errors == 0 && (!repairWarnings || a.Score.Warnings == 0)
+}
+
+// summary describes the problems of an attempt in a few words
+func (a *Attempt) summary() string {
+	return fmt.Sprintf("%d error(s) and %d warning(s)", a.Score.Errors, a.Score.Warnings)
+}
+
+// repairRequest asks the model to fix the problems of an attempt. The
+// conversation holds the original prompt and the attempt's answer, so the
+// repair prompt itself only lists the problems.
+func repairRequest(prompt string, attempt *Attempt, repairWarnings bool, temperature *float64) (llm.Request, string) {
+	repair := repairPrompt(attempt, repairWarnings)
+	request := llm.Request{
+		System: SystemPrompt,
+		Messages: []llm.Message{
+			{Role: llm.RoleUser, Content: prompt},
+			{Role: llm.RoleAssistant, Content: attempt.Response.Content},
+			{Role: llm.RoleUser, Content: repair},
+		},
+		Temperature: temperature,
+	}
+	return request, repair
+}
+
+// repairPrompt lists the problems to fix, quoting the line each one is on
+func repairPrompt(attempt *Attempt, repairWarnings bool) string {
+	var prompt strings.Builder
+	prompt.WriteString("Static checks found problems in the VBA code you returned. Fix every problem listed below and return the complete corrected code, every module in full, in ```vba fenced code blocks. Keep everything unrelated to these problems as it is.\n\n")
+	prompt.WriteString("## PROBLEMS\n")
+
+	lines := strings.Split(attempt.Code, "\n")
+	listed, skipped := 0, 0
+	for _, d := range attempt.Diagnostics.Diagnostics {
+		if d.Severity == validation.SeverityInfo || (d.Severity == validation.SeverityWarning && !repairWarnings) {
+			continue
+		}
+		if listed == maxRepairDiagnostics {
+			skipped++
+			continue
+		}
+		listed++
+		prompt.WriteString(fmt.Sprintf("%d. [%s] ", listed, d.Severity))
+		if d.Line > 0 && d.Line <= len(lines) {
+			prompt.WriteString(fmt.Sprintf("Line %d `%s`: ", d.Line, strings.TrimSpace(lines[d.Line-1])))
+		}
+		prompt.WriteString(d.Message)
+		if d.Source != "" {
+			prompt.WriteString(" (" + d.Source + " check)")
+		}
+		prompt.WriteString("\n")
+	}
+	if skipped > 0 {
+		prompt.WriteString(fmt.Sprintf("...and %d more of the same kinds.\n", skipped))
+	}
+	if attempt.Security.Blocked() {
+		prompt.WriteString("\nThe security policy forbids the flagged calls: remove them or replace them with a safe alternative, do not disguise them.\n")
+	}
+	if attempt.Response.Truncated {
+		prompt.WriteString("\nYour previous answer was cut off by the output limit; keep the corrected code shorter.\n")
+	}
+	return prompt.String()
+}
@@ -0,0 +1,406 @@
+package jetsql
+
+import "strings"
+
+// Node is any element of the SQL syntax tree
+type Node interface {
+	Span() (int, int) // Byte offsets of the first character and just after the last
+}
+
+// Statement is a complete SQL statement
+type Statement interface {
+	Node
+	stmtNode()
+}
+
+// Expr is a value expression
+type Expr interface {
+	Node
+	exprNode()
+}
+
+// TableExpr is an entry of a FROM clause
+type TableExpr interface {
+	Node
+	tableNode()
+}
+
+// Range is the byte span of a node
+type Range struct {
+	Start int
+	Stop  int
+}
+
+// Span implements Node
+func (r Range) Span() (int, int) { return r.Start, r.Stop }
+
+// SelectStmt is SELECT ... FROM ... with its optional clauses
+type SelectStmt struct {
+	Range
+	Distinct    bool
+	Top         *Literal // TOP n
+	TopPercent  bool
+	Columns     []SelectItem
+	From        []TableExpr // Comma-separated entries are cross joined
+	Where       Expr
+	GroupBy     []Expr
+	Having      Expr
+	OrderBy     []OrderItem
+	Union       *SelectStmt // Next SELECT in a UNION chain
+	UnionAll    bool
+	Parenthesis bool // Statement was wrapped in parentheses
+}
+
+// SelectItem is one output column of a SELECT
+type SelectItem struct {
+	Range
+	Expr      Expr   // Nil for * and table.*
+	Star      bool   // * or table.*
+	StarTable string // Table qualifier of table.*
+	Alias     string
+	AliasPos  int
+}
+
+// OrderItem is one ORDER BY key
+type OrderItem struct {
+	Range
+	Expr Expr
+	Desc bool
+}
+
+// TransformStmt is a Jet crosstab query:
+// TRANSFORM aggregate SELECT ... GROUP BY ... PIVOT expr [IN (values)]
+type TransformStmt struct {
+	Range
+	Value  Expr
+	Select *SelectStmt
+	Pivot  Expr
+	In     []Expr
+}
+
+// InsertStmt is INSERT INTO table [(columns)] VALUES (...) | SELECT ...
+type InsertStmt struct {
+	Range
+	Table   *TableRef
+	Columns []*ColumnRef
+	Values  []Expr
+	Select  *SelectStmt
+}
+
+// UpdateStmt is UPDATE table SET column = value, ... [WHERE ...]
+type UpdateStmt struct {
+	Range
+	Table *TableRef
+	Set   []Assignment
+	Where Expr
+}
+
+// Assignment is a column = value pair of UPDATE ... SET
+type Assignment struct {
+	Column *ColumnRef
+	Value  Expr
+}
+
+// DeleteStmt is DELETE [*] FROM table [WHERE ...]
+type DeleteStmt struct {
+	Range
+	Table *TableRef
+	Where Expr
+}
+
+func (*SelectStmt) stmtNode()    {}
+func (*TransformStmt) stmtNode() {}
+func (*InsertStmt) stmtNode()    {}
+func (*UpdateStmt) stmtNode()    {}
+func (*DeleteStmt) stmtNode()    {}
+
+// TableRef names a worksheet or range, e.g. [Sheet1$] or [Data$A1:D100].
+// Names that do not end in $ are workbook-level named ranges.
+type TableRef struct {
+	Range
+	Database string // External source of [Excel 12.0;Database=...].[Sheet1$]
+	Name     string // Full name without brackets
+	Sheet    string // Worksheet part before $, empty for named ranges
+	Address  string // Cell range after $, empty for the whole sheet
+	NamePos  int    // Offset of the table name token
+	NameEnd  int
+	Alias    string
+	AliasPos int
+}
+
+// JoinKind is the type of a JOIN
+type JoinKind string
+
+const (
+	JoinInner JoinKind = "INNER"
+	JoinLeft  JoinKind = "LEFT"
+	JoinRight JoinKind = "RIGHT"
+	JoinCross JoinKind = "CROSS" // Comma-separated FROM entries
+)
+
+// JoinExpr is left JOIN right ON condition
+type JoinExpr struct {
+	Range
+	Kind  JoinKind
+	Left  TableExpr
+	Right TableExpr
+	On    Expr
+}
+
+// SubqueryTable is (SELECT ...) AS alias in a FROM clause
+type SubqueryTable struct {
+	Range
+	Select   *SelectStmt
+	Alias    string
+	AliasPos int
+}
+
+func (*TableRef) tableNode()      {}
+func (*JoinExpr) tableNode()      {}
+func (*SubqueryTable) tableNode() {}
+
+// ColumnRef is a column name, optionally qualified by a table or alias
+type ColumnRef struct {
+	Range
+	Table   string
+	Name    string
+	NamePos int // Offset of the column name token
+	NameEnd int
+}
+
+// LiteralKind is the type of a literal value
+type LiteralKind string
+
+const (
+	LitNumber LiteralKind = "number"
+	LitString LiteralKind = "string"
+	LitDate   LiteralKind = "date"
+	LitNull   LiteralKind = "null"
+	LitBool   LiteralKind = "boolean" // True / False / Yes / No
+)
+
+// Literal is a constant value; Value holds the unquoted text
+type Literal struct {
+	Range
+	Kind  LiteralKind
+	Value string
+}
+
+// Param is a ? or @name parameter placeholder
+type Param struct {
+	Range
+	Name string
+}
+
+// BinaryExpr is X Op Y. Op is upper-cased for keyword operators
+// (AND, OR, XOR, MOD, LIKE) and "<>" for both <> and !=.
+type BinaryExpr struct {
+	Range
+	Op string
+	X  Expr
+	Y  Expr
+}
+
+// UnaryExpr is NOT X, -X or +X
+type UnaryExpr struct {
+	Range
+	Op string
+	X  Expr
+}
+
+// FuncCall is a function or aggregate call such as SUM([Sales]) or COUNT(*)
+type FuncCall struct {
+	Range
+	Name     string
+	NamePos  int
+	Args     []Expr
+	Star     bool // COUNT(*)
+	Distinct bool // COUNT(DISTINCT x), rejected by Jet but parsed for diagnostics
+}
+
+// InExpr is X [NOT] IN (list) or X [NOT] IN (SELECT ...)
+type InExpr struct {
+	Range
+	X      Expr
+	Not    bool
+	List   []Expr
+	Select *SelectStmt
+}
+
+// BetweenExpr is X [NOT] BETWEEN Low AND High
+type BetweenExpr struct {
+	Range
+	X    Expr
+	Not  bool
+	Low  Expr
+	High Expr
+}
+
+// IsNullExpr is X IS [NOT] NULL
+type IsNullExpr struct {
+	Range
+	X   Expr
+	Not bool
+}
+
+// ExistsExpr is EXISTS (SELECT ...)
+type ExistsExpr struct {
+	Range
+	Select *SelectStmt
+}
+
+// SubqueryExpr is a scalar (SELECT ...)
+type SubqueryExpr struct {
+	Range
+	Select *SelectStmt
+}
+
+// ParenExpr is (X)
+type ParenExpr struct {
+	Range
+	X Expr
+}
+
+func (*ColumnRef) exprNode()    {}
+func (*Literal) exprNode()      {}
+func (*Param) exprNode()        {}
+func (*BinaryExpr) exprNode()   {}
+func (*UnaryExpr) exprNode()    {}
+func (*FuncCall) exprNode()     {}
+func (*InExpr) exprNode()       {}
+func (*BetweenExpr) exprNode()  {}
+func (*IsNullExpr) exprNode()   {}
+func (*ExistsExpr) exprNode()   {}
+func (*SubqueryExpr) exprNode() {}
+func (*ParenExpr) exprNode()    {}
+
+// aggregates are the Jet SQL aggregate functions
+var aggregates = map[string]bool{
+	"AVG": true, "COUNT": true, "FIRST": true, "LAST": true, "MAX": true, "MIN": true,
+	"STDEV": true, "STDEVP": true, "SUM": true, "VAR": true, "VARP": true,
+}
+
+// IsAggregate reports whether name is a Jet SQL aggregate function
+func IsAggregate(name string) bool {
+	return aggregates[strings.ToUpper(name)]
+}
+
+// Inspect walks the tree in depth-first order, calling f for each node. If f
+// returns false the children of that node are skipped.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || isNilNode(node) || !f(node) {
+		return
+	}
+
+	exprs := func(list []Expr) {
+		for _, e := range list {
+			Inspect(e, f)
+		}
+	}
+
+	switch n := node.(type) {
+	case *SelectStmt:
+		if n.Top != nil {
+			Inspect(n.Top, f)
+		}
+		for _, item := range n.Columns {
+			Inspect(item.Expr, f)
+		}
+		for _, t := range n.From {
+			Inspect(t, f)
+		}
+		Inspect(n.Where, f)
+		exprs(n.GroupBy)
+		Inspect(n.Having, f)
+		for _, item := range n.OrderBy {
+			Inspect(item.Expr, f)
+		}
+		if n.Union != nil {
+			Inspect(n.Union, f)
+		}
+	case *TransformStmt:
+		Inspect(n.Value, f)
+		if n.Select != nil {
+			Inspect(n.Select, f)
+		}
+		Inspect(n.Pivot, f)
+		exprs(n.In)
+	case *InsertStmt:
+		if n.Table != nil {
+			Inspect(n.Table, f)
+		}
+		for _, c := range n.Columns {
+			Inspect(c, f)
+		}
+		exprs(n.Values)
+		if n.Select != nil {
+			Inspect(n.Select, f)
+		}
+	case *UpdateStmt:
+		if n.Table != nil {
+			Inspect(n.Table, f)
+		}
+		for _, a := range n.Set {
+			Inspect(a.Column, f)
+			Inspect(a.Value, f)
+		}
+		Inspect(n.Where, f)
+	case *DeleteStmt:
+		if n.Table != nil {
+			Inspect(n.Table, f)
+		}
+		Inspect(n.Where, f)
+	case *JoinExpr:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+		Inspect(n.On, f)
+	case *SubqueryTable:
+		if n.Select != nil {
+			Inspect(n.Select, f)
+		}
+	case *BinaryExpr:
+		Inspect(n.X, f)
+		Inspect(n.Y, f)
+	case *UnaryExpr:
+		Inspect(n.X, f)
+	case *FuncCall:
+		exprs(n.Args)
+	case *InExpr:
+		Inspect(n.X, f)
+		exprs(n.List)
+		if n.Select != nil {
+			Inspect(n.Select, f)
+		}
+	case *BetweenExpr:
+		Inspect(n.X, f)
+		Inspect(n.Low, f)
+		Inspect(n.High, f)
+	case *IsNullExpr:
+		Inspect(n.X, f)
+	case *ExistsExpr:
+		if n.Select != nil {
+			Inspect(n.Select, f)
+		}
+	case *SubqueryExpr:
+		if n.Select != nil {
+			Inspect(n.Select, f)
+		}
+	case *ParenExpr:
+		Inspect(n.X, f)
+	}
+}
+
+// isNilNode catches typed nil pointers stored in interface fields
+func isNilNode(node Node) bool {
+	switch n := node.(type) {
+	case *SelectStmt:
+		return n == nil
+	case *TableRef:
+		return n == nil
+	case *ColumnRef:
+		return n == nil
+	case *Literal:
+		return n == nil
+	}
+	return false
+}
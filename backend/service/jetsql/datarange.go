@@ -0,0 +1,31 @@
+package jetsql
+
+import (
+	"fmt"
+
+	"excel-automation-mcp/backend/service/mcp"
+)
+
+// TableFromDataRange builds a table from an analyzed data range. Only the
+// sample rows are available, so results describe the shape of the real
+// output rather than its full contents.
+func TableFromDataRange(structure mcp.DataRange) *Table {
+	name := structure.SheetName
+	if name == "" {
+		name = "Sheet1"
+	}
+
+	cells := structure.SampleData
+	table := NewTable(name, structure.Headers, cells)
+	if startCol, startRow, _, _, ok := parseArea(structure.RangeAddress); ok && startCol > 0 {
+		table.StartCol = startCol
+		if startRow > 0 {
+			table.StartRow = startRow
+		}
+	}
+	if structure.DataRows > len(cells) {
+		table.Warnings = append(table.Warnings, fmt.Sprintf(
+			"%s: preview uses %d sample rows of %d", name, len(cells), structure.DataRows))
+	}
+	return table
+}
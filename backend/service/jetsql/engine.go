@@ -0,0 +1,904 @@
+package jetsql
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"excel-automation-mcp/backend/service/excelrange"
+)
+
+// typeGuessRows is the number of rows the Excel driver samples to choose a column type
+const typeGuessRows = 8
+
+// Table is an in-memory worksheet: named columns with inferred types
+type Table struct {
+	Name     string // Worksheet or named range name, without $
+	Columns  []string
+	Types    []ValueKind
+	Rows     [][]Value
+	StartCol int // Column number of the first column, 1 for A
+	StartRow int // Row number of the header row
+	Warnings []string
+}
+
+// NewTable builds a table from headers and cell text. Each column takes the
+// majority type of its first eight values, with ties going to numbers, and
+// cells that do not fit become Null, as with the Excel driver.
+func NewTable(name string, headers []string, cells [][]string) *Table {
+	table := &Table{Name: name, StartCol: 1, StartRow: 1}
+
+	width := len(headers)
+	for _, row := range cells {
+		width = max(width, len(row))
+	}
+
+	for col := 0; col < width; col++ {
+		header := ""
+		if col < len(headers) {
+			header = strings.TrimSpace(headers[col])
+		}
+		if header == "" {
+			header = "F" + strconv.Itoa(col+1)
+		}
+		// The driver cannot expose dots in field names
+		table.Columns = append(table.Columns, strings.ReplaceAll(header, ".", "#"))
+		table.Types = append(table.Types, columnKind(cells, col))
+	}
+
+	for r, row := range cells {
+		values := make([]Value, width)
+		for col := range values {
+			text := ""
+			if col < len(row) {
+				text = row[col]
+			}
+			value, ok := convertCell(text, table.Types[col])
+			if !ok {
+				table.Warnings = append(table.Warnings, fmt.Sprintf(
+					"%s row %d: %q in column [%s] is not a %s and is read as Null",
+					name, r+1, text, table.Columns[col], table.Types[col]))
+			}
+			values[col] = value
+		}
+		table.Rows = append(table.Rows, values)
+	}
+
+	return table
+}
+
+// columnKind chooses the type of one column from its first non-empty values
+func columnKind(cells [][]string, col int) ValueKind {
+	counts := map[ValueKind]int{}
+	sampled := 0
+	for _, row := range cells {
+		if sampled == typeGuessRows {
+			break
+		}
+		if col >= len(row) {
+			continue
+		}
+		kind := guessKind(row[col])
+		if kind == KindNull {
+			continue
+		}
+		counts[kind]++
+		sampled++
+	}
+
+	best, bestCount := KindText, 0
+	for _, kind := range []ValueKind{KindNumber, KindDate, KindBool, KindText} {
+		if counts[kind] > bestCount {
+			best, bestCount = kind, counts[kind]
+		}
+	}
+	return best
+}
+
+// Database is a set of tables queries can read from
+type Database struct {
+	tables []*Table
+}
+
+// NewDatabase creates a database holding the given tables
+func NewDatabase(tables ...*Table) *Database {
+	return &Database{tables: tables}
+}
+
+// AddTable adds a table, replacing any table with the same name
+func (db *Database) AddTable(table *Table) {
+	for i, existing := range db.tables {
+		if strings.EqualFold(existing.Name, table.Name) {
+			db.tables[i] = table
+			return
+		}
+	}
+	db.tables = append(db.tables, table)
+}
+
+// Table returns the table with the given name, ignoring case
+func (db *Database) Table(name string) *Table {
+	for _, table := range db.tables {
+		if strings.EqualFold(table.Name, name) {
+			return table
+		}
+	}
+	return nil
+}
+
+// ResultColumn describes one column of a query result
+type ResultColumn struct {
+	Name string    `json:"name"`
+	Type ValueKind `json:"type"`
+}
+
+// Result is the output of a query
+type Result struct {
+	Columns  []ResultColumn `json:"columns"`
+	Rows     [][]Value      `json:"rows"`
+	Warnings []string       `json:"warnings"`
+}
+
+// Headers returns the column names getSQL writes as the title row
+func (r *Result) Headers() []string {
+	headers := make([]string, len(r.Columns))
+	for i, col := range r.Columns {
+		headers[i] = col.Name
+	}
+	return headers
+}
+
+// Query parses and runs a SELECT statement
+func (db *Database) Query(sql string) (*Result, error) {
+	stmt, errs := Parse(sql)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return db.Execute(sql, stmt)
+}
+
+// Execute runs a parsed statement. The SQL text is used to name computed
+// columns and to compare GROUP BY expressions. Only SELECT statements are
+// supported; the preview never modifies data.
+func (db *Database) Execute(sql string, stmt Statement) (result *Result, err error) {
+	selectStmt, ok := stmt.(*SelectStmt)
+	if !ok {
+		start, end := stmt.Span()
+		return nil, Error{Offset: start, End: end, Msg: "only SELECT queries can be previewed"}
+	}
+
+	x := &executor{db: db, sql: sql}
+	defer func() {
+		if r := recover(); r != nil {
+			b, ok := r.(bailout)
+			if !ok {
+				panic(r)
+			}
+			result, err = nil, b.err
+		}
+	}()
+
+	out := x.execSelect(selectStmt, nil)
+	result = &Result{Rows: out.rows, Warnings: x.warnings}
+	if result.Rows == nil {
+		result.Rows = [][]Value{}
+	}
+	if result.Warnings == nil {
+		result.Warnings = []string{}
+	}
+	for i, name := range out.columns {
+		column := ResultColumn{Name: name, Type: KindNull}
+		for _, row := range out.rows {
+			if !row[i].IsNull() {
+				column.Type = row[i].Kind
+				break
+			}
+		}
+		result.Columns = append(result.Columns, column)
+	}
+	return result, nil
+}
+
+// executor holds the state of one query execution
+type executor struct {
+	db       *Database
+	sql      string
+	warnings []string
+	exprs    int // Counter for Expr1000-style column names
+	tables   map[*Table]bool
+}
+
+func (x *executor) fail(node Node, format string, args ...interface{}) {
+	start, end := node.Span()
+	panic(bailout{Error{Offset: start, End: end, Msg: fmt.Sprintf(format, args...)}})
+}
+
+// text returns the normalized source of a node, used to match GROUP BY expressions
+func (x *executor) text(node Node) string {
+	start, end := node.Span()
+	if start < 0 || end > len(x.sql) || start >= end {
+		return ""
+	}
+	text := strings.NewReplacer("[", "", "]", "").Replace(x.sql[start:end])
+	return strings.ToLower(strings.Join(strings.Fields(text), ""))
+}
+
+// relColumn is a column of an intermediate relation
+type relColumn struct {
+	table string // Alias or table name used to qualify the column
+	name  string
+}
+
+// relation is an intermediate set of rows
+type relation struct {
+	columns []relColumn
+	rows    [][]Value
+}
+
+// output is the projected result of a SELECT
+type output struct {
+	columns []string
+	rows    [][]Value
+	keys    [][]Value // ORDER BY keys of each row
+}
+
+// execSelect runs a SELECT and any UNION chain attached to it
+func (x *executor) execSelect(stmt *SelectStmt, outer *evalCtx) output {
+	if stmt.Union == nil {
+		return x.execCore(stmt, outer, true)
+	}
+
+	var cores []*SelectStmt
+	distinct := false
+	for s := stmt; s != nil; s = s.Union {
+		cores = append(cores, s)
+		if s.Union != nil && !s.UnionAll {
+			distinct = true
+		}
+	}
+
+	result := output{}
+	for i, core := range cores {
+		out := x.execCore(core, outer, false)
+		if i == 0 {
+			result.columns = out.columns
+		} else if len(out.columns) != len(result.columns) {
+			x.fail(core, "The number of columns in the two selected tables or queries of a union query do not match")
+		}
+		result.rows = append(result.rows, out.rows...)
+	}
+	if distinct {
+		result.rows = distinctRows(result.rows)
+	}
+
+	// ORDER BY after the last SELECT sorts the whole union by output column
+	last := cores[len(cores)-1]
+	if len(last.OrderBy) > 0 {
+		ctx := &evalCtx{x: x, outer: outer}
+		for _, name := range result.columns {
+			ctx.cols = append(ctx.cols, relColumn{name: name})
+		}
+		result.keys = make([][]Value, len(result.rows))
+		for i, row := range result.rows {
+			ctx.row = row
+			for _, item := range last.OrderBy {
+				result.keys[i] = append(result.keys[i], x.orderKey(ctx, item, row))
+			}
+		}
+		sortOutput(&result, last.OrderBy)
+	}
+	return result
+}
+
+// execCore runs a single SELECT without its UNION chain
+func (x *executor) execCore(stmt *SelectStmt, outer *evalCtx, order bool) output {
+	source := x.from(stmt.From, outer)
+
+	if stmt.Where != nil {
+		x.noAggregates(stmt.Where, "WHERE clause")
+		var rows [][]Value
+		ctx := &evalCtx{x: x, cols: source.columns, outer: outer}
+		for _, row := range source.rows {
+			ctx.row = row
+			if ok, _ := truthy(ctx.eval(stmt.Where)); ok {
+				rows = append(rows, row)
+			}
+		}
+		source.rows = rows
+	}
+
+	aggregated := len(stmt.GroupBy) > 0 || stmt.Having != nil
+	for _, item := range stmt.Columns {
+		if item.Expr != nil && hasAggregate(item.Expr) {
+			aggregated = true
+		}
+	}
+	for _, item := range stmt.OrderBy {
+		if hasAggregate(item.Expr) {
+			aggregated = true
+		}
+	}
+
+	out := output{columns: x.columnNames(stmt, source)}
+	project := func(ctx *evalCtx) {
+		values := x.project(stmt, source, ctx)
+		out.rows = append(out.rows, values)
+		if order && len(stmt.OrderBy) > 0 {
+			ctx.aliases = map[string]Value{}
+			for i, name := range out.columns {
+				if _, exists := ctx.aliases[strings.ToLower(name)]; !exists && i < len(values) {
+					ctx.aliases[strings.ToLower(name)] = values[i]
+				}
+			}
+			keys := make([]Value, len(stmt.OrderBy))
+			for i, item := range stmt.OrderBy {
+				keys[i] = x.orderKey(ctx, item, values)
+			}
+			out.keys = append(out.keys, keys)
+		}
+	}
+
+	if aggregated {
+		x.checkGrouping(stmt)
+		for _, group := range x.groups(stmt, source, outer) {
+			ctx := &evalCtx{x: x, cols: source.columns, group: group, outer: outer}
+			if len(group) > 0 {
+				ctx.row = group[0]
+			} else {
+				ctx.row = make([]Value, len(source.columns))
+			}
+			if stmt.Having != nil {
+				if ok, _ := truthy(ctx.eval(stmt.Having)); !ok {
+					continue
+				}
+			}
+			project(ctx)
+		}
+	} else {
+		for _, row := range source.rows {
+			project(&evalCtx{x: x, cols: source.columns, row: row, outer: outer})
+		}
+	}
+
+	if stmt.Distinct {
+		seen := map[string]bool{}
+		var rows, keys [][]Value
+		for i, row := range out.rows {
+			k := rowKey(row)
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			rows = append(rows, row)
+			if out.keys != nil {
+				keys = append(keys, out.keys[i])
+			}
+		}
+		out.rows, out.keys = rows, keys
+	}
+
+	if order && len(stmt.OrderBy) > 0 {
+		sortOutput(&out, stmt.OrderBy)
+	}
+
+	if stmt.Top != nil {
+		n, _ := strconv.ParseFloat(stmt.Top.Value, 64)
+		limit := int(n)
+		if stmt.TopPercent {
+			limit = int(float64(len(out.rows))*n/100 + 0.999999)
+		}
+		// TOP returns every row that ties with the last one on the ORDER BY keys
+		for limit > 0 && limit < len(out.rows) && out.keys != nil && rowKey(out.keys[limit]) == rowKey(out.keys[limit-1]) {
+			limit++
+		}
+		if limit < len(out.rows) {
+			out.rows = out.rows[:limit]
+			if out.keys != nil {
+				out.keys = out.keys[:limit]
+			}
+		}
+	}
+
+	return out
+}
+
+// columnNames names the output columns: aliases, field names, or Expr1000
+// style names for computed columns. Duplicate field names are qualified.
+func (x *executor) columnNames(stmt *SelectStmt, source relation) []string {
+	var names []string
+	var tables []string
+	for _, item := range stmt.Columns {
+		switch {
+		case item.Star:
+			for _, col := range source.columns {
+				if item.StarTable == "" || strings.EqualFold(item.StarTable, col.table) {
+					names = append(names, col.name)
+					tables = append(tables, col.table)
+				}
+			}
+		case item.Alias != "":
+			names = append(names, item.Alias)
+			tables = append(tables, "")
+		default:
+			if col, ok := item.Expr.(*ColumnRef); ok {
+				names = append(names, col.Name)
+				tables = append(tables, col.Table)
+				continue
+			}
+			names = append(names, fmt.Sprintf("Expr%d", 1000+x.exprs))
+			tables = append(tables, "")
+			x.exprs++
+		}
+	}
+
+	counts := map[string]int{}
+	for _, name := range names {
+		counts[strings.ToLower(name)]++
+	}
+	for i, name := range names {
+		if counts[strings.ToLower(name)] > 1 && tables[i] != "" {
+			names[i] = tables[i] + "." + name
+		}
+	}
+	return names
+}
+
+// project evaluates the select list for one row or group
+func (x *executor) project(stmt *SelectStmt, source relation, ctx *evalCtx) []Value {
+	var values []Value
+	for _, item := range stmt.Columns {
+		if !item.Star {
+			values = append(values, ctx.eval(item.Expr))
+			continue
+		}
+		if ctx.group != nil && len(stmt.GroupBy) > 0 {
+			x.fail(item, "Cannot group on fields selected with '*'")
+		}
+		for i, col := range source.columns {
+			if item.StarTable == "" || strings.EqualFold(item.StarTable, col.table) {
+				values = append(values, ctx.row[i])
+			}
+		}
+	}
+	return values
+}
+
+// groups partitions the rows by the GROUP BY expressions. Like Jet, groups
+// come out sorted by their keys. Without GROUP BY all rows form one group,
+// even when there are none.
+func (x *executor) groups(stmt *SelectStmt, source relation, outer *evalCtx) [][][]Value {
+	if len(stmt.GroupBy) == 0 {
+		return [][][]Value{source.rows}
+	}
+
+	for _, expr := range stmt.GroupBy {
+		x.noAggregates(expr, "GROUP BY clause")
+	}
+
+	type group struct {
+		keys []Value
+		rows [][]Value
+	}
+	index := map[string]*group{}
+	var groups []*group
+
+	ctx := &evalCtx{x: x, cols: source.columns, outer: outer}
+	for _, row := range source.rows {
+		ctx.row = row
+		keys := make([]Value, len(stmt.GroupBy))
+		for i, expr := range stmt.GroupBy {
+			keys[i] = ctx.eval(expr)
+		}
+		k := rowKey(keys)
+		g, ok := index[k]
+		if !ok {
+			g = &group{keys: keys}
+			index[k] = g
+			groups = append(groups, g)
+		}
+		g.rows = append(g.rows, row)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return compareKeys(groups[i].keys, groups[j].keys, nil) < 0
+	})
+
+	result := make([][][]Value, len(groups))
+	for i, g := range groups {
+		result[i] = g.rows
+	}
+	return result
+}
+
+// checkGrouping rejects field references outside aggregates that are not
+// part of the GROUP BY clause
+func (x *executor) checkGrouping(stmt *SelectStmt) {
+	grouped := map[string]bool{}
+	for _, expr := range stmt.GroupBy {
+		grouped[x.text(expr)] = true
+	}
+	aliases := map[string]bool{}
+	for _, item := range stmt.Columns {
+		if item.Alias != "" {
+			aliases[strings.ToLower(item.Alias)] = true
+		}
+	}
+
+	check := func(expr Expr, allowAliases bool) {
+		Inspect(expr, func(n Node) bool {
+			switch node := n.(type) {
+			case *FuncCall:
+				if IsAggregate(node.Name) {
+					return false
+				}
+			case *SubqueryExpr, *ExistsExpr:
+				return false
+			case *ColumnRef:
+				if allowAliases && node.Table == "" && aliases[strings.ToLower(node.Name)] {
+					return false
+				}
+				if !grouped[x.text(node)] {
+					x.fail(node, "You tried to execute a query that does not include the specified expression '%s' as part of an aggregate function", node.Name)
+				}
+			}
+			if expr, ok := n.(Expr); ok && grouped[x.text(expr)] {
+				return false
+			}
+			return true
+		})
+	}
+
+	for _, item := range stmt.Columns {
+		if item.Expr != nil {
+			check(item.Expr, false)
+		}
+	}
+	if stmt.Having != nil {
+		check(stmt.Having, false)
+	}
+	for _, item := range stmt.OrderBy {
+		check(item.Expr, true)
+	}
+}
+
+// noAggregates rejects aggregate functions where Jet does not allow them
+func (x *executor) noAggregates(expr Expr, clause string) {
+	Inspect(expr, func(n Node) bool {
+		switch node := n.(type) {
+		case *SubqueryExpr, *ExistsExpr, *InExpr:
+			if in, ok := node.(*InExpr); ok && in.Select == nil {
+				return true
+			}
+			return false
+		case *FuncCall:
+			if IsAggregate(node.Name) {
+				x.fail(node, "Cannot have aggregate function in %s (%s)", clause, x.sql[node.Start:node.Stop])
+			}
+		}
+		return true
+	})
+}
+
+func hasAggregate(expr Expr) bool {
+	found := false
+	Inspect(expr, func(n Node) bool {
+		switch node := n.(type) {
+		case *SubqueryExpr, *ExistsExpr:
+			return false
+		case *InExpr:
+			if node.Select != nil {
+				Inspect(node.X, func(m Node) bool {
+					if call, ok := m.(*FuncCall); ok && IsAggregate(call.Name) {
+						found = true
+					}
+					return !found
+				})
+				return false
+			}
+		case *FuncCall:
+			if IsAggregate(node.Name) {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// from builds the relation of a FROM clause; comma-separated entries are cross joined
+func (x *executor) from(tables []TableExpr, outer *evalCtx) relation {
+	result := relation{rows: [][]Value{{}}}
+	for _, table := range tables {
+		next := x.tableExpr(table, outer)
+		result = crossJoin(result, next)
+	}
+	return result
+}
+
+func crossJoin(left, right relation) relation {
+	result := relation{columns: append(append([]relColumn{}, left.columns...), right.columns...)}
+	for _, l := range left.rows {
+		for _, r := range right.rows {
+			result.rows = append(result.rows, joinRow(l, r))
+		}
+	}
+	return result
+}
+
+func joinRow(left, right []Value) []Value {
+	row := make([]Value, 0, len(left)+len(right))
+	return append(append(row, left...), right...)
+}
+
+func nullRow(n int) []Value {
+	row := make([]Value, n)
+	for i := range row {
+		row[i] = Null
+	}
+	return row
+}
+
+func (x *executor) tableExpr(expr TableExpr, outer *evalCtx) relation {
+	switch t := expr.(type) {
+	case *TableRef:
+		return x.tableRef(t)
+
+	case *SubqueryTable:
+		out := x.execSelect(t.Select, outer)
+		rel := relation{rows: out.rows}
+		for _, name := range out.columns {
+			rel.columns = append(rel.columns, relColumn{table: t.Alias, name: name})
+		}
+		return rel
+
+	case *JoinExpr:
+		left := x.tableExpr(t.Left, outer)
+		right := x.tableExpr(t.Right, outer)
+		result := relation{columns: append(append([]relColumn{}, left.columns...), right.columns...)}
+		ctx := &evalCtx{x: x, cols: result.columns, outer: outer}
+		matches := func(row []Value) bool {
+			ctx.row = row
+			ok, _ := truthy(ctx.eval(t.On))
+			return ok
+		}
+
+		if t.Kind == JoinRight {
+			for _, r := range right.rows {
+				found := false
+				for _, l := range left.rows {
+					if row := joinRow(l, r); matches(row) {
+						result.rows = append(result.rows, row)
+						found = true
+					}
+				}
+				if !found {
+					result.rows = append(result.rows, joinRow(nullRow(len(left.columns)), r))
+				}
+			}
+			return result
+		}
+
+		for _, l := range left.rows {
+			found := false
+			for _, r := range right.rows {
+				if row := joinRow(l, r); matches(row) {
+					result.rows = append(result.rows, row)
+					found = true
+				}
+			}
+			if !found && t.Kind == JoinLeft {
+				result.rows = append(result.rows, joinRow(l, nullRow(len(right.columns))))
+			}
+		}
+		return result
+	}
+
+	x.fail(expr, "unsupported table expression")
+	return relation{}
+}
+
+// tableRef reads a worksheet, a cell range of a worksheet or a named range
+func (x *executor) tableRef(ref *TableRef) relation {
+	if ref.Database != "" {
+		x.fail(ref, "External workbooks cannot be previewed: %s", ref.Database)
+	}
+
+	name := ref.Name
+	if ref.Sheet != "" || strings.HasSuffix(ref.Name, "$") {
+		name = ref.Sheet
+	}
+	table := x.db.Table(name)
+	if table == nil {
+		x.fail(ref, "The Microsoft Access database engine could not find the object '%s'", ref.Name)
+	}
+
+	if x.tables == nil {
+		x.tables = map[*Table]bool{}
+	}
+	if !x.tables[table] {
+		x.tables[table] = true
+		x.warnings = append(x.warnings, table.Warnings...)
+	}
+
+	qualifier := ref.Alias
+	if qualifier == "" {
+		qualifier = ref.Name
+	}
+
+	columns, rows := table.Columns, table.Rows
+	if ref.Address != "" {
+		columns, rows = x.sliceRange(ref, table)
+	}
+
+	rel := relation{rows: rows}
+	for _, col := range columns {
+		rel.columns = append(rel.columns, relColumn{table: qualifier, name: col})
+	}
+	return rel
+}
+
+// sliceRange selects the cells of [Sheet$B1:D20] from a table. With HDR=YES
+// the first row of the range holds the column names.
+func (x *executor) sliceRange(ref *TableRef, table *Table) ([]string, [][]Value) {
+	startCol, startRow, endCol, endRow, ok := parseArea(ref.Address)
+	if !ok {
+		x.fail(ref, "'%s' is not a valid range in table [%s]", ref.Address, ref.Name)
+	}
+	if startCol == 0 {
+		startCol, endCol = table.StartCol, table.StartCol+len(table.Columns)-1
+	}
+	if startRow == 0 {
+		startRow, endRow = table.StartRow, table.StartRow+len(table.Rows)
+	}
+
+	first, last := startCol-table.StartCol, endCol-table.StartCol
+	if first < 0 || last >= len(table.Columns) {
+		x.warnings = append(x.warnings, fmt.Sprintf(
+			"Range %s extends beyond the analyzed columns of %s; cells outside them are read as Null", ref.Address, table.Name))
+	}
+
+	cell := func(row []Value, col int) Value {
+		if row == nil || col < 0 || col >= len(row) {
+			return Null
+		}
+		return row[col]
+	}
+	dataRow := func(sheetRow int) []Value {
+		i := sheetRow - table.StartRow - 1
+		if i < 0 || i >= len(table.Rows) {
+			return nil
+		}
+		return table.Rows[i]
+	}
+
+	var columns []string
+	for col := first; col <= last; col++ {
+		if startRow <= table.StartRow {
+			if col >= 0 && col < len(table.Columns) {
+				columns = append(columns, table.Columns[col])
+			} else {
+				columns = append(columns, "F"+strconv.Itoa(col-first+1))
+			}
+			continue
+		}
+		name := cell(dataRow(startRow), col).String()
+		if name == "" {
+			name = "F" + strconv.Itoa(col-first+1)
+		}
+		columns = append(columns, name)
+	}
+
+	var rows [][]Value
+	for sheetRow := max(startRow, table.StartRow) + 1; sheetRow <= endRow; sheetRow++ {
+		source := dataRow(sheetRow)
+		if source == nil {
+			break
+		}
+		row := make([]Value, 0, len(columns))
+		for col := first; col <= last; col++ {
+			row = append(row, cell(source, col))
+		}
+		rows = append(rows, row)
+	}
+	return columns, rows
+}
+
+// parseArea parses A1:D10, A:D or 1:10 into 1-based bounds; zero means unbounded
+func parseArea(address string) (startCol, startRow, endCol, endRow int, ok bool) {
+	area, err := excelrange.ParseArea(address)
+	if err != nil {
+		return 0, 0, 0, 0, false
+	}
+	startCol, startRow, endCol, endRow = area.Col1, area.Row1, area.Col2, area.Row2
+	switch {
+	case area.WholeColumns() && !area.WholeRows():
+		startRow, endRow = 0, 0
+	case area.WholeRows() && !area.WholeColumns():
+		startCol, endCol = 0, 0
+	}
+	return startCol, startRow, endCol, endRow, true
+}
+
+func rowKey(values []Value) string {
+	var b strings.Builder
+	for _, v := range values {
+		b.WriteString(v.key())
+		b.WriteByte(0x1f)
+	}
+	return b.String()
+}
+
+func distinctRows(rows [][]Value) [][]Value {
+	seen := map[string]bool{}
+	var result [][]Value
+	for _, row := range rows {
+		if k := rowKey(row); !seen[k] {
+			seen[k] = true
+			result = append(result, row)
+		}
+	}
+	return result
+}
+
+// sortOutput orders the rows by their keys; Null sorts first in ascending order
+// orderKey evaluates an ORDER BY key for a row of output values. A whole
+// number names an output column by position, as in ORDER BY 2, and must
+// not be past the last column.
+func (x *executor) orderKey(ctx *evalCtx, item OrderItem, values []Value) Value {
+	if lit, ok := item.Expr.(*Literal); ok && lit.Kind == LitNumber {
+		if n, err := strconv.Atoi(lit.Value); err == nil {
+			if n < 1 || n > len(values) {
+				x.fail(lit, "ORDER BY position %d is not a column of the result, which has %d", n, len(values))
+			}
+			return values[n-1]
+		}
+	}
+	return ctx.eval(item.Expr)
+}
+
+func sortOutput(out *output, items []OrderItem) {
+	index := make([]int, len(out.rows))
+	for i := range index {
+		index[i] = i
+	}
+	sort.SliceStable(index, func(a, b int) bool {
+		return compareKeys(out.keys[index[a]], out.keys[index[b]], items) < 0
+	})
+
+	rows := make([][]Value, len(index))
+	keys := make([][]Value, len(index))
+	for i, j := range index {
+		rows[i], keys[i] = out.rows[j], out.keys[j]
+	}
+	out.rows, out.keys = rows, keys
+}
+
+func compareKeys(a, b []Value, items []OrderItem) int {
+	for i := range a {
+		c := compareForSort(a[i], b[i])
+		if items != nil && items[i].Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareForSort orders any two values without failing on mixed types
+func compareForSort(a, b Value) int {
+	switch {
+	case a.IsNull() && b.IsNull():
+		return 0
+	case a.IsNull():
+		return -1
+	case b.IsNull():
+		return 1
+	}
+	if c, ok := compareValues(a, b); ok {
+		return c
+	}
+	return strings.Compare(strings.ToLower(a.String()), strings.ToLower(b.String()))
+}
@@ -0,0 +1,426 @@
+package jetsql
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// evalCtx is the row, group and outer query an expression is evaluated against
+type evalCtx struct {
+	x       *executor
+	cols    []relColumn
+	row     []Value
+	group   [][]Value        // Rows of the current group; nil outside aggregate queries
+	aliases map[string]Value // Output columns, visible to ORDER BY
+	outer   *evalCtx         // Enclosing query of a correlated subquery
+}
+
+// lookup resolves a column reference in this query or an enclosing one
+func (ctx *evalCtx) lookup(ref *ColumnRef) Value {
+	for c := ctx; c != nil; c = c.outer {
+		found := -1
+		for i, col := range c.cols {
+			if !strings.EqualFold(col.name, ref.Name) {
+				continue
+			}
+			if ref.Table != "" && !strings.EqualFold(col.table, ref.Table) {
+				continue
+			}
+			if found >= 0 {
+				ctx.x.fail(ref, "The specified field '%s' could refer to more than one table listed in the FROM clause of your SQL statement", ref.Name)
+			}
+			found = i
+		}
+		if found >= 0 {
+			if found < len(c.row) {
+				return c.row[found]
+			}
+			return Null
+		}
+		if ref.Table == "" && c.aliases != nil {
+			if value, ok := c.aliases[strings.ToLower(ref.Name)]; ok {
+				return value
+			}
+		}
+	}
+
+	name := ref.Name
+	if ref.Table != "" {
+		name = ref.Table + "." + ref.Name
+	}
+	ctx.x.fail(ref, "No value given for one or more required parameters: [%s] is not a field of the source tables", name)
+	return Null
+}
+
+func (ctx *evalCtx) eval(expr Expr) Value {
+	switch e := expr.(type) {
+	case *Literal:
+		return ctx.literal(e)
+	case *ColumnRef:
+		return ctx.lookup(e)
+	case *Param:
+		ctx.x.fail(e, "No value given for one or more required parameters")
+	case *ParenExpr:
+		return ctx.eval(e.X)
+	case *UnaryExpr:
+		return ctx.unary(e)
+	case *BinaryExpr:
+		return ctx.binary(e)
+	case *FuncCall:
+		if IsAggregate(e.Name) {
+			return ctx.aggregate(e)
+		}
+		return ctx.call(e)
+	case *IsNullExpr:
+		return Bool(ctx.eval(e.X).IsNull() != e.Not)
+	case *BetweenExpr:
+		return ctx.between(e)
+	case *InExpr:
+		return ctx.in(e)
+	case *ExistsExpr:
+		out := ctx.x.execSelect(e.Select, ctx)
+		return Bool(len(out.rows) > 0)
+	case *SubqueryExpr:
+		out := ctx.x.execSelect(e.Select, ctx)
+		switch {
+		case len(out.rows) == 0 || len(out.columns) == 0:
+			return Null
+		case len(out.rows) > 1:
+			ctx.x.fail(e, "At most one record can be returned by this subquery")
+		}
+		return out.rows[0][0]
+	}
+	ctx.x.fail(expr, "unsupported expression")
+	return Null
+}
+
+func (ctx *evalCtx) literal(lit *Literal) Value {
+	switch lit.Kind {
+	case LitNumber:
+		n, err := strconv.ParseFloat(lit.Value, 64)
+		if err != nil {
+			ctx.x.fail(lit, "invalid number %s", lit.Value)
+		}
+		return Number(n)
+	case LitString:
+		return Text(lit.Value)
+	case LitDate:
+		t, ok := parseDate(lit.Value)
+		if !ok {
+			ctx.x.fail(lit, "Syntax error in date in query expression '#%s#'", lit.Value)
+		}
+		return Date(t)
+	case LitBool:
+		v := strings.ToUpper(lit.Value)
+		return Bool(v == "TRUE" || v == "YES")
+	}
+	return Null
+}
+
+func (ctx *evalCtx) unary(e *UnaryExpr) Value {
+	x := ctx.eval(e.X)
+	if e.Op == "NOT" {
+		b, known := truthy(x)
+		if !known {
+			return Null
+		}
+		return Bool(!b)
+	}
+	if x.IsNull() {
+		return Null
+	}
+	n := ctx.number(e.X, x)
+	if e.Op == "-" {
+		n = -n
+	}
+	return Number(n)
+}
+
+// number converts an operand for arithmetic or fails with Jet's type mismatch error
+func (ctx *evalCtx) number(node Node, v Value) float64 {
+	n, ok := toNumber(v)
+	if !ok {
+		ctx.x.fail(node, "Data type mismatch in criteria expression: %q is not a number", v.String())
+	}
+	return n
+}
+
+func (ctx *evalCtx) binary(e *BinaryExpr) Value {
+	switch e.Op {
+	case "AND", "OR", "XOR":
+		return ctx.logical(e)
+	}
+
+	x, y := ctx.eval(e.X), ctx.eval(e.Y)
+
+	switch e.Op {
+	case "&":
+		return Text(x.String() + y.String())
+	case "=", "<>", "<", ">", "<=", ">=":
+		return ctx.compare(e, x, y)
+	case "LIKE":
+		if x.IsNull() || y.IsNull() {
+			return Null
+		}
+		return Bool(likePattern(y.String()).MatchString(x.String()))
+	}
+
+	if x.IsNull() || y.IsNull() {
+		return Null
+	}
+
+	switch e.Op {
+	case "+":
+		if x.Kind == KindText && y.Kind == KindText {
+			return Text(x.Str + y.Str)
+		}
+		sum := Number(ctx.number(e.X, x) + ctx.number(e.Y, y))
+		if x.Kind == KindDate || y.Kind == KindDate {
+			return Date(serialDate(sum.Num))
+		}
+		return sum
+	case "-":
+		diff := Number(ctx.number(e.X, x) - ctx.number(e.Y, y))
+		if x.Kind == KindDate && y.Kind != KindDate {
+			return Date(serialDate(diff.Num))
+		}
+		return diff
+	case "*":
+		return Number(ctx.number(e.X, x) * ctx.number(e.Y, y))
+	case "/":
+		d := ctx.number(e.Y, y)
+		if d == 0 {
+			ctx.x.fail(e, "Division by zero")
+		}
+		return Number(ctx.number(e.X, x) / d)
+	case "\\", "MOD":
+		a, b := math.RoundToEven(ctx.number(e.X, x)), math.RoundToEven(ctx.number(e.Y, y))
+		if b == 0 {
+			ctx.x.fail(e, "Division by zero")
+		}
+		if e.Op == "MOD" {
+			return Number(math.Mod(a, b))
+		}
+		return Number(math.Trunc(a / b))
+	case "^":
+		return Number(math.Pow(ctx.number(e.X, x), ctx.number(e.Y, y)))
+	}
+
+	ctx.x.fail(e, "unsupported operator %s", e.Op)
+	return Null
+}
+
+// logical applies three-valued AND, OR and XOR
+func (ctx *evalCtx) logical(e *BinaryExpr) Value {
+	a, aKnown := truthy(ctx.eval(e.X))
+	if e.Op == "AND" && aKnown && !a {
+		return Bool(false)
+	}
+	if e.Op == "OR" && aKnown && a {
+		return Bool(true)
+	}
+
+	b, bKnown := truthy(ctx.eval(e.Y))
+	switch e.Op {
+	case "AND":
+		if bKnown && !b {
+			return Bool(false)
+		}
+		if aKnown && bKnown {
+			return Bool(true)
+		}
+	case "OR":
+		if bKnown && b {
+			return Bool(true)
+		}
+		if aKnown && bKnown {
+			return Bool(false)
+		}
+	case "XOR":
+		if aKnown && bKnown {
+			return Bool(a != b)
+		}
+	}
+	return Null
+}
+
+// compare evaluates a comparison. Comparing a field or literal of one type
+// with a literal or field of another fails the way Jet does; computed values
+// are converted first, as in VBA.
+func (ctx *evalCtx) compare(e *BinaryExpr, x, y Value) Value {
+	if x.IsNull() || y.IsNull() {
+		return Null
+	}
+
+	c, ok := compareValues(x, y)
+	if !ok || (isTextual(x) != isTextual(y) && isFieldOrLiteral(e.X) && isFieldOrLiteral(e.Y)) {
+		ctx.x.fail(e, "Data type mismatch in criteria expression: cannot compare %s %q with %s %q",
+			x.Kind, x.String(), y.Kind, y.String())
+	}
+
+	switch e.Op {
+	case "=":
+		return Bool(c == 0)
+	case "<>":
+		return Bool(c != 0)
+	case "<":
+		return Bool(c < 0)
+	case ">":
+		return Bool(c > 0)
+	case "<=":
+		return Bool(c <= 0)
+	}
+	return Bool(c >= 0)
+}
+
+func isTextual(v Value) bool {
+	return v.Kind == KindText
+}
+
+func isFieldOrLiteral(expr Expr) bool {
+	switch e := expr.(type) {
+	case *ParenExpr:
+		return isFieldOrLiteral(e.X)
+	case *ColumnRef, *Literal:
+		return true
+	}
+	return false
+}
+
+// compareValues orders two non-null values; text compares case-insensitively
+// and is converted when compared with numbers or dates
+func compareValues(a, b Value) (int, bool) {
+	if a.Kind == KindText && b.Kind == KindText {
+		return strings.Compare(strings.ToLower(a.Str), strings.ToLower(b.Str)), true
+	}
+
+	if a.Kind == KindDate || b.Kind == KindDate {
+		ta, okA := toDate(a)
+		tb, okB := toDate(b)
+		if !okA || !okB {
+			return 0, false
+		}
+		return compareTimes(ta, tb), true
+	}
+
+	na, okA := toNumber(a)
+	nb, okB := toNumber(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	switch {
+	case na < nb:
+		return -1, true
+	case na > nb:
+		return 1, true
+	}
+	return 0, true
+}
+
+func compareTimes(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+func (ctx *evalCtx) between(e *BetweenExpr) Value {
+	x, low, high := ctx.eval(e.X), ctx.eval(e.Low), ctx.eval(e.High)
+	if x.IsNull() || low.IsNull() || high.IsNull() {
+		return Null
+	}
+	c1, ok1 := compareValues(x, low)
+	c2, ok2 := compareValues(x, high)
+	if !ok1 || !ok2 {
+		ctx.x.fail(e, "Data type mismatch in criteria expression")
+	}
+	return Bool((c1 >= 0 && c2 <= 0) != e.Not)
+}
+
+func (ctx *evalCtx) in(e *InExpr) Value {
+	x := ctx.eval(e.X)
+	if x.IsNull() {
+		return Null
+	}
+
+	var candidates []Value
+	if e.Select != nil {
+		out := ctx.x.execSelect(e.Select, ctx)
+		for _, row := range out.rows {
+			if len(row) > 0 {
+				candidates = append(candidates, row[0])
+			}
+		}
+	} else {
+		for _, item := range e.List {
+			candidates = append(candidates, ctx.eval(item))
+		}
+	}
+
+	sawNull := false
+	for _, candidate := range candidates {
+		if candidate.IsNull() {
+			sawNull = true
+			continue
+		}
+		if c, ok := compareValues(x, candidate); ok && c == 0 {
+			return Bool(!e.Not)
+		}
+	}
+	if sawNull {
+		return Null
+	}
+	return Bool(e.Not)
+}
+
+// likePattern converts a LIKE pattern to a regular expression. Both the Jet
+// wildcards (* ? #) and the ANSI ones used through OLE DB (% _) are accepted.
+func likePattern(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '*', '%':
+			b.WriteString(".*")
+		case '?', '_':
+			b.WriteString(".")
+		case '#':
+			b.WriteString("[0-9]")
+		case '[':
+			end := -1
+			for j := i + 1; j < len(runes); j++ {
+				if runes[j] == ']' {
+					end = j
+					break
+				}
+			}
+			if end < 0 {
+				b.WriteString(regexp.QuoteMeta(string(runes[i:])))
+				i = len(runes)
+				continue
+			}
+			class := string(runes[i+1 : end])
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i = end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return regexp.MustCompile("^" + regexp.QuoteMeta(pattern) + "$")
+	}
+	return re
+}
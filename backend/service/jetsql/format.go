@@ -0,0 +1,335 @@
+package jetsql
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// namedFormats are the predefined Format() styles in their US English form
+var namedFormats = map[string]string{
+	"general number": "",
+	"currency":       "$#,##0.00;($#,##0.00)",
+	"fixed":          "0.00",
+	"standard":       "#,##0.00",
+	"percent":        "0.00%",
+	"scientific":     "0.00E+00",
+	"general date":   "",
+	"long date":      "dddd, mmmm d, yyyy",
+	"medium date":    "dd-mmm-yy",
+	"short date":     "m/d/yyyy",
+	"long time":      "h:nn:ss AM/PM",
+	"medium time":    "hh:nn AM/PM",
+	"short time":     "hh:nn",
+}
+
+// Format applies a VBA Format() pattern to a value, so other packages that
+// evaluate VBA format strings share one implementation
+func Format(v Value, format string) string {
+	return formatValue(v, format)
+}
+
+// formatValue implements the VBA Format() function for the named formats and
+// the common custom date and number patterns
+func formatValue(v Value, format string) string {
+	switch strings.ToLower(format) {
+	case "":
+		return v.String()
+	case "general number":
+		if n, ok := toNumber(v); ok {
+			return formatNumber(n)
+		}
+		return v.String()
+	case "general date":
+		return v.String()
+	case "yes/no", "true/false", "on/off":
+		b, _ := truthy(v)
+		words := strings.Split(format, "/")
+		if b {
+			return words[0]
+		}
+		return words[1]
+	}
+	if named, ok := namedFormats[strings.ToLower(format)]; ok {
+		format = named
+	}
+
+	if v.Kind == KindDate || (v.Kind == KindText && isDateFormat(format)) {
+		if t, ok := toDate(v); ok {
+			return formatDate(t, format)
+		}
+	}
+	if n, ok := toNumber(v); ok && strings.ContainsAny(format, "0#") {
+		return formatNumberPattern(n, format)
+	}
+	if v.Kind == KindNumber && isDateFormat(format) {
+		return formatDate(serialDate(v.Num), format)
+	}
+	return v.String()
+}
+
+// isDateFormat reports whether a custom format uses date or time placeholders
+func isDateFormat(format string) bool {
+	if strings.ContainsAny(format, "0#") {
+		return false
+	}
+	lower := strings.ToLower(format)
+	return strings.ContainsAny(lower, "ydmhnsq")
+}
+
+// formatDate applies a custom date format such as "yyyy-mm-dd hh:nn"
+func formatDate(t time.Time, format string) string {
+	var b strings.Builder
+	lower := strings.ToLower(format)
+	lastWasHour := false
+
+	for i := 0; i < len(format); {
+		rest := lower[i:]
+		run := func(c byte) int {
+			n := 0
+			for n < len(rest) && rest[n] == c {
+				n++
+			}
+			return n
+		}
+
+		switch c := rest[0]; {
+		case c == '"':
+			end := strings.IndexByte(format[i+1:], '"')
+			if end < 0 {
+				b.WriteString(format[i+1:])
+				i = len(format)
+				continue
+			}
+			b.WriteString(format[i+1 : i+1+end])
+			i += end + 2
+			continue
+		case c == '\\' && i+1 < len(format):
+			b.WriteByte(format[i+1])
+			i += 2
+			continue
+		case strings.HasPrefix(rest, "am/pm"), strings.HasPrefix(rest, "a/p"):
+			width := 5
+			am, pm := format[i:i+2], format[i+3:i+5]
+			if strings.HasPrefix(rest, "a/p") {
+				width = 3
+				am, pm = format[i:i+1], format[i+2:i+3]
+			}
+			if t.Hour() < 12 {
+				b.WriteString(am)
+			} else {
+				b.WriteString(pm)
+			}
+			i += width
+			continue
+		case c == 'y':
+			n := run('y')
+			switch {
+			case n >= 3:
+				b.WriteString(strconv.Itoa(t.Year()))
+			case n == 2:
+				b.WriteString(t.Format("06"))
+			default:
+				b.WriteString(strconv.Itoa(t.YearDay()))
+			}
+			i += n
+		case c == 'q':
+			b.WriteString(strconv.Itoa((int(t.Month())-1)/3 + 1))
+			i++
+		case c == 'm':
+			n := run('m')
+			switch {
+			case lastWasHour && n <= 2:
+				b.WriteString(pad(t.Minute(), n))
+			case n >= 4:
+				b.WriteString(t.Month().String())
+			case n == 3:
+				b.WriteString(t.Month().String()[:3])
+			default:
+				b.WriteString(pad(int(t.Month()), n))
+			}
+			lastWasHour = false
+			i += n
+			continue
+		case c == 'd':
+			n := run('d')
+			switch {
+			case n >= 4:
+				b.WriteString(t.Weekday().String())
+			case n == 3:
+				b.WriteString(t.Weekday().String()[:3])
+			default:
+				b.WriteString(pad(t.Day(), n))
+			}
+			i += n
+		case c == 'w':
+			n := run('w')
+			if n >= 2 {
+				_, week := t.ISOWeek()
+				b.WriteString(strconv.Itoa(week))
+			} else {
+				b.WriteString(strconv.Itoa(int(t.Weekday()) + 1))
+			}
+			i += n
+		case c == 'h':
+			n := run('h')
+			hour := t.Hour()
+			if strings.Contains(lower, "am/pm") || strings.Contains(lower, "a/p") {
+				hour = (hour+11)%12 + 1
+			}
+			b.WriteString(pad(hour, n))
+			lastWasHour = true
+			i += n
+			continue
+		case c == 'n':
+			n := run('n')
+			b.WriteString(pad(t.Minute(), n))
+			i += n
+		case c == 's':
+			n := run('s')
+			b.WriteString(pad(t.Second(), n))
+			i += n
+		default:
+			b.WriteByte(format[i])
+			i++
+			continue
+		}
+		lastWasHour = false
+	}
+	return b.String()
+}
+
+func pad(n, width int) string {
+	s := strconv.Itoa(n)
+	for len(s) < width {
+		s = "0" + s
+	}
+	return s
+}
+
+// formatNumberPattern applies a custom number format such as "#,##0.00" or
+// "0%". Sections separated by ; format positive, negative and zero values.
+func formatNumberPattern(n float64, format string) string {
+	sections := splitSections(format)
+	section := sections[0]
+	negative := n < 0
+	switch {
+	case n < 0 && len(sections) > 1 && sections[1] != "":
+		section, n = sections[1], -n
+		negative = false
+	case n == 0 && len(sections) > 2 && sections[2] != "":
+		section = sections[2]
+	}
+
+	first := strings.IndexAny(section, "0#.,")
+	last := strings.LastIndexAny(section, "0#.,")
+	if first < 0 {
+		return literalText(section)
+	}
+	prefix, core, suffix := literalText(section[:first]), section[first:last+1], section[last+1:]
+
+	if strings.Contains(section, "%") {
+		n *= 100
+	}
+	if e := strings.IndexAny(core, "Ee"); e >= 0 {
+		mantissa, decimals := core[:e], 0
+		if dot := strings.IndexByte(mantissa, '.'); dot >= 0 {
+			decimals = len(mantissa) - dot - 1
+		}
+		s := strconv.FormatFloat(math.Abs(n), 'E', decimals, 64)
+		if negative {
+			prefix = "-" + prefix
+		}
+		return prefix + s + literalText(suffix)
+	}
+
+	intPattern, fracPattern := core, ""
+	if dot := strings.IndexByte(core, '.'); dot >= 0 {
+		intPattern, fracPattern = core[:dot], core[dot+1:]
+	}
+	decimals := strings.Count(fracPattern, "0") + strings.Count(fracPattern, "#")
+	minDecimals := strings.Count(fracPattern, "0")
+	minInt := strings.Count(intPattern, "0")
+	grouping := strings.Contains(intPattern, ",")
+
+	s := strconv.FormatFloat(math.Abs(n), 'f', decimals, 64)
+	intDigits, fracDigits := s, ""
+	if dot := strings.IndexByte(s, '.'); dot >= 0 {
+		intDigits, fracDigits = s[:dot], s[dot+1:]
+	}
+	for len(fracDigits) > minDecimals && strings.HasSuffix(fracDigits, "0") {
+		fracDigits = fracDigits[:len(fracDigits)-1]
+	}
+	intDigits = strings.TrimLeft(intDigits, "0")
+	for len(intDigits) < minInt {
+		intDigits = "0" + intDigits
+	}
+	if grouping {
+		intDigits = groupThousands(intDigits)
+	}
+
+	result := intDigits
+	if fracDigits != "" || strings.HasSuffix(core, ".") && minDecimals == 0 && decimals == 0 {
+		result += "." + fracDigits
+	}
+	if negative && strings.Trim(result, "0.,") != "" {
+		prefix = "-" + prefix
+	}
+	return prefix + result + literalText(suffix)
+}
+
+func splitSections(format string) []string {
+	var sections []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(format); i++ {
+		switch format[i] {
+		case '"':
+			quoted = !quoted
+		case ';':
+			if !quoted {
+				sections = append(sections, format[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(sections, format[start:])
+}
+
+// literalText removes the quotes and escapes of the literal parts of a format
+func literalText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			continue
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func groupThousands(digits string) string {
+	if len(digits) <= 3 {
+		return digits
+	}
+	var b strings.Builder
+	lead := len(digits) % 3
+	if lead > 0 {
+		b.WriteString(digits[:lead])
+	}
+	for i := lead; i < len(digits); i += 3 {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(digits[i : i+3])
+	}
+	return b.String()
+}
@@ -0,0 +1,510 @@
+package jetsql
+
+import (
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// aggregate evaluates SUM, AVG, COUNT and the other aggregates over the current group
+func (ctx *evalCtx) aggregate(call *FuncCall) Value {
+	if ctx.group == nil {
+		ctx.x.fail(call, "Aggregate function %s is only allowed in the select list or HAVING clause of a grouped query", call.Name)
+	}
+	name := strings.ToUpper(call.Name)
+	if call.Star {
+		if name != "COUNT" {
+			ctx.x.fail(call, "%s(*) is not allowed; only COUNT accepts *", call.Name)
+		}
+		return Number(float64(len(ctx.group)))
+	}
+	if len(call.Args) != 1 {
+		ctx.x.fail(call, "Wrong number of arguments used with function in query expression '%s'", ctx.x.sql[call.Start:call.Stop])
+	}
+
+	inner := &evalCtx{x: ctx.x, cols: ctx.cols, outer: ctx.outer}
+	var values []Value
+	for _, row := range ctx.group {
+		inner.row = row
+		if v := inner.eval(call.Args[0]); !v.IsNull() {
+			values = append(values, v)
+		}
+	}
+
+	switch name {
+	case "COUNT":
+		return Number(float64(len(values)))
+	case "FIRST", "LAST":
+		// FIRST and LAST see Null values too
+		if len(ctx.group) == 0 {
+			return Null
+		}
+		row := ctx.group[0]
+		if name == "LAST" {
+			row = ctx.group[len(ctx.group)-1]
+		}
+		inner.row = row
+		return inner.eval(call.Args[0])
+	case "MIN", "MAX":
+		if len(values) == 0 {
+			return Null
+		}
+		best := values[0]
+		for _, v := range values[1:] {
+			c, ok := compareValues(v, best)
+			if !ok {
+				ctx.x.fail(call, "Data type mismatch in criteria expression: %s() mixes %s and %s values", call.Name, v.Kind, best.Kind)
+			}
+			if (name == "MIN" && c < 0) || (name == "MAX" && c > 0) {
+				best = v
+			}
+		}
+		return best
+	}
+
+	if len(values) == 0 {
+		return Null
+	}
+	nums := make([]float64, len(values))
+	for i, v := range values {
+		n, ok := toNumber(v)
+		if !ok || v.Kind == KindText && strings.TrimSpace(v.Str) == "" {
+			ctx.x.fail(call, "Data type mismatch in criteria expression: %s() of text value %q", call.Name, v.String())
+		}
+		nums[i] = n
+	}
+
+	sum := 0.0
+	for _, n := range nums {
+		sum += n
+	}
+	mean := sum / float64(len(nums))
+
+	switch name {
+	case "SUM":
+		return Number(sum)
+	case "AVG":
+		return Number(mean)
+	}
+
+	// STDEV, STDEVP, VAR and VARP
+	sample := name == "STDEV" || name == "VAR"
+	n := float64(len(nums))
+	if sample && n < 2 {
+		return Null
+	}
+	squares := 0.0
+	for _, x := range nums {
+		squares += (x - mean) * (x - mean)
+	}
+	variance := squares / n
+	if sample {
+		variance = squares / (n - 1)
+	}
+	if strings.HasPrefix(name, "STDEV") {
+		return Number(math.Sqrt(variance))
+	}
+	return Number(variance)
+}
+
+// scalarFunc implements a function of the VBA expression service available to Jet SQL
+type scalarFunc struct {
+	min, max int // Argument count; max < 0 for variadic
+	fn       func(ctx *evalCtx, call *FuncCall, args []Value) Value
+}
+
+// scalarFuncs are the built-in functions the preview engine supports
+var scalarFuncs map[string]scalarFunc
+
+func init() {
+	text := func(f func(string) string) scalarFunc {
+		return scalarFunc{1, 1, func(_ *evalCtx, _ *FuncCall, args []Value) Value {
+			if args[0].IsNull() {
+				return Null
+			}
+			return Text(f(args[0].String()))
+		}}
+	}
+	numeric := func(f func(float64) float64) scalarFunc {
+		return scalarFunc{1, 1, func(ctx *evalCtx, call *FuncCall, args []Value) Value {
+			if args[0].IsNull() {
+				return Null
+			}
+			return Number(f(ctx.number(call.Args[0], args[0])))
+		}}
+	}
+	datePart := func(f func(time.Time) int) scalarFunc {
+		return scalarFunc{1, 1, func(ctx *evalCtx, call *FuncCall, args []Value) Value {
+			if args[0].IsNull() {
+				return Null
+			}
+			return Number(float64(f(ctx.date(call.Args[0], args[0]))))
+		}}
+	}
+
+	scalarFuncs = map[string]scalarFunc{
+		"IIF":    {3, 3, nil}, // Evaluated lazily by call
+		"SWITCH": {2, -1, nil},
+		"CHOOSE": {2, -1, func(ctx *evalCtx, call *FuncCall, args []Value) Value {
+			i := int(ctx.number(call.Args[0], args[0]))
+			if i < 1 || i >= len(args) {
+				return Null
+			}
+			return args[i]
+		}},
+		"ISNULL": {1, 1, func(_ *evalCtx, _ *FuncCall, args []Value) Value {
+			return Bool(args[0].IsNull())
+		}},
+		"ISNUMERIC": {1, 1, func(_ *evalCtx, _ *FuncCall, args []Value) Value {
+			_, ok := toNumber(args[0])
+			return Bool(ok && args[0].Kind != KindDate)
+		}},
+		"ISDATE": {1, 1, func(_ *evalCtx, _ *FuncCall, args []Value) Value {
+			_, ok := toDate(args[0])
+			return Bool(ok && args[0].Kind != KindNumber)
+		}},
+		"FORMAT": {1, 2, func(ctx *evalCtx, _ *FuncCall, args []Value) Value {
+			if args[0].IsNull() {
+				return Null
+			}
+			format := ""
+			if len(args) > 1 {
+				format = args[1].String()
+			}
+			return Text(formatValue(args[0], format))
+		}},
+
+		// Strings
+		"LEFT": {2, 2, func(ctx *evalCtx, call *FuncCall, args []Value) Value {
+			if args[0].IsNull() {
+				return Null
+			}
+			r := []rune(args[0].String())
+			n := int(ctx.number(call.Args[1], args[1]))
+			if n < 0 {
+				ctx.x.fail(call, "Invalid procedure call or argument: Left() length must not be negative")
+			}
+			n = min(n, len(r))
+			return Text(string(r[:n]))
+		}},
+		"RIGHT": {2, 2, func(ctx *evalCtx, call *FuncCall, args []Value) Value {
+			if args[0].IsNull() {
+				return Null
+			}
+			r := []rune(args[0].String())
+			n := int(ctx.number(call.Args[1], args[1]))
+			if n < 0 {
+				ctx.x.fail(call, "Invalid procedure call or argument: Right() length must not be negative")
+			}
+			n = min(n, len(r))
+			return Text(string(r[len(r)-n:]))
+		}},
+		"MID": {2, 3, func(ctx *evalCtx, call *FuncCall, args []Value) Value {
+			if args[0].IsNull() {
+				return Null
+			}
+			r := []rune(args[0].String())
+			start := int(ctx.number(call.Args[1], args[1])) - 1
+			if start < 0 {
+				ctx.x.fail(call, "Invalid procedure call or argument: Mid() start must be at least 1")
+			}
+			start = min(start, len(r))
+			end := len(r)
+			if len(args) > 2 {
+				end = min(start+max(int(ctx.number(call.Args[2], args[2])), 0), len(r))
+			}
+			return Text(string(r[start:end]))
+		}},
+		"LEN": {1, 1, func(_ *evalCtx, _ *FuncCall, args []Value) Value {
+			if args[0].IsNull() {
+				return Null
+			}
+			return Number(float64(utf8.RuneCountInString(args[0].String())))
+		}},
+		"INSTR": {2, 4, func(ctx *evalCtx, call *FuncCall, args []Value) Value {
+			start := 1
+			if len(args) > 2 && args[0].Kind == KindNumber {
+				start = int(args[0].Num)
+				args = args[1:]
+			}
+			if args[0].IsNull() || args[1].IsNull() {
+				return Null
+			}
+			haystack := []rune(strings.ToLower(args[0].String()))
+			needle := strings.ToLower(args[1].String())
+			if start < 1 || start > len(haystack)+1 {
+				return Number(0)
+			}
+			i := strings.Index(string(haystack[start-1:]), needle)
+			if i < 0 {
+				return Number(0)
+			}
+			return Number(float64(start + utf8.RuneCountInString(string(haystack[start-1:])[:i])))
+		}},
+		"REPLACE": {3, 3, func(_ *evalCtx, _ *FuncCall, args []Value) Value {
+			if args[0].IsNull() {
+				return Null
+			}
+			return Text(strings.ReplaceAll(args[0].String(), args[1].String(), args[2].String()))
+		}},
+		"SPACE": {1, 1, func(ctx *evalCtx, call *FuncCall, args []Value) Value {
+			return Text(strings.Repeat(" ", max(int(ctx.number(call.Args[0], args[0])), 0)))
+		}},
+		"UCASE": text(strings.ToUpper),
+		"LCASE": text(strings.ToLower),
+		"TRIM":  text(strings.TrimSpace),
+		"LTRIM": text(func(s string) string { return strings.TrimLeft(s, " ") }),
+		"RTRIM": text(func(s string) string { return strings.TrimRight(s, " ") }),
+		"STRREVERSE": text(func(s string) string {
+			r := []rune(s)
+			for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+				r[i], r[j] = r[j], r[i]
+			}
+			return string(r)
+		}),
+
+		// Numbers
+		"ABS": numeric(math.Abs),
+		"INT": numeric(math.Floor),
+		"FIX": numeric(math.Trunc),
+		"SGN": numeric(func(n float64) float64 {
+			switch {
+			case n > 0:
+				return 1
+			case n < 0:
+				return -1
+			}
+			return 0
+		}),
+		"SQR": numeric(math.Sqrt),
+		"EXP": numeric(math.Exp),
+		"LOG": numeric(math.Log),
+		"ROUND": {1, 2, func(ctx *evalCtx, call *FuncCall, args []Value) Value {
+			if args[0].IsNull() {
+				return Null
+			}
+			digits := 0.0
+			if len(args) > 1 {
+				digits = ctx.number(call.Args[1], args[1])
+			}
+			scale := math.Pow(10, digits)
+			// VBA rounds half to even
+			return Number(math.RoundToEven(ctx.number(call.Args[0], args[0])*scale) / scale)
+		}},
+		"VAL": {1, 1, func(_ *evalCtx, _ *FuncCall, args []Value) Value {
+			return Number(leadingNumber(args[0].String()))
+		}},
+		"CDBL": numeric(func(n float64) float64 { return n }),
+		"CSNG": numeric(func(n float64) float64 { return float64(float32(n)) }),
+		"CCUR": numeric(func(n float64) float64 { return math.Round(n*10000) / 10000 }),
+		"CINT": numeric(math.RoundToEven),
+		"CLNG": numeric(math.RoundToEven),
+		"CSTR": text(func(s string) string { return s }),
+		"CBOOL": {1, 1, func(_ *evalCtx, _ *FuncCall, args []Value) Value {
+			if args[0].IsNull() {
+				return Null
+			}
+			b, _ := truthy(args[0])
+			return Bool(b)
+		}},
+		"CDATE": {1, 1, func(ctx *evalCtx, call *FuncCall, args []Value) Value {
+			if args[0].IsNull() {
+				return Null
+			}
+			return Date(ctx.date(call.Args[0], args[0]))
+		}},
+		"DATEVALUE": {1, 1, func(ctx *evalCtx, call *FuncCall, args []Value) Value {
+			if args[0].IsNull() {
+				return Null
+			}
+			t := ctx.date(call.Args[0], args[0])
+			return Date(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC))
+		}},
+
+		// Dates
+		"NOW": {0, 0, func(*evalCtx, *FuncCall, []Value) Value {
+			n := time.Now()
+			return Date(time.Date(n.Year(), n.Month(), n.Day(), n.Hour(), n.Minute(), n.Second(), 0, time.UTC))
+		}},
+		"DATE": {0, 0, func(*evalCtx, *FuncCall, []Value) Value {
+			n := time.Now()
+			return Date(time.Date(n.Year(), n.Month(), n.Day(), 0, 0, 0, 0, time.UTC))
+		}},
+		"DATESERIAL": {3, 3, func(ctx *evalCtx, call *FuncCall, args []Value) Value {
+			y := int(ctx.number(call.Args[0], args[0]))
+			m := int(ctx.number(call.Args[1], args[1]))
+			d := int(ctx.number(call.Args[2], args[2]))
+			return Date(time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC))
+		}},
+		"YEAR":    datePart(func(t time.Time) int { return t.Year() }),
+		"MONTH":   datePart(func(t time.Time) int { return int(t.Month()) }),
+		"DAY":     datePart(func(t time.Time) int { return t.Day() }),
+		"HOUR":    datePart(func(t time.Time) int { return t.Hour() }),
+		"MINUTE":  datePart(func(t time.Time) int { return t.Minute() }),
+		"SECOND":  datePart(func(t time.Time) int { return t.Second() }),
+		"WEEKDAY": datePart(func(t time.Time) int { return int(t.Weekday()) + 1 }),
+		"MONTHNAME": {1, 2, func(ctx *evalCtx, call *FuncCall, args []Value) Value {
+			m := time.Month(int(ctx.number(call.Args[0], args[0])))
+			if len(args) > 1 {
+				if short, _ := truthy(args[1]); short {
+					return Text(m.String()[:3])
+				}
+			}
+			return Text(m.String())
+		}},
+		"DATEADD": {3, 3, func(ctx *evalCtx, call *FuncCall, args []Value) Value {
+			if args[2].IsNull() {
+				return Null
+			}
+			n := int(ctx.number(call.Args[1], args[1]))
+			t := ctx.date(call.Args[2], args[2])
+			switch strings.ToLower(args[0].String()) {
+			case "yyyy":
+				return Date(addMonths(t, 12*n))
+			case "q":
+				return Date(addMonths(t, 3*n))
+			case "m":
+				return Date(addMonths(t, n))
+			case "d", "y", "w":
+				return Date(t.AddDate(0, 0, n))
+			case "ww":
+				return Date(t.AddDate(0, 0, 7*n))
+			case "h":
+				return Date(t.Add(time.Duration(n) * time.Hour))
+			case "n":
+				return Date(t.Add(time.Duration(n) * time.Minute))
+			case "s":
+				return Date(t.Add(time.Duration(n) * time.Second))
+			}
+			ctx.x.fail(call.Args[0], "Invalid interval %q for DateAdd", args[0].String())
+			return Null
+		}},
+		"DATEDIFF": {3, 5, func(ctx *evalCtx, call *FuncCall, args []Value) Value {
+			if args[1].IsNull() || args[2].IsNull() {
+				return Null
+			}
+			a, b := ctx.date(call.Args[1], args[1]), ctx.date(call.Args[2], args[2])
+			days := func(t time.Time) int { return int(math.Floor(dateSerial(t))) }
+			switch strings.ToLower(args[0].String()) {
+			case "yyyy":
+				return Number(float64(b.Year() - a.Year()))
+			case "q":
+				return Number(float64((b.Year()-a.Year())*4 + (int(b.Month())-1)/3 - (int(a.Month())-1)/3))
+			case "m":
+				return Number(float64((b.Year()-a.Year())*12 + int(b.Month()) - int(a.Month())))
+			case "d", "y":
+				return Number(float64(days(b) - days(a)))
+			case "w", "ww":
+				return Number(float64((days(b) - days(a)) / 7))
+			case "h":
+				return Number(math.Trunc(b.Sub(a).Hours()))
+			case "n":
+				return Number(math.Trunc(b.Sub(a).Minutes()))
+			case "s":
+				return Number(math.Trunc(b.Sub(a).Seconds()))
+			}
+			ctx.x.fail(call.Args[0], "Invalid interval %q for DateDiff", args[0].String())
+			return Null
+		}},
+		"DATEPART": {2, 4, func(ctx *evalCtx, call *FuncCall, args []Value) Value {
+			if args[1].IsNull() {
+				return Null
+			}
+			t := ctx.date(call.Args[1], args[1])
+			switch strings.ToLower(args[0].String()) {
+			case "yyyy":
+				return Number(float64(t.Year()))
+			case "q":
+				return Number(float64((int(t.Month())-1)/3 + 1))
+			case "m":
+				return Number(float64(t.Month()))
+			case "y":
+				return Number(float64(t.YearDay()))
+			case "d":
+				return Number(float64(t.Day()))
+			case "w":
+				return Number(float64(t.Weekday() + 1))
+			case "ww":
+				return Number(float64((t.YearDay()+int(time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC).Weekday())-1)/7 + 1))
+			case "h":
+				return Number(float64(t.Hour()))
+			case "n":
+				return Number(float64(t.Minute()))
+			case "s":
+				return Number(float64(t.Second()))
+			}
+			ctx.x.fail(call.Args[0], "Invalid interval %q for DatePart", args[0].String())
+			return Null
+		}},
+	}
+}
+
+// call evaluates a scalar function
+func (ctx *evalCtx) call(call *FuncCall) Value {
+	f, ok := scalarFuncs[strings.ToUpper(call.Name)]
+	if !ok {
+		ctx.x.fail(call, "Undefined function '%s' in expression", call.Name)
+	}
+	if call.Star || len(call.Args) < f.min || (f.max >= 0 && len(call.Args) > f.max) {
+		ctx.x.fail(call, "Wrong number of arguments used with function in query expression '%s'", ctx.x.sql[call.Start:call.Stop])
+	}
+
+	// Inside queries IIf and Switch only evaluate the branch they return
+	switch strings.ToUpper(call.Name) {
+	case "IIF":
+		if ok, _ := truthy(ctx.eval(call.Args[0])); ok {
+			return ctx.eval(call.Args[1])
+		}
+		return ctx.eval(call.Args[2])
+	case "SWITCH":
+		if len(call.Args)%2 != 0 {
+			ctx.x.fail(call, "Switch() requires pairs of conditions and values")
+		}
+		for i := 0; i < len(call.Args); i += 2 {
+			if ok, _ := truthy(ctx.eval(call.Args[i])); ok {
+				return ctx.eval(call.Args[i+1])
+			}
+		}
+		return Null
+	}
+
+	args := make([]Value, len(call.Args))
+	for i, arg := range call.Args {
+		args[i] = ctx.eval(arg)
+	}
+	return f.fn(ctx, call, args)
+}
+
+// date converts a function argument to a date or fails with a type mismatch
+func (ctx *evalCtx) date(node Node, v Value) time.Time {
+	t, ok := toDate(v)
+	if !ok {
+		ctx.x.fail(node, "Data type mismatch in criteria expression: %q is not a date", v.String())
+	}
+	return t
+}
+
+// addMonths adds months, clamping the day to the end of a shorter month as
+// DateAdd does: one month after January 31 is the last day of February
+func addMonths(t time.Time, months int) time.Time {
+	first := time.Date(t.Year(), t.Month(), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC).AddDate(0, months, 0)
+	last := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(t.Day(), last)-1)
+}
+
+// leadingNumber implements Val(): the number at the start of a string, or 0
+func leadingNumber(s string) float64 {
+	s = strings.TrimLeft(s, " \t")
+	end := 0
+	for end < len(s) && (s[end] >= '0' && s[end] <= '9' || s[end] == '.' || (end == 0 && (s[end] == '-' || s[end] == '+'))) {
+		end++
+	}
+	for end > 0 {
+		if n, ok := parseNumber(s[:end]); ok {
+			return n
+		}
+		end--
+	}
+	return 0
+}
@@ -0,0 +1,231 @@
+package jetsql
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// TokenKind identifies the lexical class of a SQL token
+type TokenKind int
+
+const (
+	TokenEOF      TokenKind = iota
+	TokenIllegal            // Unrecognized or malformed input
+	TokenIdent              // Plain or [bracketed] identifier
+	TokenKeyword            // Reserved word
+	TokenNumber             // Numeric literal
+	TokenString             // 'single' or "double" quoted string
+	TokenDate               // #2024-01-31# date literal
+	TokenOperator           // = <> < > <= >= + - * / \ ^ &
+	TokenPunct              // ( ) , . ;
+	TokenParam              // ? or @name parameter placeholder
+)
+
+// Token is a single lexical element of a SQL statement
+type Token struct {
+	Kind    TokenKind
+	Text    string // Source text, including brackets and quotes
+	Value   string // Identifier without brackets, string without quotes
+	Offset  int    // Byte offset of the first character
+	End     int    // Byte offset just after the last character
+	Bracket bool   // Identifier was written in [brackets]
+}
+
+// Is reports whether the token is the given keyword, ignoring case
+func (t Token) Is(word string) bool {
+	return t.Kind == TokenKeyword && strings.EqualFold(t.Text, word)
+}
+
+// String describes the token for error messages
+func (t Token) String() string {
+	if t.Kind == TokenEOF {
+		return "end of query"
+	}
+	return fmt.Sprintf("%q", t.Text)
+}
+
+// keywords are the Jet SQL reserved words recognized by the parser
+var keywords = map[string]bool{}
+
+func init() {
+	for _, word := range []string{
+		"ALL", "AND", "AS", "ASC", "BETWEEN", "BY", "CASE", "DELETE", "DESC", "DISTINCT",
+		"DISTINCTROW", "ELSE", "END", "EXISTS", "FROM", "FULL", "GROUP", "HAVING", "IN",
+		"INNER", "INSERT", "INTO", "IS", "JOIN", "LEFT", "LIKE", "LIMIT", "MOD", "NOT",
+		"NULL", "OFFSET", "ON", "OR", "ORDER", "OUTER", "PERCENT", "PIVOT", "RIGHT",
+		"SELECT", "SET", "THEN", "TOP", "TRANSFORM", "UNION", "UPDATE", "VALUES", "WHEN",
+		"WHERE", "XOR",
+	} {
+		keywords[word] = true
+	}
+}
+
+// Error is a problem found while lexing or parsing SQL. Offsets are byte
+// offsets into the query text.
+type Error struct {
+	Offset int
+	End    int
+	Msg    string
+}
+
+// Error implements the error interface
+func (e Error) Error() string {
+	return fmt.Sprintf("offset %d: %s", e.Offset, e.Msg)
+}
+
+// Tokenize splits a SQL statement into tokens ending with TokenEOF
+func Tokenize(sql string) ([]Token, []Error) {
+	var tokens []Token
+	var errors []Error
+
+	i := 0
+	for {
+		for i < len(sql) && unicode.IsSpace(rune(sql[i])) {
+			i++
+		}
+		if i >= len(sql) {
+			tokens = append(tokens, Token{Kind: TokenEOF, Offset: len(sql), End: len(sql)})
+			return tokens, errors
+		}
+
+		start := i
+		c := sql[i]
+		emit := func(kind TokenKind, value string) {
+			tokens = append(tokens, Token{Kind: kind, Text: sql[start:i], Value: value, Offset: start, End: i})
+		}
+
+		switch {
+		case c == '[':
+			end := strings.IndexByte(sql[i+1:], ']')
+			if end < 0 {
+				i = len(sql)
+				errors = append(errors, Error{start, i, "unterminated [identifier]"})
+				emit(TokenIllegal, "")
+				continue
+			}
+			i += end + 2
+			tokens = append(tokens, Token{Kind: TokenIdent, Text: sql[start:i], Value: sql[start+1 : i-1], Offset: start, End: i, Bracket: true})
+
+		case c == '\'' || c == '"':
+			var value strings.Builder
+			i++
+			closed := false
+			for i < len(sql) {
+				if sql[i] == c {
+					if i+1 < len(sql) && sql[i+1] == c {
+						value.WriteByte(c)
+						i += 2
+						continue
+					}
+					i++
+					closed = true
+					break
+				}
+				value.WriteByte(sql[i])
+				i++
+			}
+			if !closed {
+				errors = append(errors, Error{start, i, "unterminated string literal"})
+			}
+			emit(TokenString, value.String())
+
+		case c == '#':
+			end := strings.IndexByte(sql[i+1:], '#')
+			if end < 0 {
+				i = len(sql)
+				errors = append(errors, Error{start, i, "unterminated #date# literal"})
+				emit(TokenIllegal, "")
+				continue
+			}
+			i += end + 2
+			emit(TokenDate, sql[start+1:i-1])
+
+		case isDigit(c) || (c == '.' && i+1 < len(sql) && isDigit(sql[i+1])):
+			for i < len(sql) && (isDigit(sql[i]) || sql[i] == '.') {
+				i++
+			}
+			if i < len(sql) && (sql[i] == 'e' || sql[i] == 'E') {
+				j := i + 1
+				if j < len(sql) && (sql[j] == '+' || sql[j] == '-') {
+					j++
+				}
+				if j < len(sql) && isDigit(sql[j]) {
+					i = j
+					for i < len(sql) && isDigit(sql[i]) {
+						i++
+					}
+				}
+			}
+			emit(TokenNumber, sql[start:i])
+
+		case isIdentStart(c):
+			for i < len(sql) && isIdentPart(sql[i]) {
+				i++
+			}
+			word := sql[start:i]
+			if keywords[strings.ToUpper(word)] {
+				emit(TokenKeyword, strings.ToUpper(word))
+			} else {
+				emit(TokenIdent, word)
+			}
+
+		case c == '?':
+			i++
+			emit(TokenParam, "?")
+
+		case c == '@':
+			i++
+			for i < len(sql) && isIdentPart(sql[i]) {
+				i++
+			}
+			emit(TokenParam, sql[start:i])
+
+		case c == '<':
+			i++
+			if i < len(sql) && (sql[i] == '=' || sql[i] == '>') {
+				i++
+			}
+			emit(TokenOperator, sql[start:i])
+
+		case c == '>':
+			i++
+			if i < len(sql) && sql[i] == '=' {
+				i++
+			}
+			emit(TokenOperator, sql[start:i])
+
+		case c == '!' && i+1 < len(sql) && sql[i+1] == '=':
+			i += 2
+			emit(TokenOperator, "<>")
+
+		case strings.IndexByte("=+-*/\\^&", c) >= 0:
+			i++
+			emit(TokenOperator, sql[start:i])
+
+		case strings.IndexByte("(),.;", c) >= 0:
+			i++
+			emit(TokenPunct, sql[start:i])
+
+		default:
+			i++
+			for i < len(sql) && sql[i] >= 0x80 && sql[i] < 0xC0 {
+				i++ // Rest of a multi-byte character
+			}
+			errors = append(errors, Error{start, i, fmt.Sprintf("unexpected character %q", sql[start:i])})
+			emit(TokenIllegal, "")
+		}
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
@@ -0,0 +1,753 @@
+package jetsql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parser is a recursive descent parser over the token stream of one statement
+type parser struct {
+	tokens []Token
+	pos    int
+}
+
+// bailout aborts parsing at the first syntax error
+type bailout struct{ err Error }
+
+// Parse parses a single Jet SQL statement as accepted by the ACE/Jet OLE DB
+// provider for Excel workbooks. Parsing stops at the first syntax error, which
+// is returned together with any lexical errors.
+func Parse(sql string) (stmt Statement, errs []Error) {
+	tokens, errs := Tokenize(sql)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	p := &parser{tokens: tokens}
+	defer func() {
+		if r := recover(); r != nil {
+			b, ok := r.(bailout)
+			if !ok {
+				panic(r)
+			}
+			stmt = nil
+			errs = append(errs, b.err)
+		}
+	}()
+
+	stmt = p.parseStatement()
+	if p.isPunct(";") {
+		p.next()
+	}
+	if tok := p.peek(); tok.Kind != TokenEOF {
+		p.unexpected(tok, "end of query")
+	}
+	return stmt, nil
+}
+
+// ParseExpr parses a standalone value expression such as a WHERE condition
+func ParseExpr(sql string) (expr Expr, errs []Error) {
+	tokens, errs := Tokenize(sql)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	p := &parser{tokens: tokens}
+	defer func() {
+		if r := recover(); r != nil {
+			b, ok := r.(bailout)
+			if !ok {
+				panic(r)
+			}
+			expr = nil
+			errs = append(errs, b.err)
+		}
+	}()
+
+	expr = p.parseExpr()
+	if tok := p.peek(); tok.Kind != TokenEOF {
+		p.unexpected(tok, "end of expression")
+	}
+	return expr, nil
+}
+
+func (p *parser) peek() Token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekAt(n int) Token {
+	if p.pos+n >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+n]
+}
+
+func (p *parser) next() Token {
+	tok := p.tokens[p.pos]
+	if tok.Kind != TokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// prev returns the most recently consumed token
+func (p *parser) prev() Token {
+	if p.pos == 0 {
+		return p.tokens[0]
+	}
+	return p.tokens[p.pos-1]
+}
+
+func (p *parser) fail(tok Token, format string, args ...interface{}) {
+	end := tok.End
+	if end <= tok.Offset {
+		end = tok.Offset
+	}
+	panic(bailout{Error{Offset: tok.Offset, End: end, Msg: fmt.Sprintf(format, args...)}})
+}
+
+func (p *parser) unexpected(tok Token, want string) {
+	p.fail(tok, "expected %s, found %s", want, tok)
+}
+
+func (p *parser) isPunct(text string) bool {
+	tok := p.peek()
+	return tok.Kind == TokenPunct && tok.Text == text
+}
+
+func (p *parser) isOperator(text string) bool {
+	tok := p.peek()
+	return tok.Kind == TokenOperator && tok.Text == text
+}
+
+// accept consumes the next token if it is the given keyword
+func (p *parser) accept(word string) bool {
+	if p.peek().Is(word) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(word string) Token {
+	if !p.peek().Is(word) {
+		p.unexpected(p.peek(), word)
+	}
+	return p.next()
+}
+
+func (p *parser) expectPunct(text string) Token {
+	if !p.isPunct(text) {
+		p.unexpected(p.peek(), fmt.Sprintf("%q", text))
+	}
+	return p.next()
+}
+
+func (p *parser) parseStatement() Statement {
+	tok := p.peek()
+	switch {
+	case tok.Is("SELECT") || (p.isPunct("(") && p.peekAt(1).Is("SELECT")):
+		return p.parseSelect()
+	case tok.Is("TRANSFORM"):
+		return p.parseTransform()
+	case tok.Is("INSERT"):
+		return p.parseInsert()
+	case tok.Is("UPDATE"):
+		return p.parseUpdate()
+	case tok.Is("DELETE"):
+		return p.parseDelete()
+	}
+	p.unexpected(tok, "SELECT, TRANSFORM, INSERT, UPDATE or DELETE")
+	return nil
+}
+
+// parseSelect parses a SELECT and any UNION chain that follows it
+func (p *parser) parseSelect() *SelectStmt {
+	var stmt *SelectStmt
+	if p.isPunct("(") {
+		open := p.next()
+		stmt = p.parseSelect()
+		p.expectPunct(")")
+		stmt.Start = open.Offset
+		stmt.Stop = p.prev().End
+		stmt.Parenthesis = true
+	} else {
+		stmt = p.parseSelectCore()
+	}
+
+	if p.accept("UNION") {
+		stmt.UnionAll = p.accept("ALL")
+		stmt.Union = p.parseSelect()
+		stmt.Stop = stmt.Union.Stop
+	}
+	return stmt
+}
+
+func (p *parser) parseSelectCore() *SelectStmt {
+	start := p.expect("SELECT")
+	stmt := &SelectStmt{Range: Range{Start: start.Offset}}
+
+modifiers:
+	for {
+		switch {
+		case p.accept("DISTINCT"):
+			stmt.Distinct = true
+		case p.accept("DISTINCTROW"), p.accept("ALL"):
+		case p.peek().Is("TOP"):
+			p.next()
+			tok := p.next()
+			if tok.Kind != TokenNumber {
+				p.unexpected(tok, "row count after TOP")
+			}
+			stmt.Top = &Literal{Range: Range{tok.Offset, tok.End}, Kind: LitNumber, Value: tok.Value}
+			stmt.TopPercent = p.accept("PERCENT")
+		default:
+			break modifiers
+		}
+	}
+
+	stmt.Columns = append(stmt.Columns, p.parseSelectItem())
+	for p.isPunct(",") {
+		p.next()
+		stmt.Columns = append(stmt.Columns, p.parseSelectItem())
+	}
+
+	if p.accept("INTO") {
+		p.parseTableName()
+	}
+
+	if p.accept("FROM") {
+		stmt.From = append(stmt.From, p.parseJoinedTable())
+		for p.isPunct(",") {
+			p.next()
+			stmt.From = append(stmt.From, p.parseJoinedTable())
+		}
+	}
+	if p.accept("WHERE") {
+		stmt.Where = p.parseExpr()
+	}
+	if p.accept("GROUP") {
+		p.expect("BY")
+		stmt.GroupBy = p.parseExprList()
+	}
+	if p.accept("HAVING") {
+		stmt.Having = p.parseExpr()
+	}
+	if p.accept("ORDER") {
+		p.expect("BY")
+		for {
+			expr := p.parseExpr()
+			start, _ := expr.Span()
+			item := OrderItem{Range: Range{start, p.prev().End}, Expr: expr}
+			if p.accept("DESC") {
+				item.Desc = true
+				item.Stop = p.prev().End
+			} else if p.accept("ASC") {
+				item.Stop = p.prev().End
+			}
+			stmt.OrderBy = append(stmt.OrderBy, item)
+			if !p.isPunct(",") {
+				break
+			}
+			p.next()
+		}
+	}
+
+	switch tok := p.peek(); {
+	case tok.Is("LIMIT"):
+		p.fail(tok, "LIMIT is not supported by Jet SQL; use SELECT TOP n")
+	case tok.Is("OFFSET"):
+		p.fail(tok, "OFFSET is not supported by Jet SQL")
+	}
+
+	stmt.Stop = p.prev().End
+	return stmt
+}
+
+func (p *parser) parseSelectItem() SelectItem {
+	start := p.peek()
+
+	if p.isOperator("*") {
+		p.next()
+		return SelectItem{Range: Range{start.Offset, start.End}, Star: true}
+	}
+	if start.Kind == TokenIdent && p.peekAt(1).Text == "." && p.peekAt(2).Kind == TokenOperator && p.peekAt(2).Text == "*" {
+		p.pos += 3
+		return SelectItem{Range: Range{start.Offset, p.prev().End}, Star: true, StarTable: start.Value}
+	}
+
+	expr := p.parseExpr()
+	exprStart, _ := expr.Span()
+	item := SelectItem{Range: Range{exprStart, p.prev().End}, Expr: expr}
+	if name, pos, ok := p.parseAlias(); ok {
+		item.Alias = name
+		item.AliasPos = pos
+		item.Stop = p.prev().End
+	}
+	return item
+}
+
+// parseAlias parses an optional [AS] alias after a column or table
+func (p *parser) parseAlias() (string, int, bool) {
+	if p.accept("AS") {
+		tok := p.next()
+		if tok.Kind != TokenIdent && tok.Kind != TokenString {
+			p.unexpected(tok, "alias after AS")
+		}
+		return tok.Value, tok.Offset, true
+	}
+	if tok := p.peek(); tok.Kind == TokenIdent {
+		p.next()
+		return tok.Value, tok.Offset, true
+	}
+	return "", 0, false
+}
+
+func (p *parser) parseJoinedTable() TableExpr {
+	left := p.parseTablePrimary()
+
+	for {
+		tok := p.peek()
+		var kind JoinKind
+		switch {
+		case tok.Is("INNER"), tok.Is("JOIN"):
+			kind = JoinInner
+		case tok.Is("LEFT"):
+			kind = JoinLeft
+		case tok.Is("RIGHT"):
+			kind = JoinRight
+		case tok.Is("FULL"):
+			p.fail(tok, "FULL OUTER JOIN is not supported by Jet SQL; combine a LEFT and a RIGHT JOIN with UNION")
+		default:
+			return left
+		}
+
+		if !tok.Is("JOIN") {
+			p.next()
+			if kind != JoinInner {
+				p.accept("OUTER")
+			}
+		}
+		p.expect("JOIN")
+		right := p.parseTablePrimary()
+		if !p.peek().Is("ON") {
+			p.fail(p.peek(), "JOIN requires an ON condition in Jet SQL")
+		}
+		p.next()
+		on := p.parseExpr()
+
+		start, _ := left.Span()
+		left = &JoinExpr{Range: Range{start, p.prev().End}, Kind: kind, Left: left, Right: right, On: on}
+	}
+}
+
+func (p *parser) parseTablePrimary() TableExpr {
+	if p.isPunct("(") {
+		open := p.next()
+		if p.peek().Is("SELECT") {
+			sub := p.parseSelect()
+			p.expectPunct(")")
+			table := &SubqueryTable{Range: Range{open.Offset, p.prev().End}, Select: sub}
+			if name, pos, ok := p.parseAlias(); ok {
+				table.Alias = name
+				table.AliasPos = pos
+				table.Stop = p.prev().End
+			}
+			return table
+		}
+
+		// Jet requires nested joins to be parenthesized
+		inner := p.parseJoinedTable()
+		p.expectPunct(")")
+		return inner
+	}
+
+	table := p.parseTableName()
+	if name, pos, ok := p.parseAlias(); ok {
+		table.Alias = name
+		table.AliasPos = pos
+		table.Stop = p.prev().End
+	}
+	return table
+}
+
+// parseTableName parses [Sheet1$], [Sheet1$A1:D100], NamedRange or
+// [Excel 12.0;Database=C:\file.xlsx].[Sheet1$]
+func (p *parser) parseTableName() *TableRef {
+	tok := p.next()
+	if tok.Kind != TokenIdent {
+		p.unexpected(tok, "table name")
+	}
+
+	table := &TableRef{Range: Range{tok.Offset, tok.End}}
+	if p.isPunct(".") && p.peekAt(1).Kind == TokenIdent {
+		p.next()
+		table.Database = tok.Value
+		tok = p.next()
+		table.Stop = tok.End
+	}
+
+	table.Name = tok.Value
+	table.NamePos = tok.Offset
+	table.NameEnd = tok.End
+	if i := strings.LastIndex(tok.Value, "$"); i >= 0 {
+		table.Sheet = strings.Trim(tok.Value[:i], "'")
+		table.Address = tok.Value[i+1:]
+	}
+	return table
+}
+
+func (p *parser) parseTransform() Statement {
+	start := p.expect("TRANSFORM")
+	stmt := &TransformStmt{Range: Range{Start: start.Offset}}
+	stmt.Value = p.parseExpr()
+	stmt.Select = p.parseSelect()
+	p.expect("PIVOT")
+	stmt.Pivot = p.parseExpr()
+	if p.accept("IN") {
+		p.expectPunct("(")
+		stmt.In = p.parseExprList()
+		p.expectPunct(")")
+	}
+	stmt.Stop = p.prev().End
+	return stmt
+}
+
+func (p *parser) parseInsert() Statement {
+	start := p.expect("INSERT")
+	p.expect("INTO")
+	stmt := &InsertStmt{Range: Range{Start: start.Offset}, Table: p.parseTableName()}
+
+	if p.isPunct("(") {
+		p.next()
+		for {
+			stmt.Columns = append(stmt.Columns, p.parseColumnName())
+			if !p.isPunct(",") {
+				break
+			}
+			p.next()
+		}
+		p.expectPunct(")")
+	}
+
+	if p.accept("VALUES") {
+		p.expectPunct("(")
+		stmt.Values = p.parseExprList()
+		p.expectPunct(")")
+	} else {
+		stmt.Select = p.parseSelect()
+	}
+	stmt.Stop = p.prev().End
+	return stmt
+}
+
+func (p *parser) parseUpdate() Statement {
+	start := p.expect("UPDATE")
+	stmt := &UpdateStmt{Range: Range{Start: start.Offset}, Table: p.parseTableName()}
+	p.expect("SET")
+	for {
+		column := p.parseColumnName()
+		if !p.isOperator("=") {
+			p.unexpected(p.peek(), "\"=\"")
+		}
+		p.next()
+		stmt.Set = append(stmt.Set, Assignment{Column: column, Value: p.parseExpr()})
+		if !p.isPunct(",") {
+			break
+		}
+		p.next()
+	}
+	if p.accept("WHERE") {
+		stmt.Where = p.parseExpr()
+	}
+	stmt.Stop = p.prev().End
+	return stmt
+}
+
+func (p *parser) parseDelete() Statement {
+	start := p.expect("DELETE")
+	if p.isOperator("*") {
+		p.next()
+	}
+	p.expect("FROM")
+	stmt := &DeleteStmt{Range: Range{Start: start.Offset}, Table: p.parseTableName()}
+	if p.accept("WHERE") {
+		stmt.Where = p.parseExpr()
+	}
+	stmt.Stop = p.prev().End
+	return stmt
+}
+
+func (p *parser) parseColumnName() *ColumnRef {
+	tok := p.next()
+	if tok.Kind != TokenIdent {
+		p.unexpected(tok, "column name")
+	}
+	return p.parseColumnRest(tok)
+}
+
+// parseColumnRest completes a column reference whose first identifier has
+// already been consumed, handling table.column qualification
+func (p *parser) parseColumnRest(tok Token) *ColumnRef {
+	col := &ColumnRef{Range: Range{tok.Offset, tok.End}, Name: tok.Value, NamePos: tok.Offset, NameEnd: tok.End}
+	if p.isPunct(".") && p.peekAt(1).Kind == TokenIdent {
+		p.next()
+		name := p.next()
+		col.Table = tok.Value
+		col.Name = name.Value
+		col.NamePos = name.Offset
+		col.NameEnd = name.End
+		col.Stop = name.End
+	}
+	return col
+}
+
+func (p *parser) parseExprList() []Expr {
+	list := []Expr{p.parseExpr()}
+	for p.isPunct(",") {
+		p.next()
+		list = append(list, p.parseExpr())
+	}
+	return list
+}
+
+// parseExpr parses an expression using Jet operator precedence, from lowest
+// to highest: OR/XOR, AND, NOT, comparisons, &, + -, MOD, \, * /, unary -, ^
+func (p *parser) parseExpr() Expr {
+	x := p.parseAnd()
+	for p.peek().Is("OR") || p.peek().Is("XOR") {
+		op := p.next().Value
+		y := p.parseAnd()
+		x = binary(op, x, y)
+	}
+	return x
+}
+
+func (p *parser) parseAnd() Expr {
+	x := p.parseNot()
+	for p.peek().Is("AND") {
+		p.next()
+		y := p.parseNot()
+		x = binary("AND", x, y)
+	}
+	return x
+}
+
+func (p *parser) parseNot() Expr {
+	if tok := p.peek(); tok.Is("NOT") {
+		p.next()
+		x := p.parseNot()
+		_, stop := x.Span()
+		return &UnaryExpr{Range: Range{tok.Offset, stop}, Op: "NOT", X: x}
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() Expr {
+	x := p.parseArith(0)
+	start, _ := x.Span()
+
+	tok := p.peek()
+	if tok.Kind == TokenOperator {
+		switch tok.Text {
+		case "=", "<>", "<", ">", "<=", ">=":
+			p.next()
+			op := tok.Text
+			if op == "!=" {
+				op = "<>"
+			}
+			return binary(op, x, p.parseArith(0))
+		}
+	}
+
+	not := false
+	if tok.Is("NOT") && (p.peekAt(1).Is("LIKE") || p.peekAt(1).Is("IN") || p.peekAt(1).Is("BETWEEN")) {
+		p.next()
+		not = true
+		tok = p.peek()
+	}
+
+	switch {
+	case tok.Is("LIKE"):
+		p.next()
+		var expr Expr = binary("LIKE", x, p.parseArith(0))
+		if not {
+			expr = &UnaryExpr{Range: Range{start, p.prev().End}, Op: "NOT", X: expr}
+		}
+		return expr
+
+	case tok.Is("IN"):
+		p.next()
+		p.expectPunct("(")
+		in := &InExpr{X: x, Not: not}
+		if p.peek().Is("SELECT") {
+			in.Select = p.parseSelect()
+		} else {
+			in.List = p.parseExprList()
+		}
+		p.expectPunct(")")
+		in.Range = Range{start, p.prev().End}
+		return in
+
+	case tok.Is("BETWEEN"):
+		p.next()
+		low := p.parseArith(0)
+		p.expect("AND")
+		high := p.parseArith(0)
+		return &BetweenExpr{Range: Range{start, p.prev().End}, X: x, Not: not, Low: low, High: high}
+
+	case tok.Is("IS"):
+		p.next()
+		isNot := p.accept("NOT")
+		p.expect("NULL")
+		return &IsNullExpr{Range: Range{start, p.prev().End}, X: x, Not: isNot}
+	}
+
+	return x
+}
+
+// arithLevels lists the binary arithmetic operators from lowest to highest
+// precedence
+var arithLevels = [][]string{{"&"}, {"+", "-"}, {"MOD"}, {"\\"}, {"*", "/"}}
+
+func (p *parser) parseArith(level int) Expr {
+	if level == len(arithLevels) {
+		return p.parseUnary()
+	}
+
+	x := p.parseArith(level + 1)
+	for {
+		op, ok := p.arithOperator(arithLevels[level])
+		if !ok {
+			return x
+		}
+		p.next()
+		y := p.parseArith(level + 1)
+		x = binary(op, x, y)
+	}
+}
+
+func (p *parser) arithOperator(ops []string) (string, bool) {
+	tok := p.peek()
+	for _, op := range ops {
+		if (tok.Kind == TokenOperator && tok.Text == op) || tok.Is(op) {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) parseUnary() Expr {
+	if p.isOperator("-") || p.isOperator("+") {
+		tok := p.next()
+		x := p.parseUnary()
+		_, stop := x.Span()
+		return &UnaryExpr{Range: Range{tok.Offset, stop}, Op: tok.Text, X: x}
+	}
+
+	x := p.parsePrimary()
+	if p.isOperator("^") {
+		p.next()
+		x = binary("^", x, p.parseUnary())
+	}
+	return x
+}
+
+func (p *parser) parsePrimary() Expr {
+	tok := p.peek()
+	span := Range{tok.Offset, tok.End}
+
+	switch tok.Kind {
+	case TokenNumber:
+		p.next()
+		return &Literal{Range: span, Kind: LitNumber, Value: tok.Value}
+	case TokenString:
+		p.next()
+		return &Literal{Range: span, Kind: LitString, Value: tok.Value}
+	case TokenDate:
+		p.next()
+		return &Literal{Range: span, Kind: LitDate, Value: tok.Value}
+	case TokenParam:
+		p.next()
+		return &Param{Range: span, Name: tok.Value}
+
+	case TokenPunct:
+		if tok.Text != "(" {
+			break
+		}
+		p.next()
+		if p.peek().Is("SELECT") {
+			sub := p.parseSelect()
+			p.expectPunct(")")
+			return &SubqueryExpr{Range: Range{tok.Offset, p.prev().End}, Select: sub}
+		}
+		x := p.parseExpr()
+		p.expectPunct(")")
+		return &ParenExpr{Range: Range{tok.Offset, p.prev().End}, X: x}
+
+	case TokenKeyword:
+		switch tok.Value {
+		case "NULL":
+			p.next()
+			return &Literal{Range: span, Kind: LitNull, Value: "NULL"}
+		case "EXISTS":
+			p.next()
+			p.expectPunct("(")
+			sub := p.parseSelect()
+			p.expectPunct(")")
+			return &ExistsExpr{Range: Range{tok.Offset, p.prev().End}, Select: sub}
+		case "LEFT", "RIGHT":
+			// Left() and Right() string functions share their names with joins
+			if p.peekAt(1).Text == "(" {
+				p.next()
+				return p.parseCall(tok)
+			}
+		case "CASE":
+			p.fail(tok, "CASE expressions are not supported by Jet SQL; use IIf(condition, truePart, falsePart) or Switch()")
+		}
+
+	case TokenIdent:
+		p.next()
+		if !tok.Bracket && p.isPunct("(") {
+			return p.parseCall(tok)
+		}
+		if !tok.Bracket && !p.isPunct(".") {
+			switch strings.ToUpper(tok.Value) {
+			case "TRUE", "FALSE", "YES", "NO":
+				return &Literal{Range: span, Kind: LitBool, Value: tok.Value}
+			}
+		}
+		return p.parseColumnRest(tok)
+	}
+
+	p.unexpected(tok, "expression")
+	return nil
+}
+
+// parseCall parses the argument list of a function whose name has been consumed
+func (p *parser) parseCall(name Token) Expr {
+	p.expectPunct("(")
+	call := &FuncCall{Name: name.Value, NamePos: name.Offset}
+
+	switch {
+	case p.isOperator("*"):
+		p.next()
+		call.Star = true
+	case p.isPunct(")"):
+	default:
+		if p.accept("DISTINCT") {
+			call.Distinct = true
+		}
+		call.Args = p.parseExprList()
+	}
+
+	p.expectPunct(")")
+	call.Range = Range{name.Offset, p.prev().End}
+	return call
+}
+
+func binary(op string, x, y Expr) Expr {
+	start, _ := x.Span()
+	_, stop := y.Span()
+	return &BinaryExpr{Range: Range{start, stop}, Op: op, X: x, Y: y}
+}
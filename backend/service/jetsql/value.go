@@ -0,0 +1,257 @@
+package jetsql
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValueKind is the data type of a value held by the engine
+type ValueKind string
+
+const (
+	KindNull   ValueKind = "null"
+	KindNumber ValueKind = "number"
+	KindText   ValueKind = "text"
+	KindDate   ValueKind = "date"
+	KindBool   ValueKind = "boolean"
+)
+
+// Value is a single cell or expression result
+type Value struct {
+	Kind ValueKind
+	Num  float64
+	Str  string
+	Time time.Time
+	Bool bool
+}
+
+// Null is the missing value
+var Null = Value{Kind: KindNull}
+
+// Number returns a numeric value
+func Number(n float64) Value { return Value{Kind: KindNumber, Num: n} }
+
+// Text returns a string value
+func Text(s string) Value { return Value{Kind: KindText, Str: s} }
+
+// Date returns a date/time value
+func Date(t time.Time) Value { return Value{Kind: KindDate, Time: t} }
+
+// Bool returns a Yes/No value
+func Bool(b bool) Value { return Value{Kind: KindBool, Bool: b} }
+
+// IsNull reports whether the value is Null
+func (v Value) IsNull() bool { return v.Kind == KindNull }
+
+// String formats the value the way Excel shows it after getSQL copies it to a sheet
+func (v Value) String() string {
+	switch v.Kind {
+	case KindNumber:
+		return formatNumber(v.Num)
+	case KindText:
+		return v.Str
+	case KindDate:
+		if v.Time.Hour() == 0 && v.Time.Minute() == 0 && v.Time.Second() == 0 {
+			return v.Time.Format("2006-01-02")
+		}
+		return v.Time.Format("2006-01-02 15:04:05")
+	case KindBool:
+		if v.Bool {
+			return "True"
+		}
+		return "False"
+	}
+	return ""
+}
+
+// MarshalJSON encodes numbers and booleans natively, dates and text as strings
+func (v Value) MarshalJSON() ([]byte, error) {
+	switch v.Kind {
+	case KindNull:
+		return []byte("null"), nil
+	case KindNumber:
+		if math.IsNaN(v.Num) || math.IsInf(v.Num, 0) {
+			return json.Marshal(v.String())
+		}
+		return json.Marshal(v.Num)
+	case KindBool:
+		return json.Marshal(v.Bool)
+	}
+	return json.Marshal(v.String())
+}
+
+func formatNumber(n float64) string {
+	if n == math.Trunc(n) && math.Abs(n) < 1e15 {
+		return strconv.FormatFloat(n, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(n, 'g', 15, 64)
+}
+
+// dateEpoch is day zero of the date serial numbers used by Jet, VBA and Excel
+var dateEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// dateSerial converts a date to its serial number
+func dateSerial(t time.Time) float64 {
+	return t.Sub(dateEpoch).Hours() / 24
+}
+
+// serialDate converts a serial number to a date
+func serialDate(n float64) time.Time {
+	return dateEpoch.Add(time.Duration(math.Round(n*86400)) * time.Second)
+}
+
+// dateLayouts are the cell formats recognized as dates
+var dateLayouts = []string{
+	"2006-01-02", "2006/01/02", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04:05",
+	"2006/01/02 15:04:05", "1/2/2006", "01/02/2006", "1/2/2006 15:04:05", "1/2/2006 15:04",
+	"2-Jan-2006", "02-Jan-2006", "Jan 2, 2006", "January 2, 2006", "2 Jan 2006", "2006-01",
+}
+
+// parseDate converts text in one of the common cell formats to a date
+func parseDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseNumber converts text such as "1,234.50", "$99" or "12%" to a number
+func parseNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+
+	percent := strings.HasSuffix(s, "%")
+	s = strings.TrimSuffix(s, "%")
+	negative := strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")")
+	s = strings.Trim(s, "()")
+	s = strings.TrimLeft(s, "$€£¥")
+	s = strings.ReplaceAll(s, ",", "")
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, false
+	}
+	if percent {
+		n /= 100
+	}
+	if negative {
+		n = -n
+	}
+	return n, true
+}
+
+// guessKind returns the kind a single cell's text looks like
+func guessKind(s string) ValueKind {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "":
+		return KindNull
+	case strings.EqualFold(s, "TRUE") || strings.EqualFold(s, "FALSE"):
+		return KindBool
+	}
+	if _, ok := parseNumber(s); ok {
+		return KindNumber
+	}
+	if _, ok := parseDate(s); ok {
+		return KindDate
+	}
+	return KindText
+}
+
+// convertCell converts cell text to a value of the column's kind. Like the
+// Excel driver, text that does not fit the column type becomes Null.
+func convertCell(s string, kind ValueKind) (Value, bool) {
+	if strings.TrimSpace(s) == "" {
+		return Null, true
+	}
+	switch kind {
+	case KindNumber:
+		if n, ok := parseNumber(s); ok {
+			return Number(n), true
+		}
+	case KindDate:
+		if t, ok := parseDate(s); ok {
+			return Date(t), true
+		}
+		if n, ok := parseNumber(s); ok {
+			return Date(serialDate(n)), true
+		}
+	case KindBool:
+		if strings.EqualFold(strings.TrimSpace(s), "TRUE") {
+			return Bool(true), true
+		}
+		if strings.EqualFold(strings.TrimSpace(s), "FALSE") {
+			return Bool(false), true
+		}
+	default:
+		return Text(s), true
+	}
+	return Null, false
+}
+
+// toNumber converts a value for arithmetic; dates become serial numbers and
+// True is -1 as in VBA
+func toNumber(v Value) (float64, bool) {
+	switch v.Kind {
+	case KindNumber:
+		return v.Num, true
+	case KindDate:
+		return dateSerial(v.Time), true
+	case KindBool:
+		if v.Bool {
+			return -1, true
+		}
+		return 0, true
+	case KindText:
+		return parseNumber(v.Str)
+	}
+	return 0, false
+}
+
+// toDate converts a value to a date; numbers are date serials
+func toDate(v Value) (time.Time, bool) {
+	switch v.Kind {
+	case KindDate:
+		return v.Time, true
+	case KindNumber:
+		return serialDate(v.Num), true
+	case KindText:
+		return parseDate(v.Str)
+	}
+	return time.Time{}, false
+}
+
+// truthy converts a value to a condition result; Null is neither true nor false
+func truthy(v Value) (result bool, known bool) {
+	switch v.Kind {
+	case KindNull:
+		return false, false
+	case KindBool:
+		return v.Bool, true
+	}
+	n, ok := toNumber(v)
+	return ok && n != 0, true
+}
+
+// key returns a string that is equal for values Jet treats as equal when
+// grouping or removing duplicates
+func (v Value) key() string {
+	switch v.Kind {
+	case KindNull:
+		return "\x00"
+	case KindText:
+		return "t" + strings.ToLower(v.Str)
+	case KindDate:
+		return "n" + strconv.FormatFloat(dateSerial(v.Time), 'g', -1, 64)
+	}
+	n, _ := toNumber(v)
+	return "n" + strconv.FormatFloat(n, 'g', -1, 64)
+}
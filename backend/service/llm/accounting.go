@@ -0,0 +1,257 @@
+package llm
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pricing is the price of a model in US dollars per million tokens
+type Pricing struct {
+	InputPerMillion  float64 `json:"inputPerMillion"`
+	OutputPerMillion float64 `json:"outputPerMillion"`
+}
+
+// Cost returns the price of the given usage
+func (p Pricing) Cost(u Usage) float64 {
+	return (float64(u.InputTokens)*p.InputPerMillion + float64(u.OutputTokens)*p.OutputPerMillion) / 1e6
+}
+
+// prices lists the list prices of known model families, longest prefix
+// first. Unknown models have no price; local servers are booked at zero.
+var prices = []struct {
+	prefix  string
+	pricing Pricing
+}{
+	{"gpt-4o-mini", Pricing{0.15, 0.60}},
+	{"gpt-4o", Pricing{2.50, 10}},
+	{"gpt-4.1-nano", Pricing{0.10, 0.40}},
+	{"gpt-4.1-mini", Pricing{0.40, 1.60}},
+	{"gpt-4.1", Pricing{2, 8}},
+	{"gpt-4-turbo", Pricing{10, 30}},
+	{"gpt-3.5-turbo", Pricing{0.50, 1.50}},
+	{"o1-mini", Pricing{1.10, 4.40}},
+	{"o1", Pricing{15, 60}},
+	{"o3-mini", Pricing{1.10, 4.40}},
+	{"o4-mini", Pricing{1.10, 4.40}},
+	{"claude-3-5-haiku", Pricing{0.80, 4}},
+	{"claude-3-haiku", Pricing{0.25, 1.25}},
+	{"claude-3-5-sonnet", Pricing{3, 15}},
+	{"claude-3-7-sonnet", Pricing{3, 15}},
+	{"claude-sonnet-4", Pricing{3, 15}},
+	{"claude-3-opus", Pricing{15, 75}},
+	{"claude-opus-4", Pricing{15, 75}},
+}
+
+// PriceFor returns the known price of a model
+func PriceFor(model string) (Pricing, bool) {
+	model = strings.ToLower(model)
+	for _, known := range prices {
+		if strings.HasPrefix(model, known.prefix) {
+			return known.pricing, true
+		}
+	}
+	return Pricing{}, false
+}
+
+// Caller identifies who a request is made for
+type Caller struct {
+	Session string
+	User    string
+}
+
+type callerKey struct{}
+
+// WithCaller attaches the session and user that usage is booked to
+func WithCaller(ctx context.Context, session, user string) context.Context {
+	return context.WithValue(ctx, callerKey{}, Caller{Session: session, User: user})
+}
+
+// CallerFrom returns the caller attached to ctx, with empty fields when none is
+func CallerFrom(ctx context.Context) Caller {
+	caller, _ := ctx.Value(callerKey{}).(Caller)
+	return caller
+}
+
+// UsageStats adds up the requests, tokens and cost of a session, user or
+// the whole app
+type UsageStats struct {
+	Requests         int       `json:"requests"`
+	Failures         int       `json:"failures"`
+	InputTokens      int       `json:"inputTokens"`
+	OutputTokens     int       `json:"outputTokens"`
+	CostUSD          float64   `json:"costUsd"`
+	UnpricedRequests int       `json:"unpricedRequests"` // Requests to models without a known price
+	Estimated        bool      `json:"estimated"`        // Some token counts were estimated from text length
+	LastUsed         time.Time `json:"lastUsed"`
+}
+
+// add books one request
+func (s *UsageStats) add(e entry) {
+	s.Requests++
+	if e.failed {
+		s.Failures++
+	}
+	s.InputTokens += e.usage.InputTokens
+	s.OutputTokens += e.usage.OutputTokens
+	s.CostUSD += e.cost
+	if !e.priced && e.usage.Total() > 0 {
+		s.UnpricedRequests++
+	}
+	s.Estimated = s.Estimated || e.estimated
+	s.LastUsed = e.time
+}
+
+// entry is one request booked in the ledger
+type entry struct {
+	usage     Usage
+	cost      float64
+	priced    bool
+	estimated bool
+	failed    bool
+	time      time.Time
+}
+
+// UsageSnapshot is a copy of the ledger's totals
+type UsageSnapshot struct {
+	Total    UsageStats            `json:"total"`
+	Sessions map[string]UsageStats `json:"sessions"`
+	Users    map[string]UsageStats `json:"users"`
+}
+
+// Ledger records the usage and cost of requests per session and per user
+type Ledger struct {
+	mu       sync.Mutex
+	total    UsageStats
+	sessions map[string]*UsageStats
+	users    map[string]*UsageStats
+}
+
+// NewLedger creates an empty ledger
+func NewLedger() *Ledger {
+	return &Ledger{sessions: map[string]*UsageStats{}, users: map[string]*UsageStats{}}
+}
+
+// record books a request to the total and to its caller's session and user
+func (l *Ledger) record(caller Caller, e entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total.add(e)
+	if caller.Session != "" {
+		stats, ok := l.sessions[caller.Session]
+		if !ok {
+			stats = &UsageStats{}
+			l.sessions[caller.Session] = stats
+		}
+		stats.add(e)
+	}
+	if caller.User != "" {
+		stats, ok := l.users[caller.User]
+		if !ok {
+			stats = &UsageStats{}
+			l.users[caller.User] = stats
+		}
+		stats.add(e)
+	}
+}
+
+// Snapshot returns a copy of the totals
+func (l *Ledger) Snapshot() UsageSnapshot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	snapshot := UsageSnapshot{
+		Total:    l.total,
+		Sessions: make(map[string]UsageStats, len(l.sessions)),
+		Users:    make(map[string]UsageStats, len(l.users)),
+	}
+	for id, stats := range l.sessions {
+		snapshot.Sessions[id] = *stats
+	}
+	for id, stats := range l.users {
+		snapshot.Users[id] = *stats
+	}
+	return snapshot
+}
+
+// MeteredClient books the usage and cost of every request in a ledger, under
+// the caller attached to the request's context
+type MeteredClient struct {
+	inner   Client
+	ledger  *Ledger
+	pricing *Pricing
+}
+
+// NewMeteredClient wraps a client; pricing overrides the known price of the
+// model when not nil
+func NewMeteredClient(inner Client, ledger *Ledger, pricing *Pricing) *MeteredClient {
+	return &MeteredClient{inner: inner, ledger: ledger, pricing: pricing}
+}
+
+// Complete sends the request and books its usage
+func (c *MeteredClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	resp, err := c.inner.Complete(ctx, req)
+	c.record(ctx, req, resp, "", err)
+	return resp, err
+}
+
+// Stream sends the request and books its usage. A failed stream is booked
+// with the text it delivered, since providers bill for it.
+func (c *MeteredClient) Stream(ctx context.Context, req Request, onDelta func(delta string)) (*Response, error) {
+	var received strings.Builder
+	resp, err := c.inner.Stream(ctx, req, func(delta string) {
+		received.WriteString(delta)
+		if onDelta != nil {
+			onDelta(delta)
+		}
+	})
+	c.record(ctx, req, resp, received.String(), err)
+	return resp, err
+}
+
+// Model describes the wrapped client's model
+func (c *MeteredClient) Model() ModelInfo {
+	return c.inner.Model()
+}
+
+// Usage returns the wrapped client's usage
+func (c *MeteredClient) Usage() Usage {
+	return c.inner.Usage()
+}
+
+// record books one request, estimating the tokens the provider did not report
+func (c *MeteredClient) record(ctx context.Context, req Request, resp *Response, received string, err error) {
+	e := entry{failed: err != nil, time: time.Now()}
+	model := c.inner.Model().Name
+	if resp != nil {
+		e.usage = resp.Usage
+		if resp.Model != "" {
+			model = resp.Model
+		}
+	}
+	if e.usage.InputTokens == 0 && (err == nil || received != "") {
+		e.usage.InputTokens, e.estimated = estimateInput(req), true
+	}
+	if e.usage.OutputTokens == 0 {
+		output := received
+		if resp != nil {
+			output = resp.Content
+		}
+		if output != "" {
+			e.usage.OutputTokens, e.estimated = EstimateTokens(output), true
+		}
+	}
+
+	pricing, priced := PriceFor(model)
+	if c.inner.Model().Provider == ProviderLocal {
+		pricing, priced = Pricing{}, true // Runs on the site's own hardware
+	}
+	if c.pricing != nil {
+		pricing, priced = *c.pricing, true
+	}
+	if priced {
+		e.cost = pricing.Cost(e.usage)
+	}
+	e.priced = priced
+	c.ledger.record(CallerFrom(ctx), e)
+}
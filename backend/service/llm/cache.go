@@ -0,0 +1,416 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of CacheConfig
+const (
+	DefaultCacheTTLHours   = 7 * 24
+	DefaultCacheMaxMB      = 50
+	DefaultCacheMaxEntries = 1000
+)
+
+// CacheConfig controls the response cache
+type CacheConfig struct {
+	Disabled   bool `json:"disabled,omitempty"`
+	TTLHours   int  `json:"ttlHours,omitempty"`   // DefaultCacheTTLHours when 0
+	MaxMB      int  `json:"maxMb,omitempty"`      // DefaultCacheMaxMB when 0
+	MaxEntries int  `json:"maxEntries,omitempty"` // DefaultCacheMaxEntries when 0
+}
+
+// Validate checks the limits
+func (c CacheConfig) Validate() error {
+	if c.TTLHours < 0 || c.MaxMB < 0 || c.MaxEntries < 0 {
+		return fmt.Errorf("cache limits must not be negative, got %+v", c)
+	}
+	return nil
+}
+
+func (c CacheConfig) ttl() time.Duration {
+	if c.TTLHours > 0 {
+		return time.Duration(c.TTLHours) * time.Hour
+	}
+	return DefaultCacheTTLHours * time.Hour
+}
+
+func (c CacheConfig) maxBytes() int64 {
+	if c.MaxMB > 0 {
+		return int64(c.MaxMB) << 20
+	}
+	return DefaultCacheMaxMB << 20
+}
+
+func (c CacheConfig) maxEntries() int {
+	if c.MaxEntries > 0 {
+		return c.MaxEntries
+	}
+	return DefaultCacheMaxEntries
+}
+
+// CacheStats counts the lookups of the response cache
+type CacheStats struct {
+	Enabled   bool    `json:"enabled"`
+	Hits      int     `json:"hits"`
+	Misses    int     `json:"misses"`
+	Bypassed  int     `json:"bypassed"` // Requests that skipped the cache on purpose
+	Stores    int     `json:"stores"`
+	Expired   int     `json:"expired"`
+	Evictions int     `json:"evictions"`
+	Entries   int     `json:"entries"`
+	Bytes     int64   `json:"bytes"`
+	HitRate   float64 `json:"hitRate"` // Hits over hits and misses, 0 before the first lookup
+}
+
+// cachedResponse is the file stored for one entry
+type cachedResponse struct {
+	Key      string    `json:"key"`
+	Created  time.Time `json:"created"`
+	Response Response  `json:"response"`
+}
+
+// cacheEntry is the in-memory index of one file
+type cacheEntry struct {
+	size int64
+	used time.Time
+}
+
+// ResponseCache keeps model responses on disk, one JSON file per key, and
+// evicts the least recently used ones beyond its size limits
+type ResponseCache struct {
+	dir string
+
+	mu      sync.Mutex
+	config  CacheConfig
+	entries map[string]*cacheEntry
+	bytes   int64
+	stats   CacheStats
+}
+
+// OpenCache opens or creates a cache directory. Files left by an earlier
+// run are indexed by their modification time, which Get refreshes on hits.
+func OpenCache(dir string, config CacheConfig) (*ResponseCache, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	c := &ResponseCache{dir: dir, config: config, entries: map[string]*cacheEntry{}}
+	for _, file := range files {
+		key := strings.TrimSuffix(file.Name(), ".json")
+		if file.IsDir() || key == file.Name() || !isCacheKey(key) {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		c.entries[key] = &cacheEntry{size: info.Size(), used: info.ModTime()}
+		c.bytes += info.Size()
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// SetConfig changes the settings, evicting entries beyond the new limits
+func (c *ResponseCache) SetConfig(config CacheConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.config = config
+	c.evict()
+	return nil
+}
+
+// Enabled reports whether lookups and stores are on
+func (c *ResponseCache) Enabled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.config.Disabled
+}
+
+// Get returns the response stored under key unless it is missing or
+// older than the TTL
+func (c *ResponseCache) Get(key string) (*Response, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	var stored cachedResponse
+	data, err := os.ReadFile(c.path(key))
+	if err == nil {
+		err = json.Unmarshal(data, &stored)
+	}
+	if err != nil || stored.Key != key {
+		c.remove(key)
+		c.stats.Misses++
+		return nil, false
+	}
+	if time.Since(stored.Created) > c.config.ttl() {
+		c.remove(key)
+		c.stats.Expired++
+		c.stats.Misses++
+		return nil, false
+	}
+
+	now := time.Now()
+	c.entries[key].used = now
+	os.Chtimes(c.path(key), now, now)
+	c.stats.Hits++
+	return &stored.Response, true
+}
+
+// Put stores a response under key
+func (c *ResponseCache) Put(key string, resp *Response) error {
+	data, err := json.Marshal(cachedResponse{Key: key, Created: time.Now(), Response: *resp})
+	if err != nil {
+		return fmt.Errorf("failed to encode cached response: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Write to a temporary file first so a crash never leaves half an entry
+	tmp := c.path(key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write cached response: %w", err)
+	}
+	if err := os.Rename(tmp, c.path(key)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write cached response: %w", err)
+	}
+
+	if old, ok := c.entries[key]; ok {
+		c.bytes -= old.size
+	}
+	c.entries[key] = &cacheEntry{size: int64(len(data)), used: time.Now()}
+	c.bytes += int64(len(data))
+	c.stats.Stores++
+	c.evict()
+	return nil
+}
+
+// Clear removes every entry
+func (c *ResponseCache) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if err := os.Remove(c.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to clear cache: %w", err)
+		}
+		c.bytes -= c.entries[key].size
+		delete(c.entries, key)
+	}
+	return nil
+}
+
+// Stats returns the lookup counters and the current size
+func (c *ResponseCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Enabled = !c.config.Disabled
+	stats.Entries = len(c.entries)
+	stats.Bytes = c.bytes
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(lookups)
+	}
+	return stats
+}
+
+// bypassed counts a request that skipped the cache
+func (c *ResponseCache) bypassed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Bypassed++
+}
+
+// evict removes the least recently used entries until both limits hold;
+// the caller holds the lock
+func (c *ResponseCache) evict() {
+	maxBytes, maxEntries := c.config.maxBytes(), c.config.maxEntries()
+	if c.bytes <= maxBytes && len(c.entries) <= maxEntries {
+		return
+	}
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return c.entries[keys[i]].used.Before(c.entries[keys[j]].used) })
+	for _, key := range keys {
+		if c.bytes <= maxBytes && len(c.entries) <= maxEntries {
+			break
+		}
+		c.remove(key)
+		c.stats.Evictions++
+	}
+}
+
+// remove deletes an entry and its file; the caller holds the lock
+func (c *ResponseCache) remove(key string) {
+	if entry, ok := c.entries[key]; ok {
+		c.bytes -= entry.size
+		delete(c.entries, key)
+	}
+	os.Remove(c.path(key))
+}
+
+func (c *ResponseCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// isCacheKey reports whether a file name is a key written by Put
+func isCacheKey(name string) bool {
+	if len(name) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// CacheKey hashes everything that shapes a response: the model, its
+// endpoint, the prompt after normalize and the sampling parameters.
+// normalize may be nil.
+func CacheKey(model ModelInfo, req Request, normalize func(string) string) string {
+	if normalize == nil {
+		normalize = func(s string) string { return s }
+	}
+	name := model.Name
+	if req.Model != "" {
+		name = req.Model
+	}
+	maxTokens := model.MaxOutputTokens
+	if req.MaxTokens > 0 {
+		maxTokens = req.MaxTokens
+	}
+	messages := make([]Message, len(req.Messages))
+	for i, message := range req.Messages {
+		messages[i] = Message{Role: message.Role, Content: normalize(message.Content)}
+	}
+
+	data, _ := json.Marshal(struct {
+		Provider      Provider
+		BaseURL       string
+		Model         string
+		System        string
+		Messages      []Message
+		MaxTokens     int
+		Temperature   *float64
+		StopSequences []string
+	}{model.Provider, model.BaseURL, name, normalize(req.System), messages, maxTokens, req.Temperature, req.StopSequences})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+type bypassKey struct{}
+
+// WithoutCache makes requests made with the returned context skip the cache
+// lookup; their responses still refresh the cache
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassKey{}, true)
+}
+
+func cacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(bypassKey{}).(bool)
+	return bypass
+}
+
+// CachedClient answers repeated requests from a response cache. Failed
+// and cut-off responses are not stored.
+type CachedClient struct {
+	inner     Client
+	cache     *ResponseCache
+	normalize func(string) string
+}
+
+// NewCachedClient wraps a client; normalize is applied to the prompts
+// before hashing and may be nil
+func NewCachedClient(inner Client, cache *ResponseCache, normalize func(string) string) *CachedClient {
+	return &CachedClient{inner: inner, cache: cache, normalize: normalize}
+}
+
+// Complete returns the cached response or sends the request
+func (c *CachedClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	key, hit := c.lookup(ctx, req)
+	if hit != nil {
+		return hit, nil
+	}
+	resp, err := c.inner.Complete(ctx, req)
+	c.store(key, resp, err)
+	return resp, err
+}
+
+// Stream returns the cached response as a single delta or streams the request
+func (c *CachedClient) Stream(ctx context.Context, req Request, onDelta func(delta string)) (*Response, error) {
+	key, hit := c.lookup(ctx, req)
+	if hit != nil {
+		if onDelta != nil {
+			onDelta(hit.Content)
+		}
+		return hit, nil
+	}
+	resp, err := c.inner.Stream(ctx, req, onDelta)
+	c.store(key, resp, err)
+	return resp, err
+}
+
+// Model describes the wrapped client's model
+func (c *CachedClient) Model() ModelInfo {
+	return c.inner.Model()
+}
+
+// Usage returns the wrapped client's usage, which excludes cache hits
+func (c *CachedClient) Usage() Usage {
+	return c.inner.Usage()
+}
+
+// lookup returns the key of a request and its cached response, if any. The
+// key is empty when the cache is off.
+func (c *CachedClient) lookup(ctx context.Context, req Request) (string, *Response) {
+	if !c.cache.Enabled() {
+		return "", nil
+	}
+	key := CacheKey(c.inner.Model(), req, c.normalize)
+	if cacheBypassed(ctx) {
+		c.cache.bypassed()
+		return key, nil
+	}
+	hit, ok := c.cache.Get(key)
+	if !ok {
+		return key, nil
+	}
+	hit.Cached = true
+	hit.Duration = 0
+	return key, hit
+}
+
+// store keeps a finished, complete response
+func (c *CachedClient) store(key string, resp *Response, err error) {
+	if key == "" || err != nil || resp == nil || resp.Truncated || strings.TrimSpace(resp.Content) == "" {
+		return
+	}
+	c.cache.Put(key, resp)
+}
@@ -0,0 +1,213 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// anthropicVersion is the Messages API version the client speaks
+const anthropicVersion = "2023-06-01"
+
+// ClaudeClient talks to the Anthropic Messages API
+type ClaudeClient struct {
+	config Config
+	usage  usageCounter
+}
+
+// NewClaudeClient creates a client for the Anthropic Messages API
+func NewClaudeClient(config Config) *ClaudeClient {
+	config.Provider = ProviderAnthropic
+	return &ClaudeClient{config: config}
+}
+
+type claudeRequest struct {
+	Model         string    `json:"model"`
+	MaxTokens     int       `json:"max_tokens"`
+	System        string    `json:"system,omitempty"`
+	Messages      []Message `json:"messages"`
+	Temperature   *float64  `json:"temperature,omitempty"`
+	StopSequences []string  `json:"stop_sequences,omitempty"`
+	Stream        bool      `json:"stream,omitempty"`
+}
+
+type claudeUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type claudeMessage struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string      `json:"stop_reason"`
+	Usage      claudeUsage `json:"usage"`
+}
+
+// claudeEvent is one event of a streamed message
+type claudeEvent struct {
+	Type    string        `json:"type"`
+	Message claudeMessage `json:"message"` // message_start
+	Delta   struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`        // content_block_delta
+		StopReason string `json:"stop_reason"` // message_delta
+	} `json:"delta"`
+	Usage *claudeUsage `json:"usage"` // message_delta
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Complete sends the request and waits for the whole response
+func (c *ClaudeClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	requestCtx, cancel := context.WithTimeout(ctx, c.config.timeout())
+	defer cancel()
+	start := time.Now()
+
+	resp, err := post(requestCtx, c.config, c.config.baseURL()+"/v1/messages", c.headers(), c.body(req, false))
+	if err != nil {
+		return nil, requestError(c.config.Provider, err, c.config.timeout(), ctx)
+	}
+	defer resp.Body.Close()
+
+	var body claudeMessage
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, requestError(c.config.Provider, fmt.Errorf("failed to decode %s response: %w", c.config.Provider, err), c.config.timeout(), ctx)
+	}
+	var content strings.Builder
+	for _, block := range body.Content {
+		if block.Type == "text" {
+			content.WriteString(block.Text)
+		}
+	}
+	result := &Response{
+		ID:         body.ID,
+		Model:      body.Model,
+		Content:    content.String(),
+		StopReason: body.StopReason,
+		Truncated:  body.StopReason == "max_tokens",
+		Usage:      Usage{InputTokens: body.Usage.InputTokens, OutputTokens: body.Usage.OutputTokens, Requests: 1},
+		Duration:   time.Since(start),
+	}
+	c.usage.add(result.Usage)
+	return result, nil
+}
+
+// Stream sends the request and calls onDelta with each piece of text
+func (c *ClaudeClient) Stream(ctx context.Context, req Request, onDelta func(delta string)) (*Response, error) {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := newWatchdog(c.config.timeout(), cancel)
+	start := time.Now()
+
+	resp, err := post(streamCtx, c.config, c.config.baseURL()+"/v1/messages", c.headers(), c.body(req, true))
+	if err != nil {
+		return nil, streamError(c.config.Provider, err, w, ctx)
+	}
+	defer resp.Body.Close()
+
+	result := &Response{Usage: Usage{Requests: 1}}
+	var content strings.Builder
+	err = readEvents(resp.Body, func(name, data string) error {
+		w.reset()
+		var event claudeEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("failed to decode %s stream event: %w", c.config.Provider, err)
+		}
+		switch event.Type {
+		case "message_start":
+			result.ID = event.Message.ID
+			result.Model = event.Message.Model
+			result.Usage.InputTokens = event.Message.Usage.InputTokens
+			result.Usage.OutputTokens = event.Message.Usage.OutputTokens
+		case "content_block_delta":
+			if event.Delta.Type == "text_delta" && event.Delta.Text != "" {
+				content.WriteString(event.Delta.Text)
+				if onDelta != nil {
+					onDelta(event.Delta.Text)
+				}
+			}
+		case "message_delta":
+			if event.Delta.StopReason != "" {
+				result.StopReason = event.Delta.StopReason
+			}
+			if event.Usage != nil {
+				// Output tokens are reported as a running total
+				result.Usage.OutputTokens = event.Usage.OutputTokens
+			}
+		case "message_stop":
+			return errStopEvents
+		case "error":
+			e := &APIError{Provider: c.config.Provider, StatusCode: resp.StatusCode}
+			if event.Error != nil {
+				e.Type, e.Message = event.Error.Type, event.Error.Message
+			}
+			return e
+		}
+		return nil
+	})
+	if err == nil {
+		err = streamCut(c.config.Provider, "message_stop")
+	}
+	if !errors.Is(err, errStopEvents) {
+		return nil, streamError(c.config.Provider, err, w, ctx)
+	}
+	w.stop()
+
+	result.Content = content.String()
+	result.Truncated = result.StopReason == "max_tokens"
+	result.Duration = time.Since(start)
+	if result.Model == "" {
+		result.Model = c.config.model()
+	}
+	c.usage.add(result.Usage)
+	return result, nil
+}
+
+// Model describes the configured model
+func (c *ClaudeClient) Model() ModelInfo {
+	return c.config.modelInfo()
+}
+
+// Usage returns the tokens used by all requests of this client
+func (c *ClaudeClient) Usage() Usage {
+	return c.usage.get()
+}
+
+// headers returns the API key and version headers
+func (c *ClaudeClient) headers() map[string]string {
+	headers := map[string]string{"anthropic-version": anthropicVersion}
+	if key := c.config.apiKey(); key != "" {
+		headers["x-api-key"] = key
+	}
+	return headers
+}
+
+// body builds the Messages API request
+func (c *ClaudeClient) body(req Request, stream bool) claudeRequest {
+	model := req.Model
+	if model == "" {
+		model = c.config.model()
+	}
+	messages := req.Messages
+	if messages == nil {
+		messages = []Message{}
+	}
+	return claudeRequest{
+		Model:         model,
+		MaxTokens:     c.config.maxTokens(req),
+		System:        req.System,
+		Messages:      messages,
+		Temperature:   req.Temperature,
+		StopSequences: req.StopSequences,
+		Stream:        stream,
+	}
+}
@@ -0,0 +1,164 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// claudeServer stands in for the Messages API
+func claudeServer(t *testing.T, handler func(w http.ResponseWriter, body claudeRequest)) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("x-api-key") != "test-key" || r.Header.Get("anthropic-version") != anthropicVersion {
+			t.Errorf("headers = %v", r.Header)
+		}
+		var body claudeRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("request body: %v", err)
+		}
+		handler(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testClaudeClient(url string) *ClaudeClient {
+	return NewClaudeClient(Config{Provider: ProviderAnthropic, BaseURL: url, APIKey: "test-key", Model: "claude-3-5-sonnet-latest", TimeoutSeconds: 1})
+}
+
+// claudeEvents writes named server-sent events, flushing after each
+func claudeEvents(w http.ResponseWriter, events ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, data := range events {
+		var event struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal([]byte(data), &event)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		w.(http.Flusher).Flush()
+	}
+}
+
+func TestClaudeComplete(t *testing.T) {
+	server := claudeServer(t, func(w http.ResponseWriter, body claudeRequest) {
+		if body.Stream || body.System != "system" || len(body.Messages) != 1 || body.MaxTokens != DefaultMaxTokens {
+			t.Errorf("unexpected request %+v", body)
+		}
+		fmt.Fprint(w, `{"id":"m1","model":"claude-3-5-sonnet-20241022","content":[{"type":"text","text":"Sub A()"},{"type":"text","text":"\nEnd Sub"}],"stop_reason":"end_turn","usage":{"input_tokens":20,"output_tokens":6}}`)
+	})
+	client := testClaudeClient(server.URL)
+
+	resp, err := client.Complete(context.Background(), Prompt("system", "hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != "m1" || resp.Content != "Sub A()\nEnd Sub" || resp.StopReason != "end_turn" || resp.Truncated {
+		t.Errorf("response = %+v", resp)
+	}
+	if resp.Usage != (Usage{InputTokens: 20, OutputTokens: 6, Requests: 1}) || client.Usage() != resp.Usage {
+		t.Errorf("usage = %+v, client usage = %+v", resp.Usage, client.Usage())
+	}
+}
+
+func TestClaudeStream(t *testing.T) {
+	server := claudeServer(t, func(w http.ResponseWriter, body claudeRequest) {
+		if !body.Stream {
+			t.Error("stream not requested")
+		}
+		claudeEvents(w,
+			`{"type":"message_start","message":{"id":"m2","model":"claude-3-5-sonnet-20241022","usage":{"input_tokens":9,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Sub "}}`,
+			`{"type":"ping"}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"A()"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"message_delta","delta":{"stop_reason":"max_tokens"},"usage":{"output_tokens":4}}`,
+			`{"type":"message_stop"}`)
+	})
+
+	var deltas []string
+	resp, err := testClaudeClient(server.URL).Stream(context.Background(), Prompt("", "hello"), func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(deltas, "|") != "Sub |A()" || resp.Content != "Sub A()" || resp.ID != "m2" || !resp.Truncated {
+		t.Errorf("deltas = %q, response = %+v", deltas, resp)
+	}
+	if resp.Usage != (Usage{InputTokens: 9, OutputTokens: 4, Requests: 1}) {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+}
+
+func TestClaudeAPIError(t *testing.T) {
+	server := claudeServer(t, func(w http.ResponseWriter, body claudeRequest) {
+		w.WriteHeader(529)
+		fmt.Fprint(w, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+	})
+
+	_, err := testClaudeClient(server.URL).Stream(context.Background(), Prompt("", "hello"), nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("error = %v, want *APIError", err)
+	}
+	if apiErr.StatusCode != 529 || apiErr.Type != "overloaded_error" || apiErr.Provider != ProviderAnthropic {
+		t.Errorf("API error = %+v", apiErr)
+	}
+}
+
+func TestClaudeStreamErrorEvent(t *testing.T) {
+	server := claudeServer(t, func(w http.ResponseWriter, body claudeRequest) {
+		claudeEvents(w,
+			`{"type":"message_start","message":{"id":"m3"}}`,
+			`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+	})
+
+	_, err := testClaudeClient(server.URL).Stream(context.Background(), Prompt("", "hello"), nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Type != "overloaded_error" {
+		t.Errorf("error = %v, want the error event", err)
+	}
+}
+
+func TestClaudeTimeout(t *testing.T) {
+	server := claudeServer(t, func(w http.ResponseWriter, body claudeRequest) {
+		if body.Stream {
+			claudeEvents(w, `{"type":"message_start","message":{"id":"m4"}}`)
+		}
+		time.Sleep(1500 * time.Millisecond)
+	})
+	client := testClaudeClient(server.URL)
+
+	if _, err := client.Complete(context.Background(), Prompt("", "hello")); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Complete error = %v, want ErrTimeout", err)
+	}
+	if _, err := client.Stream(context.Background(), Prompt("", "hello"), nil); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Stream error = %v, want ErrTimeout", err)
+	}
+}
+
+func TestClaudeStreamEndsEarly(t *testing.T) {
+	server := claudeServer(t, func(w http.ResponseWriter, body claudeRequest) {
+		claudeEvents(w,
+			`{"type":"message_start","message":{"id":"m5"}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Sub A()"}}`)
+	})
+
+	resp, err := testClaudeClient(server.URL).Stream(context.Background(), Prompt("", "hello"), nil)
+	if !errors.Is(err, io.ErrUnexpectedEOF) || resp != nil {
+		t.Errorf("response = %+v, error = %v, want io.ErrUnexpectedEOF", resp, err)
+	}
+}
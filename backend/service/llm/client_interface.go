@@ -0,0 +1,177 @@
+// Package llm sends prompts to language model APIs: an OpenAI-compatible
+// chat completions client, an Anthropic Messages client and a client for
+// self-hosted servers behind one Client interface.
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client sends prompts to a language model
+type Client interface {
+	// Complete sends the request and waits for the whole response
+	Complete(ctx context.Context, req Request) (*Response, error)
+	// Stream sends the request and calls onDelta with each piece of text as
+	// it arrives. The returned response holds the full text and usage.
+	Stream(ctx context.Context, req Request, onDelta func(delta string)) (*Response, error)
+	// Model describes the model requests go to
+	Model() ModelInfo
+	// Usage returns the tokens used by all requests of this client
+	Usage() Usage
+}
+
+// Role is the author of a message
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+)
+
+// Message is one turn of a conversation
+type Message struct {
+	Role    Role   `json:"role"`
+	Content string `json:"content"`
+}
+
+// Request is a prompt for the model
+type Request struct {
+	System        string    `json:"system"`                  // System prompt
+	Messages      []Message `json:"messages"`                // Conversation, ending with the user's turn
+	Model         string    `json:"model,omitempty"`         // Overrides the configured model
+	MaxTokens     int       `json:"maxTokens,omitempty"`     // Overrides the configured output limit
+	Temperature   *float64  `json:"temperature,omitempty"`   // Provider default when nil
+	StopSequences []string  `json:"stopSequences,omitempty"` // Text that ends the response
+}
+
+// Prompt builds a request with a single user message
+func Prompt(system, user string) Request {
+	return Request{System: system, Messages: []Message{{Role: RoleUser, Content: user}}}
+}
+
+// Response is the model's answer
+type Response struct {
+	ID         string        `json:"id"`
+	Model      string        `json:"model"`
+	Content    string        `json:"content"`
+	StopReason string        `json:"stopReason"` // As reported by the provider
+	Truncated  bool          `json:"truncated"`  // The output limit cut the response short
+	Usage      Usage         `json:"usage"`
+	Duration   time.Duration `json:"duration"`
+	Cached     bool          `json:"cached"` // Replayed from the response cache; Usage is that of the original request
+}
+
+// Usage counts tokens
+type Usage struct {
+	InputTokens  int `json:"inputTokens"`
+	OutputTokens int `json:"outputTokens"`
+	Requests     int `json:"requests"`
+}
+
+// Total returns the input and output tokens together
+func (u Usage) Total() int {
+	return u.InputTokens + u.OutputTokens
+}
+
+// Add returns the sum of two usages
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		InputTokens:  u.InputTokens + other.InputTokens,
+		OutputTokens: u.OutputTokens + other.OutputTokens,
+		Requests:     u.Requests + other.Requests,
+	}
+}
+
+// ModelInfo describes a model
+type ModelInfo struct {
+	Provider        Provider `json:"provider"`
+	Name            string   `json:"name"`
+	BaseURL         string   `json:"baseUrl"`
+	ContextWindow   int      `json:"contextWindow"`   // Input and output tokens the model accepts
+	MaxOutputTokens int      `json:"maxOutputTokens"` // Output limit sent with each request
+}
+
+// APIError is an error response from a provider
+type APIError struct {
+	Provider   Provider      `json:"provider"`
+	StatusCode int           `json:"statusCode"`
+	Type       string        `json:"type"` // Provider error type, e.g. "rate_limit_error"
+	Message    string        `json:"message"`
+	RetryAfter time.Duration `json:"retryAfter"` // From the Retry-After header, 0 when absent
+}
+
+func (e *APIError) Error() string {
+	message := e.Message
+	if message == "" {
+		message = "request failed"
+	}
+	if e.Type != "" {
+		return fmt.Sprintf("%s API error %d (%s): %s", e.Provider, e.StatusCode, e.Type, message)
+	}
+	return fmt.Sprintf("%s API error %d: %s", e.Provider, e.StatusCode, message)
+}
+
+// New creates the client for the configured provider
+func New(config Config) (Client, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	switch config.Provider {
+	case ProviderAnthropic:
+		return NewClaudeClient(config), nil
+	case ProviderLocal:
+		return NewLocalClient(config), nil
+	default:
+		return NewOpenAIClient(config), nil
+	}
+}
+
+// usageCounter adds up the usage of a client's requests
+type usageCounter struct {
+	mu    sync.Mutex
+	total Usage
+}
+
+func (c *usageCounter) add(u Usage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total = c.total.Add(u)
+}
+
+func (c *usageCounter) get() Usage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.total
+}
+
+// contextWindows lists the context window of known model families, longest
+// prefix first
+var contextWindows = []struct {
+	prefix string
+	tokens int
+}{
+	{"gpt-4.1", 1047576},
+	{"gpt-4o", 128000},
+	{"gpt-4-turbo", 128000},
+	{"gpt-4", 8192},
+	{"gpt-3.5-turbo", 16385},
+	{"o1", 200000},
+	{"o3", 200000},
+	{"o4", 200000},
+	{"claude", 200000},
+}
+
+// contextWindow returns the context window of a model, or 0 when unknown
+func contextWindow(model string) int {
+	model = strings.ToLower(model)
+	for _, known := range contextWindows {
+		if strings.HasPrefix(model, known.prefix) {
+			return known.tokens
+		}
+	}
+	return 0
+}
@@ -0,0 +1,235 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Provider is the API a client talks to
+type Provider string
+
+const (
+	ProviderOpenAI    Provider = "openai"    // OpenAI or any server with its chat completions API
+	ProviderAnthropic Provider = "anthropic" // Anthropic Messages API
+	ProviderLocal     Provider = "local"     // Self-hosted OpenAI-compatible server: llama.cpp, Ollama, vLLM
+)
+
+// Default endpoints and models of each provider
+const (
+	DefaultOpenAIBaseURL    = "https://api.openai.com/v1"
+	DefaultAnthropicBaseURL = "https://api.anthropic.com"
+	DefaultLocalBaseURL     = "http://localhost:11434/v1" // Ollama; llama.cpp listens on :8080 and vLLM on :8000
+	DefaultOpenAIModel      = "gpt-4o-mini"
+	DefaultAnthropicModel   = "claude-3-5-sonnet-latest"
+	DefaultMaxTokens        = 4096
+	DefaultTimeout          = 120 * time.Second
+)
+
+// Config selects and configures an LLM provider
+type Config struct {
+	Provider       Provider          `json:"provider"`
+	BaseURL        string            `json:"baseUrl,omitempty"` // Provider default when empty
+	APIKey         string            `json:"apiKey,omitempty"`  // Read from OPENAI_API_KEY or ANTHROPIC_API_KEY when empty
+	Model          string            `json:"model,omitempty"`   // Provider default when empty; the first served model for local servers
+	MaxTokens      int               `json:"maxTokens,omitempty"`
+	ContextWindow  int               `json:"contextWindow,omitempty"`  // Overrides the known window of the model
+	TimeoutSeconds int               `json:"timeoutSeconds,omitempty"` // Whole request for Complete, silence between chunks for Stream
+	Headers        map[string]string `json:"headers,omitempty"`        // Extra request headers, e.g. for a proxy
+	MaxRetries     int               `json:"maxRetries,omitempty"`     // 0 uses DefaultMaxRetries, -1 disables retries
+	RateLimit      RateLimit         `json:"rateLimit"`                // Shared by every client of this provider and base URL
+	Pricing        *Pricing          `json:"pricing,omitempty"`        // Overrides the known prices of the model
+	Cache          CacheConfig       `json:"cache"`
+
+	HTTPClient *http.Client `json:"-"` // Defaults to a client without a timeout of its own
+}
+
+// DefaultConfig uses the OpenAI API with its default model
+func DefaultConfig() *Config {
+	return &Config{Provider: ProviderOpenAI}
+}
+
+// LoadConfig reads the LLM settings from a JSON file
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LLM config: %w", err)
+	}
+
+	config := DefaultConfig()
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse LLM config %s: %w", path, err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid LLM config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Save writes the settings as indented JSON, readable by the user only
+// because they hold the API key
+func (c *Config) Save(path string) error {
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("invalid LLM config: %w", err)
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode LLM config: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write LLM config: %w", err)
+	}
+	return nil
+}
+
+// Validate checks the provider, base URL and limits
+func (c *Config) Validate() error {
+	switch c.Provider {
+	case ProviderOpenAI, ProviderAnthropic, ProviderLocal:
+	default:
+		return fmt.Errorf("unknown provider %q", c.Provider)
+	}
+	if c.BaseURL != "" {
+		u, err := url.Parse(c.BaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("baseUrl must be an http or https URL, got %q", c.BaseURL)
+		}
+	}
+	if c.MaxTokens < 0 {
+		return fmt.Errorf("maxTokens must not be negative, got %d", c.MaxTokens)
+	}
+	if c.ContextWindow < 0 {
+		return fmt.Errorf("contextWindow must not be negative, got %d", c.ContextWindow)
+	}
+	if c.TimeoutSeconds < 0 {
+		return fmt.Errorf("timeoutSeconds must not be negative, got %d", c.TimeoutSeconds)
+	}
+	if c.MaxRetries < -1 {
+		return fmt.Errorf("maxRetries must be -1 or more, got %d", c.MaxRetries)
+	}
+	if c.RateLimit.RequestsPerMinute < 0 || c.RateLimit.TokensPerMinute < 0 {
+		return fmt.Errorf("rateLimit must not be negative, got %+v", c.RateLimit)
+	}
+	if c.Pricing != nil && (c.Pricing.InputPerMillion < 0 || c.Pricing.OutputPerMillion < 0) {
+		return fmt.Errorf("pricing must not be negative, got %+v", *c.Pricing)
+	}
+	if err := c.Cache.Validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Redacted returns a copy whose API key only shows its last four characters
+func (c Config) Redacted() Config {
+	if len(c.APIKey) > 4 {
+		c.APIKey = strings.Repeat("*", 8) + c.APIKey[len(c.APIKey)-4:]
+	} else if c.APIKey != "" {
+		c.APIKey = strings.Repeat("*", 8)
+	}
+	c.Headers = nil
+	return c
+}
+
+// Ready reports whether requests can be sent: an API key is set, or the
+// server is local or another one that may not need a key
+func (c Config) Ready() bool {
+	return c.apiKey() != "" || c.BaseURL != "" || c.Provider == ProviderLocal
+}
+
+// baseURL returns the configured or default base URL without a trailing slash
+func (c Config) baseURL() string {
+	base := c.BaseURL
+	if base == "" {
+		switch c.Provider {
+		case ProviderAnthropic:
+			base = DefaultAnthropicBaseURL
+		case ProviderLocal:
+			base = DefaultLocalBaseURL
+		default:
+			base = DefaultOpenAIBaseURL
+		}
+	}
+	return strings.TrimRight(base, "/")
+}
+
+// model returns the configured or default model
+func (c Config) model() string {
+	if c.Model != "" {
+		return c.Model
+	}
+	switch c.Provider {
+	case ProviderAnthropic:
+		return DefaultAnthropicModel
+	case ProviderLocal:
+		return "" // Found by LocalClient.Detect
+	default:
+		return DefaultOpenAIModel
+	}
+}
+
+// apiKey returns the configured key or the provider's environment variable;
+// a local server only gets a key that was configured for it
+func (c Config) apiKey() string {
+	if c.APIKey != "" {
+		return c.APIKey
+	}
+	switch c.Provider {
+	case ProviderAnthropic:
+		return os.Getenv("ANTHROPIC_API_KEY")
+	case ProviderLocal:
+		return ""
+	default:
+		return os.Getenv("OPENAI_API_KEY")
+	}
+}
+
+// maxTokens returns the output limit of a request
+func (c Config) maxTokens(req Request) int {
+	if req.MaxTokens > 0 {
+		return req.MaxTokens
+	}
+	if c.MaxTokens > 0 {
+		return c.MaxTokens
+	}
+	return DefaultMaxTokens
+}
+
+// timeout returns the configured or default timeout
+func (c Config) timeout() time.Duration {
+	if c.TimeoutSeconds > 0 {
+		return time.Duration(c.TimeoutSeconds) * time.Second
+	}
+	return DefaultTimeout
+}
+
+// httpClient returns the configured or default HTTP client
+func (c Config) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// modelInfo describes the configured model
+func (c Config) modelInfo() ModelInfo {
+	window := c.ContextWindow
+	if window == 0 {
+		window = contextWindow(c.model())
+	}
+	return ModelInfo{
+		Provider:        c.Provider,
+		Name:            c.model(),
+		BaseURL:         c.baseURL(),
+		ContextWindow:   window,
+		MaxOutputTokens: c.maxTokens(Request{}),
+	}
+}
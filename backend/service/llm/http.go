@@ -0,0 +1,226 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maxEventSize bounds one line of a server-sent event stream
+const maxEventSize = 1 << 20
+
+// post sends a JSON request and returns the response. Error statuses are
+// returned as *APIError with the body already read.
+func post(ctx context.Context, config Config, url string, headers map[string]string, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return send(config, req, headers)
+}
+
+// get sends a GET request and returns the response like post
+func get(ctx context.Context, config Config, url string, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return send(config, req, headers)
+}
+
+// send adds the headers, sends the request and turns error statuses into
+// *APIError
+func send(config Config, req *http.Request, headers map[string]string) (*http.Response, error) {
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	for key, value := range config.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := config.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	return nil, apiError(config.Provider, resp)
+}
+
+// apiError reads the error body both providers send, {"error": {"type",
+// "message"}}, falling back to the body text for other servers
+func apiError(provider Provider, resp *http.Response) *APIError {
+	e := &APIError{
+		Provider:   provider,
+		StatusCode: resp.StatusCode,
+		RetryAfter: retryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	var envelope struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(body, &envelope) == nil && len(envelope.Error) > 0 {
+		var detail struct {
+			Type    string `json:"type"`
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		var text string
+		if json.Unmarshal(envelope.Error, &detail) == nil {
+			e.Type, e.Message = detail.Type, detail.Message
+			if e.Type == "" {
+				e.Type = detail.Code
+			}
+		} else if json.Unmarshal(envelope.Error, &text) == nil {
+			e.Message = text
+		}
+	}
+	if e.Message == "" {
+		e.Message = strings.TrimSpace(string(body))
+		if len(e.Message) > 500 {
+			e.Message = e.Message[:500] + "..."
+		}
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+	return e
+}
+
+// retryAfter reads a Retry-After header given in seconds or as an HTTP date
+func retryAfter(header string, now time.Time) time.Duration {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(header, 64); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if when, err := http.ParseTime(header); err == nil && when.After(now) {
+		return when.Sub(now)
+	}
+	return 0
+}
+
+// readEvents calls fn with the event name and data of each server-sent event
+func readEvents(r io.Reader, fn func(event, data string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxEventSize)
+	var event string
+	var data []string
+	dispatch := func() error {
+		defer func() { event, data = "", nil }()
+		if len(data) == 0 {
+			return nil
+		}
+		return fn(event, strings.Join(data, "\n"))
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if err := dispatch(); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, ":"):
+			// Comment, sent as a keep-alive
+		default:
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "event":
+				event = value
+			case "data":
+				data = append(data, value)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return dispatch()
+}
+
+// errStopEvents ends readEvents early without an error
+var errStopEvents = errors.New("end of stream")
+
+// streamCut explains a stream that ended without its end marker: the
+// connection dropped or a proxy cut the response short, so the text is
+// incomplete
+func streamCut(provider Provider, marker string) error {
+	return fmt.Errorf("%s stream ended without %s: %w", provider, marker, io.ErrUnexpectedEOF)
+}
+
+// watchdog cancels a stream that stays silent for longer than its timeout
+type watchdog struct {
+	timeout time.Duration
+	timer   *time.Timer
+	fired   atomic.Bool
+}
+
+// newWatchdog starts a watchdog that calls cancel after timeout of silence
+func newWatchdog(timeout time.Duration, cancel context.CancelFunc) *watchdog {
+	w := &watchdog{timeout: timeout}
+	w.timer = time.AfterFunc(timeout, func() {
+		w.fired.Store(true)
+		cancel()
+	})
+	return w
+}
+
+// reset restarts the timeout after data arrived
+func (w *watchdog) reset() {
+	w.timer.Reset(w.timeout)
+}
+
+// stop ends the watchdog and reports whether it cancelled the stream
+func (w *watchdog) stop() bool {
+	w.timer.Stop()
+	return w.fired.Load()
+}
+
+// streamError explains why a stream ended early: the caller cancelled it,
+// the watchdog did, or the request failed
+func streamError(provider Provider, err error, w *watchdog, parent context.Context) error {
+	timedOut := w.stop()
+	if parent.Err() != nil {
+		return parent.Err()
+	}
+	if timedOut {
+		return fmt.Errorf("%s stream %w after %s without data", provider, ErrTimeout, w.timeout)
+	}
+	return err
+}
+
+// requestError explains why a complete request failed, telling its own
+// timeout apart from the caller's cancellation
+func requestError(provider Provider, err error, timeout time.Duration, parent context.Context) error {
+	if parent.Err() != nil {
+		return parent.Err()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%s request %w after %s", provider, ErrTimeout, timeout)
+	}
+	return err
+}
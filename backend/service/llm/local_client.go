@@ -0,0 +1,348 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServerKind names the software behind a local endpoint
+type ServerKind string
+
+const (
+	ServerOllama   ServerKind = "ollama"
+	ServerLlamaCpp ServerKind = "llama.cpp"
+	ServerVLLM     ServerKind = "vllm"
+	ServerUnknown  ServerKind = "unknown"
+)
+
+// Context windows assumed when a local server does not report its own
+const (
+	DefaultLocalContextWindow = 4096 // Conservative guess for an unknown server or model
+	ollamaDefaultNumCtx       = 4096 // Ollama's num_ctx unless the model sets one; its OpenAI endpoint cannot raise it
+)
+
+// probeTimeout bounds each capability probe
+const probeTimeout = 10 * time.Second
+
+// Capabilities describes what a local server and its model support
+type Capabilities struct {
+	Server        ServerKind `json:"server"`
+	Models        []string   `json:"models"`        // Models the server offers
+	Model         string     `json:"model"`         // Model requests go to
+	ContextWindow int        `json:"contextWindow"` // 0 when the server does not report it
+	Streaming     bool       `json:"streaming"`
+	JSONMode      bool       `json:"jsonMode"` // Accepts response_format {"type": "json_object"}
+	Detected      time.Time  `json:"detected"`
+}
+
+// LocalClient talks to a self-hosted OpenAI-compatible server. It finds out
+// which server it is and what the model can do before the first request,
+// then keeps requests within the model's context window.
+type LocalClient struct {
+	config Config
+	openai *OpenAIClient
+
+	mu   sync.Mutex
+	caps *Capabilities
+}
+
+// NewLocalClient creates a client for a local server; nothing is sent
+// until Detect or the first request
+func NewLocalClient(config Config) *LocalClient {
+	config.Provider = ProviderLocal
+	return &LocalClient{config: config, openai: NewOpenAIClient(config)}
+}
+
+// Capabilities returns the result of the last detection, or nil before one
+func (c *LocalClient) Capabilities() *Capabilities {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.caps == nil {
+		return nil
+	}
+	caps := *c.caps
+	return &caps
+}
+
+// Detect asks the server which models it serves, how large their context
+// is and whether it streams and answers in JSON mode, replacing what an
+// earlier call found
+func (c *LocalClient) Detect(ctx context.Context) (*Capabilities, error) {
+	caps := &Capabilities{Server: ServerUnknown, Detected: time.Now()}
+
+	models, err := c.listModels(ctx)
+	if err != nil && c.config.Model == "" {
+		return nil, fmt.Errorf("local server at %s did not list its models: %w", c.config.baseURL(), err)
+	}
+	for _, model := range models {
+		caps.Models = append(caps.Models, model.ID)
+	}
+	caps.Model = c.config.Model
+	if caps.Model == "" {
+		if len(models) == 0 {
+			return nil, fmt.Errorf("local server at %s serves no models; set the model name", c.config.baseURL())
+		}
+		caps.Model = models[0].ID
+	}
+	var listed *localModel
+	for i := range models {
+		if models[i].ID == caps.Model {
+			listed = &models[i]
+		}
+	}
+
+	switch {
+	case listed != nil && (listed.MaxModelLen > 0 || listed.OwnedBy == "vllm"):
+		caps.Server, caps.ContextWindow = ServerVLLM, listed.MaxModelLen
+	case c.isOllama(ctx):
+		caps.Server, caps.ContextWindow = ServerOllama, c.ollamaContext(ctx, caps.Model)
+	default:
+		if n, ok := c.llamaCppContext(ctx); ok {
+			caps.Server, caps.ContextWindow = ServerLlamaCpp, n
+		}
+	}
+	if caps.ContextWindow == 0 && listed != nil && listed.Meta.TrainedContext > 0 {
+		caps.ContextWindow = listed.Meta.TrainedContext
+	}
+
+	// The known servers stream; anything else has to show it does. JSON
+	// mode depends on the server version and model, so it is always probed.
+	caps.Streaming = caps.Server != ServerUnknown || c.probeStreaming(ctx, caps.Model)
+	caps.JSONMode = c.probeJSONMode(ctx, caps.Model)
+
+	c.mu.Lock()
+	c.caps = caps
+	c.mu.Unlock()
+	copied := *caps
+	return &copied, nil
+}
+
+// Complete sends the request once the server is known
+func (c *LocalClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	req, err := c.prepare(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return c.openai.Complete(ctx, req)
+}
+
+// Stream streams the request, or sends it whole and delivers the text as a
+// single delta when the server cannot stream
+func (c *LocalClient) Stream(ctx context.Context, req Request, onDelta func(delta string)) (*Response, error) {
+	req, err := c.prepare(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if caps := c.Capabilities(); caps != nil && !caps.Streaming {
+		resp, err := c.openai.Complete(ctx, req)
+		if err == nil && onDelta != nil && resp.Content != "" {
+			onDelta(resp.Content)
+		}
+		return resp, err
+	}
+	return c.openai.Stream(ctx, req, onDelta)
+}
+
+// Model describes the detected model. Before detection the context window
+// is the configured one or a conservative guess, so prompts built early
+// err on the small side. Half the window at most is kept for output.
+func (c *LocalClient) Model() ModelInfo {
+	info := c.config.modelInfo()
+	if caps := c.Capabilities(); caps != nil {
+		info.Name = caps.Model
+		if c.config.ContextWindow == 0 && caps.ContextWindow > 0 {
+			info.ContextWindow = caps.ContextWindow
+		}
+	}
+	if info.ContextWindow == 0 {
+		info.ContextWindow = DefaultLocalContextWindow
+	}
+	if info.MaxOutputTokens > info.ContextWindow/2 {
+		info.MaxOutputTokens = info.ContextWindow / 2
+	}
+	return info
+}
+
+// Usage returns the tokens used by all requests of this client
+func (c *LocalClient) Usage() Usage {
+	return c.openai.Usage()
+}
+
+// prepare detects the server on first use, fills in the model and shrinks
+// the output limit so prompt and answer fit the context window
+func (c *LocalClient) prepare(ctx context.Context, req Request) (Request, error) {
+	if c.Capabilities() == nil {
+		if _, err := c.Detect(ctx); err != nil {
+			return req, err
+		}
+	}
+	info := c.Model()
+	if req.Model == "" {
+		req.Model = info.Name
+	}
+	maxTokens := req.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = info.MaxOutputTokens
+	}
+	input := estimateInput(req)
+	if room := info.ContextWindow - input; room < maxTokens {
+		if room < minLocalOutputTokens {
+			return req, fmt.Errorf("prompt of about %d tokens leaves no room for an answer in the %d-token context of %s", input, info.ContextWindow, info.Name)
+		}
+		maxTokens = room
+	}
+	req.MaxTokens = maxTokens
+	return req, nil
+}
+
+// minLocalOutputTokens is the smallest answer worth asking a local model for
+const minLocalOutputTokens = 256
+
+// localModel is an entry of GET /models. vLLM adds max_model_len and
+// llama.cpp adds meta.n_ctx_train.
+type localModel struct {
+	ID          string `json:"id"`
+	OwnedBy     string `json:"owned_by"`
+	MaxModelLen int    `json:"max_model_len"`
+	Meta        struct {
+		TrainedContext int `json:"n_ctx_train"`
+	} `json:"meta"`
+}
+
+// listModels reads the models of the OpenAI-compatible endpoint
+func (c *LocalClient) listModels(ctx context.Context) ([]localModel, error) {
+	var body struct {
+		Data []localModel `json:"data"`
+	}
+	if err := c.getJSON(ctx, c.config.baseURL()+"/models", &body); err != nil {
+		return nil, err
+	}
+	return body.Data, nil
+}
+
+// isOllama reports whether the server answers Ollama's version endpoint
+func (c *LocalClient) isOllama(ctx context.Context) bool {
+	var body struct {
+		Version string `json:"version"`
+	}
+	return c.getJSON(ctx, c.rootURL()+"/api/version", &body) == nil && body.Version != ""
+}
+
+// numCtx finds num_ctx in the parameters Ollama reports for a model
+var numCtx = regexp.MustCompile(`(?m)^num_ctx\s+(\d+)`)
+
+// ollamaContext returns the context Ollama runs a model with: num_ctx when
+// the model sets it, else Ollama's default, and never more than it was
+// trained for
+func (c *LocalClient) ollamaContext(ctx context.Context, model string) int {
+	probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	resp, err := post(probeCtx, c.config, c.rootURL()+"/api/show", c.openai.headers(), map[string]string{"model": model})
+	if err != nil {
+		return ollamaDefaultNumCtx
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Parameters string                     `json:"parameters"`
+		ModelInfo  map[string]json.RawMessage `json:"model_info"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return ollamaDefaultNumCtx
+	}
+
+	window := ollamaDefaultNumCtx
+	if m := numCtx.FindStringSubmatch(body.Parameters); m != nil {
+		window, _ = strconv.Atoi(m[1])
+	}
+	for key, value := range body.ModelInfo {
+		if !strings.HasSuffix(key, ".context_length") {
+			continue
+		}
+		if trained, err := strconv.Atoi(string(value)); err == nil && trained > 0 && trained < window {
+			window = trained
+		}
+	}
+	return window
+}
+
+// llamaCppContext reads the context size from llama.cpp's /props
+func (c *LocalClient) llamaCppContext(ctx context.Context) (int, bool) {
+	var body struct {
+		Settings struct {
+			NCtx int `json:"n_ctx"`
+		} `json:"default_generation_settings"`
+		NCtx int `json:"n_ctx"`
+	}
+	if err := c.getJSON(ctx, c.rootURL()+"/props", &body); err != nil {
+		return 0, false
+	}
+	if body.Settings.NCtx > 0 {
+		return body.Settings.NCtx, true
+	}
+	return body.NCtx, body.NCtx > 0
+}
+
+// probeStreaming asks for a one-token streamed answer and checks that it
+// comes back as server-sent events
+func (c *LocalClient) probeStreaming(ctx context.Context, model string) bool {
+	probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	req := Prompt("", "Reply with OK.")
+	req.Model, req.MaxTokens = model, 1
+	resp, err := post(probeCtx, c.config, c.config.baseURL()+"/chat/completions", c.openai.headers(), c.openai.body(req, true))
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+}
+
+// probeJSONMode asks for a short answer in JSON mode and checks that the
+// server accepts the response format and answers with JSON
+func (c *LocalClient) probeJSONMode(ctx context.Context, model string) bool {
+	probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	req := Prompt("", `Reply with the JSON object {"ok": true}.`)
+	req.Model, req.MaxTokens = model, 16
+	body := c.openai.body(req, false)
+	body.ResponseFormat = &openAIResponseFormat{Type: "json_object"}
+	resp, err := post(probeCtx, c.config, c.config.baseURL()+"/chat/completions", c.openai.headers(), body)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	var answer openAIResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&answer); err != nil || len(answer.Choices) == 0 {
+		return false
+	}
+	return json.Valid([]byte(strings.TrimSpace(answer.Choices[0].Message.Content)))
+}
+
+// getJSON decodes the response of a GET probe
+func (c *LocalClient) getJSON(ctx context.Context, url string, into interface{}) error {
+	probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	resp, err := get(probeCtx, c.config, url, c.openai.headers())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(into); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", url, err)
+	}
+	return nil
+}
+
+// rootURL is the base URL without the /v1 of the OpenAI-compatible API,
+// where the servers' own endpoints live
+func (c *LocalClient) rootURL() string {
+	return strings.TrimSuffix(c.config.baseURL(), "/v1")
+}
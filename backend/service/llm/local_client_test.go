@@ -0,0 +1,214 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// localServer stands in for a self-hosted server of the given kind. Ollama,
+// llama.cpp and vLLM answer their own endpoints, stream and support JSON
+// mode; the unknown server does neither and only knows the OpenAI routes.
+type localServer struct {
+	*httptest.Server
+	kind ServerKind
+
+	mu       sync.Mutex
+	requests []openAIRequest // Chat requests received, probes included
+}
+
+func newLocalServer(t *testing.T, kind ServerKind) *localServer {
+	t.Helper()
+	s := &localServer{kind: kind}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *localServer) serve(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/v1/models":
+		switch s.kind {
+		case ServerVLLM:
+			fmt.Fprint(w, `{"data":[{"id":"Qwen/Qwen2.5-Coder-7B","owned_by":"vllm","max_model_len":32768}]}`)
+		case ServerLlamaCpp:
+			fmt.Fprint(w, `{"data":[{"id":"coder.gguf","owned_by":"llamacpp","meta":{"n_ctx_train":131072}}]}`)
+		default:
+			fmt.Fprint(w, `{"data":[{"id":"llama3.1:8b"},{"id":"phi3:mini"}]}`)
+		}
+	case r.URL.Path == "/api/version" && s.kind == ServerOllama:
+		fmt.Fprint(w, `{"version":"0.5.7"}`)
+	case r.URL.Path == "/api/show" && s.kind == ServerOllama:
+		fmt.Fprint(w, `{"parameters":"stop \"<|eot_id|>\"\nnum_ctx 8192","model_info":{"llama.context_length":131072}}`)
+	case r.URL.Path == "/props" && s.kind == ServerLlamaCpp:
+		fmt.Fprint(w, `{"default_generation_settings":{"n_ctx":2048}}`)
+	case r.URL.Path == "/v1/chat/completions":
+		var body openAIRequest
+		json.NewDecoder(r.Body).Decode(&body)
+		s.mu.Lock()
+		s.requests = append(s.requests, body)
+		s.mu.Unlock()
+		s.chat(w, body)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *localServer) chat(w http.ResponseWriter, body openAIRequest) {
+	if body.ResponseFormat != nil {
+		if s.kind == ServerUnknown {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":{"type":"invalid_request_error","message":"response_format is not supported"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"{\"ok\": true}"},"finish_reason":"stop"}]}`)
+		return
+	}
+	if body.Stream && s.kind != ServerUnknown {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Sub \"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"A()\"},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		return
+	}
+	// The unknown server ignores stream and answers in one piece
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"id":"x","model":%q,"choices":[{"message":{"role":"assistant","content":"Sub A()"},"finish_reason":"stop"}]}`, body.Model)
+}
+
+// last returns the last chat request received
+func (s *localServer) last() openAIRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[len(s.requests)-1]
+}
+
+// sent returns the number of chat requests received
+func (s *localServer) sent() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.requests)
+}
+
+func TestLocalDetect(t *testing.T) {
+	tests := []struct {
+		kind          ServerKind
+		model         string
+		contextWindow int
+		streaming     bool
+		jsonMode      bool
+	}{
+		// num_ctx of the model, below what it was trained for
+		{ServerOllama, "llama3.1:8b", 8192, true, true},
+		// max_model_len of the listed model
+		{ServerVLLM, "Qwen/Qwen2.5-Coder-7B", 32768, true, true},
+		// n_ctx the server runs with, not n_ctx_train
+		{ServerLlamaCpp, "coder.gguf", 2048, true, true},
+		// Nothing reported; streaming and JSON mode probed and refused
+		{ServerUnknown, "llama3.1:8b", 0, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.kind), func(t *testing.T) {
+			server := newLocalServer(t, tt.kind)
+			client := NewLocalClient(Config{BaseURL: server.URL + "/v1"})
+
+			caps, err := client.Detect(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if caps.Server != tt.kind || caps.Model != tt.model || caps.ContextWindow != tt.contextWindow ||
+				caps.Streaming != tt.streaming || caps.JSONMode != tt.jsonMode {
+				t.Errorf("capabilities = %+v", caps)
+			}
+			if stored := client.Capabilities(); stored.Server != caps.Server || stored.ContextWindow != caps.ContextWindow {
+				t.Errorf("stored capabilities = %+v", stored)
+			}
+		})
+	}
+}
+
+func TestLocalModelContextWindow(t *testing.T) {
+	server := newLocalServer(t, ServerLlamaCpp)
+	client := NewLocalClient(Config{BaseURL: server.URL + "/v1"})
+
+	if info := client.Model(); info.ContextWindow != DefaultLocalContextWindow || info.Provider != ProviderLocal {
+		t.Errorf("model before detection = %+v", info)
+	}
+	if _, err := client.Detect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	info := client.Model()
+	if info.Name != "coder.gguf" || info.ContextWindow != 2048 || info.MaxOutputTokens > 1024 {
+		t.Errorf("model after detection = %+v", info)
+	}
+
+	// A configured context window wins over the detected one
+	configured := NewLocalClient(Config{BaseURL: server.URL + "/v1", ContextWindow: 16384})
+	configured.Detect(context.Background())
+	if info := configured.Model(); info.ContextWindow != 16384 {
+		t.Errorf("configured model = %+v", info)
+	}
+}
+
+func TestLocalStream(t *testing.T) {
+	for _, kind := range []ServerKind{ServerOllama, ServerUnknown} {
+		t.Run(string(kind), func(t *testing.T) {
+			server := newLocalServer(t, kind)
+			client := NewLocalClient(Config{BaseURL: server.URL + "/v1"})
+
+			var deltas []string
+			resp, err := client.Stream(context.Background(), Prompt("", "hello"), func(delta string) {
+				deltas = append(deltas, delta)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Content != "Sub A()" || strings.Join(deltas, "") != "Sub A()" {
+				t.Errorf("deltas = %q, content = %q", deltas, resp.Content)
+			}
+			// A server that cannot stream gets the request whole and the
+			// answer arrives as a single delta
+			if streamed := server.last().Stream; streamed != (kind != ServerUnknown) {
+				t.Errorf("stream = %v", streamed)
+			}
+			if kind == ServerUnknown && len(deltas) != 1 {
+				t.Errorf("deltas = %q, want one", deltas)
+			}
+		})
+	}
+}
+
+func TestLocalPrepare(t *testing.T) {
+	server := newLocalServer(t, ServerLlamaCpp)
+	client := NewLocalClient(Config{BaseURL: server.URL + "/v1", MaxTokens: 4000})
+
+	// The prompt and the requested answer do not fit 2048 tokens together,
+	// so the answer is cut to the room that is left
+	req := Prompt("", strings.Repeat("word ", 1000))
+	if _, err := client.Complete(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	sent := server.last()
+	if sent.Model != "coder.gguf" {
+		t.Errorf("model = %q", sent.Model)
+	}
+	if want := 2048 - estimateInput(req); sent.MaxTokens != want {
+		t.Errorf("max tokens = %d, want %d", sent.MaxTokens, want)
+	}
+
+	// A prompt that leaves less than the smallest useful answer is refused
+	// before it is sent
+	sentBefore := server.sent()
+	_, err := client.Complete(context.Background(), Prompt("", strings.Repeat("word ", 2000)))
+	if err == nil || !strings.Contains(err.Error(), "no room for an answer") {
+		t.Errorf("error = %v", err)
+	}
+	if server.sent() != sentBefore {
+		t.Error("request sent despite no room")
+	}
+}
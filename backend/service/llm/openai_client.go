@@ -0,0 +1,218 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// OpenAIClient talks to the chat completions API of OpenAI and of servers
+// that copy it
+type OpenAIClient struct {
+	config Config
+	usage  usageCounter
+}
+
+// NewOpenAIClient creates a client for an OpenAI-compatible API
+func NewOpenAIClient(config Config) *OpenAIClient {
+	if config.Provider != ProviderLocal {
+		config.Provider = ProviderOpenAI
+	}
+	return &OpenAIClient{config: config}
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIRequest struct {
+	Model          string                `json:"model"`
+	Messages       []openAIMessage       `json:"messages"`
+	MaxTokens      int                   `json:"max_tokens,omitempty"`
+	Temperature    *float64              `json:"temperature,omitempty"`
+	Stop           []string              `json:"stop,omitempty"`
+	Stream         bool                  `json:"stream,omitempty"`
+	StreamOptions  *openAIStreamOptions  `json:"stream_options,omitempty"`
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+}
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIResponseFormat struct {
+	Type string `json:"type"` // "json_object" for JSON mode
+}
+
+type openAIResponse struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Message      openAIMessage `json:"message"`
+		Delta        openAIMessage `json:"delta"`
+		FinishReason string        `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Complete sends the request and waits for the whole response
+func (c *OpenAIClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	requestCtx, cancel := context.WithTimeout(ctx, c.config.timeout())
+	defer cancel()
+	start := time.Now()
+
+	resp, err := post(requestCtx, c.config, c.config.baseURL()+"/chat/completions", c.headers(), c.body(req, false))
+	if err != nil {
+		return nil, requestError(c.config.Provider, err, c.config.timeout(), ctx)
+	}
+	defer resp.Body.Close()
+
+	var body openAIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, requestError(c.config.Provider, fmt.Errorf("failed to decode %s response: %w", c.config.Provider, err), c.config.timeout(), ctx)
+	}
+	if len(body.Choices) == 0 {
+		return nil, fmt.Errorf("%s response has no choices", c.config.Provider)
+	}
+	result := &Response{
+		ID:         body.ID,
+		Model:      body.Model,
+		Content:    body.Choices[0].Message.Content,
+		StopReason: body.Choices[0].FinishReason,
+		Truncated:  body.Choices[0].FinishReason == "length",
+		Usage:      Usage{Requests: 1},
+		Duration:   time.Since(start),
+	}
+	if body.Usage != nil {
+		result.Usage.InputTokens = body.Usage.PromptTokens
+		result.Usage.OutputTokens = body.Usage.CompletionTokens
+	}
+	c.usage.add(result.Usage)
+	return result, nil
+}
+
+// Stream sends the request and calls onDelta with each piece of text
+func (c *OpenAIClient) Stream(ctx context.Context, req Request, onDelta func(delta string)) (*Response, error) {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := newWatchdog(c.config.timeout(), cancel)
+	start := time.Now()
+
+	resp, err := post(streamCtx, c.config, c.config.baseURL()+"/chat/completions", c.headers(), c.body(req, true))
+	if err != nil {
+		return nil, streamError(c.config.Provider, err, w, ctx)
+	}
+	defer resp.Body.Close()
+
+	result := &Response{Usage: Usage{Requests: 1}}
+	var content strings.Builder
+	err = readEvents(resp.Body, func(event, data string) error {
+		w.reset()
+		if strings.TrimSpace(data) == "[DONE]" {
+			return errStopEvents
+		}
+		var chunk openAIResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("failed to decode %s stream chunk: %w", c.config.Provider, err)
+		}
+		if chunk.Error != nil {
+			return &APIError{Provider: c.config.Provider, StatusCode: resp.StatusCode, Type: chunk.Error.Type, Message: chunk.Error.Message}
+		}
+		if chunk.ID != "" {
+			result.ID = chunk.ID
+		}
+		if chunk.Model != "" {
+			result.Model = chunk.Model
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				content.WriteString(choice.Delta.Content)
+				if onDelta != nil {
+					onDelta(choice.Delta.Content)
+				}
+			}
+			if choice.FinishReason != "" {
+				result.StopReason = choice.FinishReason
+			}
+		}
+		if chunk.Usage != nil {
+			result.Usage.InputTokens = chunk.Usage.PromptTokens
+			result.Usage.OutputTokens = chunk.Usage.CompletionTokens
+		}
+		return nil
+	})
+	if err == nil {
+		err = streamCut(c.config.Provider, "[DONE]")
+	}
+	if !errors.Is(err, errStopEvents) {
+		return nil, streamError(c.config.Provider, err, w, ctx)
+	}
+	w.stop()
+
+	result.Content = content.String()
+	result.Truncated = result.StopReason == "length"
+	result.Duration = time.Since(start)
+	if result.Model == "" {
+		result.Model = c.config.model()
+	}
+	c.usage.add(result.Usage)
+	return result, nil
+}
+
+// Model describes the configured model
+func (c *OpenAIClient) Model() ModelInfo {
+	return c.config.modelInfo()
+}
+
+// Usage returns the tokens used by all requests of this client
+func (c *OpenAIClient) Usage() Usage {
+	return c.usage.get()
+}
+
+// headers returns the authentication headers; local servers need none
+func (c *OpenAIClient) headers() map[string]string {
+	headers := map[string]string{}
+	if key := c.config.apiKey(); key != "" {
+		headers["Authorization"] = "Bearer " + key
+	}
+	return headers
+}
+
+// body builds the chat completions request, with the system prompt as the
+// first message
+func (c *OpenAIClient) body(req Request, stream bool) openAIRequest {
+	messages := make([]openAIMessage, 0, len(req.Messages)+1)
+	if req.System != "" {
+		messages = append(messages, openAIMessage{Role: "system", Content: req.System})
+	}
+	for _, message := range req.Messages {
+		messages = append(messages, openAIMessage{Role: string(message.Role), Content: message.Content})
+	}
+	model := req.Model
+	if model == "" {
+		model = c.config.model()
+	}
+	body := openAIRequest{
+		Model:       model,
+		Messages:    messages,
+		MaxTokens:   c.config.maxTokens(req),
+		Temperature: req.Temperature,
+		Stop:        req.StopSequences,
+		Stream:      stream,
+	}
+	if stream {
+		body.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+	}
+	return body
+}
@@ -0,0 +1,168 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openAIServer stands in for the chat completions endpoint
+func openAIServer(t *testing.T, handler func(w http.ResponseWriter, body openAIRequest)) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer test-key" {
+			t.Errorf("Authorization = %q", got)
+		}
+		var body openAIRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("request body: %v", err)
+		}
+		handler(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testOpenAIClient(url string) *OpenAIClient {
+	return NewOpenAIClient(Config{Provider: ProviderOpenAI, BaseURL: url + "/v1", APIKey: "test-key", Model: "gpt-4o-mini", TimeoutSeconds: 1})
+}
+
+// sse writes server-sent events, flushing after each
+func sse(w http.ResponseWriter, events ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, event := range events {
+		fmt.Fprintf(w, "data: %s\n\n", event)
+		w.(http.Flusher).Flush()
+	}
+}
+
+func TestOpenAIComplete(t *testing.T) {
+	server := openAIServer(t, func(w http.ResponseWriter, body openAIRequest) {
+		if body.Stream || body.Model != "gpt-4o-mini" || len(body.Messages) != 2 || body.Messages[0].Role != "system" {
+			t.Errorf("unexpected request %+v", body)
+		}
+		fmt.Fprint(w, `{"id":"c1","model":"gpt-4o-mini-2024","choices":[{"message":{"role":"assistant","content":"Sub A()\nEnd Sub"},"finish_reason":"length"}],"usage":{"prompt_tokens":12,"completion_tokens":5}}`)
+	})
+	client := testOpenAIClient(server.URL)
+
+	resp, err := client.Complete(context.Background(), Prompt("system", "hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != "c1" || resp.Model != "gpt-4o-mini-2024" || resp.Content != "Sub A()\nEnd Sub" || !resp.Truncated {
+		t.Errorf("response = %+v", resp)
+	}
+	if resp.Usage != (Usage{InputTokens: 12, OutputTokens: 5, Requests: 1}) {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+	if client.Usage() != resp.Usage {
+		t.Errorf("client usage = %+v", client.Usage())
+	}
+}
+
+func TestOpenAIStream(t *testing.T) {
+	server := openAIServer(t, func(w http.ResponseWriter, body openAIRequest) {
+		if !body.Stream || body.StreamOptions == nil || !body.StreamOptions.IncludeUsage {
+			t.Errorf("stream options not set: %+v", body)
+		}
+		sse(w,
+			`{"id":"c2","model":"gpt-4o-mini","choices":[{"delta":{"content":"Sub "}}]}`,
+			`{"choices":[{"delta":{"content":"A()"},"finish_reason":"stop"}]}`,
+			`{"choices":[],"usage":{"prompt_tokens":7,"completion_tokens":3}}`,
+			`[DONE]`)
+	})
+
+	var deltas []string
+	resp, err := testOpenAIClient(server.URL).Stream(context.Background(), Prompt("", "hello"), func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(deltas, "|") != "Sub |A()" || resp.Content != "Sub A()" || resp.StopReason != "stop" || resp.Truncated {
+		t.Errorf("deltas = %q, response = %+v", deltas, resp)
+	}
+	if resp.Usage != (Usage{InputTokens: 7, OutputTokens: 3, Requests: 1}) {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+}
+
+func TestOpenAIAPIError(t *testing.T) {
+	server := openAIServer(t, func(w http.ResponseWriter, body openAIRequest) {
+		w.Header().Set("Retry-After", "2")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error":{"type":"rate_limit_exceeded","message":"slow down"}}`)
+	})
+
+	_, err := testOpenAIClient(server.URL).Complete(context.Background(), Prompt("", "hello"))
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("error = %v, want *APIError", err)
+	}
+	if apiErr.StatusCode != 429 || apiErr.Type != "rate_limit_exceeded" || apiErr.Message != "slow down" || apiErr.RetryAfter != 2*time.Second {
+		t.Errorf("API error = %+v", apiErr)
+	}
+}
+
+func TestOpenAIStreamErrorChunk(t *testing.T) {
+	server := openAIServer(t, func(w http.ResponseWriter, body openAIRequest) {
+		sse(w, `{"error":{"type":"server_error","message":"model crashed"}}`)
+	})
+
+	_, err := testOpenAIClient(server.URL).Stream(context.Background(), Prompt("", "hello"), nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Message != "model crashed" {
+		t.Errorf("error = %v, want the API error of the chunk", err)
+	}
+}
+
+func TestOpenAITimeout(t *testing.T) {
+	server := openAIServer(t, func(w http.ResponseWriter, body openAIRequest) {
+		if body.Stream {
+			sse(w, `{"choices":[{"delta":{"content":"Sub"}}]}`)
+		}
+		time.Sleep(1500 * time.Millisecond)
+	})
+	client := testOpenAIClient(server.URL)
+
+	if _, err := client.Complete(context.Background(), Prompt("", "hello")); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Complete error = %v, want ErrTimeout", err)
+	}
+	if _, err := client.Stream(context.Background(), Prompt("", "hello"), nil); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Stream error = %v, want ErrTimeout", err)
+	}
+}
+
+func TestOpenAIStreamEndsEarly(t *testing.T) {
+	server := openAIServer(t, func(w http.ResponseWriter, body openAIRequest) {
+		sse(w, `{"choices":[{"delta":{"content":"Sub A()"}}]}`)
+	})
+
+	resp, err := testOpenAIClient(server.URL).Stream(context.Background(), Prompt("", "hello"), nil)
+	if !errors.Is(err, io.ErrUnexpectedEOF) || resp != nil {
+		t.Errorf("response = %+v, error = %v, want io.ErrUnexpectedEOF", resp, err)
+	}
+}
+
+func TestOpenAICancel(t *testing.T) {
+	server := openAIServer(t, func(w http.ResponseWriter, body openAIRequest) {
+		time.Sleep(500 * time.Millisecond)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	if _, err := testOpenAIClient(server.URL).Stream(ctx, Prompt("", "hello"), nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want context.Canceled", err)
+	}
+}
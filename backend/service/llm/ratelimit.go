@@ -0,0 +1,172 @@
+package llm
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit caps the requests and tokens sent to a provider per minute; 0
+// leaves a dimension unlimited
+type RateLimit struct {
+	RequestsPerMinute int `json:"requestsPerMinute,omitempty"`
+	TokensPerMinute   int `json:"tokensPerMinute,omitempty"`
+}
+
+// bucket is a token bucket that refills at a steady rate up to one minute's
+// worth. It may go negative: a reservation larger than what is left makes
+// the caller wait until the deficit has refilled.
+type bucket struct {
+	perMinute float64
+	level     float64
+	last      time.Time
+}
+
+// refill adds what has accrued since the last call
+func (b *bucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.level = math.Min(b.perMinute, b.level+now.Sub(b.last).Minutes()*b.perMinute)
+	}
+	b.last = now
+}
+
+// take removes n, capped at the bucket size, and returns how long to wait
+// until the bucket is no longer in deficit
+func (b *bucket) take(n float64, now time.Time) time.Duration {
+	if b.perMinute <= 0 {
+		return 0
+	}
+	b.refill(now)
+	b.level -= math.Min(n, b.perMinute)
+	if b.level >= 0 {
+		return 0
+	}
+	return time.Duration(-b.level / b.perMinute * float64(time.Minute))
+}
+
+// give returns n to the bucket
+func (b *bucket) give(n float64, now time.Time) {
+	if b.perMinute <= 0 {
+		return
+	}
+	b.refill(now)
+	b.level = math.Min(b.perMinute, b.level+n)
+}
+
+// RateLimiter spaces out requests to one provider
+type RateLimiter struct {
+	mu       sync.Mutex
+	limit    RateLimit
+	requests bucket
+	tokens   bucket
+}
+
+// NewRateLimiter creates a limiter with full buckets
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	l := &RateLimiter{}
+	l.SetLimit(limit)
+	return l
+}
+
+// SetLimit changes the limits, keeping what is left of the current minute
+func (l *RateLimiter) SetLimit(limit RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limit == l.limit && !l.requests.last.IsZero() {
+		return
+	}
+	l.limit = limit
+	l.requests = bucket{perMinute: float64(limit.RequestsPerMinute), level: float64(limit.RequestsPerMinute)}
+	l.tokens = bucket{perMinute: float64(limit.TokensPerMinute), level: float64(limit.TokensPerMinute)}
+}
+
+// Wait reserves one request and an estimated number of tokens, sleeping
+// until both fit. It returns how long it waited.
+func (l *RateLimiter) Wait(ctx context.Context, tokens int) (time.Duration, error) {
+	l.mu.Lock()
+	now := time.Now()
+	wait := l.requests.take(1, now)
+	if tokenWait := l.tokens.take(float64(tokens), now); tokenWait > wait {
+		wait = tokenWait
+	}
+	l.mu.Unlock()
+	if wait <= 0 {
+		return 0, nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return wait, nil
+	case <-ctx.Done():
+		// The request is not sent, so hand the reservation back
+		l.mu.Lock()
+		now := time.Now()
+		l.requests.give(1, now)
+		l.tokens.give(float64(tokens), now)
+		l.mu.Unlock()
+		return 0, ctx.Err()
+	}
+}
+
+// Adjust corrects a token reservation once the actual usage is known;
+// negative values hand tokens back
+func (l *RateLimiter) Adjust(tokens int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if tokens < 0 {
+		l.tokens.give(float64(-tokens), now)
+	} else if tokens > 0 {
+		l.tokens.take(float64(tokens), now)
+	}
+}
+
+// Limiters keeps one rate limiter per provider endpoint, so limits survive
+// clients being rebuilt after a settings change
+type Limiters struct {
+	mu       sync.Mutex
+	limiters map[string]*RateLimiter
+}
+
+// NewLimiters creates an empty registry
+func NewLimiters() *Limiters {
+	return &Limiters{limiters: map[string]*RateLimiter{}}
+}
+
+// For returns the limiter of the configured provider and base URL, updated
+// to the configured limits
+func (l *Limiters) For(config Config) *RateLimiter {
+	key := string(config.Provider) + " " + config.baseURL()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limiter, ok := l.limiters[key]
+	if !ok {
+		limiter = NewRateLimiter(config.RateLimit)
+		l.limiters[key] = limiter
+	} else {
+		limiter.SetLimit(config.RateLimit)
+	}
+	return limiter
+}
+
+// EstimateTokens roughly counts the tokens of a text, at four characters
+// per token
+func EstimateTokens(text string) int {
+	n := 0
+	for range text {
+		n++
+	}
+	return (n + 3) / 4
+}
+
+// estimateInput roughly counts the input tokens of a request
+func estimateInput(req Request) int {
+	tokens := EstimateTokens(req.System)
+	for _, message := range req.Messages {
+		tokens += EstimateTokens(message.Content) + 4
+	}
+	return tokens
+}
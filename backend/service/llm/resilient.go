@@ -0,0 +1,368 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Errors worth telling apart: a request that got no answer in time, and one
+// refused because the provider failed too often
+var (
+	ErrTimeout     = errors.New("timed out")
+	ErrCircuitOpen = errors.New("circuit breaker open")
+)
+
+// Defaults of ResilienceOptions
+const (
+	DefaultMaxRetries       = 3
+	DefaultBaseDelay        = 500 * time.Millisecond
+	DefaultMaxDelay         = 30 * time.Second
+	DefaultMaxRetryAfter    = 2 * time.Minute
+	DefaultFailureThreshold = 5
+	DefaultCooldown         = 30 * time.Second
+)
+
+// ResilienceOptions controls retries and the circuit breaker
+type ResilienceOptions struct {
+	MaxRetries       int           // Retries after the first attempt; negative disables retries
+	BaseDelay        time.Duration // Backoff before the first retry, doubled on each retry
+	MaxDelay         time.Duration // Longest backoff
+	MaxRetryAfter    time.Duration // A longer Retry-After fails at once instead of waiting
+	FailureThreshold int           // Consecutive failures that open the circuit
+	Cooldown         time.Duration // How long the circuit stays open before a trial request
+	Limiter          *RateLimiter  // Optional rate limiter shared by clients of one provider
+}
+
+// withDefaults fills in unset options
+func (o ResilienceOptions) withDefaults() ResilienceOptions {
+	if o.MaxRetries == 0 {
+		o.MaxRetries = DefaultMaxRetries
+	} else if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	}
+	if o.BaseDelay <= 0 {
+		o.BaseDelay = DefaultBaseDelay
+	}
+	if o.MaxDelay <= 0 {
+		o.MaxDelay = DefaultMaxDelay
+	}
+	if o.MaxRetryAfter <= 0 {
+		o.MaxRetryAfter = DefaultMaxRetryAfter
+	}
+	if o.FailureThreshold <= 0 {
+		o.FailureThreshold = DefaultFailureThreshold
+	}
+	if o.Cooldown <= 0 {
+		o.Cooldown = DefaultCooldown
+	}
+	return o
+}
+
+// ResilienceStats counts what the resilient client did
+type ResilienceStats struct {
+	Calls            int           `json:"calls"`    // Complete and Stream calls
+	Attempts         int           `json:"attempts"` // Requests sent to the provider
+	Retries          int           `json:"retries"`
+	Failures         int           `json:"failures"`  // Calls that failed after all retries
+	Rejected         int           `json:"rejected"`  // Calls refused by the open circuit
+	Throttled        time.Duration `json:"throttled"` // Time spent waiting for the rate limiter
+	BreakerState     BreakerState  `json:"breakerState"`
+	BreakerOpenUntil time.Time     `json:"breakerOpenUntil"`
+}
+
+// ResilientClient wraps a client with rate limiting, retries with jittered
+// exponential backoff and a circuit breaker
+type ResilientClient struct {
+	inner   Client
+	opts    ResilienceOptions
+	breaker *circuitBreaker
+	sleep   func(ctx context.Context, d time.Duration) error
+
+	mu    sync.Mutex
+	stats ResilienceStats
+}
+
+// NewResilientClient wraps a client
+func NewResilientClient(inner Client, opts ResilienceOptions) *ResilientClient {
+	opts = opts.withDefaults()
+	return &ResilientClient{
+		inner:   inner,
+		opts:    opts,
+		breaker: &circuitBreaker{threshold: opts.FailureThreshold, cooldown: opts.Cooldown, state: BreakerClosed},
+		sleep:   sleepContext,
+	}
+}
+
+// Complete sends the request, retrying failures that may pass on a retry
+func (c *ResilientClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	return c.do(ctx, req, func(ctx context.Context) (*Response, error) {
+		return c.inner.Complete(ctx, req)
+	}, func() bool { return false })
+}
+
+// Stream sends the request and retries it only while no text has been
+// delivered, so onDelta never sees the same text twice
+func (c *ResilientClient) Stream(ctx context.Context, req Request, onDelta func(delta string)) (*Response, error) {
+	delivered := false
+	return c.do(ctx, req, func(ctx context.Context) (*Response, error) {
+		return c.inner.Stream(ctx, req, func(delta string) {
+			delivered = true
+			if onDelta != nil {
+				onDelta(delta)
+			}
+		})
+	}, func() bool { return delivered })
+}
+
+// Model describes the wrapped client's model
+func (c *ResilientClient) Model() ModelInfo {
+	return c.inner.Model()
+}
+
+// Usage returns the wrapped client's usage
+func (c *ResilientClient) Usage() Usage {
+	return c.inner.Usage()
+}
+
+// Stats returns the retry, throttling and breaker counters
+func (c *ResilientClient) Stats() ResilienceStats {
+	c.mu.Lock()
+	stats := c.stats
+	c.mu.Unlock()
+	stats.BreakerState, stats.BreakerOpenUntil = c.breaker.status(time.Now())
+	return stats
+}
+
+// do runs one call through the limiter, breaker and retry loop
+func (c *ResilientClient) do(ctx context.Context, req Request, call func(context.Context) (*Response, error), delivered func() bool) (*Response, error) {
+	c.count(func(s *ResilienceStats) { s.Calls++ })
+	provider := c.inner.Model().Provider
+	estimate := estimateInput(req) + c.inner.Model().MaxOutputTokens
+	if req.MaxTokens > 0 {
+		estimate = estimateInput(req) + req.MaxTokens
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := c.breaker.allow(time.Now()); err != nil {
+			c.count(func(s *ResilienceStats) { s.Rejected++ })
+			return nil, fmt.Errorf("%s: %w", provider, err)
+		}
+		if c.opts.Limiter != nil {
+			waited, err := c.opts.Limiter.Wait(ctx, estimate)
+			c.count(func(s *ResilienceStats) { s.Throttled += waited })
+			if err != nil {
+				c.breaker.release()
+				return nil, err
+			}
+		}
+
+		c.count(func(s *ResilienceStats) { s.Attempts++ })
+		resp, err := call(ctx)
+		if c.opts.Limiter != nil {
+			if err == nil && resp.Usage.Total() > 0 {
+				c.opts.Limiter.Adjust(resp.Usage.Total() - estimate)
+			} else if err != nil {
+				c.opts.Limiter.Adjust(-estimate)
+			}
+		}
+		if err == nil {
+			c.breaker.success()
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			c.breaker.release()
+			return nil, ctx.Err()
+		}
+
+		// A refused request says nothing about the provider's health: it
+		// neither closes an open circuit nor resets the failure count
+		if isProviderFailure(err) {
+			c.breaker.failure(time.Now())
+		} else {
+			c.breaker.release()
+		}
+		delay, retry := c.retryDelay(err, attempt)
+		if !retry || attempt >= c.opts.MaxRetries || delivered() {
+			c.count(func(s *ResilienceStats) { s.Failures++ })
+			if attempt > 0 {
+				return nil, fmt.Errorf("failed after %d attempts: %w", attempt+1, err)
+			}
+			return nil, err
+		}
+		c.count(func(s *ResilienceStats) { s.Retries++ })
+		if err := c.sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryDelay decides whether an error is worth retrying and how long to
+// wait first: the server's Retry-After when given, otherwise exponential
+// backoff with jitter
+func (c *ResilientClient) retryDelay(err error, attempt int) (time.Duration, bool) {
+	if !isRetryable(err) {
+		return 0, false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		if apiErr.RetryAfter > c.opts.MaxRetryAfter {
+			return 0, false
+		}
+		return apiErr.RetryAfter, true
+	}
+	return backoff(c.opts.BaseDelay, c.opts.MaxDelay, attempt), true
+}
+
+// count updates the counters under the lock
+func (c *ResilientClient) count(update func(s *ResilienceStats)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	update(&c.stats)
+}
+
+// backoff doubles the delay on each attempt up to max, then picks a random
+// point in its upper half so clients that failed together retry apart
+func backoff(base, max time.Duration, attempt int) time.Duration {
+	d := base
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// retryableStatus lists the statuses that may succeed on a retry; 529 is
+// Anthropic's "overloaded"
+var retryableStatus = map[int]bool{
+	http.StatusRequestTimeout:      true,
+	http.StatusConflict:            true,
+	http.StatusTooEarly:            true,
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+	529:                            true,
+}
+
+// isRetryable reports whether an error may pass on a retry: throttling,
+// server errors, timeouts and dropped connections
+func isRetryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return retryableStatus[apiErr.StatusCode]
+	}
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, ErrTimeout) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) || errors.As(err, &netErr)
+}
+
+// isProviderFailure reports whether an error counts against the circuit
+// breaker: the provider is down or unreachable, not merely refusing a request
+func isProviderFailure(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500
+	}
+	return isRetryable(err)
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// BreakerState is the state of a circuit breaker
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"    // Requests flow
+	BreakerOpen     BreakerState = "open"      // Requests fail at once
+	BreakerHalfOpen BreakerState = "half-open" // One trial request decides
+)
+
+// circuitBreaker stops calls to a provider after repeated failures, then
+// lets one trial request through after a cooldown
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	trial     bool
+}
+
+// allow reports whether a request may go out
+func (b *circuitBreaker) allow(now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if wait := b.openedAt.Add(b.cooldown).Sub(now); wait > 0 {
+			return fmt.Errorf("%w after %d failures in a row; next try in %s", ErrCircuitOpen, b.failures, wait.Round(time.Second))
+		}
+		b.state, b.trial = BreakerHalfOpen, true
+	case BreakerHalfOpen:
+		if b.trial {
+			return fmt.Errorf("%w while a trial request is running", ErrCircuitOpen)
+		}
+		b.trial = true
+	}
+	return nil
+}
+
+// success closes the circuit
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state, b.failures, b.trial = BreakerClosed, 0, false
+}
+
+// failure counts a failure and opens the circuit at the threshold or when
+// the trial request failed
+func (b *circuitBreaker) failure(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state, b.openedAt, b.trial = BreakerOpen, now, false
+	}
+}
+
+// release ends a trial request that was cancelled or refused, leaving the
+// state and failure count as they are
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// status returns the state and, when open, until when
+func (b *circuitBreaker) status(now time.Time) (BreakerState, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen {
+		return b.state, b.openedAt.Add(b.cooldown)
+	}
+	return b.state, time.Time{}
+}
@@ -30,6 +30,7 @@ type AdvancedPromptConfig struct {
 	// Module options
 	IncludeModules      []string          // Standard modules to include
 	CustomModules       []CustomModule    // User-provided custom modules
+	ExistingModules     []ExistingModule  // Modules in the workbook the generated code must integrate with
 	
 	// Advanced features
 	EnableChainOfThought bool              // Enable step-by-step reasoning
@@ -124,13 +125,18 @@ func GenerateAdvancedPrompt(structure DataRange, userRequirement string, config
 		"Config":            config,
 		"TaskClassification": classifyUserRequirement(userRequirement, structure),
 		"HeadersFormatted":   formatHeadersAdvanced(structure.Headers, structure.DataTypes, config.HighlightColumns),
+		"HeaderGroupsFormatted": formatHeaderGroups(structure),
+		"CalculatedColumnsFormatted": formatCalculatedColumns(structure),
+		"TablesFormatted": formatTableContext(structure),
 		"SampleData":         limitSampleData(structure.SampleData, config.MaxSampleRows),
 		"RelationshipInfo":   getRelationshipDescription(structure.Relationships),
 		"ModulesInfo":        getModulesDescription(config.IncludeModules),
-		"Examples":           selectExamplesForTask(config.TaskType, config.FewShotExamples),
+		"Examples":           selectExamplesForTask(config.TaskType, config.FewShotExamples, config.TargetExcelVersion),
 		"ChainOfThought":     getChainOfThoughtPrompt(config.TaskType),
 		"ErrorScenarios":     getCommonErrorScenarios(config.TaskType),
 		"OptimizationTips":   getOptimizationTips(config.OptimizationLevel),
+		"VersionConstraints": formatVersionConstraints(config.TargetExcelVersion),
+		"ExistingCode":       formatExistingModules(config.ExistingModules, 0),
 	}
 
 	// Add custom template variables
@@ -157,6 +163,12 @@ func GenerateAdvancedPrompt(structure DataRange, userRequirement string, config
 	return prompt.String()
 }
 
+// DetectTaskType returns the task type detected for a requirement, for
+// callers that fill in AdvancedPromptConfig themselves
+func DetectTaskType(structure DataRange, userRequirement string) string {
+	return classifyUserRequirement(userRequirement, structure).PrimaryType
+}
+
 // classifyUserRequirement analyzes a user requirement to determine its type and complexity
 func classifyUserRequirement(requirement string, structure DataRange) TaskClassification {
 	classification := TaskClassification{
@@ -473,8 +485,9 @@ func getModulesDescription(modules []string) string {
 	return result.String()
 }
 
-// selectExamplesForTask returns appropriate examples for the given task type
-func selectExamplesForTask(taskType string, numExamples int) string {
+// selectExamplesForTask returns appropriate examples for the given task type,
+// skipping examples that are incompatible with the target Excel version
+func selectExamplesForTask(taskType string, numExamples int, targetVersion string) string {
 	if numExamples <= 0 {
 		return ""
 	}
@@ -507,6 +520,9 @@ func selectExamplesForTask(taskType string, numExamples int) string {
 		examples = append(examples, errorHandlingExample)
 	}
 	
+	// Drop examples that would teach features the target version lacks
+	examples = filterCompatibleExamples(examples, targetVersion)
+	
 	// Limit to requested number
 	if len(examples) > numExamples {
 		examples = examples[:numExamples]
@@ -696,6 +712,15 @@ func fallbackAdvancedPrompt(structure DataRange, userRequirement string, err err
 	prompt.WriteString(fmt.Sprintf("- Sheet: %s\n", structure.SheetName))
 	prompt.WriteString(fmt.Sprintf("- Range: %s\n", structure.RangeAddress))
 	prompt.WriteString(fmt.Sprintf("- Headers: %s\n", strings.Join(structure.Headers, ", ")))
+	if structure.HeaderRows > 1 {
+		prompt.WriteString(fmt.Sprintf("- Header Rows: %d (names join the levels with %q)\n", structure.HeaderRows, HeaderSeparator))
+	}
+	for _, column := range structure.CalculatedColumns {
+		prompt.WriteString(fmt.Sprintf("- Calculated Column: %s (column %s) = %s; keep the formula, do not overwrite it with values\n", column.Header, column.Column, column.Formula))
+	}
+	if structure.Table != nil {
+		prompt.WriteString(fmt.Sprintf("- Excel Table: %s; use ListObjects(%q) and its ListColumns, not fixed addresses\n", structure.Table.Name, structure.Table.Name))
+	}
 	prompt.WriteString(fmt.Sprintf("- Data Rows: %d\n\n", structure.DataRows))
 	
 	// Headers detail
@@ -761,7 +786,18 @@ const advancedGenericTemplate = `# TASK: Generate Excel VBA script based on user
 
 ## HEADERS
 {{.HeadersFormatted}}
-
+{{if .HeaderGroupsFormatted}}
+## GROUPED COLUMNS
+{{.HeaderGroupsFormatted}}
+{{end}}
+{{if .CalculatedColumnsFormatted}}
+## CALCULATED COLUMNS
+{{.CalculatedColumnsFormatted}}
+{{end}}
+{{if .TablesFormatted}}
+## EXCEL TABLES AND NAMED RANGES
+{{.TablesFormatted}}
+{{end}}
 ## SAMPLE DATA
 {{range $index, $row := .SampleData}}Row {{add $index 1}}: {{join $row ", "}}
 {{end}}
@@ -791,6 +827,14 @@ Consider handling these common error cases:
 ## OPTIMIZATION TIPS
 {{.OptimizationTips}}
 
+{{if .ExistingCode}}
+## EXISTING VBA CODE
+{{.ExistingCode}}
+{{end}}
+
+## EXCEL VERSION CONSTRAINTS
+{{.VersionConstraints}}
+
 ## USER REQUIREMENT
 {{.UserRequirement}}
 
@@ -827,7 +871,18 @@ const advancedReportingTemplate = `# TASK: Generate Excel VBA reporting script
 
 ## COLUMNS FOR REPORTING
 {{.HeadersFormatted}}
-
+{{if .HeaderGroupsFormatted}}
+## GROUPED COLUMNS
+{{.HeaderGroupsFormatted}}
+{{end}}
+{{if .CalculatedColumnsFormatted}}
+## CALCULATED COLUMNS
+{{.CalculatedColumnsFormatted}}
+{{end}}
+{{if .TablesFormatted}}
+## EXCEL TABLES AND NAMED RANGES
+{{.TablesFormatted}}
+{{end}}
 ## SAMPLE DATA
 {{range $index, $row := .SampleData}}Row {{add $index 1}}: {{join $row ", "}}
 {{end}}
@@ -857,6 +912,14 @@ Consider handling these common reporting error scenarios:
 ## REPORT OPTIMIZATION TIPS
 {{.OptimizationTips}}
 
+{{if .ExistingCode}}
+## EXISTING VBA CODE
+{{.ExistingCode}}
+{{end}}
+
+## EXCEL VERSION CONSTRAINTS
+{{.VersionConstraints}}
+
 ## REPORTING REQUIREMENTS
 {{.UserRequirement}}
 
@@ -894,7 +957,18 @@ const advancedDataProcessingTemplate = `# TASK: Generate Excel VBA data processi
 
 ## DATA COLUMNS
 {{.HeadersFormatted}}
-
+{{if .HeaderGroupsFormatted}}
+## GROUPED COLUMNS
+{{.HeaderGroupsFormatted}}
+{{end}}
+{{if .CalculatedColumnsFormatted}}
+## CALCULATED COLUMNS
+{{.CalculatedColumnsFormatted}}
+{{end}}
+{{if .TablesFormatted}}
+## EXCEL TABLES AND NAMED RANGES
+{{.TablesFormatted}}
+{{end}}
 ## SAMPLE DATA
 {{range $index, $row := .SampleData}}Row {{add $index 1}}: {{join $row ", "}}
 {{end}}
@@ -924,6 +998,14 @@ Consider handling these common data processing error cases:
 ## DATA PROCESSING OPTIMIZATION TIPS
 {{.OptimizationTips}}
 
+{{if .ExistingCode}}
+## EXISTING VBA CODE
+{{.ExistingCode}}
+{{end}}
+
+## EXCEL VERSION CONSTRAINTS
+{{.VersionConstraints}}
+
 ## DATA PROCESSING REQUIREMENTS
 {{.UserRequirement}}
 
@@ -961,7 +1043,18 @@ const advancedUserInterfaceTemplate = `# TASK: Generate Excel VBA user interface
 
 ## DATA FIELDS
 {{.HeadersFormatted}}
-
+{{if .HeaderGroupsFormatted}}
+## GROUPED COLUMNS
+{{.HeaderGroupsFormatted}}
+{{end}}
+{{if .CalculatedColumnsFormatted}}
+## CALCULATED COLUMNS
+{{.CalculatedColumnsFormatted}}
+{{end}}
+{{if .TablesFormatted}}
+## EXCEL TABLES AND NAMED RANGES
+{{.TablesFormatted}}
+{{end}}
 ## SAMPLE DATA
 {{range $index, $row := .SampleData}}Row {{add $index 1}}: {{join $row ", "}}
 {{end}}
@@ -991,6 +1084,14 @@ Consider handling these common UI error cases:
 ## UI OPTIMIZATION TIPS
 {{.OptimizationTips}}
 
+{{if .ExistingCode}}
+## EXISTING VBA CODE
+{{.ExistingCode}}
+{{end}}
+
+## EXCEL VERSION CONSTRAINTS
+{{.VersionConstraints}}
+
 ## UI REQUIREMENTS
 {{.UserRequirement}}
 
@@ -1028,7 +1129,18 @@ const advancedAutomationTemplate = `# TASK: Generate Excel VBA automation script
 
 ## DATA FIELDS
 {{.HeadersFormatted}}
-
+{{if .HeaderGroupsFormatted}}
+## GROUPED COLUMNS
+{{.HeaderGroupsFormatted}}
+{{end}}
+{{if .CalculatedColumnsFormatted}}
+## CALCULATED COLUMNS
+{{.CalculatedColumnsFormatted}}
+{{end}}
+{{if .TablesFormatted}}
+## EXCEL TABLES AND NAMED RANGES
+{{.TablesFormatted}}
+{{end}}
 ## SAMPLE DATA
 {{range $index, $row := .SampleData}}Row {{add $index 1}}: {{join $row ", "}}
 {{end}}
@@ -1058,6 +1170,14 @@ Consider handling these common automation error cases:
 ## AUTOMATION OPTIMIZATION TIPS
 {{.OptimizationTips}}
 
+{{if .ExistingCode}}
+## EXISTING VBA CODE
+{{.ExistingCode}}
+{{end}}
+
+## EXCEL VERSION CONSTRAINTS
+{{.VersionConstraints}}
+
 ## AUTOMATION REQUIREMENTS
 {{.UserRequirement}}
 
@@ -1095,7 +1215,18 @@ const advancedDataValidationTemplate = `# TASK: Generate Excel VBA data validati
 
 ## DATA FIELDS
 {{.HeadersFormatted}}
-
+{{if .HeaderGroupsFormatted}}
+## GROUPED COLUMNS
+{{.HeaderGroupsFormatted}}
+{{end}}
+{{if .CalculatedColumnsFormatted}}
+## CALCULATED COLUMNS
+{{.CalculatedColumnsFormatted}}
+{{end}}
+{{if .TablesFormatted}}
+## EXCEL TABLES AND NAMED RANGES
+{{.TablesFormatted}}
+{{end}}
 ## SAMPLE DATA
 {{range $index, $row := .SampleData}}Row {{add $index 1}}: {{join $row ", "}}
 {{end}}
@@ -1125,6 +1256,14 @@ Consider handling these common validation error cases:
 ## VALIDATION OPTIMIZATION TIPS
 {{.OptimizationTips}}
 
+{{if .ExistingCode}}
+## EXISTING VBA CODE
+{{.ExistingCode}}
+{{end}}
+
+## EXCEL VERSION CONSTRAINTS
+{{.VersionConstraints}}
+
 ## VALIDATION REQUIREMENTS
 {{.UserRequirement}}
 
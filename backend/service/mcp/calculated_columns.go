@@ -0,0 +1,61 @@
+package mcp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// formatCalculatedColumns describes the formula columns of a range and what
+// each one reads, or returns "" when the range has none
+func formatCalculatedColumns(structure DataRange) string {
+	if len(structure.CalculatedColumns) == 0 {
+		return ""
+	}
+
+	letters := HeaderColumns(structure)
+	names := map[string]string{}
+	for i, header := range structure.Headers {
+		if i < len(letters) {
+			names[letters[i]] = header
+		}
+	}
+	calculated := map[string]bool{}
+	for _, column := range structure.CalculatedColumns {
+		calculated[column.Column] = true
+	}
+
+	var result strings.Builder
+	result.WriteString("These columns hold formulas; their sample values are calculated results, not data.\n")
+	result.WriteString("Never overwrite them with static values. Leave them alone, fill the formula down to new rows (Range.FormulaR1C1), ")
+	result.WriteString("or recompute them in code only when the requirement asks for values, in dependency order.\n")
+	for _, column := range structure.CalculatedColumns {
+		line := fmt.Sprintf("- %s (column %s) = %s", column.Header, column.Column, column.Formula)
+		if column.FormulaR1C1 != "" {
+			line += fmt.Sprintf(" [R1C1: %s]", column.FormulaR1C1)
+		}
+		if column.Consistent {
+			line += fmt.Sprintf(", the same formula in all %d data rows", column.Rows)
+		} else {
+			line += fmt.Sprintf(", in %d data rows; other rows hold different formulas or typed values, so do not refill the column blindly", column.Rows)
+		}
+		if len(column.DependsOn) > 0 {
+			var reads []string
+			for _, letter := range column.DependsOn {
+				read := letter
+				if name := names[letter]; name != "" {
+					read = fmt.Sprintf("%s (%s)", name, letter)
+				}
+				if calculated[letter] {
+					read += ", itself calculated"
+				}
+				reads = append(reads, read)
+			}
+			line += "; depends on " + strings.Join(reads, "; ")
+		}
+		if len(column.External) > 0 {
+			line += "; also reads " + strings.Join(column.External, ", ")
+		}
+		result.WriteString(line + "\n")
+	}
+	return result.String()
+}
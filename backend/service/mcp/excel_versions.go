@@ -0,0 +1,345 @@
+package mcp
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VersionRank orders Excel releases from oldest to newest
+type VersionRank int
+
+const (
+	Excel2010 VersionRank = iota + 1
+	Excel2013
+	Excel2016
+	Excel2019
+	Excel2021
+	Excel365
+)
+
+// Platform identifies the operating system Excel runs on
+type Platform string
+
+const (
+	PlatformWindows Platform = "Windows"
+	PlatformMac     Platform = "Mac"
+)
+
+// FeatureKind groups version-dependent features for prompt output
+type FeatureKind string
+
+const (
+	FeatureWorksheetFunction FeatureKind = "WorksheetFunction"
+	FeatureObjectModel       FeatureKind = "ObjectModel"
+	FeatureLanguage          FeatureKind = "Language"
+	FeatureLibrary           FeatureKind = "Library"
+)
+
+// ExcelVersion describes a known Excel release
+type ExcelVersion struct {
+	ID            string      // Short identifier, e.g. "2016", "365", "Mac"
+	Name          string      // Display name
+	Rank          VersionRank // Feature level of the release
+	Platform      Platform    // Windows or Mac
+	VBAVersion    string      // VBA runtime shipped with the release
+	DynamicArrays bool        // Whether formulas spill natively
+}
+
+// ExcelFeature describes a worksheet function, object model member or
+// language construct whose availability depends on the Excel version
+type ExcelFeature struct {
+	Name        string         // Feature name as shown in the prompt
+	Kind        FeatureKind    // Feature category
+	MinRank     VersionRank    // First release that supports the feature
+	WindowsOnly bool           // Not available in Excel for Mac
+	Pattern     *regexp.Regexp // Detects usage of the feature in VBA code
+	Alternative string         // What to use instead when unavailable
+}
+
+// VersionProfile is the resolved compatibility target for a prompt
+type VersionProfile struct {
+	Target        string      // Original target string from the configuration
+	Name          string      // Resolved display name
+	Rank          VersionRank // Lowest release that must be supported
+	Platform      Platform    // Target platform
+	DynamicArrays bool        // Whether dynamic array formulas are safe to use
+}
+
+// VersionConstraints lists explicit prompt rules derived from a VersionProfile
+type VersionConstraints struct {
+	DoNotUse []string
+	MustUse  []string
+}
+
+// knownExcelVersions is the version knowledge base
+var knownExcelVersions = []ExcelVersion{
+	{ID: "2010", Name: "Excel 2010", Rank: Excel2010, Platform: PlatformWindows, VBAVersion: "VBA 7.0"},
+	{ID: "2013", Name: "Excel 2013", Rank: Excel2013, Platform: PlatformWindows, VBAVersion: "VBA 7.1"},
+	{ID: "2016", Name: "Excel 2016", Rank: Excel2016, Platform: PlatformWindows, VBAVersion: "VBA 7.1"},
+	{ID: "2019", Name: "Excel 2019", Rank: Excel2019, Platform: PlatformWindows, VBAVersion: "VBA 7.1"},
+	{ID: "2021", Name: "Excel 2021", Rank: Excel2021, Platform: PlatformWindows, VBAVersion: "VBA 7.1", DynamicArrays: true},
+	{ID: "365", Name: "Microsoft 365", Rank: Excel365, Platform: PlatformWindows, VBAVersion: "VBA 7.1", DynamicArrays: true},
+	{ID: "Mac", Name: "Excel for Mac 2016+", Rank: Excel2016, Platform: PlatformMac, VBAVersion: "VBA 7.1"},
+}
+
+// excelFeatures lists every version-dependent feature the prompts know about
+var excelFeatures = []ExcelFeature{
+	// Dynamic array worksheet functions
+	{Name: "XLOOKUP", Kind: FeatureWorksheetFunction, MinRank: Excel2021,
+		Pattern: worksheetFunctionPattern("XLOOKUP", "XLookup"), Alternative: "INDEX/MATCH or WorksheetFunction.Match"},
+	{Name: "XMATCH", Kind: FeatureWorksheetFunction, MinRank: Excel2021,
+		Pattern: worksheetFunctionPattern("XMATCH", "XMatch"), Alternative: "MATCH or WorksheetFunction.Match"},
+	{Name: "FILTER", Kind: FeatureWorksheetFunction, MinRank: Excel2021,
+		Pattern: worksheetFunctionPattern("FILTER", "Filter"), Alternative: "AutoFilter or a VBA loop over an array"},
+	{Name: "SORT / SORTBY", Kind: FeatureWorksheetFunction, MinRank: Excel2021,
+		Pattern: worksheetFunctionPattern("SORT|SORTBY", "Sort|SortBy"), Alternative: "Range.Sort"},
+	{Name: "UNIQUE", Kind: FeatureWorksheetFunction, MinRank: Excel2021,
+		Pattern: worksheetFunctionPattern("UNIQUE", "Unique"), Alternative: "a Collection or RemoveDuplicates"},
+	{Name: "SEQUENCE / RANDARRAY", Kind: FeatureWorksheetFunction, MinRank: Excel2021,
+		Pattern: worksheetFunctionPattern("SEQUENCE|RANDARRAY", "Sequence|RandArray"), Alternative: "a VBA loop filling an array"},
+	{Name: "LET", Kind: FeatureWorksheetFunction, MinRank: Excel2021,
+		Pattern: worksheetFunctionPattern("LET", "Let"), Alternative: "helper cells or VBA variables"},
+	{Name: "LAMBDA", Kind: FeatureWorksheetFunction, MinRank: Excel365,
+		Pattern: worksheetFunctionPattern("LAMBDA", "Lambda"), Alternative: "a VBA Function"},
+	{Name: "TEXTSPLIT / TEXTBEFORE / TEXTAFTER", Kind: FeatureWorksheetFunction, MinRank: Excel365,
+		Pattern: worksheetFunctionPattern("TEXTSPLIT|TEXTBEFORE|TEXTAFTER", "TextSplit|TextBefore|TextAfter"), Alternative: "VBA Split, InStr and Mid"},
+	{Name: "TEXTJOIN / CONCAT", Kind: FeatureWorksheetFunction, MinRank: Excel2019,
+		Pattern: worksheetFunctionPattern("TEXTJOIN|CONCAT", "TextJoin|Concat"), Alternative: "VBA Join or the & operator"},
+	{Name: "IFS / SWITCH / MAXIFS / MINIFS", Kind: FeatureWorksheetFunction, MinRank: Excel2019,
+		Pattern: worksheetFunctionPattern("IFS|SWITCH|MAXIFS|MINIFS", "Ifs|Switch|MaxIfs|MinIfs"), Alternative: "nested IF, MAX with criteria loops or Select Case"},
+
+	// Object model members
+	{Name: "Range.Formula2", Kind: FeatureObjectModel, MinRank: Excel2021,
+		Pattern: featurePattern(`\.Formula2(R1C1)?\b`), Alternative: "Range.Formula or Range.FormulaArray"},
+	{Name: "Range.SpillingToRange / HasSpill", Kind: FeatureObjectModel, MinRank: Excel2021,
+		Pattern: featurePattern(`\.(SpillingToRange|HasSpill|SpillParent)\b`), Alternative: "explicitly sized output ranges"},
+	{Name: "Workbook.Queries (Power Query)", Kind: FeatureObjectModel, MinRank: Excel2016, WindowsOnly: true,
+		Pattern: featurePattern(`\.Queries\b`), Alternative: "QueryTables or direct range processing"},
+	{Name: "Application.FileDialog", Kind: FeatureObjectModel, MinRank: Excel2010, WindowsOnly: true,
+		Pattern: featurePattern(`\.FileDialog\b|msoFileDialog`), Alternative: "Application.GetOpenFilename"},
+	{Name: "ActiveX controls", Kind: FeatureObjectModel, MinRank: Excel2010, WindowsOnly: true,
+		Pattern: featurePattern(`OLEObjects|Forms\.CommandButton\.1`), Alternative: "Form controls or shapes with OnAction"},
+
+	// External libraries
+	{Name: "Scripting.Dictionary", Kind: FeatureLibrary, MinRank: Excel2010, WindowsOnly: true,
+		Pattern: featurePattern(`Scripting\.Dictionary`), Alternative: "a Collection keyed by string"},
+	{Name: "Scripting.FileSystemObject", Kind: FeatureLibrary, MinRank: Excel2010, WindowsOnly: true,
+		Pattern: featurePattern(`FileSystemObject`), Alternative: "Dir, MkDir and Open ... For Input"},
+	{Name: "ADO (ADODB) and the SQLUtils getSQL helper", Kind: FeatureLibrary, MinRank: Excel2010, WindowsOnly: true,
+		Pattern: featurePattern(`ADODB\.|getSQL\(`), Alternative: "AutoFilter, arrays and a Collection for grouping"},
+	{Name: "WScript.Shell", Kind: FeatureLibrary, MinRank: Excel2010, WindowsOnly: true,
+		Pattern: featurePattern(`WScript\.Shell`), Alternative: "AppleScriptTask"},
+	{Name: "Windows API Declare statements", Kind: FeatureLanguage, MinRank: Excel2010, WindowsOnly: true,
+		Pattern: featurePattern(`\bDeclare\s+(PtrSafe\s+)?(Sub|Function)\b[^\n]*Lib\s+"(user32|kernel32|gdi32|shell32)`), Alternative: "built-in VBA functions"},
+}
+
+// featurePattern compiles a case-insensitive feature detection pattern
+func featurePattern(expr string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)` + expr)
+}
+
+// worksheetFunctionPattern detects a worksheet function used either inside a
+// formula string ("=XLOOKUP(...") or through Application.WorksheetFunction.
+// Plain VBA calls are not matched because VBA has its own Filter, Join, etc.
+func worksheetFunctionPattern(formulaNames string, methodNames string) *regexp.Regexp {
+	return featurePattern(fmt.Sprintf(`"=[^"\n]*\b(%s)\(|WorksheetFunction\.(%s)\b`, formulaNames, methodNames))
+}
+
+// KnownExcelVersions returns the versions covered by the knowledge base
+func KnownExcelVersions() []ExcelVersion {
+	versions := make([]ExcelVersion, len(knownExcelVersions))
+	copy(versions, knownExcelVersions)
+	return versions
+}
+
+// ResolveVersionProfile interprets a target version string such as
+// "Excel 2016+", "365" or "Excel for Mac" as a compatibility profile.
+// Unrecognized targets resolve to Excel 2016 on Windows.
+func ResolveVersionProfile(target string) VersionProfile {
+	lower := strings.ToLower(target)
+
+	profile := VersionProfile{
+		Target:   target,
+		Rank:     Excel2016,
+		Platform: PlatformWindows,
+	}
+
+	if strings.Contains(lower, "mac") {
+		profile.Platform = PlatformMac
+	}
+
+	switch {
+	case strings.Contains(lower, "365") || strings.Contains(lower, "office 365"):
+		profile.Rank = Excel365
+	default:
+		if year := regexp.MustCompile(`20\d\d`).FindString(lower); year != "" {
+			n, _ := strconv.Atoi(year)
+			profile.Rank = rankForYear(n)
+		}
+	}
+
+	profile.DynamicArrays = profile.Rank >= Excel2021
+	profile.Name = profileName(profile)
+
+	return profile
+}
+
+// rankForYear maps a release year to the closest release at or below it
+func rankForYear(year int) VersionRank {
+	switch {
+	case year >= 2021:
+		return Excel2021
+	case year >= 2019:
+		return Excel2019
+	case year >= 2016:
+		return Excel2016
+	case year >= 2013:
+		return Excel2013
+	default:
+		return Excel2010
+	}
+}
+
+// profileName builds the display name for a resolved profile
+func profileName(profile VersionProfile) string {
+	name := ""
+	for _, version := range knownExcelVersions {
+		if version.Rank == profile.Rank && version.Platform == PlatformWindows {
+			name = version.Name
+			break
+		}
+	}
+
+	if profile.Platform == PlatformMac {
+		return fmt.Sprintf("%s for Mac", name)
+	}
+	return name
+}
+
+// Supports reports whether a feature is available in every release the
+// profile covers
+func (p VersionProfile) Supports(feature ExcelFeature) bool {
+	if feature.WindowsOnly && p.Platform == PlatformMac {
+		return false
+	}
+	return p.Rank >= feature.MinRank
+}
+
+// UnavailableFeatures returns the features that must not be used for the profile
+func (p VersionProfile) UnavailableFeatures() []ExcelFeature {
+	var result []ExcelFeature
+	for _, feature := range excelFeatures {
+		if !p.Supports(feature) {
+			result = append(result, feature)
+		}
+	}
+	return result
+}
+
+// IsCompatible reports whether VBA code only uses features available in
+// the profile
+func (p VersionProfile) IsCompatible(code string) bool {
+	return len(p.IncompatibleUsages(code)) == 0
+}
+
+// IncompatibleUsages returns the names of unavailable features used by code
+func (p VersionProfile) IncompatibleUsages(code string) []string {
+	var used []string
+	for _, feature := range p.UnavailableFeatures() {
+		if feature.Pattern != nil && feature.Pattern.MatchString(code) {
+			used = append(used, feature.Name)
+		}
+	}
+	return used
+}
+
+// Constraints derives the explicit "do not use" and "must use" rules for the profile
+func (p VersionProfile) Constraints() VersionConstraints {
+	var constraints VersionConstraints
+
+	for _, feature := range p.UnavailableFeatures() {
+		reason := fmt.Sprintf("requires %s", rankName(feature.MinRank))
+		if feature.WindowsOnly && p.Platform == PlatformMac {
+			reason = "not available in Excel for Mac"
+		}
+		constraints.DoNotUse = append(constraints.DoNotUse,
+			fmt.Sprintf("%s (%s) - use %s instead", feature.Name, reason, feature.Alternative))
+	}
+
+	// All supported releases ship VBA7, which runs in 64-bit Office
+	constraints.MustUse = append(constraints.MustUse,
+		"PtrSafe on every Declare statement and LongPtr for pointer/handle arguments (64-bit Office)")
+
+	if !p.DynamicArrays {
+		constraints.MustUse = append(constraints.MustUse,
+			"Explicitly sized output ranges - formulas do not spill in this version")
+	} else if p.Rank < Excel365 {
+		constraints.MustUse = append(constraints.MustUse,
+			"Range.Formula2 when writing dynamic array formulas")
+	}
+
+	if p.Platform == PlatformMac {
+		constraints.MustUse = append(constraints.MustUse,
+			"Application.PathSeparator instead of hardcoded \"\\\" in file paths",
+			"Collection objects instead of Scripting.Dictionary")
+	}
+
+	sort.Strings(constraints.MustUse)
+	return constraints
+}
+
+// rankName returns the display name of the first Windows release with the rank
+func rankName(rank VersionRank) string {
+	for _, version := range knownExcelVersions {
+		if version.Rank == rank && version.Platform == PlatformWindows {
+			return version.Name
+		}
+	}
+	return "a newer Excel version"
+}
+
+// formatVersionConstraints renders the constraints section of a prompt
+func formatVersionConstraints(target string) string {
+	profile := ResolveVersionProfile(target)
+	constraints := profile.Constraints()
+
+	var result strings.Builder
+	if profile.Rank < Excel365 {
+		result.WriteString(fmt.Sprintf("The code must run on %s and every later release.\n", profile.Name))
+	} else {
+		result.WriteString(fmt.Sprintf("The code must run on %s.\n", profile.Name))
+	}
+
+	if len(constraints.DoNotUse) > 0 {
+		result.WriteString("\nDO NOT USE:\n")
+		for _, rule := range constraints.DoNotUse {
+			result.WriteString(fmt.Sprintf("- %s\n", rule))
+		}
+	}
+
+	if len(constraints.MustUse) > 0 {
+		result.WriteString("\nMUST USE:\n")
+		for _, rule := range constraints.MustUse {
+			result.WriteString(fmt.Sprintf("- %s\n", rule))
+		}
+	}
+
+	return result.String()
+}
+
+// filterCompatibleExamples drops examples that use features unavailable
+// in the target version
+func filterCompatibleExamples(examples []string, target string) []string {
+	profile := ResolveVersionProfile(target)
+
+	var result []string
+	for _, example := range examples {
+		if profile.IsCompatible(example) {
+			result = append(result, example)
+		}
+	}
+	return result
+}
@@ -0,0 +1,77 @@
+package mcp
+
+import (
+	"fmt"
+	"strings"
+
+	"excel-automation-mcp/backend/service/vba"
+)
+
+// maxExistingCodeChars caps the existing source quoted in a prompt so large
+// projects do not crowd out the data description
+const maxExistingCodeChars = 30000
+
+// formatExistingModules quotes the workbook's modules and tells the model how
+// to fit its code around them, or returns "" when there are none. At most
+// budget characters of source are quoted; 0 means maxExistingCodeChars.
+func formatExistingModules(modules []ExistingModule, budget int) string {
+	if len(modules) == 0 {
+		return ""
+	}
+
+	var result strings.Builder
+	result.WriteString("The workbook already contains the VBA modules below. The new code must integrate with them:\n")
+	result.WriteString("- Reuse the existing procedures, types and constants instead of writing new copies\n")
+	result.WriteString("- Do not declare another Public procedure, variable or constant with a name listed below\n")
+	result.WriteString("- To change an existing procedure, edit it in place and return its module complete; leave unrelated procedures as they are\n")
+	result.WriteString("- Put new code in a new module unless it belongs in one of these\n\n")
+
+	if budget <= 0 {
+		budget = maxExistingCodeChars
+	}
+	var omitted []string
+	for _, module := range modules {
+		kind := module.Type
+		if kind == "" {
+			kind = "standard"
+		}
+		result.WriteString(fmt.Sprintf("### %s (%s module)\n", module.Name, kind))
+		if names := procedureNames(module.Code); len(names) > 0 {
+			result.WriteString("Procedures: " + strings.Join(names, ", ") + "\n")
+		}
+		code := strings.TrimRight(module.Code, "\n")
+		if strings.TrimSpace(code) == "" {
+			result.WriteString("(empty)\n\n")
+			continue
+		}
+		if len(code) > budget {
+			omitted = append(omitted, module.Name)
+			result.WriteString("(source omitted for length)\n\n")
+			continue
+		}
+		budget -= len(code)
+		result.WriteString("```vba\n" + code + "\n```\n\n")
+	}
+	if len(omitted) > 0 {
+		result.WriteString(fmt.Sprintf("Source of %s was left out to keep the prompt short; rely on the procedure names listed.\n", strings.Join(omitted, ", ")))
+	}
+	return result.String()
+}
+
+// procedureNames lists the procedures of a module with their kind and
+// visibility, e.g. "Public Function GetRate"
+func procedureNames(code string) []string {
+	module, _ := vba.ParseModule(code)
+	if module == nil {
+		return nil
+	}
+	names := make([]string, 0, len(module.Procedures))
+	for _, proc := range module.Procedures {
+		name := string(proc.Kind) + " " + proc.Name
+		if proc.Visibility != "" {
+			name = proc.Visibility + " " + name
+		}
+		names = append(names, name)
+	}
+	return names
+}
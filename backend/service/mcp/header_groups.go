@@ -0,0 +1,96 @@
+package mcp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"excel-automation-mcp/backend/service/excelrange"
+)
+
+// HeaderSeparator joins the levels of a multi-row header into one column
+// name, so "2024" / "Q1" / "Sales" becomes "2024 / Q1 / Sales"
+const HeaderSeparator = " / "
+
+// ColumnGroups returns the groups above a column, top level first
+func ColumnGroups(structure DataRange, column int) []HeaderGroup {
+	var groups []HeaderGroup
+	for _, group := range structure.HeaderGroups {
+		if column >= group.FirstColumn && column <= group.LastColumn {
+			groups = append(groups, group)
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].Level < groups[j].Level })
+	return groups
+}
+
+// ColumnLabels returns the label each column shows in its own header cell:
+// the composite header name without the group labels above it
+func ColumnLabels(structure DataRange) []string {
+	labels := make([]string, len(structure.Headers))
+	for i, header := range structure.Headers {
+		prefix := ""
+		for _, group := range ColumnGroups(structure, i) {
+			prefix += group.Label + HeaderSeparator
+		}
+		labels[i] = strings.TrimPrefix(header, prefix)
+	}
+	return labels
+}
+
+// formatHeaderGroups describes a multi-row header as a tree of groups and
+// columns, or returns "" for a single header row
+func formatHeaderGroups(structure DataRange) string {
+	if structure.HeaderRows <= 1 || len(structure.HeaderGroups) == 0 {
+		return ""
+	}
+
+	firstRow := 1
+	if area, err := excelrange.ParseArea(structure.RangeAddress); err == nil {
+		firstRow = area.Row1
+	}
+	lastRow := firstRow + structure.HeaderRows - 1
+	letters := HeaderColumns(structure)
+	columns := func(first, last int) string {
+		if first == last {
+			return "column " + letters[first]
+		}
+		return "columns " + letters[first] + ":" + letters[last]
+	}
+
+	var result strings.Builder
+	result.WriteString(fmt.Sprintf("The header spans %d rows (rows %d-%d) and data starts on row %d.\n",
+		structure.HeaderRows, firstRow, lastRow, lastRow+1))
+	result.WriteString(fmt.Sprintf("Group labels sit in merged cells above the columns; each column's own label is on row %d. ", lastRow))
+	result.WriteString(fmt.Sprintf("Header names join the levels with %q, so locate a column by its letter or by its own label within its group, never by the full name.\n", HeaderSeparator))
+
+	labels := ColumnLabels(structure)
+	groups := append([]HeaderGroup{}, structure.HeaderGroups...)
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].FirstColumn != groups[j].FirstColumn {
+			return groups[i].FirstColumn < groups[j].FirstColumn
+		}
+		return groups[i].Level < groups[j].Level
+	})
+
+	next := 0
+	writeColumns := func(upTo int) {
+		for ; next <= upTo && next < len(labels); next++ {
+			depth := len(ColumnGroups(structure, next))
+			result.WriteString(fmt.Sprintf("%s- %s (%s)\n", strings.Repeat("  ", depth), labels[next], columns(next, next)))
+		}
+	}
+	for i, group := range groups {
+		if group.FirstColumn > next {
+			writeColumns(group.FirstColumn - 1)
+		}
+		result.WriteString(fmt.Sprintf("%s- %s (row %d, %s)\n", strings.Repeat("  ", group.Level),
+			group.Label, firstRow+group.Level, columns(group.FirstColumn, group.LastColumn)))
+		// Columns follow the innermost group that holds them
+		if i+1 == len(groups) || groups[i+1].FirstColumn > group.LastColumn || groups[i+1].Level <= group.Level {
+			writeColumns(group.LastColumn)
+		}
+	}
+	writeColumns(len(labels) - 1)
+	return result.String()
+}
@@ -0,0 +1,24 @@
+package mcp
+
+import (
+	"regexp"
+	"strings"
+)
+
+// volatileLine matches the lines where the templates render the time of
+// generation: {{.CurrentDateTime}}, {{.Timestamp}} and the fallback prompts
+var volatileLine = regexp.MustCompile(`(?m)^(\s*-?\s*(?:Timestamp|Current Date and Time(?: \(UTC\))?): )\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}$`)
+
+// NormalizePrompt returns a prompt with the generation time blanked out and
+// line endings and trailing spaces made uniform, so two prompts built from
+// the same range and requirement compare equal. It is meant for hashing,
+// not for sending.
+func NormalizePrompt(prompt string) string {
+	prompt = strings.ReplaceAll(prompt, "\r\n", "\n")
+	lines := strings.Split(prompt, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	prompt = strings.TrimSpace(strings.Join(lines, "\n"))
+	return volatileLine.ReplaceAllString(prompt, "${1}<time>")
+}
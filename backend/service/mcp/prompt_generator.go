@@ -6,6 +6,8 @@ import (
 	"strings"
 	"text/template"
 	"time"
+
+	"excel-automation-mcp/backend/service/excelrange"
 )
 
 // DataType represents Excel column data types
@@ -32,6 +34,56 @@ type DataRange struct {
 	HasHeaders   bool              // Whether the range has headers
 	SheetName    string            // Sheet name
 	Relationships []Relationship   // Related ranges
+	HeaderRows   int               // Rows taken by the header; 0 or 1 for a single row
+	HeaderGroups []HeaderGroup     // Group labels above the column names of a multi-row header
+	CalculatedColumns []CalculatedColumn // Columns filled by a formula rather than typed values
+	Table        *ExcelTable       // Excel Table (ListObject) holding the range, nil for a plain range
+	NamedRanges  []NamedRange      // Defined names the code can use instead of addresses
+}
+
+// HeaderGroup is a label spanning one or more columns in a multi-row header,
+// such as "2024" over its quarters or "Q1" over Sales and Cost
+type HeaderGroup struct {
+	Label       string // Label as shown in the (usually merged) header cell
+	Level       int    // Header row holding the label, 0 for the top row
+	FirstColumn int    // Index in Headers of the first column in the group
+	LastColumn  int    // Index in Headers of the last column in the group
+}
+
+// CalculatedColumn is a column whose values come from a formula, such as a
+// Total computed from Price and Quantity
+type CalculatedColumn struct {
+	Header      string   // Column header
+	Column      string   // Column letter on the sheet
+	Formula     string   // Representative formula as written in the first data row that has it
+	FormulaR1C1 string   // The same formula in R1C1 notation, identical in every row of a filled column
+	DependsOn   []string // Letters of the columns of the range the formula reads
+	External    []string // Other references: cells outside the range or on other sheets
+	Rows        int      // Data rows holding the representative formula
+	Consistent  bool     // Every non-empty data row holds the representative formula
+}
+
+// ExcelTable is an Excel Table (ListObject) holding a data range
+type ExcelTable struct {
+	Name         string   // ListObject name, e.g. "tblSales"
+	RangeAddress string   // Whole table: header, data and totals rows
+	Columns      []string // ListColumn names in order
+	HasHeaders   bool     // The header row is shown
+	HasTotals    bool     // A totals row is shown below the data
+}
+
+// NamedRange is a defined name of the workbook
+type NamedRange struct {
+	Name     string // e.g. "TaxRate"
+	RefersTo string // e.g. "=Rates!$B$1"
+	Scope    string // Sheet of a sheet-level name, "" for a workbook-level name
+}
+
+// ExistingModule is a module already in the workbook's VBA project
+type ExistingModule struct {
+	Name string // e.g. "Module1"
+	Type string // "standard", "class", "document" or "form"
+	Code string // Source without Attribute lines
 }
 
 // Relationship represents a relationship between data ranges
@@ -50,10 +102,12 @@ type PromptConfig struct {
 	MaxSampleRows       int               // Maximum number of sample data rows to include
 	HighlightKeyColumns []string          // Column names to highlight as important
 	TemplateVariables   map[string]string // Custom template variables
-	OutputType          string            // Type of output (Generic, DataProcessing, Reporting, etc.)
+	OutputType          string            // Type of output (Generic, DataProcessing, Reporting, Compact, etc.)
 	DetailLevel         string            // Level of detail (Basic, Intermediate, Advanced)
 	IncludeModules      []string          // Standard modules to include
 	TargetExcelVersion  string            // Target Excel version
+	ExistingModules     []ExistingModule  // Modules in the workbook the generated code must integrate with
+	MaxExistingCode     int               // Characters of existing source to quote at most (0: default)
 }
 
 // DefaultPromptConfig returns default configuration for prompt generation
@@ -101,11 +155,16 @@ func GenerateExaMCPPromptWithConfig(structure DataRange, userRequirement string,
 		"UserRequirement": userRequirement,
 		"Config":          config,
 		"HeadersFormatted": formatHeaders(structure.Headers, structure.DataTypes),
+		"HeaderGroupsFormatted": formatHeaderGroups(structure),
+		"CalculatedColumnsFormatted": formatCalculatedColumns(structure),
+		"TablesFormatted": formatTableContext(structure),
 		"SampleDataLimited": limitSampleData(structure.SampleData, config.MaxSampleRows),
 		"RelationshipDescriptions": formatRelationships(structure.Relationships),
 		"ModuleDescriptions": getModuleDescriptions(config.IncludeModules),
 		"Examples": getExamples(config),
 		"ColumnLetters": generateColumnLetters(len(structure.Headers)),
+		"VersionConstraints": formatVersionConstraints(config.TargetExcelVersion),
+		"ExistingCode": formatExistingModules(config.ExistingModules, config.MaxExistingCode),
 	}
 
 	// Add custom template variables
@@ -114,7 +173,10 @@ func GenerateExaMCPPromptWithConfig(structure DataRange, userRequirement string,
 	}
 
 	// Parse and execute template
-	tmpl, err := template.New("mcp_prompt").Parse(templateContent)
+	tmpl, err := template.New("mcp_prompt").Funcs(template.FuncMap{
+		"add":  func(a, b int) int { return a + b },
+		"join": strings.Join,
+	}).Parse(templateContent)
 	if err != nil {
 		return fallbackPrompt(structure, userRequirement, config, err)
 	}
@@ -131,7 +193,9 @@ func GenerateExaMCPPromptWithConfig(structure DataRange, userRequirement string,
 // getPromptTemplate returns the appropriate template based on the configuration
 func getPromptTemplate(config PromptConfig) string {
 	// Use the appropriate template based on configuration
-	if config.OutputType == "Reporting" {
+	if config.OutputType == "Compact" {
+		return compactTemplate
+	} else if config.OutputType == "Reporting" {
 		return reportingTemplate
 	} else if config.OutputType == "DataProcessing" {
 		return dataProcessingTemplate
@@ -244,6 +308,9 @@ func getExamples(config PromptConfig) string {
 		examples = append(examples, sqlExample)
 	}
 	
+	// Drop examples that would teach features the target version lacks
+	examples = filterCompatibleExamples(examples, config.TargetExcelVersion)
+	
 	// Combine examples
 	var result strings.Builder
 	for i, example := range examples {
@@ -264,13 +331,31 @@ func generateColumnLetters(count int) []string {
 
 // columnLetterFromIndex converts a 0-based index to Excel column letter
 func columnLetterFromIndex(index int) string {
-	result := ""
-	for index >= 0 {
-		remainder := index % 26
-		result = string(rune('A'+remainder)) + result
-		index = index/26 - 1
+	return excelrange.ColumnLetter(index + 1)
+}
+
+// columnIndexFromLetter converts an Excel column letter to a 0-based index,
+// or returns -1 if the letters are not a column
+func columnIndexFromLetter(letters string) int {
+	return excelrange.ColumnNumber(strings.TrimSpace(letters)) - 1
+}
+
+// HeaderColumns returns the column letter of each header, starting from the
+// first column of the range address (headers of "C1:E10" map to C, D, E)
+func HeaderColumns(structure DataRange) []string {
+	offset := 0
+	address := structure.RangeAddress
+	if i := strings.LastIndex(address, "!"); i >= 0 {
+		address = address[i+1:]
 	}
-	return result
+	start := strings.ReplaceAll(strings.SplitN(address, ":", 2)[0], "$", "")
+	if letters := strings.TrimRight(start, "0123456789"); letters != "" {
+		if index := columnIndexFromLetter(letters); index >= 0 {
+			offset = index
+		}
+	}
+	
+	return generateColumnLetters(offset + len(structure.Headers))[offset:]
 }
 
 // fallbackPrompt generates a simple prompt when template processing fails
@@ -285,8 +370,29 @@ func fallbackPrompt(structure DataRange, userRequirement string, config PromptCo
 	prompt.WriteString(fmt.Sprintf("- Sheet: %s\n", structure.SheetName))
 	prompt.WriteString(fmt.Sprintf("- Range: %s\n", structure.RangeAddress))
 	prompt.WriteString(fmt.Sprintf("- Headers: %s\n", strings.Join(structure.Headers, ", ")))
+	if structure.HeaderRows > 1 {
+		prompt.WriteString(fmt.Sprintf("- Header Rows: %d (names join the levels with %q)\n", structure.HeaderRows, HeaderSeparator))
+	}
+	for _, column := range structure.CalculatedColumns {
+		prompt.WriteString(fmt.Sprintf("- Calculated Column: %s (column %s) = %s; keep the formula, do not overwrite it with values\n", column.Header, column.Column, column.Formula))
+	}
+	if structure.Table != nil {
+		prompt.WriteString(fmt.Sprintf("- Excel Table: %s; use ListObjects(%q) and its ListColumns, not fixed addresses\n", structure.Table.Name, structure.Table.Name))
+	}
 	prompt.WriteString(fmt.Sprintf("- Data Rows: %d\n\n", structure.DataRows))
 	
+	// Existing code
+	if existing := formatExistingModules(config.ExistingModules, config.MaxExistingCode); existing != "" {
+		prompt.WriteString("## EXISTING VBA CODE\n")
+		prompt.WriteString(existing)
+		prompt.WriteString("\n")
+	}
+	
+	// Version constraints
+	prompt.WriteString("## EXCEL VERSION CONSTRAINTS\n")
+	prompt.WriteString(formatVersionConstraints(config.TargetExcelVersion))
+	prompt.WriteString("\n")
+	
 	// User requirement
 	prompt.WriteString("## USER REQUIREMENT\n")
 	prompt.WriteString(userRequirement)
@@ -328,7 +434,18 @@ const basicTemplate = `# TASK: Generate Excel VBA script based on user requireme
 
 ## HEADERS
 {{.HeadersFormatted}}
-
+{{if .HeaderGroupsFormatted}}
+## GROUPED COLUMNS
+{{.HeaderGroupsFormatted}}
+{{end}}
+{{if .CalculatedColumnsFormatted}}
+## CALCULATED COLUMNS
+{{.CalculatedColumnsFormatted}}
+{{end}}
+{{if .TablesFormatted}}
+## EXCEL TABLES AND NAMED RANGES
+{{.TablesFormatted}}
+{{end}}
 ## SAMPLE DATA
 {{range $index, $row := .SampleDataLimited}}Row {{add $index 1}}: {{join $row ", "}}
 {{end}}
@@ -343,6 +460,14 @@ const basicTemplate = `# TASK: Generate Excel VBA script based on user requireme
 {{.Examples}}
 {{end}}
 
+{{if .ExistingCode}}
+## EXISTING VBA CODE
+{{.ExistingCode}}
+{{end}}
+
+## EXCEL VERSION CONSTRAINTS
+{{.VersionConstraints}}
+
 ## USER REQUIREMENT
 {{.UserRequirement}}
 
@@ -373,7 +498,18 @@ const advancedTemplate = `# TASK: Generate Excel VBA script based on user requir
 
 ## HEADERS
 {{.HeadersFormatted}}
-
+{{if .HeaderGroupsFormatted}}
+## GROUPED COLUMNS
+{{.HeaderGroupsFormatted}}
+{{end}}
+{{if .CalculatedColumnsFormatted}}
+## CALCULATED COLUMNS
+{{.CalculatedColumnsFormatted}}
+{{end}}
+{{if .TablesFormatted}}
+## EXCEL TABLES AND NAMED RANGES
+{{.TablesFormatted}}
+{{end}}
 ## KEY COLUMNS
 {{range .Config.HighlightKeyColumns}}
 - {{.}}: Critical for business logic
@@ -396,6 +532,14 @@ const advancedTemplate = `# TASK: Generate Excel VBA script based on user requir
 {{.Examples}}
 {{end}}
 
+{{if .ExistingCode}}
+## EXISTING VBA CODE
+{{.ExistingCode}}
+{{end}}
+
+## EXCEL VERSION CONSTRAINTS
+{{.VersionConstraints}}
+
 ## USER REQUIREMENT
 {{.UserRequirement}}
 
@@ -427,7 +571,18 @@ const reportingTemplate = `# TASK: Generate Excel VBA reporting script
 
 ## COLUMNS FOR REPORTING
 {{.HeadersFormatted}}
-
+{{if .HeaderGroupsFormatted}}
+## GROUPED COLUMNS
+{{.HeaderGroupsFormatted}}
+{{end}}
+{{if .CalculatedColumnsFormatted}}
+## CALCULATED COLUMNS
+{{.CalculatedColumnsFormatted}}
+{{end}}
+{{if .TablesFormatted}}
+## EXCEL TABLES AND NAMED RANGES
+{{.TablesFormatted}}
+{{end}}
 ## SAMPLE DATA
 {{range $index, $row := .SampleDataLimited}}Row {{add $index 1}}: {{join $row ", "}}
 {{end}}
@@ -442,6 +597,14 @@ const reportingTemplate = `# TASK: Generate Excel VBA reporting script
 {{.Examples}}
 {{end}}
 
+{{if .ExistingCode}}
+## EXISTING VBA CODE
+{{.ExistingCode}}
+{{end}}
+
+## EXCEL VERSION CONSTRAINTS
+{{.VersionConstraints}}
+
 ## REPORTING REQUIREMENTS
 {{.UserRequirement}}
 
@@ -473,7 +636,18 @@ const dataProcessingTemplate = `# TASK: Generate Excel VBA data processing scrip
 
 ## DATA COLUMNS
 {{.HeadersFormatted}}
-
+{{if .HeaderGroupsFormatted}}
+## GROUPED COLUMNS
+{{.HeaderGroupsFormatted}}
+{{end}}
+{{if .CalculatedColumnsFormatted}}
+## CALCULATED COLUMNS
+{{.CalculatedColumnsFormatted}}
+{{end}}
+{{if .TablesFormatted}}
+## EXCEL TABLES AND NAMED RANGES
+{{.TablesFormatted}}
+{{end}}
 ## SAMPLE DATA
 {{range $index, $row := .SampleDataLimited}}Row {{add $index 1}}: {{join $row ", "}}
 {{end}}
@@ -488,6 +662,14 @@ const dataProcessingTemplate = `# TASK: Generate Excel VBA data processing scrip
 {{.Examples}}
 {{end}}
 
+{{if .ExistingCode}}
+## EXISTING VBA CODE
+{{.ExistingCode}}
+{{end}}
+
+## EXCEL VERSION CONSTRAINTS
+{{.VersionConstraints}}
+
 ## DATA PROCESSING REQUIREMENTS
 {{.UserRequirement}}
 
@@ -519,7 +701,18 @@ const userInterfaceTemplate = `# TASK: Generate Excel VBA user interface script
 
 ## DATA FIELDS
 {{.HeadersFormatted}}
-
+{{if .HeaderGroupsFormatted}}
+## GROUPED COLUMNS
+{{.HeaderGroupsFormatted}}
+{{end}}
+{{if .CalculatedColumnsFormatted}}
+## CALCULATED COLUMNS
+{{.CalculatedColumnsFormatted}}
+{{end}}
+{{if .TablesFormatted}}
+## EXCEL TABLES AND NAMED RANGES
+{{.TablesFormatted}}
+{{end}}
 ## SAMPLE DATA
 {{range $index, $row := .SampleDataLimited}}Row {{add $index 1}}: {{join $row ", "}}
 {{end}}
@@ -534,6 +727,14 @@ const userInterfaceTemplate = `# TASK: Generate Excel VBA user interface script
 {{.Examples}}
 {{end}}
 
+{{if .ExistingCode}}
+## EXISTING VBA CODE
+{{.ExistingCode}}
+{{end}}
+
+## EXCEL VERSION CONSTRAINTS
+{{.VersionConstraints}}
+
 ## UI REQUIREMENTS
 {{.UserRequirement}}
 
@@ -547,6 +748,46 @@ const userInterfaceTemplate = `# TASK: Generate Excel VBA user interface script
 7. Return only the VBA code, without additional explanations
 `
 
+// compactTemplate keeps only what the code depends on, for models with a
+// small context window; it never includes examples
+const compactTemplate = `# TASK: Generate Excel VBA code
+- Timestamp: {{.CurrentDateTime}}
+- Target Excel Version: {{.Config.TargetExcelVersion}}
+
+## DATA
+- Sheet: {{.Structure.SheetName}}
+- Range: {{.Structure.RangeAddress}}
+- Rows: {{.Structure.DataRows}}
+- Has Headers: {{.Structure.HasHeaders}}
+
+## HEADERS
+{{.HeadersFormatted}}
+{{- if .CalculatedColumnsFormatted}}
+## CALCULATED COLUMNS
+{{.CalculatedColumnsFormatted}}
+{{- end}}
+{{- if .TablesFormatted}}
+## TABLES AND NAMES
+{{.TablesFormatted}}
+{{- end}}
+{{- if .SampleDataLimited}}
+## SAMPLE DATA
+{{range $index, $row := .SampleDataLimited}}Row {{add $index 1}}: {{join $row ", "}}
+{{end}}
+{{- end}}
+{{- if .ExistingCode}}
+## EXISTING VBA CODE
+{{.ExistingCode}}
+{{- end}}
+## EXCEL VERSION CONSTRAINTS
+{{.VersionConstraints}}
+## USER REQUIREMENT
+{{.UserRequirement}}
+
+## OUTPUT
+Return complete, working VBA code with error handling in a single vba code block and nothing else.
+`
+
 // Example code snippets
 const basicExample = `## Basic Example
 User Requirement: Filter records where [Sales] > 1000 and calculate the sum of [Quantity]
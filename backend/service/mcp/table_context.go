@@ -0,0 +1,83 @@
+package mcp
+
+import (
+	"fmt"
+	"strings"
+
+	"excel-automation-mcp/backend/service/excelrange"
+)
+
+// formatTableContext tells the model to address the data through its Excel
+// Table and the workbook's defined names rather than fixed addresses, or
+// returns "" when the range has neither
+func formatTableContext(structure DataRange) string {
+	if structure.Table == nil && len(structure.NamedRanges) == 0 {
+		return ""
+	}
+
+	var result strings.Builder
+	if table := structure.Table; table != nil {
+		sheet, owner := "the sheet", "ws"
+		if structure.SheetName != "" {
+			sheet, owner = fmt.Sprintf("sheet %q", structure.SheetName), fmt.Sprintf("Worksheets(%q)", structure.SheetName)
+		}
+		result.WriteString(fmt.Sprintf("The data is the Excel Table %q (a ListObject) on %s, currently %s. ", table.Name, sheet, table.RangeAddress))
+		result.WriteString("Tables grow and move as rows are added, so never hardcode Range(\"" + structure.RangeAddress + "\") or a last row:\n")
+		result.WriteString(fmt.Sprintf("- Set lo = %s.ListObjects(%q) and work with lo.DataBodyRange (data rows only)\n", owner, table.Name))
+		if len(table.Columns) > 0 {
+			result.WriteString(fmt.Sprintf("- Columns by name: lo.ListColumns(%q).DataBodyRange; lo.ListColumns(%q).Index gives the position\n", table.Columns[0], table.Columns[0]))
+			quoted := make([]string, len(table.Columns))
+			for i, column := range table.Columns {
+				quoted[i] = fmt.Sprintf("%q", column)
+			}
+			result.WriteString("- ListColumn names: " + strings.Join(quoted, ", ") + "\n")
+			result.WriteString(fmt.Sprintf("- Formulas use structured references such as =%s[@[%s]] or =SUM(%s[%s])\n", table.Name, table.Columns[0], table.Name, table.Columns[len(table.Columns)-1]))
+		}
+		result.WriteString("- Add rows with lo.ListRows.Add; the table extends its formulas and formats itself\n")
+		if table.HasTotals {
+			result.WriteString("- The totals row (lo.TotalsRowRange) is not data; leave it out of loops and lookups\n")
+		}
+		if !table.HasHeaders {
+			result.WriteString("- The header row is hidden; lo.HeaderRowRange is Nothing, so refer to columns by ListColumns name\n")
+		}
+	}
+
+	if len(structure.NamedRanges) > 0 {
+		if result.Len() > 0 {
+			result.WriteString("\n")
+		}
+		result.WriteString("Defined names of the workbook; use them instead of the addresses they stand for:\n")
+		for _, name := range structure.NamedRanges {
+			result.WriteString("- " + describeNamedRange(name, structure) + "\n")
+		}
+	}
+	return result.String()
+}
+
+// describeNamedRange explains what a defined name refers to and how code reads it
+func describeNamedRange(name NamedRange, structure DataRange) string {
+	refersTo := strings.TrimPrefix(name.RefersTo, "=")
+	scope := "workbook-level"
+	access := fmt.Sprintf("Range(%q)", name.Name)
+	if name.Scope != "" {
+		scope = "sheet-level on " + name.Scope
+		access = fmt.Sprintf("Worksheets(%q).Range(%q)", name.Scope, name.Name)
+	}
+
+	area, err := excelrange.ParseArea(refersTo)
+	if err != nil {
+		// Constants and formulas have no cells to address
+		return fmt.Sprintf("%s refers to %s (%s constant or formula): read it with Evaluate(%q)", name.Name, name.RefersTo, scope, name.Name)
+	}
+	line := fmt.Sprintf("%s refers to %s (%s): %s", name.Name, name.RefersTo, scope, access)
+
+	// Point out names that cover columns of the data
+	data, err := excelrange.ParseArea(structure.RangeAddress)
+	sameSheet := area.Sheet == "" || strings.EqualFold(area.Sheet, structure.SheetName)
+	if err == nil && sameSheet && area.Col1 == area.Col2 && area.Col1 >= data.Col1 && area.Col1 <= data.Col2 {
+		if i := area.Col1 - data.Col1; i < len(structure.Headers) {
+			line += fmt.Sprintf(", the %s column", structure.Headers[i])
+		}
+	}
+	return line
+}
@@ -0,0 +1,90 @@
+package validation
+
+import (
+	"strings"
+
+	"excel-automation-mcp/backend/service/vba"
+)
+
+// CodeBlock is a fenced code block found in an LLM response
+type CodeBlock struct {
+	Language  string `json:"language"`
+	Code      string `json:"code"`
+	StartLine int    `json:"startLine"` // Response line of the first code line (1-based)
+}
+
+// vbaLanguages are the fence languages treated as VBA code
+var vbaLanguages = map[string]bool{"": true, "vba": true, "vb": true, "vbnet": true, "vbscript": true, "basic": true}
+
+// ExtractCodeBlocks returns every ``` fenced block of the response in order.
+// An unterminated final block runs to the end of the response.
+func ExtractCodeBlocks(response string) []CodeBlock {
+	var blocks []CodeBlock
+	var current *CodeBlock
+	var lines []string
+
+	for i, line := range strings.Split(strings.ReplaceAll(response, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			if current == nil {
+				current = &CodeBlock{
+					Language:  strings.ToLower(strings.TrimSpace(strings.TrimPrefix(trimmed, "```"))),
+					StartLine: i + 2,
+				}
+				lines = nil
+			} else {
+				current.Code = strings.Join(lines, "\n")
+				blocks = append(blocks, *current)
+				current = nil
+			}
+			continue
+		}
+		if current != nil {
+			lines = append(lines, line)
+		}
+	}
+
+	if current != nil {
+		current.Code = strings.Join(lines, "\n")
+		blocks = append(blocks, *current)
+	}
+	return blocks
+}
+
+// ExtractVBABlocks returns the VBA code blocks of a response. A response
+// without any fences is a single block of bare code when it parses as a
+// module, and holds no code otherwise: a refusal or an explanation is not
+// checked as VBA.
+func ExtractVBABlocks(response string) []CodeBlock {
+	blocks := ExtractCodeBlocks(response)
+	if len(blocks) == 0 {
+		if !isBareModule(response) {
+			return nil
+		}
+		return []CodeBlock{{Language: "vba", Code: response, StartLine: 1}}
+	}
+
+	var vbaBlocks []CodeBlock
+	for _, block := range blocks {
+		if vbaLanguages[block.Language] {
+			vbaBlocks = append(vbaBlocks, block)
+		}
+	}
+	return vbaBlocks
+}
+
+// isBareModule reports whether unfenced text is VBA code: it declares or
+// defines something and parses without errors
+func isBareModule(text string) bool {
+	module, errs := vba.ParseModule(text)
+	return len(errs) == 0 && (len(module.Procedures) > 0 || len(module.Declarations) > 0)
+}
+
+// ExtractVBACode joins the VBA code blocks of a response
+func ExtractVBACode(response string) string {
+	var parts []string
+	for _, block := range ExtractVBABlocks(response) {
+		parts = append(parts, block.Code)
+	}
+	return strings.Join(parts, "\n\n")
+}
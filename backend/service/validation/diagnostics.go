@@ -0,0 +1,121 @@
+package validation
+
+import (
+	"encoding/json"
+	"sort"
+
+	"excel-automation-mcp/backend/service/vba"
+)
+
+// Severity indicates how serious a diagnostic is
+type Severity string
+
+const (
+	SeverityError   Severity = "error"   // Code will not compile or will fail at runtime
+	SeverityWarning Severity = "warning" // Code runs but is likely wrong or fragile
+	SeverityInfo    Severity = "info"    // Style or best-practice suggestion
+)
+
+// Diagnostic is a single problem found in generated code. Lines and columns
+// are 1-based so they can be passed directly to the frontend code editor.
+type Diagnostic struct {
+	Code      string   `json:"code"`
+	Severity  Severity `json:"severity"`
+	Message   string   `json:"message"`
+	Line      int      `json:"line"`
+	Column    int      `json:"column"`
+	EndLine   int      `json:"endLine"`
+	EndColumn int      `json:"endColumn"`
+	Source    string   `json:"source"`
+}
+
+// Report collects the diagnostics produced by one validation run
+type Report struct {
+	Valid        bool         `json:"valid"`
+	ErrorCount   int          `json:"errorCount"`
+	WarningCount int          `json:"warningCount"`
+	InfoCount    int          `json:"infoCount"`
+	Diagnostics  []Diagnostic `json:"diagnostics"`
+}
+
+// NewReport creates an empty report
+func NewReport() *Report {
+	return &Report{Valid: true, Diagnostics: []Diagnostic{}}
+}
+
+// Add appends a diagnostic and updates the counters
+func (r *Report) Add(d Diagnostic) {
+	switch d.Severity {
+	case SeverityError:
+		r.ErrorCount++
+		r.Valid = false
+	case SeverityWarning:
+		r.WarningCount++
+	default:
+		r.InfoCount++
+	}
+	r.Diagnostics = append(r.Diagnostics, d)
+}
+
+// AddAt appends a diagnostic covering the source between start and end
+func (r *Report) AddAt(source, code string, severity Severity, start, end vba.Pos, message string) {
+	if !end.IsValid() || end.Offset < start.Offset {
+		end = start
+	}
+	r.Add(Diagnostic{
+		Code:      code,
+		Severity:  severity,
+		Message:   message,
+		Line:      start.Line,
+		Column:    start.Column,
+		EndLine:   end.Line,
+		EndColumn: end.Column,
+		Source:    source,
+	})
+}
+
+// Merge appends every diagnostic of other to the report
+func (r *Report) Merge(other *Report) {
+	if other == nil {
+		return
+	}
+	for _, d := range other.Diagnostics {
+		r.Add(d)
+	}
+}
+
+// Sort orders diagnostics by position, then by severity
+func (r *Report) Sort() {
+	rank := map[Severity]int{SeverityError: 0, SeverityWarning: 1, SeverityInfo: 2}
+	sort.SliceStable(r.Diagnostics, func(i, j int) bool {
+		a, b := r.Diagnostics[i], r.Diagnostics[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		if a.Column != b.Column {
+			return a.Column < b.Column
+		}
+		return rank[a.Severity] < rank[b.Severity]
+	})
+}
+
+// HasErrors reports whether the report contains any error diagnostics
+func (r *Report) HasErrors() bool {
+	return r.ErrorCount > 0
+}
+
+// ByCode returns the diagnostics with the given code
+func (r *Report) ByCode(code string) []Diagnostic {
+	var matches []Diagnostic
+	for _, d := range r.Diagnostics {
+		if d.Code == code {
+			matches = append(matches, d)
+		}
+	}
+	return matches
+}
+
+// JSON returns the report encoded for the frontend
+func (r *Report) JSON() ([]byte, error) {
+	return json.Marshal(r)
+}
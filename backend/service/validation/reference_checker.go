@@ -0,0 +1,651 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"excel-automation-mcp/backend/service/excelrange"
+	"excel-automation-mcp/backend/service/mcp"
+	"excel-automation-mcp/backend/service/vba"
+)
+
+// ReferenceKind identifies what a reference in generated code points at
+type ReferenceKind string
+
+const (
+	RefSheet  ReferenceKind = "sheet"  // Sheets("Data"), Worksheets("Data")
+	RefHeader ReferenceKind = "header" // "Sales" compared with a header cell or passed to Match/Find
+	RefColumn ReferenceKind = "column" // Cells(r, "C"), Columns("C")
+	RefRange  ReferenceKind = "range"  // Range("A1:E100")
+	RefTable  ReferenceKind = "table"  // ListObjects("tblSales")
+)
+
+// Diagnostic codes reported by the reference checker
+const (
+	CodeUnknownSheet     = "unknown-sheet"
+	CodeUnknownHeader    = "unknown-header"
+	CodeColumnOutOfRange = "column-out-of-range"
+	CodeInvalidRange     = "invalid-range"
+	CodeUnknownTable     = "unknown-table"
+)
+
+// SourceReferences identifies diagnostics produced by the reference checker
+const SourceReferences = "references"
+
+// maxReferenceSuggested limits the suggestions offered for an unknown reference
+const maxReferenceSuggested = 3
+
+// Reference is a sheet, header, column, range or table used by generated code
+type Reference struct {
+	Kind      ReferenceKind `json:"kind"`
+	Value     string        `json:"value"`
+	Write     bool          `json:"write"` // Part of an assignment target
+	Line      int           `json:"line"`
+	Column    int           `json:"column"`
+	EndLine   int           `json:"endLine"`
+	EndColumn int           `json:"endColumn"`
+}
+
+// UnknownReference is a reference that does not match the analyzed data
+type UnknownReference struct {
+	Reference
+	Reason      string   `json:"reason"`
+	Suggestions []string `json:"suggestions"`
+}
+
+// ReferenceReport lists the references of generated code and those that do not exist
+type ReferenceReport struct {
+	References []Reference        `json:"references"`
+	Unknown    []UnknownReference `json:"unknown"`
+}
+
+// Diagnostics converts the unknown references into editor diagnostics
+func (r *ReferenceReport) Diagnostics() *Report {
+	report := NewReport()
+	for _, unknown := range r.Unknown {
+		code, severity := CodeUnknownHeader, SeverityWarning
+		switch unknown.Kind {
+		case RefSheet:
+			code, severity = CodeUnknownSheet, SeverityError
+		case RefColumn:
+			code = CodeColumnOutOfRange
+		case RefRange:
+			code, severity = CodeInvalidRange, SeverityError
+		case RefTable:
+			code, severity = CodeUnknownTable, SeverityError
+		}
+
+		message := unknown.Reason
+		if len(unknown.Suggestions) > 0 {
+			message += "; did you mean " + strings.Join(quoteAll(unknown.Suggestions), " or ") + "?"
+		}
+		report.Add(Diagnostic{
+			Code:      code,
+			Severity:  severity,
+			Message:   message,
+			Line:      unknown.Line,
+			Column:    unknown.Column,
+			EndLine:   unknown.EndLine,
+			EndColumn: unknown.EndColumn,
+			Source:    SourceReferences,
+		})
+	}
+	report.Sort()
+	return report
+}
+
+func quoteAll(values []string) []string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value)
+	}
+	return quoted
+}
+
+// a1Area is a parsed A1 area. Columns are 0-based and -1 for whole-row
+// references; rows are 1-based and 0 for whole-column references.
+type a1Area struct {
+	Sheet            string
+	StartCol, EndCol int
+	StartRow, EndRow int
+}
+
+// parseA1 parses an address such as "A1:E100", "'My Sheet'!C:C" or "2:2".
+// It reports false for text that is not an address, such as a defined name.
+func parseA1(address string) (a1Area, bool, error) {
+	parsed, err := excelrange.ParseArea(strings.TrimSpace(address))
+	if err != nil {
+		var bounds *excelrange.BoundsError
+		if errors.As(err, &bounds) {
+			return a1Area{}, true, bounds
+		}
+		return a1Area{}, false, nil
+	}
+
+	area := a1Area{
+		Sheet:    parsed.Sheet,
+		StartCol: parsed.Col1 - 1, EndCol: parsed.Col2 - 1,
+		StartRow: parsed.Row1, EndRow: parsed.Row2,
+	}
+	switch {
+	case parsed.WholeColumns() && !parsed.WholeRows():
+		area.StartRow, area.EndRow = 0, 0
+	case parsed.WholeRows() && !parsed.WholeColumns():
+		area.StartCol, area.EndCol = -1, -1
+	}
+	return area, true, nil
+}
+
+// columnNumber converts column letters to a 1-based number, or 0
+func columnNumber(letters string) int {
+	return excelrange.ColumnNumber(letters)
+}
+
+// columnLetters converts a 0-based column index to letters
+func columnLetters(index int) string {
+	return excelrange.ColumnLetter(index + 1)
+}
+
+// referenceContext is the workbook knowledge references are checked against
+type referenceContext struct {
+	sheets    []string
+	headers   []string
+	tables    []string
+	columns   map[int]string // Column index -> header
+	minCol    int
+	maxCol    int
+	headerRow int
+}
+
+func newReferenceContext(ranges []mcp.DataRange) *referenceContext {
+	ctx := &referenceContext{columns: map[int]string{}, minCol: -1, maxCol: -1, headerRow: 1}
+
+	for i, structure := range ranges {
+		if structure.SheetName != "" && !containsFold(ctx.sheets, structure.SheetName) {
+			ctx.sheets = append(ctx.sheets, structure.SheetName)
+		}
+		ctx.headers = append(ctx.headers, structure.Headers...)
+		if structure.HeaderRows > 1 {
+			// Code finds grouped columns by the labels in their own cells
+			ctx.headers = append(ctx.headers, mcp.ColumnLabels(structure)...)
+			for _, group := range structure.HeaderGroups {
+				ctx.headers = append(ctx.headers, group.Label)
+			}
+		}
+
+		if structure.Table != nil {
+			ctx.tables = append(ctx.tables, structure.Table.Name)
+			ctx.headers = append(ctx.headers, structure.Table.Columns...)
+		}
+
+		letters := mcp.HeaderColumns(structure)
+		for j, header := range structure.Headers {
+			col := columnNumber(letters[j]) - 1
+			if _, exists := ctx.columns[col]; !exists {
+				ctx.columns[col] = header
+			}
+		}
+
+		area, ok, _ := parseA1(structure.RangeAddress)
+		if !ok && len(letters) > 0 {
+			area = a1Area{StartCol: columnNumber(letters[0]) - 1, EndCol: columnNumber(letters[len(letters)-1]) - 1, StartRow: 1}
+			ok = true
+		}
+		if ok && area.StartCol >= 0 {
+			if ctx.minCol < 0 || area.StartCol < ctx.minCol {
+				ctx.minCol = area.StartCol
+			}
+			if area.EndCol > ctx.maxCol {
+				ctx.maxCol = area.EndCol
+			}
+			if i == 0 && area.StartRow > 0 {
+				ctx.headerRow = area.StartRow
+			}
+		}
+	}
+
+	return ctx
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), strings.TrimSpace(value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// columnLabel describes a column with its header, e.g. "E (Sales)"
+func (ctx *referenceContext) columnLabel(col int) string {
+	if header, ok := ctx.columns[col]; ok {
+		return fmt.Sprintf("%s (%s)", columnLetters(col), header)
+	}
+	return columnLetters(col)
+}
+
+// nearestColumns suggests the data columns closest to col
+func (ctx *referenceContext) nearestColumns(col int) []string {
+	var cols []int
+	for c := range ctx.columns {
+		cols = append(cols, c)
+	}
+	sort.Slice(cols, func(i, j int) bool {
+		di, dj := abs(cols[i]-col), abs(cols[j]-col)
+		if di != dj {
+			return di < dj
+		}
+		return cols[i] < cols[j]
+	})
+
+	var suggestions []string
+	for _, c := range cols {
+		if len(suggestions) == maxReferenceSuggested {
+			break
+		}
+		suggestions = append(suggestions, ctx.columnLabel(c))
+	}
+	return suggestions
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// CheckReferences extracts the references of a VBA module and compares them
+// with the analyzed data ranges. Sheets the code creates itself (ws.Name = "Report")
+// are treated as known.
+func CheckReferences(code string, ranges ...mcp.DataRange) *ReferenceReport {
+	ctx := newReferenceContext(ranges)
+	module, _ := vba.ParseModule(code)
+	refs := extractReferences(module, ctx.headerRow)
+
+	report := &ReferenceReport{References: refs, Unknown: []UnknownReference{}}
+	if refs == nil {
+		report.References = []Reference{}
+	}
+
+	created := createdSheetNames(module)
+	for _, ref := range refs {
+		if unknown := ctx.check(ref, created); unknown != nil {
+			report.Unknown = append(report.Unknown, *unknown)
+		}
+	}
+	return report
+}
+
+// ExtractReferences returns the sheets, headers, columns and ranges used by a VBA module
+func ExtractReferences(code string) []Reference {
+	module, _ := vba.ParseModule(code)
+	return extractReferences(module, 1)
+}
+
+// check compares one reference with the context; it returns nil when the reference is valid
+func (ctx *referenceContext) check(ref Reference, created []string) *UnknownReference {
+	unknown := func(reason string, suggestions []string) *UnknownReference {
+		if suggestions == nil {
+			suggestions = []string{}
+		}
+		return &UnknownReference{Reference: ref, Reason: reason, Suggestions: suggestions}
+	}
+
+	switch ref.Kind {
+	case RefSheet:
+		if len(ctx.sheets) == 0 || containsFold(ctx.sheets, ref.Value) || containsFold(created, ref.Value) {
+			return nil
+		}
+		return unknown(fmt.Sprintf("Sheet %q does not exist in the workbook", ref.Value),
+			suggestNames(ref.Value, append(append([]string{}, ctx.sheets...), created...)))
+
+	case RefTable:
+		if len(ctx.tables) == 0 || containsFold(ctx.tables, ref.Value) || containsFold(created, ref.Value) {
+			return nil
+		}
+		return unknown(fmt.Sprintf("Table %q is not an Excel Table of the workbook", ref.Value), suggestNames(ref.Value, ctx.tables))
+
+	case RefHeader:
+		if len(ctx.headers) == 0 || containsFold(ctx.headers, ref.Value) {
+			return nil
+		}
+		return unknown(fmt.Sprintf("Header %q is not a column of the data", ref.Value), suggestNames(ref.Value, ctx.headers))
+
+	case RefColumn:
+		col := columnNumber(ref.Value) - 1
+		if ref.Write || ctx.maxCol < 0 || (col >= ctx.minCol && col <= ctx.maxCol) {
+			return nil
+		}
+		return unknown(fmt.Sprintf("Column %s is outside the data columns %s:%s", strings.ToUpper(ref.Value),
+			columnLetters(ctx.minCol), columnLetters(ctx.maxCol)), ctx.nearestColumns(col))
+
+	case RefRange:
+		area, ok, err := parseA1(ref.Value)
+		if err != nil {
+			return unknown(fmt.Sprintf("Range %q is not a valid address: %v", ref.Value, err), nil)
+		}
+		if !ok || ref.Write || ctx.maxCol < 0 || area.StartCol < 0 {
+			return nil
+		}
+		if area.Sheet != "" && len(ctx.sheets) > 0 && !containsFold(ctx.sheets, area.Sheet) && !containsFold(created, area.Sheet) {
+			return unknown(fmt.Sprintf("Range %q refers to sheet %q, which does not exist", ref.Value, area.Sheet),
+				suggestNames(area.Sheet, ctx.sheets))
+		}
+		if area.StartCol > ctx.maxCol || area.EndCol < ctx.minCol {
+			return unknown(fmt.Sprintf("Range %q does not overlap the data columns %s:%s", ref.Value,
+				columnLetters(ctx.minCol), columnLetters(ctx.maxCol)), ctx.nearestColumns(area.StartCol))
+		}
+	}
+	return nil
+}
+
+// suggestNames returns the candidates closest to value by edit distance
+func suggestNames(value string, candidates []string) []string {
+	type scored struct {
+		name     string
+		distance int
+	}
+
+	target := strings.ToLower(strings.TrimSpace(value))
+	limit := len([]rune(target))/3 + 2
+
+	var matches []scored
+	seen := map[string]bool{}
+	for _, candidate := range candidates {
+		key := strings.ToLower(strings.TrimSpace(candidate))
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		distance := levenshtein(target, key)
+		if strings.Contains(key, target) || strings.Contains(target, key) {
+			distance = min(distance, 1)
+		}
+		if distance <= limit {
+			matches = append(matches, scored{candidate, distance})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].distance < matches[j].distance })
+	suggestions := []string{}
+	for i := 0; i < len(matches) && i < maxReferenceSuggested; i++ {
+		suggestions = append(suggestions, matches[i].name)
+	}
+	return suggestions
+}
+
+// levenshtein returns the edit distance between two strings
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// createdSheetNames returns the names the code assigns to sheets (ws.Name = "Report")
+func createdSheetNames(module *vba.Module) []string {
+	var names []string
+	vba.Inspect(module, func(n vba.Node) bool {
+		assign, ok := n.(*vba.AssignStmt)
+		if !ok {
+			return true
+		}
+		if member, ok := assign.Target.(*vba.MemberExpr); ok && strings.EqualFold(member.Name, "Name") {
+			if lit := stringLiteral(assign.Value); lit != nil {
+				names = append(names, lit.Value)
+			}
+		}
+		return true
+	})
+	return names
+}
+
+// stringLiteral returns expr as a string literal, or nil
+func stringLiteral(expr vba.Expr) *vba.Literal {
+	if paren, ok := expr.(*vba.ParenExpr); ok {
+		expr = paren.X
+	}
+	if lit, ok := expr.(*vba.Literal); ok && lit.Kind == vba.LitString {
+		return lit
+	}
+	return nil
+}
+
+// leadingLiteral returns the string literal at the start of a concatenation
+// ("A2:A" & lastRow), or nil
+func leadingLiteral(expr vba.Expr) *vba.Literal {
+	for {
+		if bin, ok := expr.(*vba.BinaryExpr); ok && (bin.Op == "&" || bin.Op == "+") {
+			expr = bin.X
+			continue
+		}
+		return stringLiteral(expr)
+	}
+}
+
+// literalRef creates a reference located at a literal. The span excludes the
+// quotes so editors underline the referenced text itself.
+func literalRef(kind ReferenceKind, value string, lit *vba.Literal, write bool) Reference {
+	start, end := lit.Pos(), lit.End()
+	if lit.Kind == vba.LitString {
+		start.Column++
+		start.Offset++
+		end.Column--
+		end.Offset--
+	}
+	return Reference{
+		Kind:      kind,
+		Value:     value,
+		Write:     write,
+		Line:      start.Line,
+		Column:    start.Column,
+		EndLine:   end.Line,
+		EndColumn: end.Column,
+	}
+}
+
+// columnPrefixPattern extracts the column letters of a partial address ("A2:A")
+var columnPrefixPattern = regexp.MustCompile(`\$?([A-Za-z]{1,3})\$?[0-9]*`)
+
+// columnOnlyPattern matches bare column letters ("C", "$AB")
+var columnOnlyPattern = regexp.MustCompile(`^\s*\$?[A-Za-z]{1,3}\s*$`)
+
+// extractReferences walks the module and collects references in source order
+func extractReferences(module *vba.Module, headerRow int) []Reference {
+	var refs []Reference
+
+	writes := map[vba.Node]bool{}
+	vba.Inspect(module, func(n vba.Node) bool {
+		if assign, ok := n.(*vba.AssignStmt); ok {
+			vba.Inspect(assign.Target, func(t vba.Node) bool {
+				writes[t] = true
+				return true
+			})
+		}
+		return true
+	})
+
+	addRange := func(lit *vba.Literal, complete bool, write bool) {
+		for _, part := range strings.Split(lit.Value, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			if complete {
+				if area, ok, err := parseA1(part); ok || err != nil {
+					if area.Sheet != "" {
+						refs = append(refs, literalRef(RefSheet, area.Sheet, lit, write))
+					}
+					refs = append(refs, literalRef(RefRange, part, lit, write))
+				}
+				continue
+			}
+			// Partial address built by concatenation: only the columns are known
+			if i := strings.LastIndex(part, "!"); i >= 0 {
+				part = part[i+1:]
+			}
+			for _, m := range columnPrefixPattern.FindAllStringSubmatch(part, -1) {
+				refs = append(refs, literalRef(RefColumn, strings.ToUpper(m[1]), lit, write))
+			}
+		}
+	}
+
+	vba.Inspect(module, func(n vba.Node) bool {
+		switch node := n.(type) {
+		case *vba.CallExpr:
+			name := callName(node.Fun)
+			write := writes[node]
+			switch {
+			case name == "sheets" || name == "worksheets":
+				if len(node.Args) > 0 {
+					if lit := stringLiteral(node.Args[0].Value); lit != nil {
+						refs = append(refs, literalRef(RefSheet, lit.Value, lit, write))
+					}
+				}
+
+			case name == "listobjects":
+				if len(node.Args) > 0 {
+					if lit := stringLiteral(node.Args[0].Value); lit != nil {
+						refs = append(refs, literalRef(RefTable, lit.Value, lit, write))
+					}
+				}
+
+			case name == "listcolumns":
+				if len(node.Args) > 0 {
+					if lit := stringLiteral(node.Args[0].Value); lit != nil {
+						refs = append(refs, literalRef(RefHeader, lit.Value, lit, false))
+					}
+				}
+
+			case name == "range":
+				for _, arg := range node.Args {
+					if lit := stringLiteral(arg.Value); lit != nil {
+						addRange(lit, true, write)
+					} else if lit := leadingLiteral(arg.Value); lit != nil {
+						addRange(lit, false, write)
+					}
+				}
+
+			case name == "columns":
+				if len(node.Args) == 1 {
+					if lit := stringLiteral(node.Args[0].Value); lit != nil {
+						for _, letters := range strings.Split(lit.Value, ":") {
+							if columnOnlyPattern.MatchString(letters) {
+								refs = append(refs, literalRef(RefColumn, strings.ToUpper(strings.Trim(letters, "$ ")), lit, write))
+							}
+						}
+					}
+				}
+
+			case name == "cells":
+				if len(node.Args) == 2 {
+					if lit := stringLiteral(node.Args[1].Value); lit != nil && columnOnlyPattern.MatchString(lit.Value) {
+						refs = append(refs, literalRef(RefColumn, strings.ToUpper(strings.Trim(lit.Value, "$ ")), lit, write))
+					}
+				}
+
+			case name == "match" || name == "xmatch":
+				if len(node.Args) >= 2 && isHeaderAccess(node.Args[1].Value, headerRow) {
+					if lit := stringLiteral(node.Args[0].Value); lit != nil {
+						refs = append(refs, literalRef(RefHeader, lit.Value, lit, false))
+					}
+				}
+
+			case name == "find":
+				if member, ok := node.Fun.(*vba.MemberExpr); ok && len(node.Args) > 0 && isHeaderAccess(member.X, headerRow) {
+					if lit := stringLiteral(node.Args[0].Value); lit != nil {
+						refs = append(refs, literalRef(RefHeader, lit.Value, lit, false))
+					}
+				}
+
+			case isHeaderLookupName(name):
+				for _, arg := range node.Args {
+					if lit := stringLiteral(arg.Value); lit != nil && lit.Value != "" {
+						refs = append(refs, literalRef(RefHeader, lit.Value, lit, false))
+					}
+				}
+			}
+
+		case *vba.BinaryExpr:
+			if node.Op != "=" && node.Op != "<>" {
+				return true
+			}
+			if lit := stringLiteral(node.Y); lit != nil && lit.Value != "" && isHeaderAccess(node.X, headerRow) {
+				refs = append(refs, literalRef(RefHeader, lit.Value, lit, false))
+			} else if lit := stringLiteral(node.X); lit != nil && lit.Value != "" && isHeaderAccess(node.Y, headerRow) {
+				refs = append(refs, literalRef(RefHeader, lit.Value, lit, false))
+			}
+		}
+		return true
+	})
+
+	sort.SliceStable(refs, func(i, j int) bool {
+		if refs[i].Line != refs[j].Line {
+			return refs[i].Line < refs[j].Line
+		}
+		return refs[i].Column < refs[j].Column
+	})
+	return refs
+}
+
+// isHeaderLookupName reports whether a user procedure name looks like a
+// column lookup helper, e.g. GetColumnIndex or FindHeader
+func isHeaderLookupName(name string) bool {
+	if name == "columns" || name == "column" {
+		return false
+	}
+	return strings.Contains(name, "header") || strings.Contains(name, "column")
+}
+
+// isHeaderAccess reports whether expr reads the header row: a variable named
+// like a header, Rows(1), or Cells on the header row
+func isHeaderAccess(expr vba.Expr, headerRow int) bool {
+	for expr != nil {
+		switch e := expr.(type) {
+		case *vba.Ident:
+			return strings.Contains(strings.ToLower(e.Name), "header")
+		case *vba.MemberExpr:
+			if strings.Contains(strings.ToLower(e.Name), "header") {
+				return true
+			}
+			expr = e.X
+		case *vba.CallExpr:
+			name := callName(e.Fun)
+			if strings.Contains(name, "header") {
+				return true
+			}
+			if (name == "rows" || name == "cells") && len(e.Args) > 0 {
+				if lit, ok := e.Args[0].Value.(*vba.Literal); ok && lit.Kind == vba.LitInteger && lit.Value == strconv.Itoa(headerRow) {
+					return true
+				}
+			}
+			expr = e.Fun
+		case *vba.ParenExpr:
+			expr = e.X
+		default:
+			return false
+		}
+	}
+	return false
+}
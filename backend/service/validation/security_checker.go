@@ -0,0 +1,627 @@
+package validation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"excel-automation-mcp/backend/service/vba"
+)
+
+// RiskCategory groups security findings by the kind of damage they can cause
+type RiskCategory string
+
+const (
+	RiskProcessExecution RiskCategory = "process-execution" // Starts external programs
+	RiskFileSystem       RiskCategory = "file-system"       // Deletes or rewrites files outside the workbook
+	RiskRegistry         RiskCategory = "registry"          // Reads or writes the Windows registry
+	RiskNetwork          RiskCategory = "network"           // Sends or downloads data over the network
+	RiskNativeCode       RiskCategory = "native-code"       // Calls Win32 APIs directly
+	RiskUIAutomation     RiskCategory = "ui-automation"     // Drives other applications through keystrokes
+	RiskCodeInjection    RiskCategory = "code-injection"    // Modifies or executes VBA code at runtime
+	RiskDataDestruction  RiskCategory = "data-destruction"  // Irreversibly clears workbook contents
+)
+
+// Security rule identifiers
+const (
+	RuleShell          = "shell"
+	RuleWScriptShell   = "wscript-shell"
+	RuleMacScript      = "mac-script"
+	RuleKill           = "kill"
+	RuleRmDir          = "rmdir"
+	RuleFSODelete      = "fso-delete"
+	RuleFSOAccess      = "fso-access"
+	RuleRegistry       = "registry"
+	RuleAppSettings    = "app-settings"
+	RuleNetworkObject  = "network-object"
+	RuleBinaryStream   = "binary-stream"
+	RuleMailAutomation = "mail-automation"
+	RuleWin32Declare   = "win32-declare"
+	RuleSendKeys       = "sendkeys"
+	RuleVBProject      = "vbproject-access"
+	RuleExcel4Macro    = "excel4-macro"
+	RuleSheetWipe      = "sheet-wipe"
+	RuleWMI            = "wmi"
+	RuleDynamicObject  = "dynamic-object"
+	RuleCallByName     = "call-by-name"
+	RuleApplicationRun = "application-run"
+	RuleUnparsed       = "unparsed-code"
+)
+
+// SourceSecurity identifies diagnostics produced by the security checker
+const SourceSecurity = "security"
+
+// SecurityRule describes a risky construct and why it is risky
+type SecurityRule struct {
+	ID        string       `json:"id"`
+	Category  RiskCategory `json:"category"`
+	Score     int          `json:"score"` // 0-100, higher is more dangerous
+	Rationale string       `json:"rationale"`
+}
+
+// securityRules is the catalogue of rules checked by the scanner
+var securityRules = map[string]SecurityRule{
+	RuleShell: {RuleShell, RiskProcessExecution, 90,
+		"Shell starts an arbitrary external program with the user's privileges"},
+	RuleWScriptShell: {RuleWScriptShell, RiskProcessExecution, 90,
+		"WScript.Shell and Shell.Application can run commands, write the registry and launch programs"},
+	RuleMacScript: {RuleMacScript, RiskProcessExecution, 90,
+		"MacScript and AppleScriptTask run AppleScript or shell commands on macOS"},
+	RuleKill: {RuleKill, RiskFileSystem, 70,
+		"Kill permanently deletes files without sending them to the Recycle Bin"},
+	RuleRmDir: {RuleRmDir, RiskFileSystem, 60,
+		"RmDir removes directories from disk"},
+	RuleFSODelete: {RuleFSODelete, RiskFileSystem, 75,
+		"FileSystemObject.DeleteFile/DeleteFolder permanently delete files and folders, including with wildcards"},
+	RuleFSOAccess: {RuleFSOAccess, RiskFileSystem, 30,
+		"FileSystemObject grants unrestricted access to the file system"},
+	RuleRegistry: {RuleRegistry, RiskRegistry, 80,
+		"RegWrite/RegDelete change the Windows registry and can alter system or security settings"},
+	RuleAppSettings: {RuleAppSettings, RiskRegistry, 20,
+		"SaveSetting/DeleteSetting write to the VBA section of the registry"},
+	RuleNetworkObject: {RuleNetworkObject, RiskNetwork, 70,
+		"HTTP and browser objects can send workbook data to remote servers or download content"},
+	RuleBinaryStream: {RuleBinaryStream, RiskFileSystem, 50,
+		"ADODB.Stream writes arbitrary binary files and is commonly used to drop downloaded executables"},
+	RuleMailAutomation: {RuleMailAutomation, RiskNetwork, 50,
+		"Automating Outlook can send e-mail on the user's behalf"},
+	RuleWin32Declare: {RuleWin32Declare, RiskNativeCode, 60,
+		"Declare statements call Win32 APIs directly, bypassing VBA's safety checks"},
+	RuleSendKeys: {RuleSendKeys, RiskUIAutomation, 50,
+		"SendKeys sends keystrokes to whichever window has focus, which may not be Excel"},
+	RuleVBProject: {RuleVBProject, RiskCodeInjection, 90,
+		"Accessing VBProject lets a macro rewrite itself or other projects and requires trusting access to the VBA object model"},
+	RuleExcel4Macro: {RuleExcel4Macro, RiskCodeInjection, 70,
+		"ExecuteExcel4Macro runs legacy XLM macro code, a common malware technique"},
+	RuleSheetWipe: {RuleSheetWipe, RiskDataDestruction, 60,
+		"Clearing or deleting every cell of a worksheet destroys data that cannot be restored after saving"},
+	RuleWMI: {RuleWMI, RiskProcessExecution, 85,
+		"WMI (winmgmts: monikers, SWbemLocator) can start processes and read or change system configuration"},
+	RuleDynamicObject: {RuleDynamicObject, RiskCodeInjection, 60,
+		"CreateObject/GetObject with a ProgID built at runtime hides which component is created"},
+	RuleCallByName: {RuleCallByName, RiskCodeInjection, 50,
+		"CallByName invokes a member whose name may only be known at runtime, hiding what is called"},
+	RuleApplicationRun: {RuleApplicationRun, RiskCodeInjection, 50,
+		"Application.Run calls any macro by name, including macros in other workbooks and add-ins"},
+	RuleUnparsed: {RuleUnparsed, RiskCodeInjection, 50,
+		"Part of the code could not be parsed, so risky calls in it may have been missed"},
+}
+
+// SecurityRules returns the rule catalogue sorted by descending score
+func SecurityRules() []SecurityRule {
+	rules := make([]SecurityRule, 0, len(securityRules))
+	for _, rule := range securityRules {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Score != rules[j].Score {
+			return rules[i].Score > rules[j].Score
+		}
+		return rules[i].ID < rules[j].ID
+	})
+	return rules
+}
+
+// progIDFamilies maps lower-case prefixes of COM ProgIDs and class names to
+// rules. A prefix covers the versioned ProgIDs of a family, such as
+// MSXML2.ServerXMLHTTP.6.0 or WinHttp.WinHttpRequest.5.1.
+var progIDFamilies = []struct {
+	prefix string
+	rule   string
+}{
+	{"wscript.shell", RuleWScriptShell},
+	{"shell.application", RuleWScriptShell},
+	{"wshshell", RuleWScriptShell},
+	{"iwshruntimelibrary.wshshell", RuleWScriptShell},
+	{"scripting.filesystemobject", RuleFSOAccess},
+	{"filesystemobject", RuleFSOAccess},
+	{"msxml2.xmlhttp", RuleNetworkObject},
+	{"msxml2.serverxmlhttp", RuleNetworkObject},
+	{"microsoft.xmlhttp", RuleNetworkObject},
+	{"xmlhttp", RuleNetworkObject},
+	{"serverxmlhttp", RuleNetworkObject},
+	{"winhttp.winhttprequest", RuleNetworkObject},
+	{"winhttprequest", RuleNetworkObject},
+	{"internetexplorer.application", RuleNetworkObject},
+	{"adodb.stream", RuleBinaryStream},
+	{"outlook.application", RuleMailAutomation},
+	{"winmgmts:", RuleWMI},
+	{"wbemscripting.", RuleWMI},
+	{"swbemlocator", RuleWMI},
+}
+
+// progIDRule returns the rule of a ProgID, class name or moniker, ignoring
+// case and surrounding spaces
+func progIDRule(progID string) (string, bool) {
+	progID = strings.ToLower(strings.TrimSpace(progID))
+	for _, family := range progIDFamilies {
+		if strings.HasPrefix(progID, family.prefix) {
+			return family.rule, true
+		}
+	}
+	return "", false
+}
+
+// callRules maps lower-case procedure names to rules
+var callRules = map[string]string{
+	"shell":              RuleShell,
+	"macscript":          RuleMacScript,
+	"applescripttask":    RuleMacScript,
+	"kill":               RuleKill,
+	"rmdir":              RuleRmDir,
+	"deletefile":         RuleFSODelete,
+	"deletefolder":       RuleFSODelete,
+	"regwrite":           RuleRegistry,
+	"regdelete":          RuleRegistry,
+	"savesetting":        RuleAppSettings,
+	"deletesetting":      RuleAppSettings,
+	"sendkeys":           RuleSendKeys,
+	"executeexcel4macro": RuleExcel4Macro,
+}
+
+// dangerousLibraries raise the score of Declare statements for APIs that run or download code
+var dangerousLibraries = map[string]bool{
+	"urlmon": true, "wininet": true, "shell32": true, "advapi32": true,
+}
+
+// sheetWipeMethods are destructive methods that, called on one of the
+// wholeSheetRanges, affect a whole sheet
+var sheetWipeMethods = map[string]bool{
+	"delete": true, "clear": true, "clearcontents": true,
+}
+
+// wholeSheetRanges are the properties that return every cell of a sheet
+var wholeSheetRanges = map[string]bool{
+	"cells": true, "usedrange": true, "rows": true, "columns": true,
+}
+
+// SecurityFinding is a risky construct found in generated code
+type SecurityFinding struct {
+	Rule      string       `json:"rule"`
+	Category  RiskCategory `json:"category"`
+	Score     int          `json:"score"`
+	Rationale string       `json:"rationale"`
+	Line      int          `json:"line"`
+	Column    int          `json:"column"`
+	EndLine   int          `json:"endLine"`
+	EndColumn int          `json:"endColumn"`
+	Snippet   string       `json:"snippet"`
+	Action    PolicyAction `json:"action"` // Set by the policy
+}
+
+// SecurityReport is the result of scanning code and applying a policy
+type SecurityReport struct {
+	Findings  []SecurityFinding `json:"findings"`
+	RiskScore int               `json:"riskScore"`
+	RiskLevel string            `json:"riskLevel"`
+	Decision  PolicyAction      `json:"decision"`
+	Reasons   []string          `json:"reasons"`
+	Policy    string            `json:"policy"`
+}
+
+// Blocked reports whether the policy forbids showing the code
+func (r *SecurityReport) Blocked() bool {
+	return r.Decision == PolicyDeny
+}
+
+// NeedsConfirmation reports whether the user must confirm before the code is shown
+func (r *SecurityReport) NeedsConfirmation() bool {
+	return r.Decision == PolicyConfirm
+}
+
+// Diagnostics converts the findings into editor diagnostics: denied
+// findings are errors, findings that need confirmation are warnings and
+// allowed findings are informational
+func (r *SecurityReport) Diagnostics() *Report {
+	report := NewReport()
+	for _, finding := range r.Findings {
+		severity := SeverityInfo
+		switch finding.Action {
+		case PolicyDeny:
+			severity = SeverityError
+		case PolicyConfirm:
+			severity = SeverityWarning
+		}
+		report.Add(Diagnostic{
+			Code:      finding.Rule,
+			Severity:  severity,
+			Message:   fmt.Sprintf("%s (risk %d)", finding.Rationale, finding.Score),
+			Line:      finding.Line,
+			Column:    finding.Column,
+			EndLine:   finding.EndLine,
+			EndColumn: finding.EndColumn,
+			Source:    SourceSecurity,
+		})
+	}
+	report.Sort()
+	return report
+}
+
+// riskLevel maps a score to a human readable level
+func riskLevel(score int) string {
+	switch {
+	case score == 0:
+		return "none"
+	case score < 30:
+		return "low"
+	case score < 60:
+		return "medium"
+	case score < 80:
+		return "high"
+	default:
+		return "critical"
+	}
+}
+
+// SecurityChecker scans VBA code for risky operations and applies a policy
+type SecurityChecker struct {
+	Policy *SecurityPolicy
+}
+
+// NewSecurityChecker creates a checker; a nil policy uses DefaultSecurityPolicy
+func NewSecurityChecker(policy *SecurityPolicy) *SecurityChecker {
+	if policy == nil {
+		policy = DefaultSecurityPolicy()
+	}
+	return &SecurityChecker{Policy: policy}
+}
+
+// Check scans a VBA module and evaluates the findings against the policy
+func (c *SecurityChecker) Check(code string) *SecurityReport {
+	findings := ScanSecurity(code)
+	return c.report(findings)
+}
+
+// CheckResponse scans the VBA code blocks of an LLM response. Positions are
+// relative to the response text.
+func (c *SecurityChecker) CheckResponse(response string) *SecurityReport {
+	var findings []SecurityFinding
+	for _, block := range ExtractVBABlocks(response) {
+		for _, finding := range ScanSecurity(block.Code) {
+			finding.Line += block.StartLine - 1
+			finding.EndLine += block.StartLine - 1
+			findings = append(findings, finding)
+		}
+	}
+	return c.report(findings)
+}
+
+// Gate checks code before it is shown to the user. It returns ErrCodeBlocked
+// when the policy denies the code; callers must check NeedsConfirmation on
+// the report for code that requires confirmation.
+func (c *SecurityChecker) Gate(code string) (*SecurityReport, error) {
+	report := c.Check(code)
+	if report.Blocked() {
+		return report, fmt.Errorf("%w: %s", ErrCodeBlocked, strings.Join(report.Reasons, "; "))
+	}
+	return report, nil
+}
+
+// report builds a SecurityReport and applies the policy
+func (c *SecurityChecker) report(findings []SecurityFinding) *SecurityReport {
+	if findings == nil {
+		findings = []SecurityFinding{}
+	}
+
+	report := &SecurityReport{Findings: findings, Policy: c.Policy.Name}
+	for _, finding := range findings {
+		if finding.Score > report.RiskScore {
+			report.RiskScore = finding.Score
+		}
+	}
+	report.RiskLevel = riskLevel(report.RiskScore)
+	report.Decision, report.Reasons = c.Policy.Evaluate(findings)
+	return report
+}
+
+// ScanSecurity returns every risky construct in a VBA module, in source order.
+// Text the parser could not structure is scanned token by token as well,
+// and a module with parse errors is reported as unparsed code.
+func ScanSecurity(code string) []SecurityFinding {
+	module, errs := vba.ParseModule(code)
+	lines := strings.Split(strings.ReplaceAll(code, "\r\n", "\n"), "\n")
+
+	var findings []SecurityFinding
+	seen := map[string]bool{}
+	add := func(ruleID string, node vba.Node) {
+		rule := securityRules[ruleID]
+		start, end := node.Pos(), node.End()
+		key := fmt.Sprintf("%s:%d:%d", ruleID, start.Line, start.Column)
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+
+		snippet := ""
+		if start.Line >= 1 && start.Line <= len(lines) {
+			snippet = strings.TrimSpace(lines[start.Line-1])
+		}
+		findings = append(findings, SecurityFinding{
+			Rule:      rule.ID,
+			Category:  rule.Category,
+			Score:     rule.Score,
+			Rationale: rule.Rationale,
+			Line:      start.Line,
+			Column:    start.Column,
+			EndLine:   end.Line,
+			EndColumn: end.Column,
+			Snippet:   snippet,
+		})
+	}
+
+	// withs holds the objects of the enclosing With blocks, innermost last,
+	// so that .Clear inside With ws.Cells is checked as ws.Cells.Clear
+	var withs []vba.Expr
+	with := func() vba.Expr {
+		if len(withs) == 0 {
+			return nil
+		}
+		return withs[len(withs)-1]
+	}
+
+	var raw []*vba.RawStmt
+	var visit func(vba.Node) bool
+	visit = func(n vba.Node) bool {
+		switch node := n.(type) {
+		case *vba.WithStmt:
+			vba.Inspect(node.Object, visit)
+			withs = append(withs, qualify(node.Object, with()))
+			for _, stmt := range node.Body {
+				vba.Inspect(stmt, visit)
+			}
+			withs = withs[:len(withs)-1]
+			return false
+
+		case *vba.RawStmt:
+			raw = append(raw, node)
+
+		case *vba.DeclareStmt:
+			add(RuleWin32Declare, node)
+			if dangerousLibraries[strings.TrimSuffix(strings.ToLower(node.Lib), ".dll")] {
+				findings[len(findings)-1].Score = 85
+				findings[len(findings)-1].Rationale += fmt.Sprintf("; %s exposes APIs that download files or run programs", node.Lib)
+			}
+
+		case *vba.CallStmt:
+			checkCall(qualify(node.Target, with()), node.Args, node, add)
+
+		case *vba.CallExpr:
+			checkCall(qualify(node.Fun, with()), node.Args, node, add)
+
+		case *vba.MemberExpr:
+			switch strings.ToLower(node.Name) {
+			case "vbproject", "vbcomponents", "codemodule":
+				add(RuleVBProject, node)
+			}
+
+		case *vba.NewExpr:
+			if rule, ok := progIDRule(node.Type); ok {
+				add(rule, node)
+			}
+
+		case *vba.TypeRef:
+			if rule, ok := progIDRule(node.Name); ok && node.New {
+				add(rule, node)
+			}
+		}
+		return true
+	}
+	vba.Inspect(module, visit)
+
+	// The token scan only adds what the syntax tree scan did not find on
+	// the same line
+	found := map[string]bool{}
+	for _, finding := range findings {
+		found[fmt.Sprintf("%s:%d", finding.Rule, finding.Line)] = true
+	}
+	addToken := func(ruleID string, node vba.Node) {
+		if !found[fmt.Sprintf("%s:%d", ruleID, node.Pos().Line)] {
+			add(ruleID, node)
+		}
+	}
+	for _, stmt := range raw {
+		scanTokens(stmt.Tokens, addToken)
+	}
+	if len(errs) > 0 {
+		// Bad statements and text skipped after a syntax error are not in
+		// the tree at all
+		tokens, _ := vba.Tokenize(code)
+		scanTokens(tokens, addToken)
+		add(RuleUnparsed, vba.Span{Start: errs[0].Pos, Stop: errs[0].End})
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Line != findings[j].Line {
+			return findings[i].Line < findings[j].Line
+		}
+		return findings[i].Column < findings[j].Column
+	})
+	return findings
+}
+
+// checkCall matches a call target against the call, ProgID and sheet-wipe rules
+func checkCall(target vba.Expr, args []*vba.Arg, node vba.Node, add func(string, vba.Node)) {
+	name := callName(target)
+
+	if rule, ok := callRules[name]; ok {
+		add(rule, node)
+		return
+	}
+
+	switch name {
+	case "createobject", "getobject":
+		if len(args) == 0 {
+			return
+		}
+		// GetObject(, "Class") attaches to a running instance by class
+		progID := args[0].Value
+		if progID == nil && name == "getobject" && len(args) > 1 {
+			progID = args[1].Value
+		}
+		if progID == nil {
+			return
+		}
+		value, constant := constantString(progID)
+		if !constant {
+			add(RuleDynamicObject, node)
+		} else if rule, ok := progIDRule(value); ok {
+			add(rule, node)
+		}
+		return
+	case "callbyname":
+		add(RuleCallByName, node)
+		return
+	case "run":
+		// Application.Run and Excel.Application.Run, not the Run method of other objects
+		if member, ok := target.(*vba.MemberExpr); ok && strings.EqualFold(callName(member.X), "application") {
+			add(RuleApplicationRun, node)
+			return
+		}
+	}
+
+	// Cells.Delete, ws.Cells.ClearContents, ActiveSheet.UsedRange.Clear, ws.Rows.Delete
+	if member, ok := target.(*vba.MemberExpr); ok && sheetWipeMethods[strings.ToLower(member.Name)] {
+		owner := ""
+		switch x := member.X.(type) {
+		case *vba.MemberExpr:
+			owner = x.Name
+		case *vba.Ident:
+			owner = x.Name
+		}
+		if wholeSheetRanges[strings.ToLower(owner)] {
+			add(RuleSheetWipe, node)
+		}
+	}
+}
+
+// qualify resolves a member access that starts with a dot against the
+// object of the enclosing With block; other expressions are returned as
+// they are. The tree is not changed.
+func qualify(expr vba.Expr, with vba.Expr) vba.Expr {
+	if with == nil {
+		return expr
+	}
+	switch e := expr.(type) {
+	case *vba.MemberExpr:
+		qualified := *e
+		if e.X == nil {
+			qualified.X = with
+		} else {
+			qualified.X = qualify(e.X, with)
+		}
+		return &qualified
+	case *vba.CallExpr:
+		qualified := *e
+		qualified.Fun = qualify(e.Fun, with)
+		return &qualified
+	}
+	return expr
+}
+
+// scanTokens applies the call, ProgID and object model rules to bare
+// tokens. A CreateObject or GetObject call without a string among its first
+// tokens counts as a dynamic ProgID.
+func scanTokens(tokens []vba.Token, add func(string, vba.Node)) {
+	for i, token := range tokens {
+		span := vba.Span{Start: token.Pos, Stop: token.End}
+		switch {
+		case token.Kind == vba.TokenString:
+			value := strings.ReplaceAll(strings.Trim(token.Text, `"`), `""`, `"`)
+			if rule, ok := progIDRule(value); ok {
+				add(rule, span)
+			}
+			continue
+		case !token.IsWord():
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimSuffix(token.Text, "$"))
+		if rule, ok := callRules[name]; ok {
+			add(rule, span)
+			continue
+		}
+		if rule, ok := progIDRule(name); ok {
+			add(rule, span)
+			continue
+		}
+		switch name {
+		case "vbproject", "vbcomponents", "codemodule":
+			add(RuleVBProject, span)
+		case "callbyname":
+			add(RuleCallByName, span)
+		case "run":
+			if i >= 2 && tokens[i-1].Text == "." && tokens[i-2].Is("Application") {
+				add(RuleApplicationRun, span)
+			}
+		case "createobject", "getobject":
+			constant := false
+			for _, next := range tokens[i+1:] {
+				if next.Kind == vba.TokenString {
+					constant = true
+				}
+				if constant || next.Text == ")" || next.IsEndOfStatement() {
+					break
+				}
+			}
+			if !constant {
+				add(RuleDynamicObject, span)
+			}
+		}
+	}
+}
+
+// constantString folds string literals joined with & or + into their value;
+// it reports false for anything computed at runtime
+func constantString(expr vba.Expr) (string, bool) {
+	switch e := expr.(type) {
+	case *vba.Literal:
+		return e.Value, e.Kind == vba.LitString
+	case *vba.ParenExpr:
+		return constantString(e.X)
+	case *vba.BinaryExpr:
+		if e.Op != "&" && e.Op != "+" {
+			return "", false
+		}
+		x, ok := constantString(e.X)
+		if !ok {
+			return "", false
+		}
+		y, ok := constantString(e.Y)
+		return x + y, ok
+	}
+	return "", false
+}
+
+// callName returns the lower-case procedure or method name of a call target.
+// Library qualifiers such as VBA.Shell or Application.SendKeys are ignored.
+func callName(target vba.Expr) string {
+	switch t := target.(type) {
+	case *vba.Ident:
+		return strings.ToLower(strings.TrimSuffix(t.Name, "$"))
+	case *vba.MemberExpr:
+		return strings.ToLower(t.Name)
+	case *vba.CallExpr:
+		return callName(t.Fun)
+	}
+	return ""
+}
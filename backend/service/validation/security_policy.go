@@ -0,0 +1,164 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PolicyAction is the outcome of evaluating a finding against a policy
+type PolicyAction string
+
+const (
+	PolicyAllow   PolicyAction = "allow"   // Show the code without interruption
+	PolicyConfirm PolicyAction = "confirm" // Ask the user before showing the code
+	PolicyDeny    PolicyAction = "deny"    // Never show the code
+)
+
+// actionRank orders actions from least to most restrictive
+var actionRank = map[PolicyAction]int{PolicyAllow: 0, PolicyConfirm: 1, PolicyDeny: 2}
+
+// ErrCodeBlocked is returned when the security policy denies generated code
+var ErrCodeBlocked = errors.New("generated code blocked by security policy")
+
+// SecurityPolicy decides which findings block generated code or require
+// confirmation. Rule actions take precedence over category actions, which
+// take precedence over the score thresholds.
+type SecurityPolicy struct {
+	Name             string                        `json:"name"`
+	Rules            map[string]PolicyAction       `json:"rules,omitempty"`      // Rule ID -> action
+	Categories       map[RiskCategory]PolicyAction `json:"categories,omitempty"` // Category -> action
+	ConfirmThreshold int                           `json:"confirmThreshold"`     // Scores at or above require confirmation; 0 disables
+	DenyThreshold    int                           `json:"denyThreshold"`        // Scores at or above are denied; 0 disables
+}
+
+// DefaultSecurityPolicy asks for confirmation on medium risk and above and
+// denies self-modifying code
+func DefaultSecurityPolicy() *SecurityPolicy {
+	return &SecurityPolicy{
+		Name: "default",
+		Rules: map[string]PolicyAction{
+			RuleVBProject: PolicyDeny,
+		},
+		ConfirmThreshold: 50,
+	}
+}
+
+// LoadSecurityPolicy reads a policy from a JSON file shared by the team
+func LoadSecurityPolicy(path string) (*SecurityPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read security policy: %w", err)
+	}
+
+	policy := &SecurityPolicy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("failed to parse security policy %s: %w", path, err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid security policy %s: %w", path, err)
+	}
+	if policy.Name == "" {
+		policy.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	return policy, nil
+}
+
+// Save writes the policy as indented JSON, creating the directory if needed
+func (p *SecurityPolicy) Save(path string) error {
+	if err := p.Validate(); err != nil {
+		return fmt.Errorf("invalid security policy: %w", err)
+	}
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode security policy: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create policy directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write security policy: %w", err)
+	}
+	return nil
+}
+
+// Validate checks that every action, rule, category and threshold is known
+func (p *SecurityPolicy) Validate() error {
+	for rule, action := range p.Rules {
+		if _, ok := securityRules[rule]; !ok {
+			return fmt.Errorf("unknown rule %q", rule)
+		}
+		if _, ok := actionRank[action]; !ok {
+			return fmt.Errorf("unknown action %q for rule %s", action, rule)
+		}
+	}
+
+	categories := map[RiskCategory]bool{}
+	for _, rule := range securityRules {
+		categories[rule.Category] = true
+	}
+	for category, action := range p.Categories {
+		if !categories[category] {
+			return fmt.Errorf("unknown category %q", category)
+		}
+		if _, ok := actionRank[action]; !ok {
+			return fmt.Errorf("unknown action %q for category %s", action, category)
+		}
+	}
+
+	if p.ConfirmThreshold < 0 || p.ConfirmThreshold > 100 {
+		return fmt.Errorf("confirmThreshold must be between 0 and 100, got %d", p.ConfirmThreshold)
+	}
+	if p.DenyThreshold < 0 || p.DenyThreshold > 100 {
+		return fmt.Errorf("denyThreshold must be between 0 and 100, got %d", p.DenyThreshold)
+	}
+	return nil
+}
+
+// ActionFor returns the action the policy takes for a single finding
+func (p *SecurityPolicy) ActionFor(finding SecurityFinding) PolicyAction {
+	if action, ok := p.Rules[finding.Rule]; ok {
+		return action
+	}
+	if action, ok := p.Categories[finding.Category]; ok {
+		return action
+	}
+	if p.DenyThreshold > 0 && finding.Score >= p.DenyThreshold {
+		return PolicyDeny
+	}
+	if p.ConfirmThreshold > 0 && finding.Score >= p.ConfirmThreshold {
+		return PolicyConfirm
+	}
+	return PolicyAllow
+}
+
+// Evaluate sets the action of every finding and returns the most restrictive
+// action together with a reason for each finding that is not allowed
+func (p *SecurityPolicy) Evaluate(findings []SecurityFinding) (PolicyAction, []string) {
+	decision := PolicyAllow
+	reasons := []string{}
+
+	for i := range findings {
+		action := p.ActionFor(findings[i])
+		if findings[i].Rule == RuleUnparsed && action == PolicyAllow {
+			// Code the scanner could not read is never allowed unseen
+			action = PolicyConfirm
+		}
+		findings[i].Action = action
+
+		if actionRank[action] > actionRank[decision] {
+			decision = action
+		}
+		if action != PolicyAllow {
+			reasons = append(reasons, fmt.Sprintf("line %d: %s (%s, risk %d): %s",
+				findings[i].Line, findings[i].Snippet, findings[i].Rule, findings[i].Score, action))
+		}
+	}
+
+	return decision, reasons
+}
@@ -0,0 +1,483 @@
+package validation
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"excel-automation-mcp/backend/service/jetsql"
+	"excel-automation-mcp/backend/service/mcp"
+	"excel-automation-mcp/backend/service/vba"
+)
+
+// Diagnostic codes reported by the SQL checker
+const (
+	CodeSQLSyntax        = "sql-syntax-error"
+	CodeSQLUnknownTable  = "sql-unknown-table"
+	CodeSQLUnknownColumn = "sql-unknown-column"
+	CodeSQLInvalidRange  = "sql-invalid-range"
+	CodeSQLUnsupported   = "sql-unsupported"
+)
+
+// SourceSQL identifies diagnostics produced by the SQL checker
+const SourceSQL = "sql"
+
+// dynamicMarker stands in for parts of a query computed at run time, such as
+// "FROM [" & ws.Name & "$]". Identifiers containing it are never reported.
+const dynamicMarker = "__dynamic__"
+
+// sqlCalls are the SQLUtils procedures whose first argument is a SQL string
+var sqlCalls = map[string]bool{"getsql": true}
+
+// vbaWhitespace are the VBA constants commonly concatenated into SQL strings
+var vbaWhitespace = map[string]string{
+	"vbcrlf": "\r\n", "vbnewline": "\r\n", "vbcr": "\r", "vblf": "\n", "vbtab": "\t",
+}
+
+// unsupportedFunctions maps functions from other SQL dialects to their Jet equivalents
+var unsupportedFunctions = map[string]string{
+	"COALESCE":     "IIf(IsNull(x), y, x)",
+	"IFNULL":       "IIf(IsNull(x), y, x)",
+	"NVL":          "IIf(IsNull(x), y, x)",
+	"NZ":           "IIf(IsNull(x), y, x), since Nz is only available inside Access",
+	"GETDATE":      "Now()",
+	"CURRENT_DATE": "Date()",
+	"SUBSTRING":    "Mid()",
+	"SUBSTR":       "Mid()",
+	"CONCAT":       "the & operator",
+	"CHARINDEX":    "InStr()",
+	"LENGTH":       "Len()",
+	"UPPER":        "UCase()",
+	"LOWER":        "LCase()",
+	"CAST":         "CStr(), CDbl(), CLng() or CDate()",
+	"CONVERT":      "CStr(), CDbl(), CLng() or CDate()",
+	"TO_CHAR":      "Format()",
+	"DATE_FORMAT":  "Format()",
+}
+
+// SQLQuery is a SQL statement passed to getSQL, reconstructed from string
+// literals, concatenations and variables assigned earlier in the procedure
+type SQLQuery struct {
+	Text      string   `json:"text"`
+	Procedure string   `json:"procedure"`
+	Dynamic   bool     `json:"dynamic"` // Parts of the query are only known at run time
+	Line      int      `json:"line"`    // Position of the getSQL call
+	Column    int      `json:"column"`
+	Tables    []string `json:"tables"`
+	Columns   []string `json:"columns"`
+
+	segments []sqlSegment
+}
+
+// SQLReport lists the queries found in generated code and the problems in them
+type SQLReport struct {
+	Queries     []SQLQuery `json:"queries"`
+	Diagnostics *Report    `json:"diagnostics"`
+}
+
+// sqlSegment is a piece of a reconstructed query and the VBA expression it came from
+type sqlSegment struct {
+	start int          // Offset of the segment in the query text
+	text  string       // Contribution to the query text
+	lit   *vba.Literal // String literal the text was copied from, or nil
+	node  vba.Node     // Expression the text stands for
+}
+
+// CheckSQL finds the queries passed to getSQL in a VBA module, parses them as
+// Jet SQL and checks their tables and columns against the analyzed data ranges
+func CheckSQL(code string, ranges ...mcp.DataRange) *SQLReport {
+	module, _ := vba.ParseModule(code)
+	ctx := newSQLContext(ranges, createdSheetNames(module))
+
+	report := &SQLReport{Queries: []SQLQuery{}, Diagnostics: NewReport()}
+	for _, query := range extractSQL(module) {
+		ctx.check(&query, report.Diagnostics)
+		report.Queries = append(report.Queries, query)
+	}
+	report.Diagnostics.Sort()
+	return report
+}
+
+// ExtractSQL returns the queries passed to getSQL in a VBA module
+func ExtractSQL(code string) []SQLQuery {
+	module, _ := vba.ParseModule(code)
+	return extractSQL(module)
+}
+
+// extractSQL evaluates the string assignments of every procedure in source
+// order and records the value of the first argument of each getSQL call
+func extractSQL(module *vba.Module) []SQLQuery {
+	var queries []SQLQuery
+
+	moduleEnv := map[string][]sqlSegment{}
+	for _, decl := range module.Declarations {
+		if c, ok := decl.(*vba.ConstStmt); ok {
+			defineConsts(moduleEnv, c)
+		}
+	}
+
+	for _, proc := range module.Procedures {
+		env := make(map[string][]sqlSegment, len(moduleEnv))
+		for name, value := range moduleEnv {
+			env[name] = value
+		}
+
+		visitStmts(proc.Body, nil, func(stmt vba.Stmt, _ []string) {
+			switch s := stmt.(type) {
+			case *vba.ConstStmt:
+				defineConsts(env, s)
+			case *vba.AssignStmt:
+				if ident, ok := s.Target.(*vba.Ident); ok && s.Keyword != "Set" {
+					env[strings.ToLower(ident.Name)] = evalString(s.Value, env)
+				}
+			case *vba.CallStmt:
+				if !sqlCalls[callName(s.Target)] || len(s.Args) == 0 || s.Args[0].Value == nil {
+					return
+				}
+				if query, ok := newSQLQuery(evalString(s.Args[0].Value, env)); ok {
+					query.Procedure = proc.Name
+					query.Line = s.Pos().Line
+					query.Column = s.Pos().Column
+					queries = append(queries, query)
+				}
+			}
+		})
+	}
+
+	return queries
+}
+
+func defineConsts(env map[string][]sqlSegment, stmt *vba.ConstStmt) {
+	for _, c := range stmt.Consts {
+		env[strings.ToLower(c.Name)] = evalString(c.Value, env)
+	}
+}
+
+// evalString reconstructs the text of a string expression. Parts that cannot
+// be known statically become dynamicMarker segments.
+func evalString(expr vba.Expr, env map[string][]sqlSegment) []sqlSegment {
+	switch e := expr.(type) {
+	case *vba.ParenExpr:
+		return evalString(e.X, env)
+	case *vba.BinaryExpr:
+		if e.Op == "&" {
+			left := evalString(e.X, env)
+			return append(left[:len(left):len(left)], evalString(e.Y, env)...)
+		}
+	case *vba.Literal:
+		if e.Kind == vba.LitString {
+			return []sqlSegment{{text: e.Value, lit: e, node: e}}
+		}
+		return []sqlSegment{{text: e.Value, node: e}}
+	case *vba.Ident:
+		name := strings.ToLower(e.Name)
+		if value, ok := env[name]; ok {
+			return value
+		}
+		if ws, ok := vbaWhitespace[name]; ok {
+			return []sqlSegment{{text: ws, node: e}}
+		}
+	}
+	return []sqlSegment{{text: dynamicMarker, node: expr}}
+}
+
+// newSQLQuery lays out the segments of a query. Values with no string literal
+// at all, such as a parameter passed through, are not queries we can check.
+func newSQLQuery(segments []sqlSegment) (SQLQuery, bool) {
+	var query SQLQuery
+	var text strings.Builder
+	literal := false
+
+	for _, seg := range segments {
+		seg.start = text.Len()
+		text.WriteString(seg.text)
+		query.segments = append(query.segments, seg)
+		if seg.lit != nil {
+			literal = true
+		}
+		if seg.text == dynamicMarker && seg.lit == nil {
+			query.Dynamic = true
+		}
+	}
+
+	query.Text = text.String()
+	query.Tables, query.Columns = sqlIdentifiers(query.Text)
+	return query, literal
+}
+
+// position maps a byte offset of the query text back to the VBA source. Text
+// from a string literal maps to the exact character; other segments map to
+// the start or end of the expression they came from.
+func (q *SQLQuery) position(offset int, end bool) vba.Pos {
+	if len(q.segments) == 0 {
+		return vba.Pos{Line: q.Line, Column: q.Column}
+	}
+
+	seg := q.segments[len(q.segments)-1]
+	for _, s := range q.segments {
+		if offset < s.start+len(s.text) || (end && offset == s.start+len(s.text)) {
+			seg = s
+			break
+		}
+	}
+
+	if seg.lit == nil {
+		if end {
+			return seg.node.End()
+		}
+		return seg.node.Pos()
+	}
+
+	// Walk the raw literal, where each quote in the value is written twice
+	pos := seg.lit.Pos()
+	pos.Column++
+	pos.Offset++
+	raw := seg.lit.Raw[1:]
+	for remaining := offset - seg.start; remaining > 0 && raw != ""; {
+		r, size := utf8.DecodeRuneInString(raw)
+		if r == '"' && strings.HasPrefix(raw[1:], `"`) {
+			raw = raw[2:]
+			pos.Offset += 2
+			pos.Column += 2
+		} else {
+			raw = raw[size:]
+			pos.Offset += size
+			pos.Column++
+		}
+		remaining -= size
+	}
+	return pos
+}
+
+// sqlContext is the workbook knowledge queries are checked against
+type sqlContext struct {
+	sheets  []string
+	created []string
+	headers map[string][]string // Lower-case sheet name -> headers; "" for ranges without a sheet
+}
+
+func newSQLContext(ranges []mcp.DataRange, created []string) *sqlContext {
+	ctx := &sqlContext{created: created, headers: map[string][]string{}}
+	for _, structure := range ranges {
+		if structure.SheetName != "" && !containsFold(ctx.sheets, structure.SheetName) {
+			ctx.sheets = append(ctx.sheets, structure.SheetName)
+		}
+		key := strings.ToLower(strings.TrimSpace(structure.SheetName))
+		ctx.headers[key] = append(ctx.headers[key], structure.Headers...)
+	}
+	return ctx
+}
+
+// sheetHeaders returns the headers known for a worksheet and whether any are known
+func (ctx *sqlContext) sheetHeaders(sheet string) ([]string, bool) {
+	headers := ctx.headers[strings.ToLower(strings.TrimSpace(sheet))]
+	if len(headers) == 0 {
+		headers = ctx.headers[""]
+	}
+	return headers, len(headers) > 0
+}
+
+// check parses one query and reports syntax errors, unsupported constructs and
+// unknown tables and columns
+func (ctx *sqlContext) check(query *SQLQuery, report *Report) {
+	add := func(code string, severity Severity, start, end int, message string) {
+		report.AddAt(SourceSQL, code, severity, query.position(start, false), query.position(end, true), message)
+	}
+
+	stmt, errs := jetsql.Parse(query.Text)
+	if len(errs) > 0 {
+		// A syntax error in a dynamic query may be caused by the text we could not see
+		severity := SeverityError
+		if query.Dynamic {
+			severity = SeverityWarning
+		}
+		for _, err := range errs {
+			add(CodeSQLSyntax, severity, err.Offset, err.End, "SQL syntax error: "+err.Msg)
+		}
+		return
+	}
+
+	scope := newSQLScope(stmt)
+	jetsql.Inspect(stmt, func(n jetsql.Node) bool {
+		switch node := n.(type) {
+		case *jetsql.TableRef:
+			ctx.checkTable(node, add)
+		case *jetsql.ColumnRef:
+			ctx.checkColumn(node, scope, add)
+		case *jetsql.FuncCall:
+			start, end := node.Span()
+			name := strings.ToUpper(node.Name)
+			if replacement, ok := unsupportedFunctions[name]; ok {
+				add(CodeSQLUnsupported, SeverityError, node.NamePos, node.NamePos+len(node.Name),
+					fmt.Sprintf("%s() is not a Jet SQL function; use %s", node.Name, replacement))
+			}
+			if node.Distinct {
+				add(CodeSQLUnsupported, SeverityError, start, end,
+					fmt.Sprintf("Jet SQL does not support %s(DISTINCT ...); select from a SELECT DISTINCT subquery instead", node.Name))
+			}
+		}
+		return true
+	})
+}
+
+type sqlAddFunc func(code string, severity Severity, start, end int, message string)
+
+func (ctx *sqlContext) checkTable(table *jetsql.TableRef, add sqlAddFunc) {
+	if table.Database != "" || strings.Contains(table.Name, dynamicMarker) || len(ctx.sheets) == 0 {
+		return
+	}
+
+	if table.Sheet == "" {
+		// Worksheets are only visible to the driver as [Name$]
+		if containsFold(ctx.sheets, table.Name) || containsFold(ctx.created, table.Name) {
+			add(CodeSQLUnknownTable, SeverityError, table.NamePos, table.NameEnd,
+				fmt.Sprintf("Worksheet tables need a trailing $: write [%s$]", table.Name))
+		}
+		return
+	}
+
+	if !containsFold(ctx.sheets, table.Sheet) && !containsFold(ctx.created, table.Sheet) {
+		message := fmt.Sprintf("Table [%s] refers to worksheet %q, which is not in the workbook", table.Name, table.Sheet)
+		if suggestions := suggestNames(table.Sheet, append(ctx.sheets, ctx.created...)); len(suggestions) > 0 {
+			message += "; did you mean " + strings.Join(quoteAll(suggestions), " or ") + "?"
+		}
+		add(CodeSQLUnknownTable, SeverityError, table.NamePos, table.NameEnd, message)
+	}
+
+	if table.Address != "" {
+		if _, ok, err := parseA1(table.Address); !ok || err != nil {
+			reason := "is not an A1 range"
+			if err != nil {
+				reason = err.Error()
+			}
+			add(CodeSQLInvalidRange, SeverityError, table.NamePos, table.NameEnd,
+				fmt.Sprintf("Range %q of table [%s] %s", table.Address, table.Name, reason))
+		}
+	}
+}
+
+func (ctx *sqlContext) checkColumn(col *jetsql.ColumnRef, scope *sqlScope, add sqlAddFunc) {
+	if strings.Contains(col.Name, dynamicMarker) || strings.Contains(col.Table, dynamicMarker) {
+		return
+	}
+	if col.Table == "" && containsFold(scope.aliases, col.Name) {
+		return
+	}
+
+	headers, known := ctx.tableHeaders(col.Table, scope)
+	if !known || isHeaderName(headers, col.Name) {
+		return
+	}
+
+	message := fmt.Sprintf("Column [%s] is not a header of the source data; Jet treats unknown names as parameters and fails with \"No value given for one or more required parameters\"", col.Name)
+	if suggestions := suggestNames(col.Name, headers); len(suggestions) > 0 {
+		message += "; did you mean " + strings.Join(quoteAll(suggestions), " or ") + "?"
+	}
+	add(CodeSQLUnknownColumn, SeverityError, col.NamePos, col.NameEnd, message)
+}
+
+// tableHeaders returns the headers a column may refer to: those of the
+// qualifying table, or of every worksheet in the query when unqualified
+func (ctx *sqlContext) tableHeaders(qualifier string, scope *sqlScope) ([]string, bool) {
+	if qualifier != "" {
+		if containsFold(scope.subqueries, qualifier) {
+			return nil, false
+		}
+		for _, table := range scope.tables {
+			if strings.EqualFold(table.Alias, qualifier) || strings.EqualFold(table.Name, qualifier) {
+				if table.Sheet == "" || table.Database != "" || strings.Contains(table.Name, dynamicMarker) {
+					return nil, false
+				}
+				return ctx.sheetHeaders(table.Sheet)
+			}
+		}
+		return nil, false
+	}
+
+	if len(scope.subqueries) > 0 {
+		return nil, false
+	}
+	var headers []string
+	for _, table := range scope.tables {
+		if table.Sheet == "" || table.Database != "" || strings.Contains(table.Name, dynamicMarker) {
+			return nil, false
+		}
+		sheetHeaders, ok := ctx.sheetHeaders(table.Sheet)
+		if !ok {
+			return nil, false
+		}
+		headers = append(headers, sheetHeaders...)
+	}
+	return headers, len(headers) > 0
+}
+
+// isHeaderName reports whether name refers to one of the headers. Jet
+// replaces dots in header names with #, and names columns F1, F2, ... when
+// the workbook is opened with HDR=NO.
+func isHeaderName(headers []string, name string) bool {
+	if containsFold(headers, name) || containsFold(headers, strings.ReplaceAll(name, "#", ".")) {
+		return true
+	}
+	if len(name) > 1 && (name[0] == 'F' || name[0] == 'f') {
+		if n, err := strconv.Atoi(name[1:]); err == nil && n >= 1 && n <= len(headers) {
+			return true
+		}
+	}
+	return false
+}
+
+// sqlScope holds the names a query defines for itself
+type sqlScope struct {
+	tables     []*jetsql.TableRef
+	subqueries []string // Aliases of (SELECT ...) tables
+	aliases    []string // Output column aliases, usable in ORDER BY and HAVING
+}
+
+func newSQLScope(stmt jetsql.Statement) *sqlScope {
+	scope := &sqlScope{}
+	jetsql.Inspect(stmt, func(n jetsql.Node) bool {
+		switch node := n.(type) {
+		case *jetsql.TableRef:
+			scope.tables = append(scope.tables, node)
+		case *jetsql.SubqueryTable:
+			if node.Alias != "" {
+				scope.subqueries = append(scope.subqueries, node.Alias)
+			}
+		case *jetsql.SelectStmt:
+			for _, item := range node.Columns {
+				if item.Alias != "" {
+					scope.aliases = append(scope.aliases, item.Alias)
+				}
+			}
+		}
+		return true
+	})
+	return scope
+}
+
+// sqlIdentifiers returns the tables and columns named by a query
+func sqlIdentifiers(text string) ([]string, []string) {
+	tables, columns := []string{}, []string{}
+	stmt, errs := jetsql.Parse(text)
+	if len(errs) > 0 {
+		return tables, columns
+	}
+	jetsql.Inspect(stmt, func(n jetsql.Node) bool {
+		switch node := n.(type) {
+		case *jetsql.TableRef:
+			tables = appendUnique(tables, node.Name)
+		case *jetsql.ColumnRef:
+			columns = appendUnique(columns, node.Name)
+		}
+		return true
+	})
+	return tables, columns
+}
+
+func appendUnique(values []string, value string) []string {
+	if containsFold(values, value) {
+		return values
+	}
+	return append(values, value)
+}
@@ -0,0 +1,64 @@
+package validation
+
+import (
+	"errors"
+	"strings"
+
+	"excel-automation-mcp/backend/service/jetsql"
+	"excel-automation-mcp/backend/service/mcp"
+)
+
+// SQLPreview is the result of dry-running one getSQL query against sample data
+type SQLPreview struct {
+	Query     SQLQuery       `json:"query"`
+	Result    *jetsql.Result `json:"result,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	Line      int            `json:"line,omitempty"` // Source position of the error
+	Column    int            `json:"column,omitempty"`
+	EndLine   int            `json:"endLine,omitempty"`
+	EndColumn int            `json:"endColumn,omitempty"`
+}
+
+// PreviewSQL runs every query generated code passes to getSQL over the sample
+// rows of the data ranges, so the result headers and rows can be shown before
+// the macro runs in Excel
+func PreviewSQL(code string, ranges ...mcp.DataRange) []SQLPreview {
+	db := jetsql.NewDatabase()
+	for _, structure := range ranges {
+		db.AddTable(jetsql.TableFromDataRange(structure))
+	}
+
+	previews := []SQLPreview{}
+	for _, query := range ExtractSQL(code) {
+		preview := SQLPreview{Query: query}
+		text := query.Text
+
+		// A sheet name computed at run time can only be the analyzed sheet
+		if len(ranges) == 1 && ranges[0].SheetName != "" {
+			text = strings.ReplaceAll(text, "["+dynamicMarker+"$", "["+ranges[0].SheetName+"$")
+		}
+
+		if strings.Contains(text, dynamicMarker) {
+			preview.Error = "The query is built from values only known when the macro runs and cannot be previewed"
+			previews = append(previews, preview)
+			continue
+		}
+
+		result, err := db.Query(text)
+		if err != nil {
+			preview.Error = err.Error()
+			var sqlErr jetsql.Error
+			if errors.As(err, &sqlErr) {
+				preview.Error = sqlErr.Msg
+				if text == query.Text {
+					start, end := query.position(sqlErr.Offset, false), query.position(sqlErr.End, true)
+					preview.Line, preview.Column = start.Line, start.Column
+					preview.EndLine, preview.EndColumn = end.Line, end.Column
+				}
+			}
+		}
+		preview.Result = result
+		previews = append(previews, preview)
+	}
+	return previews
+}
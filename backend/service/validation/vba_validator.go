@@ -0,0 +1,452 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"excel-automation-mcp/backend/service/vba"
+)
+
+// Diagnostic codes reported by the VBA validator
+const (
+	CodeSyntaxError           = "syntax-error"
+	CodeUnbalancedBlock       = "unbalanced-block"
+	CodeMisplacedStatement    = "misplaced-statement"
+	CodeNextMismatch          = "next-mismatch"
+	CodeUndefinedLabel        = "undefined-label"
+	CodeDuplicateLabel        = "duplicate-label"
+	CodeMissingOptionExplicit = "missing-option-explicit"
+	CodeUndeclaredVariable    = "undeclared-variable"
+	CodeDuplicateProcedure    = "duplicate-procedure"
+	CodeInvalidExit           = "invalid-exit"
+	CodeHandlerFallthrough    = "error-handler-fallthrough"
+	CodeUnreachableHandler    = "unreachable-error-handler"
+)
+
+// SourceVBA identifies diagnostics produced by the VBA validator
+const SourceVBA = "vba"
+
+// assignableBuiltins are statements that look like assignments to undeclared names
+var assignableBuiltins = map[string]bool{
+	"date": true, "time": true, "mid": true, "mid$": true, "midb": true, "error": true,
+}
+
+// VBAValidator performs static checks on generated VBA code
+type VBAValidator struct {
+	RequireOptionExplicit bool // Warn when a module does not declare Option Explicit
+	CheckUndeclared       bool // Report assignments to variables that are never declared
+}
+
+// NewVBAValidator creates a validator with every check enabled
+func NewVBAValidator() *VBAValidator {
+	return &VBAValidator{
+		RequireOptionExplicit: true,
+		CheckUndeclared:       true,
+	}
+}
+
+// ValidateVBA validates code with the default validator
+func ValidateVBA(code string) *Report {
+	return NewVBAValidator().Validate(code)
+}
+
+// Validate parses a VBA module and reports structural problems
+func (v *VBAValidator) Validate(code string) *Report {
+	report := NewReport()
+
+	module, errs := vba.ParseModule(code)
+	for _, err := range errs {
+		code := CodeSyntaxError
+		switch err.Kind {
+		case vba.ErrUnclosedBlock, vba.ErrUnmatchedClose:
+			code = CodeUnbalancedBlock
+		case vba.ErrMisplaced:
+			code = CodeMisplacedStatement
+		}
+		report.AddAt(SourceVBA, code, SeverityError, err.Pos, err.End, err.Msg)
+	}
+
+	v.checkModule(report, module)
+
+	report.Sort()
+	return report
+}
+
+// ValidateResponse validates the VBA code blocks of an LLM response. Positions
+// are relative to the response text.
+func (v *VBAValidator) ValidateResponse(response string) *Report {
+	report := NewReport()
+
+	for _, block := range ExtractVBABlocks(response) {
+		for _, d := range v.Validate(block.Code).Diagnostics {
+			d.Line += block.StartLine - 1
+			d.EndLine += block.StartLine - 1
+			report.Add(d)
+		}
+	}
+
+	report.Sort()
+	return report
+}
+
+// checkModule runs the module-level and per-procedure checks
+func (v *VBAValidator) checkModule(report *Report, module *vba.Module) {
+	if v.RequireOptionExplicit && len(module.Procedures) > 0 && !module.HasOption("Explicit") {
+		start := vba.Pos{Line: 1, Column: 1}
+		report.AddAt(SourceVBA, CodeMissingOptionExplicit, SeverityWarning, start, start,
+			"Module does not declare Option Explicit; undeclared variables will not be detected by the compiler")
+	}
+
+	checkDuplicateProcedures(report, module)
+
+	moduleNames := moduleScope(module)
+	for _, proc := range module.Procedures {
+		checkLabels(report, proc)
+		checkExits(report, proc)
+		checkNextVariables(report, proc)
+		checkErrorHandlers(report, proc)
+		if v.CheckUndeclared {
+			checkUndeclared(report, module, proc, moduleNames)
+		}
+	}
+}
+
+// endOf returns the position just after word starting at pos
+func endOf(pos vba.Pos, word string) vba.Pos {
+	return vba.Pos{
+		Offset: pos.Offset + len(word),
+		Line:   pos.Line,
+		Column: pos.Column + utf8.RuneCountInString(word),
+	}
+}
+
+// checkDuplicateProcedures reports procedures declared more than once.
+// Property Get/Let/Set may share a name with each other, but not twice with the same kind.
+func checkDuplicateProcedures(report *Report, module *vba.Module) {
+	seen := map[string][]*vba.Procedure{}
+
+	for _, proc := range module.Procedures {
+		key := strings.ToLower(proc.Name)
+		for _, previous := range seen[key] {
+			if proc.Kind.IsProperty() && previous.Kind.IsProperty() && proc.Kind != previous.Kind {
+				continue
+			}
+			report.AddAt(SourceVBA, CodeDuplicateProcedure, SeverityError, proc.NamePos, endOf(proc.NamePos, proc.Name),
+				fmt.Sprintf("Ambiguous name: %s is already declared as a %s on line %d", proc.Name, previous.Kind, previous.NamePos.Line))
+			break
+		}
+		seen[key] = append(seen[key], proc)
+	}
+}
+
+// checkLabels reports GoTo, On Error GoTo and Resume targets that are not defined
+func checkLabels(report *Report, proc *vba.Procedure) {
+	defined := map[string]bool{}
+	vba.Inspect(proc, func(n vba.Node) bool {
+		label, ok := n.(*vba.LabelStmt)
+		if !ok {
+			return true
+		}
+		key := strings.ToLower(label.Name)
+		if defined[key] {
+			report.AddAt(SourceVBA, CodeDuplicateLabel, SeverityError, label.Start, label.Stop,
+				fmt.Sprintf("Label %s is defined more than once in %s", label.Name, proc.Name))
+		}
+		defined[key] = true
+		return true
+	})
+
+	undefined := func(name string, pos vba.Pos, statement string) {
+		if name == "" || name == "0" || defined[strings.ToLower(name)] {
+			return
+		}
+		report.AddAt(SourceVBA, CodeUndefinedLabel, SeverityError, pos, endOf(pos, name),
+			fmt.Sprintf("%s target %s is not defined in %s", statement, name, proc.Name))
+	}
+
+	vba.Inspect(proc, func(n vba.Node) bool {
+		switch stmt := n.(type) {
+		case *vba.GoToStmt:
+			keyword := "GoTo"
+			if stmt.GoSub {
+				keyword = "GoSub"
+			}
+			undefined(stmt.Label, stmt.LabelPos, keyword)
+		case *vba.OnErrorStmt:
+			if stmt.Action == vba.OnErrorGoToLabel {
+				undefined(stmt.Label, stmt.LabelPos, "On Error GoTo")
+			}
+		case *vba.ResumeStmt:
+			if stmt.Label != "" {
+				undefined(stmt.Label, stmt.LabelPos, "Resume")
+			}
+		}
+		return true
+	})
+}
+
+// visitStmts calls visit for every statement nested in stmts, together with
+// the kinds of the enclosing loops ("For" or "Do"), innermost last
+func visitStmts(stmts []vba.Stmt, loops []string, visit func(vba.Stmt, []string)) {
+	for _, stmt := range stmts {
+		visit(stmt, loops)
+
+		switch s := stmt.(type) {
+		case *vba.IfStmt:
+			visitStmts(s.Then, loops, visit)
+			for _, clause := range s.ElseIfs {
+				visitStmts(clause.Body, loops, visit)
+			}
+			visitStmts(s.Else, loops, visit)
+		case *vba.ForStmt:
+			visitStmts(s.Body, append(loops[:len(loops):len(loops)], "For"), visit)
+		case *vba.ForEachStmt:
+			visitStmts(s.Body, append(loops[:len(loops):len(loops)], "For"), visit)
+		case *vba.DoStmt:
+			visitStmts(s.Body, append(loops[:len(loops):len(loops)], "Do"), visit)
+		case *vba.WhileStmt:
+			visitStmts(s.Body, append(loops[:len(loops):len(loops)], "While"), visit)
+		case *vba.SelectStmt:
+			for _, clause := range s.Cases {
+				visitStmts(clause.Body, loops, visit)
+			}
+		case *vba.WithStmt:
+			visitStmts(s.Body, loops, visit)
+		}
+	}
+}
+
+// checkExits reports Exit statements that do not match the enclosing procedure or loop
+func checkExits(report *Report, proc *vba.Procedure) {
+	visitStmts(proc.Body, nil, func(stmt vba.Stmt, loops []string) {
+		exit, ok := stmt.(*vba.ExitStmt)
+		if !ok || exit.Kind == "" {
+			return
+		}
+
+		var valid bool
+		switch exit.Kind {
+		case "Sub":
+			valid = proc.Kind == vba.ProcSub
+		case "Function":
+			valid = proc.Kind == vba.ProcFunction
+		case "Property":
+			valid = proc.Kind.IsProperty()
+		case "For", "Do":
+			for _, loop := range loops {
+				if loop == exit.Kind {
+					valid = true
+				}
+			}
+		}
+		if valid {
+			return
+		}
+
+		var msg string
+		switch exit.Kind {
+		case "For", "Do":
+			msg = fmt.Sprintf("Exit %s is not inside a %s loop", exit.Kind, exit.Kind)
+		default:
+			msg = fmt.Sprintf("Exit %s is not allowed in %s %s; use Exit %s", exit.Kind, proc.Kind, proc.Name,
+				strings.Fields(string(proc.Kind))[0])
+		}
+		report.AddAt(SourceVBA, CodeInvalidExit, SeverityError, exit.Start, exit.Stop, msg)
+	})
+}
+
+// checkNextVariables reports "Next j" closing a "For i" loop
+func checkNextVariables(report *Report, proc *vba.Procedure) {
+	vba.Inspect(proc, func(n vba.Node) bool {
+		loop, ok := n.(*vba.ForStmt)
+		if !ok || loop.NextVar == "" {
+			return true
+		}
+		if name := vba.ExprName(loop.Var); name != "" && !strings.EqualFold(name, loop.NextVar) {
+			report.AddAt(SourceVBA, CodeNextMismatch, SeverityError, loop.Start, loop.Stop,
+				fmt.Sprintf("For %s is closed by Next %s", name, loop.NextVar))
+		}
+		return true
+	})
+}
+
+// checkErrorHandlers reports error handlers that normal execution falls into
+// and handler blocks that no On Error statement activates
+func checkErrorHandlers(report *Report, proc *vba.Procedure) {
+	targets := map[string]bool{}
+	jumps := map[string]bool{}
+	vba.Inspect(proc, func(n vba.Node) bool {
+		switch stmt := n.(type) {
+		case *vba.OnErrorStmt:
+			if stmt.Action == vba.OnErrorGoToLabel {
+				targets[strings.ToLower(stmt.Label)] = true
+			}
+		case *vba.GoToStmt:
+			jumps[strings.ToLower(stmt.Label)] = true
+		case *vba.ResumeStmt:
+			jumps[strings.ToLower(stmt.Label)] = true
+		}
+		return true
+	})
+
+	for i, stmt := range proc.Body {
+		label, ok := stmt.(*vba.LabelStmt)
+		if !ok {
+			continue
+		}
+		key := strings.ToLower(label.Name)
+
+		if targets[key] && fallsThrough(proc.Body[:i]) {
+			report.AddAt(SourceVBA, CodeHandlerFallthrough, SeverityWarning, label.Start, label.Stop,
+				fmt.Sprintf("Normal execution falls through into error handler %s; add Exit %s before the label",
+					label.Name, strings.Fields(string(proc.Kind))[0]))
+		}
+
+		if !targets[key] && !jumps[key] && looksLikeHandler(proc.Body[i+1:]) {
+			report.AddAt(SourceVBA, CodeUnreachableHandler, SeverityWarning, label.Start, label.Stop,
+				fmt.Sprintf("Error handler %s is never activated; add On Error GoTo %s", label.Name, label.Name))
+		}
+	}
+}
+
+// fallsThrough reports whether the last statement before a label lets
+// execution continue into it
+func fallsThrough(before []vba.Stmt) bool {
+	for i := len(before) - 1; i >= 0; i-- {
+		switch before[i].(type) {
+		case *vba.LabelStmt:
+			continue
+		case *vba.ExitStmt, *vba.EndStmt, *vba.GoToStmt, *vba.ResumeStmt:
+			return false
+		}
+		return true
+	}
+	// A handler at the very top of the procedure is reached immediately
+	return len(before) > 0
+}
+
+// looksLikeHandler reports whether the statements up to the next label use
+// Resume or the Err object
+func looksLikeHandler(stmts []vba.Stmt) bool {
+	found := false
+	for _, stmt := range stmts {
+		if _, isLabel := stmt.(*vba.LabelStmt); isLabel {
+			break
+		}
+		vba.Inspect(stmt, func(n vba.Node) bool {
+			switch node := n.(type) {
+			case *vba.ResumeStmt:
+				found = true
+			case *vba.MemberExpr:
+				if root := vba.RootIdent(node); root != nil && strings.EqualFold(root.Name, "Err") {
+					found = true
+				}
+			}
+			return !found
+		})
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// moduleScope returns the lower-case names declared at module level
+func moduleScope(module *vba.Module) map[string]bool {
+	names := map[string]bool{}
+
+	for _, decl := range module.Declarations {
+		switch d := decl.(type) {
+		case *vba.DimStmt:
+			for _, v := range d.Vars {
+				names[strings.ToLower(v.Name)] = true
+			}
+		case *vba.ConstStmt:
+			for _, c := range d.Consts {
+				names[strings.ToLower(c.Name)] = true
+			}
+		case *vba.DeclareStmt:
+			names[strings.ToLower(d.Name)] = true
+		case *vba.TypeDef:
+			names[strings.ToLower(d.Name)] = true
+		case *vba.EnumDef:
+			names[strings.ToLower(d.Name)] = true
+			for _, member := range d.Members {
+				names[strings.ToLower(member.Name)] = true
+			}
+		}
+	}
+	for _, proc := range module.Procedures {
+		names[strings.ToLower(proc.Name)] = true
+	}
+
+	return names
+}
+
+// procedureScope returns the lower-case names declared inside a procedure.
+// VBA declarations are procedure-scoped regardless of the block they appear in.
+func procedureScope(proc *vba.Procedure) map[string]bool {
+	names := map[string]bool{strings.ToLower(proc.Name): true}
+
+	for _, param := range proc.Params {
+		names[strings.ToLower(param.Name)] = true
+	}
+	vba.Inspect(proc, func(n vba.Node) bool {
+		switch d := n.(type) {
+		case *vba.DimStmt:
+			for _, v := range d.Vars {
+				names[strings.ToLower(v.Name)] = true
+			}
+		case *vba.ReDimStmt:
+			for _, v := range d.Vars {
+				names[strings.ToLower(v.Name)] = true
+			}
+		case *vba.ConstStmt:
+			for _, c := range d.Consts {
+				names[strings.ToLower(c.Name)] = true
+			}
+		}
+		return true
+	})
+
+	return names
+}
+
+// checkUndeclared reports variables that are assigned or used as loop
+// counters without a declaration. Reads are not checked because they cannot
+// be told apart from Excel and VBA library members.
+func checkUndeclared(report *Report, module *vba.Module, proc *vba.Procedure, moduleNames map[string]bool) {
+	local := procedureScope(proc)
+	severity := SeverityWarning
+	if module.HasOption("Explicit") {
+		severity = SeverityError
+	}
+
+	reported := map[string]bool{}
+	check := func(target vba.Expr) {
+		ident, ok := target.(*vba.Ident)
+		if !ok {
+			return
+		}
+		key := strings.ToLower(strings.TrimRight(ident.Name, "%&@!#$"))
+		if local[key] || moduleNames[key] || assignableBuiltins[key] || reported[key] {
+			return
+		}
+		reported[key] = true
+		report.AddAt(SourceVBA, CodeUndeclaredVariable, severity, ident.Start, ident.Stop,
+			fmt.Sprintf("Variable %s is not declared; add Dim %s As <Type> in %s", ident.Name, ident.Name, proc.Name))
+	}
+
+	vba.Inspect(proc, func(n vba.Node) bool {
+		switch stmt := n.(type) {
+		case *vba.AssignStmt:
+			check(stmt.Target)
+		case *vba.ForStmt:
+			check(stmt.Var)
+		case *vba.ForEachStmt:
+			check(stmt.Var)
+		}
+		return true
+	})
+}
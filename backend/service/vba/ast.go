@@ -0,0 +1,595 @@
+package vba
+
+import "strings"
+
+// Node is any element of the syntax tree
+type Node interface {
+	Pos() Pos // Position of the first character of the node
+	End() Pos // Position just after the last character of the node
+}
+
+// Stmt is a statement node
+type Stmt interface {
+	Node
+	stmtNode()
+}
+
+// Expr is an expression node
+type Expr interface {
+	Node
+	exprNode()
+}
+
+// Span records the source extent of a node
+type Span struct {
+	Start Pos
+	Stop  Pos
+}
+
+// Pos returns the start of the span
+func (s Span) Pos() Pos { return s.Start }
+
+// End returns the end of the span
+func (s Span) End() Pos { return s.Stop }
+
+// ----------------------------------------------------------------------------
+// Module level
+
+// Module is a parsed VBA code module (.bas, .cls or .frm code section)
+type Module struct {
+	Span
+	Name         string           // Value of Attribute VB_Name, if present
+	Header       []*RawStmt       // VERSION / BEGIN...END header of exported .cls and .frm files
+	Attributes   []*AttributeStmt // Module-level Attribute lines
+	Options      []*OptionStmt    // Option Explicit, Option Base, ...
+	Declarations []Stmt           // Module-level Dim, Const, Declare, Type, Enum, Event, Implements
+	Procedures   []*Procedure     // Sub, Function and Property procedures in source order
+	Comments     []*Comment       // Every comment in the module
+	Directives   []*RawStmt       // Conditional compilation lines (#If, #Const, ...)
+}
+
+// HasOption reports whether the module declares the given option, e.g. "Explicit"
+func (m *Module) HasOption(name string) bool {
+	for _, option := range m.Options {
+		if strings.EqualFold(option.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Procedure returns the first procedure with the given name, compared case-insensitively
+func (m *Module) Procedure(name string) *Procedure {
+	for _, proc := range m.Procedures {
+		if strings.EqualFold(proc.Name, name) {
+			return proc
+		}
+	}
+	return nil
+}
+
+// Comment is a ' or Rem comment
+type Comment struct {
+	Span
+	Text string // Comment text including the leading ' or Rem
+}
+
+// AttributeStmt is an "Attribute VB_Name = ..." line
+type AttributeStmt struct {
+	Span
+	Name  string
+	Value Expr
+}
+
+// OptionStmt is an Option statement
+type OptionStmt struct {
+	Span
+	Name  string // "Explicit", "Base", "Compare" or "Private Module"
+	Value string // "0"/"1" for Base, "Binary"/"Text"/"Database" for Compare
+}
+
+// ProcKind identifies the kind of procedure
+type ProcKind string
+
+const (
+	ProcSub         ProcKind = "Sub"
+	ProcFunction    ProcKind = "Function"
+	ProcPropertyGet ProcKind = "Property Get"
+	ProcPropertyLet ProcKind = "Property Let"
+	ProcPropertySet ProcKind = "Property Set"
+)
+
+// IsProperty reports whether the kind is one of the Property procedures
+func (k ProcKind) IsProperty() bool {
+	return strings.HasPrefix(string(k), "Property")
+}
+
+// Procedure is a Sub, Function or Property declaration with its body
+type Procedure struct {
+	Span
+	Kind       ProcKind
+	Name       string
+	NamePos    Pos
+	Visibility string // "Public", "Private", "Friend" or "" when omitted
+	Static     bool
+	Params     []*Param
+	ReturnType *TypeRef
+	Body       []Stmt
+	EndStmt    Span // Location of End Sub / End Function / End Property; zero when missing
+}
+
+// Param is a procedure parameter
+type Param struct {
+	Span
+	Name       string
+	Optional   bool
+	ByVal      bool
+	ByRef      bool
+	ParamArray bool
+	IsArray    bool // Declared with empty parentheses: values()
+	Type       *TypeRef
+	Default    Expr
+}
+
+// TypeRef is the type following As
+type TypeRef struct {
+	Span
+	Name   string // Type name, possibly qualified (Excel.Range)
+	New    bool   // As New Collection
+	Length Expr   // Fixed-length string: String * 10
+}
+
+// ----------------------------------------------------------------------------
+// Declarations
+
+// VarDecl declares a single variable within Dim, ReDim, Private, Public, Static or Type
+type VarDecl struct {
+	Span
+	Name       string
+	WithEvents bool
+	IsArray    bool
+	Bounds     []*ArrayBound
+	Type       *TypeRef
+}
+
+// ArrayBound is one dimension of an array declaration: (1 To 10) or (10)
+type ArrayBound struct {
+	Span
+	Lower Expr // nil when only the upper bound is given
+	Upper Expr
+}
+
+// DimStmt declares variables
+type DimStmt struct {
+	Span
+	Keyword string // "Dim", "Private", "Public", "Global" or "Static"
+	Vars    []*VarDecl
+}
+
+// ReDimStmt resizes dynamic arrays
+type ReDimStmt struct {
+	Span
+	Preserve bool
+	Vars     []*VarDecl
+}
+
+// ConstStmt declares constants
+type ConstStmt struct {
+	Span
+	Visibility string
+	Consts     []*ConstDecl
+}
+
+// ConstDecl is a single constant within a Const statement
+type ConstDecl struct {
+	Span
+	Name  string
+	Type  *TypeRef
+	Value Expr
+}
+
+// DeclareStmt declares an external DLL procedure
+type DeclareStmt struct {
+	Span
+	Visibility string
+	PtrSafe    bool
+	IsFunction bool
+	Name       string
+	Lib        string
+	Alias      string
+	Params     []*Param
+	ReturnType *TypeRef
+}
+
+// TypeDef is a user-defined Type ... End Type block
+type TypeDef struct {
+	Span
+	Visibility string
+	Name       string
+	Fields     []*VarDecl
+}
+
+// EnumDef is an Enum ... End Enum block
+type EnumDef struct {
+	Span
+	Visibility string
+	Name       string
+	Members    []*EnumMember
+}
+
+// EnumMember is a single enumeration constant
+type EnumMember struct {
+	Span
+	Name  string
+	Value Expr
+}
+
+// ----------------------------------------------------------------------------
+// Statements
+
+// IfStmt is a block or single-line If statement
+type IfStmt struct {
+	Span
+	Cond       Expr
+	Then       []Stmt
+	ElseIfs    []*ElseIfClause
+	Else       []Stmt
+	HasElse    bool
+	SingleLine bool
+}
+
+// ElseIfClause is an ElseIf branch of a block If
+type ElseIfClause struct {
+	Span
+	Cond Expr
+	Body []Stmt
+}
+
+// ForStmt is a For ... To ... [Step] ... Next loop
+type ForStmt struct {
+	Span
+	Var     Expr
+	From    Expr
+	To      Expr
+	Step    Expr
+	Body    []Stmt
+	NextVar string // Variable named after Next, if any
+}
+
+// ForEachStmt is a For Each ... In ... Next loop
+type ForEachStmt struct {
+	Span
+	Var     Expr
+	Group   Expr
+	Body    []Stmt
+	NextVar string
+}
+
+// DoStmt is a Do ... Loop with an optional While/Until condition at either end
+type DoStmt struct {
+	Span
+	Cond      Expr // nil for an unconditional loop
+	Until     bool // Condition is Until rather than While
+	CondAtEnd bool // Condition follows Loop rather than Do
+	Body      []Stmt
+}
+
+// WhileStmt is a While ... Wend loop
+type WhileStmt struct {
+	Span
+	Cond Expr
+	Body []Stmt
+}
+
+// SelectStmt is a Select Case block
+type SelectStmt struct {
+	Span
+	Expr  Expr
+	Cases []*CaseClause
+}
+
+// CaseClause is a single Case branch
+type CaseClause struct {
+	Span
+	Conditions []Expr // CaseRange, CaseIs or plain expressions
+	IsElse     bool
+	Body       []Stmt
+}
+
+// WithStmt is a With ... End With block
+type WithStmt struct {
+	Span
+	Object Expr
+	Body   []Stmt
+}
+
+// LabelStmt defines a line label or line number
+type LabelStmt struct {
+	Span
+	Name string
+}
+
+// GoToStmt is a GoTo or GoSub statement
+type GoToStmt struct {
+	Span
+	Label    string
+	LabelPos Pos
+	GoSub    bool
+}
+
+// OnErrorAction identifies the effect of an On Error statement
+type OnErrorAction string
+
+const (
+	OnErrorResumeNext OnErrorAction = "ResumeNext"
+	OnErrorGoTo0      OnErrorAction = "GoTo0"
+	OnErrorGoToMinus1 OnErrorAction = "GoTo-1"
+	OnErrorGoToLabel  OnErrorAction = "GoToLabel"
+)
+
+// OnErrorStmt is an On Error statement
+type OnErrorStmt struct {
+	Span
+	Action   OnErrorAction
+	Label    string
+	LabelPos Pos
+}
+
+// ResumeStmt is a Resume statement inside an error handler
+type ResumeStmt struct {
+	Span
+	Next     bool
+	Label    string
+	LabelPos Pos
+}
+
+// ExitStmt is Exit Sub, Exit Function, Exit Property, Exit For or Exit Do
+type ExitStmt struct {
+	Span
+	Kind string
+}
+
+// AssignStmt assigns a value: [Let|Set] target = value
+type AssignStmt struct {
+	Span
+	Keyword string // "", "Let" or "Set"
+	Target  Expr
+	Value   Expr
+}
+
+// CallStmt invokes a procedure or method as a statement
+type CallStmt struct {
+	Span
+	Explicit bool // Uses the Call keyword
+	Target   Expr // Procedure or method being called (without the argument list)
+	Args     []*Arg
+}
+
+// EndStmt is a bare End statement that terminates execution
+type EndStmt struct {
+	Span
+}
+
+// StopStmt is a Stop statement
+type StopStmt struct {
+	Span
+}
+
+// EraseStmt clears arrays
+type EraseStmt struct {
+	Span
+	Names []Expr
+}
+
+// RawStmt is a statement kept as tokens without further structure: file I/O
+// (Open, Print #, Close), conditional compilation, Event, Implements and
+// other rarely used statements
+type RawStmt struct {
+	Span
+	Keyword string
+	Tokens  []Token
+	Exprs   []Expr // Operands that parse as expressions, such as the value of LSet or the data of Print #
+}
+
+// BadStmt marks a statement that could not be parsed
+type BadStmt struct {
+	Span
+}
+
+func (*AttributeStmt) stmtNode() {}
+func (*OptionStmt) stmtNode()    {}
+func (*DimStmt) stmtNode()       {}
+func (*ReDimStmt) stmtNode()     {}
+func (*ConstStmt) stmtNode()     {}
+func (*DeclareStmt) stmtNode()   {}
+func (*TypeDef) stmtNode()       {}
+func (*EnumDef) stmtNode()       {}
+func (*IfStmt) stmtNode()        {}
+func (*ForStmt) stmtNode()       {}
+func (*ForEachStmt) stmtNode()   {}
+func (*DoStmt) stmtNode()        {}
+func (*WhileStmt) stmtNode()     {}
+func (*SelectStmt) stmtNode()    {}
+func (*WithStmt) stmtNode()      {}
+func (*LabelStmt) stmtNode()     {}
+func (*GoToStmt) stmtNode()      {}
+func (*OnErrorStmt) stmtNode()   {}
+func (*ResumeStmt) stmtNode()    {}
+func (*ExitStmt) stmtNode()      {}
+func (*AssignStmt) stmtNode()    {}
+func (*CallStmt) stmtNode()      {}
+func (*EndStmt) stmtNode()       {}
+func (*StopStmt) stmtNode()      {}
+func (*EraseStmt) stmtNode()     {}
+func (*RawStmt) stmtNode()       {}
+func (*BadStmt) stmtNode()       {}
+
+// ----------------------------------------------------------------------------
+// Expressions
+
+// Ident is a simple name
+type Ident struct {
+	Span
+	Name string
+}
+
+// LiteralKind identifies the type of a literal
+type LiteralKind string
+
+const (
+	LitInteger LiteralKind = "Integer"
+	LitFloat   LiteralKind = "Float"
+	LitString  LiteralKind = "String"
+	LitDate    LiteralKind = "Date"
+	LitBoolean LiteralKind = "Boolean"
+	LitNothing LiteralKind = "Nothing"
+	LitEmpty   LiteralKind = "Empty"
+	LitNull    LiteralKind = "Null"
+)
+
+// Literal is a constant value
+type Literal struct {
+	Span
+	Kind  LiteralKind
+	Raw   string // Exact source text
+	Value string // String contents without quotes, date without #, otherwise Raw
+}
+
+// MemberExpr accesses a member: X.Name or X!Name. X is nil for
+// .Name inside a With block.
+type MemberExpr struct {
+	Span
+	X       Expr
+	Name    string
+	NamePos Pos
+	Bang    bool
+}
+
+// CallExpr is a call or index: Fun(args). VBA does not distinguish the two syntactically.
+type CallExpr struct {
+	Span
+	Fun  Expr
+	Args []*Arg
+}
+
+// Arg is a single argument in a call
+type Arg struct {
+	Span
+	Name  string // Named argument (Name:=value)
+	Value Expr   // nil for an omitted argument: Foo(a, , c)
+}
+
+// BinaryExpr is a binary operation; Op uses canonical casing ("And", "Mod", "&", "<>")
+type BinaryExpr struct {
+	Span
+	Op string
+	X  Expr
+	Y  Expr
+}
+
+// UnaryExpr is a unary operation: -x, +x, Not x, AddressOf x
+type UnaryExpr struct {
+	Span
+	Op string
+	X  Expr
+}
+
+// ParenExpr is a parenthesized expression
+type ParenExpr struct {
+	Span
+	X Expr
+}
+
+// NewExpr creates an object: New Collection
+type NewExpr struct {
+	Span
+	Type string
+}
+
+// TypeOfExpr tests an object type: TypeOf x Is Worksheet
+type TypeOfExpr struct {
+	Span
+	X    Expr
+	Type string
+}
+
+// CaseRange is a "low To high" Case condition
+type CaseRange struct {
+	Span
+	Low  Expr
+	High Expr
+}
+
+// CaseIs is an "Is <op> value" Case condition
+type CaseIs struct {
+	Span
+	Op    string
+	Value Expr
+}
+
+// BadExpr marks an expression that could not be parsed
+type BadExpr struct {
+	Span
+}
+
+func (*Ident) exprNode()      {}
+func (*Literal) exprNode()    {}
+func (*MemberExpr) exprNode() {}
+func (*CallExpr) exprNode()   {}
+func (*BinaryExpr) exprNode() {}
+func (*UnaryExpr) exprNode()  {}
+func (*ParenExpr) exprNode()  {}
+func (*NewExpr) exprNode()    {}
+func (*TypeOfExpr) exprNode() {}
+func (*CaseRange) exprNode()  {}
+func (*CaseIs) exprNode()     {}
+func (*BadExpr) exprNode()    {}
+
+// Arg, Param, VarDecl and the other helper nodes implement Node through Span
+// so they can be visited by Inspect.
+
+// ExprName returns the dotted name of an identifier or member chain
+// (ws.Range -> "ws.Range"), or "" for other expressions
+func ExprName(expr Expr) string {
+	switch e := expr.(type) {
+	case *Ident:
+		return e.Name
+	case *MemberExpr:
+		if e.X == nil {
+			return "." + e.Name
+		}
+		base := ExprName(e.X)
+		if base == "" {
+			return ""
+		}
+		if e.Bang {
+			return base + "!" + e.Name
+		}
+		return base + "." + e.Name
+	case *CallExpr:
+		return ExprName(e.Fun)
+	}
+	return ""
+}
+
+// RootIdent returns the identifier at the base of a member or call chain
+// (ws.Cells(1, 1).Value -> ws), or nil
+func RootIdent(expr Expr) *Ident {
+	for {
+		switch e := expr.(type) {
+		case *Ident:
+			return e
+		case *MemberExpr:
+			if e.X == nil {
+				return nil
+			}
+			expr = e.X
+		case *CallExpr:
+			expr = e.Fun
+		case *ParenExpr:
+			expr = e.X
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package vba
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// diffOp is one line of an edit script. A and B are the line indexes in the
+// old and new text at which the operation applies.
+type diffOp struct {
+	Kind byte // ' ' unchanged, '-' deleted, '+' inserted
+	A, B int
+}
+
+// UnifiedDiff returns the line differences between two texts in unified diff
+// format, or "" when the texts have the same lines. Line endings are ignored.
+func UnifiedDiff(oldName, newName, a, b string) string {
+	aLines, bLines := splitLines(a), splitLines(b)
+	ops := diffLines(aLines, bLines)
+
+	var changes []int
+	for i, op := range ops {
+		if op.Kind != ' ' {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+
+	for i := 0; i < len(changes); {
+		// Extend the hunk while the next change is close enough to share context
+		j := i
+		for j+1 < len(changes) && changes[j+1]-changes[j] <= 2*diffContext {
+			j++
+		}
+		lo := changes[i] - diffContext
+		if lo < 0 {
+			lo = 0
+		}
+		hi := changes[j] + diffContext + 1
+		if hi > len(ops) {
+			hi = len(ops)
+		}
+
+		aCount, bCount := 0, 0
+		for _, op := range ops[lo:hi] {
+			if op.Kind != '+' {
+				aCount++
+			}
+			if op.Kind != '-' {
+				bCount++
+			}
+		}
+		aStart, bStart := ops[lo].A+1, ops[lo].B+1
+		if aCount == 0 {
+			aStart--
+		}
+		if bCount == 0 {
+			bStart--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+
+		for _, op := range ops[lo:hi] {
+			switch op.Kind {
+			case '-':
+				out.WriteString("-" + aLines[op.A] + "\n")
+			case '+':
+				out.WriteString("+" + bLines[op.B] + "\n")
+			default:
+				out.WriteString(" " + aLines[op.A] + "\n")
+			}
+		}
+		i = j + 1
+	}
+	return out.String()
+}
+
+// splitLines splits text into lines without their terminators
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// diffLines returns a shortest edit script from a to b. The common prefix and
+// suffix are matched directly and the rest is diffed with Myers' algorithm.
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, diffOp{Kind: ' ', A: i, B: i})
+	}
+	for _, op := range myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]) {
+		op.A += prefix
+		op.B += prefix
+		ops = append(ops, op)
+	}
+	for i := suffix; i > 0; i-- {
+		ops = append(ops, diffOp{Kind: ' ', A: len(a) - i, B: len(b) - i})
+	}
+	return ops
+}
+
+// myers computes the edit script with the greedy O(ND) algorithm, keeping
+// only the diagonals each round can reach for the backtrack
+func myers(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+	offset := max + 1
+	v := make([]int, 2*max+3)
+
+	// trace[d] holds v[k] for k in [-d-1, d+1] before round d
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, n, m)
+			}
+		}
+	}
+	return nil
+}
+
+// backtrack walks the trace from the end back to the start and returns the
+// edit script in forward order
+func backtrack(trace [][]int, x, y int) []diffOp {
+	var ops []diffOp
+	for d := len(trace) - 1; d >= 0; d-- {
+		at := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{Kind: ' ', A: x, B: y})
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{Kind: '+', A: x, B: prevY})
+			} else {
+				ops = append(ops, diffOp{Kind: '-', A: prevX, B: y})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
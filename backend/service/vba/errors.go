@@ -0,0 +1,26 @@
+package vba
+
+import "fmt"
+
+// ErrorKind classifies lexer and parser errors
+type ErrorKind string
+
+const (
+	ErrSyntax         ErrorKind = "Syntax"         // Malformed token or statement
+	ErrUnclosedBlock  ErrorKind = "UnclosedBlock"  // Block opened but never closed (If without End If)
+	ErrUnmatchedClose ErrorKind = "UnmatchedClose" // Block closed but never opened (Next without For)
+	ErrMisplaced      ErrorKind = "Misplaced"      // Valid statement in an invalid location
+)
+
+// Error is a problem found while lexing or parsing VBA source
+type Error struct {
+	Kind ErrorKind
+	Pos  Pos
+	End  Pos
+	Msg  string
+}
+
+// Error implements the error interface
+func (e Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
@@ -0,0 +1,667 @@
+package vba
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FormatResult is the outcome of formatting one module
+type FormatResult struct {
+	Path      string `json:"path,omitempty"`
+	Changed   bool   `json:"changed"`
+	Formatted string `json:"formatted,omitempty"` // Formatted code; empty in check mode
+	Diff      string `json:"diff,omitempty"`      // Unified diff from the original to the formatted code
+	Written   bool   `json:"written,omitempty"`   // Whether the file was rewritten
+}
+
+// Format rewrites VBA source in the given style: indentation per block,
+// keyword casing, spacing around operators, blank lines between procedures
+// and the placement of continuation lines. Comments, string literals and
+// conditional compilation lines are kept exactly as written. Source that
+// cannot be tokenized is returned unchanged with an error.
+func Format(src string, style *FormatStyle) (string, error) {
+	if style == nil {
+		style = DefaultFormatStyle()
+	}
+	if err := style.Validate(); err != nil {
+		return src, fmt.Errorf("invalid format style: %w", err)
+	}
+	if !utf8.ValidString(src) {
+		return src, fmt.Errorf("cannot format code that is not valid UTF-8")
+	}
+
+	tokens, errs := Tokenize(src)
+	if len(errs) > 0 {
+		return src, fmt.Errorf("cannot format code with syntax errors: %w", errs[0])
+	}
+
+	bom := ""
+	if strings.HasPrefix(src, "\uFEFF") {
+		bom = "\uFEFF"
+	}
+	newline := "\n"
+	if strings.Contains(src, "\r\n") {
+		newline = "\r\n"
+	}
+
+	f := &formatter{style: style, src: strings.TrimPrefix(src, bom)}
+	f.format(tokens)
+	return bom + f.output(newline), nil
+}
+
+// CheckFormat formats src and reports the changes as a unified diff. The
+// formatted code is only included when includeFormatted is set, so check
+// mode returns the diff alone.
+func CheckFormat(name, src string, style *FormatStyle, includeFormatted bool) (*FormatResult, error) {
+	formatted, err := Format(src, style)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &FormatResult{Path: name, Changed: formatted != src}
+	if result.Changed {
+		result.Diff = UnifiedDiff("a/"+name, "b/"+name, src, formatted)
+	}
+	if includeFormatted {
+		result.Formatted = formatted
+	}
+	return result, nil
+}
+
+// FormatFile formats an exported .bas, .cls or .frm module. In check mode
+// (write false) the file is left untouched and only the diff is returned; in
+// write mode a file that changes is rewritten in place. Files that are not
+// UTF-8 are treated as Windows-1252, as the VBA editor exports them, and are
+// written back in the same encoding.
+func FormatFile(path string, style *FormatStyle, write bool) (*FormatResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	ansi := !utf8.Valid(data)
+	src := string(data)
+	if ansi {
+		src = decodeWindows1252(data)
+	}
+
+	result, err := CheckFormat(path, src, style, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to format %s: %w", path, err)
+	}
+	formatted := result.Formatted
+	result.Formatted = ""
+	if !write || !result.Changed {
+		return result, nil
+	}
+
+	out := []byte(formatted)
+	if ansi {
+		out = encodeWindows1252(formatted)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, out, info.Mode().Perm()); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	result.Written = true
+	return result, nil
+}
+
+// formatBlock is an open block on the formatter's stack
+type formatBlock struct {
+	kind   string // "proc", "if", "for", "do", "while", "with", "select", "case", "type" or "enum"
+	levels int    // Indentation levels the block adds to its body
+}
+
+// formattedLine is one logical line of output
+type formattedLine struct {
+	text      []string // Physical lines; more than one when the statement is continued
+	blank     bool
+	comment   bool // Holds only a comment
+	procedure bool // Starts a Sub, Function or Property procedure
+	attribute bool // Attribute line or class/form header, which procedures follow directly
+}
+
+type formatter struct {
+	style     *FormatStyle
+	src       string
+	blocks    []formatBlock
+	lines     []formattedLine
+	procedure bool // Set by apply when a statement starts a procedure
+}
+
+// format splits the tokens into logical lines and formats each one. The
+// VERSION and BEGIN...END header of exported classes and forms is copied
+// verbatim up to the first Attribute line.
+func (f *formatter) format(tokens []Token) {
+	header := len(tokens) > 0 && tokens[0].Is("VERSION")
+
+	for start := 0; start < len(tokens); {
+		end := start
+		for tokens[end].Kind != TokenNewline && tokens[end].Kind != TokenEOF {
+			end++
+		}
+		line := tokens[start:end]
+
+		if header && len(line) > 0 && line[0].Is("Attribute") {
+			header = false
+		}
+		if header {
+			f.raw(line)
+		} else {
+			f.line(line)
+		}
+
+		if tokens[end].Kind == TokenEOF {
+			break
+		}
+		start = end + 1
+	}
+}
+
+// raw copies a line from the source without changes
+func (f *formatter) raw(line []Token) {
+	if len(line) == 0 {
+		f.lines = append(f.lines, formattedLine{blank: true})
+		return
+	}
+	first, last := line[0], line[len(line)-1]
+	text := f.src[first.Pos.Offset-(first.Pos.Column-1) : last.End.Offset]
+	f.lines = append(f.lines, formattedLine{text: splitLines(text), attribute: true})
+}
+
+// line formats one logical line, which may span several physical lines
+func (f *formatter) line(tokens []Token) {
+	switch {
+	case len(tokens) == 0:
+		f.lines = append(f.lines, formattedLine{blank: true})
+		return
+	case tokens[0].Kind == TokenDirective:
+		text := strings.TrimRightFunc(tokens[0].Text, unicode.IsSpace)
+		f.lines = append(f.lines, formattedLine{text: []string{text}})
+		return
+	case tokens[0].Kind == TokenComment:
+		f.lines = append(f.lines, formattedLine{text: f.render(tokens, f.depth()), comment: true})
+		return
+	}
+
+	// Split into statements, leaving out comments and continuations
+	var statements [][]Token
+	var stmt []Token
+	for _, t := range tokens {
+		switch t.Kind {
+		case TokenColon:
+			statements = append(statements, stmt)
+			stmt = nil
+		case TokenComment, TokenContinuation:
+		default:
+			stmt = append(stmt, t)
+		}
+	}
+	statements = append(statements, stmt)
+
+	label := isLineLabel(tokens)
+	if label {
+		statements[0] = statements[0][1:]
+	}
+
+	depth := f.depth()
+	first, procedure := true, false
+	for i, stmt := range statements {
+		if len(stmt) == 0 {
+			continue
+		}
+		f.procedure = false
+		d, stop := f.apply(stmt, i == len(statements)-1)
+		if first {
+			depth, procedure = d, f.procedure
+			first = false
+		}
+		if stop {
+			break
+		}
+	}
+
+	switch {
+	case label && f.style.OutdentLabels, tokens[0].Is("Attribute"):
+		depth = 0
+	}
+	f.lines = append(f.lines, formattedLine{
+		text:      f.render(tokens, depth),
+		procedure: procedure,
+		attribute: tokens[0].Is("Attribute"),
+	})
+}
+
+// isLineLabel reports whether a line starts with a label (ErrHandler:) or a
+// line number (10 x = 1)
+func isLineLabel(tokens []Token) bool {
+	if tokens[0].Kind == TokenInteger {
+		return true
+	}
+	return tokens[0].Kind == TokenIdent && len(tokens) > 1 && tokens[1].Kind == TokenColon
+}
+
+// apply updates the block stack for one statement. It returns the depth a
+// line starting with the statement is written at, and whether the rest of
+// the line is the body of a single-line If.
+func (f *formatter) apply(stmt []Token, last bool) (int, bool) {
+	word := func(i int) Token {
+		if i < len(stmt) {
+			return stmt[i]
+		}
+		return Token{}
+	}
+
+	i := 0
+	for word(i).Is("Public") || word(i).Is("Private") || word(i).Is("Friend") || word(i).Is("Global") || word(i).Is("Static") {
+		i++
+	}
+	switch head := word(i); {
+	case head.Is("Sub"), head.Is("Function"), head.Is("Property"):
+		// A new procedure closes anything left open by the previous one
+		f.blocks = f.blocks[:0]
+		f.procedure = true
+		f.push("proc", 1)
+		return 0, false
+	case head.Is("Type"), head.Is("Enum"):
+		depth := f.depth()
+		f.push(strings.ToLower(head.Text), 1)
+		return depth, false
+	case i > 0:
+		return f.depth(), false
+	}
+
+	head, next := word(0), word(1)
+	depth := f.depth()
+	switch {
+	case head.Is("End"):
+		switch {
+		case next.Is("Sub"), next.Is("Function"), next.Is("Property"):
+			f.blocks = f.blocks[:0]
+		case next.Is("If"), next.Is("With"), next.Is("Type"), next.Is("Enum"):
+			f.close(strings.ToLower(next.Text))
+		case next.Is("Select"):
+			f.close("select")
+		}
+		return f.depth(), false
+
+	case head.Is("If"):
+		for j, t := range stmt {
+			if t.Is("Then") && (j < len(stmt)-1 || !last) {
+				return depth, true
+			}
+		}
+		f.push("if", 1)
+	case head.Is("ElseIf"), head.Is("Else"):
+		return f.middle("if"), false
+
+	case head.Is("For"):
+		f.push("for", 1)
+	case head.Is("Next"):
+		// Next i, j closes two loops
+		f.close("for")
+		for _, t := range stmt {
+			if t.Kind == TokenPunct && t.Text == "," {
+				f.close("for")
+			}
+		}
+		return f.depth(), false
+
+	case head.Is("Do"):
+		f.push("do", 1)
+	case head.Is("Loop"):
+		f.close("do")
+		return f.depth(), false
+	case head.Is("While"):
+		f.push("while", 1)
+	case head.Is("Wend"):
+		f.close("while")
+		return f.depth(), false
+	case head.Is("With"):
+		f.push("with", 1)
+
+	case head.Is("Select"):
+		levels := 0
+		if f.style.IndentCase {
+			levels = 1
+		}
+		f.push("select", levels)
+	case head.Is("Case"):
+		if f.top() == "case" {
+			f.blocks = f.blocks[:len(f.blocks)-1]
+		}
+		depth = f.depth()
+		f.push("case", 1)
+	}
+	return depth, false
+}
+
+func (f *formatter) push(kind string, levels int) {
+	f.blocks = append(f.blocks, formatBlock{kind: kind, levels: levels})
+}
+
+// close pops the innermost open block of the given kind and anything opened
+// inside it. A close without a matching block is ignored.
+func (f *formatter) close(kind string) {
+	for i := len(f.blocks) - 1; i >= 0; i-- {
+		if f.blocks[i].kind == kind {
+			f.blocks = f.blocks[:i]
+			return
+		}
+	}
+}
+
+// middle returns the depth of Else and ElseIf lines, level with their If
+func (f *formatter) middle(kind string) int {
+	depth := f.depth()
+	if n := len(f.blocks); n > 0 && f.blocks[n-1].kind == kind {
+		depth -= f.blocks[n-1].levels
+	}
+	return depth
+}
+
+func (f *formatter) top() string {
+	if len(f.blocks) == 0 {
+		return ""
+	}
+	return f.blocks[len(f.blocks)-1].kind
+}
+
+func (f *formatter) depth() int {
+	depth := 0
+	for _, block := range f.blocks {
+		depth += block.levels
+	}
+	return depth
+}
+
+func (f *formatter) indent(levels int) string {
+	if f.style.UseTabs {
+		return strings.Repeat("\t", levels)
+	}
+	return strings.Repeat(" ", levels*f.style.IndentSize)
+}
+
+// render writes the tokens of a logical line at the given depth, breaking it
+// into physical lines at each continuation
+func (f *formatter) render(tokens []Token, depth int) []string {
+	indent := f.indent(depth)
+	var lines []string
+	var b strings.Builder
+	col := 0            // Characters written after the indentation
+	var parens []int    // Column after each open parenthesis
+	assign := -1        // Column of the value of an assignment
+	assignNext := false // The next token starts the value of an assignment
+	prev := -1          // Previous token on the physical line
+	assignment := tokens[0].Kind == TokenIdent || tokens[0].Is("Set") || tokens[0].Is("Let") ||
+		(tokens[0].Kind == TokenPunct && tokens[0].Text == ".")
+
+	for i, t := range tokens {
+		if t.Kind == TokenContinuation {
+			b.WriteString(" _")
+			lines = append(lines, indent+b.String())
+			b.Reset()
+
+			pad := f.indent(f.style.ContinuationIndent)
+			col = f.style.ContinuationIndent * f.style.IndentSize
+			if f.style.Continuation == ContinuationAlign {
+				target := assign
+				if len(parens) > 0 {
+					target = parens[len(parens)-1]
+				}
+				if target >= 0 {
+					pad, col = strings.Repeat(" ", target), target
+				}
+			}
+			b.WriteString(pad)
+			prev = -1
+			continue
+		}
+
+		text := f.separator(tokens, i, prev) + f.tokenText(tokens, i)
+		if assignNext {
+			assign = col + len(text) - len(strings.TrimLeft(text, " "))
+			assignNext = false
+		}
+		b.WriteString(text)
+		col += utf8.RuneCountInString(text)
+
+		switch {
+		case t.Kind == TokenPunct && t.Text == "(":
+			parens = append(parens, col)
+		case t.Kind == TokenPunct && t.Text == ")" && len(parens) > 0:
+			parens = parens[:len(parens)-1]
+		case t.Kind == TokenOperator && t.Text == "=" && assignment && assign < 0 && len(parens) == 0:
+			assignNext = true
+		}
+		prev = i
+	}
+
+	lines = append(lines, indent+b.String())
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			lines[i] = ""
+		}
+	}
+	return lines
+}
+
+// optionWords are the words of Option statements that are not reserved
+var optionWords = map[string]string{
+	"explicit": "Explicit", "base": "Base", "compare": "Compare", "binary": "Binary",
+	"text": "Text", "database": "Database", "module": "Module",
+}
+
+// tokenText returns a token as it is written: keywords and the words of Option
+// statements in the style's casing, everything else as in the source
+func (f *formatter) tokenText(tokens []Token, i int) string {
+	t := tokens[i]
+	if f.style.KeywordCase == KeywordCasePreserve {
+		return t.Text
+	}
+	word, ok := optionWords[strings.ToLower(t.Text)]
+	if t.Kind != TokenKeyword && !(ok && tokens[0].Is("Option")) {
+		return t.Text
+	}
+	// Members such as rng.End and ws.Select keep the casing of the object model
+	if i > 0 && tokens[i-1].Kind == TokenPunct && (tokens[i-1].Text == "." || tokens[i-1].Text == "!") {
+		return t.Text
+	}
+
+	if t.Kind == TokenKeyword {
+		word = CanonicalKeyword(t.Text)
+	}
+	switch f.style.KeywordCase {
+	case KeywordCaseUpper:
+		return strings.ToUpper(word)
+	case KeywordCaseLower:
+		return strings.ToLower(word)
+	}
+	return word
+}
+
+// separator returns the whitespace written between the previous token on the
+// physical line and token i
+func (f *formatter) separator(tokens []Token, i, prev int) string {
+	if prev < 0 {
+		return ""
+	}
+	t, p := tokens[i], tokens[prev]
+	original := ""
+	if t.Space {
+		original = " "
+	}
+	punct := func(t Token, texts ...string) bool {
+		if t.Kind != TokenPunct {
+			return false
+		}
+		for _, text := range texts {
+			if t.Text == text {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case t.Kind == TokenComment:
+		// Keep the gap before trailing comments so aligned comments stay aligned
+		if gap := f.src[p.End.Offset:t.Pos.Offset]; gap != "" {
+			return gap
+		}
+		return " "
+	case t.Kind == TokenColon:
+		return ""
+	case p.Kind == TokenColon:
+		return " "
+	case !f.style.SpaceAroundOperators:
+		return original
+	case punct(t, ":=") || punct(p, ":="):
+		return ""
+	case punct(p, "(") || punct(t, ")"):
+		return ""
+	case t.Kind == TokenOperator:
+		return " "
+	case p.Kind == TokenOperator:
+		if isUnaryOperator(tokens, prev) {
+			return ""
+		}
+		return " "
+	case punct(t, ",", ";"):
+		return ""
+	case punct(p, ",", ";"):
+		return " "
+	case punct(p, ".", "!"):
+		return ""
+	}
+	// Foo (x) and Foo(x) differ in VBA, so space before ( is kept as written
+	return original
+}
+
+// operandKeywords are reserved words that end an operand, so a following - or + is binary
+var operandKeywords = map[string]bool{
+	"true": true, "false": true, "nothing": true, "null": true, "empty": true, "me": true, "date": true,
+}
+
+// isUnaryOperator reports whether the - or + at index i is a sign rather than
+// a binary operator
+func isUnaryOperator(tokens []Token, i int) bool {
+	if tokens[i].Text != "-" && tokens[i].Text != "+" {
+		return false
+	}
+	j := i - 1
+	for j >= 0 && tokens[j].Kind == TokenContinuation {
+		j--
+	}
+	if j < 0 {
+		return true
+	}
+
+	p := tokens[j]
+	switch p.Kind {
+	case TokenOperator, TokenColon:
+		return true
+	case TokenPunct:
+		return p.Text != ")"
+	case TokenKeyword:
+		member := j > 0 && tokens[j-1].Kind == TokenPunct && (tokens[j-1].Text == "." || tokens[j-1].Text == "!")
+		return !member && !operandKeywords[strings.ToLower(p.Text)]
+	}
+	return false
+}
+
+// output joins the formatted lines, collapsing runs of blank lines and
+// placing the configured number of blank lines before each procedure
+func (f *formatter) output(newline string) string {
+	// A procedure's leading comments move with it
+	heads := map[int]bool{}
+	for i, line := range f.lines {
+		if !line.procedure {
+			continue
+		}
+		h := i
+		for h > 0 && f.lines[h-1].comment {
+			h--
+		}
+		heads[h] = true
+	}
+
+	var out []string
+	blanks := 0
+	afterAttribute := false
+	for i, line := range f.lines {
+		if line.blank {
+			blanks++
+			continue
+		}
+		if len(out) > 0 {
+			n := blanks
+			if n > f.style.MaxBlankLines {
+				n = f.style.MaxBlankLines
+			}
+			if heads[i] && !afterAttribute {
+				n = f.style.BlankLinesBetweenProcedures
+			}
+			for ; n > 0; n-- {
+				out = append(out, "")
+			}
+		}
+		blanks = 0
+		afterAttribute = line.attribute
+		out = append(out, line.text...)
+	}
+
+	if len(out) == 0 {
+		return ""
+	}
+	return strings.Join(out, newline) + newline
+}
+
+// windows1252 maps the bytes 0x80-0x9F to the characters Windows-1252 assigns
+// them. Unassigned bytes map to the control character with the same value,
+// so decoding and encoding any byte sequence round-trips unchanged.
+var windows1252 = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡',
+	'ˆ', '‰', 'Š', '‹', 'Œ', '\u008D', 'Ž', '\u008F',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—',
+	'˜', '™', 'š', '›', 'œ', '\u009D', 'ž', 'Ÿ',
+}
+
+func decodeWindows1252(data []byte) string {
+	var b strings.Builder
+	for _, c := range data {
+		if c >= 0x80 && c <= 0x9F {
+			b.WriteRune(windows1252[c-0x80])
+		} else {
+			b.WriteRune(rune(c))
+		}
+	}
+	return b.String()
+}
+
+func encodeWindows1252(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r < 0x80 || (r >= 0xA0 && r <= 0xFF):
+			out = append(out, byte(r))
+		default:
+			c := byte('?')
+			for i, mapped := range windows1252 {
+				if mapped == r {
+					c = byte(0x80 + i)
+					break
+				}
+			}
+			out = append(out, c)
+		}
+	}
+	return out
+}
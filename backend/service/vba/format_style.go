@@ -0,0 +1,128 @@
+package vba
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// KeywordCase controls how the formatter writes reserved words
+type KeywordCase string
+
+const (
+	KeywordCaseCanonical KeywordCase = "canonical" // As the VBA editor writes them: End If, ByVal
+	KeywordCaseUpper     KeywordCase = "upper"     // END IF, BYVAL
+	KeywordCaseLower     KeywordCase = "lower"     // end if, byval
+	KeywordCasePreserve  KeywordCase = "preserve"  // Leave keywords as written
+)
+
+// ContinuationStyle controls where the lines following a " _" continuation start
+type ContinuationStyle string
+
+const (
+	ContinuationIndent ContinuationStyle = "indent" // ContinuationIndent levels deeper than the statement
+	ContinuationAlign  ContinuationStyle = "align"  // Under the first argument of the open parenthesis or the value of the assignment
+)
+
+// FormatStyle is a team's formatting preferences, usually shared as a JSON file
+type FormatStyle struct {
+	Name                        string            `json:"name"`
+	IndentSize                  int               `json:"indentSize"`                  // Spaces per indentation level
+	UseTabs                     bool              `json:"useTabs"`                     // Indent with one tab per level instead of spaces
+	KeywordCase                 KeywordCase       `json:"keywordCase"`                 // Casing of reserved words
+	SpaceAroundOperators        bool              `json:"spaceAroundOperators"`        // Normalize spacing around operators, commas and parentheses
+	IndentCase                  bool              `json:"indentCase"`                  // Indent Case lines inside Select Case
+	OutdentLabels               bool              `json:"outdentLabels"`               // Write line labels such as ErrHandler: at column 1
+	BlankLinesBetweenProcedures int               `json:"blankLinesBetweenProcedures"` // Blank lines before each procedure and its leading comments
+	MaxBlankLines               int               `json:"maxBlankLines"`               // Longest run of blank lines kept elsewhere
+	Continuation                ContinuationStyle `json:"continuation"`                // Placement of continuation lines
+	ContinuationIndent          int               `json:"continuationIndent"`          // Extra levels for continuation lines in indent style
+}
+
+// DefaultFormatStyle matches the layout of the VBA editor with four-space indentation
+func DefaultFormatStyle() *FormatStyle {
+	return &FormatStyle{
+		Name:                        "default",
+		IndentSize:                  4,
+		KeywordCase:                 KeywordCaseCanonical,
+		SpaceAroundOperators:        true,
+		IndentCase:                  true,
+		OutdentLabels:               true,
+		BlankLinesBetweenProcedures: 1,
+		MaxBlankLines:               1,
+		Continuation:                ContinuationIndent,
+		ContinuationIndent:          1,
+	}
+}
+
+// LoadFormatStyle reads a style from a JSON file shared by the team. Fields
+// missing from the file keep their default values.
+func LoadFormatStyle(path string) (*FormatStyle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read format style: %w", err)
+	}
+
+	style := DefaultFormatStyle()
+	style.Name = ""
+	if err := json.Unmarshal(data, style); err != nil {
+		return nil, fmt.Errorf("failed to parse format style %s: %w", path, err)
+	}
+	if err := style.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid format style %s: %w", path, err)
+	}
+	if style.Name == "" {
+		style.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	return style, nil
+}
+
+// Save writes the style as indented JSON, creating the directory if needed
+func (s *FormatStyle) Save(path string) error {
+	if err := s.Validate(); err != nil {
+		return fmt.Errorf("invalid format style: %w", err)
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode format style: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create style directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write format style: %w", err)
+	}
+	return nil
+}
+
+// Validate checks that every setting is within range and every enum value is known
+func (s *FormatStyle) Validate() error {
+	switch s.KeywordCase {
+	case KeywordCaseCanonical, KeywordCaseUpper, KeywordCaseLower, KeywordCasePreserve:
+	default:
+		return fmt.Errorf("unknown keywordCase %q", s.KeywordCase)
+	}
+	switch s.Continuation {
+	case ContinuationIndent, ContinuationAlign:
+	default:
+		return fmt.Errorf("unknown continuation style %q", s.Continuation)
+	}
+
+	if s.IndentSize < 0 || s.IndentSize > 16 {
+		return fmt.Errorf("indentSize must be between 0 and 16, got %d", s.IndentSize)
+	}
+	if s.ContinuationIndent < 0 || s.ContinuationIndent > 8 {
+		return fmt.Errorf("continuationIndent must be between 0 and 8, got %d", s.ContinuationIndent)
+	}
+	if s.BlankLinesBetweenProcedures < 0 || s.BlankLinesBetweenProcedures > 5 {
+		return fmt.Errorf("blankLinesBetweenProcedures must be between 0 and 5, got %d", s.BlankLinesBetweenProcedures)
+	}
+	if s.MaxBlankLines < 0 || s.MaxBlankLines > 5 {
+		return fmt.Errorf("maxBlankLines must be between 0 and 5, got %d", s.MaxBlankLines)
+	}
+	return nil
+}
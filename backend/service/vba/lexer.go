@@ -0,0 +1,383 @@
+package vba
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// dateLiteralPattern matches the body of a #...# date literal
+var dateLiteralPattern = regexp.MustCompile(`^[0-9A-Za-z/\-:,. ]*[/\-:][0-9A-Za-z/\-:,. ]*$|^(?i)[a-z]+ \d{1,2}, \d{4}$`)
+
+// Lexer splits VBA source code into tokens
+type Lexer struct {
+	src         []rune
+	index       int // Index of the next rune
+	pos         Pos // Position of the next rune
+	atLineStart bool
+	atStmtStart bool
+	errors      []Error
+}
+
+// NewLexer creates a lexer for the given source. A leading byte order mark is ignored.
+func NewLexer(src string) *Lexer {
+	src = strings.TrimPrefix(src, "\uFEFF")
+	return &Lexer{
+		src:         []rune(src),
+		pos:         Pos{Offset: 0, Line: 1, Column: 1},
+		atLineStart: true,
+		atStmtStart: true,
+	}
+}
+
+// Tokenize returns every token in src, ending with a TokenEOF token,
+// together with any lexical errors
+func Tokenize(src string) ([]Token, []Error) {
+	lexer := NewLexer(src)
+
+	var tokens []Token
+	for {
+		token := lexer.Next()
+		tokens = append(tokens, token)
+		if token.Kind == TokenEOF {
+			break
+		}
+	}
+
+	return tokens, lexer.Errors()
+}
+
+// Errors returns the lexical errors found so far
+func (l *Lexer) Errors() []Error {
+	return l.errors
+}
+
+// peek returns the rune n positions ahead of the current one, or 0 past the end
+func (l *Lexer) peek(n int) rune {
+	if l.index+n >= len(l.src) {
+		return 0
+	}
+	return l.src[l.index+n]
+}
+
+// advance consumes one rune and updates the position
+func (l *Lexer) advance() rune {
+	r := l.src[l.index]
+	l.index++
+	l.pos.Offset += utf8.RuneLen(r)
+	if r == '\n' {
+		l.pos.Line++
+		l.pos.Column = 1
+	} else {
+		l.pos.Column++
+	}
+	return r
+}
+
+// text returns the source between two positions
+func (l *Lexer) text(startIndex int) string {
+	return string(l.src[startIndex:l.index])
+}
+
+// errorf records a lexical error
+func (l *Lexer) errorf(start Pos, msg string) {
+	l.errors = append(l.errors, Error{Kind: ErrSyntax, Pos: start, End: l.pos, Msg: msg})
+}
+
+// Next returns the next token
+func (l *Lexer) Next() Token {
+	space := false
+	for l.index < len(l.src) && isBlank(l.peek(0)) {
+		l.advance()
+		space = true
+	}
+
+	start := l.pos
+	startIndex := l.index
+
+	token := func(kind TokenKind) Token {
+		t := Token{Kind: kind, Text: l.text(startIndex), Pos: start, End: l.pos, Space: space}
+		switch kind {
+		case TokenNewline, TokenColon:
+			l.atStmtStart = true
+			l.atLineStart = kind == TokenNewline
+		case TokenComment, TokenContinuation:
+			l.atLineStart = false
+		default:
+			l.atStmtStart = false
+			l.atLineStart = false
+		}
+		return t
+	}
+
+	if l.index >= len(l.src) {
+		return Token{Kind: TokenEOF, Pos: start, End: start, Space: space}
+	}
+
+	r := l.advance()
+	switch {
+	case r == '\r':
+		if l.peek(0) == '\n' {
+			l.advance()
+		} else {
+			// A lone CR still ends the line
+			l.pos.Line++
+			l.pos.Column = 1
+		}
+		return token(TokenNewline)
+
+	case r == '\n':
+		return token(TokenNewline)
+
+	case r == '\'':
+		l.skipToLineEnd()
+		return token(TokenComment)
+
+	case r == '_' && l.restOfLineIsBlank():
+		t := token(TokenContinuation)
+		l.skipContinuation()
+		return t
+
+	case r == '"':
+		return l.lexString(start, startIndex, token)
+
+	case r == '#':
+		if l.atLineStart {
+			l.skipToLineEnd()
+			return token(TokenDirective)
+		}
+		if l.tryDateLiteral() {
+			return token(TokenDate)
+		}
+		return token(TokenPunct)
+
+	case r == '[':
+		for l.index < len(l.src) && l.peek(0) != ']' && l.peek(0) != '\n' && l.peek(0) != '\r' {
+			l.advance()
+		}
+		if l.peek(0) != ']' {
+			l.errorf(start, "unterminated bracketed identifier")
+			return token(TokenIllegal)
+		}
+		l.advance()
+		return token(TokenIdent)
+
+	case unicode.IsLetter(r):
+		for isIdentRune(l.peek(0)) {
+			l.advance()
+		}
+		word := l.text(startIndex)
+		if strings.EqualFold(word, "Rem") && l.atStmtStart && (isBlank(l.peek(0)) || isLineEnd(l.peek(0))) {
+			l.skipToLineEnd()
+			return token(TokenComment)
+		}
+		if l.peek(0) == '$' {
+			// String functions such as Left$, Mid$ and String$
+			l.advance()
+			return token(TokenIdent)
+		}
+		if IsKeyword(word) {
+			return token(TokenKeyword)
+		}
+		l.lexTypeSuffix()
+		return token(TokenIdent)
+
+	case unicode.IsDigit(r) || (r == '.' && unicode.IsDigit(l.peek(0))):
+		return token(l.lexNumber(r))
+
+	case r == '&':
+		if next := unicode.ToUpper(l.peek(0)); (next == 'H' && isHexDigit(l.peek(1))) || (next == 'O' && isOctalDigit(l.peek(1))) {
+			l.advance()
+			for isHexDigit(l.peek(0)) {
+				l.advance()
+			}
+			if l.peek(0) == '&' || l.peek(0) == '%' || l.peek(0) == '^' {
+				l.advance()
+			}
+			return token(TokenInteger)
+		}
+		return token(TokenOperator)
+
+	case r == ':':
+		if l.peek(0) == '=' {
+			l.advance()
+			return token(TokenPunct)
+		}
+		return token(TokenColon)
+
+	case r == '<':
+		if l.peek(0) == '=' || l.peek(0) == '>' {
+			l.advance()
+		}
+		return token(TokenOperator)
+
+	case r == '>':
+		if l.peek(0) == '=' {
+			l.advance()
+		}
+		return token(TokenOperator)
+
+	case strings.ContainsRune("=+-*/\\^", r):
+		return token(TokenOperator)
+
+	case strings.ContainsRune("(),.!;", r):
+		return token(TokenPunct)
+	}
+
+	l.errorf(start, "unexpected character "+string(r))
+	return token(TokenIllegal)
+}
+
+// lexString scans a string literal whose opening quote was already consumed
+func (l *Lexer) lexString(start Pos, startIndex int, token func(TokenKind) Token) Token {
+	for l.index < len(l.src) {
+		r := l.peek(0)
+		if isLineEnd(r) {
+			break
+		}
+		l.advance()
+		if r == '"' {
+			if l.peek(0) == '"' {
+				l.advance()
+				continue
+			}
+			return token(TokenString)
+		}
+	}
+	l.errorf(start, "unterminated string literal")
+	return token(TokenIllegal)
+}
+
+// lexNumber scans a decimal number whose first rune was already consumed
+func (l *Lexer) lexNumber(first rune) TokenKind {
+	kind := TokenInteger
+	if first == '.' {
+		kind = TokenFloat
+	}
+
+	for unicode.IsDigit(l.peek(0)) {
+		l.advance()
+	}
+	if kind == TokenInteger && l.peek(0) == '.' && unicode.IsDigit(l.peek(1)) {
+		kind = TokenFloat
+		l.advance()
+		for unicode.IsDigit(l.peek(0)) {
+			l.advance()
+		}
+	}
+
+	// Exponent: 1E10, 1.5D-3
+	if e := unicode.ToUpper(l.peek(0)); e == 'E' || e == 'D' {
+		offset := 1
+		if l.peek(1) == '+' || l.peek(1) == '-' {
+			offset = 2
+		}
+		if unicode.IsDigit(l.peek(offset)) {
+			kind = TokenFloat
+			for i := 0; i < offset; i++ {
+				l.advance()
+			}
+			for unicode.IsDigit(l.peek(0)) {
+				l.advance()
+			}
+		}
+	}
+
+	// Type suffix: 1%, 1&, 1!, 1#, 1@
+	if strings.ContainsRune("%&!#@", l.peek(0)) && !isIdentRune(l.peek(1)) {
+		if l.peek(0) == '!' || l.peek(0) == '#' || l.peek(0) == '@' {
+			kind = TokenFloat
+		}
+		l.advance()
+	}
+
+	return kind
+}
+
+// lexTypeSuffix consumes an identifier type-declaration character (count&, total@)
+func (l *Lexer) lexTypeSuffix() {
+	switch l.peek(0) {
+	case '%', '&', '@', '!', '#':
+		// ws!Name is bang member access, not a Single suffix
+		if !isIdentRune(l.peek(1)) {
+			l.advance()
+		}
+	}
+}
+
+// tryDateLiteral consumes the rest of a #...# date literal if one starts here
+func (l *Lexer) tryDateLiteral() bool {
+	for end := l.index; end < len(l.src) && !isLineEnd(l.src[end]); end++ {
+		if l.src[end] != '#' {
+			continue
+		}
+		body := strings.TrimSpace(string(l.src[l.index:end]))
+		if body == "" || !dateLiteralPattern.MatchString(body) {
+			return false
+		}
+		for l.index <= end {
+			l.advance()
+		}
+		return true
+	}
+	return false
+}
+
+// restOfLineIsBlank reports whether only whitespace remains on the current line
+func (l *Lexer) restOfLineIsBlank() bool {
+	for i := l.index; i < len(l.src); i++ {
+		if isLineEnd(l.src[i]) {
+			return true
+		}
+		if !isBlank(l.src[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// skipContinuation consumes the whitespace and line break following " _"
+func (l *Lexer) skipContinuation() {
+	for l.index < len(l.src) && isBlank(l.peek(0)) {
+		l.advance()
+	}
+	if l.peek(0) == '\r' {
+		l.advance()
+		if l.peek(0) == '\n' {
+			l.advance()
+		} else {
+			l.pos.Line++
+			l.pos.Column = 1
+		}
+	} else if l.peek(0) == '\n' {
+		l.advance()
+	}
+}
+
+// skipToLineEnd consumes everything up to, but not including, the line break
+func (l *Lexer) skipToLineEnd() {
+	for l.index < len(l.src) && !isLineEnd(l.peek(0)) {
+		l.advance()
+	}
+}
+
+func isBlank(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\f' || r == '\v' || r == '\u00A0' || r == '\u3000'
+}
+
+func isLineEnd(r rune) bool {
+	return r == '\n' || r == '\r'
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isHexDigit(r rune) bool {
+	return unicode.IsDigit(r) || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')
+}
+
+func isOctalDigit(r rune) bool {
+	return r >= '0' && r <= '7'
+}
@@ -0,0 +1,1850 @@
+package vba
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ParseModule parses the source of a VBA code module. It always returns a
+// module; statements that cannot be parsed are recorded as BadStmt nodes and
+// reported in the returned errors, and parsing resumes on the next line.
+func ParseModule(src string) (*Module, []Error) {
+	tokens, lexErrors := Tokenize(src)
+
+	p := newParser(tokens)
+	module := p.parseModule()
+
+	errors := append(lexErrors, p.errors...)
+	sort.SliceStable(errors, func(i, j int) bool {
+		return errors[i].Pos.Offset < errors[j].Pos.Offset
+	})
+
+	return module, errors
+}
+
+// ParseExpr parses a single VBA expression
+func ParseExpr(src string) (Expr, []Error) {
+	tokens, lexErrors := Tokenize(src)
+
+	p := newParser(tokens)
+	expr := p.parseExpr()
+	if p.cur().Kind != TokenEOF && p.cur().Kind != TokenNewline {
+		p.errorf(p.cur(), "unexpected %s after expression", p.cur())
+	}
+
+	return expr, append(lexErrors, p.errors...)
+}
+
+// parser is a recursive-descent parser over the significant tokens of a module
+type parser struct {
+	tokens     []Token
+	continued  []bool // Token follows a line continuation
+	index      int
+	errors     []Error
+	comments   []*Comment
+	directives []*RawStmt
+	blocks     [][]string // Terminators expected by the enclosing blocks, innermost last
+	singleLine int        // Depth of single-line If statements being parsed
+}
+
+// newParser filters comments, continuations and directives out of the token stream
+func newParser(tokens []Token) *parser {
+	p := &parser{}
+	afterContinuation := false
+
+	for _, token := range tokens {
+		switch token.Kind {
+		case TokenComment:
+			p.comments = append(p.comments, &Comment{Span: Span{token.Pos, token.End}, Text: token.Text})
+		case TokenContinuation:
+			afterContinuation = true
+		case TokenDirective:
+			p.directives = append(p.directives, &RawStmt{
+				Span:    Span{token.Pos, token.End},
+				Keyword: directiveKeyword(token.Text),
+				Tokens:  []Token{token},
+			})
+		default:
+			p.tokens = append(p.tokens, token)
+			p.continued = append(p.continued, afterContinuation)
+			afterContinuation = false
+		}
+	}
+
+	return p
+}
+
+// directiveKeyword returns the directive name of a conditional compilation line
+func directiveKeyword(text string) string {
+	fields := strings.Fields(strings.TrimPrefix(text, "#"))
+	if len(fields) == 0 {
+		return "#"
+	}
+	if strings.EqualFold(fields[0], "End") && len(fields) > 1 {
+		return "#End " + fields[1]
+	}
+	return "#" + fields[0]
+}
+
+// ----------------------------------------------------------------------------
+// Token helpers
+
+func (p *parser) cur() Token {
+	return p.peek(0)
+}
+
+func (p *parser) peek(n int) Token {
+	if p.index+n < len(p.tokens) {
+		return p.tokens[p.index+n]
+	}
+	last := p.tokens[len(p.tokens)-1]
+	return Token{Kind: TokenEOF, Pos: last.End, End: last.End}
+}
+
+func (p *parser) next() Token {
+	token := p.cur()
+	if p.index < len(p.tokens)-1 || token.Kind != TokenEOF {
+		p.index++
+	}
+	return token
+}
+
+// prevEnd returns the end of the last consumed token
+func (p *parser) prevEnd() Pos {
+	if p.index == 0 {
+		return p.cur().Pos
+	}
+	return p.tokens[p.index-1].End
+}
+
+// startPos returns the start position of the current token
+func (p *parser) startPos() Pos {
+	return p.cur().Pos
+}
+
+func (p *parser) at(word string) bool {
+	return p.cur().Is(word)
+}
+
+func (p *parser) atPunct(text string) bool {
+	token := p.cur()
+	return token.Kind == TokenPunct && token.Text == text
+}
+
+func (p *parser) atOp(text string) bool {
+	token := p.cur()
+	return token.Kind == TokenOperator && token.Text == text
+}
+
+func (p *parser) atEOF() bool {
+	return p.cur().Kind == TokenEOF
+}
+
+// atStmtEnd reports whether the current token ends a statement
+func (p *parser) atStmtEnd() bool {
+	if p.cur().IsEndOfStatement() {
+		return true
+	}
+	return p.singleLine > 0 && p.at("Else")
+}
+
+// atLineStart reports whether the current token starts a statement of its own
+func (p *parser) atLineStart() bool {
+	if p.index == 0 {
+		return true
+	}
+	previous := p.tokens[p.index-1].Kind
+	return previous == TokenNewline || previous == TokenColon
+}
+
+// spacedBefore reports whether the current token is separated from the
+// previous one by whitespace on the same line
+func (p *parser) spacedBefore() bool {
+	return p.cur().Space && !p.continued[min(p.index, len(p.continued)-1)]
+}
+
+func (p *parser) accept(word string) bool {
+	if p.at(word) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) acceptPunct(text string) bool {
+	if p.atPunct(text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(word string) bool {
+	if p.accept(word) {
+		return true
+	}
+	p.errorf(p.cur(), "expected %s, found %s", word, p.cur())
+	return false
+}
+
+func (p *parser) expectPunct(text string) bool {
+	if p.acceptPunct(text) {
+		return true
+	}
+	p.errorf(p.cur(), "expected %q, found %s", text, p.cur())
+	return false
+}
+
+// expectName consumes an identifier and returns its text
+func (p *parser) expectName(what string) (string, Pos) {
+	token := p.cur()
+	if token.Kind == TokenIdent {
+		p.next()
+		return token.Text, token.Pos
+	}
+	p.errorf(token, "expected %s, found %s", what, token)
+	return "", token.Pos
+}
+
+// errorf records a syntax error at the given token
+func (p *parser) errorf(token Token, format string, args ...interface{}) {
+	p.report(ErrSyntax, token.Pos, token.End, fmt.Sprintf(format, args...))
+}
+
+// report records an error unless one was already reported at the same position
+func (p *parser) report(kind ErrorKind, pos, end Pos, msg string) {
+	for _, existing := range p.errors {
+		if existing.Pos == pos && existing.Kind == kind {
+			return
+		}
+	}
+	p.errors = append(p.errors, Error{Kind: kind, Pos: pos, End: end, Msg: msg})
+}
+
+// insertToken inserts a synthetic token at the current position
+func (p *parser) insertToken(token Token) {
+	p.tokens = append(p.tokens[:p.index], append([]Token{token}, p.tokens[p.index:]...)...)
+	p.continued = append(p.continued[:p.index], append([]bool{false}, p.continued[p.index:]...)...)
+}
+
+// skipSeparators consumes newlines and colons between statements
+func (p *parser) skipSeparators() {
+	for p.cur().Kind == TokenNewline || p.cur().Kind == TokenColon {
+		p.next()
+	}
+}
+
+// skipLine consumes tokens up to, but not including, the end of the line
+func (p *parser) skipLine() {
+	for p.cur().Kind != TokenNewline && p.cur().Kind != TokenEOF {
+		p.next()
+	}
+}
+
+// endStatement verifies that the current statement is complete
+func (p *parser) endStatement() {
+	if p.atStmtEnd() {
+		return
+	}
+	p.errorf(p.cur(), "unexpected %s at end of statement", p.cur())
+	p.skipLine()
+}
+
+// ----------------------------------------------------------------------------
+// Block structure
+
+// blockOpeners maps terminators to the statement that opens the block
+var blockOpeners = map[string]string{
+	"End Sub":      "Sub",
+	"End Function": "Function",
+	"End Property": "Property",
+	"End If":       "If",
+	"Else":         "If",
+	"ElseIf":       "If",
+	"End With":     "With",
+	"End Select":   "Select Case",
+	"Case":         "Select Case",
+	"End Type":     "Type",
+	"End Enum":     "Enum",
+	"Next":         "For",
+	"Loop":         "Do",
+	"Wend":         "While",
+}
+
+// terminator returns the block terminator at the current token, or ""
+func (p *parser) terminator() string {
+	token := p.cur()
+	if token.Kind != TokenKeyword {
+		return ""
+	}
+
+	switch {
+	case token.Is("End"):
+		next := p.peek(1)
+		for _, word := range []string{"Sub", "Function", "Property", "If", "With", "Select", "Type", "Enum"} {
+			if next.Is(word) {
+				return "End " + word
+			}
+		}
+	case token.Is("Next"), token.Is("Loop"), token.Is("Wend"), token.Is("ElseIf"), token.Is("Case"):
+		return CanonicalKeyword(token.Text)
+	case token.Is("Else"):
+		if p.singleLine > 0 {
+			return ""
+		}
+		return "Else"
+	}
+	return ""
+}
+
+// consumeTerminator consumes a one- or two-word terminator
+func (p *parser) consumeTerminator(term string) {
+	p.next()
+	if strings.HasPrefix(term, "End ") {
+		p.next()
+	}
+}
+
+// expected reports whether any enclosing block accepts the terminator
+func (p *parser) expected(term string) bool {
+	for i := len(p.blocks) - 1; i >= 0; i-- {
+		for _, candidate := range p.blocks[i] {
+			if candidate == term {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *parser) pushBlock(terms ...string) {
+	p.blocks = append(p.blocks, terms)
+}
+
+func (p *parser) popBlock() {
+	p.blocks = p.blocks[:len(p.blocks)-1]
+}
+
+// unclosed reports a block that ends without its terminator
+func (p *parser) unclosed(opener Token, what, term string) {
+	p.report(ErrUnclosedBlock, opener.Pos, opener.End, fmt.Sprintf("%s is missing %s", what, term))
+}
+
+// parseBlock parses statements until a terminator expected by an enclosing
+// block, the start of another procedure or the end of input
+func (p *parser) parseBlock() []Stmt {
+	var stmts []Stmt
+
+	for {
+		p.skipSeparators()
+		if p.atEOF() || p.atProcedureStart() {
+			return stmts
+		}
+
+		if term := p.terminator(); term != "" {
+			if p.expected(term) {
+				return stmts
+			}
+			token := p.cur()
+			p.report(ErrUnmatchedClose, token.Pos, token.End,
+				fmt.Sprintf("%s without %s", term, blockOpeners[term]))
+			p.skipLine()
+			continue
+		}
+
+		stmt := p.parseStatement()
+		if stmt != nil {
+			stmts = append(stmts, stmt)
+		}
+		// A nested block left unclosed stops at the end of an enclosing
+		// block or at the next procedure, which belong to the caller
+		if p.atLineStart() && (p.expected(p.terminator()) || p.atProcedureStart()) {
+			continue
+		}
+		if _, isLabel := stmt.(*LabelStmt); !isLabel {
+			p.endStatement()
+		}
+	}
+}
+
+// atProcedureStart reports whether the current line starts a procedure declaration
+func (p *parser) atProcedureStart() bool {
+	for i := 0; ; i++ {
+		token := p.peek(i)
+		switch {
+		case token.Is("Public"), token.Is("Private"), token.Is("Friend"), token.Is("Global"), token.Is("Static"):
+			continue
+		case token.Is("Sub"), token.Is("Function"):
+			return true
+		case token.Is("Property"):
+			next := p.peek(i + 1)
+			return next.Is("Get") || next.Is("Let") || next.Is("Set")
+		}
+		return false
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Module level
+
+func (p *parser) parseModule() *Module {
+	module := &Module{}
+	if len(p.tokens) > 0 {
+		module.Start = p.tokens[0].Pos
+	}
+
+	for {
+		p.skipSeparators()
+		if p.atEOF() {
+			break
+		}
+
+		start := p.cur()
+		switch {
+		case start.Is("VERSION") && len(module.Procedures) == 0:
+			module.Header = append(module.Header, p.parseRawLine("VERSION"))
+
+		case start.Is("Begin") && len(module.Procedures) == 0:
+			module.Header = append(module.Header, p.parseFormHeader())
+
+		case start.Is("Attribute"):
+			attr := p.parseAttribute()
+			module.Attributes = append(module.Attributes, attr)
+			if strings.EqualFold(attr.Name, "VB_Name") {
+				if lit, ok := attr.Value.(*Literal); ok {
+					module.Name = lit.Value
+				}
+			}
+
+		case start.Is("Option"):
+			module.Options = append(module.Options, p.parseOption())
+
+		case p.atProcedureStart():
+			module.Procedures = append(module.Procedures, p.parseProcedure())
+			continue
+
+		default:
+			if term := p.terminator(); term != "" {
+				p.report(ErrUnmatchedClose, start.Pos, start.End,
+					fmt.Sprintf("%s without %s", term, blockOpeners[term]))
+				p.skipLine()
+				continue
+			}
+			if decl := p.parseDeclaration(); decl != nil {
+				module.Declarations = append(module.Declarations, decl)
+			} else {
+				p.report(ErrMisplaced, start.Pos, start.End, "statement is not valid outside a procedure")
+				p.skipLine()
+				continue
+			}
+		}
+
+		p.endStatement()
+	}
+
+	module.Stop = p.prevEnd()
+	module.Comments = p.comments
+	module.Directives = p.directives
+	return module
+}
+
+// parseRawLine keeps the rest of the line as a RawStmt, along with the
+// expressions found among its tokens
+func (p *parser) parseRawLine(keyword string) *RawStmt {
+	stmt := &RawStmt{Keyword: keyword}
+	stmt.Start = p.startPos()
+	for p.cur().Kind != TokenNewline && p.cur().Kind != TokenEOF {
+		stmt.Tokens = append(stmt.Tokens, p.next())
+	}
+	stmt.Stop = p.prevEnd()
+	if words := len(strings.Fields(keyword)); words < len(stmt.Tokens) {
+		stmt.Exprs = rawExprs(stmt.Tokens[words:])
+	}
+	return stmt
+}
+
+// rawClauseWords separate the operands of Open and the file statements
+var rawClauseWords = map[string]bool{
+	"for": true, "as": true, "access": true, "lock": true, "shared": true,
+	"input": true, "output": true, "append": true, "binary": true, "random": true,
+	"read": true, "write": true, "len": true,
+}
+
+// rawExprs parses the operands of a raw statement: the tokens are split at
+// commas, semicolons, # and = outside parentheses and at the clause words of
+// Open, and each part that parses as a whole becomes an expression
+func rawExprs(tokens []Token) []Expr {
+	var exprs []Expr
+	start, depth := 0, 0
+	split := func(end int) {
+		if end > start {
+			sub := newParser(tokens[start:end])
+			expr := sub.parseExpr()
+			if len(sub.errors) == 0 && sub.cur().Kind == TokenEOF {
+				exprs = append(exprs, expr)
+			}
+		}
+		start = end + 1
+	}
+	for i, token := range tokens {
+		switch {
+		case token.Text == "(":
+			depth++
+		case token.Text == ")":
+			depth--
+		case depth > 0:
+		case token.Text == "," || token.Text == ";" || token.Text == "#" || token.Text == "=":
+			split(i)
+		case token.IsWord() && rawClauseWords[strings.ToLower(token.Text)] &&
+			(i+1 == len(tokens) || tokens[i+1].Text != "("):
+			split(i)
+		}
+	}
+	split(len(tokens))
+	return exprs
+}
+
+// parseFormHeader consumes a Begin ... End designer block of an exported form or class
+func (p *parser) parseFormHeader() *RawStmt {
+	stmt := &RawStmt{Keyword: "Begin"}
+	stmt.Start = p.startPos()
+
+	depth := 0
+	for !p.atEOF() {
+		lineStart := p.cur()
+		lineEnd := p.peek(1)
+		for p.cur().Kind != TokenNewline && p.cur().Kind != TokenEOF {
+			stmt.Tokens = append(stmt.Tokens, p.next())
+		}
+		p.skipSeparators()
+
+		if lineStart.Is("Begin") {
+			depth++
+		} else if lineStart.Is("End") && (lineEnd.Kind == TokenNewline || lineEnd.Kind == TokenEOF) {
+			depth--
+			if depth == 0 {
+				break
+			}
+		}
+	}
+
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+func (p *parser) parseAttribute() *AttributeStmt {
+	stmt := &AttributeStmt{}
+	stmt.Start = p.startPos()
+	p.next()
+
+	name := p.cur()
+	if name.IsWord() {
+		p.next()
+		stmt.Name = name.Text
+		// Attribute Value.VB_UserMemId = 0
+		for p.acceptPunct(".") {
+			if p.cur().IsWord() {
+				stmt.Name += "." + p.next().Text
+			}
+		}
+	} else {
+		p.errorf(name, "expected attribute name, found %s", name)
+	}
+
+	if p.atOp("=") {
+		p.next()
+		stmt.Value = p.parseExpr()
+		// Attribute VB_Ext_KEY = "a", "b"
+		for p.acceptPunct(",") {
+			p.parseExpr()
+		}
+	}
+
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+func (p *parser) parseOption() *OptionStmt {
+	stmt := &OptionStmt{}
+	stmt.Start = p.startPos()
+	p.next()
+
+	token := p.cur()
+	switch {
+	case token.Is("Explicit"):
+		p.next()
+		stmt.Name = "Explicit"
+	case token.Is("Base"):
+		p.next()
+		stmt.Name = "Base"
+		stmt.Value = p.next().Text
+	case token.Is("Compare"):
+		p.next()
+		stmt.Name = "Compare"
+		stmt.Value = p.next().Text
+	case token.Is("Private"):
+		p.next()
+		p.expect("Module")
+		stmt.Name = "Private Module"
+	default:
+		p.errorf(token, "unknown option %s", token)
+		p.skipLine()
+	}
+
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+// parseDeclaration parses a module-level declaration, or returns nil when
+// the current line is not a declaration
+func (p *parser) parseDeclaration() Stmt {
+	start := p.cur()
+	visibility := ""
+	offset := 0
+	if start.Is("Public") || start.Is("Private") || start.Is("Global") || start.Is("Friend") {
+		visibility = CanonicalKeyword(start.Text)
+		offset = 1
+	}
+	keyword := p.peek(offset)
+
+	switch {
+	case keyword.Is("Const"):
+		return p.parseConst()
+	case keyword.Is("Declare"):
+		return p.parseDeclare()
+	case keyword.Is("Type"):
+		return p.parseTypeDef()
+	case keyword.Is("Enum"):
+		return p.parseEnumDef()
+	case keyword.Is("Event"), keyword.Is("Implements"):
+		return p.parseRawLine(CanonicalKeyword(keyword.Text))
+	case keyword.Is("Dim"), keyword.Is("Static"):
+		return p.parseDim()
+	case visibility != "" && (keyword.Kind == TokenIdent || keyword.Is("WithEvents")):
+		return p.parseDim()
+	case start.Kind == TokenIdent && defTypeStatements[strings.ToLower(start.Text)]:
+		// DefInt A-Z and friends
+		return p.parseRawLine(start.Text)
+	}
+	return nil
+}
+
+// defTypeStatements set the default type of variables by initial letter
+var defTypeStatements = map[string]bool{
+	"defbool": true, "defbyte": true, "defint": true, "deflng": true, "deflnglng": true,
+	"deflngptr": true, "defcur": true, "defsng": true, "defdbl": true, "defdate": true,
+	"defstr": true, "defobj": true, "defvar": true,
+}
+
+// parseVisibility consumes an optional Public/Private/Friend/Global modifier
+func (p *parser) parseVisibility() string {
+	for _, word := range []string{"Public", "Private", "Friend", "Global"} {
+		if p.accept(word) {
+			return word
+		}
+	}
+	return ""
+}
+
+func (p *parser) parseProcedure() *Procedure {
+	proc := &Procedure{}
+	proc.Start = p.startPos()
+
+	proc.Visibility = p.parseVisibility()
+	proc.Static = p.accept("Static")
+	if proc.Visibility == "" {
+		proc.Visibility = p.parseVisibility()
+	}
+
+	opener := p.cur()
+	switch {
+	case p.accept("Sub"):
+		proc.Kind = ProcSub
+	case p.accept("Function"):
+		proc.Kind = ProcFunction
+	case p.accept("Property"):
+		switch {
+		case p.accept("Get"):
+			proc.Kind = ProcPropertyGet
+		case p.accept("Let"):
+			proc.Kind = ProcPropertyLet
+		default:
+			p.expect("Set")
+			proc.Kind = ProcPropertySet
+		}
+	}
+
+	nameToken := p.cur()
+	if nameToken.IsWord() {
+		p.next()
+		proc.Name = nameToken.Text
+		proc.NamePos = nameToken.Pos
+	} else {
+		p.errorf(nameToken, "expected procedure name, found %s", nameToken)
+	}
+
+	if p.atPunct("(") {
+		proc.Params = p.parseParams()
+	}
+	if p.accept("As") {
+		proc.ReturnType = p.parseTypeRef()
+	}
+	p.endStatement()
+
+	endTerm := "End " + strings.Fields(string(proc.Kind))[0]
+	p.pushBlock(endTerm)
+	for {
+		proc.Body = append(proc.Body, p.parseBlock()...)
+		if p.terminator() == endTerm {
+			endStart := p.startPos()
+			p.consumeTerminator(endTerm)
+			proc.EndStmt = Span{endStart, p.prevEnd()}
+			break
+		}
+		if p.atEOF() || p.atProcedureStart() {
+			p.unclosed(opener, fmt.Sprintf("%s %s", proc.Kind, proc.Name), endTerm)
+			break
+		}
+		// A terminator for another procedure kind, e.g. End Function in a Sub
+		token := p.cur()
+		p.report(ErrUnmatchedClose, token.Pos, token.End,
+			fmt.Sprintf("%s does not close %s %s", p.terminator(), proc.Kind, proc.Name))
+		p.skipLine()
+	}
+	p.popBlock()
+
+	proc.Stop = p.prevEnd()
+	if proc.EndStmt.Start.IsValid() {
+		p.endStatement()
+	}
+	return proc
+}
+
+func (p *parser) parseParams() []*Param {
+	var params []*Param
+	p.expectPunct("(")
+
+	for !p.atPunct(")") && !p.atStmtEnd() {
+		param := &Param{}
+		param.Start = p.startPos()
+
+		for {
+			switch {
+			case p.accept("Optional"):
+				param.Optional = true
+				continue
+			case p.accept("ByVal"):
+				param.ByVal = true
+				continue
+			case p.accept("ByRef"):
+				param.ByRef = true
+				continue
+			case p.accept("ParamArray"):
+				param.ParamArray = true
+				continue
+			}
+			break
+		}
+
+		param.Name, _ = p.expectName("parameter name")
+		if p.atPunct("(") && p.peek(1).Kind == TokenPunct && p.peek(1).Text == ")" {
+			p.next()
+			p.next()
+			param.IsArray = true
+		}
+		if p.accept("As") {
+			param.Type = p.parseTypeRef()
+		}
+		if p.atOp("=") {
+			p.next()
+			param.Default = p.parseExpr()
+		}
+
+		param.Stop = p.prevEnd()
+		params = append(params, param)
+
+		if !p.acceptPunct(",") {
+			break
+		}
+	}
+
+	p.expectPunct(")")
+	return params
+}
+
+// parseTypeRef parses the type after As
+func (p *parser) parseTypeRef() *TypeRef {
+	ref := &TypeRef{}
+	ref.Start = p.startPos()
+
+	ref.New = p.accept("New")
+	token := p.cur()
+	if !token.IsWord() {
+		p.errorf(token, "expected type name, found %s", token)
+		ref.Stop = p.prevEnd()
+		return ref
+	}
+	p.next()
+	ref.Name = CanonicalKeyword(token.Text)
+	for p.atPunct(".") && p.peek(1).IsWord() {
+		p.next()
+		ref.Name += "." + p.next().Text
+	}
+
+	if p.atOp("*") {
+		p.next()
+		ref.Length = p.parseUnary()
+	}
+
+	ref.Stop = p.prevEnd()
+	return ref
+}
+
+func (p *parser) parseDim() *DimStmt {
+	stmt := &DimStmt{}
+	stmt.Start = p.startPos()
+
+	stmt.Keyword = CanonicalKeyword(p.next().Text)
+	// "Public Dim" is invalid but harmless; accept it
+	p.accept("Dim")
+
+	for {
+		stmt.Vars = append(stmt.Vars, p.parseVarDecl())
+		if !p.acceptPunct(",") {
+			break
+		}
+	}
+
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+func (p *parser) parseVarDecl() *VarDecl {
+	decl := &VarDecl{}
+	decl.Start = p.startPos()
+
+	decl.WithEvents = p.accept("WithEvents")
+	decl.Name, _ = p.expectName("variable name")
+
+	if p.acceptPunct("(") {
+		decl.IsArray = true
+		for !p.atPunct(")") && !p.atStmtEnd() {
+			decl.Bounds = append(decl.Bounds, p.parseArrayBound())
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+		p.expectPunct(")")
+	}
+
+	if p.accept("As") {
+		decl.Type = p.parseTypeRef()
+	}
+
+	decl.Stop = p.prevEnd()
+	return decl
+}
+
+func (p *parser) parseArrayBound() *ArrayBound {
+	bound := &ArrayBound{}
+	bound.Start = p.startPos()
+
+	bound.Upper = p.parseExpr()
+	if p.accept("To") {
+		bound.Lower = bound.Upper
+		bound.Upper = p.parseExpr()
+	}
+
+	bound.Stop = p.prevEnd()
+	return bound
+}
+
+func (p *parser) parseConst() *ConstStmt {
+	stmt := &ConstStmt{}
+	stmt.Start = p.startPos()
+
+	stmt.Visibility = p.parseVisibility()
+	p.expect("Const")
+
+	for {
+		decl := &ConstDecl{}
+		decl.Start = p.startPos()
+		decl.Name, _ = p.expectName("constant name")
+		if p.accept("As") {
+			decl.Type = p.parseTypeRef()
+		}
+		if p.atOp("=") {
+			p.next()
+			decl.Value = p.parseExpr()
+		} else {
+			p.errorf(p.cur(), "expected = in constant declaration, found %s", p.cur())
+		}
+		decl.Stop = p.prevEnd()
+		stmt.Consts = append(stmt.Consts, decl)
+
+		if !p.acceptPunct(",") {
+			break
+		}
+	}
+
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+func (p *parser) parseDeclare() *DeclareStmt {
+	stmt := &DeclareStmt{}
+	stmt.Start = p.startPos()
+
+	stmt.Visibility = p.parseVisibility()
+	p.expect("Declare")
+	stmt.PtrSafe = p.accept("PtrSafe")
+
+	switch {
+	case p.accept("Function"):
+		stmt.IsFunction = true
+	case p.accept("Sub"):
+	default:
+		p.errorf(p.cur(), "expected Sub or Function, found %s", p.cur())
+	}
+
+	stmt.Name, _ = p.expectName("procedure name")
+	if p.expect("Lib") && p.cur().Kind == TokenString {
+		stmt.Lib = unquote(p.next().Text)
+	}
+	if p.accept("Alias") && p.cur().Kind == TokenString {
+		stmt.Alias = unquote(p.next().Text)
+	}
+	if p.atPunct("(") {
+		stmt.Params = p.parseParams()
+	}
+	if p.accept("As") {
+		stmt.ReturnType = p.parseTypeRef()
+	}
+
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+func (p *parser) parseTypeDef() *TypeDef {
+	def := &TypeDef{}
+	def.Start = p.startPos()
+
+	def.Visibility = p.parseVisibility()
+	opener := p.next()
+	def.Name, _ = p.expectName("type name")
+	p.endStatement()
+
+	for {
+		p.skipSeparators()
+		if p.terminator() == "End Type" {
+			p.consumeTerminator("End Type")
+			break
+		}
+		if p.atEOF() || p.atProcedureStart() || p.terminator() != "" {
+			p.unclosed(opener, "Type "+def.Name, "End Type")
+			break
+		}
+		def.Fields = append(def.Fields, p.parseVarDecl())
+		p.endStatement()
+	}
+
+	def.Stop = p.prevEnd()
+	return def
+}
+
+func (p *parser) parseEnumDef() *EnumDef {
+	def := &EnumDef{}
+	def.Start = p.startPos()
+
+	def.Visibility = p.parseVisibility()
+	opener := p.next()
+	def.Name, _ = p.expectName("enum name")
+	p.endStatement()
+
+	for {
+		p.skipSeparators()
+		if p.terminator() == "End Enum" {
+			p.consumeTerminator("End Enum")
+			break
+		}
+		if p.atEOF() || p.atProcedureStart() || p.terminator() != "" {
+			p.unclosed(opener, "Enum "+def.Name, "End Enum")
+			break
+		}
+
+		member := &EnumMember{}
+		member.Start = p.startPos()
+		member.Name, _ = p.expectName("enum member")
+		if p.atOp("=") {
+			p.next()
+			member.Value = p.parseExpr()
+		}
+		member.Stop = p.prevEnd()
+		def.Members = append(def.Members, member)
+		p.endStatement()
+	}
+
+	def.Stop = p.prevEnd()
+	return def
+}
+
+// ----------------------------------------------------------------------------
+// Statements
+
+// fileStatements are I/O statements kept as raw tokens when followed by #
+var fileStatements = map[string]bool{
+	"print": true, "write": true, "input": true, "get": true, "put": true,
+	"seek": true, "lock": true, "unlock": true, "width": true,
+}
+
+func (p *parser) parseStatement() Stmt {
+	token := p.cur()
+	next := p.peek(1)
+
+	switch {
+	case token.Kind == TokenIdent && next.Kind == TokenColon && !p.continued[p.index+1]:
+		return p.parseLabel()
+	case token.Kind == TokenInteger && p.index > 0 &&
+		(p.tokens[p.index-1].Kind == TokenNewline || p.tokens[p.index-1].Kind == TokenColon):
+		return p.parseLabel()
+
+	case token.Is("Dim"), token.Is("Static"):
+		return p.parseDim()
+	case token.Is("ReDim"):
+		return p.parseReDim()
+	case token.Is("Const"):
+		return p.parseConst()
+	case token.Is("If"):
+		return p.parseIf()
+	case token.Is("For"):
+		if next.Is("Each") {
+			return p.parseForEach()
+		}
+		return p.parseFor()
+	case token.Is("Do"):
+		return p.parseDo()
+	case token.Is("While"):
+		return p.parseWhile()
+	case token.Is("Select"):
+		return p.parseSelect()
+	case token.Is("With"):
+		return p.parseWith()
+	case token.Is("GoTo"), token.Is("GoSub"):
+		return p.parseGoTo()
+	case token.Is("On"):
+		return p.parseOn()
+	case token.Is("Resume"):
+		return p.parseResume()
+	case token.Is("Exit"):
+		return p.parseExit()
+	case token.Is("Set"), token.Is("Let"):
+		return p.parseAssignment(CanonicalKeyword(p.next().Text), token.Pos)
+	case token.Is("Call"):
+		return p.parseCall()
+	case token.Is("End") && next.IsEndOfStatement():
+		p.next()
+		return &EndStmt{Span: Span{token.Pos, token.End}}
+	case token.Is("Stop"):
+		p.next()
+		return &StopStmt{Span: Span{token.Pos, token.End}}
+	case token.Is("Erase"):
+		return p.parseErase()
+	case token.Is("Attribute"):
+		return p.parseAttribute()
+	case token.Is("Return"), token.Is("RaiseEvent"), token.Is("LSet"), token.Is("RSet"):
+		return p.parseRawLine(CanonicalKeyword(token.Text))
+	case token.Is("Line") && next.Is("Input"):
+		return p.parseRawLine("Line Input")
+	case token.Is("Open") && !(next.Kind == TokenPunct || next.Kind == TokenOperator):
+		return p.parseRawLine("Open")
+	case token.Is("Close") && (next.IsEndOfStatement() || next.Text == "#" || next.Kind == TokenInteger):
+		return p.parseRawLine("Close")
+	case token.Is("Name") && next.Kind == TokenString:
+		return p.parseRawLine("Name")
+	case token.IsWord() && fileStatements[strings.ToLower(token.Text)] && next.Text == "#":
+		return p.parseRawLine(token.Text)
+
+	case token.Is("Declare"), token.Is("Type"), token.Is("Enum"), token.Is("Option"),
+		token.Is("Public"), token.Is("Private"), token.Is("Global"), token.Is("Friend"),
+		token.Is("Event"), token.Is("Implements"):
+		p.report(ErrMisplaced, token.Pos, token.End,
+			fmt.Sprintf("%s is not valid inside a procedure", CanonicalKeyword(token.Text)))
+		p.skipLine()
+		return &BadStmt{Span: Span{token.Pos, p.prevEnd()}}
+	}
+
+	if token.Kind == TokenKeyword && !valueKeywords[strings.ToLower(token.Text)] {
+		p.errorf(token, "unexpected %s", token)
+		p.skipLine()
+		return &BadStmt{Span: Span{token.Pos, p.prevEnd()}}
+	}
+
+	return p.parseExprStatement()
+}
+
+func (p *parser) parseLabel() *LabelStmt {
+	token := p.next()
+	return &LabelStmt{Span: Span{token.Pos, token.End}, Name: token.Text}
+}
+
+func (p *parser) parseReDim() *ReDimStmt {
+	stmt := &ReDimStmt{}
+	stmt.Start = p.startPos()
+	p.next()
+
+	stmt.Preserve = p.accept("Preserve")
+	for {
+		stmt.Vars = append(stmt.Vars, p.parseVarDecl())
+		if !p.acceptPunct(",") {
+			break
+		}
+	}
+
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+func (p *parser) parseIf() *IfStmt {
+	stmt := &IfStmt{}
+	opener := p.next()
+	stmt.Start = opener.Pos
+
+	stmt.Cond = p.parseExpr()
+	p.expect("Then")
+
+	if p.cur().Kind != TokenNewline && p.cur().Kind != TokenEOF {
+		p.parseSingleLineIf(stmt)
+		stmt.Stop = p.prevEnd()
+		return stmt
+	}
+
+	p.pushBlock("ElseIf", "Else", "End If")
+	stmt.Then = p.parseBlock()
+
+	for {
+		if p.at("ElseIf") {
+			clause := &ElseIfClause{}
+			clause.Start = p.next().Pos
+			clause.Cond = p.parseExpr()
+			p.expect("Then")
+			p.endStatement()
+			clause.Body = p.parseBlock()
+			clause.Stop = p.prevEnd()
+			stmt.ElseIfs = append(stmt.ElseIfs, clause)
+			continue
+		}
+		if p.terminator() == "Else" && !stmt.HasElse {
+			p.next()
+			stmt.HasElse = true
+			p.blocks[len(p.blocks)-1] = []string{"End If"}
+			stmt.Else = p.parseBlock()
+			continue
+		}
+		break
+	}
+	p.popBlock()
+
+	if p.terminator() == "End If" {
+		p.consumeTerminator("End If")
+	} else {
+		p.unclosed(opener, "If block", "End If")
+	}
+
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+// parseSingleLineIf parses the statements after Then on the same line
+func (p *parser) parseSingleLineIf(stmt *IfStmt) {
+	stmt.SingleLine = true
+	p.singleLine++
+	defer func() { p.singleLine-- }()
+
+	stmt.Then = p.parseInlineStatements()
+	if p.accept("Else") {
+		stmt.HasElse = true
+		stmt.Else = p.parseInlineStatements()
+	}
+}
+
+// parseInlineStatements parses colon-separated statements up to Else or the end of the line
+func (p *parser) parseInlineStatements() []Stmt {
+	var stmts []Stmt
+
+	for {
+		for p.cur().Kind == TokenColon {
+			p.next()
+		}
+		if p.cur().Kind == TokenNewline || p.cur().Kind == TokenEOF || p.at("Else") {
+			return stmts
+		}
+
+		// If x Then 100 is an implicit GoTo
+		if token := p.cur(); token.Kind == TokenInteger {
+			p.next()
+			stmts = append(stmts, &GoToStmt{Span: Span{token.Pos, token.End}, Label: token.Text, LabelPos: token.Pos})
+			continue
+		}
+
+		stmt := p.parseStatement()
+		if stmt != nil {
+			stmts = append(stmts, stmt)
+		}
+		if !p.atStmtEnd() {
+			p.errorf(p.cur(), "unexpected %s in single-line If", p.cur())
+			p.skipLine()
+			return stmts
+		}
+	}
+}
+
+func (p *parser) parseFor() *ForStmt {
+	stmt := &ForStmt{}
+	opener := p.next()
+	stmt.Start = opener.Pos
+
+	stmt.Var = p.parsePostfix(false)
+	if p.atOp("=") {
+		p.next()
+	} else {
+		p.errorf(p.cur(), "expected = in For statement, found %s", p.cur())
+	}
+	stmt.From = p.parseExpr()
+	p.expect("To")
+	stmt.To = p.parseExpr()
+	if p.accept("Step") {
+		stmt.Step = p.parseExpr()
+	}
+	p.endStatement()
+
+	stmt.Body, stmt.NextVar = p.parseLoopBody(opener, "For loop")
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+func (p *parser) parseForEach() *ForEachStmt {
+	stmt := &ForEachStmt{}
+	opener := p.next()
+	stmt.Start = opener.Pos
+	p.next() // Each
+
+	stmt.Var = p.parsePostfix(false)
+	p.expect("In")
+	stmt.Group = p.parseExpr()
+	p.endStatement()
+
+	stmt.Body, stmt.NextVar = p.parseLoopBody(opener, "For Each loop")
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+// parseLoopBody parses a For body and its Next, returning the Next variable
+func (p *parser) parseLoopBody(opener Token, what string) ([]Stmt, string) {
+	p.pushBlock("Next")
+	body := p.parseBlock()
+	p.popBlock()
+
+	if p.terminator() != "Next" {
+		p.unclosed(opener, what, "Next")
+		return body, ""
+	}
+	p.next()
+
+	name := ""
+	if p.cur().Kind == TokenIdent {
+		name = p.next().Text
+	}
+
+	// Next j, i closes several loops: the comma becomes a new line holding
+	// another Next for the enclosing For
+	if p.atPunct(",") {
+		comma := p.cur()
+		p.tokens[p.index] = Token{Kind: TokenKeyword, Text: "Next", Pos: comma.Pos, End: comma.End}
+		p.insertToken(Token{Kind: TokenNewline, Pos: comma.Pos, End: comma.Pos})
+	}
+
+	return body, name
+}
+
+func (p *parser) parseDo() *DoStmt {
+	stmt := &DoStmt{}
+	opener := p.next()
+	stmt.Start = opener.Pos
+
+	if p.at("While") || p.at("Until") {
+		stmt.Until = p.next().Is("Until")
+		stmt.Cond = p.parseExpr()
+	}
+	p.endStatement()
+
+	p.pushBlock("Loop")
+	stmt.Body = p.parseBlock()
+	p.popBlock()
+
+	if p.terminator() != "Loop" {
+		p.unclosed(opener, "Do loop", "Loop")
+		stmt.Stop = p.prevEnd()
+		return stmt
+	}
+	p.next()
+
+	if p.at("While") || p.at("Until") {
+		if stmt.Cond != nil {
+			p.errorf(p.cur(), "Do loop cannot have a condition at both ends")
+		}
+		stmt.Until = p.next().Is("Until")
+		stmt.Cond = p.parseExpr()
+		stmt.CondAtEnd = true
+	}
+
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+func (p *parser) parseWhile() *WhileStmt {
+	stmt := &WhileStmt{}
+	opener := p.next()
+	stmt.Start = opener.Pos
+
+	stmt.Cond = p.parseExpr()
+	p.endStatement()
+
+	p.pushBlock("Wend")
+	stmt.Body = p.parseBlock()
+	p.popBlock()
+
+	if p.terminator() == "Wend" {
+		p.next()
+	} else {
+		p.unclosed(opener, "While loop", "Wend")
+	}
+
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+func (p *parser) parseSelect() *SelectStmt {
+	stmt := &SelectStmt{}
+	opener := p.next()
+	stmt.Start = opener.Pos
+
+	p.expect("Case")
+	stmt.Expr = p.parseExpr()
+	p.endStatement()
+
+	p.pushBlock("Case", "End Select")
+	if leading := p.parseBlock(); len(leading) > 0 {
+		p.report(ErrMisplaced, leading[0].Pos(), leading[0].End(), "statement is not valid before the first Case")
+	}
+
+	for p.terminator() == "Case" {
+		clause := &CaseClause{}
+		clause.Start = p.next().Pos
+
+		if p.accept("Else") {
+			clause.IsElse = true
+		} else {
+			clause.Conditions = p.parseCaseConditions()
+		}
+		clause.Body = p.parseBlock()
+		clause.Stop = p.prevEnd()
+		stmt.Cases = append(stmt.Cases, clause)
+	}
+	p.popBlock()
+
+	if p.terminator() == "End Select" {
+		p.consumeTerminator("End Select")
+	} else {
+		p.unclosed(opener, "Select Case block", "End Select")
+	}
+
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+func (p *parser) parseCaseConditions() []Expr {
+	var conditions []Expr
+
+	for {
+		start := p.startPos()
+		if p.accept("Is") {
+			op := p.cur()
+			if op.Kind == TokenOperator {
+				p.next()
+			} else {
+				p.errorf(op, "expected comparison operator after Is, found %s", op)
+			}
+			value := p.parseExpr()
+			conditions = append(conditions, &CaseIs{Span: Span{start, p.prevEnd()}, Op: op.Text, Value: value})
+		} else {
+			low := p.parseExpr()
+			if p.accept("To") {
+				high := p.parseExpr()
+				conditions = append(conditions, &CaseRange{Span: Span{start, p.prevEnd()}, Low: low, High: high})
+			} else {
+				conditions = append(conditions, low)
+			}
+		}
+
+		if !p.acceptPunct(",") {
+			return conditions
+		}
+	}
+}
+
+func (p *parser) parseWith() *WithStmt {
+	stmt := &WithStmt{}
+	opener := p.next()
+	stmt.Start = opener.Pos
+
+	stmt.Object = p.parseExpr()
+	p.endStatement()
+
+	p.pushBlock("End With")
+	stmt.Body = p.parseBlock()
+	p.popBlock()
+
+	if p.terminator() == "End With" {
+		p.consumeTerminator("End With")
+	} else {
+		p.unclosed(opener, "With block", "End With")
+	}
+
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+func (p *parser) parseGoTo() *GoToStmt {
+	stmt := &GoToStmt{}
+	keyword := p.next()
+	stmt.Start = keyword.Pos
+	stmt.GoSub = keyword.Is("GoSub")
+
+	label := p.cur()
+	if label.Kind == TokenIdent || label.Kind == TokenInteger {
+		p.next()
+		stmt.Label = label.Text
+		stmt.LabelPos = label.Pos
+	} else {
+		p.errorf(label, "expected label, found %s", label)
+	}
+
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+func (p *parser) parseOn() Stmt {
+	start := p.startPos()
+	if !(p.peek(1).Is("Error") || (p.peek(1).Is("Local") && p.peek(2).Is("Error"))) {
+		// On expr GoTo a, b, c
+		return p.parseRawLine("On")
+	}
+
+	stmt := &OnErrorStmt{}
+	stmt.Start = start
+	p.next()
+	p.accept("Local")
+	p.next() // Error
+
+	switch {
+	case p.accept("Resume"):
+		p.expect("Next")
+		stmt.Action = OnErrorResumeNext
+	case p.accept("GoTo"):
+		label := p.cur()
+		switch {
+		case label.Kind == TokenInteger && label.Text == "0":
+			p.next()
+			stmt.Action = OnErrorGoTo0
+		case label.Kind == TokenOperator && label.Text == "-" && p.peek(1).Text == "1":
+			p.next()
+			p.next()
+			stmt.Action = OnErrorGoToMinus1
+		case label.Kind == TokenIdent || label.Kind == TokenInteger:
+			p.next()
+			stmt.Action = OnErrorGoToLabel
+			stmt.Label = label.Text
+			stmt.LabelPos = label.Pos
+		default:
+			p.errorf(label, "expected label after On Error GoTo, found %s", label)
+		}
+	default:
+		p.errorf(p.cur(), "expected Resume Next or GoTo after On Error, found %s", p.cur())
+	}
+
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+func (p *parser) parseResume() *ResumeStmt {
+	stmt := &ResumeStmt{}
+	stmt.Start = p.next().Pos
+
+	switch token := p.cur(); {
+	case p.accept("Next"):
+		stmt.Next = true
+	case token.Kind == TokenIdent || token.Kind == TokenInteger:
+		p.next()
+		stmt.Label = token.Text
+		stmt.LabelPos = token.Pos
+	}
+
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+func (p *parser) parseExit() *ExitStmt {
+	stmt := &ExitStmt{}
+	stmt.Start = p.next().Pos
+
+	kind := p.cur()
+	switch {
+	case kind.Is("Sub"), kind.Is("Function"), kind.Is("Property"), kind.Is("For"), kind.Is("Do"):
+		p.next()
+		stmt.Kind = CanonicalKeyword(kind.Text)
+	default:
+		p.errorf(kind, "expected Sub, Function, Property, For or Do after Exit, found %s", kind)
+	}
+
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+func (p *parser) parseAssignment(keyword string, start Pos) *AssignStmt {
+	stmt := &AssignStmt{Keyword: keyword}
+	stmt.Start = start
+
+	stmt.Target = p.parsePostfix(false)
+	if p.atOp("=") {
+		p.next()
+		stmt.Value = p.parseExpr()
+	} else {
+		p.errorf(p.cur(), "expected = in %s statement, found %s", keyword, p.cur())
+	}
+
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+func (p *parser) parseCall() *CallStmt {
+	stmt := &CallStmt{Explicit: true}
+	stmt.Start = p.next().Pos
+
+	target := p.parsePostfix(false)
+	if call, ok := target.(*CallExpr); ok {
+		stmt.Target = call.Fun
+		stmt.Args = call.Args
+	} else {
+		stmt.Target = target
+	}
+
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+func (p *parser) parseErase() *EraseStmt {
+	stmt := &EraseStmt{}
+	stmt.Start = p.next().Pos
+
+	for {
+		stmt.Names = append(stmt.Names, p.parsePostfix(false))
+		if !p.acceptPunct(",") {
+			break
+		}
+	}
+
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+// parseExprStatement parses an assignment without Let or a call without Call
+func (p *parser) parseExprStatement() Stmt {
+	start := p.startPos()
+	target := p.parsePostfix(true)
+
+	if p.atOp("=") {
+		p.next()
+		value := p.parseExpr()
+		return &AssignStmt{Span: Span{start, p.prevEnd()}, Target: target, Value: value}
+	}
+
+	stmt := &CallStmt{}
+	stmt.Start = start
+	if p.atStmtEnd() {
+		// Foo(1, 2) without Call is a call with a parenthesized argument list
+		if call, ok := target.(*CallExpr); ok {
+			stmt.Target = call.Fun
+			stmt.Args = call.Args
+		} else {
+			stmt.Target = target
+		}
+	} else {
+		stmt.Target = target
+		stmt.Args = p.parseArgs(false)
+	}
+
+	stmt.Stop = p.prevEnd()
+	return stmt
+}
+
+// ----------------------------------------------------------------------------
+// Expressions
+
+// valueKeywords are reserved words that may appear where a value is expected
+var valueKeywords = map[string]bool{
+	"me": true, "date": true, "string": true, "error": true, "true": true, "false": true,
+	"nothing": true, "empty": true, "null": true, "new": true, "typeof": true,
+	"addressof": true, "not": true,
+}
+
+// binaryPrecedence returns the canonical operator and its precedence, or 0
+// when the token is not a binary operator
+func binaryPrecedence(token Token) (string, int) {
+	switch token.Kind {
+	case TokenOperator:
+		switch token.Text {
+		case "^":
+			return token.Text, 14
+		case "*", "/":
+			return token.Text, 12
+		case "\\":
+			return token.Text, 11
+		case "+", "-":
+			return token.Text, 9
+		case "&":
+			return token.Text, 8
+		case "=", "<>", "<", ">", "<=", ">=":
+			return token.Text, 7
+		}
+	case TokenKeyword:
+		switch strings.ToLower(token.Text) {
+		case "mod":
+			return "Mod", 10
+		case "like":
+			return "Like", 7
+		case "is":
+			return "Is", 7
+		case "and":
+			return "And", 5
+		case "or":
+			return "Or", 4
+		case "xor":
+			return "Xor", 3
+		case "eqv":
+			return "Eqv", 2
+		case "imp":
+			return "Imp", 1
+		}
+	}
+	return "", 0
+}
+
+const (
+	precComparison = 7
+	precPower      = 14
+)
+
+func (p *parser) parseExpr() Expr {
+	return p.parseBinary(1)
+}
+
+func (p *parser) parseBinary(minPrec int) Expr {
+	x := p.parseUnary()
+
+	for {
+		op, prec := binaryPrecedence(p.cur())
+		if prec == 0 || prec < minPrec {
+			return x
+		}
+		p.next()
+		y := p.parseBinary(prec + 1)
+		x = &BinaryExpr{Span: Span{x.Pos(), p.prevEnd()}, Op: op, X: x, Y: y}
+	}
+}
+
+func (p *parser) parseUnary() Expr {
+	token := p.cur()
+
+	switch {
+	case token.Is("Not"):
+		p.next()
+		x := p.parseBinary(precComparison)
+		return &UnaryExpr{Span: Span{token.Pos, p.prevEnd()}, Op: "Not", X: x}
+	case token.Kind == TokenOperator && (token.Text == "-" || token.Text == "+"):
+		p.next()
+		x := p.parseBinary(precPower)
+		return &UnaryExpr{Span: Span{token.Pos, p.prevEnd()}, Op: token.Text, X: x}
+	case token.Is("AddressOf"):
+		p.next()
+		x := p.parsePostfix(false)
+		return &UnaryExpr{Span: Span{token.Pos, p.prevEnd()}, Op: "AddressOf", X: x}
+	}
+
+	return p.parsePostfix(false)
+}
+
+// parsePostfix parses a primary expression followed by member accesses and
+// argument lists. In statement context a "(", "." or "!" preceded by a space
+// starts the argument list of a call without parentheses: MsgBox ("Hi").
+func (p *parser) parsePostfix(stmtContext bool) Expr {
+	x := p.parsePrimary()
+
+	for {
+		if stmtContext && p.spacedBefore() {
+			return x
+		}
+
+		switch {
+		case p.atPunct(".") || p.atPunct("!"):
+			bang := p.next().Text == "!"
+			name := p.cur()
+			if !name.IsWord() {
+				p.errorf(name, "expected member name, found %s", name)
+				return x
+			}
+			p.next()
+			x = &MemberExpr{Span: Span{x.Pos(), name.End}, X: x, Name: name.Text, NamePos: name.Pos, Bang: bang}
+		case p.atPunct("("):
+			args := p.parseParenArgs()
+			x = &CallExpr{Span: Span{x.Pos(), p.prevEnd()}, Fun: x, Args: args}
+		default:
+			return x
+		}
+	}
+}
+
+func (p *parser) parsePrimary() Expr {
+	token := p.cur()
+	span := Span{token.Pos, token.End}
+
+	switch token.Kind {
+	case TokenInteger:
+		p.next()
+		return &Literal{Span: span, Kind: LitInteger, Raw: token.Text, Value: token.Text}
+	case TokenFloat:
+		p.next()
+		return &Literal{Span: span, Kind: LitFloat, Raw: token.Text, Value: token.Text}
+	case TokenString:
+		p.next()
+		return &Literal{Span: span, Kind: LitString, Raw: token.Text, Value: unquote(token.Text)}
+	case TokenDate:
+		p.next()
+		return &Literal{Span: span, Kind: LitDate, Raw: token.Text, Value: strings.Trim(token.Text, "#")}
+	case TokenIdent:
+		p.next()
+		return &Ident{Span: span, Name: token.Text}
+
+	case TokenPunct:
+		switch token.Text {
+		case "(":
+			p.next()
+			x := p.parseExpr()
+			p.expectPunct(")")
+			return &ParenExpr{Span: Span{token.Pos, p.prevEnd()}, X: x}
+		case ".", "!":
+			// Member of the object in the enclosing With block
+			p.next()
+			name := p.cur()
+			if !name.IsWord() {
+				p.errorf(name, "expected member name, found %s", name)
+				return &BadExpr{Span: span}
+			}
+			p.next()
+			return &MemberExpr{Span: Span{token.Pos, name.End}, Name: name.Text, NamePos: name.Pos, Bang: token.Text == "!"}
+		}
+
+	case TokenKeyword:
+		switch strings.ToLower(token.Text) {
+		case "true", "false":
+			p.next()
+			return &Literal{Span: span, Kind: LitBoolean, Raw: token.Text, Value: CanonicalKeyword(token.Text)}
+		case "nothing":
+			p.next()
+			return &Literal{Span: span, Kind: LitNothing, Raw: token.Text, Value: "Nothing"}
+		case "empty":
+			p.next()
+			return &Literal{Span: span, Kind: LitEmpty, Raw: token.Text, Value: "Empty"}
+		case "null":
+			p.next()
+			return &Literal{Span: span, Kind: LitNull, Raw: token.Text, Value: "Null"}
+		case "new":
+			p.next()
+			ref := p.parseTypeRef()
+			return &NewExpr{Span: Span{token.Pos, p.prevEnd()}, Type: ref.Name}
+		case "typeof":
+			p.next()
+			x := p.parsePostfix(false)
+			p.expect("Is")
+			ref := p.parseTypeRef()
+			return &TypeOfExpr{Span: Span{token.Pos, p.prevEnd()}, X: x, Type: ref.Name}
+		case "me", "date", "string", "error":
+			p.next()
+			return &Ident{Span: span, Name: CanonicalKeyword(token.Text)}
+		}
+	}
+
+	p.errorf(token, "expected expression, found %s", token)
+	if !token.IsEndOfStatement() && !(token.Kind == TokenPunct && token.Text == ")") {
+		p.next()
+	}
+	return &BadExpr{Span: span}
+}
+
+// parseParenArgs parses a parenthesized argument list
+func (p *parser) parseParenArgs() []*Arg {
+	p.expectPunct("(")
+	if p.acceptPunct(")") {
+		return nil
+	}
+	args := p.parseArgs(true)
+	p.expectPunct(")")
+	return args
+}
+
+// parseArgs parses comma-separated arguments, allowing omitted and named
+// arguments. Outside parentheses semicolons also separate arguments (Debug.Print a; b).
+func (p *parser) parseArgs(inParens bool) []*Arg {
+	var args []*Arg
+
+	atArgEnd := func() bool {
+		if inParens {
+			return p.atPunct(",") || p.atPunct(")") || p.atStmtEnd()
+		}
+		return p.atPunct(",") || p.atPunct(";") || p.atStmtEnd()
+	}
+
+	for {
+		arg := &Arg{}
+		arg.Start = p.startPos()
+
+		if token := p.cur(); token.IsWord() && p.peek(1).Kind == TokenPunct && p.peek(1).Text == ":=" {
+			p.next()
+			p.next()
+			arg.Name = token.Text
+		}
+		if !atArgEnd() {
+			p.accept("ByVal")
+			arg.Value = p.parseExpr()
+		}
+		arg.Stop = p.prevEnd()
+		args = append(args, arg)
+
+		if p.acceptPunct(",") || (!inParens && p.acceptPunct(";")) {
+			if !inParens && p.atStmtEnd() {
+				// Debug.Print a; keeps the cursor on the line
+				return args
+			}
+			continue
+		}
+		return args
+	}
+}
+
+// unquote removes the quotes from a string literal and collapses doubled quotes
+func unquote(raw string) string {
+	if len(raw) >= 2 && raw[0] == '"' && raw[len(raw)-1] == '"' {
+		raw = raw[1 : len(raw)-1]
+	}
+	return strings.ReplaceAll(raw, `""`, `"`)
+}
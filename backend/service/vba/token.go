@@ -0,0 +1,148 @@
+package vba
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pos is a position in VBA source code. Line and Column are 1-based,
+// Column counts characters (not bytes), Offset is the 0-based byte offset.
+type Pos struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// IsValid reports whether the position refers to a location in the source
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+// String returns the position formatted as "line:column"
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// TokenKind identifies the lexical class of a token
+type TokenKind int
+
+const (
+	TokenEOF          TokenKind = iota // End of input
+	TokenIllegal                       // Unrecognized or malformed input
+	TokenNewline                       // End of a physical line
+	TokenComment                       // ' comment or Rem comment
+	TokenContinuation                  // " _" line continuation
+	TokenIdent                         // Identifier, including [bracketed] names
+	TokenKeyword                       // Reserved word
+	TokenInteger                       // Integer literal, including &H and &O forms
+	TokenFloat                         // Floating point literal
+	TokenString                        // String literal, quotes included
+	TokenDate                          // Date literal such as #1/31/2024#
+	TokenOperator                      // Arithmetic, comparison and concatenation operators
+	TokenPunct                         // ( ) , . ! ; # :=
+	TokenColon                         // : statement separator
+	TokenDirective                     // Conditional compilation line (#If, #Else, #Const...)
+)
+
+var tokenKindNames = map[TokenKind]string{
+	TokenEOF:          "EOF",
+	TokenIllegal:      "Illegal",
+	TokenNewline:      "Newline",
+	TokenComment:      "Comment",
+	TokenContinuation: "Continuation",
+	TokenIdent:        "Ident",
+	TokenKeyword:      "Keyword",
+	TokenInteger:      "Integer",
+	TokenFloat:        "Float",
+	TokenString:       "String",
+	TokenDate:         "Date",
+	TokenOperator:     "Operator",
+	TokenPunct:        "Punct",
+	TokenColon:        "Colon",
+	TokenDirective:    "Directive",
+}
+
+// String returns the name of the token kind
+func (k TokenKind) String() string {
+	if name, ok := tokenKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("TokenKind(%d)", int(k))
+}
+
+// Token is a single lexical element of VBA source
+type Token struct {
+	Kind  TokenKind
+	Text  string // Exact source text
+	Pos   Pos    // Position of the first character
+	End   Pos    // Position just after the last character
+	Space bool   // Whether whitespace precedes the token on the same line
+}
+
+// Is reports whether the token is the given keyword or identifier,
+// compared case-insensitively as VBA does
+func (t Token) Is(word string) bool {
+	return (t.Kind == TokenKeyword || t.Kind == TokenIdent) && strings.EqualFold(t.Text, word)
+}
+
+// IsWord reports whether the token is an identifier or keyword
+func (t Token) IsWord() bool {
+	return t.Kind == TokenIdent || t.Kind == TokenKeyword
+}
+
+// IsEndOfStatement reports whether the token terminates a statement
+func (t Token) IsEndOfStatement() bool {
+	switch t.Kind {
+	case TokenEOF, TokenNewline, TokenColon, TokenComment:
+		return true
+	}
+	return false
+}
+
+// String returns a short description of the token for error messages
+func (t Token) String() string {
+	switch t.Kind {
+	case TokenEOF:
+		return "end of file"
+	case TokenNewline:
+		return "end of line"
+	}
+	return fmt.Sprintf("%q", t.Text)
+}
+
+// keywords maps lower-case reserved words to their canonical casing
+var keywords = map[string]string{}
+
+func init() {
+	for _, word := range []string{
+		"AddressOf", "Alias", "And", "Any", "As", "Attribute", "Boolean", "ByRef",
+		"Byte", "ByVal", "Call", "Case", "Const", "Currency", "Date", "Declare",
+		"Dim", "Do", "Double", "Each", "Else", "ElseIf", "Empty", "End", "Enum",
+		"Eqv", "Erase", "Error", "Event", "Exit", "False", "For", "Friend",
+		"Function", "Get", "Global", "GoSub", "GoTo", "If", "Imp", "Implements",
+		"In", "Integer", "Is", "Let", "Lib", "Like", "Long", "LongLong", "LongPtr",
+		"Loop", "LSet", "Me", "Mod", "New", "Next", "Not", "Nothing", "Null",
+		"Object", "On", "Option", "Optional", "Or", "ParamArray", "Preserve",
+		"Private", "Property", "PtrSafe", "Public", "RaiseEvent", "ReDim", "Resume",
+		"Return", "RSet", "Select", "Set", "Single", "Static", "Step", "Stop",
+		"String", "Sub", "Then", "To", "True", "Type", "TypeOf", "Until", "Variant",
+		"Wend", "While", "With", "WithEvents", "Xor",
+	} {
+		keywords[strings.ToLower(word)] = word
+	}
+}
+
+// IsKeyword reports whether word is a VBA reserved word
+func IsKeyword(word string) bool {
+	_, ok := keywords[strings.ToLower(word)]
+	return ok
+}
+
+// CanonicalKeyword returns the canonical casing of a reserved word, or the
+// word unchanged if it is not reserved
+func CanonicalKeyword(word string) string {
+	if canonical, ok := keywords[strings.ToLower(word)]; ok {
+		return canonical
+	}
+	return word
+}
@@ -0,0 +1,210 @@
+package vba
+
+// Inspect traverses the tree rooted at node in depth-first order. It calls
+// f(node) for every node; if f returns true, Inspect visits the children of
+// the node. Procedure bodies, clauses, parameters, declarations,
+// arguments and the operands of raw statements are all visited.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Module:
+		for _, attr := range n.Attributes {
+			Inspect(attr, f)
+		}
+		for _, option := range n.Options {
+			Inspect(option, f)
+		}
+		inspectStmts(n.Declarations, f)
+		for _, proc := range n.Procedures {
+			Inspect(proc, f)
+		}
+
+	case *Procedure:
+		for _, param := range n.Params {
+			Inspect(param, f)
+		}
+		inspectTypeRef(n.ReturnType, f)
+		inspectStmts(n.Body, f)
+
+	case *Param:
+		inspectTypeRef(n.Type, f)
+		inspectExpr(n.Default, f)
+
+	case *TypeRef:
+		inspectExpr(n.Length, f)
+
+	case *VarDecl:
+		for _, bound := range n.Bounds {
+			Inspect(bound, f)
+		}
+		inspectTypeRef(n.Type, f)
+
+	case *ArrayBound:
+		inspectExpr(n.Lower, f)
+		inspectExpr(n.Upper, f)
+
+	case *AttributeStmt:
+		inspectExpr(n.Value, f)
+
+	case *DimStmt:
+		for _, v := range n.Vars {
+			Inspect(v, f)
+		}
+
+	case *ReDimStmt:
+		for _, v := range n.Vars {
+			Inspect(v, f)
+		}
+
+	case *ConstStmt:
+		for _, c := range n.Consts {
+			Inspect(c, f)
+		}
+
+	case *ConstDecl:
+		inspectTypeRef(n.Type, f)
+		inspectExpr(n.Value, f)
+
+	case *DeclareStmt:
+		for _, param := range n.Params {
+			Inspect(param, f)
+		}
+		inspectTypeRef(n.ReturnType, f)
+
+	case *TypeDef:
+		for _, field := range n.Fields {
+			Inspect(field, f)
+		}
+
+	case *EnumDef:
+		for _, member := range n.Members {
+			Inspect(member, f)
+		}
+
+	case *EnumMember:
+		inspectExpr(n.Value, f)
+
+	case *IfStmt:
+		inspectExpr(n.Cond, f)
+		inspectStmts(n.Then, f)
+		for _, clause := range n.ElseIfs {
+			Inspect(clause, f)
+		}
+		inspectStmts(n.Else, f)
+
+	case *ElseIfClause:
+		inspectExpr(n.Cond, f)
+		inspectStmts(n.Body, f)
+
+	case *ForStmt:
+		inspectExpr(n.Var, f)
+		inspectExpr(n.From, f)
+		inspectExpr(n.To, f)
+		inspectExpr(n.Step, f)
+		inspectStmts(n.Body, f)
+
+	case *ForEachStmt:
+		inspectExpr(n.Var, f)
+		inspectExpr(n.Group, f)
+		inspectStmts(n.Body, f)
+
+	case *DoStmt:
+		inspectExpr(n.Cond, f)
+		inspectStmts(n.Body, f)
+
+	case *WhileStmt:
+		inspectExpr(n.Cond, f)
+		inspectStmts(n.Body, f)
+
+	case *SelectStmt:
+		inspectExpr(n.Expr, f)
+		for _, clause := range n.Cases {
+			Inspect(clause, f)
+		}
+
+	case *CaseClause:
+		for _, cond := range n.Conditions {
+			inspectExpr(cond, f)
+		}
+		inspectStmts(n.Body, f)
+
+	case *WithStmt:
+		inspectExpr(n.Object, f)
+		inspectStmts(n.Body, f)
+
+	case *AssignStmt:
+		inspectExpr(n.Target, f)
+		inspectExpr(n.Value, f)
+
+	case *CallStmt:
+		inspectExpr(n.Target, f)
+		for _, arg := range n.Args {
+			Inspect(arg, f)
+		}
+
+	case *EraseStmt:
+		for _, name := range n.Names {
+			inspectExpr(name, f)
+		}
+
+	case *RawStmt:
+		for _, expr := range n.Exprs {
+			inspectExpr(expr, f)
+		}
+
+	case *MemberExpr:
+		inspectExpr(n.X, f)
+
+	case *CallExpr:
+		inspectExpr(n.Fun, f)
+		for _, arg := range n.Args {
+			Inspect(arg, f)
+		}
+
+	case *Arg:
+		inspectExpr(n.Value, f)
+
+	case *BinaryExpr:
+		inspectExpr(n.X, f)
+		inspectExpr(n.Y, f)
+
+	case *UnaryExpr:
+		inspectExpr(n.X, f)
+
+	case *ParenExpr:
+		inspectExpr(n.X, f)
+
+	case *TypeOfExpr:
+		inspectExpr(n.X, f)
+
+	case *CaseRange:
+		inspectExpr(n.Low, f)
+		inspectExpr(n.High, f)
+
+	case *CaseIs:
+		inspectExpr(n.Value, f)
+	}
+}
+
+func inspectStmts(stmts []Stmt, f func(Node) bool) {
+	for _, stmt := range stmts {
+		Inspect(stmt, f)
+	}
+}
+
+// inspectExpr skips nil expressions, which are common for optional parts
+func inspectExpr(expr Expr, f func(Node) bool) {
+	if expr != nil {
+		Inspect(expr, f)
+	}
+}
+
+// inspectTypeRef avoids passing a nil *TypeRef as a non-nil Node
+func inspectTypeRef(ref *TypeRef, f func(Node) bool) {
+	if ref != nil {
+		Inspect(ref, f)
+	}
+}
@@ -0,0 +1,302 @@
+package vbaexport
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"excel-automation-mcp/backend/service/vbaproject"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Files written next to the modules in a bundle
+const (
+	ManifestFile     = "manifest.json"
+	InstructionsFile = "INSTALL.txt"
+)
+
+// Document module bases, as written by the VBA editor when it exports
+// ThisWorkbook and a worksheet
+const (
+	workbookBase  = "0{00020819-0000-0000-C000-000000000046}"
+	worksheetBase = "0{00020820-0000-0000-C000-000000000046}"
+)
+
+// DefaultCodePage is the code page of the VBA editor on Western European
+// Windows
+const DefaultCodePage = 1252
+
+// Options controls how a response is bundled
+type Options struct {
+	Name          string // Bundle name used in the manifest (default "vba-modules")
+	DefaultModule string // Module for code not assigned to one (default DefaultModuleName)
+	CodePage      int    // Windows code page of the module files, that of the importing VBA editor (default DefaultCodePage; 936 for Simplified Chinese)
+}
+
+// Bundle is a set of importable modules split out of one response
+type Bundle struct {
+	Name     string    `json:"name"`
+	Created  time.Time `json:"created"`
+	CodePage int       `json:"codePage"`
+	Modules  []Module  `json:"modules"`
+	Warnings []string  `json:"warnings"`
+}
+
+// Manifest describes the files of a bundle
+type Manifest struct {
+	Name     string           `json:"name"`
+	Created  time.Time        `json:"created"`
+	CodePage int              `json:"codePage"`
+	Encoding string           `json:"encoding"` // Name of the code page, such as "windows-1252" or "gbk"
+	Modules  []ManifestModule `json:"modules"`
+	Warnings []string         `json:"warnings"`
+}
+
+// ManifestModule is the manifest entry of one module file
+type ManifestModule struct {
+	Name       string                `json:"name"`
+	Type       vbaproject.ModuleType `json:"type"`
+	File       string                `json:"file"`
+	Procedures []string              `json:"procedures"`
+	Lines      int                   `json:"lines"`
+	Install    string                `json:"install"` // How to add the file to a workbook
+}
+
+// NewBundle splits a response into modules ready for export
+func NewBundle(response string, opts Options) *Bundle {
+	if opts.Name == "" {
+		opts.Name = "vba-modules"
+	}
+	if opts.CodePage == 0 {
+		opts.CodePage = DefaultCodePage
+	}
+	modules, warnings := Split(response, opts.DefaultModule)
+	if modules == nil {
+		modules = []Module{}
+	}
+	if warnings == nil {
+		warnings = []string{}
+	}
+	if len(modules) == 0 {
+		warnings = append(warnings, "the response contains no VBA code")
+	}
+	return &Bundle{Name: opts.Name, Created: time.Now(), CodePage: opts.CodePage, Modules: modules, Warnings: warnings}
+}
+
+// Source returns the module file as the VBA editor exports it: the header
+// for its type, Attribute VB_Name and the code, with CRLF line endings
+func (m Module) Source() string {
+	var header []string
+	switch m.Type {
+	case vbaproject.ModuleClass:
+		header = []string{
+			"VERSION 1.0 CLASS",
+			"BEGIN",
+			"  MultiUse = -1  'True",
+			"END",
+			fmt.Sprintf("Attribute VB_Name = %q", m.Name),
+			"Attribute VB_GlobalNameSpace = False",
+			"Attribute VB_Creatable = False",
+			"Attribute VB_PredeclaredId = False",
+			"Attribute VB_Exposed = False",
+		}
+	case vbaproject.ModuleDocument:
+		base := worksheetBase
+		if strings.EqualFold(m.Name, "ThisWorkbook") {
+			base = workbookBase
+		}
+		header = []string{
+			"VERSION 1.0 CLASS",
+			"BEGIN",
+			"  MultiUse = -1  'True",
+			"END",
+			fmt.Sprintf("Attribute VB_Name = %q", m.Name),
+			fmt.Sprintf("Attribute VB_Base = %q", base),
+			"Attribute VB_GlobalNameSpace = False",
+			"Attribute VB_Creatable = False",
+			"Attribute VB_PredeclaredId = True",
+			"Attribute VB_Exposed = True",
+			"Attribute VB_TemplateDerived = False",
+			"Attribute VB_Customizable = True",
+		}
+	case vbaproject.ModuleForm:
+		header = []string{
+			"VERSION 5.00",
+			"Begin {C62A69F0-16DC-11CE-9E98-00AA00574A4F} " + m.Name,
+			fmt.Sprintf("   Caption         =   %q", m.Name),
+			"   ClientHeight    =   3015",
+			"   ClientLeft      =   120",
+			"   ClientTop       =   465",
+			"   ClientWidth     =   4560",
+			"   StartUpPosition =   1  'CenterOwner",
+			"End",
+			fmt.Sprintf("Attribute VB_Name = %q", m.Name),
+			"Attribute VB_GlobalNameSpace = False",
+			"Attribute VB_Creatable = False",
+			"Attribute VB_PredeclaredId = True",
+			"Attribute VB_Exposed = False",
+		}
+	default:
+		header = []string{fmt.Sprintf("Attribute VB_Name = %q", m.Name)}
+	}
+	code := strings.TrimRight(m.Code, "\n")
+	return strings.ReplaceAll(strings.Join(header, "\n")+"\n"+code+"\n", "\n", "\r\n")
+}
+
+// Encode returns the module file in a Windows code page, the one the VBA
+// editor that imports it runs with. It fails rather than lose characters
+// the code page cannot hold.
+func (m Module) Encode(codePage int) ([]byte, error) {
+	enc, err := codePageEncoding(codePage)
+	if err != nil {
+		return nil, err
+	}
+	source := m.Source()
+	data, err := enc.NewEncoder().Bytes([]byte(source))
+	if err == nil {
+		return data, nil
+	}
+	for i, line := range strings.Split(source, "\r\n") {
+		if _, err := enc.NewEncoder().String(line); err != nil {
+			return nil, fmt.Errorf("%s: line %d has characters code page %d cannot hold; export with the code page of the VBA editor that imports the file: %s",
+				m.File, i+1, codePage, strings.TrimSpace(line))
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", m.File, err)
+}
+
+// codePageEncoding returns the encoding of a code page the VBA editor uses
+func codePageEncoding(codePage int) (encoding.Encoding, error) {
+	enc, ok := vbaproject.CodePageEncoding(codePage)
+	if !ok {
+		return nil, fmt.Errorf("unsupported code page %d", codePage)
+	}
+	return enc, nil
+}
+
+// codePageName returns the common name of a code page
+func codePageName(codePage int) string {
+	if enc, err := codePageEncoding(codePage); err == nil {
+		if name, err := htmlindex.Name(enc); err == nil {
+			return name
+		}
+	}
+	return fmt.Sprintf("cp%d", codePage)
+}
+
+// Manifest describes the bundle's files
+func (b *Bundle) Manifest() Manifest {
+	manifest := Manifest{
+		Name:     b.Name,
+		Created:  b.Created,
+		CodePage: b.CodePage,
+		Encoding: codePageName(b.CodePage),
+		Modules:  make([]ManifestModule, 0, len(b.Modules)),
+		Warnings: b.Warnings,
+	}
+	for _, module := range b.Modules {
+		manifest.Modules = append(manifest.Modules, ManifestModule{
+			Name:       module.Name,
+			Type:       module.Type,
+			File:       module.File,
+			Procedures: module.Procedures,
+			Lines:      module.Lines,
+			Install:    installStep(module),
+		})
+	}
+	return manifest
+}
+
+// installStep tells how one module gets into a workbook
+func installStep(m Module) string {
+	switch m.Type {
+	case vbaproject.ModuleDocument:
+		return fmt.Sprintf("Open %s in the Project Explorer and paste the code below the header lines of %s; importing would create a new class instead", m.Name, m.File)
+	case vbaproject.ModuleForm:
+		return fmt.Sprintf("File > Import File... > %s, then add the controls the code refers to", m.File)
+	}
+	return fmt.Sprintf("File > Import File... > %s", m.File)
+}
+
+// Instructions returns the install instructions written to INSTALL.txt
+func (b *Bundle) Instructions() string {
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("%s\n%s\n\n", b.Name, strings.Repeat("=", len(b.Name))))
+	text.WriteString("This bundle holds VBA modules to add to an Excel workbook.\n\n")
+	text.WriteString("1. Open the workbook and press Alt+F11 to open the VBA editor.\n")
+	text.WriteString("2. Add each module as listed below.\n")
+	text.WriteString("3. Choose Debug > Compile VBAProject to check the code.\n")
+	text.WriteString("4. Save the workbook as .xlsm (Excel Macro-Enabled Workbook), or the macros are lost.\n\n")
+
+	text.WriteString("Modules\n-------\n")
+	for _, module := range b.Modules {
+		text.WriteString(fmt.Sprintf("- %s (%s module): %s\n", module.Name, module.Type, installStep(module)))
+		if len(module.Procedures) > 0 {
+			text.WriteString("  Procedures: " + strings.Join(module.Procedures, ", ") + "\n")
+		}
+	}
+
+	text.WriteString("\nNotes\n-----\n")
+	text.WriteString("- If a module with the same name exists, the editor imports the file under a new name; remove or rename the old module first.\n")
+	text.WriteString(fmt.Sprintf("- The files are saved in code page %d (%s). The VBA editor reads them in the code page Windows uses for non-Unicode programs; if text looks garbled, the two differ.\n",
+		b.CodePage, codePageName(b.CodePage)))
+	for _, module := range b.Modules {
+		if module.Type == vbaproject.ModuleForm {
+			text.WriteString("- UserForms are exported without their controls; add them in the form designer with the names the code uses.\n")
+			break
+		}
+	}
+	for _, warning := range b.Warnings {
+		text.WriteString("- " + warning + "\n")
+	}
+	return strings.ReplaceAll(text.String(), "\n", "\r\n")
+}
+
+// WriteZip writes the module files, manifest.json and INSTALL.txt as a zip
+// archive. Nothing is written when a module does not fit the code page.
+func (b *Bundle) WriteZip(w io.Writer) error {
+	files := make([][]byte, len(b.Modules))
+	for i, module := range b.Modules {
+		data, err := module.Encode(b.CodePage)
+		if err != nil {
+			return err
+		}
+		files[i] = data
+	}
+
+	archive := zip.NewWriter(w)
+	for i, module := range b.Modules {
+		if err := writeFile(archive, module.File, files[i], b.Created); err != nil {
+			return err
+		}
+	}
+
+	manifest, err := json.MarshalIndent(b.Manifest(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFile(archive, ManifestFile, manifest, b.Created); err != nil {
+		return err
+	}
+	if err := writeFile(archive, InstructionsFile, []byte(b.Instructions()), b.Created); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// writeFile adds one file to the archive
+func writeFile(archive *zip.Writer, name string, data []byte, modified time.Time) error {
+	f, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,470 @@
+// Package vbaexport turns generated VBA into files the VBA editor can import:
+// it splits an LLM response into modules, writes each as a .bas, .cls or .frm
+// file with the proper header, and bundles them with a manifest and
+// install instructions.
+package vbaexport
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"excel-automation-mcp/backend/service/validation"
+	"excel-automation-mcp/backend/service/vba"
+	"excel-automation-mcp/backend/service/vbaproject"
+)
+
+// DefaultModuleName names the standard module that collects code the
+// response does not assign to a module
+const DefaultModuleName = "GeneratedCode"
+
+// maxNameLength is the longest module name the VBA editor accepts
+const maxNameLength = 31
+
+// Module is one module split out of a response
+type Module struct {
+	Name       string                `json:"name"`
+	Type       vbaproject.ModuleType `json:"type"`
+	File       string                `json:"file"`       // e.g. "GeneratedCode.bas"
+	Code       string                `json:"code"`       // Code without the file header
+	Procedures []string              `json:"procedures"` // Procedure names in source order
+	Lines      int                   `json:"lines"`
+
+	origins []int // Line of each code line in the joined response code, 0 for added lines
+}
+
+// label is what a heading or separator comment says about the module that follows
+type label struct {
+	name string
+	kind vbaproject.ModuleType
+}
+
+// part is a run of code that belongs to one module
+type part struct {
+	label   label
+	lines   []string
+	origins []int // Line of each line in the code joined by validation.ExtractVBACode
+}
+
+var (
+	// "Class Module: clsOrder", "Module - Helpers", "UserForm: frmInput"
+	kindNamePattern = regexp.MustCompile(`(?i)^(?:\d+[.)]\s*)?(standard module|code module|class module|userform|user form|sheet module|worksheet module|workbook module|document module|module|class|form)\s*[:\-–]\s*([A-Za-z][A-Za-z0-9_]*)`)
+	// "clsOrder (Class Module)", "Helpers module"
+	nameKindPattern = regexp.MustCompile(`(?i)^(?:\d+[.)]\s*)?([A-Za-z][A-Za-z0-9_]*)\s*\(?\s*(standard module|code module|class module|userform|user form|sheet module|worksheet module|workbook module|document module|module|class|form)\s*\)?\s*:?$`)
+	// "Place this in the ThisWorkbook module", "Code for Sheet1"
+	placePattern = regexp.MustCompile(`(?i)\b(?:code for|place (?:this |it )?in(?:to)?|put (?:this |it )?in(?:to)?|paste (?:this |it )?in(?:to)?|add (?:this |it )?to|goes in(?:to)?)\s+(?:the\s+)?(ThisWorkbook|Sheet\d+)\b`)
+	// "ThisWorkbook module", "Sheet1 code"
+	documentPattern = regexp.MustCompile(`(?i)^(ThisWorkbook|Sheet\d+)(?:\s*\([^)]*\))?\s+(?:module|code)\b`)
+
+	vbNamePattern    = regexp.MustCompile(`(?i)^\s*Attribute\s+VB_Name\s*=\s*"([^"]*)"`)
+	attributePattern = regexp.MustCompile(`(?i)^\s*Attribute\s+VB_\w+\s*=`)
+	procStartPattern = regexp.MustCompile(`(?i)^\s*(?:(?:public|private|friend)\s+)?(?:static\s+)?(?:sub|function|property\s+(?:get|let|set))\s+[A-Za-z]`)
+	procEndPattern   = regexp.MustCompile(`(?i)^\s*end\s+(?:sub|function|property)\b`)
+	eventPattern     = regexp.MustCompile(`(?im)^\s*(?:(?:public|private)\s+)?sub\s+(workbook|worksheet|userform|class)_\w+`)
+	optionPattern    = regexp.MustCompile(`(?i)^\s*option\s+\w+`)
+)
+
+// kindWords maps the words of a heading to a module type
+var kindWords = map[string]vbaproject.ModuleType{
+	"standard module":  vbaproject.ModuleStandard,
+	"code module":      vbaproject.ModuleStandard,
+	"module":           vbaproject.ModuleStandard,
+	"class module":     vbaproject.ModuleClass,
+	"class":            vbaproject.ModuleClass,
+	"userform":         vbaproject.ModuleForm,
+	"user form":        vbaproject.ModuleForm,
+	"form":             vbaproject.ModuleForm,
+	"sheet module":     vbaproject.ModuleDocument,
+	"worksheet module": vbaproject.ModuleDocument,
+	"workbook module":  vbaproject.ModuleDocument,
+	"document module":  vbaproject.ModuleDocument,
+}
+
+// Split divides the VBA of an LLM response into modules. Modules are told
+// apart by Attribute VB_Name lines, separator comments such as
+// "' ===== Class Module: clsOrder =====", the heading above each code block
+// and the event procedures they contain. Code that belongs to no named
+// module is collected in one standard module called defaultName.
+func Split(response string, defaultName string) ([]Module, []string) {
+	if defaultName == "" {
+		defaultName = DefaultModuleName
+	}
+	var warnings []string
+	responseLines := strings.Split(strings.ReplaceAll(response, "\r\n", "\n"), "\n")
+
+	var parts []*part
+	start := 1
+	for _, block := range validation.ExtractVBABlocks(response) {
+		current := &part{label: headingBefore(responseLines, block.StartLine-2)}
+		parts = append(parts, current)
+		inProc, headerDepth := false, 0
+		for i, line := range strings.Split(block.Code, "\n") {
+			line = strings.TrimRight(line, "\r")
+			trimmed := strings.TrimSpace(line)
+			if !inProc {
+				// File headers of exported modules are rebuilt on export
+				upper := strings.ToUpper(trimmed)
+				switch {
+				case headerDepth > 0:
+					// Form designer blocks nest a Begin ... End per control
+					if upper == "END" {
+						headerDepth--
+					} else if strings.HasPrefix(upper, "BEGIN ") {
+						headerDepth++
+					}
+					continue
+				case strings.HasPrefix(upper, "VERSION ") && strings.HasSuffix(upper, "CLASS"):
+					current = nextPart(&parts, current, label{kind: vbaproject.ModuleClass})
+					continue
+				case strings.HasPrefix(upper, "VERSION "):
+					current = nextPart(&parts, current, label{kind: vbaproject.ModuleForm})
+					continue
+				case upper == "BEGIN" || strings.HasPrefix(upper, "BEGIN {"):
+					headerDepth = 1
+					continue
+				}
+				if m := vbNamePattern.FindStringSubmatch(line); m != nil {
+					current = nextPart(&parts, current, label{name: m[1]})
+					continue
+				}
+				if attributePattern.MatchString(line) {
+					continue
+				}
+				if l, ok := commentLabel(trimmed); ok {
+					current = nextPart(&parts, current, l)
+					continue
+				}
+			}
+			if procStartPattern.MatchString(line) {
+				inProc = true
+			} else if procEndPattern.MatchString(line) {
+				inProc = false
+			}
+			current.lines = append(current.lines, line)
+			current.origins = append(current.origins, start+i)
+		}
+		// Blocks are joined with a blank line between them
+		start += strings.Count(block.Code, "\n") + 2
+	}
+
+	var modules []Module
+	counters := map[vbaproject.ModuleType]int{}
+	for _, p := range parts {
+		lines, origins := trimEmpty(p.lines, p.origins)
+		code := strings.Join(lines, "\n")
+		if strings.TrimSpace(code) == "" {
+			continue
+		}
+		kind, name := resolve(p.label, code)
+		if name == "" {
+			name = defaultNameFor(kind, defaultName, counters)
+		}
+		if kind == vbaproject.ModuleDocument && p.label.name == "" && name == "Sheet1" {
+			warnings = append(warnings, "worksheet event code was put in Sheet1; paste it into the module of the sheet it is for")
+		}
+		if clean := moduleName(name); clean != name {
+			warnings = append(warnings, fmt.Sprintf("module name %q is not a valid VBA name; using %s", name, clean))
+			name = clean
+		}
+		if kind == vbaproject.ModuleDocument && !isDocumentName(name) {
+			warnings = append(warnings, fmt.Sprintf("%s looks like sheet or workbook event code; paste it into the matching sheet module", name))
+		}
+
+		merged := false
+		for i := range modules {
+			if strings.EqualFold(modules[i].Name, name) {
+				if modules[i].Type != kind {
+					warnings = append(warnings, fmt.Sprintf("code for %s is labelled both %s and %s; keeping %s", name, modules[i].Type, kind, modules[i].Type))
+				}
+				modules[i].Code, modules[i].origins = mergeCode(modules[i].Code, modules[i].origins, lines, origins)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			modules = append(modules, Module{Name: name, Type: kind, Code: code, origins: origins})
+		}
+	}
+
+	for i := range modules {
+		module := &modules[i]
+		module.Code += "\n"
+		module.File = module.Name + extension(module.Type)
+		module.Procedures = procedureNames(module.Code)
+		module.Lines = strings.Count(module.Code, "\n")
+	}
+	return modules, warnings
+}
+
+// Validate checks each module of a response on its own, so procedures of
+// the same name in different modules are not duplicates. Positions are
+// those of the code joined by validation.ExtractVBACode.
+func Validate(response string) *validation.Report {
+	report := validation.NewReport()
+	modules, _ := Split(response, "")
+	for _, module := range modules {
+		for _, d := range validation.ValidateVBA(module.Code).Diagnostics {
+			d.Line, d.EndLine = module.origin(d.Line), module.origin(d.EndLine)
+			report.Add(d)
+		}
+	}
+	report.Sort()
+	return report
+}
+
+// origin maps a line of the module code to the joined response code. Lines
+// added by the split take the line of the code before them.
+func (m Module) origin(line int) int {
+	for i := min(line, len(m.origins)) - 1; i >= 0; i-- {
+		if m.origins[i] > 0 {
+			return m.origins[i]
+		}
+	}
+	return line
+}
+
+// nextPart starts a new part for a module label, or labels the current part
+// when it has no code yet
+func nextPart(parts *[]*part, current *part, l label) *part {
+	hasCode := false
+	for _, line := range current.lines {
+		if strings.TrimSpace(line) != "" {
+			hasCode = true
+			break
+		}
+	}
+	if !hasCode {
+		if l.name != "" {
+			current.label.name = l.name
+		}
+		if l.kind != "" {
+			current.label.kind = l.kind
+		}
+		return current
+	}
+	next := &part{label: l}
+	*parts = append(*parts, next)
+	return next
+}
+
+// headingBefore reads the module label from the prose just above a code
+// fence at the given 0-based line
+func headingBefore(lines []string, fence int) label {
+	checked := 0
+	for i := fence - 1; i >= 0 && checked < 2; i-- {
+		text := strings.TrimSpace(lines[i])
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "```") {
+			break
+		}
+		checked++
+		text = strings.Trim(text, "#*_>`: \t")
+		text = strings.ReplaceAll(text, "`", "")
+		text = strings.ReplaceAll(text, "*", "")
+		if l, ok := parseLabel(text); ok {
+			return l
+		}
+		if m := placePattern.FindStringSubmatch(text); m != nil {
+			return label{name: m[1], kind: vbaproject.ModuleDocument}
+		}
+	}
+	return label{}
+}
+
+// commentLabel recognizes a separator comment that names a module. Only
+// short comments are considered so ordinary comments are left in the code.
+func commentLabel(trimmed string) (label, bool) {
+	var text string
+	switch {
+	case strings.HasPrefix(trimmed, "'"):
+		text = trimmed[1:]
+	case len(trimmed) > 4 && strings.EqualFold(trimmed[:4], "Rem "):
+		text = trimmed[4:]
+	default:
+		return label{}, false
+	}
+	text = strings.Trim(text, "'=-*#~ \t")
+	if text == "" || len(text) > 60 {
+		return label{}, false
+	}
+	if l, ok := parseLabel(text); ok {
+		return l, true
+	}
+	if m := placePattern.FindStringSubmatch(text); m != nil {
+		return label{name: m[1], kind: vbaproject.ModuleDocument}, true
+	}
+	return label{}, false
+}
+
+// parseLabel reads "Kind: Name", "Name (Kind)" and "ThisWorkbook module" labels
+func parseLabel(text string) (label, bool) {
+	if m := kindNamePattern.FindStringSubmatch(text); m != nil {
+		return label{name: m[2], kind: kindWords[strings.ToLower(m[1])]}, true
+	}
+	if m := documentPattern.FindStringSubmatch(text); m != nil {
+		return label{name: m[1], kind: vbaproject.ModuleDocument}, true
+	}
+	if m := nameKindPattern.FindStringSubmatch(text); m != nil {
+		if _, isKindWord := kindWords[strings.ToLower(m[1])]; !isKindWord && !isKindPrefix(m[1]) {
+			return label{name: m[1], kind: kindWords[strings.ToLower(m[2])]}, true
+		}
+	}
+	return label{}, false
+}
+
+// isKindPrefix reports whether a word only starts a kind, as in "Standard Module"
+func isKindPrefix(word string) bool {
+	switch strings.ToLower(word) {
+	case "standard", "code", "user", "sheet", "worksheet", "workbook", "document", "new", "vba", "the", "this":
+		return true
+	}
+	return false
+}
+
+// resolve settles the type and name of a part from its label and, failing
+// that, from the event procedures in its code
+func resolve(l label, code string) (vbaproject.ModuleType, string) {
+	kind, name := l.kind, l.name
+	if isDocumentName(name) {
+		kind = vbaproject.ModuleDocument
+	}
+	if kind == "" || (kind == vbaproject.ModuleStandard && l.name == "") {
+		events := map[string]bool{}
+		for _, m := range eventPattern.FindAllStringSubmatch(code, -1) {
+			events[strings.ToLower(m[1])] = true
+		}
+		switch {
+		case events["workbook"]:
+			kind = vbaproject.ModuleDocument
+			if name == "" {
+				name = "ThisWorkbook"
+			}
+		case events["worksheet"]:
+			kind = vbaproject.ModuleDocument
+			if name == "" {
+				name = "Sheet1"
+			}
+		case events["userform"]:
+			kind = vbaproject.ModuleForm
+		case events["class"]:
+			kind = vbaproject.ModuleClass
+		}
+	}
+	if kind == "" {
+		kind = vbaproject.ModuleStandard
+	}
+	return kind, name
+}
+
+// defaultNameFor names an unlabelled module after its type
+func defaultNameFor(kind vbaproject.ModuleType, defaultName string, counters map[vbaproject.ModuleType]int) string {
+	switch kind {
+	case vbaproject.ModuleClass:
+		counters[kind]++
+		return fmt.Sprintf("Class%d", counters[kind])
+	case vbaproject.ModuleForm:
+		counters[kind]++
+		return fmt.Sprintf("UserForm%d", counters[kind])
+	}
+	return defaultName
+}
+
+// isDocumentName reports whether a name is one of the modules Excel creates
+// for the workbook and its sheets
+func isDocumentName(name string) bool {
+	lower := strings.ToLower(name)
+	if lower == "thisworkbook" {
+		return true
+	}
+	if !strings.HasPrefix(lower, "sheet") || len(lower) == len("sheet") {
+		return false
+	}
+	for _, r := range lower[len("sheet"):] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// moduleName makes a name a valid VBA module name: a letter followed by
+// letters, digits and underscores, at most 31 characters
+func moduleName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	clean := b.String()
+	if clean == "" || clean[0] < 'A' || (clean[0] > 'Z' && clean[0] < 'a') || clean[0] > 'z' {
+		clean = "M" + clean
+	}
+	if len(clean) > maxNameLength {
+		clean = clean[:maxNameLength]
+	}
+	return clean
+}
+
+// mergeCode appends code given for the same module in several blocks,
+// dropping Option lines the module already has
+func mergeCode(existing string, existingOrigins []int, lines []string, origins []int) (string, []int) {
+	have := map[string]bool{}
+	for _, line := range strings.Split(existing, "\n") {
+		if optionPattern.MatchString(line) {
+			have[strings.ToLower(strings.Join(strings.Fields(line), " "))] = true
+		}
+	}
+	var kept []string
+	var keptOrigins []int
+	for i, line := range lines {
+		if optionPattern.MatchString(line) && have[strings.ToLower(strings.Join(strings.Fields(line), " "))] {
+			continue
+		}
+		kept = append(kept, line)
+		keptOrigins = append(keptOrigins, origins[i])
+	}
+	kept, keptOrigins = trimEmpty(kept, keptOrigins)
+	merged := append(append(append([]int{}, existingOrigins...), 0), keptOrigins...)
+	return existing + "\n\n" + strings.Join(kept, "\n"), merged
+}
+
+// trimEmpty drops the empty lines at either end of a run of lines
+func trimEmpty(lines []string, origins []int) ([]string, []int) {
+	for len(lines) > 0 && lines[0] == "" {
+		lines, origins = lines[1:], origins[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines, origins = lines[:len(lines)-1], origins[:len(origins)-1]
+	}
+	return lines, origins
+}
+
+// procedureNames lists the procedures of a module in source order
+func procedureNames(code string) []string {
+	names := []string{}
+	module, _ := vba.ParseModule(code)
+	if module == nil {
+		return names
+	}
+	for _, proc := range module.Procedures {
+		names = append(names, proc.Name)
+	}
+	return names
+}
+
+// extension is the file extension the VBA editor imports for a module type
+func extension(kind vbaproject.ModuleType) string {
+	switch kind {
+	case vbaproject.ModuleClass, vbaproject.ModuleDocument:
+		return ".cls"
+	case vbaproject.ModuleForm:
+		return ".frm"
+	}
+	return ".bas"
+}
@@ -0,0 +1,264 @@
+package vbaproject
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// cfbSignature starts every OLE compound file
+var cfbSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+// Special sector numbers of the allocation tables
+const (
+	maxRegularSector = 0xFFFFFFFA
+	endOfChain       = 0xFFFFFFFE
+	noStream         = 0xFFFFFFFF
+)
+
+// Directory entry types
+const (
+	entryStorage = 1
+	entryStream  = 2
+	entryRoot    = 5
+)
+
+// compoundFile is an OLE Compound File Binary container ([MS-CFB]) read into
+// memory: the sector chains and the directory tree
+type compoundFile struct {
+	data           []byte
+	sectorSize     int
+	miniSectorSize int
+	miniCutoff     uint64
+	fat            []uint32
+	miniFAT        []uint32
+	miniStream     []byte
+	entries        []dirEntry
+	paths          map[string]int // Lower-case path, such as "vba/dir", to entry index
+}
+
+// dirEntry is one 128-byte directory entry
+type dirEntry struct {
+	name               string
+	kind               byte
+	left, right, child uint32
+	start              uint32
+	size               uint64
+}
+
+// openCompoundFile parses the header, allocation tables and directory
+func openCompoundFile(data []byte) (*compoundFile, error) {
+	if len(data) < 512 || !bytes.Equal(data[:8], cfbSignature) {
+		return nil, errors.New("not an OLE compound file")
+	}
+	le := binary.LittleEndian
+	major := le.Uint16(data[0x1A:])
+	sectorShift := le.Uint16(data[0x1E:])
+	miniShift := le.Uint16(data[0x20:])
+	if (major != 3 || sectorShift != 9) && (major != 4 || sectorShift != 12) || miniShift != 6 {
+		return nil, fmt.Errorf("unsupported compound file version %d with %d-byte sectors", major, 1<<sectorShift)
+	}
+
+	cf := &compoundFile{
+		data:           data,
+		sectorSize:     1 << sectorShift,
+		miniSectorSize: 1 << miniShift,
+		miniCutoff:     uint64(le.Uint32(data[0x38:])),
+		paths:          map[string]int{},
+	}
+	fatSectors := int(le.Uint32(data[0x2C:]))
+	firstDir := le.Uint32(data[0x30:])
+	firstMiniFAT := le.Uint32(data[0x3C:])
+	firstDIFAT := le.Uint32(data[0x44:])
+
+	// The DIFAT lists the FAT sectors: 109 in the header, the rest in a chain
+	var difat []uint32
+	for i := 0; i < 109; i++ {
+		difat = append(difat, le.Uint32(data[0x4C+4*i:]))
+	}
+	perSector := cf.sectorSize/4 - 1
+	seen := map[uint32]bool{}
+	for sector := firstDIFAT; sector <= maxRegularSector && len(difat) < fatSectors+perSector; {
+		if seen[sector] {
+			return nil, errors.New("DIFAT chain loops")
+		}
+		seen[sector] = true
+		block, err := cf.sector(sector)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < perSector; i++ {
+			difat = append(difat, le.Uint32(block[4*i:]))
+		}
+		sector = le.Uint32(block[4*perSector:])
+	}
+	if fatSectors > len(difat) {
+		return nil, errors.New("truncated DIFAT")
+	}
+	for _, sector := range difat[:fatSectors] {
+		block, err := cf.sector(sector)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < cf.sectorSize; i += 4 {
+			cf.fat = append(cf.fat, le.Uint32(block[i:]))
+		}
+	}
+
+	dir, err := cf.chain(firstDir, -1)
+	if err != nil {
+		return nil, fmt.Errorf("directory: %w", err)
+	}
+	for off := 0; off+128 <= len(dir); off += 128 {
+		cf.entries = append(cf.entries, parseDirEntry(dir[off:off+128], major))
+	}
+	if len(cf.entries) == 0 || cf.entries[0].kind != entryRoot {
+		return nil, errors.New("missing root directory entry")
+	}
+
+	if firstMiniFAT <= maxRegularSector {
+		table, err := cf.chain(firstMiniFAT, -1)
+		if err != nil {
+			return nil, fmt.Errorf("mini FAT: %w", err)
+		}
+		for i := 0; i+4 <= len(table); i += 4 {
+			cf.miniFAT = append(cf.miniFAT, le.Uint32(table[i:]))
+		}
+	}
+	root := cf.entries[0]
+	if root.start <= maxRegularSector {
+		if cf.miniStream, err = cf.chain(root.start, int64(root.size)); err != nil {
+			return nil, fmt.Errorf("mini stream: %w", err)
+		}
+	}
+
+	cf.walk(root.child, "", map[uint32]bool{})
+	return cf, nil
+}
+
+// parseDirEntry decodes a directory entry; version 3 files only use the low
+// 32 bits of the stream size
+func parseDirEntry(b []byte, major uint16) dirEntry {
+	le := binary.LittleEndian
+	nameLen := int(le.Uint16(b[0x40:]))
+	if nameLen > 64 {
+		nameLen = 64
+	}
+	units := make([]uint16, 0, 32)
+	for i := 0; i+2 <= nameLen; i += 2 {
+		u := le.Uint16(b[i:])
+		if u == 0 {
+			break
+		}
+		units = append(units, u)
+	}
+	entry := dirEntry{
+		name:  string(utf16.Decode(units)),
+		kind:  b[0x42],
+		left:  le.Uint32(b[0x44:]),
+		right: le.Uint32(b[0x48:]),
+		child: le.Uint32(b[0x4C:]),
+		start: le.Uint32(b[0x74:]),
+		size:  le.Uint64(b[0x78:]),
+	}
+	if major == 3 {
+		entry.size &= 0xFFFFFFFF
+	}
+	return entry
+}
+
+// walk records the path of every entry below a storage. Siblings form a
+// tree through their left and right links.
+func (cf *compoundFile) walk(id uint32, prefix string, seen map[uint32]bool) {
+	if id == noStream || int(id) >= len(cf.entries) || seen[id] {
+		return
+	}
+	seen[id] = true
+	entry := cf.entries[id]
+	cf.walk(entry.left, prefix, seen)
+	cf.walk(entry.right, prefix, seen)
+	path := prefix + strings.ToLower(entry.name)
+	cf.paths[path] = int(id)
+	if entry.kind == entryStorage {
+		cf.walk(entry.child, path+"/", seen)
+	}
+}
+
+// sector returns the bytes of a regular sector
+func (cf *compoundFile) sector(n uint32) ([]byte, error) {
+	off := (int64(n) + 1) * int64(cf.sectorSize)
+	if n > maxRegularSector || off+int64(cf.sectorSize) > int64(len(cf.data)) {
+		return nil, fmt.Errorf("sector %d is outside the file", n)
+	}
+	return cf.data[off : off+int64(cf.sectorSize)], nil
+}
+
+// chain concatenates a chain of regular sectors, cut to size unless it is negative
+func (cf *compoundFile) chain(start uint32, size int64) ([]byte, error) {
+	var out []byte
+	seen := map[uint32]bool{}
+	for sector := start; sector != endOfChain; {
+		if int(sector) >= len(cf.fat) || seen[sector] {
+			return nil, fmt.Errorf("broken sector chain at %d", sector)
+		}
+		seen[sector] = true
+		block, err := cf.sector(sector)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, block...)
+		if size >= 0 && int64(len(out)) >= size {
+			break
+		}
+		sector = cf.fat[sector]
+	}
+	if size >= 0 {
+		if int64(len(out)) < size {
+			return nil, errors.New("stream is shorter than its directory entry")
+		}
+		out = out[:size]
+	}
+	return out, nil
+}
+
+// miniChain concatenates a chain of mini sectors from the mini stream
+func (cf *compoundFile) miniChain(start uint32, size int64) ([]byte, error) {
+	var out []byte
+	seen := map[uint32]bool{}
+	for sector := start; sector != endOfChain && int64(len(out)) < size; {
+		off := int64(sector) * int64(cf.miniSectorSize)
+		if int(sector) >= len(cf.miniFAT) || seen[sector] || off+int64(cf.miniSectorSize) > int64(len(cf.miniStream)) {
+			return nil, fmt.Errorf("broken mini sector chain at %d", sector)
+		}
+		seen[sector] = true
+		out = append(out, cf.miniStream[off:off+int64(cf.miniSectorSize)]...)
+		sector = cf.miniFAT[sector]
+	}
+	if int64(len(out)) < size {
+		return nil, errors.New("stream is shorter than its directory entry")
+	}
+	return out[:size], nil
+}
+
+// stream returns the content of the stream at a path such as "VBA/dir",
+// ignoring case
+func (cf *compoundFile) stream(path string) ([]byte, error) {
+	id, ok := cf.paths[strings.ToLower(path)]
+	if !ok || cf.entries[id].kind != entryStream {
+		return nil, fmt.Errorf("stream %s not found", path)
+	}
+	entry := cf.entries[id]
+	if entry.size == 0 {
+		return []byte{}, nil
+	}
+	if entry.size > uint64(len(cf.data)) {
+		return nil, fmt.Errorf("stream %s is larger than the file", path)
+	}
+	if entry.size < cf.miniCutoff {
+		return cf.miniChain(entry.start, int64(entry.size))
+	}
+	return cf.chain(entry.start, int64(entry.size))
+}
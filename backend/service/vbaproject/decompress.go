@@ -0,0 +1,91 @@
+package vbaproject
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// chunkSize is the decompressed size of a full chunk
+const chunkSize = 4096
+
+// decompress expands a CompressedContainer ([MS-OVBA] 2.4.1): a signature
+// byte followed by chunks of up to 4096 bytes, each either raw or a sequence
+// of literal bytes and copy tokens pointing back into the chunk
+func decompress(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] != 0x01 {
+		return nil, errors.New("compressed container has no signature byte")
+	}
+	out := make([]byte, 0, len(data)*2)
+	pos := 1
+	for pos < len(data) {
+		if pos+2 > len(data) {
+			return nil, errors.New("truncated chunk header")
+		}
+		header := binary.LittleEndian.Uint16(data[pos:])
+		size := int(header&0x0FFF) + 3
+		if header>>12&0x7 != 0x3 {
+			return nil, fmt.Errorf("invalid chunk signature at offset %d", pos)
+		}
+		end := pos + size
+		if end > len(data) {
+			end = len(data)
+		}
+		pos += 2
+
+		if header&0x8000 == 0 {
+			// Raw chunk: 4096 bytes stored as is
+			if pos+chunkSize > len(data) {
+				return nil, errors.New("truncated raw chunk")
+			}
+			out = append(out, data[pos:pos+chunkSize]...)
+			pos += chunkSize
+			continue
+		}
+
+		start := len(out)
+		for pos < end {
+			flags := data[pos]
+			pos++
+			for bit := 0; bit < 8 && pos < end; bit++ {
+				if flags&(1<<bit) == 0 {
+					out = append(out, data[pos])
+					pos++
+					continue
+				}
+				if pos+2 > end {
+					return nil, errors.New("truncated copy token")
+				}
+				token := binary.LittleEndian.Uint16(data[pos:])
+				pos += 2
+				length, offset := copyToken(token, len(out)-start)
+				from := len(out) - offset
+				if from < start {
+					return nil, errors.New("copy token points before its chunk")
+				}
+				// Copies may overlap their own output, so go byte by byte
+				for i := 0; i < length; i++ {
+					out = append(out, out[from+i])
+				}
+			}
+		}
+		pos = end
+	}
+	return out, nil
+}
+
+// copyToken splits a copy token into length and offset. The number of offset
+// bits grows with the position in the chunk, from 4 up to 12.
+func copyToken(token uint16, position int) (int, int) {
+	bits := 4
+	for 1<<bits < position {
+		bits++
+	}
+	if bits > 12 {
+		bits = 12
+	}
+	lengthMask := uint16(0xFFFF) >> bits
+	length := int(token&lengthMask) + 3
+	offset := int(token>>(16-bits)) + 1
+	return length, offset
+}
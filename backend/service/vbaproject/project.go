@@ -0,0 +1,342 @@
+// Package vbaproject reads the VBA project stored in a macro-enabled workbook
+// (xl/vbaProject.bin) without Excel: the OLE compound file, the compressed
+// dir stream ([MS-OVBA]) and the source of every module.
+package vbaproject
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// ModuleType is the kind of a VBA module
+type ModuleType string
+
+const (
+	ModuleStandard ModuleType = "standard" // Module1.bas
+	ModuleClass    ModuleType = "class"    // Class1.cls
+	ModuleDocument ModuleType = "document" // ThisWorkbook, Sheet1
+	ModuleForm     ModuleType = "form"     // UserForm1.frm
+)
+
+// Module is one module of a VBA project
+type Module struct {
+	Name       string     `json:"name"`
+	Type       ModuleType `json:"type"`
+	StreamName string     `json:"streamName"`
+	Code       string     `json:"code"` // Source as shown in the VBA editor, without Attribute lines
+	Attributes []string   `json:"attributes"`
+	Lines      int        `json:"lines"`
+	ReadOnly   bool       `json:"readOnly"`
+	Private    bool       `json:"private"`
+}
+
+// Project is the VBA project of a workbook
+type Project struct {
+	Name       string   `json:"name"`
+	CodePage   int      `json:"codePage"`
+	Modules    []Module `json:"modules"`
+	References []string `json:"references"` // Referenced type libraries and projects, by name
+	Warnings   []string `json:"warnings"`   // Modules that could not be read
+}
+
+// Module returns the module with the given name, ignoring case, or nil
+func (p *Project) Module(name string) *Module {
+	for i := range p.Modules {
+		if strings.EqualFold(p.Modules[i].Name, name) {
+			return &p.Modules[i]
+		}
+	}
+	return nil
+}
+
+// Open reads a vbaProject.bin file
+func Open(filename string) (*Project, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	project, err := Read(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return project, nil
+}
+
+// dir stream record ids ([MS-OVBA] 2.3.4.2)
+const (
+	recordCodePage        = 0x0003
+	recordName            = 0x0004
+	recordVersion         = 0x0009
+	recordReferenceName   = 0x0016
+	recordModuleName      = 0x0019
+	recordModuleUnicode   = 0x0047
+	recordModuleStream    = 0x001A
+	recordModuleOffset    = 0x0031
+	recordModuleProcedure = 0x0021
+	recordModuleOther     = 0x0022
+	recordModuleReadOnly  = 0x0025
+	recordModulePrivate   = 0x0028
+	recordModuleEnd       = 0x002B
+	recordTerminator      = 0x0010
+)
+
+// Read reads the VBA project from the bytes of a vbaProject.bin stream.
+// Modules whose source cannot be read are skipped with a warning.
+func Read(data []byte) (*Project, error) {
+	cf, err := openCompoundFile(data)
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := cf.stream("VBA/dir")
+	if err != nil {
+		return nil, err
+	}
+	dir, err := decompress(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("dir stream: %w", err)
+	}
+
+	project := &Project{CodePage: 1252, Modules: []Module{}, References: []string{}, Warnings: []string{}}
+	type moduleRecord struct {
+		module Module
+		offset uint32
+	}
+	var records []moduleRecord
+	var current *moduleRecord
+	var nameBytes []byte
+
+	for pos := 0; pos+6 <= len(dir); {
+		id := binary.LittleEndian.Uint16(dir[pos:])
+		size := int(binary.LittleEndian.Uint32(dir[pos+2:]))
+		pos += 6
+		if id == recordVersion {
+			// The size field says 4 but a 2-byte minor version follows
+			size = 6
+		}
+		if size < 0 || pos+size > len(dir) {
+			return nil, fmt.Errorf("dir stream: record 0x%04X runs past the end", id)
+		}
+		value := dir[pos : pos+size]
+		pos += size
+
+		switch id {
+		case recordCodePage:
+			if len(value) >= 2 {
+				project.CodePage = int(binary.LittleEndian.Uint16(value))
+			}
+		case recordName:
+			nameBytes = value
+		case recordReferenceName:
+			project.References = append(project.References, string(value))
+		case recordModuleName:
+			records = append(records, moduleRecord{})
+			current = &records[len(records)-1]
+			current.module.Name = decodeText(value, codePageDecoder(project.CodePage))
+		case recordModuleUnicode:
+			if current != nil {
+				current.module.Name = decodeUTF16(value)
+			}
+		case recordModuleStream:
+			if current != nil {
+				current.module.StreamName = decodeText(value, codePageDecoder(project.CodePage))
+			}
+		case recordModuleOffset:
+			if current != nil && len(value) >= 4 {
+				current.offset = binary.LittleEndian.Uint32(value)
+			}
+		case recordModuleProcedure:
+			if current != nil {
+				current.module.Type = ModuleStandard
+			}
+		case recordModuleOther:
+			if current != nil {
+				current.module.Type = ModuleClass
+			}
+		case recordModuleReadOnly:
+			if current != nil {
+				current.module.ReadOnly = true
+			}
+		case recordModulePrivate:
+			if current != nil {
+				current.module.Private = true
+			}
+		case recordModuleEnd:
+			current = nil
+		case recordTerminator:
+			pos = len(dir)
+		}
+	}
+
+	decoder := codePageDecoder(project.CodePage)
+	project.Name = decodeText(nameBytes, decoder)
+	kinds := projectModuleKinds(cf, decoder)
+	for _, record := range records {
+		module := record.module
+		if kind, ok := kinds[strings.ToLower(module.Name)]; ok {
+			module.Type = kind
+		}
+		if module.StreamName == "" {
+			module.StreamName = module.Name
+		}
+		stream, err := cf.stream("VBA/" + module.StreamName)
+		if err == nil && int(record.offset) > len(stream) {
+			err = fmt.Errorf("source offset %d is past the end of the stream", record.offset)
+		}
+		var source []byte
+		if err == nil {
+			source, err = decompress(stream[record.offset:])
+		}
+		if err != nil {
+			project.Warnings = append(project.Warnings, fmt.Sprintf("module %s: %v", module.Name, err))
+			continue
+		}
+		module.Code, module.Attributes = splitAttributes(decodeText(source, decoder))
+		module.Lines = countLines(module.Code)
+		project.Modules = append(project.Modules, module)
+	}
+	return project, nil
+}
+
+// projectModuleKinds reads the PROJECT stream, whose Module=, Class=,
+// Document= and BaseClass= lines tell the kinds the dir stream lumps together
+func projectModuleKinds(cf *compoundFile, decoder *encoding.Decoder) map[string]ModuleType {
+	kinds := map[string]ModuleType{}
+	data, err := cf.stream("PROJECT")
+	if err != nil {
+		return kinds
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := decodeText(scanner.Bytes(), decoder)
+		if strings.HasPrefix(line, "[") {
+			// [Host Extender Info] and [Workspace] follow the module list
+			break
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(value))
+		switch strings.TrimSpace(key) {
+		case "Module":
+			kinds[name] = ModuleStandard
+		case "Class":
+			kinds[name] = ModuleClass
+		case "BaseClass":
+			kinds[name] = ModuleForm
+		case "Document":
+			// Document=Sheet1/&H00000000
+			if i := strings.Index(name, "/"); i >= 0 {
+				name = name[:i]
+			}
+			kinds[name] = ModuleDocument
+		}
+	}
+	return kinds
+}
+
+// splitAttributes separates the Attribute lines, which the VBA editor
+// hides, from the code
+func splitAttributes(source string) (string, []string) {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	lines := strings.Split(source, "\n")
+	attributes := []string{}
+	code := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if strings.HasPrefix(line, "Attribute ") {
+			attributes = append(attributes, line)
+			continue
+		}
+		code = append(code, line)
+	}
+	return strings.TrimRight(strings.Join(code, "\n"), "\n") + "\n", attributes
+}
+
+// countLines counts the lines of code, ignoring the final newline
+func countLines(code string) int {
+	code = strings.TrimRight(code, "\n")
+	if code == "" {
+		return 0
+	}
+	return strings.Count(code, "\n") + 1
+}
+
+// codePageDecoder returns a decoder for the project code page; text in an
+// unknown code page is read as Windows-1252
+func codePageDecoder(codePage int) *encoding.Decoder {
+	enc, ok := CodePageEncoding(codePage)
+	if !ok {
+		enc = charmap.Windows1252
+	}
+	return enc.NewDecoder()
+}
+
+// CodePageEncoding returns the encoding of a Windows code page, as stored
+// in a VBA project and used by the VBA editor for module files. It reports
+// false for a code page it does not know.
+func CodePageEncoding(codePage int) (encoding.Encoding, bool) {
+	switch codePage {
+	case 65001:
+		return unicode.UTF8, true
+	case 932:
+		return japanese.ShiftJIS, true
+	case 936:
+		return simplifiedchinese.GBK, true
+	case 949:
+		return korean.EUCKR, true
+	case 950:
+		return traditionalchinese.Big5, true
+	case 874:
+		return charmap.Windows874, true
+	case 1250:
+		return charmap.Windows1250, true
+	case 1251:
+		return charmap.Windows1251, true
+	case 1252:
+		return charmap.Windows1252, true
+	case 1253:
+		return charmap.Windows1253, true
+	case 1254:
+		return charmap.Windows1254, true
+	case 1255:
+		return charmap.Windows1255, true
+	case 1256:
+		return charmap.Windows1256, true
+	case 1257:
+		return charmap.Windows1257, true
+	case 1258:
+		return charmap.Windows1258, true
+	}
+	return nil, false
+}
+
+// decodeText converts code page bytes to a string, keeping the bytes as
+// they are if they do not decode
+func decodeText(b []byte, decoder *encoding.Decoder) string {
+	text, err := decoder.Bytes(b)
+	if err != nil {
+		return string(b)
+	}
+	return string(text)
+}
+
+// decodeUTF16 converts little-endian UTF-16 bytes to a string
+func decodeUTF16(b []byte) string {
+	text, err := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder().Bytes(b)
+	if err != nil {
+		return ""
+	}
+	return string(text)
+}
@@ -0,0 +1,382 @@
+package xlsx
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"excel-automation-mcp/backend/service/excelrange"
+	"excel-automation-mcp/backend/service/mcp"
+)
+
+// Limits of the range detector
+const (
+	maxHeaderSearch = 5   // Rows at the top of a block that may hold the header
+	headerTypeRows  = 20  // Rows below a candidate header used to compare types
+	headerThreshold = 0.5 // Score a row needs to be taken as the header
+	maxTotalsRows   = 2   // Trailing totals rows removed, e.g. subtotal and grand total
+)
+
+// totalsLabel matches the label of a totals row
+var totalsLabel = regexp.MustCompile(`(?i)^\s*((grand|sub)\s*-?\s*)?totals?\b|^\s*sum\b|合计|總計|总计|小计|小計|合計`)
+
+// totalsFormula matches formulas that aggregate the column above
+var totalsFormula = regexp.MustCompile(`(?i)\b(SUM|SUBTOTAL|AGGREGATE)\s*\(`)
+
+// Candidate is a table found on a sheet, ready to be used as a data range
+type Candidate struct {
+	Range      mcp.DataRange `json:"range"`
+	Confidence float64       `json:"confidence"` // 0 to 1
+	HeaderRow  int           `json:"headerRow"`  // First header row, 1-based; 0 when the table has no header
+	HeaderRows int           `json:"headerRows"` // Rows in the header, more than 1 when columns are grouped
+	Excluded   []string      `json:"excluded"`   // Title and totals rows left out, as addresses
+	Reasons    []string      `json:"reasons"`    // Why the region and header were chosen
+}
+
+// DetectFile reads an .xlsx or .xlsm file and returns the tables found on its
+// worksheets, best first
+func DetectFile(filename string, opts AnalyzeOptions) ([]Candidate, error) {
+	wb, err := Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := wb.DetectTables(opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	for i := range candidates {
+		candidates[i].Range.Description += " in " + filepath.Base(filename)
+	}
+	return candidates, nil
+}
+
+// DetectTables returns the tables found on the selected worksheets, best first
+func (wb *Workbook) DetectTables(opts AnalyzeOptions) ([]Candidate, error) {
+	sheets, err := wb.selectSheets(opts)
+	if err != nil {
+		return nil, err
+	}
+	candidates := []Candidate{}
+	for _, sheet := range sheets {
+		for _, candidate := range sheet.DetectTables(opts.SampleRows) {
+			candidate.Range.NamedRanges = wb.namedRanges(sheet.Name)
+			candidates = append(candidates, candidate)
+		}
+	}
+	rankCandidates(candidates)
+	return candidates, nil
+}
+
+// DetectTables returns the Excel Tables of the sheet, then finds the other
+// contiguous blocks of data, chooses the header row of each, leaves out title
+// and totals rows and returns the resulting tables, best first
+func (s *Sheet) DetectTables(sampleRows int) []Candidate {
+	candidates := []Candidate{}
+	for _, table := range s.Tables {
+		candidates = append(candidates, s.tableCandidate(table, sampleRows))
+	}
+	for _, block := range s.blocks() {
+		if !s.inTable(block) {
+			candidates = append(candidates, s.detectTable(block, sampleRows))
+		}
+	}
+	rankCandidates(candidates)
+	return candidates
+}
+
+// rankCandidates sorts by confidence, then by size
+func rankCandidates(candidates []Candidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Confidence != candidates[j].Confidence {
+			return candidates[i].Confidence > candidates[j].Confidence
+		}
+		return candidates[i].Range.DataRows*len(candidates[i].Range.Headers) > candidates[j].Range.DataRows*len(candidates[j].Range.Headers)
+	})
+}
+
+// detectTable turns one block into a candidate
+func (s *Sheet) detectTable(block excelrange.Area, sampleRows int) Candidate {
+	candidate := Candidate{Excluded: []string{}, Reasons: []string{}}
+	area := block
+
+	headerRow, headerScore, why := s.findHeader(block)
+	headerRows := 0
+	if headerRow > 0 {
+		// Group labels merged above the column names may outscore them
+		for headerRow < block.Row2-1 && headerRow-block.Row1 < maxHeaderRows-1 && s.isGroupLabels(headerRow, headerRow+1, block) {
+			headerRow++
+		}
+		top := headerRow
+		for top > block.Row1 && headerRow-top+1 < maxHeaderRows && s.isGroupRow(top-1, block) {
+			top--
+		}
+		headerRows = headerRow - top + 1
+		candidate.HeaderRow = top
+		candidate.HeaderRows = headerRows
+		if headerRows > 1 {
+			candidate.Reasons = append(candidate.Reasons, fmt.Sprintf("rows %d-%d are the header (%s), with group labels above row %d", top, headerRow, why, headerRow))
+		} else {
+			candidate.Reasons = append(candidate.Reasons, fmt.Sprintf("row %d is the header (%s)", headerRow, why))
+		}
+		if top > block.Row1 {
+			title := excelrange.Area{Row1: block.Row1, Col1: block.Col1, Row2: top - 1, Col2: block.Col2}
+			candidate.Excluded = append(candidate.Excluded, title.Address(false))
+			candidate.Reasons = append(candidate.Reasons, fmt.Sprintf("rows above the header (%s) look like a title", title.Address(false)))
+			area.Row1 = top
+		}
+	} else {
+		candidate.Reasons = append(candidate.Reasons, "no row looks like a header")
+	}
+
+	firstData := area.Row1 + headerRows
+	for n := 0; n < maxTotalsRows && area.Row2 > firstData && s.isTotalsRow(area.Row2, area); n++ {
+		totals := excelrange.Area{Row1: area.Row2, Col1: area.Col1, Row2: area.Row2, Col2: area.Col2}
+		candidate.Excluded = append(candidate.Excluded, totals.Address(false))
+		candidate.Reasons = append(candidate.Reasons, fmt.Sprintf("row %d is a totals row", area.Row2))
+		area.Row2--
+	}
+
+	candidate.Range = s.DataRange(area, headerRows, sampleRows)
+
+	// Confidence: a clear header, well-filled cells, consistent column types
+	// and enough data to be a table rather than a note
+	dataArea := excelrange.Area{Row1: firstData, Col1: area.Col1, Row2: area.Row2, Col2: area.Col2}
+	fill, consistent := 0.0, 0.0
+	if firstData <= area.Row2 {
+		fill = float64(s.filledCells(dataArea)) / float64(dataArea.Cells())
+		for col := area.Col1; col <= area.Col2; col++ {
+			if s.columnConsistent(col, firstData, area.Row2) {
+				consistent++
+			}
+		}
+		consistent /= float64(area.Columns())
+	}
+	size := 1.0
+	if rows := area.Row2 - firstData + 1; rows < 5 {
+		size *= float64(rows) / 5
+	}
+	if area.Columns() < 2 {
+		size *= 0.5
+	}
+	// Fill and type consistency say little about a handful of cells
+	candidate.Confidence = round2(0.35*headerScore + (0.25*fill+0.2*consistent)*size + 0.2*size)
+	candidate.Reasons = append(candidate.Reasons, fmt.Sprintf("%.0f%% of data cells filled, %.0f%% of columns with a consistent type", fill*100, consistent*100))
+	return candidate
+}
+
+// blocks returns the contiguous regions of non-empty cells, with cells
+// touching at a corner counted as neighbors (like CurrentRegion). Regions
+// whose bounds overlap are merged so holes do not split a table.
+func (s *Sheet) blocks() []excelrange.Area {
+	seen := map[cellKey]bool{}
+	var areas []excelrange.Area
+	keys := make([]cellKey, 0, len(s.cells))
+	for key := range s.cells {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Row != keys[j].Row {
+			return keys[i].Row < keys[j].Row
+		}
+		return keys[i].Col < keys[j].Col
+	})
+
+	for _, start := range keys {
+		if seen[start] || !s.occupied(start) {
+			continue
+		}
+		area := excelrange.Area{Row1: start.Row, Col1: start.Col, Row2: start.Row, Col2: start.Col}
+		stack := []cellKey{start}
+		seen[start] = true
+		for len(stack) > 0 {
+			key := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			area = grow(area, key.Row, key.Col)
+			for dr := -1; dr <= 1; dr++ {
+				for dc := -1; dc <= 1; dc++ {
+					next := cellKey{key.Row + dr, key.Col + dc}
+					if !seen[next] && s.occupied(next) {
+						seen[next] = true
+						stack = append(stack, next)
+					}
+				}
+			}
+		}
+		areas = append(areas, area)
+	}
+
+	for merged := true; merged; {
+		merged = false
+		for i := 0; i < len(areas) && !merged; i++ {
+			for j := i + 1; j < len(areas); j++ {
+				if _, ok := areas[i].Intersect(areas[j]); ok {
+					areas[i] = grow(grow(areas[i], areas[j].Row1, areas[j].Col1), areas[j].Row2, areas[j].Col2)
+					areas = append(areas[:j], areas[j+1:]...)
+					merged = true
+					break
+				}
+			}
+		}
+	}
+	return areas
+}
+
+// grow extends an area to hold a cell
+func grow(area excelrange.Area, row, col int) excelrange.Area {
+	if row < area.Row1 {
+		area.Row1 = row
+	}
+	if row > area.Row2 {
+		area.Row2 = row
+	}
+	if col < area.Col1 {
+		area.Col1 = col
+	}
+	if col > area.Col2 {
+		area.Col2 = col
+	}
+	return area
+}
+
+// occupied reports whether a cell holds a value or formula
+func (s *Sheet) occupied(key cellKey) bool {
+	cell := s.cells[key]
+	return cell != nil && (strings.TrimSpace(cell.Text) != "" || cell.Formula != "")
+}
+
+// filledCells counts the occupied cells of an area
+func (s *Sheet) filledCells(area excelrange.Area) int {
+	n := 0
+	for row := area.Row1; row <= area.Row2; row++ {
+		for col := area.Col1; col <= area.Col2; col++ {
+			if s.occupied(cellKey{row, col}) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// findHeader scores the first rows of a block as the header and returns the
+// best one with its score and a short explanation, or 0 when none qualifies.
+// A header row is mostly text, sits above values of other types and is often
+// bold; repeated titles or numbers in the row count against it.
+func (s *Sheet) findHeader(block excelrange.Area) (int, float64, string) {
+	bestRow, bestScore, bestWhy := 0, 0.0, ""
+	for row := block.Row1; row <= block.Row2 && row < block.Row1+maxHeaderSearch; row++ {
+		text, other, bold, changed := 0, 0, 0, 0
+		seen := map[string]bool{}
+		unique := true
+		for col := block.Col1; col <= block.Col2; col++ {
+			cell := s.cells[cellKey{row, col}]
+			if cell == nil || strings.TrimSpace(cell.Text) == "" {
+				continue
+			}
+			if cell.Type != CellString {
+				other++
+				continue
+			}
+			text++
+			if cell.Bold {
+				bold++
+			}
+			key := strings.ToLower(strings.TrimSpace(cell.Text))
+			unique = unique && !seen[key]
+			seen[key] = true
+
+			last := row + headerTypeRows
+			if last > block.Row2 {
+				last = block.Row2
+			}
+			if below := s.columnType(col, row+1, last); below != mcp.TypeText && below != mcp.TypeUnknown {
+				changed++
+			}
+		}
+		if text == 0 || row == block.Row2 && block.Rows() > 1 {
+			continue
+		}
+
+		density := float64(text) / float64(block.Columns())
+		typeChange := float64(changed) / float64(text)
+		boldShare := float64(bold) / float64(text)
+		score := 0.45*density + 0.35*typeChange + 0.2*boldShare
+		if !unique {
+			score *= 0.5
+		}
+		if other > 0 {
+			score *= 0.3
+		}
+		if row == block.Row1 && block.Rows() > 2 && typeChange == 0 && boldShare == 0 && density == 1 && unique {
+			// All-text tables: a full, distinct first row is the usual header
+			score += 0.1
+		}
+		if score > bestScore+0.05 {
+			var parts []string
+			parts = append(parts, fmt.Sprintf("%.0f%% text", density*100))
+			if changed > 0 {
+				parts = append(parts, fmt.Sprintf("%d columns change type below", changed))
+			}
+			if bold > 0 {
+				parts = append(parts, "bold")
+			}
+			bestRow, bestScore, bestWhy = row, score, strings.Join(parts, ", ")
+		}
+	}
+	if bestScore < headerThreshold {
+		return 0, 0, ""
+	}
+	return bestRow, round2(bestScore), bestWhy
+}
+
+// isTotalsRow reports whether a row of the area adds up the rows above: it
+// is labelled Total (or 合计) or its formulas are all SUM or SUBTOTAL
+func (s *Sheet) isTotalsRow(row int, area excelrange.Area) bool {
+	formulas, sums := 0, 0
+	for col := area.Col1; col <= area.Col2; col++ {
+		cell := s.cells[cellKey{row, col}]
+		if cell == nil {
+			continue
+		}
+		if cell.Type == CellString && col < area.Col1+3 && totalsLabel.MatchString(cell.Text) {
+			return true
+		}
+		if cell.Formula != "" {
+			formulas++
+			if totalsFormula.MatchString(cell.Formula) {
+				sums++
+			}
+		}
+	}
+	return formulas > 0 && sums == formulas
+}
+
+// columnConsistent reports whether one type dominates a column
+func (s *Sheet) columnConsistent(col, first, last int) bool {
+	t := s.columnType(col, first, last)
+	if t == mcp.TypeUnknown {
+		return false
+	}
+	if t != mcp.TypeText {
+		return true
+	}
+	// Text wins by default in mixed columns; require it to be the real majority
+	text, total := 0, 0
+	for row := first; row <= last; row++ {
+		cell := s.cells[cellKey{row, col}]
+		if cell == nil || strings.TrimSpace(cell.Text) == "" {
+			continue
+		}
+		total++
+		if cell.Type == CellString {
+			text++
+		}
+	}
+	return float64(text) >= typeMajority*float64(total)
+}
+
+// round2 rounds a score to two decimals for display
+func round2(f float64) float64 {
+	return float64(int(f*100+0.5)) / 100
+}
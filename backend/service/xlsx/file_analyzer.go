@@ -0,0 +1,225 @@
+package xlsx
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"excel-automation-mcp/backend/service/excelrange"
+	"excel-automation-mcp/backend/service/mcp"
+)
+
+// DefaultSampleRows is the number of data rows copied into SampleData
+const DefaultSampleRows = 5
+
+// typeMajority is the share of a column's values that must agree on a type
+// for the column to get that type; mixed columns are Text
+const typeMajority = 0.8
+
+// AnalyzeOptions controls how worksheets become data ranges
+type AnalyzeOptions struct {
+	SampleRows    int      // Rows copied into SampleData; DefaultSampleRows when zero
+	Sheets        []string // Sheets to analyze; every non-empty worksheet when empty
+	IncludeHidden bool     // Also analyze hidden sheets when Sheets is empty
+}
+
+// AnalyzeFile reads an .xlsx or .xlsm file and describes the data of each worksheet
+func AnalyzeFile(filename string, opts AnalyzeOptions) ([]mcp.DataRange, error) {
+	wb, err := Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	ranges, err := wb.Analyze(opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	for i := range ranges {
+		ranges[i].Description += " in " + filepath.Base(filename)
+	}
+	return ranges, nil
+}
+
+// Analyze describes each selected worksheet as data ranges: its Excel Tables,
+// or else the best table the detector finds on it, or else its whole used
+// area. Every range lists the defined names usable from its sheet.
+func (wb *Workbook) Analyze(opts AnalyzeOptions) ([]mcp.DataRange, error) {
+	sheets, err := wb.selectSheets(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ranges := []mcp.DataRange{}
+	for _, sheet := range sheets {
+		var found []mcp.DataRange
+		if len(sheet.Tables) > 0 {
+			for _, table := range sheet.Tables {
+				found = append(found, sheet.tableRange(table, opts.SampleRows))
+			}
+		} else if candidates := sheet.DetectTables(opts.SampleRows); len(candidates) > 0 {
+			found = append(found, candidates[0].Range)
+		} else if area, ok := sheet.UsedArea(); ok {
+			found = append(found, sheet.DataRange(area, 0, opts.SampleRows))
+		}
+		for _, structure := range found {
+			structure.NamedRanges = wb.namedRanges(sheet.Name)
+			ranges = append(ranges, structure)
+		}
+	}
+	return ranges, nil
+}
+
+// selectSheets returns the sheets named in the options, or every worksheet
+// that is visible or allowed to be hidden
+func (wb *Workbook) selectSheets(opts AnalyzeOptions) ([]*Sheet, error) {
+	sheets := []*Sheet{}
+	if len(opts.Sheets) > 0 {
+		for _, name := range opts.Sheets {
+			sheet := wb.Sheet(name)
+			if sheet == nil {
+				return nil, fmt.Errorf("sheet %q does not exist", name)
+			}
+			sheets = append(sheets, sheet)
+		}
+		return sheets, nil
+	}
+	for _, sheet := range wb.Sheets {
+		if !sheet.Hidden || opts.IncludeHidden {
+			sheets = append(sheets, sheet)
+		}
+	}
+	return sheets, nil
+}
+
+// DataRange describes an area of the sheet: its headers, sample rows, data
+// row count and the inferred type of each column. headerRows is the number
+// of header rows at the top of the area, 0 when there are none; a header of
+// several rows is kept as groups and flattened into composite names.
+func (s *Sheet) DataRange(area excelrange.Area, headerRows int, sampleRows int) mcp.DataRange {
+	if sampleRows <= 0 {
+		sampleRows = DefaultSampleRows
+	}
+	if headerRows > area.Rows() {
+		headerRows = area.Rows()
+	}
+	firstData := area.Row1 + headerRows
+
+	headers, groups := s.headerHierarchy(area, headerRows)
+	structure := mcp.DataRange{
+		SheetName:    s.Name,
+		RangeAddress: area.Address(false),
+		HasHeaders:   headerRows > 0,
+		HeaderRows:   headerRows,
+		HeaderGroups: groups,
+		Headers:      headers,
+		DataTypes:    map[string]string{},
+		SampleData:   [][]string{},
+	}
+	if firstData <= area.Row2 {
+		structure.DataRows = area.Row2 - firstData + 1
+	}
+
+	structure.CalculatedColumns = s.calculatedColumns(area, firstData, headers)
+	calculated := map[string]bool{}
+	for _, column := range structure.CalculatedColumns {
+		calculated[column.Header] = true
+	}
+	for i, header := range structure.Headers {
+		t := s.columnType(area.Col1+i, firstData, area.Row2)
+		if calculated[header] && t == mcp.TypeUnknown {
+			// Formulas saved without results still tell what the column is
+			t = mcp.TypeFormula
+		}
+		structure.DataTypes[header] = string(t)
+	}
+	for row := firstData; row <= area.Row2 && len(structure.SampleData) < sampleRows; row++ {
+		values := make([]string, area.Columns())
+		blank := true
+		for i := range values {
+			values[i] = s.Text(row, area.Col1+i)
+			blank = blank && values[i] == ""
+		}
+		if !blank {
+			structure.SampleData = append(structure.SampleData, values)
+		}
+	}
+
+	structure.Description = fmt.Sprintf("Sheet %q: %d data rows in %d columns", s.Name, structure.DataRows, area.Columns())
+	if headerRows > 1 {
+		structure.Description += fmt.Sprintf(" under a %d-row header", headerRows)
+	}
+	if n := len(structure.CalculatedColumns); n > 0 {
+		structure.Description += fmt.Sprintf(", %d calculated", n)
+	}
+	if s.Hidden {
+		structure.Description += " (hidden sheet)"
+	}
+	return structure
+}
+
+// uniqueNames makes repeated names unique by numbering them, as Excel tables
+// do ("Amount", "Amount2"). Blank names become "Column C" after their letter.
+func uniqueNames(names []string, firstCol int) []string {
+	unique := make([]string, len(names))
+	seen := map[string]bool{}
+	for i, name := range names {
+		if name == "" {
+			name = "Column " + excelrange.ColumnLetter(firstCol+i)
+		}
+		candidate := name
+		for n := 2; seen[strings.ToLower(candidate)]; n++ {
+			candidate = name + strconv.Itoa(n)
+		}
+		seen[strings.ToLower(candidate)] = true
+		unique[i] = candidate
+	}
+	return unique
+}
+
+// columnType infers the type of a column from the values in rows first to last
+func (s *Sheet) columnType(col, first, last int) mcp.DataType {
+	counts := map[mcp.DataType]int{}
+	total := 0
+	for row := first; row <= last; row++ {
+		cell := s.Cell(row, col)
+		if cell == nil {
+			continue
+		}
+		var t mcp.DataType
+		switch cell.Type {
+		case CellNumber:
+			t = mcp.TypeNumber
+			if isCurrencyFormat(cell.Format) {
+				t = mcp.TypeCurrency
+			}
+		case CellDate:
+			t = mcp.TypeDate
+		case CellBoolean:
+			t = mcp.TypeBoolean
+		case CellString:
+			if strings.TrimSpace(cell.Text) == "" {
+				continue
+			}
+			t = mcp.TypeText
+		default:
+			// Errors and uncalculated formulas say nothing about the type
+			continue
+		}
+		counts[t]++
+		total++
+	}
+	if total == 0 {
+		return mcp.TypeUnknown
+	}
+
+	// A money column may leave some amounts in a plain number format
+	if counts[mcp.TypeCurrency]*2 >= counts[mcp.TypeCurrency]+counts[mcp.TypeNumber] && float64(counts[mcp.TypeCurrency]+counts[mcp.TypeNumber]) >= typeMajority*float64(total) {
+		return mcp.TypeCurrency
+	}
+	for _, t := range []mcp.DataType{mcp.TypeNumber, mcp.TypeDate, mcp.TypeBoolean, mcp.TypeText} {
+		if float64(counts[t]) >= typeMajority*float64(total) {
+			return t
+		}
+	}
+	return mcp.TypeText
+}
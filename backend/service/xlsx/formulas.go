@@ -0,0 +1,107 @@
+package xlsx
+
+import (
+	"sort"
+	"strings"
+
+	"excel-automation-mcp/backend/service/excelrange"
+	"excel-automation-mcp/backend/service/mcp"
+)
+
+// calculatedColumns finds the columns of an area whose data rows are mostly
+// formulas. Formulas are compared in R1C1 notation, so a formula filled down
+// the column counts as one formula; the most common one represents the column.
+func (s *Sheet) calculatedColumns(area excelrange.Area, firstData int, headers []string) []mcp.CalculatedColumn {
+	var columns []mcp.CalculatedColumn
+	for i, header := range headers {
+		col := area.Col1 + i
+		counts := map[string]int{}
+		firstRow := map[string]int{}
+		values, formulas := 0, 0
+		for row := firstData; row <= area.Row2; row++ {
+			cell := s.Cell(row, col)
+			if cell == nil || !s.occupied(cellKey{row, col}) {
+				continue
+			}
+			values++
+			if cell.Formula == "" {
+				continue
+			}
+			formulas++
+			r1c1 := excelrange.FormulaR1C1(cell.Formula, excelrange.Cell{Row: row, Col: col})
+			if counts[r1c1] == 0 {
+				firstRow[r1c1] = row
+			}
+			counts[r1c1]++
+		}
+		// A formula or two among typed values is a correction, not a column
+		if formulas == 0 || formulas*2 < values || formulas < 2 && values > 1 {
+			continue
+		}
+
+		representative := ""
+		for r1c1, n := range counts {
+			if representative == "" || n > counts[representative] || n == counts[representative] && firstRow[r1c1] < firstRow[representative] {
+				representative = r1c1
+			}
+		}
+		row := firstRow[representative]
+		formula := s.Cell(row, col).Formula
+		dependsOn, external := s.formulaDependencies(formula, area, col, headers)
+		columns = append(columns, mcp.CalculatedColumn{
+			Header:      header,
+			Column:      excelrange.ColumnLetter(col),
+			Formula:     "=" + formula,
+			FormulaR1C1: "=" + representative,
+			DependsOn:   dependsOn,
+			External:    external,
+			Rows:        counts[representative],
+			Consistent:  counts[representative] == values,
+		})
+	}
+	return columns
+}
+
+// formulaDependencies splits the references of a formula into the columns of
+// the area it reads, as letters, and the references outside the area. Table
+// formulas name their columns in structured references such as [@Price].
+func (s *Sheet) formulaDependencies(formula string, area excelrange.Area, own int, headers []string) ([]string, []string) {
+	columns := map[int]bool{}
+	for _, i := range structuredColumns(formula, headers) {
+		if col := area.Col1 + i; col != own {
+			columns[col] = true
+		}
+	}
+	var external []string
+	seen := map[string]bool{}
+	for _, ref := range excelrange.FormulaReferences(formula) {
+		target := ref.Area
+		target.Sheet = ""
+		inside, ok := area.Intersect(target)
+		if ok && (ref.Sheet == "" || strings.EqualFold(ref.Sheet, s.Name)) {
+			for col := inside.Col1; col <= inside.Col2; col++ {
+				if col != own {
+					columns[col] = true
+				}
+			}
+			if area.ContainsArea(target) {
+				continue
+			}
+		}
+		if text := ref.Text; !seen[text] {
+			seen[text] = true
+			external = append(external, text)
+		}
+	}
+
+	order := make([]int, 0, len(columns))
+	for col := range columns {
+		order = append(order, col)
+	}
+	sort.Ints(order)
+	dependsOn := make([]string, len(order))
+	for i, col := range order {
+		dependsOn[i] = excelrange.ColumnLetter(col)
+	}
+	return dependsOn, external
+}
@@ -0,0 +1,198 @@
+package xlsx
+
+import (
+	"strings"
+
+	"excel-automation-mcp/backend/service/excelrange"
+	"excel-automation-mcp/backend/service/mcp"
+)
+
+// maxHeaderRows is the deepest header the detector builds, such as
+// year / quarter / measure
+const maxHeaderRows = 3
+
+// mergeAt returns the merged area holding a cell, if any
+func (s *Sheet) mergeAt(row, col int) (excelrange.Area, bool) {
+	for _, merge := range s.Merges {
+		if merge.Contains(row, col) {
+			return merge, true
+		}
+	}
+	return excelrange.Area{}, false
+}
+
+// shownText returns the text Excel shows for a cell: cells covered by a
+// merge show the text of the merge's top-left cell
+func (s *Sheet) shownText(row, col int) string {
+	if merge, ok := s.mergeAt(row, col); ok {
+		row, col = merge.Row1, merge.Col1
+	}
+	return strings.TrimSpace(s.Text(row, col))
+}
+
+// isGroupRow reports whether a row above the column names holds group
+// labels: only text, with several labels or a label merged across some but
+// not all of the columns. A single label over the whole table is a title.
+func (s *Sheet) isGroupRow(row int, area excelrange.Area) bool {
+	labels, merged := 0, false
+	for col := area.Col1; col <= area.Col2; col++ {
+		cell := s.Cell(row, col)
+		if cell == nil || strings.TrimSpace(cell.Text) == "" {
+			continue
+		}
+		if cell.Type != CellString {
+			return false
+		}
+		labels++
+		if merge, ok := s.mergeAt(row, col); ok && merge.Columns() > 1 && merge.Columns() < area.Columns() {
+			merged = true
+		}
+	}
+	return labels >= 2 || merged
+}
+
+// isGroupLabels reports whether a row chosen as the header is really group
+// labels over the row below it: the row below is all text and names more
+// columns than the row does
+func (s *Sheet) isGroupLabels(row, below int, area excelrange.Area) bool {
+	if !s.isGroupRow(row, area) || !s.isGroupRow(below, area) {
+		return false
+	}
+	labels := func(row int) int {
+		n := 0
+		for col := area.Col1; col <= area.Col2; col++ {
+			if strings.TrimSpace(s.Text(row, col)) != "" {
+				n++
+			}
+		}
+		return n
+	}
+	return labels(below) > labels(row)
+}
+
+// headerHierarchy names the columns of an area from its top headerRows rows.
+// With one row the names are the cell texts. With more, the rows above the
+// last hold group labels: a label spans its merged cells, or runs right over
+// the blank cells after it until the group above changes. Each column is
+// named by its path through the groups, joined with mcp.HeaderSeparator.
+func (s *Sheet) headerHierarchy(area excelrange.Area, headerRows int) ([]string, []mcp.HeaderGroup) {
+	width := area.Columns()
+	if headerRows == 0 {
+		return uniqueNames(make([]string, width), area.Col1), nil
+	}
+	if headerRows == 1 {
+		names := make([]string, width)
+		for i := range names {
+			names[i] = strings.TrimSpace(s.Text(area.Row1, area.Col1+i))
+		}
+		return uniqueNames(names, area.Col1), nil
+	}
+
+	// grid[level][i] is the label over column i on each header row
+	grid := make([][]string, headerRows)
+	for level := range grid {
+		grid[level] = make([]string, width)
+		for i := range grid[level] {
+			grid[level][i] = s.shownText(area.Row1+level, area.Col1+i)
+		}
+	}
+	hasBelow := func(level, i int) bool {
+		for l := level + 1; l < headerRows; l++ {
+			if grid[l][i] != "" {
+				return true
+			}
+		}
+		return false
+	}
+	for level := 0; level < headerRows-1; level++ {
+		for i := 1; i < width; i++ {
+			row, col := area.Row1+level, area.Col1+i
+			if grid[level][i] != "" || grid[level][i-1] == "" || !hasBelow(level, i) || !hasBelow(level, i-1) {
+				continue
+			}
+			if _, merged := s.mergeAt(row, col); merged {
+				continue
+			}
+			// A label merged down the rows names its own column, not a group
+			if left, merged := s.mergeAt(row, col-1); merged && left.Rows() > 1 {
+				continue
+			}
+			if level > 0 && grid[level-1][i] != grid[level-1][i-1] {
+				continue
+			}
+			grid[level][i] = grid[level][i-1]
+		}
+	}
+
+	// Each column's path: group labels, then its own label. A label repeated
+	// down the levels is one vertically merged cell, not a group.
+	paths := make([][]string, width)
+	groupLevels := make([][]int, width)
+	for i := 0; i < width; i++ {
+		own := -1
+		for level := headerRows - 1; level >= 0; level-- {
+			if grid[level][i] != "" {
+				own = level
+				break
+			}
+		}
+		for level := 0; level <= own; level++ {
+			label := grid[level][i]
+			if label == "" || (len(paths[i]) > 0 && paths[i][len(paths[i])-1] == label) {
+				continue
+			}
+			paths[i] = append(paths[i], label)
+			if level < own && label != grid[own][i] {
+				groupLevels[i] = append(groupLevels[i], level)
+			}
+		}
+		if own >= 0 && len(paths[i]) > 0 && paths[i][len(paths[i])-1] != grid[own][i] {
+			paths[i] = append(paths[i], grid[own][i])
+		}
+	}
+
+	names := make([]string, width)
+	for i, path := range paths {
+		names[i] = strings.Join(path, mcp.HeaderSeparator)
+	}
+	names = uniqueNames(names, area.Col1)
+
+	// Groups are runs of columns sharing a label and everything above it
+	var groups []mcp.HeaderGroup
+	for level := 0; level < headerRows-1; level++ {
+		for i := 0; i < width; {
+			if !containsLevel(groupLevels[i], level) {
+				i++
+				continue
+			}
+			j := i
+			for j+1 < width && containsLevel(groupLevels[j+1], level) && samePrefix(grid, level, i, j+1) {
+				j++
+			}
+			groups = append(groups, mcp.HeaderGroup{Label: grid[level][i], Level: level, FirstColumn: i, LastColumn: j})
+			i = j + 1
+		}
+	}
+	return names, groups
+}
+
+// containsLevel reports whether a column has a group label on a level
+func containsLevel(levels []int, level int) bool {
+	for _, l := range levels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// samePrefix reports whether two columns carry the same labels from the top
+// header row down to level
+func samePrefix(grid [][]string, level, a, b int) bool {
+	for l := 0; l <= level; l++ {
+		if grid[l][a] != grid[l][b] {
+			return false
+		}
+	}
+	return true
+}
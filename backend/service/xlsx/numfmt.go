@@ -0,0 +1,204 @@
+package xlsx
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// builtinFormats are the number formats Excel refers to by id without
+// writing them to styles.xml. Ids 27-36 and 50-58 are the East Asian date and
+// time formats; their exact codes depend on the locale.
+var builtinFormats = map[int]string{
+	0: "General", 1: "0", 2: "0.00", 3: "#,##0", 4: "#,##0.00",
+	5: `"$"#,##0_);("$"#,##0)`, 6: `"$"#,##0_);[Red]("$"#,##0)`,
+	7: `"$"#,##0.00_);("$"#,##0.00)`, 8: `"$"#,##0.00_);[Red]("$"#,##0.00)`,
+	9: "0%", 10: "0.00%", 11: "0.00E+00", 12: "# ?/?", 13: "# ??/??",
+	14: "mm-dd-yy", 15: "d-mmm-yy", 16: "d-mmm", 17: "mmm-yy",
+	18: "h:mm AM/PM", 19: "h:mm:ss AM/PM", 20: "h:mm", 21: "h:mm:ss", 22: "m/d/yy h:mm",
+	27: "yyyy-mm-dd", 28: "yyyy-mm-dd", 29: "yyyy-mm-dd", 30: "m-d-yy", 31: "yyyy-mm-dd",
+	32: "h:mm:ss", 33: "h:mm:ss", 34: "h:mm:ss", 35: "h:mm:ss", 36: "yyyy-mm-dd",
+	37: "#,##0 ;(#,##0)", 38: "#,##0 ;[Red](#,##0)", 39: "#,##0.00;(#,##0.00)", 40: "#,##0.00;[Red](#,##0.00)",
+	41: `_(* #,##0_);_(* \(#,##0\);_(* "-"_);_(@_)`,
+	42: `_("$"* #,##0_);_("$"* \(#,##0\);_("$"* "-"_);_(@_)`,
+	43: `_(* #,##0.00_);_(* \(#,##0.00\);_(* "-"??_);_(@_)`,
+	44: `_("$"* #,##0.00_);_("$"* \(#,##0.00\);_("$"* "-"??_);_(@_)`,
+	45: "mm:ss", 46: "[h]:mm:ss", 47: "mmss.0", 48: "##0.0E+0", 49: "@",
+	50: "yyyy-mm-dd", 51: "yyyy-mm-dd", 52: "yyyy-mm-dd", 53: "yyyy-mm-dd", 54: "yyyy-mm-dd",
+	55: "yyyy-mm-dd", 56: "yyyy-mm-dd", 57: "yyyy-mm-dd", 58: "yyyy-mm-dd",
+}
+
+// formatTokens returns the first section of a format code with quoted text,
+// escaped characters and bracketed colors and locales removed. Elapsed time
+// brackets such as [h] are kept as their letter. Letters are lowercased.
+func formatTokens(code string) string {
+	if strings.EqualFold(code, "General") {
+		return ""
+	}
+	var b strings.Builder
+	for i := 0; i < len(code); i++ {
+		switch c := code[i]; c {
+		case ';':
+			return b.String()
+		case '"':
+			if end := strings.IndexByte(code[i+1:], '"'); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(code)
+			}
+		case '\\', '_', '*':
+			i++ // The next character is literal or padding
+		case '[':
+			end := strings.IndexByte(code[i:], ']')
+			if end < 0 {
+				return b.String()
+			}
+			inner := strings.ToLower(code[i+1 : i+end])
+			if inner != "" && strings.Trim(inner, "hms") == "" {
+				b.WriteString(inner)
+			}
+			i += end
+		case 'E', 'e':
+			if i+1 < len(code) && (code[i+1] == '+' || code[i+1] == '-') {
+				i++ // Scientific notation, not an era year
+				break
+			}
+			b.WriteByte('e')
+		default:
+			b.WriteByte(c | 0x20)
+		}
+	}
+	return b.String()
+}
+
+// formatHasDate reports whether a number format shows a calendar date
+func formatHasDate(code string) bool {
+	tokens := formatTokens(code)
+	if strings.ContainsAny(tokens, "yde") {
+		return true
+	}
+	// m alone is a month; next to h or s it is minutes
+	return strings.Contains(tokens, "m") && !strings.ContainsAny(tokens, "hs")
+}
+
+// formatHasTime reports whether a number format shows a time of day
+func formatHasTime(code string) bool {
+	tokens := formatTokens(code)
+	return strings.ContainsAny(tokens, "hs") || strings.Contains(tokens, "am/pm") || strings.Contains(tokens, "a/p")
+}
+
+// isDateFormat reports whether a number format displays numbers as dates or times
+func isDateFormat(code string) bool {
+	return formatHasDate(code) || formatHasTime(code)
+}
+
+// isCurrencyFormat reports whether a number format shows a currency symbol
+func isCurrencyFormat(code string) bool {
+	section := code
+	if i := strings.IndexByte(section, ';'); i >= 0 {
+		section = section[:i]
+	}
+	for {
+		// [$€-407] names a currency; [$-409] only a locale
+		start := strings.Index(section, "[$")
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(section[start:], ']')
+		if end < 0 {
+			break
+		}
+		if symbol := section[start+2 : start+end]; symbol != "" && symbol[0] != '-' {
+			return true
+		}
+		section = section[:start] + section[start+end+1:]
+	}
+	return strings.ContainsAny(section, "$€£¥₩₹")
+}
+
+// isPercentFormat reports whether a number format shows numbers as percentages
+func isPercentFormat(code string) bool {
+	return strings.Contains(formatTokens(code), "%")
+}
+
+// numberCell classifies a numeric value by its format and returns its text
+func numberCell(n float64, format string, date1904 bool) (CellType, string) {
+	if isDateFormat(format) && n >= 0 {
+		hasTime, hasDate := formatHasTime(format), formatHasDate(format)
+		return CellDate, formatDateTime(serialToTime(n, date1904), hasTime, hasDate)
+	}
+	if isPercentFormat(format) {
+		return CellNumber, formatNumber(n*100) + "%"
+	}
+	return CellNumber, formatNumber(n)
+}
+
+// formatNumber writes a number without exponent, rounded to the 15
+// significant digits Excel keeps
+func formatNumber(n float64) string {
+	rounded, err := strconv.ParseFloat(strconv.FormatFloat(n, 'g', 15, 64), 64)
+	if err != nil {
+		rounded = n
+	}
+	return strconv.FormatFloat(rounded, 'f', -1, 64)
+}
+
+// Epochs of the two date systems. The 1900 system counts the nonexistent
+// 1900-02-29 as day 60, so serials from 61 on are one day ahead.
+var (
+	epoch1900 = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	epoch1904 = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// serialToTime converts a date serial number to a time
+func serialToTime(serial float64, date1904 bool) time.Time {
+	epoch := epoch1904
+	if !date1904 {
+		epoch = epoch1900
+		if serial < 61 {
+			serial++
+		}
+	}
+	days := math.Floor(serial)
+	seconds := math.Round((serial - days) * 86400)
+	return epoch.AddDate(0, 0, int(days)).Add(time.Duration(seconds) * time.Second)
+}
+
+// timeToSerial converts a time to a date serial number
+func timeToSerial(t time.Time, date1904 bool) float64 {
+	epoch := epoch1904
+	if !date1904 {
+		epoch = epoch1900
+	}
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	serial := math.Round(day.Sub(epoch).Hours() / 24)
+	if !date1904 && serial < 61 {
+		serial--
+	}
+	clock := t.Hour()*3600 + t.Minute()*60 + t.Second()
+	return serial + float64(clock)/86400
+}
+
+// formatDateTime writes a date as ISO 8601, with the parts the format shows
+func formatDateTime(t time.Time, hasTime, hasDate bool) string {
+	switch {
+	case hasDate && hasTime:
+		return t.Format("2006-01-02 15:04:05")
+	case hasTime:
+		return t.Format("15:04:05")
+	default:
+		return t.Format("2006-01-02")
+	}
+}
+
+// parseISODate parses the value of a cell of type "d"
+func parseISODate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999Z07:00", "2006-01-02T15:04:05.999999999", "2006-01-02T15:04:05", "2006-01-02", "15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,274 @@
+package xlsx
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"excel-automation-mcp/backend/service/excelrange"
+)
+
+// CellType is the kind of value a cell holds
+type CellType string
+
+const (
+	CellEmpty   CellType = "empty"
+	CellNumber  CellType = "number"
+	CellString  CellType = "string"
+	CellBoolean CellType = "boolean"
+	CellDate    CellType = "date"
+	CellError   CellType = "error"
+)
+
+// Cell is one cell of a worksheet
+type Cell struct {
+	Type    CellType
+	Text    string  // Value as shown to the model: plain numbers, ISO 8601 dates
+	Number  float64 // Numeric value, including the serial number of dates
+	Formula string  // Formula without the leading "=", if any
+	Format  string  // Number format code
+	Bold    bool
+}
+
+// cellKey addresses a cell by 1-based row and column
+type cellKey struct {
+	Row, Col int
+}
+
+// Sheet is a worksheet of the workbook
+type Sheet struct {
+	Name      string
+	Hidden    bool
+	Dimension string            // Used range declared by the file, such as "A1:D10"
+	Merges    []excelrange.Area // Merged cell areas
+	Tables    []Table           // Excel Tables (ListObjects) on the sheet
+
+	cells map[cellKey]*Cell
+	used  excelrange.Area
+}
+
+// Cell returns the cell at a 1-based row and column, or nil when it is empty
+func (s *Sheet) Cell(row, col int) *Cell {
+	return s.cells[cellKey{row, col}]
+}
+
+// Text returns the text of a cell, or "" when it is empty
+func (s *Sheet) Text(row, col int) string {
+	if c := s.Cell(row, col); c != nil {
+		return c.Text
+	}
+	return ""
+}
+
+// UsedArea returns the smallest area holding every non-empty cell. It reports
+// false for an empty sheet.
+func (s *Sheet) UsedArea() (excelrange.Area, bool) {
+	if len(s.cells) == 0 {
+		return excelrange.Area{}, false
+	}
+	return s.used, true
+}
+
+// xmlCell is a <c> element of the sheet data
+type xmlCell struct {
+	Ref     string      `xml:"r,attr"`
+	Type    string      `xml:"t,attr"`
+	Style   int         `xml:"s,attr"`
+	Value   *string     `xml:"v"`
+	Formula *xmlFormula `xml:"f"`
+	Inline  richText    `xml:"is"`
+}
+
+// xmlFormula is the <f> element of a cell. A shared formula is written once
+// in the first cell of its range; the other cells only carry its index.
+type xmlFormula struct {
+	Text   string `xml:",chardata"`
+	Type   string `xml:"t,attr"`
+	Shared string `xml:"si,attr"`
+}
+
+// sharedFormula is the first cell of a shared formula and its text
+type sharedFormula struct {
+	formula string
+	cell    excelrange.Cell
+}
+
+// readSheet streams the cells, dimension and merged areas of a worksheet part
+func (wb *Workbook) readSheet(sheet *Sheet, part string) error {
+	rc, err := wb.open(part)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	decoder := xml.NewDecoder(rc)
+	row, col := 0, 0
+	truncated := false
+	shared := map[string]sharedFormula{}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", part, err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "dimension":
+			sheet.Dimension = attr(start, "ref")
+		case "mergeCell":
+			if area, err := excelrange.ParseArea(attr(start, "ref")); err == nil {
+				sheet.Merges = append(sheet.Merges, area)
+			}
+		case "row":
+			if n, err := strconv.Atoi(attr(start, "r")); err == nil {
+				row = n
+			} else {
+				row++
+			}
+			col = 0
+		case "c":
+			var x xmlCell
+			if err := decoder.DecodeElement(&x, &start); err != nil {
+				return fmt.Errorf("%s: %w", part, err)
+			}
+			col++
+			if x.Ref != "" {
+				area, err := excelrange.ParseArea(x.Ref)
+				if err != nil {
+					return fmt.Errorf("%s: invalid cell reference %q", part, x.Ref)
+				}
+				row, col = area.Row1, area.Col1
+			}
+			if row < 1 {
+				row = 1
+			}
+			cell := wb.cell(x)
+			if cell == nil {
+				continue
+			}
+			if f := x.Formula; f != nil && f.Type == "shared" {
+				here := excelrange.Cell{Row: row, Col: col}
+				if master, ok := shared[f.Shared]; ok && cell.Formula == "" {
+					cell.Formula = excelrange.TranslateFormula(master.formula, master.cell, here)
+				} else if cell.Formula != "" {
+					shared[f.Shared] = sharedFormula{formula: cell.Formula, cell: here}
+				}
+			}
+			if wb.cells >= MaxCells {
+				truncated = true
+				continue
+			}
+			wb.cells++
+			sheet.add(row, col, cell)
+		}
+	}
+	if truncated {
+		wb.Warnings = append(wb.Warnings, fmt.Sprintf("sheet %q: only the first %d cells of the workbook were read", sheet.Name, MaxCells))
+	}
+	return nil
+}
+
+// add stores a cell and grows the used area
+func (s *Sheet) add(row, col int, cell *Cell) {
+	if len(s.cells) == 0 {
+		s.used = excelrange.Area{Row1: row, Col1: col, Row2: row, Col2: col}
+	} else {
+		if row < s.used.Row1 {
+			s.used.Row1 = row
+		}
+		if row > s.used.Row2 {
+			s.used.Row2 = row
+		}
+		if col < s.used.Col1 {
+			s.used.Col1 = col
+		}
+		if col > s.used.Col2 {
+			s.used.Col2 = col
+		}
+	}
+	s.cells[cellKey{row, col}] = cell
+}
+
+// cell converts a <c> element, returning nil for a cell with only formatting
+func (wb *Workbook) cell(x xmlCell) *Cell {
+	cell := &Cell{}
+	if x.Style >= 0 && x.Style < len(wb.styles) {
+		cell.Format = wb.styles[x.Style].Format
+		cell.Bold = wb.styles[x.Style].Bold
+	}
+	if x.Formula != nil {
+		cell.Formula = strings.TrimPrefix(x.Formula.Text, "=")
+	}
+	value := ""
+	if x.Value != nil {
+		value = *x.Value
+	}
+
+	switch x.Type {
+	case "s":
+		index, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || index < 0 || index >= len(wb.strings) {
+			return nil
+		}
+		cell.Type, cell.Text = CellString, wb.strings[index]
+	case "inlineStr":
+		cell.Type, cell.Text = CellString, x.Inline.String()
+	case "str":
+		cell.Type, cell.Text = CellString, value
+	case "b":
+		cell.Type = CellBoolean
+		cell.Text = "FALSE"
+		if strings.TrimSpace(value) == "1" {
+			cell.Type, cell.Text, cell.Number = CellBoolean, "TRUE", 1
+		}
+	case "e":
+		cell.Type, cell.Text = CellError, value
+	case "d":
+		// ISO 8601 date written by some producers instead of a serial number
+		t, ok := parseISODate(value)
+		if !ok {
+			cell.Type, cell.Text = CellString, value
+			break
+		}
+		cell.Type, cell.Number = CellDate, timeToSerial(t, wb.Date1904)
+		cell.Text = formatDateTime(t, formatHasTime(cell.Format), formatHasDate(cell.Format) || !formatHasTime(cell.Format))
+	default:
+		if x.Value == nil || strings.TrimSpace(value) == "" {
+			break
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			cell.Type, cell.Text = CellString, value
+			break
+		}
+		cell.Number = n
+		cell.Type, cell.Text = numberCell(n, cell.Format, wb.Date1904)
+	}
+
+	if cell.Type == "" {
+		// Cells of a shared formula may have neither text nor a value yet
+		if cell.Formula == "" && x.Formula == nil {
+			return nil
+		}
+		cell.Type = CellEmpty
+	}
+	return cell
+}
+
+// attr returns the value of an attribute of an element, or ""
+func attr(start xml.StartElement, name string) string {
+	for _, a := range start.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
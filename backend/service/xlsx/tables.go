@@ -0,0 +1,199 @@
+package xlsx
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"excel-automation-mcp/backend/service/excelrange"
+	"excel-automation-mcp/backend/service/mcp"
+)
+
+// Table is an Excel Table (ListObject) of a worksheet
+type Table struct {
+	Name       string          // Display name used in code and formulas, e.g. "tblSales"
+	Area       excelrange.Area // Whole table: header, data and totals rows
+	Columns    []TableColumn
+	HeaderRows int // 1 when the header row is shown, else 0
+	TotalsRows int // 1 when the totals row is shown, else 0
+}
+
+// TableColumn is a column (ListColumn) of a table
+type TableColumn struct {
+	Name           string
+	Formula        string // Calculated column formula, if any, without "="
+	TotalsFunction string // Function of the totals row, such as "sum", if any
+}
+
+// DefinedName is a name defined in the workbook
+type DefinedName struct {
+	Name     string
+	RefersTo string // Formula without "=", e.g. "Rates!$B$1" or "0.08"
+	Sheet    string // Sheet of a sheet-level name, "" for a workbook-level name
+	Hidden   bool
+}
+
+// readTables reads the table parts related to a worksheet part
+func (wb *Workbook) readTables(sheet *Sheet, part string) error {
+	rels, err := wb.relationships(part)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, rel := range rels {
+		if !strings.HasSuffix(rel.Type, "/table") {
+			continue
+		}
+		var doc struct {
+			Name        string `xml:"name,attr"`
+			DisplayName string `xml:"displayName,attr"`
+			Ref         string `xml:"ref,attr"`
+			HeaderRows  *int   `xml:"headerRowCount,attr"`
+			TotalsRows  int    `xml:"totalsRowCount,attr"`
+			Columns     []struct {
+				Name           string `xml:"name,attr"`
+				TotalsFunction string `xml:"totalsRowFunction,attr"`
+				Formula        string `xml:"calculatedColumnFormula"`
+			} `xml:"tableColumns>tableColumn"`
+		}
+		if err := wb.decode(rel.Target, &doc); err != nil {
+			return err
+		}
+		area, err := excelrange.ParseArea(doc.Ref)
+		if err != nil {
+			wb.Warnings = append(wb.Warnings, "table "+doc.DisplayName+": invalid reference "+doc.Ref)
+			continue
+		}
+		table := Table{Name: doc.DisplayName, Area: area, HeaderRows: 1, TotalsRows: doc.TotalsRows}
+		if table.Name == "" {
+			table.Name = doc.Name
+		}
+		if doc.HeaderRows != nil {
+			table.HeaderRows = *doc.HeaderRows
+		}
+		for _, column := range doc.Columns {
+			table.Columns = append(table.Columns, TableColumn{
+				Name:           column.Name,
+				Formula:        strings.TrimPrefix(column.Formula, "="),
+				TotalsFunction: column.TotalsFunction,
+			})
+		}
+		sheet.Tables = append(sheet.Tables, table)
+	}
+	sort.Slice(sheet.Tables, func(i, j int) bool {
+		a, b := sheet.Tables[i].Area, sheet.Tables[j].Area
+		if a.Row1 != b.Row1 {
+			return a.Row1 < b.Row1
+		}
+		return a.Col1 < b.Col1
+	})
+	return nil
+}
+
+// tableCandidate describes a table as a detected range. The table definition
+// is certain, so it gets full confidence.
+func (s *Sheet) tableCandidate(table Table, sampleRows int) Candidate {
+	candidate := Candidate{
+		Range:      s.tableRange(table, sampleRows),
+		Confidence: 1,
+		HeaderRows: table.HeaderRows,
+		Excluded:   []string{},
+		Reasons:    []string{fmt.Sprintf("Excel Table %s defines the range %s", table.Name, table.Area.Address(false))},
+	}
+	if table.HeaderRows > 0 {
+		candidate.HeaderRow = table.Area.Row1
+	}
+	if table.TotalsRows > 0 {
+		totals := excelrange.Area{Row1: table.Area.Row2 - table.TotalsRows + 1, Col1: table.Area.Col1, Row2: table.Area.Row2, Col2: table.Area.Col2}
+		candidate.Excluded = append(candidate.Excluded, totals.Address(false))
+		candidate.Reasons = append(candidate.Reasons, fmt.Sprintf("row %d is the totals row of the table", totals.Row1))
+	}
+	return candidate
+}
+
+// tableRange describes a table as a data range. The column names of the
+// table win over the header cells, which a table may hide.
+func (s *Sheet) tableRange(table Table, sampleRows int) mcp.DataRange {
+	area := table.Area
+	area.Row2 -= table.TotalsRows
+	structure := s.DataRange(area, table.HeaderRows, sampleRows)
+
+	if len(table.Columns) == len(structure.Headers) {
+		types := map[string]string{}
+		for i, column := range table.Columns {
+			types[column.Name] = structure.DataTypes[structure.Headers[i]]
+			for j := range structure.CalculatedColumns {
+				if structure.CalculatedColumns[j].Header == structure.Headers[i] {
+					structure.CalculatedColumns[j].Header = column.Name
+				}
+			}
+			structure.Headers[i] = column.Name
+		}
+		structure.DataTypes = types
+	}
+
+	columns := make([]string, len(table.Columns))
+	for i, column := range table.Columns {
+		columns[i] = column.Name
+	}
+	structure.Table = &mcp.ExcelTable{
+		Name:         table.Name,
+		RangeAddress: table.Area.Address(false),
+		Columns:      columns,
+		HasHeaders:   table.HeaderRows > 0,
+		HasTotals:    table.TotalsRows > 0,
+	}
+	structure.Description += " (Excel Table " + table.Name + ")"
+	return structure
+}
+
+// inTable reports whether an area overlaps one of the sheet's tables
+func (s *Sheet) inTable(area excelrange.Area) bool {
+	for _, table := range s.Tables {
+		if _, ok := table.Area.Intersect(area); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// namedRanges returns the visible defined names code on a sheet can use:
+// workbook-level names and the sheet's own names
+func (wb *Workbook) namedRanges(sheet string) []mcp.NamedRange {
+	names := []mcp.NamedRange{}
+	for _, name := range wb.Names {
+		if name.Hidden || strings.HasPrefix(strings.ToLower(name.Name), "_xlnm.") {
+			continue
+		}
+		if name.Sheet != "" && !strings.EqualFold(name.Sheet, sheet) {
+			continue
+		}
+		names = append(names, mcp.NamedRange{Name: name.Name, RefersTo: "=" + name.RefersTo, Scope: name.Sheet})
+	}
+	return names
+}
+
+// structuredPattern matches the column part of a structured reference, as in
+// [@Price], [@[Unit Price]] or tblSales[[#This Row],[Qty]]
+var structuredPattern = regexp.MustCompile(`\[@?\[?([^\[\]]+?)\]?\]`)
+
+// structuredColumns returns the indexes of the headers named by the
+// structured references of a formula
+func structuredColumns(formula string, headers []string) []int {
+	var columns []int
+	for _, m := range structuredPattern.FindAllStringSubmatch(formula, -1) {
+		name := strings.TrimPrefix(strings.TrimSpace(m[1]), "@")
+		for i, header := range headers {
+			if strings.EqualFold(header, name) {
+				columns = append(columns, i)
+				break
+			}
+		}
+	}
+	return columns
+}
@@ -0,0 +1,414 @@
+package xlsx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"excel-automation-mcp/backend/service/mcp"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// ClipboardSheet is the sheet name given to pasted data
+const ClipboardSheet = "Clipboard"
+
+// detectSampleRecords is the number of records used to detect the delimiter and quote
+const detectSampleRecords = 50
+
+// TextOptions controls how delimited text is read. Zero values are detected.
+type TextOptions struct {
+	SheetName  string // Synthetic sheet name; ClipboardSheet when empty
+	Delimiter  rune   // Field separator: ',', '\t', ';' or '|'
+	Quote      rune   // Quote character: '"' or '\''
+	Encoding   string // "utf-8", "utf-16le", "utf-16be", "gbk", "gb18030" or "windows-1252"
+	SampleRows int    // Rows copied into SampleData; DefaultSampleRows when zero
+}
+
+// TextAnalysis is the data range read from delimited text, with what was detected
+type TextAnalysis struct {
+	Range     mcp.DataRange `json:"range"`
+	Encoding  string        `json:"encoding"`
+	Delimiter string        `json:"delimiter"` // "tab" for tab-separated text
+	Quote     string        `json:"quote"`
+	Rows      int           `json:"rows"`    // Records read, including the header
+	Columns   int           `json:"columns"` // Fields of the widest record
+	Warnings  []string      `json:"warnings"`
+}
+
+// AnalyzeTextFile reads a CSV, TSV or text export and describes its data. The
+// sheet is named after the file, as when Excel opens it.
+func AnalyzeTextFile(filename string, opts TextOptions) (*TextAnalysis, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if opts.SheetName == "" {
+		opts.SheetName = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	}
+	analysis, err := AnalyzeText(data, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	analysis.Range.Description += " in " + filepath.Base(filename)
+	return analysis, nil
+}
+
+// AnalyzeText reads delimited text, such as cells copied from Excel, and
+// describes its data as a range on a synthetic sheet
+func AnalyzeText(data []byte, opts TextOptions) (*TextAnalysis, error) {
+	wb, analysis, err := ReadText(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	ranges, err := wb.Analyze(AnalyzeOptions{SampleRows: opts.SampleRows})
+	if err != nil {
+		return nil, err
+	}
+	if len(ranges) == 0 {
+		return nil, errors.New("the text holds no data")
+	}
+	analysis.Range = ranges[0]
+	return analysis, nil
+}
+
+// ReadText decodes delimited text into a workbook with one sheet. Values are
+// typed as Excel would type them when opening the file: numbers, currency,
+// percentages, dates and booleans.
+func ReadText(data []byte, opts TextOptions) (*Workbook, *TextAnalysis, error) {
+	text, encoding, err := decodeText(data, opts.Encoding)
+	if err != nil {
+		return nil, nil, err
+	}
+	analysis := &TextAnalysis{Encoding: encoding, Warnings: []string{}}
+	if strings.ContainsRune(text, utf8.RuneError) {
+		analysis.Warnings = append(analysis.Warnings, fmt.Sprintf("some bytes are not valid %s and were replaced", encoding))
+	}
+
+	delimiter, quote := opts.Delimiter, opts.Quote
+	if delimiter == 0 {
+		delimiter = detectDelimiter(text)
+	}
+	if quote == 0 {
+		quote = detectQuote(text, delimiter)
+	}
+	analysis.Delimiter, analysis.Quote = string(delimiter), string(quote)
+	if delimiter == '\t' {
+		analysis.Delimiter = "tab"
+	}
+
+	records := parseDelimited(text, delimiter, quote, 0)
+	if len(records) == 0 {
+		return nil, nil, errors.New("the text holds no data")
+	}
+
+	name := opts.SheetName
+	if name == "" {
+		name = ClipboardSheet
+	}
+	wb := &Workbook{}
+	sheet := &Sheet{Name: name, cells: map[cellKey]*Cell{}}
+	wb.Sheets = []*Sheet{sheet}
+
+	short := 0
+	for r, record := range records {
+		if len(record) > analysis.Columns {
+			analysis.Columns = len(record)
+		}
+		if r > 0 && len(record) < len(records[0]) {
+			short++
+		}
+		for c, value := range record {
+			cell := textCell(value)
+			if cell == nil {
+				continue
+			}
+			if wb.cells >= MaxCells {
+				analysis.Warnings = append(analysis.Warnings, fmt.Sprintf("only the first %d cells were read", MaxCells))
+				analysis.Rows = r
+				return wb, analysis, nil
+			}
+			wb.cells++
+			sheet.add(r+1, c+1, cell)
+		}
+	}
+	analysis.Rows = len(records)
+	if short > 0 {
+		analysis.Warnings = append(analysis.Warnings, fmt.Sprintf("%d rows have fewer fields than the first row", short))
+	}
+	return wb, analysis, nil
+}
+
+// decodeText converts the bytes to UTF-8 and names the encoding used. Byte
+// order marks select UTF-8 or UTF-16; other text that is not valid UTF-8 is
+// read as GB18030, a superset of GBK, or as Windows-1252 when that fails.
+func decodeText(data []byte, encoding string) (string, string, error) {
+	switch strings.ToLower(strings.ReplaceAll(encoding, "_", "-")) {
+	case "":
+		// Detect below
+	case "utf-8", "utf8":
+		return string(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))), "UTF-8", nil
+	case "utf-16le", "utf-16", "unicode":
+		return decodeUTF16(bytes.TrimPrefix(data, []byte("\xff\xfe")), false), "UTF-16LE", nil
+	case "utf-16be":
+		return decodeUTF16(bytes.TrimPrefix(data, []byte("\xfe\xff")), true), "UTF-16BE", nil
+	case "gbk", "gb2312", "gb18030", "cp936":
+		text, err := simplifiedchinese.GB18030.NewDecoder().Bytes(data)
+		return string(text), "GB18030", err
+	case "windows-1252", "cp1252", "latin1", "iso-8859-1":
+		text, err := charmap.Windows1252.NewDecoder().Bytes(data)
+		return string(text), "Windows-1252", err
+	default:
+		return "", "", fmt.Errorf("unsupported encoding %q", encoding)
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte("\xef\xbb\xbf")):
+		return string(data[3:]), "UTF-8 (BOM)", nil
+	case bytes.HasPrefix(data, []byte("\xff\xfe")):
+		return decodeUTF16(data[2:], false), "UTF-16LE", nil
+	case bytes.HasPrefix(data, []byte("\xfe\xff")):
+		return decodeUTF16(data[2:], true), "UTF-16BE", nil
+	case utf8.Valid(data):
+		return string(data), "UTF-8", nil
+	}
+	if text, err := simplifiedchinese.GB18030.NewDecoder().Bytes(data); err == nil && !bytes.ContainsRune(text, utf8.RuneError) {
+		return string(text), "GB18030", nil
+	}
+	text, err := charmap.Windows1252.NewDecoder().Bytes(data)
+	return string(text), "Windows-1252", err
+}
+
+// decodeUTF16 converts UTF-16 text without its byte order mark
+func decodeUTF16(data []byte, bigEndian bool) string {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		} else {
+			units[i] = uint16(data[2*i+1])<<8 | uint16(data[2*i])
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// detectDelimiter chooses the separator that splits the first records into
+// the same number of fields most consistently. Ties go to tab, as in text
+// copied from Excel, then comma, semicolon and bar.
+func detectDelimiter(text string) rune {
+	best, bestScore := '\t', 0.0
+	for _, delimiter := range []rune{'\t', ',', ';', '|'} {
+		records := parseDelimited(text, delimiter, '"', detectSampleRecords)
+		counts := map[int]int{}
+		for _, record := range records {
+			counts[len(record)]++
+		}
+		mode, modeCount := 0, 0
+		for fields, n := range counts {
+			if n > modeCount || n == modeCount && fields > mode {
+				mode, modeCount = fields, n
+			}
+		}
+		if mode < 2 {
+			continue
+		}
+		score := float64(modeCount) / float64(len(records))
+		if score > bestScore {
+			best, bestScore = delimiter, score
+		}
+	}
+	return best
+}
+
+// detectQuote returns the quote character: the double quote unless fields
+// are consistently wrapped in single quotes instead
+func detectQuote(text string, delimiter rune) rune {
+	double, single, fields := 0, 0, 0
+	for _, record := range parseDelimited(text, delimiter, 0, detectSampleRecords) {
+		for _, field := range record {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			fields++
+			switch {
+			case strings.HasPrefix(field, `"`):
+				double++
+			case len(field) >= 2 && strings.HasPrefix(field, "'") && strings.HasSuffix(field, "'"):
+				single++
+			}
+		}
+	}
+	if double == 0 && single > 0 && single*3 >= fields {
+		return '\''
+	}
+	return '"'
+}
+
+// parseDelimited splits text into records of fields. A quoted field may hold
+// delimiters, line breaks and doubled quotes. A quote of 0 disables quoting;
+// a limit above 0 stops after that many records.
+func parseDelimited(text string, delimiter, quote rune, limit int) [][]string {
+	var records [][]string
+	var record []string
+	var field strings.Builder
+	quoted, atStart := false, true
+
+	endField := func() {
+		record = append(record, field.String())
+		field.Reset()
+		atStart = true
+	}
+	endRecord := func() {
+		endField()
+		if len(record) > 1 || record[0] != "" {
+			records = append(records, record)
+		}
+		record = nil
+	}
+
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+		switch {
+		case quoted:
+			if r != quote {
+				field.WriteRune(r)
+			} else if next, n := utf8.DecodeRuneInString(text[i:]); next == quote && n > 0 {
+				field.WriteRune(quote)
+				i += n
+			} else {
+				quoted = false
+			}
+		case quote != 0 && r == quote && atStart:
+			quoted, atStart = true, false
+		case r == delimiter:
+			endField()
+		case r == '\r' || r == '\n':
+			if r == '\r' && strings.HasPrefix(text[i:], "\n") {
+				i++
+			}
+			endRecord()
+			if limit > 0 && len(records) >= limit {
+				return records
+			}
+		default:
+			field.WriteRune(r)
+			atStart = false
+		}
+	}
+	if field.Len() > 0 || len(record) > 0 {
+		endRecord()
+	}
+	return records
+}
+
+// textNumber matches a number with optional thousands separators and exponent
+var textNumber = regexp.MustCompile(`^(\d{1,3}(,\d{3})+|\d+)?(\.\d+)?([eE][+-]?\d+)?$`)
+
+// textDateLayouts are the date and time layouts recognized in text, day-first
+// layouts after month-first ones as Excel does in the US locale
+var textDateLayouts = []string{
+	"2006-01-02", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02T15:04:05Z07:00",
+	"2006/1/2", "2006/1/2 15:04:05", "2006/1/2 15:04",
+	"1/2/2006", "1/2/2006 15:04:05", "1/2/2006 15:04",
+	"2/1/2006", "2/1/2006 15:04:05", "2/1/2006 15:04", "2.1.2006",
+	"2-Jan-2006", "2 Jan 2006", "Jan 2, 2006", "January 2, 2006",
+	"2006年1月2日", "15:04:05", "15:04",
+}
+
+// textCell types a field the way Excel does when it opens delimited text,
+// or returns nil for an empty field
+func textCell(value string) *Cell {
+	s := strings.TrimSpace(value)
+	if s == "" {
+		return nil
+	}
+	if strings.EqualFold(s, "TRUE") || strings.EqualFold(s, "FALSE") {
+		cell := &Cell{Type: CellBoolean, Text: strings.ToUpper(s)}
+		if cell.Text == "TRUE" {
+			cell.Number = 1
+		}
+		return cell
+	}
+	if n, format, ok := parseTextNumber(s); ok {
+		cell := &Cell{Number: n, Format: format}
+		cell.Type, cell.Text = numberCell(n, format, false)
+		return cell
+	}
+	for _, layout := range textDateLayouts {
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			continue
+		}
+		hasTime := strings.Contains(layout, "15:")
+		hasDate := !strings.HasPrefix(layout, "15:")
+		format := "yyyy-mm-dd"
+		switch {
+		case hasDate && hasTime:
+			format = "yyyy-mm-dd hh:mm:ss"
+		case hasTime:
+			format = "hh:mm:ss"
+			t = time.Date(1899, 12, 31, t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+		}
+		serial := timeToSerial(t, false)
+		if !hasDate {
+			serial -= float64(int(serial))
+		}
+		return &Cell{Type: CellDate, Text: formatDateTime(t, hasTime, hasDate), Number: serial, Format: format}
+	}
+	return &Cell{Type: CellString, Text: value}
+}
+
+// parseTextNumber reads numbers such as 1,234.5, -3e2, (42), 12.5% and
+// $1,234.50 with the format Excel would give them. Integers with leading
+// zeros stay text, since they are usually codes.
+func parseTextNumber(s string) (float64, string, bool) {
+	format := "General"
+	negative := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		negative, s = true, s[1:len(s)-1]
+	}
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		negative, s = negative != (s[0] == '-'), s[1:]
+	}
+	for _, symbol := range []string{"$", "€", "£", "¥", "￥"} {
+		if strings.HasPrefix(s, symbol) || strings.HasSuffix(s, symbol) {
+			s = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(s, symbol), symbol))
+			format = `"` + symbol + `"#,##0.00`
+			break
+		}
+	}
+	if strings.HasPrefix(s, "-") && format != "General" {
+		negative, s = !negative, s[1:]
+	}
+	if strings.HasSuffix(s, "%") && format == "General" {
+		s, format = strings.TrimSpace(s[:len(s)-1]), "0%"
+	}
+	if s == "" || s == "." || !textNumber.MatchString(s) {
+		return 0, "", false
+	}
+	if len(s) > 1 && s[0] == '0' && s[1] != '.' && !strings.ContainsAny(s, "eE") {
+		return 0, "", false
+	}
+	n, err := strconv.ParseFloat(strings.ReplaceAll(s, ",", ""), 64)
+	if err != nil {
+		return 0, "", false
+	}
+	if format == "0%" {
+		n /= 100
+	}
+	if negative {
+		n = -n
+	}
+	return n, format, true
+}
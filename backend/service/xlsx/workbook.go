@@ -0,0 +1,375 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"excel-automation-mcp/backend/service/mcp"
+	"excel-automation-mcp/backend/service/vbaproject"
+)
+
+// MaxCells caps the cells read from one workbook so a huge or hostile file
+// cannot exhaust memory. Cells beyond the limit are dropped with a warning.
+const MaxCells = 2000000
+
+// Workbook is the content of an .xlsx or .xlsm file
+type Workbook struct {
+	Sheets    []*Sheet
+	Date1904  bool // Dates count from 1904-01-01 instead of 1900-01-01
+	HasMacros bool // The package holds a VBA project (xl/vbaProject.bin)
+	Names     []DefinedName
+	Warnings  []string // Problems that did not stop the file from being read
+
+	files   map[string]*zip.File
+	vbaPart string
+	strings []string
+	styles  []style
+	cells   int
+}
+
+// Open reads a workbook from an .xlsx or .xlsm file
+func Open(filename string) (*Workbook, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	wb, err := Read(f, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return wb, nil
+}
+
+// Read reads a workbook from the bytes of an .xlsx or .xlsm package
+func Read(r io.ReaderAt, size int64) (*Workbook, error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		if errors.Is(err, zip.ErrFormat) {
+			return nil, errors.New("not an .xlsx or .xlsm file (legacy .xls workbooks must be saved as .xlsx first)")
+		}
+		return nil, err
+	}
+
+	wb := &Workbook{files: map[string]*zip.File{}}
+	for _, f := range z.File {
+		wb.files[strings.TrimPrefix(f.Name, "/")] = f
+	}
+
+	workbookPart := wb.officeDocument()
+	if workbookPart == "" {
+		return nil, errors.New("package has no workbook part")
+	}
+	rels, err := wb.relationships(workbookPart)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		Props struct {
+			Date1904 string `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+		Sheets []struct {
+			Name  string     `xml:"name,attr"`
+			State string     `xml:"state,attr"`
+			Attrs []xml.Attr `xml:",any,attr"`
+		} `xml:"sheets>sheet"`
+		Names []struct {
+			Name    string `xml:"name,attr"`
+			SheetID *int   `xml:"localSheetId,attr"`
+			Hidden  string `xml:"hidden,attr"`
+			Value   string `xml:",chardata"`
+		} `xml:"definedNames>definedName"`
+	}
+	if err := wb.decode(workbookPart, &doc); err != nil {
+		return nil, err
+	}
+	wb.Date1904 = parseBool(doc.Props.Date1904)
+
+	for _, rel := range rels {
+		switch {
+		case strings.HasSuffix(rel.Type, "/sharedStrings"):
+			if wb.strings, err = wb.readSharedStrings(rel.Target); err != nil {
+				return nil, err
+			}
+		case strings.HasSuffix(rel.Type, "/styles"):
+			if wb.styles, err = wb.readStyles(rel.Target); err != nil {
+				return nil, err
+			}
+		case strings.HasSuffix(rel.Type, "/vbaProject"):
+			wb.HasMacros = true
+			wb.vbaPart = rel.Target
+		}
+	}
+
+	for _, entry := range doc.Sheets {
+		id := ""
+		for _, attr := range entry.Attrs {
+			if attr.Name.Local == "id" && attr.Name.Space != "" {
+				id = attr.Value
+			}
+		}
+		rel, ok := rels[id]
+		if !ok || !strings.HasSuffix(rel.Type, "/worksheet") {
+			// Chart sheets and dialog sheets hold no cells
+			continue
+		}
+		sheet := &Sheet{Name: entry.Name, Hidden: entry.State == "hidden" || entry.State == "veryHidden", cells: map[cellKey]*Cell{}}
+		if err := wb.readSheet(sheet, rel.Target); err != nil {
+			return nil, fmt.Errorf("sheet %q: %w", entry.Name, err)
+		}
+		if err := wb.readTables(sheet, rel.Target); err != nil {
+			return nil, fmt.Errorf("sheet %q: %w", entry.Name, err)
+		}
+		wb.Sheets = append(wb.Sheets, sheet)
+	}
+	if len(wb.Sheets) == 0 {
+		return nil, errors.New("workbook has no worksheets")
+	}
+
+	// Sheet-level names hold the index of their sheet among all sheets
+	for _, entry := range doc.Names {
+		name := DefinedName{Name: entry.Name, RefersTo: strings.TrimPrefix(strings.TrimSpace(entry.Value), "="), Hidden: parseBool(entry.Hidden)}
+		if entry.SheetID != nil && *entry.SheetID >= 0 && *entry.SheetID < len(doc.Sheets) {
+			name.Sheet = doc.Sheets[*entry.SheetID].Name
+		}
+		wb.Names = append(wb.Names, name)
+	}
+	return wb, nil
+}
+
+// VBAProject reads the modules of the workbook's VBA project
+func (wb *Workbook) VBAProject() (*vbaproject.Project, error) {
+	if wb.vbaPart == "" {
+		return nil, errors.New("workbook has no VBA project (save it as .xlsm to keep macros)")
+	}
+	rc, err := wb.open(wb.vbaPart)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", wb.vbaPart, err)
+	}
+	project, err := vbaproject.Read(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", wb.vbaPart, err)
+	}
+	return project, nil
+}
+
+// ExistingModules turns the named modules of a VBA project, or all of them
+// when names is empty, into prompt context
+func ExistingModules(project *vbaproject.Project, names []string) []mcp.ExistingModule {
+	modules := []mcp.ExistingModule{}
+	if project == nil {
+		return modules
+	}
+	selected := func(name string) bool {
+		if len(names) == 0 {
+			return true
+		}
+		for _, n := range names {
+			if strings.EqualFold(n, name) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, module := range project.Modules {
+		if selected(module.Name) {
+			modules = append(modules, mcp.ExistingModule{Name: module.Name, Type: string(module.Type), Code: module.Code})
+		}
+	}
+	return modules
+}
+
+// Sheet returns the worksheet with the given name, ignoring case, or nil
+func (wb *Workbook) Sheet(name string) *Sheet {
+	for _, sheet := range wb.Sheets {
+		if strings.EqualFold(sheet.Name, name) {
+			return sheet
+		}
+	}
+	return nil
+}
+
+// relationship is one entry of a .rels part, with its target resolved to a package path
+type relationship struct {
+	Type   string
+	Target string
+}
+
+// officeDocument returns the path of the main workbook part
+func (wb *Workbook) officeDocument() string {
+	rels, err := wb.relationships("")
+	if err == nil {
+		for _, rel := range rels {
+			if strings.HasSuffix(rel.Type, "/officeDocument") {
+				return rel.Target
+			}
+		}
+	}
+	if _, ok := wb.files["xl/workbook.xml"]; ok {
+		return "xl/workbook.xml"
+	}
+	return ""
+}
+
+// relationships reads the relationships of a part, keyed by id. An empty
+// part reads the package relationships.
+func (wb *Workbook) relationships(part string) (map[string]relationship, error) {
+	dir, name := path.Split(part)
+	relsPath := path.Join(dir, "_rels", name+".rels")
+
+	var doc struct {
+		Items []struct {
+			ID         string `xml:"Id,attr"`
+			Type       string `xml:"Type,attr"`
+			Target     string `xml:"Target,attr"`
+			TargetMode string `xml:"TargetMode,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := wb.decode(relsPath, &doc); err != nil {
+		return nil, err
+	}
+
+	rels := map[string]relationship{}
+	for _, item := range doc.Items {
+		if item.TargetMode == "External" {
+			continue
+		}
+		target := item.Target
+		if strings.HasPrefix(target, "/") {
+			target = strings.TrimPrefix(target, "/")
+		} else {
+			target = path.Join(dir, target)
+		}
+		rels[item.ID] = relationship{Type: item.Type, Target: target}
+	}
+	return rels, nil
+}
+
+// open opens a part of the package
+func (wb *Workbook) open(part string) (io.ReadCloser, error) {
+	f, ok := wb.files[part]
+	if !ok {
+		return nil, fmt.Errorf("missing part %s: %w", part, os.ErrNotExist)
+	}
+	return f.Open()
+}
+
+// decode unmarshals a whole XML part
+func (wb *Workbook) decode(part string, v interface{}) error {
+	rc, err := wb.open(part)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(rc).Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", part, err)
+	}
+	return nil
+}
+
+// readSharedStrings reads the shared string table. Rich text runs are joined
+// and phonetic guides are left out, as Excel shows them.
+func (wb *Workbook) readSharedStrings(part string) ([]string, error) {
+	var doc struct {
+		Items []richText `xml:"si"`
+	}
+	if err := wb.decode(part, &doc); err != nil {
+		return nil, err
+	}
+	table := make([]string, len(doc.Items))
+	for i, item := range doc.Items {
+		table[i] = item.String()
+	}
+	return table, nil
+}
+
+// richText is a string item: plain text or formatted runs
+type richText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t richText) String() string {
+	if len(t.Runs) == 0 {
+		return t.Text
+	}
+	var b strings.Builder
+	b.WriteString(t.Text)
+	for _, run := range t.Runs {
+		b.WriteString(run.Text)
+	}
+	return b.String()
+}
+
+// style is the part of a cell format the analyzer needs
+type style struct {
+	Format string // Number format code
+	Bold   bool
+}
+
+// readStyles reads the cell formats (cellXfs) with their number formats and fonts
+func (wb *Workbook) readStyles(part string) ([]style, error) {
+	var doc struct {
+		NumFmts []struct {
+			ID   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		Fonts []struct {
+			Bold *struct {
+				Val string `xml:"val,attr"`
+			} `xml:"b"`
+		} `xml:"fonts>font"`
+		Xfs []struct {
+			NumFmtID int `xml:"numFmtId,attr"`
+			FontID   int `xml:"fontId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	if err := wb.decode(part, &doc); err != nil {
+		return nil, err
+	}
+
+	custom := map[int]string{}
+	for _, f := range doc.NumFmts {
+		custom[f.ID] = f.Code
+	}
+	styles := make([]style, len(doc.Xfs))
+	for i, xf := range doc.Xfs {
+		code, ok := custom[xf.NumFmtID]
+		if !ok {
+			code = builtinFormats[xf.NumFmtID]
+		}
+		styles[i].Format = code
+		if xf.FontID >= 0 && xf.FontID < len(doc.Fonts) {
+			if b := doc.Fonts[xf.FontID].Bold; b != nil {
+				styles[i].Bold = b.Val == "" || parseBool(b.Val)
+			}
+		}
+	}
+	return styles, nil
+}
+
+// parseBool reads an XML schema boolean
+func parseBool(s string) bool {
+	b, _ := strconv.ParseBool(s)
+	return b
+}